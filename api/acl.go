@@ -0,0 +1,368 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// routePermissions is the permission each route addressing a network, or
+// one of its allocations, needs on that network; keyed by method and path
+// template below the version prefix. Operations naming their networks in
+// the body check them in their handlers.
+var routePermissions = map[string]store.Permission{
+	"GET /networks/{id}":                   store.PermissionView,
+	"PATCH /networks/{id}":                 store.PermissionAdmin,
+	"DELETE /networks/{id}":                store.PermissionAdmin,
+	"GET /networks/{id}/stats":             store.PermissionView,
+	"GET /networks/{id}/allocations":       store.PermissionView,
+	"POST /networks/{id}/allocations":      store.PermissionAllocate,
+	"GET /networks/{id}/allocations/count": store.PermissionView,
+	"GET /networks/{id}/ptr-zone":          store.PermissionView,
+	"POST /networks/{id}/reconcile":        store.PermissionAdmin,
+	"POST /networks/{id}/subnets":          store.PermissionAllocate,
+	"POST /networks/{id}/freeze":           store.PermissionAdmin,
+	"DELETE /networks/{id}/freeze":         store.PermissionAdmin,
+	"GET /networks/{id}/acl":               store.PermissionView,
+	"PUT /networks/{id}/acl":               store.PermissionAdmin,
+	"POST /networks/{id}/hold":             store.PermissionAllocate,
+	"GET /networks/{id}/next-free":         store.PermissionView,
+	"POST /networks/{id}/next-free":        store.PermissionAllocate,
+	"GET /allocations/{id}":                store.PermissionView,
+	"PATCH /allocations/{id}":              store.PermissionAllocate,
+	"DELETE /allocations/{id}":             store.PermissionRelease,
+	"POST /allocations/{id}/release":       store.PermissionRelease,
+	"POST /allocations/{id}/transfer":      store.PermissionRelease,
+	"GET /allocations/{id}/cloud-init":     store.PermissionView,
+}
+
+// globalRoutePermissions is the permission each route exposing or changing
+// every network at once needs on all of them, so that a caller an ACL keeps
+// out of a network cannot reach it this way. Routes listing networks,
+// allocations, tags or audit entries instead narrow their output to what
+// the caller may view.
+var globalRoutePermissions = map[string]store.Permission{
+	"GET /snapshot":             store.PermissionView,
+	"GET /audit/export":         store.PermissionView,
+	"GET /audit-entries/export": store.PermissionView,
+	"POST /tags/rename":         store.PermissionAdmin,
+	"POST /tags/merge":          store.PermissionAdmin,
+}
+
+// enforceACL rejects requests to routes listed in routePermissions when the
+// ACL of the network they address does not grant the caller the route's
+// permission, and requests to routes listed in globalRoutePermissions when
+// the ACL of any network does not. An unknown network or allocation is left
+// to the handler to report.
+func (s *Server) enforceACL(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := mux.CurrentRoute(r)
+		if current == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tmpl, err := current.GetPathTemplate()
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tmpl = strings.TrimPrefix(strings.TrimPrefix(tmpl, "/api/v1"), "/api/v2")
+		if perm, ok := globalRoutePermissions[r.Method+" "+tmpl]; ok {
+			if err := s.checkEveryNetwork(r, perm); err != nil {
+				writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+				return
+			}
+		}
+		perm, ok := routePermissions[r.Method+" "+tmpl]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id := mux.Vars(r)["id"]
+		if strings.HasPrefix(tmpl, "/allocations/") {
+			alloc, err := s.store.GetAllocation(id)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			id = alloc.NetworkID
+		}
+		if s.rejectDenied(w, r, id, "", perm) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// caller identifies who made r for network ACLs: the principal its SPIFFE
+// ID maps to, e.g. "spiffe:lab", the fingerprint of its bearer token, e.g.
+// "token:9f86d081", or "anonymous" without auth tokens configured
+func (s *Server) caller(r *http.Request) string {
+	if principal, ok := s.svidPrincipal(r); ok {
+		return principal
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if len(s.tokens) == 0 || !ok || token == "" {
+		return "anonymous"
+	}
+	return "token:" + tokenFingerprint(token)
+}
+
+// rejectDenied writes a 403 and returns true when the ACL of the network
+// identified by networkID, or cidr when networkID is empty, does not grant
+// the caller of r perm. A network that cannot be found is not reported
+// here; the operation being guarded reports it.
+func (s *Server) rejectDenied(w http.ResponseWriter, r *http.Request, networkID, cidr string, perm store.Permission) bool {
+	var network *ipam.Network
+	var err error
+	if networkID != "" {
+		network, err = s.store.GetNetwork(networkID)
+	} else {
+		network, err = s.store.GetNetworkByCIDR(cidr)
+	}
+	if err != nil {
+		return false
+	}
+	if err := store.CheckPermission(network, perm, s.caller(r)); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return true
+	}
+	return false
+}
+
+// rejectDeniedAllocations is rejectDenied for the networks of allocations
+func (s *Server) rejectDeniedAllocations(w http.ResponseWriter, r *http.Request, allocations []*ipam.IPAllocation, perm store.Permission) bool {
+	checked := make(map[string]bool)
+	for _, alloc := range allocations {
+		if checked[alloc.NetworkID] {
+			continue
+		}
+		checked[alloc.NetworkID] = true
+		if s.rejectDenied(w, r, alloc.NetworkID, "", perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkEveryNetwork returns ErrPermissionDenied, naming the first such
+// network, when the ACL of any network does not grant the caller of r perm
+func (s *Server) checkEveryNetwork(r *http.Request, perm store.Permission) error {
+	networks, err := s.store.ListNetworks()
+	if err != nil {
+		return err
+	}
+	caller := s.caller(r)
+	for _, network := range networks {
+		if err := store.CheckPermission(network, perm, caller); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// visibleNetworks narrows networks to those the caller of r may view
+func (s *Server) visibleNetworks(r *http.Request, networks []*ipam.Network) []*ipam.Network {
+	caller := s.caller(r)
+	visible := networks[:0:0] // Keeps a nil list nil, which v1 encodes as null
+	for _, network := range networks {
+		if store.NetworkACL(network).Allows(store.PermissionView, caller) {
+			visible = append(visible, network)
+		}
+	}
+	return visible
+}
+
+// visibleAllocations narrows allocations to those in networks the caller
+// of r may view
+func (s *Server) visibleAllocations(r *http.Request, allocations []*ipam.IPAllocation) []*ipam.IPAllocation {
+	caller := s.caller(r)
+	allowed := make(map[string]bool)
+	visible := allocations[:0:0]
+	for _, alloc := range allocations {
+		ok, checked := allowed[alloc.NetworkID]
+		if !checked {
+			network, err := s.store.GetNetwork(alloc.NetworkID)
+			ok = err == nil && store.NetworkACL(network).Allows(store.PermissionView, caller)
+			allowed[alloc.NetworkID] = ok
+		}
+		if ok {
+			visible = append(visible, alloc)
+		}
+	}
+	return visible
+}
+
+// listVisibleAuditEntries returns up to limit of the latest audit entries
+// the caller of r may view, see visibleAuditEntries; all of them when limit
+// is not positive
+func (s *Server) listVisibleAuditEntries(r *http.Request, limit int) ([]*ipam.AuditEntry, error) {
+	err := s.checkEveryNetwork(r, store.PermissionView)
+	if err == nil {
+		return s.auditLog().ListAuditEntries(limit)
+	}
+	if !errors.Is(err, store.ErrPermissionDenied) {
+		return nil, err
+	}
+
+	entries, err := s.auditLog().ListAuditEntries(0)
+	if err != nil {
+		return nil, err
+	}
+	visible := s.visibleAuditEntries(r, entries)
+	if limit > 0 && len(visible) > limit {
+		visible = visible[:limit]
+	}
+	return visible, nil
+}
+
+// visibleAuditEntries narrows entries to those about networks, or
+// allocations in networks, the caller of r may view. Entries about anything
+// else, or about a network or allocation since deleted, are left to callers
+// that may view every network.
+func (s *Server) visibleAuditEntries(r *http.Request, entries []*ipam.AuditEntry) []*ipam.AuditEntry {
+	caller := s.caller(r)
+	allowed := make(map[string]bool)
+	visible := entries[:0:0]
+	for _, entry := range entries {
+		ok, checked := allowed[entry.Resource]
+		if !checked {
+			networkID := entry.Resource
+			if alloc, err := s.store.GetAllocation(entry.Resource); err == nil {
+				networkID = alloc.NetworkID
+			}
+			network, err := s.store.GetNetwork(networkID)
+			ok = err == nil && store.NetworkACL(network).Allows(store.PermissionView, caller)
+			allowed[entry.Resource] = ok
+		}
+		if ok {
+			visible = append(visible, entry)
+		}
+	}
+	return visible
+}
+
+// aclResponse is a network's ACL, every permission listed
+type aclResponse struct {
+	NetworkID string              `json:"network_id"`
+	ACL       map[string][]string `json:"acl"`
+}
+
+func newACLResponse(network *ipam.Network) *aclResponse {
+	acl := store.NetworkACL(network)
+	resp := &aclResponse{NetworkID: network.ID, ACL: make(map[string][]string)}
+	for _, p := range store.Permissions {
+		resp.ACL[string(p)] = append([]string{}, acl[p]...)
+	}
+	return resp
+}
+
+// getNetworkACL returns the ACL of a network
+func (s *Server) getNetworkACL(w http.ResponseWriter, r *http.Request) {
+	network, err := s.store.GetNetwork(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(newACLResponse(network))
+}
+
+// setNetworkACL replaces the ACL of a network. An empty ACL opens the
+// network to every caller; any other needs an admin, so that it can be
+// changed again.
+func (s *Server) setNetworkACL(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ACL map[string][]string `json:"acl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	var errs fieldErrors
+	acl := make(store.ACL)
+	for _, name := range slices.Sorted(maps.Keys(req.ACL)) {
+		if !store.ValidPermission(store.Permission(name)) {
+			errs.add("acl", "unknown permission %q: use %s", name, strings.Join(permissionNames(), ", "))
+		}
+	}
+	for _, p := range store.Permissions {
+		for i, principal := range req.ACL[string(p)] {
+			field := fmt.Sprintf("acl.%s[%d]", p, i)
+			switch {
+			case principal == "":
+				errs.add(field, "is required")
+			case !tagPattern.MatchString(store.ACLTagPrefix(p) + principal):
+				errs.add(field, "invalid principal %q: use a token fingerprint such as token:9f86d081, a SPIFFE principal such as spiffe:lab, anonymous or %s", principal, store.AnyPrincipal)
+			default:
+				acl[p] = append(acl[p], principal)
+			}
+		}
+	}
+	if !acl.Empty() && len(acl[store.PermissionAdmin]) == 0 {
+		errs.add("acl.admin", "is required, or the ACL could not be changed again")
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	network, err := s.store.GetNetwork(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	network.Tags = store.ACLTags(network.Tags, acl)
+	network.UpdatedAt = s.clock.Now()
+	if err := s.store.SaveNetwork(network); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.invalidateNetworks()
+	s.recordAudit(r, "network_acl_changed", network.ID, fmt.Sprintf("Set ACL of network %s: %s", network.CIDR, describeACL(acl)))
+
+	json.NewEncoder(w).Encode(newACLResponse(network))
+}
+
+// rejectACLTags adds an error for each ACL tag among tags, which only the
+// ACL endpoint may change
+func rejectACLTags(errs *fieldErrors, field string, tags []string) {
+	for i, tag := range tags {
+		if store.IsACLTag(tag) {
+			errs.add(fmt.Sprintf("%s[%d]", field, i), "network ACLs are changed through /networks/{id}/acl")
+		}
+	}
+}
+
+func permissionNames() []string {
+	names := make([]string, len(store.Permissions))
+	for i, p := range store.Permissions {
+		names[i] = string(p)
+	}
+	return names
+}
+
+// describeACL renders acl for the audit log
+func describeACL(acl store.ACL) string {
+	if acl.Empty() {
+		return "open"
+	}
+	var terms []string
+	for _, p := range store.Permissions {
+		if len(acl[p]) > 0 {
+			terms = append(terms, fmt.Sprintf("%s=%s", p, strings.Join(acl[p], ",")))
+		}
+	}
+	return strings.Join(terms, " ")
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/jeremyhahn/go-ipam/pkg/approval"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/notify"
+)
+
+// SetApprovalWebhooks configures the webhooks that networks tagged
+// approval-webhook=<name> must get allocations approved by
+func (s *Server) SetApprovalWebhooks(w *approval.Webhooks) {
+	s.approvals = w
+}
+
+// SetNotifier configures the channels networks tagged notify=<name> are
+// told about allocations waiting on approval through
+func (s *Server) SetNotifier(n *notify.Notifier) {
+	s.notifier = n
+}
+
+// rejectUnapproved writes an error and returns true when the approval
+// webhook of req's network rejects it or cannot be reached. A missing
+// network is left for the allocator to report.
+func (s *Server) rejectUnapproved(w http.ResponseWriter, r *http.Request, req *ipam.AllocationRequest) bool {
+	var network *ipam.Network
+	var err error
+	if req.NetworkID != "" {
+		network, err = s.store.GetNetwork(req.NetworkID)
+	} else {
+		network, err = s.store.GetNetworkByCIDR(req.CIDR)
+	}
+	if err != nil {
+		return false
+	}
+
+	if approval.Webhook(network) != "" {
+		s.notifier.Go(network, notify.ApprovalPending(network, req, r.RemoteAddr, s.clock.Now()))
+	}
+	if err := s.approvals.Check(r.Context(), network, approval.NewRequest(network, req, "", r.RemoteAddr)); err != nil {
+		writeError(w, errorStatus(err, http.StatusBadGateway), err)
+		return true
+	}
+	return false
+}
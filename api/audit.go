@@ -0,0 +1,154 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jeremyhahn/go-ipam/pkg/auditlog"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// maxAuditedErrorBody bounds how much of a failed response is kept to
+// extract its error code and message
+const maxAuditedErrorBody = 4096
+
+// failureRecorder remembers the status and error body of a response
+type failureRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *failureRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *failureRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if r.status >= 400 && r.body.Len() < maxAuditedErrorBody {
+		r.body.Write(b)
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// auditFailures records rejected and failed write requests in the audit log
+// with outcome=failure, so attempted changes are visible alongside the
+// successful ones
+func (s *Server) auditFailures(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if readsOnly(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &failureRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status < 400 {
+			return
+		}
+
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if tmpl, err := current.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+		resource := mux.Vars(r)["id"]
+		if resource == "" {
+			resource = route
+		}
+
+		var resp ErrorResponse
+		json.Unmarshal(rec.body.Bytes(), &resp)
+		if resp.Code == "" {
+			resp.Code = errorCode(nil, rec.status)
+		}
+
+		s.recordAudit(r, "request_failed", resource, fmt.Sprintf("outcome=failure status=%d code=%s %s %s: %s",
+			rec.status, resp.Code, r.Method, route, resp.Message))
+	})
+}
+
+// SetAuditWriter saves the audit entries of requests through w, in the
+// background, instead of writing each before responding. Reading the audit
+// log flushes w first.
+func (s *Server) SetAuditWriter(w *auditlog.Writer) {
+	s.auditWriter = w
+}
+
+// auditLog returns the store audit entries are saved to and read from
+func (s *Server) auditLog() ipam.Store {
+	if s.auditWriter != nil {
+		return s.auditWriter
+	}
+	return s.store
+}
+
+// auditLeaderChanges records each election this node wins until changes is
+// closed. Every node learns of every election, so leaving the others to
+// their winners records each one once.
+func (s *Server) auditLeaderChanges(nodeID uint64, changes <-chan store.LeaderChange) {
+	for change := range changes {
+		if change.LeaderID != nodeID {
+			continue
+		}
+		details := fmt.Sprintf("Node %d became leader in term %d", change.LeaderID, change.Term)
+		if change.Previous != 0 {
+			details += fmt.Sprintf(", replacing node %d", change.Previous)
+		}
+		s.recordAuditAs(nil, "raft", "leader-change", "cluster", details)
+	}
+}
+
+// auditExportTypes are the content types of the audit export formats
+var auditExportTypes = map[string]string{
+	auditlog.FormatJSONL: "application/x-ndjson",
+	auditlog.FormatCSV:   "text/csv; charset=utf-8",
+}
+
+// exportAuditEntries streams the audit log, oldest first, as JSON lines or
+// CSV for ingestion into other systems. since, an RFC 3339 time, skips older
+// entries. Unlike listing, the log is written as it is read; a failure
+// midway can only cut the stream short.
+func (s *Server) exportAuditEntries(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	var errs fieldErrors
+	format := q.Get("format")
+	if format == "" {
+		format = auditlog.FormatJSONL
+	}
+	contentType, ok := auditExportTypes[format]
+	if !ok {
+		errs.add("format", "must be %s or %s", auditlog.FormatJSONL, auditlog.FormatCSV)
+	}
+	var since time.Time
+	if v := q.Get("since"); v != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, v); err != nil {
+			errs.add("since", "must be an RFC 3339 time")
+		}
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=audit.%s", format))
+	if s.auditWriter != nil {
+		// Export from the store itself, which can stream the log
+		s.auditWriter.Flush()
+	}
+	auditlog.Export(s.store, w, format, since)
+}
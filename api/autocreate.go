@@ -0,0 +1,45 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// SetAutoCreateNetworks makes allocations by cidr create the network when
+// it is not registered yet, instead of failing. Meant for labs, where
+// registering every network first is friction.
+func (s *Server) SetAutoCreateNetworks(enabled bool) {
+	s.autoCreate = enabled
+}
+
+// autoCreateNetwork registers the network req names by cidr when automatic
+// creation is enabled and it does not exist. The CIDR is made canonical so
+// that later allocations by the same CIDR find the network.
+func (s *Server) autoCreateNetwork(r *http.Request, req *ipam.AllocationRequest) error {
+	if !s.autoCreate || req.NetworkID != "" || req.CIDR == "" {
+		return nil
+	}
+	prefix, err := netip.ParsePrefix(req.CIDR)
+	if err != nil {
+		return nil // Left for the allocator to report
+	}
+	req.CIDR = prefix.Masked().String()
+
+	s.autoCreateMu.Lock()
+	defer s.autoCreateMu.Unlock()
+	if _, err := s.store.GetNetworkByCIDR(req.CIDR); !errors.Is(err, ipam.ErrNetworkNotFound) {
+		return nil
+	}
+	network, err := s.ipam.AddNetwork(req.CIDR, "Created on demand by an allocation", []string{store.AutoCreatedTag})
+	if err != nil {
+		return err
+	}
+	s.invalidateNetworks()
+	s.recordAudit(r, "network_auto_created", network.ID, fmt.Sprintf("Created network %s for an allocation", network.CIDR))
+	return nil
+}
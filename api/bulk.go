@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// bulkUpdateRequest selects allocations and the change to make to each.
+// The selector needs at least one of network_id, cidr, tags or hostname,
+// so that a forgotten field does not update every allocation.
+type bulkUpdateRequest struct {
+	Selector struct {
+		NetworkID string   `json:"network_id"`
+		CIDR      string   `json:"cidr"`
+		Tags      []string `json:"tags"`
+		Hostname  string   `json:"hostname"`
+		Status    string   `json:"status"`
+	} `json:"selector"`
+	Patch struct {
+		AddTags    []string `json:"add_tags"`
+		RemoveTags []string `json:"remove_tags"`
+		Owner      *string  `json:"owner"`
+	} `json:"patch"`
+}
+
+// bulkUpdateResponse counts the selected allocations and those changed
+type bulkUpdateResponse struct {
+	Matched int `json:"matched"`
+	Updated int `json:"updated"`
+}
+
+// bulkUpdateAllocations changes the tags and owner of every allocation a
+// selector matches, in one atomic write
+func (s *Server) bulkUpdateAllocations(w http.ResponseWriter, r *http.Request) {
+	var req bulkUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	sel := &store.BulkSelector{
+		NetworkID:        req.Selector.NetworkID,
+		CIDR:             req.Selector.CIDR,
+		Hostname:         req.Selector.Hostname,
+		AllocationFilter: store.AllocationFilter{Status: req.Selector.Status, Tags: req.Selector.Tags},
+	}
+	patch := &store.AllocationPatch{
+		AddTags:    req.Patch.AddTags,
+		RemoveTags: req.Patch.RemoveTags,
+		Owner:      req.Patch.Owner,
+	}
+	if errs := s.validateBulkUpdate(sel, patch); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	applier, ok := s.store.(batchApplier)
+	if !ok {
+		writeErrorCode(w, http.StatusNotImplemented, CodeInternal, "store does not support bulk updates", nil)
+		return
+	}
+
+	var update *store.BulkUpdate
+	var err error
+	for attempt := 0; attempt < transactionAttempts; attempt++ {
+		if update, err = store.PrepareBulkUpdate(s.store, sel, patch, s.clock.Now()); err != nil || update.Updated == 0 {
+			break
+		}
+		if s.rejectDeniedAllocations(w, r, update.Batch.Allocations, store.PermissionAllocate) {
+			return
+		}
+		if err = applier.ApplyBatch(update.Batch); !errors.Is(err, store.ErrBatchConflict) {
+			break
+		}
+	}
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+
+	s.recordAudit(r, "allocations_bulk_updated", "allocations",
+		fmt.Sprintf("Updated %d of %d allocations matching %s", update.Updated, update.Matched, describeBulkSelector(sel)))
+
+	json.NewEncoder(w).Encode(&bulkUpdateResponse{Matched: update.Matched, Updated: update.Updated})
+}
+
+// validateBulkUpdate checks the selector and patch of a bulk update
+func (s *Server) validateBulkUpdate(sel *store.BulkSelector, patch *store.AllocationPatch) fieldErrors {
+	var errs fieldErrors
+
+	if sel.NetworkID == "" && sel.CIDR == "" && len(sel.Tags) == 0 && sel.Hostname == "" {
+		errs.add("selector", "network_id, cidr, tags or hostname is required")
+	}
+	if sel.CIDR != "" {
+		if _, _, err := net.ParseCIDR(sel.CIDR); err != nil {
+			errs.add("selector.cidr", "invalid CIDR %q", sel.CIDR)
+		}
+	}
+	if _, err := path.Match(sel.Hostname, ""); err != nil {
+		errs.add("selector.hostname", "invalid pattern %q", sel.Hostname)
+	}
+	if err := sel.AllocationFilter.Validate(); err != nil {
+		errs.add("selector.status", "%v", err)
+	}
+
+	if len(patch.AddTags) == 0 && len(patch.RemoveTags) == 0 && patch.Owner == nil {
+		errs.add("patch", "add_tags, remove_tags or owner is required")
+	}
+	for _, field := range []struct {
+		name string
+		tags []string
+	}{{"add_tags", patch.AddTags}, {"remove_tags", patch.RemoveTags}} {
+		var tagErrs fieldErrors
+		validateTags(&tagErrs, field.tags)
+		if field.name == "add_tags" {
+			s.checkTags(&tagErrs, field.tags)
+		}
+		for _, e := range tagErrs {
+			errs.add("patch."+strings.Replace(e.Field, "tags", field.name, 1), "%s", e.Message)
+		}
+	}
+	if patch.Owner != nil && *patch.Owner != "" {
+		owner := store.OwnerTagPrefix + *patch.Owner
+		if !tagPattern.MatchString(owner) || strings.Contains(*patch.Owner, "=") {
+			errs.add("patch.owner", "invalid owner %q: use letters, digits and . _ : / - (max 57 characters)", *patch.Owner)
+		} else if err := s.tagRegistry.Check(owner); err != nil {
+			errs.add("patch.owner", "%v", err)
+		}
+	}
+	return errs
+}
+
+// describeBulkSelector renders a selector for the audit log
+func describeBulkSelector(sel *store.BulkSelector) string {
+	var terms []string
+	for _, term := range []struct{ key, value string }{
+		{"network_id", sel.NetworkID},
+		{"cidr", sel.CIDR},
+		{"hostname", sel.Hostname},
+		{"status", sel.Status},
+	} {
+		if term.value != "" {
+			terms = append(terms, term.key+"="+term.value)
+		}
+	}
+	for _, tag := range sel.Tags {
+		terms = append(terms, "tag="+tag)
+	}
+	return strings.Join(terms, ",")
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// checkRequest lists the addresses to check
+type checkRequest struct {
+	IPs []string `json:"ips"`
+}
+
+// checkResult tells whether an address is managed and, when it is, what it
+// belongs to
+type checkResult struct {
+	IP string `json:"ip"`
+
+	// Managed is whether a network the caller may view contains the address
+	Managed bool `json:"managed"`
+
+	NetworkID string `json:"network_id,omitempty"`
+	CIDR      string `json:"cidr,omitempty"`
+
+	// Tenant is the tenant label of the most specific network carrying one
+	Tenant string   `json:"tenant,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+
+	AllocationID string `json:"allocation_id,omitempty"`
+	Hostname     string `json:"hostname,omitempty"`
+
+	// Status is "active", "expired" or "available" for managed addresses
+	Status string `json:"status,omitempty"`
+}
+
+// checkResponse holds a result per requested address, in request order
+type checkResponse struct {
+	Results []checkResult `json:"results"`
+}
+
+// checkIPs reports, for each address in the body, whether it is managed,
+// the network, tenant and tags it belongs to, and the allocation covering
+// it, so that firewall pipelines can validate rule sources in one request.
+// Networks the caller may not view are treated as unmanaged.
+func (s *Server) checkIPs(w http.ResponseWriter, r *http.Request) {
+	var req checkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	var errs fieldErrors
+	switch {
+	case len(req.IPs) == 0:
+		errs.add("ips", "at least one IP address is required")
+	case len(req.IPs) > maxPageSize:
+		errs.add("ips", "at most %d IP addresses may be checked at once", maxPageSize)
+	}
+	for i, ip := range req.IPs {
+		if net.ParseIP(ip) == nil {
+			errs.add(fmt.Sprintf("ips[%d]", i), "invalid IP address %q", ip)
+		}
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	now := s.clock.Now()
+	resp := checkResponse{Results: make([]checkResult, 0, len(req.IPs))}
+	for _, ip := range req.IPs {
+		result, err := s.checkIP(r, ip, now)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// checkIP checks one address against the networks the caller of r may view
+func (s *Server) checkIP(r *http.Request, ip string, now time.Time) (checkResult, error) {
+	loc, err := store.Locate(s.store, ip, now)
+	if errors.Is(err, ipam.ErrNetworkNotFound) {
+		return checkResult{IP: ip}, nil
+	}
+	if err != nil {
+		return checkResult{}, err
+	}
+
+	result := checkResult{IP: loc.IP}
+	networks := s.visibleNetworks(r, loc.Networks)
+	if len(networks) == 0 {
+		return result, nil
+	}
+	network := networks[0]
+	result.Managed = true
+	result.NetworkID = network.ID
+	result.CIDR = network.CIDR
+	result.Tags = network.Tags
+	result.Status = "available"
+	for _, n := range networks {
+		if tenant, ok := store.LabelsFromTags(n.Tags)["tenant"]; ok {
+			result.Tenant = tenant
+			break
+		}
+	}
+
+	if alloc := loc.Allocation; alloc != nil {
+		for _, n := range networks {
+			if n.ID == alloc.NetworkID {
+				result.AllocationID = alloc.ID
+				result.Hostname = alloc.Hostname
+				result.Status = loc.Status
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// readsOnly reports whether r only reads: a GET, HEAD or OPTIONS request,
+// or a POST to a route taking its query in the body
+func readsOnly(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	case http.MethodPost:
+		return r.URL.Path == "/api/v1/check" || r.URL.Path == "/api/v2/check"
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"regexp"
+
+	"github.com/gorilla/mux"
+	"github.com/jeremyhahn/go-ipam/pkg/cloudinit"
+)
+
+// interfacePattern matches Linux interface names
+var interfacePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]{0,14}$`)
+
+// getCloudInit renders the network configuration of an allocation as a
+// network-config version 2 document, or its hostname as #cloud-config
+func (s *Server) getCloudInit(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	q := r.URL.Query()
+
+	format := q.Get("format")
+	iface := q.Get("interface")
+	var errs fieldErrors
+	switch format {
+	case "", cloudinit.FormatNetworkConfig, cloudinit.FormatNetplan, cloudinit.FormatCloudConfig:
+	default:
+		errs.add("format", "must be %s, %s or %s", cloudinit.FormatNetworkConfig, cloudinit.FormatNetplan, cloudinit.FormatCloudConfig)
+	}
+	if iface != "" && !interfacePattern.MatchString(iface) {
+		errs.add("interface", "must be an interface name of up to 15 letters, digits and . _ : -")
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	alloc, err := s.store.GetAllocation(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	network, err := s.store.GetNetwork(alloc.NetworkID)
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	allocations, err := s.store.ListAllocations(network.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	config, err := cloudinit.New(network, allocations, alloc, iface, s.clock.Now())
+	if errors.Is(err, cloudinit.ErrRange) || errors.Is(err, cloudinit.ErrInactive) {
+		writeErrorCode(w, http.StatusConflict, CodeConflict, err.Error(), nil)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := config.Render(&buf, format); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if format == cloudinit.FormatCloudConfig {
+		w.Header().Set("Content-Type", "text/cloud-config; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/yaml; charset=utf-8")
+	}
+	w.Write(buf.Bytes())
+}
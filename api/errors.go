@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/jeremyhahn/go-ipam/pkg/approval"
+	"github.com/jeremyhahn/go-ipam/pkg/hooks"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// Stable, machine-readable error codes returned in ErrorResponse.Code
+const (
+	CodeBadRequest          = "bad_request"
+	CodeInvalidJSON         = "invalid_json"
+	CodeNotFound            = "not_found"
+	CodeConflict            = "conflict"
+	CodeInternal            = "internal_error"
+	CodeNetworkNotFound     = "network_not_found"
+	CodeNetworkFull         = "network_full"
+	CodeNetworkInUse        = "network_in_use"
+	CodeAllocationNotFound  = "allocation_not_found"
+	CodeIPNotAvailable      = "ip_not_available"
+	CodeClusterModeRequired = "cluster_mode_required"
+	CodeNetworkFrozen       = "network_frozen"
+	CodeAlreadyReleased     = "already_released"
+	CodeAllocationRejected  = "allocation_rejected"
+	CodeApprovalUnavailable = "approval_unavailable"
+	CodeOperationVetoed     = "operation_vetoed"
+	CodeUnauthorized        = "unauthorized"
+	CodeRequestTooLarge     = "request_too_large"
+	CodeNotReady            = "not_ready"
+	CodeStandby             = "standby"
+	CodeGossipRequired      = "gossip_required"
+	CodeHoldNotFound        = "hold_not_found"
+	CodeForbidden           = "forbidden"
+	CodePoolNotFound        = "pool_not_found"
+)
+
+// ErrorResponse is the JSON envelope returned by every endpoint on failure
+type ErrorResponse struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// errorCodes maps engine and store sentinel errors to their API codes and
+// HTTP statuses
+var errorCodes = []struct {
+	err    error
+	code   string
+	status int
+}{
+	{ipam.ErrNetworkNotFound, CodeNetworkNotFound, http.StatusNotFound},
+	{ipam.ErrNetworkFull, CodeNetworkFull, http.StatusConflict},
+	{ipam.ErrIPNotAvailable, CodeIPNotAvailable, http.StatusConflict},
+	{ipam.ErrIPNotAllocated, CodeAllocationNotFound, http.StatusNotFound},
+	{store.ErrAlreadyReleased, CodeAlreadyReleased, http.StatusConflict},
+	{store.ErrTransferConflict, CodeIPNotAvailable, http.StatusConflict},
+	{store.ErrNoFreeSubnet, CodeNetworkFull, http.StatusConflict},
+	{store.ErrNetworkFrozen, CodeNetworkFrozen, http.StatusConflict},
+	{store.ErrBatchConflict, CodeConflict, http.StatusConflict},
+	{store.ErrHoldNotFound, CodeHoldNotFound, http.StatusConflict},
+	{store.ErrPermissionDenied, CodeForbidden, http.StatusForbidden},
+	{store.ErrPoolNotFound, CodePoolNotFound, http.StatusNotFound},
+	{approval.ErrRejected, CodeAllocationRejected, http.StatusForbidden},
+	{approval.ErrUnavailable, CodeApprovalUnavailable, http.StatusBadGateway},
+	{hooks.ErrVetoed, CodeOperationVetoed, http.StatusForbidden},
+}
+
+// errorCode returns the API code for err, falling back to a generic code
+// derived from the HTTP status
+func errorCode(err error, status int) string {
+	for _, m := range errorCodes {
+		if errors.Is(err, m.err) {
+			return m.code
+		}
+	}
+
+	switch status {
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusInternalServerError:
+		return CodeInternal
+	default:
+		return CodeBadRequest
+	}
+}
+
+// errorStatus returns the HTTP status for a sentinel error anywhere in err's
+// chain, or fallback for errors without one
+func errorStatus(err error, fallback int) int {
+	for _, m := range errorCodes {
+		if errors.Is(err, m.err) {
+			return m.status
+		}
+	}
+	return fallback
+}
+
+// writeError writes err as an ErrorResponse with the given status
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeErrorCode(w, status, errorCode(err, status), err.Error(), nil)
+}
+
+// writeErrorCode writes an ErrorResponse with an explicit code and details
+func writeErrorCode(w http.ResponseWriter, status int, code, message string, details interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&ErrorResponse{
+		Code:    code,
+		Message: message,
+		Details: details,
+	})
+}
+
+// writeDecodeError writes a request body that could not be read or decoded:
+// 413 when it exceeded the size limit, otherwise 400 invalid_json
+func writeDecodeError(w http.ResponseWriter, err error) {
+	if tooLarge(err) {
+		writeErrorCode(w, http.StatusRequestEntityTooLarge, CodeRequestTooLarge, err.Error(), nil)
+		return
+	}
+	writeErrorCode(w, http.StatusBadRequest, CodeInvalidJSON, err.Error(), nil)
+}
+
+// tooLarge reports whether err is from reading past a body size limit
+func tooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// eventLister is implemented by stores that number their audit entries
+type eventLister interface {
+	ListEvents(since uint64, limit int) ([]*store.Event, error)
+}
+
+// eventsResponse is a page of the event stream. LastSeq is the since_seq
+// of the next page: the sequence number of the last event listed, or the
+// requested since_seq when there were none.
+type eventsResponse struct {
+	Events  []*store.Event `json:"events"`
+	LastSeq uint64         `json:"last_seq"`
+	HasMore bool           `json:"has_more"`
+}
+
+// listEvents returns the events numbered after since_seq, oldest first, so
+// that a consumer that was offline can catch up on every change without
+// gaps or repeats. Events the caller may not view, see visibleAuditEntries,
+// are left out but still advance last_seq, so a page can come back empty
+// with has_more set.
+func (s *Server) listEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	var errs fieldErrors
+	var since uint64
+	if v := q.Get("since_seq"); v != "" {
+		var err error
+		if since, err = strconv.ParseUint(v, 10, 64); err != nil {
+			errs.add("since_seq", "must be a sequence number")
+		}
+	}
+	limit := defaultPageSize
+	if v := q.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxPageSize {
+			errs.add("limit", "must be between 1 and %d", maxPageSize)
+		}
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	lister, ok := s.store.(eventLister)
+	if !ok {
+		writeErrorCode(w, http.StatusNotImplemented, CodeInternal, "store does not support the event stream", nil)
+		return
+	}
+	if s.auditWriter != nil {
+		// Entries still queued have no sequence number yet
+		s.auditWriter.Flush()
+	}
+
+	events, err := lister.ListEvents(since, limit+1)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resp := &eventsResponse{Events: events, LastSeq: since}
+	if len(events) > limit {
+		resp.Events, resp.HasMore = events[:limit], true
+	}
+	if n := len(resp.Events); n > 0 {
+		resp.LastSeq = resp.Events[n-1].Seq
+	}
+	if resp.Events, err = s.visibleEvents(r, resp.Events); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if len(resp.Events) == 0 {
+		resp.Events = []*store.Event{}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// visibleEvents narrows events to those whose audit entries the caller of r
+// may view
+func (s *Server) visibleEvents(r *http.Request, events []*store.Event) ([]*store.Event, error) {
+	err := s.checkEveryNetwork(r, store.PermissionView)
+	if err == nil {
+		return events, nil
+	}
+	if !errors.Is(err, store.ErrPermissionDenied) {
+		return nil, err
+	}
+
+	entries := make([]*ipam.AuditEntry, len(events))
+	for i, event := range events {
+		entries[i] = event.AuditEntry
+	}
+	visible := make(map[*ipam.AuditEntry]bool)
+	for _, entry := range s.visibleAuditEntries(r, entries) {
+		visible[entry] = true
+	}
+	kept := events[:0:0]
+	for _, event := range events {
+		if visible[event.AuditEntry] {
+			kept = append(kept, event)
+		}
+	}
+	return kept, nil
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/gorilla/mux"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// maxGroupMembers bounds the allocations created by one group request
+const maxGroupMembers = 256
+
+// groupIDPattern keeps group IDs valid as the value of a group= tag and as
+// a single URL path segment
+var groupIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]{0,56}$`)
+
+// Group is a named set of allocations created and released together
+type Group struct {
+	ID      string               `json:"id"`
+	Members []*ipam.IPAllocation `json:"members"`
+}
+
+// createGroup allocates every member in one request, tagging each with the
+// group ID. Either all members are allocated or none are.
+func (s *Server) createGroup(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID      string                   `json:"id"`
+		Members []ipam.AllocationRequest `json:"members"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	var errs fieldErrors
+	if !groupIDPattern.MatchString(req.ID) {
+		errs.add("id", "must be 1-57 letters, digits or . _ : - starting with a letter or digit")
+	}
+	if len(req.Members) == 0 || len(req.Members) > maxGroupMembers {
+		errs.add("members", "must list between 1 and %d allocations", maxGroupMembers)
+	}
+	for i := range req.Members {
+		memberErrs := validateAllocationRequest(&req.Members[i])
+		s.checkTags(&memberErrs, req.Members[i].Tags)
+		for _, e := range memberErrs {
+			errs.add(fmt.Sprintf("members[%d].%s", i, e.Field), "%s", e.Message)
+		}
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	now := s.clock.Now()
+	if _, err := store.GroupMembers(s.store, req.ID, now); err == nil {
+		writeErrorCode(w, http.StatusConflict, CodeConflict, fmt.Sprintf("group %s already exists", req.ID), nil)
+		return
+	} else if !errors.Is(err, store.ErrGroupNotFound) {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	for i := range req.Members {
+		if s.rejectDenied(w, r, req.Members[i].NetworkID, req.Members[i].CIDR, store.PermissionAllocate) ||
+			s.rejectFrozen(w, req.Members[i].NetworkID, req.Members[i].CIDR) {
+			return
+		}
+		if s.rejectUnapproved(w, r, &req.Members[i]) {
+			return
+		}
+	}
+
+	group := &Group{ID: req.ID}
+	for i := range req.Members {
+		member := req.Members[i]
+		member.Tags = append(append([]string(nil), member.Tags...), store.GroupTag(req.ID))
+
+		allocation, err := s.hooks.Allocate(r.Context(), s.store, &member, s.ipam.AllocateIP)
+		if err != nil {
+			for _, done := range group.Members {
+				s.releaseWithHooks(r.Context(), done)
+			}
+			writeError(w, errorStatus(err, http.StatusBadRequest), fmt.Errorf("members[%d]: %w", i, err))
+			return
+		}
+		group.Members = append(group.Members, allocation)
+	}
+
+	s.recordAudit(r, "group_created", group.ID, fmt.Sprintf("Created group %s with %d allocations", group.ID, len(group.Members)))
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(group)
+}
+
+// getGroup returns the active members of a group
+func (s *Server) getGroup(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	members, err := store.GroupMembers(s.store, id, s.clock.Now())
+	if err != nil {
+		if errors.Is(err, store.ErrGroupNotFound) {
+			writeError(w, http.StatusNotFound, err)
+		} else {
+			writeError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	if s.rejectDeniedAllocations(w, r, members, store.PermissionView) {
+		return
+	}
+
+	writeJSONWithETag(w, r, &Group{ID: id, Members: members})
+}
+
+// releaseGroup releases every active member of a group
+func (s *Server) releaseGroup(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	members, err := store.GroupMembers(s.store, id, s.clock.Now())
+	if err != nil {
+		if errors.Is(err, store.ErrGroupNotFound) {
+			writeError(w, http.StatusNotFound, err)
+		} else {
+			writeError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	if s.rejectDeniedAllocations(w, r, members, store.PermissionRelease) || s.rejectFrozenAllocations(w, members) {
+		return
+	}
+
+	for _, member := range members {
+		if err := s.releaseQuarantined(r, member); err != nil {
+			writeError(w, errorStatus(err, http.StatusInternalServerError), fmt.Errorf("release %s: %w", member.IP, err))
+			return
+		}
+	}
+
+	s.recordAudit(r, "group_released", id, fmt.Sprintf("Released group %s (%d allocations)", id, len(members)))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// releaseWithHooks releases alloc, running the lifecycle hooks around it
+func (s *Server) releaseWithHooks(ctx context.Context, alloc *ipam.IPAllocation) error {
+	return s.hooks.Release(ctx, s.store, alloc, func() error {
+		return s.ipam.ReleaseIP(alloc.NetworkID, alloc.IP)
+	})
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// Hold durations in seconds
+const (
+	defaultHoldSeconds = 300
+	maxHoldSeconds     = 24 * 60 * 60
+)
+
+// holdResponse is a hold and the token that takes it over
+type holdResponse struct {
+	Token        string     `json:"token"`
+	NetworkID    string     `json:"network_id"`
+	IP           string     `json:"ip"`
+	AllocationID string     `json:"allocation_id"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+}
+
+// createHold sets the next free address of a network aside for ttl seconds.
+// An allocation request carrying the returned token as hold_token gets that
+// address; otherwise the hold expires like any allocation with a TTL.
+func (s *Server) createHold(w http.ResponseWriter, r *http.Request) {
+	networkID := mux.Vars(r)["id"]
+
+	var req struct {
+		TTL         int    `json:"ttl"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	var errs fieldErrors
+	if req.TTL == 0 {
+		req.TTL = defaultHoldSeconds
+	}
+	if req.TTL < 1 || req.TTL > maxHoldSeconds {
+		errs.add("ttl", "must be between 1 and %d seconds", maxHoldSeconds)
+	}
+	validateDescription(&errs, req.Description)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	if s.rejectFrozen(w, networkID, "") {
+		return
+	}
+
+	token, err := newHoldToken()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	hold, err := s.ipam.AllocateIP(&ipam.AllocationRequest{
+		NetworkID:   networkID,
+		Description: req.Description,
+		Tags:        []string{store.HoldTag(token)},
+		TTL:         req.TTL,
+	})
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusBadRequest), err)
+		return
+	}
+	s.recordAudit(r, "ip_held", hold.ID, fmt.Sprintf("Held %s for %ds", hold.IP, req.TTL))
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&holdResponse{
+		Token:        token,
+		NetworkID:    hold.NetworkID,
+		IP:           hold.IP,
+		AllocationID: hold.ID,
+		ExpiresAt:    hold.ExpiresAt,
+	})
+}
+
+// allocator returns the function allocating req, made by r: the engine,
+// taking over the hold req names or allocating from the pool it names
+func (s *Server) allocator(r *http.Request, req *allocationRequest) func(*ipam.AllocationRequest) (*ipam.IPAllocation, error) {
+	switch {
+	case req.HoldToken != "":
+		return func(engineReq *ipam.AllocationRequest) (*ipam.IPAllocation, error) {
+			return s.convertHold(r, req.HoldToken, engineReq)
+		}
+	case req.Pool != "":
+		return func(engineReq *ipam.AllocationRequest) (*ipam.IPAllocation, error) {
+			return s.allocateInPool(r, req.Pool, engineReq)
+		}
+	}
+	return s.ipam.AllocateIP
+}
+
+// convertHold turns the active hold with token into the allocation req
+// asks for
+func (s *Server) convertHold(r *http.Request, token string, req *ipam.AllocationRequest) (*ipam.IPAllocation, error) {
+	s.holdMu.Lock()
+	defer s.holdMu.Unlock()
+
+	var network *ipam.Network
+	var err error
+	if req.NetworkID != "" {
+		network, err = s.store.GetNetwork(req.NetworkID)
+	} else {
+		network, err = s.store.GetNetworkByCIDR(req.CIDR)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.clock.Now()
+	hold, err := store.FindHold(s.store, network.ID, token, now)
+	if err != nil {
+		return nil, err
+	}
+	alloc := store.ConvertHold(hold, req, now)
+	if err := s.store.SaveAllocation(alloc); err != nil {
+		return nil, err
+	}
+	s.recordAudit(r, "hold_converted", alloc.ID, fmt.Sprintf("Allocated held IP %s", alloc.IP))
+	return alloc, nil
+}
+
+// validateHoldToken checks that a request taking over a hold allocates a
+// single address
+func validateHoldToken(errs *fieldErrors, req *allocationRequest) {
+	if req.HoldToken != "" && req.Count > 1 {
+		errs.add("count", "must be 1 when taking over a hold")
+	}
+}
+
+// keepHoldTags returns tags, without hold tags, followed by the hold tags
+// of current, so that editing a hold's tags does not release its token
+func keepHoldTags(tags, current []string) []string {
+	isHold := func(tag string) bool { return strings.HasPrefix(tag, store.HoldTagPrefix) }
+	kept := slices.DeleteFunc(slices.Clone(tags), isHold)
+	for _, tag := range current {
+		if isHold(tag) {
+			kept = append(kept, tag)
+		}
+	}
+	return kept
+}
+
+// newHoldToken returns a random token for a hold
+func newHoldToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate hold token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
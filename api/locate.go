@@ -0,0 +1,39 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// locateIP reports the most specific network containing an address and
+// the allocation covering it
+func (s *Server) locateIP(w http.ResponseWriter, r *http.Request) {
+	ip := r.URL.Query().Get("ip")
+	if net.ParseIP(ip) == nil {
+		var errs fieldErrors
+		errs.add("ip", "invalid IP address %q", ip)
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	loc, err := store.Locate(s.store, ip, s.clock.Now())
+	if err != nil {
+		if errors.Is(err, ipam.ErrNetworkNotFound) {
+			writeError(w, http.StatusNotFound, err)
+		} else {
+			writeError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	if s.rejectDenied(w, r, loc.Network.ID, "", store.PermissionView) {
+		return
+	}
+	loc.Networks = s.visibleNetworks(r, loc.Networks)
+
+	json.NewEncoder(w).Encode(loc)
+}
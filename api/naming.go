@@ -0,0 +1,67 @@
+package api
+
+import (
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/naming"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// allocationRequest is the body accepted when allocating IPs: the engine's
+// request plus an optional template for generating the hostname, the token
+// of a hold whose address to take, the pool to allocate from and DHCP
+// options
+type allocationRequest struct {
+	ipam.AllocationRequest
+	HostnameTemplate string `json:"hostname_template,omitempty"`
+	HoldToken        string `json:"hold_token,omitempty"`
+
+	// Pool names the pool of the network to allocate from, see
+	// store.PoolTagPrefix
+	Pool string `json:"pool,omitempty"`
+
+	// DHCP options are stored as the allocation's tags
+	DHCP *store.DHCPOptions `json:"dhcp,omitempty"`
+}
+
+// generateHostname fills in req.Hostname from its template when no hostname
+// was supplied. An unknown network is left for the allocator to report.
+func (s *Server) generateHostname(req *allocationRequest) fieldErrors {
+	var errs fieldErrors
+	if req.HostnameTemplate == "" || req.Hostname != "" {
+		return errs
+	}
+	if err := naming.Validate(req.HostnameTemplate); err != nil {
+		errs.add("hostname_template", "%v", err)
+		return errs
+	}
+
+	var network *ipam.Network
+	var err error
+	if req.NetworkID != "" {
+		network, err = s.store.GetNetwork(req.NetworkID)
+	} else {
+		network, err = s.store.GetNetworkByCIDR(req.CIDR)
+	}
+	if err != nil {
+		return errs
+	}
+
+	allocations, err := s.store.ListAllocations(network.ID)
+	if err != nil {
+		errs.add("hostname_template", "failed to list allocations: %v", err)
+		return errs
+	}
+
+	hostname, err := naming.NewGenerator(network, allocations, s.clock.Now()).Generate(req.HostnameTemplate)
+	if err != nil {
+		errs.add("hostname_template", "%v", err)
+		return errs
+	}
+	if err := validateHostname(hostname); err != nil {
+		errs.add("hostname_template", "generated hostname %q: %v", hostname, err)
+		return errs
+	}
+
+	req.Hostname = hostname
+	return errs
+}
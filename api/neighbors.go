@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/neighbor"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// ingestNeighbors records an ARP or NDP table pushed by a collector on the
+// allocations of the networks the caller may allocate in, and reports MAC
+// conflicts, which are also written to the audit log
+func (s *Server) ingestNeighbors(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", neighbor.FormatText, neighbor.FormatJSON:
+	default:
+		var errs fieldErrors
+		errs.add("format", "must be one of text or json")
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	entries, err := neighbor.Parse(http.MaxBytesReader(w, r.Body, maxObservationBytes), format)
+	if err != nil {
+		if tooLarge(err) {
+			writeDecodeError(w, err)
+			return
+		}
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	caller := s.caller(r)
+	in := &neighbor.Ingester{
+		Store: s.store,
+		Allow: func(network *ipam.Network) bool {
+			return store.NetworkACL(network).Allows(store.PermissionAllocate, caller)
+		},
+	}
+	result, err := in.Ingest(entries, s.clock.Now())
+	if result != nil {
+		for _, c := range result.Conflicts {
+			details := fmt.Sprintf("Observed MAC %s for IP %s", strings.Join(c.Observed, ", "), c.IP)
+			if c.Recorded != "" {
+				details += ", recorded " + c.Recorded
+			}
+			s.recordAudit(r, "mac_conflict", c.AllocationID, details)
+		}
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
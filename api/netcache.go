@@ -0,0 +1,85 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// networkCacheTTL bounds how long the cached network list can miss networks
+// added or removed behind the API's back, by another cluster node or by
+// replication from a primary
+const networkCacheTTL = 5 * time.Second
+
+// maxListWorkers bounds how many networks have their allocations fetched at
+// once when listing every allocation
+const maxListWorkers = 8
+
+// networkCache holds the network list for handlers that only enumerate
+// networks, such as listing every allocation, so that they do not read
+// every network from the store on each request. Handlers adding or removing
+// networks invalidate it.
+type networkCache struct {
+	mu       sync.Mutex
+	networks []*ipam.Network
+	loadedAt time.Time
+	valid    bool
+}
+
+// cachedNetworks returns the network list, from the cache while it is valid
+// and younger than networkCacheTTL. The networks are shared: callers must
+// not modify them, and should read anything but their IDs from the store.
+func (s *Server) cachedNetworks() ([]*ipam.Network, error) {
+	c := &s.networkCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := s.clock.Now()
+	if c.valid && now.Sub(c.loadedAt) < networkCacheTTL {
+		return c.networks, nil
+	}
+	networks, err := s.store.ListNetworks()
+	if err != nil {
+		return nil, err
+	}
+	c.networks, c.loadedAt, c.valid = networks, now, true
+	return networks, nil
+}
+
+// invalidateNetworks drops the cached network list once the API has added
+// or removed networks
+func (s *Server) invalidateNetworks() {
+	c := &s.networkCache
+	c.mu.Lock()
+	c.valid = false
+	c.networks = nil
+	c.mu.Unlock()
+}
+
+// listAllocationsOf fetches the allocations of networks concurrently, at
+// most maxListWorkers at a time, and returns them in network order. A
+// network whose allocations cannot be read, e.g. because it was deleted
+// meanwhile, is skipped.
+func (s *Server) listAllocationsOf(networks []*ipam.Network) [][]*ipam.IPAllocation {
+	results := make([][]*ipam.IPAllocation, len(networks))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for n := 0; n < min(maxListWorkers, len(networks)); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if allocations, err := s.store.ListAllocations(networks[i].ID); err == nil {
+					results[i] = allocations
+				}
+			}
+		}()
+	}
+	for i := range networks {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// maxPreviewCount bounds the addresses one next-free preview lists
+const maxPreviewCount = 256
+
+// freeAddress is an address the allocator would pick, and its hold when
+// one was requested
+type freeAddress struct {
+	IP           string     `json:"ip"`
+	Token        string     `json:"token,omitempty"`
+	AllocationID string     `json:"allocation_id,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+}
+
+// nextFreeResponse lists a network's next free addresses in allocation order
+type nextFreeResponse struct {
+	NetworkID string         `json:"network_id"`
+	Addresses []*freeAddress `json:"addresses"`
+}
+
+// nextFree previews the count addresses the allocator would hand out next
+// in a network, without committing them: the allocator runs against a
+// staged copy of the store that is discarded. POSTed, the addresses are
+// held instead, for ttl seconds, in one atomic write; each comes with the
+// token that takes it over. Fewer addresses are listed when the network has
+// fewer free.
+func (s *Server) nextFree(w http.ResponseWriter, r *http.Request) {
+	networkID := mux.Vars(r)["id"]
+	q := r.URL.Query()
+
+	var errs fieldErrors
+	count := 1
+	if v := q.Get("count"); v != "" {
+		var err error
+		if count, err = strconv.Atoi(v); err != nil || count < 1 || count > maxPreviewCount {
+			errs.add("count", "must be between 1 and %d", maxPreviewCount)
+		}
+	}
+	hold := r.Method == http.MethodPost
+	ttl := defaultHoldSeconds
+	if v := q.Get("ttl"); v != "" {
+		var err error
+		if ttl, err = strconv.Atoi(v); err != nil || ttl < 1 || ttl > maxHoldSeconds {
+			errs.add("ttl", "must be between 1 and %d seconds", maxHoldSeconds)
+		}
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	if !hold {
+		addresses, _, err := s.stageFree(networkID, count, 0)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+			return
+		}
+		json.NewEncoder(w).Encode(&nextFreeResponse{NetworkID: networkID, Addresses: addresses})
+		return
+	}
+
+	applier, ok := s.store.(batchApplier)
+	if !ok {
+		writeErrorCode(w, http.StatusNotImplemented, CodeInternal, "store does not support holding several addresses", nil)
+		return
+	}
+	if s.rejectFrozen(w, networkID, "") {
+		return
+	}
+
+	var addresses []*freeAddress
+	var err error
+	for attempt := 0; attempt < transactionAttempts; attempt++ {
+		var batch *store.Batch
+		if addresses, batch, err = s.stageFree(networkID, count, ttl); err != nil || len(addresses) == 0 {
+			break
+		}
+		if err = applier.ApplyBatch(batch); !errors.Is(err, store.ErrBatchConflict) {
+			break
+		}
+	}
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	for _, a := range addresses {
+		s.recordAudit(r, "ip_held", a.AllocationID, fmt.Sprintf("Held %s for %ds", a.IP, ttl))
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&nextFreeResponse{NetworkID: networkID, Addresses: addresses})
+}
+
+// stageFree allocates up to count single addresses in networkID against a
+// staged copy of the store. With a ttl each allocation is a hold with its
+// own token, and the returned batch saves them.
+func (s *Server) stageFree(networkID string, count, ttl int) ([]*freeAddress, *store.Batch, error) {
+	staging := store.NewStaging(s.store)
+	engine := ipam.New(staging)
+
+	addresses := []*freeAddress{}
+	for len(addresses) < count {
+		req := &ipam.AllocationRequest{NetworkID: networkID}
+		var token string
+		if ttl > 0 {
+			var err error
+			if token, err = newHoldToken(); err != nil {
+				return nil, nil, err
+			}
+			req.Tags = []string{store.HoldTag(token)}
+			req.TTL = ttl
+		}
+		alloc, err := engine.AllocateIP(req)
+		if errors.Is(err, ipam.ErrNetworkFull) {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		free := &freeAddress{IP: alloc.IP}
+		if token != "" {
+			free.Token, free.AllocationID, free.ExpiresAt = token, alloc.ID, alloc.ExpiresAt
+		}
+		addresses = append(addresses, free)
+	}
+	return addresses, staging.Batch(), nil
+}
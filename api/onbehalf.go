@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// OnBehalfOfHeader names the end user a request is made for by an admin
+// principal, such as an automation gateway acting for its users
+const OnBehalfOfHeader = "X-On-Behalf-Of"
+
+// onBehalfOfPattern limits the end users named to what is safe to log
+var onBehalfOfPattern = regexp.MustCompile(`^[A-Za-z0-9._:@+/-]{1,128}$`)
+
+type auditUserKey struct{}
+
+// SetAdminPrincipals lets the callers named by principals, e.g.
+// "token:9f86d081" or "spiffe:gateway", send X-On-Behalf-Of. Nobody may
+// by default.
+func (s *Server) SetAdminPrincipals(principals []string) {
+	s.admins = principals
+}
+
+// withAuditUser records who the audit entries r leads to are logged as:
+// the principal of r, see principal, followed by the end user it names in
+// X-On-Behalf-Of, e.g. "alice via token:9f86d081@10.0.0.5". Without
+// authentication configured the user is "api". A caller that is not an
+// admin principal may not name an end user.
+func (s *Server) withAuditUser(r *http.Request) (*http.Request, error) {
+	user := "api"
+	if len(s.tokens) > 0 || s.spiffeIDs != nil {
+		user = s.principal(r)
+	}
+	if onBehalfOf := r.Header.Get(OnBehalfOfHeader); onBehalfOf != "" {
+		if !slices.Contains(s.admins, s.caller(r)) {
+			return nil, fmt.Errorf("%w: %s may not act on behalf of other users", store.ErrPermissionDenied, s.caller(r))
+		}
+		if !onBehalfOfPattern.MatchString(onBehalfOf) {
+			return nil, fmt.Errorf("invalid %s %q", OnBehalfOfHeader, onBehalfOf)
+		}
+		user = onBehalfOf + " via " + user
+	}
+	return r.WithContext(context.WithValue(r.Context(), auditUserKey{}, user)), nil
+}
+
+// auditUser returns who the audit entries r leads to are logged as, "api"
+// for a nil request or one that did not go through the server
+func auditUser(r *http.Request) string {
+	if r == nil {
+		return "api"
+	}
+	if user, ok := r.Context().Value(auditUserKey{}).(string); ok {
+		return user
+	}
+	return "api"
+}
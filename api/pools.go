@@ -0,0 +1,96 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// validatePool checks that a request allocating from a pool allocates a
+// single address of its own
+func validatePool(errs *fieldErrors, req *allocationRequest) {
+	if req.Pool == "" {
+		return
+	}
+	if req.Count > 1 {
+		errs.add("count", "must be 1 when allocating from a pool")
+	}
+	if req.HoldToken != "" {
+		errs.add("pool", "cannot be combined with hold_token")
+	}
+}
+
+// allocateInPool allocates the lowest free address of the pool of req's
+// network named pool, for r. The allocation is staged against the
+// network's allocations and staged again if other writes changed them
+// meanwhile.
+func (s *Server) allocateInPool(r *http.Request, pool string, req *ipam.AllocationRequest) (*ipam.IPAllocation, error) {
+	applier, ok := s.store.(batchApplier)
+	if !ok {
+		return nil, errors.New("store does not support allocating from pools")
+	}
+
+	var alloc *ipam.IPAllocation
+	var err error
+	for attempt := 0; attempt < transactionAttempts; attempt++ {
+		staging := store.NewStaging(s.store)
+		if alloc, err = s.stagePoolAllocation(staging, pool, req); err != nil {
+			return nil, err
+		}
+		if err = applier.ApplyBatch(staging.Batch()); !errors.Is(err, store.ErrBatchConflict) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.recordAudit(r, "ip_allocated", alloc.ID, fmt.Sprintf("Allocated %s from pool %s", alloc.IP, pool))
+	return alloc, nil
+}
+
+// stagePoolAllocation stages the allocation of the lowest free address of
+// the pool named pool
+func (s *Server) stagePoolAllocation(staging *store.Staging, pool string, req *ipam.AllocationRequest) (*ipam.IPAllocation, error) {
+	var network *ipam.Network
+	var err error
+	if req.NetworkID != "" {
+		network, err = staging.GetNetwork(req.NetworkID)
+	} else {
+		network, err = staging.GetNetworkByCIDR(req.CIDR)
+	}
+	if err != nil {
+		return nil, err
+	}
+	allocations, err := staging.ListAllocations(network.ID)
+	if err != nil {
+		return nil, err
+	}
+	now := s.clock.Now()
+	ip, err := store.NextInPool(network, allocations, pool, now)
+	if err != nil {
+		return nil, err
+	}
+	id, err := store.NewAllocationID()
+	if err != nil {
+		return nil, err
+	}
+	alloc := &ipam.IPAllocation{
+		ID:          id,
+		NetworkID:   network.ID,
+		IP:          ip,
+		Hostname:    req.Hostname,
+		Description: req.Description,
+		Tags:        req.Tags,
+		Status:      "allocated",
+		AllocatedAt: now,
+	}
+	if req.TTL > 0 {
+		expires := now.Add(time.Duration(req.TTL) * time.Second)
+		alloc.ExpiresAt = &expires
+	}
+	return alloc, staging.SaveAllocation(alloc)
+}
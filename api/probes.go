@@ -0,0 +1,38 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// pinger is implemented by stores that can report whether they are able to
+// serve requests
+type pinger interface {
+	Ping() error
+}
+
+// Probe paths for container orchestrators. They sit outside /api so they
+// are never versioned, and like the health endpoints they need no token.
+const (
+	livezPath  = "/livez"
+	readyzPath = "/readyz"
+)
+
+// livez answers as long as the process serves HTTP
+func (s *Server) livez(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// readyz answers 200 when the store can serve requests and 503 otherwise,
+// e.g. while a cluster node has no leader
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	if p, ok := s.store.(pinger); ok {
+		if err := p.Ping(); err != nil {
+			writeErrorCode(w, http.StatusServiceUnavailable, CodeNotReady, err.Error(), nil)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ready"})
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jeremyhahn/go-ipam/pkg/gossip"
+)
+
+// nodeProgressResponse reports how far a node has caught up with the rest
+// of the cluster, e.g. after it was added
+type nodeProgressResponse struct {
+	NodeID   uint64  `json:"node_id"`
+	Member   bool    `json:"member"` // In the Raft membership
+	State    string  `json:"state"`
+	Ready    bool    `json:"ready"`
+	Applied  uint64  `json:"applied"`
+	Target   uint64  `json:"target"` // Applied by the furthest caught-up node
+	Behind   uint64  `json:"behind"`
+	Percent  float64 `json:"percent"`
+	CaughtUp bool    `json:"caught_up"`
+}
+
+// nodeProgress reports node nodeID's catch-up progress. This node's own
+// progress is measured directly; the others' come from gossip, so they are
+// a few seconds old.
+func (s *Server) nodeProgress(w http.ResponseWriter, r *http.Request) {
+	if s.raftStore == nil {
+		writeErrorCode(w, http.StatusBadRequest, CodeClusterModeRequired, "Not in cluster mode", nil)
+		return
+	}
+
+	nodeID, err := strconv.ParseUint(mux.Vars(r)["nodeID"], 10, 64)
+	if err != nil {
+		writeErrorCode(w, http.StatusBadRequest, CodeBadRequest, "Invalid node ID", nil)
+		return
+	}
+
+	local, err := s.raftStore.Progress()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// This node, then the others as last gossiped
+	self := gossip.Member{
+		Meta: gossip.Meta{
+			NodeID:   local.NodeID,
+			Ready:    s.raftStore.Ping() == nil,
+			Applied:  local.Applied,
+			CaughtUp: local.CaughtUp,
+		},
+		State: gossip.StateAlive,
+	}
+	members := []gossip.Member{self}
+	if s.members != nil {
+		for _, m := range s.members() {
+			if m.NodeID != local.NodeID {
+				members = append(members, m)
+			}
+		}
+	}
+
+	progress, ok := catchUpProgress(nodeID, members, s.raftStore.LocalMembership())
+	if !ok {
+		if s.members == nil {
+			writeErrorCode(w, http.StatusBadRequest, CodeGossipRequired,
+				fmt.Sprintf("Progress of other nodes is shared over gossip; start the nodes with --gossip-addr, or ask node %d", nodeID), nil)
+			return
+		}
+		writeErrorCode(w, http.StatusNotFound, CodeNotFound, fmt.Sprintf("Node %d has not been heard from", nodeID), nil)
+		return
+	}
+	json.NewEncoder(w).Encode(progress)
+}
+
+// catchUpProgress compares node nodeID's applied count with that of the
+// furthest member that is caught up. membership maps the Raft members' IDs
+// to their addresses. ok is false when nodeID is not among members.
+func catchUpProgress(nodeID uint64, members []gossip.Member, membership map[uint64]string) (*nodeProgressResponse, bool) {
+	var node *gossip.Member
+	var target, furthest uint64
+	for i, m := range members {
+		if m.NodeID == nodeID {
+			node = &members[i]
+		}
+		if m.State != gossip.StateAlive {
+			continue
+		}
+		furthest = max(furthest, m.Applied)
+		if m.CaughtUp {
+			target = max(target, m.Applied)
+		}
+	}
+	if node == nil {
+		return nil, false
+	}
+	// Without a leader no node is caught up
+	if target == 0 {
+		target = furthest
+	}
+
+	_, member := membership[nodeID]
+	p := &nodeProgressResponse{
+		NodeID:  nodeID,
+		Member:  member,
+		State:   node.State,
+		Ready:   node.Ready,
+		Applied: node.Applied,
+		Target:  target,
+	}
+
+	// A node's own caught-up check is exact; counts gossiped at different
+	// moments are not
+	switch {
+	case node.State == gossip.StateAlive && node.CaughtUp:
+		p.CaughtUp = true
+		p.Percent = 100
+	case target > node.Applied:
+		p.Behind = target - node.Applied
+		p.Percent = math.Floor(float64(node.Applied)/float64(target)*1000) / 10
+	case target > 0:
+		p.Percent = 99.9
+	}
+	return p, true
+}
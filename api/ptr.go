@@ -0,0 +1,152 @@
+package api
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jeremyhahn/go-ipam/pkg/rdns"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// Reverse zone delegations of IPv4 networks longer than /24
+const (
+	// delegationChild renders the network's RFC 2317 zone
+	delegationChild = "child"
+
+	// delegationParent renders the CNAME records, and NS records with ns,
+	// delegating the network's addresses from its /24 zone
+	delegationParent = "parent"
+
+	// delegationNone renders PTR records in the /24 zone itself
+	delegationNone = "none"
+)
+
+// getPTRZone renders a reverse DNS zone file for the network's active
+// allocations that have hostnames. The zone of an IPv4 network longer
+// than /24 is an RFC 2317 classless zone, unless delegation says otherwise.
+func (s *Server) getPTRZone(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	q := r.URL.Query()
+
+	zone := &rdns.Zone{
+		NS:    q.Get("ns"),
+		Email: q.Get("email"),
+	}
+	domain := q.Get("domain")
+	delegation := q.Get("delegation")
+	style := q.Get("classless_style")
+
+	var errs fieldErrors
+	if v := q.Get("ttl"); v != "" {
+		ttl, err := strconv.Atoi(v)
+		if err != nil || ttl < 0 || ttl > maxTTLSeconds {
+			errs.add("ttl", "must be between 0 and %d seconds", maxTTLSeconds)
+		}
+		zone.TTL = ttl
+	}
+	switch delegation {
+	case "", delegationChild, delegationParent, delegationNone:
+	default:
+		errs.add("delegation", "must be %s, %s or %s", delegationChild, delegationParent, delegationNone)
+	}
+	if style != "" && !slices.Contains(rdns.ClasslessStyles, style) {
+		errs.add("classless_style", "must be %s", strings.Join(rdns.ClasslessStyles, ", "))
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	network, err := s.store.GetNetwork(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	_, ipNet, err := net.ParseCIDR(network.CIDR)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	zone.Origin = rdns.ZoneOrigin(ipNet)
+	classless := rdns.IsClassless(ipNet)
+	if !classless && (delegation == delegationChild || delegation == delegationParent) {
+		errs.add("delegation", "only applies to IPv4 networks longer than /24")
+		writeValidationErrors(w, errs)
+		return
+	}
+	if classless && delegation == delegationParent {
+		writeClasslessDelegation(w, zone, ipNet, style)
+		return
+	}
+	if classless && delegation != delegationNone {
+		zone.Origin = rdns.ClasslessOrigin(ipNet, style)
+	}
+
+	allocations, err := s.store.ListAllocations(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	sort.Slice(allocations, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(allocations[i].IP).To16(), net.ParseIP(allocations[j].IP).To16()) < 0
+	})
+
+	now := s.clock.Now()
+	for _, alloc := range allocations {
+		if alloc.Hostname == "" || store.AllocationStatus(alloc, now) != store.StatusActive {
+			continue
+		}
+
+		ips, err := rdns.ExpandRange(alloc.IP, alloc.EndIP)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		target := rdns.QualifyHostname(alloc.Hostname, domain)
+		for _, ip := range ips {
+			name := rdns.RelativeName(rdns.ReverseName(ip), zone.Origin)
+			if classless && delegation != delegationNone {
+				// Names in a classless zone are the last octet
+				name = strconv.Itoa(int(ip.To4()[3]))
+			}
+			zone.Records = append(zone.Records, rdns.Record{Name: name, Target: target})
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/dns; charset=utf-8")
+	zone.Render(w)
+}
+
+// writeClasslessDelegation renders the records delegating a classless
+// network's reverse zone from its /24 zone, as RFC 2317 describes: an NS
+// record when zone names a name server, and a CNAME for every address of
+// the network pointing into the classless zone. They are meant to be
+// included in the /24 zone, so no SOA is emitted.
+func writeClasslessDelegation(w http.ResponseWriter, zone *rdns.Zone, ipNet *net.IPNet, style string) {
+	label := rdns.ClasslessLabel(ipNet, style)
+	if zone.NS != "" {
+		zone.Records = append(zone.Records, rdns.Record{Name: label, Type: "NS", Target: rdns.QualifyHostname(zone.NS, "")})
+		zone.NS = ""
+	}
+	ones, _ := ipNet.Mask.Size()
+	first := int(ipNet.IP.To4()[3])
+	for octet := first; octet < first+1<<(32-ones); octet++ {
+		zone.Records = append(zone.Records, rdns.Record{
+			Name:   strconv.Itoa(octet),
+			Type:   "CNAME",
+			Target: strconv.Itoa(octet) + "." + label,
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/dns; charset=utf-8")
+	zone.Render(w)
+}
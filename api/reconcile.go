@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+
+	"github.com/gorilla/mux"
+	"github.com/jeremyhahn/go-ipam/pkg/reconcile"
+)
+
+// maxObservationBytes bounds the size of an uploaded observation
+const maxObservationBytes = 16 << 20
+
+// reconcileResponse is the reconcile report, with the outcome of the scan
+// import when one was asked for
+type reconcileResponse struct {
+	*reconcile.Report
+	Import *reconcile.ImportResult `json:"import,omitempty"`
+}
+
+// reconcileNetwork compares the network's allocations with an uploaded list
+// of observed addresses and reports the differences. With import=true, an
+// nmap or masscan scan is imported first, see reconcile.Import.
+func (s *Server) reconcileNetwork(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var errs fieldErrors
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", reconcile.FormatText, reconcile.FormatNmap, reconcile.FormatMasscan, reconcile.FormatJSON:
+	default:
+		errs.add("format", "must be one of text, nmap, masscan or json")
+	}
+	importScan := r.URL.Query().Get("import") == "true"
+	if importScan && format != reconcile.FormatNmap && format != reconcile.FormatMasscan {
+		errs.add("import", "needs format nmap or masscan")
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	network, err := s.store.GetNetwork(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if importScan && s.rejectFrozen(w, network.ID, "") {
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxObservationBytes)
+	var observed []netip.Addr
+	var hosts []reconcile.Host
+	if importScan {
+		hosts, err = reconcile.ParseScan(body)
+		observed = reconcile.Addrs(hosts)
+	} else {
+		observed, err = reconcile.Parse(body, format)
+	}
+	if err != nil {
+		if tooLarge(err) {
+			writeDecodeError(w, err)
+			return
+		}
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var resp reconcileResponse
+	if importScan {
+		resp.Import, err = reconcile.Import(s.store, network, hosts, s.clock.Now())
+		if resp.Import != nil {
+			for _, d := range resp.Import.Discovered {
+				s.recordAudit(r, "allocation_discovered", d.AllocationID, fmt.Sprintf("Recorded IP %s found by a network scan", d.IP))
+			}
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	allocations, err := s.store.ListAllocations(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resp.Report, err = reconcile.Compare(network, allocations, observed, s.clock.Now())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/replication"
+)
+
+// SetReplica makes the server a standby of r's primary: it serves reads,
+// rejects writes with 503 standby until r is promoted, and reports r's
+// status at /api/v1/replication and the age of its data at /api/v1/health
+func (s *Server) SetReplica(r *replication.Replica) {
+	s.replica = r
+}
+
+// standbyRejects reports whether r is a write a standby must refuse.
+// Managing the standby's own Raft membership and promoting it are allowed.
+func (s *Server) standbyRejects(r *http.Request) bool {
+	if s.replica == nil {
+		return false
+	}
+	if readsOnly(r) {
+		return false
+	}
+	if r.URL.Path == "/api/v1/replication/promote" || strings.HasPrefix(r.URL.Path, "/api/v1/cluster/") {
+		return false
+	}
+	return s.replica.Standby()
+}
+
+func (s *Server) replicationStatus(w http.ResponseWriter, r *http.Request) {
+	if s.replica == nil {
+		writeErrorCode(w, http.StatusNotFound, CodeNotFound, "This server is not a replica", nil)
+		return
+	}
+	json.NewEncoder(w).Encode(s.replica.Status())
+}
+
+// replicaHealth is the replication part of the health check
+type replicaHealth struct {
+	Primary  string     `json:"primary"`
+	Standby  bool       `json:"standby"`
+	ReadOnly bool       `json:"read_only,omitempty"`
+	LastSync *time.Time `json:"last_sync,omitempty"`
+	// SnapshotAge is how many seconds ago the replica last matched the
+	// primary; absent until it has since starting
+	SnapshotAge *int64 `json:"snapshot_age_seconds,omitempty"`
+	LastError   string `json:"last_error,omitempty"`
+}
+
+func (s *Server) replicaHealth() replicaHealth {
+	status := s.replica.Status()
+	h := replicaHealth{
+		Primary:   status.Primary,
+		Standby:   status.Standby,
+		ReadOnly:  status.ReadOnly,
+		LastSync:  status.LastSync,
+		LastError: status.LastError,
+	}
+	if age, ok := s.replica.Age(s.clock.Now()); ok {
+		seconds := int64(age / time.Second)
+		h.SnapshotAge = &seconds
+	}
+	return h
+}
+
+// promote stops replication so this server accepts writes. Promoting twice
+// is harmless.
+func (s *Server) promote(w http.ResponseWriter, r *http.Request) {
+	if s.replica == nil {
+		writeErrorCode(w, http.StatusNotFound, CodeNotFound, "This server is not a replica", nil)
+		return
+	}
+	status, err := s.replica.Promote(s.clock.Now())
+	if errors.Is(err, replication.ErrReadOnly) {
+		writeErrorCode(w, http.StatusConflict, CodeConflict, "This server is a read-only replica and cannot be promoted", nil)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.recordAudit(r, "promote", "replication", fmt.Sprintf("Promoted; stopped replicating from %s", status.Primary))
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jeremyhahn/go-ipam/pkg/report"
+)
+
+// maxReportTop bounds the nearly-full networks a capacity report lists
+const maxReportTop = 1000
+
+// capacityReport aggregates utilization across the networks matching the
+// network list filters, grouped by the labels in group_by, and lists the
+// top fullest networks
+func (s *Server) capacityReport(w http.ResponseWriter, r *http.Request) {
+	filter, errs := parseNetworkFilter(r)
+
+	q := r.URL.Query()
+	var opts report.Options
+	if q.Has("group_by") {
+		opts.GroupBy = []string{}
+		for _, label := range strings.Split(q.Get("group_by"), ",") {
+			label = strings.TrimSpace(label)
+			if label == "" {
+				continue
+			}
+			if strings.ContainsAny(label, "= ") {
+				errs.add("group_by", "invalid label key %q", label)
+			}
+			opts.GroupBy = append(opts.GroupBy, label)
+		}
+	}
+	if v := q.Get("top"); v != "" {
+		top, err := strconv.Atoi(v)
+		if err != nil || top < 1 || top > maxReportTop {
+			errs.add("top", "must be between 1 and %d", maxReportTop)
+		}
+		opts.Top = top
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	networks, err := s.findNetworks(r, filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	capacity, err := report.BuildCapacity(s.ipam, networks, opts, s.clock.Now())
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(capacity)
+}
+
+// groupedStatsResponse is the utilization of the networks per group
+type groupedStatsResponse struct {
+	GroupBy string          `json:"group_by"`
+	Groups  []*report.Group `json:"groups"`
+}
+
+// groupedStats adds up the utilization of the networks matching the network
+// list filters per value of the group_by label, or per tag, so that clients
+// need not fetch the stats of every network
+func (s *Server) groupedStats(w http.ResponseWriter, r *http.Request) {
+	filter, errs := parseNetworkFilter(r)
+
+	label := strings.TrimSpace(r.URL.Query().Get("group_by"))
+	switch {
+	case label == "":
+		errs.add("group_by", "is required")
+	case strings.ContainsAny(label, "=, "):
+		errs.add("group_by", "invalid label key %q", label)
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	networks, err := s.findNetworks(r, filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	groups, err := report.GroupUsage(s.ipam, networks, label)
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(&groupedStatsResponse{GroupBy: label, Groups: groups})
+}
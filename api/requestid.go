@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+)
+
+// RequestIDHeader carries the correlation ID of a request, both ways
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDPattern limits caller-provided IDs to what is safe to log
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type requestIDKey struct{}
+
+// withRequestID gives r a correlation ID, the caller's when it provides a
+// usable one, and returns it in the response header. Audit entries the
+// request leads to carry the ID in their details, and the access log
+// prints it, so a multi-record operation can be traced end to end.
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := r.Header.Get(RequestIDHeader)
+	if !requestIDPattern.MatchString(id) {
+		id = newRequestID()
+	}
+	w.Header().Set(RequestIDHeader, id)
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
+
+// requestID returns the correlation ID of r, or "" for a nil request or
+// one that did not go through the server
+func requestID(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
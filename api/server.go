@@ -1,13 +1,32 @@
 package api
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/jeremyhahn/go-ipam/pkg/approval"
+	"github.com/jeremyhahn/go-ipam/pkg/auditlog"
+	"github.com/jeremyhahn/go-ipam/pkg/clock"
+	"github.com/jeremyhahn/go-ipam/pkg/gossip"
+	"github.com/jeremyhahn/go-ipam/pkg/hooks"
 	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/notify"
+	"github.com/jeremyhahn/go-ipam/pkg/replication"
+	"github.com/jeremyhahn/go-ipam/pkg/spiffe"
 	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/jeremyhahn/go-ipam/pkg/taxonomy"
 )
 
 type Server struct {
@@ -15,6 +34,28 @@ type Server struct {
 	store     ipam.Store
 	router    *mux.Router
 	raftStore *store.RaftStore // Optional, only set in cluster mode
+	clock     clock.Clock
+	approvals *approval.Webhooks // Optional, see SetApprovalWebhooks
+	notifier  *notify.Notifier   // Optional, see SetNotifier
+	hooks     hooks.Chain
+	tokens    [][]byte               // Accepted bearer tokens, see SetAuthTokens
+	admins    []string               // Callers that may send X-On-Behalf-Of, see SetAdminPrincipals
+	spiffeIDs *spiffe.Mapper         // Optional, see SetSPIFFEIDs
+	members   func() []gossip.Member // Optional, see SetGossip
+	replica   *replication.Replica   // Optional, see SetReplica
+
+	tagRegistry *taxonomy.Registry // Optional, see SetTagRegistry
+
+	holdMu sync.Mutex // Serializes taking over holds
+
+	autoCreate   bool       // See SetAutoCreateNetworks
+	autoCreateMu sync.Mutex // Serializes creating networks on demand
+
+	networkCache networkCache // See cachedNetworks
+
+	auditWriter *auditlog.Writer // Optional, see SetAuditWriter
+
+	maxBodySize int64 // 0 is unlimited, see SetMaxBodySize
 }
 
 func NewServer(ipamClient *ipam.IPAM, st ipam.Store) *Server {
@@ -22,25 +63,71 @@ func NewServer(ipamClient *ipam.IPAM, st ipam.Store) *Server {
 		ipam:   ipamClient,
 		store:  st,
 		router: mux.NewRouter(),
+		clock:  clock.System,
+		hooks:  hooks.Registered(),
 	}
 
 	// Check if this is a Raft store
 	if raftStore, ok := st.(*store.RaftStore); ok {
 		s.raftStore = raftStore
+		go s.auditLeaderChanges(raftStore.NodeID(), raftStore.LeaderChanges())
 	}
 
 	s.setupRoutes()
 	return s
 }
 
+// SetClock replaces the clock used for audit timestamps and for judging
+// expiry and freezes, e.g. with a clock.Fake in tests
+func (s *Server) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// SetHooks replaces the lifecycle hooks run around allocations and
+// releases, by default those registered with hooks.Register
+func (s *Server) SetHooks(c hooks.Chain) {
+	s.hooks = c
+}
+
+// SetMaxBodySize rejects request bodies larger than n bytes with 413
+// request_too_large. 0 removes the limit.
+func (s *Server) SetMaxBodySize(n int64) {
+	s.maxBodySize = n
+}
+
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = withRequestID(w, r)
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ipam"`)
+		writeErrorCode(w, http.StatusUnauthorized, CodeUnauthorized, "A valid API token is required", nil)
+		return
+	}
+	r, err := s.withAuditUser(r)
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusBadRequest), err)
+		return
+	}
+	if s.maxBodySize > 0 {
+		// Refuse declared oversized bodies before reading any of them
+		if r.ContentLength > s.maxBodySize {
+			writeErrorCode(w, http.StatusRequestEntityTooLarge, CodeRequestTooLarge,
+				fmt.Sprintf("request body exceeds %d bytes", s.maxBodySize), nil)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxBodySize)
+	}
+	if s.standbyRejects(r) {
+		writeErrorCode(w, http.StatusServiceUnavailable, CodeStandby,
+			fmt.Sprintf("This server is a standby replica of %s; send writes to the primary, or promote this server", s.replica.Primary), nil)
+		return
+	}
 	s.router.ServeHTTP(w, r)
 }
 
 func (s *Server) setupRoutes() {
 	// API routes
 	api := s.router.PathPrefix("/api/v1").Subrouter()
-	api.Use(jsonMiddleware)
+	api.Use(jsonMiddleware, s.auditFailures, s.enforceACL)
 
 	// Network endpoints
 	api.HandleFunc("/networks", s.listNetworks).Methods("GET")
@@ -48,26 +135,77 @@ func (s *Server) setupRoutes() {
 	api.HandleFunc("/networks/{id}", s.getNetwork).Methods("GET")
 	api.HandleFunc("/networks/{id}", s.deleteNetwork).Methods("DELETE")
 	api.HandleFunc("/networks/{id}/stats", s.getNetworkStats).Methods("GET")
+	api.HandleFunc("/networks/{id}/allocations/count", s.countAllocations).Methods("GET")
+	api.HandleFunc("/networks/{id}/ptr-zone", s.getPTRZone).Methods("GET")
+	api.HandleFunc("/networks/{id}/reconcile", s.reconcileNetwork).Methods("POST")
+	api.HandleFunc("/networks/{id}/subnets", s.carveSubnet).Methods("POST")
+	api.HandleFunc("/networks/{id}/freeze", s.freezeNetwork).Methods("POST")
+	api.HandleFunc("/networks/{id}/freeze", s.unfreezeNetwork).Methods("DELETE")
+	api.HandleFunc("/networks/{id}/acl", s.getNetworkACL).Methods("GET")
+	api.HandleFunc("/networks/{id}/acl", s.setNetworkACL).Methods("PUT")
+	api.HandleFunc("/networks/{id}/hold", s.createHold).Methods("POST")
+	api.HandleFunc("/networks/{id}/next-free", s.nextFree).Methods("GET", "POST")
 
 	// Allocation endpoints
 	api.HandleFunc("/allocations", s.listAllocations).Methods("GET")
 	api.HandleFunc("/allocations", s.allocateIP).Methods("POST")
+	api.HandleFunc("/allocations/bulk-update", s.bulkUpdateAllocations).Methods("POST")
 	api.HandleFunc("/allocations/{id}", s.getAllocation).Methods("GET")
 	api.HandleFunc("/allocations/{id}/release", s.releaseIP).Methods("POST")
+	api.HandleFunc("/allocations/{id}/transfer", s.transferAllocation).Methods("POST")
+	api.HandleFunc("/allocations/{id}/cloud-init", s.getCloudInit).Methods("GET")
+
+	// Transaction endpoints
+	api.HandleFunc("/transactions", s.createTransaction).Methods("POST")
+
+	// Group endpoints
+	api.HandleFunc("/groups", s.createGroup).Methods("POST")
+	api.HandleFunc("/groups/{id}", s.getGroup).Methods("GET")
+	api.HandleFunc("/groups/{id}/release", s.releaseGroup).Methods("POST")
+
+	// Lookup endpoints
+	api.HandleFunc("/locate", s.locateIP).Methods("GET")
+	api.HandleFunc("/check", s.checkIPs).Methods("POST")
+	api.HandleFunc("/neighbors", s.ingestNeighbors).Methods("POST")
+
+	// Report endpoints
+	api.HandleFunc("/reports/capacity", s.capacityReport).Methods("GET")
+	api.HandleFunc("/stats", s.groupedStats).Methods("GET")
+
+	// Tag endpoints
+	api.HandleFunc("/tags", s.listTags).Methods("GET")
+	api.HandleFunc("/tags/rename", s.renameTag).Methods("POST")
+	api.HandleFunc("/tags/merge", s.mergeTags).Methods("POST")
 
 	// Audit endpoints
 	api.HandleFunc("/audit", s.listAuditEntries).Methods("GET")
+	api.HandleFunc("/audit/export", s.exportAuditEntries).Methods("GET")
+
+	// Event endpoints
+	api.HandleFunc("/events", s.listEvents).Methods("GET")
 
 	// Health check
 	api.HandleFunc("/health", s.healthCheck).Methods("GET")
 
+	// Replication endpoints
+	api.HandleFunc("/snapshot", s.exportSnapshot).Methods("GET")
+	api.HandleFunc("/replication", s.replicationStatus).Methods("GET")
+	api.HandleFunc("/replication/promote", s.promote).Methods("POST")
+
 	// Cluster endpoints (only available in cluster mode)
 	if s.raftStore != nil {
 		api.HandleFunc("/cluster/status", s.clusterStatus).Methods("GET")
 		api.HandleFunc("/cluster/nodes", s.addNode).Methods("POST")
 		api.HandleFunc("/cluster/nodes/{nodeID}", s.removeNode).Methods("DELETE")
+		api.HandleFunc("/cluster/nodes/{nodeID}/progress", s.nodeProgress).Methods("GET")
+		api.HandleFunc("/cluster/compact", s.compact).Methods("POST")
 	}
 
+	s.setupV2Routes()
+
+	// Liveness and readiness probes
+	s.router.HandleFunc(livezPath, s.livez).Methods("GET")
+	s.router.HandleFunc(readyzPath, s.readyz).Methods("GET")
 }
 
 // Middleware
@@ -78,37 +216,277 @@ func jsonMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// writeJSONWithETag encodes v with a content-derived ETag and answers
+// 304 Not Modified when the client already holds the current representation.
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	data = append(data, '\n')
+
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Write(data)
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		candidate = strings.TrimPrefix(candidate, "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// geoTags returns the tags the country and asn query parameters match
+func geoTags(q url.Values, errs *fieldErrors) []string {
+	var tags []string
+	if v := q.Get("country"); v != "" {
+		tag, err := store.CountryTag(v)
+		if err != nil {
+			errs.add("country", "%v", err)
+		}
+		tags = append(tags, tag)
+	}
+	if v := q.Get("asn"); v != "" {
+		tag, err := store.ASNTag(v)
+		if err != nil {
+			errs.add("asn", "%v", err)
+		}
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// networkFinder is implemented by stores that can filter networks natively
+type networkFinder interface {
+	FindNetworks(filter store.NetworkFilter) ([]*ipam.Network, error)
+}
+
+// parseNetworkFilter reads the cidr, contains_ip, tag, country, asn, q and
+// selector query parameters
+func parseNetworkFilter(r *http.Request) (store.NetworkFilter, fieldErrors) {
+	q := r.URL.Query()
+	filter := store.NetworkFilter{
+		CIDR:       q.Get("cidr"),
+		ContainsIP: q.Get("contains_ip"),
+		Tags:       q["tag"],
+		Query:      q.Get("q"),
+		Selector:   q.Get("selector"),
+	}
+
+	var errs fieldErrors
+	if filter.CIDR != "" {
+		if _, _, err := net.ParseCIDR(filter.CIDR); err != nil {
+			errs.add("cidr", "invalid CIDR %q", filter.CIDR)
+		}
+	}
+	if filter.ContainsIP != "" && net.ParseIP(filter.ContainsIP) == nil {
+		errs.add("contains_ip", "invalid IP address %q", filter.ContainsIP)
+	}
+	if _, err := store.ParseSelector(filter.Selector); err != nil {
+		errs.add("selector", "%v", err)
+	}
+	filter.Tags = append(filter.Tags, geoTags(q, &errs)...)
+
+	return filter, errs
+}
+
+// findNetworks lists the networks matching filter that the caller of r may
+// view, using the store's native filtering when available
+func (s *Server) findNetworks(r *http.Request, filter store.NetworkFilter) ([]*ipam.Network, error) {
+	var networks []*ipam.Network
+	var err error
+	if finder, ok := s.store.(networkFinder); ok && !filter.IsEmpty() {
+		networks, err = finder.FindNetworks(filter)
+	} else if networks, err = s.store.ListNetworks(); err == nil && !filter.IsEmpty() {
+		networks = store.FilterNetworks(networks, filter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.visibleNetworks(r, networks), nil
+}
+
 // Network handlers
 func (s *Server) listNetworks(w http.ResponseWriter, r *http.Request) {
-	networks, err := s.store.ListNetworks()
+	filter, errs := parseNetworkFilter(r)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	networks, err := s.findNetworks(r, filter)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	json.NewEncoder(w).Encode(networks)
+	writeJSONWithETag(w, r, networks)
+}
+
+// networkResponse is a network with the settings hosts in it need
+type networkResponse struct {
+	*ipam.Network
+	*store.Settings
 }
 
+// allocationResponse is an allocation with the settings of its network and
+// its DHCP options
+type allocationResponse struct {
+	*ipam.IPAllocation
+	*store.Settings
+}
+
+// networkSettings returns the settings of the network identified by
+// networkID, or nil when it has none or cannot be read
+func (s *Server) networkSettings(networkID string) *store.Settings {
+	network, err := s.store.GetNetwork(networkID)
+	if err != nil {
+		return nil
+	}
+	allocations, err := s.store.ListAllocations(networkID)
+	if err != nil {
+		return nil
+	}
+	return store.NetworkSettings(network, allocations, s.clock.Now())
+}
+
+// allocationSettings returns the settings of alloc, see
+// store.AllocationSettings, or nil when it has none or its network cannot
+// be read
+func (s *Server) allocationSettings(alloc *ipam.IPAllocation) *store.Settings {
+	network, err := s.store.GetNetwork(alloc.NetworkID)
+	if err != nil {
+		return nil
+	}
+	allocations, err := s.store.ListAllocations(alloc.NetworkID)
+	if err != nil {
+		return nil
+	}
+	return store.AllocationSettings(network, allocations, alloc, s.clock.Now())
+}
+
+// createNetwork creates a network. gateway, dns_servers, domain,
+// reuse_delay, dhcp and pools are stored as the network's tags, and the
+// gateway address is reserved by an allocation tagged gateway, created
+// along with the network.
 func (s *Server) createNetwork(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		CIDR        string   `json:"cidr"`
-		Description string   `json:"description"`
-		Tags        []string `json:"tags"`
+		CIDR        string             `json:"cidr"`
+		Description string             `json:"description"`
+		Tags        []string           `json:"tags"`
+		Gateway     string             `json:"gateway"`
+		DNSServers  []string           `json:"dns_servers"`
+		Domain      string             `json:"domain"`
+		ReuseDelay  int                `json:"reuse_delay"`
+		DHCP        *store.DHCPOptions `json:"dhcp"`
+		Pools       []store.Pool       `json:"pools"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeDecodeError(w, err)
 		return
 	}
 
-	network, err := s.ipam.AddNetwork(req.CIDR, req.Description, req.Tags)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	errs := validateNetworkRequest(req.CIDR, req.Description, req.Tags)
+	rejectACLTags(&errs, "tags", req.Tags)
+	s.checkTags(&errs, req.Tags)
+	gateway := validateNetworkSettings(&errs, req.CIDR, req.Tags, req.Gateway, req.DNSServers, req.Domain)
+	if req.ReuseDelay < 0 || req.ReuseDelay > maxHoldSeconds {
+		errs.add("reuse_delay", "must be between 0 and %d seconds", maxHoldSeconds)
+	}
+	validateDHCPOptions(&errs, "dhcp", req.DHCP)
+	tags := store.PoolTags(store.DHCPTags(store.SettingsTags(req.Tags, req.DNSServers, req.Domain), req.DHCP), req.Pools)
+	if req.ReuseDelay > 0 {
+		tags = append(slices.DeleteFunc(tags, func(tag string) bool { return strings.HasPrefix(tag, store.ReuseDelayTagPrefix) }),
+			store.ReuseDelayTag(time.Duration(req.ReuseDelay)*time.Second))
+	}
+	if len(errs) == 0 && len(tags) > maxTags {
+		errs.add("tags", "at most %d tags are allowed, dns_servers, domain, reuse_delay, dhcp and pools included", maxTags)
+	}
+	if len(errs) == 0 {
+		validatePools(&errs, "pools", req.CIDR, tags)
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
 		return
 	}
 
+	var network *ipam.Network
+	var err error
+	if gateway == "" {
+		if network, err = s.ipam.AddNetwork(req.CIDR, req.Description, tags); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	} else {
+		var alloc *ipam.IPAllocation
+		if network, alloc, err = s.addNetworkWithGateway(req.CIDR, req.Description, tags, gateway); err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err)
+			return
+		}
+		s.recordAudit(r, "ip_allocated", alloc.ID, fmt.Sprintf("Reserved gateway %s", alloc.IP))
+	}
+	s.invalidateNetworks()
+
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(network)
+	json.NewEncoder(w).Encode(&networkResponse{Network: network, Settings: s.networkSettings(network.ID)})
+}
+
+// addNetworkWithGateway creates a network and the allocation reserving its
+// gateway in one atomic write, staging them again if other writes changed
+// the networks meanwhile
+func (s *Server) addNetworkWithGateway(cidr, description string, tags []string, gateway string) (*ipam.Network, *ipam.IPAllocation, error) {
+	applier, ok := s.store.(batchApplier)
+	if !ok {
+		return nil, nil, errors.New("store does not support reserving a gateway with the network")
+	}
+
+	var network *ipam.Network
+	var alloc *ipam.IPAllocation
+	var err error
+	for attempt := 0; attempt < transactionAttempts; attempt++ {
+		staging := store.NewStaging(s.store)
+		if network, err = ipam.New(staging).AddNetwork(cidr, description, tags); err != nil {
+			return nil, nil, err
+		}
+		var id string
+		if id, err = store.NewAllocationID(); err != nil {
+			return nil, nil, err
+		}
+		alloc = &ipam.IPAllocation{
+			ID:          id,
+			NetworkID:   network.ID,
+			IP:          gateway,
+			Description: "Gateway",
+			Tags:        []string{store.GatewayTag},
+			Status:      "allocated",
+			AllocatedAt: s.clock.Now(),
+		}
+		if err = staging.SaveAllocation(alloc); err != nil {
+			return nil, nil, err
+		}
+		if err = applier.ApplyBatch(staging.Batch()); !errors.Is(err, store.ErrBatchConflict) {
+			break
+		}
+	}
+	return network, alloc, err
 }
 
 func (s *Server) getNetwork(w http.ResponseWriter, r *http.Request) {
@@ -117,11 +495,11 @@ func (s *Server) getNetwork(w http.ResponseWriter, r *http.Request) {
 
 	network, err := s.store.GetNetwork(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, http.StatusNotFound, err)
 		return
 	}
 
-	json.NewEncoder(w).Encode(network)
+	json.NewEncoder(w).Encode(&networkResponse{Network: network, Settings: s.networkSettings(network.ID)})
 }
 
 func (s *Server) deleteNetwork(w http.ResponseWriter, r *http.Request) {
@@ -131,7 +509,7 @@ func (s *Server) deleteNetwork(w http.ResponseWriter, r *http.Request) {
 	// Check for active allocations
 	allocations, err := s.store.ListAllocations(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -143,14 +521,15 @@ func (s *Server) deleteNetwork(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if activeCount > 0 {
-		http.Error(w, "Network has active allocations", http.StatusConflict)
+		writeErrorCode(w, http.StatusConflict, CodeNetworkInUse, "Network has active allocations", map[string]int{"active_allocations": activeCount})
 		return
 	}
 
 	if err := s.store.DeleteNetwork(id); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	s.invalidateNetworks()
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -161,11 +540,112 @@ func (s *Server) getNetworkStats(w http.ResponseWriter, r *http.Request) {
 
 	stats, err := s.ipam.GetNetworkStats(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	network, err := s.store.GetNetwork(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	allocations, err := s.store.ListAllocations(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	breakdown, err := store.BreakDownAddresses(network, allocations, s.clock.Now())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	pools, err := store.CountPools(network, allocations, s.clock.Now())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	json.NewEncoder(w).Encode(stats)
+	json.NewEncoder(w).Encode(&NetworkStats{NetworkStats: stats, ExpiredIPs: breakdown.Expired, Breakdown: breakdown, Pools: pools})
+}
+
+// NetworkStats are the engine's statistics of a network with every address
+// accounted for by kind
+type NetworkStats struct {
+	*ipam.NetworkStats
+
+	// ExpiredIPs counts the addresses of expired allocations not released
+	// yet. The engine counts them in AllocatedIPs, though they can be
+	// reclaimed.
+	ExpiredIPs uint64 `json:"expired_ips"`
+
+	Breakdown *store.AddressBreakdown `json:"breakdown"`
+
+	// Pools break the network's pools down, see store.PoolTagPrefix
+	Pools []store.PoolStats `json:"pools,omitempty"`
+}
+
+// AllocationCounts breaks down a network's allocation records by status
+type AllocationCounts struct {
+	NetworkID string `json:"network_id"`
+	Status    string `json:"status"`
+	Count     int    `json:"count"`
+	Active    int    `json:"active"`
+	Expired   int    `json:"expired"`
+	Released  int    `json:"released"`
+	Total     int    `json:"total"`
+}
+
+func (s *Server) countAllocations(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "all"
+	}
+	switch status {
+	case "all", "active", "expired", "released":
+	default:
+		var errs fieldErrors
+		errs.add("status", "must be one of all, active, expired, released")
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	if _, err := s.store.GetNetwork(id); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	allocations, err := s.store.ListAllocations(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	counts := &AllocationCounts{NetworkID: id, Status: status, Total: len(allocations)}
+	now := s.clock.Now()
+	for _, alloc := range allocations {
+		switch store.AllocationStatus(alloc, now) {
+		case store.StatusReleased:
+			counts.Released++
+		case store.StatusExpired:
+			counts.Expired++
+		default:
+			counts.Active++
+		}
+	}
+
+	switch status {
+	case "active":
+		counts.Count = counts.Active
+	case "expired":
+		counts.Count = counts.Expired
+	case "released":
+		counts.Count = counts.Released
+	default:
+		counts.Count = counts.Total
+	}
+
+	json.NewEncoder(w).Encode(counts)
 }
 
 // Allocation handlers
@@ -173,13 +653,95 @@ func (s *Server) listAllocations(w http.ResponseWriter, r *http.Request) {
 	networkID := r.URL.Query().Get("network_id")
 	showAll := r.URL.Query().Get("all") == "true"
 
+	query, errs := parseAllocationQuery(r)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	allAllocations, err := s.collectAllocations(r, networkID, showAll || query.filter.Status != "")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSONWithETag(w, r, query.apply(allAllocations, s.clock.Now()))
+}
+
+// allocationQuery holds the filtering and ordering parameters shared by the
+// allocation list endpoints
+type allocationQuery struct {
+	filter   store.AllocationFilter
+	selector *store.Selector
+	sort     string
+	desc     bool
+}
+
+// parseAllocationQuery reads the status, tag, country, asn,
+// expiring_within, selector, sort and order query parameters
+func parseAllocationQuery(r *http.Request) (*allocationQuery, fieldErrors) {
+	q := r.URL.Query()
+	query := &allocationQuery{
+		filter: store.AllocationFilter{Status: q.Get("status"), Tags: q["tag"]},
+		sort:   q.Get("sort"),
+	}
+
+	var errs fieldErrors
+	if err := query.filter.Validate(); err != nil {
+		errs.add("status", "%v", err)
+	}
+	query.filter.Tags = append(query.filter.Tags, geoTags(q, &errs)...)
+
+	if within := q.Get("expiring_within"); within != "" {
+		d, err := time.ParseDuration(within)
+		if err != nil || d <= 0 {
+			errs.add("expiring_within", "must be a positive duration such as 24h")
+		}
+		query.filter.ExpiringWithin = d
+	}
+
+	selector, err := store.ParseSelector(q.Get("selector"))
+	if err != nil {
+		errs.add("selector", "%v", err)
+	}
+	query.selector = selector
+
+	if query.sort != "" && !store.ValidAllocationSortField(query.sort) {
+		errs.add("sort", "must be one of %s", strings.Join(store.AllocationSortFields, ", "))
+	}
+
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.desc = true
+	default:
+		errs.add("order", "must be asc or desc")
+	}
+
+	return query, errs
+}
+
+// apply filters and orders allocations according to the query, judging
+// expiry at now
+func (q *allocationQuery) apply(allocations []*ipam.IPAllocation, now time.Time) []*ipam.IPAllocation {
+	allocations = store.FilterAllocations(allocations, q.filter, now)
+	allocations = selectAllocations(allocations, q.selector)
+	if q.sort != "" {
+		store.SortAllocations(allocations, q.sort, q.desc)
+	}
+	return allocations
+}
+
+// collectAllocations returns the allocations of one network, or of every
+// network when networkID is empty, skipping released ones unless showAll
+// and those of networks the caller of r may not view
+func (s *Server) collectAllocations(r *http.Request, networkID string, showAll bool) ([]*ipam.IPAllocation, error) {
 	var allAllocations []*ipam.IPAllocation
 
 	if networkID != "" {
 		allocations, err := s.store.ListAllocations(networkID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return nil, err
 		}
 
 		for _, alloc := range allocations {
@@ -189,18 +751,12 @@ func (s *Server) listAllocations(w http.ResponseWriter, r *http.Request) {
 			allAllocations = append(allAllocations, alloc)
 		}
 	} else {
-		networks, err := s.store.ListNetworks()
+		networks, err := s.cachedNetworks()
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return nil, err
 		}
 
-		for _, network := range networks {
-			allocations, err := s.store.ListAllocations(network.ID)
-			if err != nil {
-				continue
-			}
-
+		for _, allocations := range s.listAllocationsOf(networks) {
 			for _, alloc := range allocations {
 				if !showAll && alloc.ReleasedAt != nil {
 					continue
@@ -210,29 +766,72 @@ func (s *Server) listAllocations(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	json.NewEncoder(w).Encode(allAllocations)
+	return s.visibleAllocations(r, allAllocations), nil
+}
+
+// selectAllocations narrows allocations to those whose tags match selector
+func selectAllocations(allocations []*ipam.IPAllocation, selector *store.Selector) []*ipam.IPAllocation {
+	if selector.Empty() {
+		return allocations
+	}
+
+	tagSets := make([][]string, len(allocations))
+	for i, alloc := range allocations {
+		tagSets[i] = alloc.Tags
+	}
+
+	selected := make([]*ipam.IPAllocation, 0)
+	for _, i := range store.NewLabelIndex(tagSets).Select(selector) {
+		selected = append(selected, allocations[i])
+	}
+	return selected
 }
 
 func (s *Server) allocateIP(w http.ResponseWriter, r *http.Request) {
-	var req ipam.AllocationRequest
+	var req allocationRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeDecodeError(w, err)
 		return
 	}
 
-	allocation, err := s.ipam.AllocateIP(&req)
+	errs := validateAllocationRequest(&req.AllocationRequest)
+	s.checkTags(&errs, req.Tags)
+	validateHoldToken(&errs, &req)
+	validateAllocationDHCP(&errs, &req)
+	validatePool(&errs, &req)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	if err := s.autoCreateNetwork(r, &req.AllocationRequest); err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	if errs := s.generateHostname(&req); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	if s.rejectDenied(w, r, req.NetworkID, req.CIDR, store.PermissionAllocate) || s.rejectFrozen(w, req.NetworkID, req.CIDR) {
+		return
+	}
+	if s.rejectUnapproved(w, r, &req.AllocationRequest) {
+		return
+	}
+
+	allocation, err := s.hooks.Allocate(r.Context(), s.store, &req.AllocationRequest, s.allocator(r, &req))
 	if err != nil {
-		if err == ipam.ErrIPNotAvailable || err == ipam.ErrNetworkFull {
-			http.Error(w, err.Error(), http.StatusConflict)
-		} else {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		status := errorStatus(err, http.StatusBadRequest)
+		if status == http.StatusNotFound {
+			// v1 has always reported an unknown network as a bad request
+			status = http.StatusBadRequest
 		}
+		writeError(w, status, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(allocation)
+	json.NewEncoder(w).Encode(&allocationResponse{IPAllocation: allocation, Settings: s.allocationSettings(allocation)})
 }
 
 func (s *Server) getAllocation(w http.ResponseWriter, r *http.Request) {
@@ -241,11 +840,11 @@ func (s *Server) getAllocation(w http.ResponseWriter, r *http.Request) {
 
 	allocation, err := s.store.GetAllocation(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, http.StatusNotFound, err)
 		return
 	}
 
-	json.NewEncoder(w).Encode(allocation)
+	json.NewEncoder(w).Encode(&allocationResponse{IPAllocation: allocation, Settings: s.allocationSettings(allocation)})
 }
 
 func (s *Server) releaseIP(w http.ResponseWriter, r *http.Request) {
@@ -254,18 +853,50 @@ func (s *Server) releaseIP(w http.ResponseWriter, r *http.Request) {
 
 	allocation, err := s.store.GetAllocation(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	if err := store.CheckReleasable(s.store, allocation); err != nil {
+		writeErrorCode(w, http.StatusConflict, CodeAlreadyReleased, err.Error(), map[string]interface{}{
+			"released_at": allocation.ReleasedAt,
+			"released_by": store.ReleasedBy(s.auditLog(), allocation),
+		})
+		return
+	}
+	if s.rejectFrozen(w, allocation.NetworkID, "") {
 		return
 	}
 
-	if err := s.ipam.ReleaseIP(allocation.NetworkID, allocation.IP); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := s.releaseQuarantined(r, allocation); err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
 		return
 	}
+	s.recordAudit(r, "ip_released", allocation.ID, fmt.Sprintf("Released %s", allocation.IP))
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// releaseQuarantined releases alloc, running the lifecycle hooks around it,
+// and holds its addresses for the reuse delay of its network, if it has
+// one, in the same write
+func (s *Server) releaseQuarantined(r *http.Request, alloc *ipam.IPAllocation) error {
+	var hold *ipam.IPAllocation
+	err := s.hooks.Release(r.Context(), s.store, alloc, func() error {
+		var err error
+		hold, err = store.ReleaseQuarantined(s.store, alloc, s.clock.Now(), func() error {
+			return s.ipam.ReleaseIP(alloc.NetworkID, alloc.IP)
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if hold != nil {
+		s.recordAudit(r, "ip_held", hold.ID, fmt.Sprintf("Held %s for the reuse delay until %s", hold.IP, hold.ExpiresAt.UTC().Format(time.RFC3339)))
+	}
+	return nil
+}
+
 // Audit handlers
 func (s *Server) listAuditEntries(w http.ResponseWriter, r *http.Request) {
 	limitStr := r.URL.Query().Get("limit")
@@ -274,14 +905,14 @@ func (s *Server) listAuditEntries(w http.ResponseWriter, r *http.Request) {
 		var err error
 		limit, err = strconv.Atoi(limitStr)
 		if err != nil {
-			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			writeErrorCode(w, http.StatusBadRequest, CodeBadRequest, "Invalid limit parameter", nil)
 			return
 		}
 	}
 
-	entries, err := s.store.ListAuditEntries(limit)
+	entries, err := s.listVisibleAuditEntries(r, limit)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -295,6 +926,14 @@ func (s *Server) healthCheck(w http.ResponseWriter, r *http.Request) {
 		"service":      "ipam",
 		"cluster_mode": s.raftStore != nil,
 	}
+	if s.replica != nil {
+		response["replica"] = s.replicaHealth()
+		if s.replica.Stale(s.clock.Now()) {
+			// Still serving reads, but load balancers should prefer another
+			response["status"] = "stale"
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}
 	json.NewEncoder(w).Encode(response)
 }
 
@@ -302,22 +941,46 @@ func (s *Server) healthCheck(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) clusterStatus(w http.ResponseWriter, r *http.Request) {
 	if s.raftStore == nil {
-		http.Error(w, "Not in cluster mode", http.StatusBadRequest)
+		writeErrorCode(w, http.StatusBadRequest, CodeClusterModeRequired, "Not in cluster mode", nil)
 		return
 	}
 
 	info, err := s.raftStore.GetClusterInfo()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	storage, err := s.raftStore.Storage()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	json.NewEncoder(w).Encode(info)
+	response := clusterStatusResponse{ClusterInfo: info, Storage: storage}
+	if s.members != nil {
+		response.Members = s.members()
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// clusterStatusResponse adds the nodes known through gossip, with their API
+// addresses and liveness, and this node's disk usage to the Raft view of the
+// cluster
+type clusterStatusResponse struct {
+	*store.ClusterInfo
+	Members []gossip.Member    `json:"members,omitempty"`
+	Storage *store.StorageInfo `json:"storage"`
+}
+
+// SetGossip lists the nodes known through g in the cluster status
+func (s *Server) SetGossip(g *gossip.Gossip) {
+	s.members = g.Members
 }
 
 func (s *Server) addNode(w http.ResponseWriter, r *http.Request) {
 	if s.raftStore == nil {
-		http.Error(w, "Not in cluster mode", http.StatusBadRequest)
+		writeErrorCode(w, http.StatusBadRequest, CodeClusterModeRequired, "Not in cluster mode", nil)
 		return
 	}
 
@@ -327,26 +990,71 @@ func (s *Server) addNode(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeDecodeError(w, err)
 		return
 	}
 
 	if req.NodeID == 0 || req.Addr == "" {
-		http.Error(w, "node_id and addr are required", http.StatusBadRequest)
+		writeErrorCode(w, http.StatusBadRequest, CodeBadRequest, "node_id and addr are required", nil)
 		return
 	}
 
 	if err := s.raftStore.AddNode(req.NodeID, req.Addr); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	s.recordAuditAs(r, s.principal(r), "add-node", "cluster", fmt.Sprintf("Added node %d at %s", req.NodeID, req.Addr))
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// compactResponse reports this node's disk usage around a compaction
+type compactResponse struct {
+	NodeID        uint64             `json:"node_id"`
+	Compacted     bool               `json:"compacted"`
+	SnapshotIndex uint64             `json:"snapshot_index,omitempty"`
+	Before        *store.StorageInfo `json:"before"`
+	After         *store.StorageInfo `json:"after"`
+}
+
+// compact snapshots this node and discards the Raft log the snapshot
+// covers. Compacting a node that has had no writes since its last snapshot
+// succeeds with compacted false.
+func (s *Server) compact(w http.ResponseWriter, r *http.Request) {
+	if s.raftStore == nil {
+		writeErrorCode(w, http.StatusBadRequest, CodeClusterModeRequired, "Not in cluster mode", nil)
+		return
+	}
+
+	before, err := s.raftStore.Storage()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := compactResponse{NodeID: before.NodeID, Before: before}
+	index, err := s.raftStore.Compact(30 * time.Second)
+	switch {
+	case err == nil:
+		response.Compacted = true
+		response.SnapshotIndex = index
+		s.recordAudit(r, "compact", "cluster", fmt.Sprintf("Compacted node %d's Raft log up to index %d", before.NodeID, index))
+	case errors.Is(err, store.ErrNothingToCompact):
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if response.After, err = s.raftStore.Storage(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
 func (s *Server) removeNode(w http.ResponseWriter, r *http.Request) {
 	if s.raftStore == nil {
-		http.Error(w, "Not in cluster mode", http.StatusBadRequest)
+		writeErrorCode(w, http.StatusBadRequest, CodeClusterModeRequired, "Not in cluster mode", nil)
 		return
 	}
 
@@ -355,14 +1063,15 @@ func (s *Server) removeNode(w http.ResponseWriter, r *http.Request) {
 
 	nodeID, err := strconv.ParseUint(nodeIDStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid node ID", http.StatusBadRequest)
+		writeErrorCode(w, http.StatusBadRequest, CodeBadRequest, "Invalid node ID", nil)
 		return
 	}
 
 	if err := s.raftStore.RemoveNode(nodeID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	s.recordAuditAs(r, s.principal(r), "remove-node", "cluster", fmt.Sprintf("Removed node %d", nodeID))
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -2,15 +2,34 @@ package api
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/jeremyhahn/go-ipam/pkg/approval"
+	"github.com/jeremyhahn/go-ipam/pkg/auditlog"
+	"github.com/jeremyhahn/go-ipam/pkg/clock"
+	"github.com/jeremyhahn/go-ipam/pkg/gossip"
+	"github.com/jeremyhahn/go-ipam/pkg/hooks"
 	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/notify"
+	"github.com/jeremyhahn/go-ipam/pkg/reconcile"
+	"github.com/jeremyhahn/go-ipam/pkg/replication"
+	"github.com/jeremyhahn/go-ipam/pkg/report"
+	"github.com/jeremyhahn/go-ipam/pkg/spiffe"
 	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/jeremyhahn/go-ipam/pkg/taxonomy"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -51,6 +70,154 @@ func TestHealthEndpoint(t *testing.T) {
 	assert.Equal(t, false, response["cluster_mode"])
 }
 
+// unreadyStore is a store that cannot serve requests
+type unreadyStore struct {
+	ipam.Store
+}
+
+func (unreadyStore) Ping() error { return fmt.Errorf("cluster 1 has no leader") }
+
+func TestProbes(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	for _, path := range []string{"/livez", "/readyz"} {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, http.StatusOK, w.Code, path)
+	}
+
+	// A store that cannot serve requests is live but not ready
+	unready := NewServer(server.ipam, unreadyStore{server.store})
+	w := httptest.NewRecorder()
+	unready.ServeHTTP(w, httptest.NewRequest("GET", "/livez", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	unready.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	var resp ErrorResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, CodeNotReady, resp.Code)
+	assert.Contains(t, resp.Message, "no leader")
+}
+
+func TestReplication(t *testing.T) {
+	primary, cleanup := createTestServer(t)
+	defer cleanup()
+	ts := httptest.NewServer(primary)
+	defer ts.Close()
+	_, err := primary.ipam.AddNetwork("10.0.0.0/24", "primary", nil)
+	require.NoError(t, err)
+
+	standby, cleanupStandby := createTestServer(t)
+	defer cleanupStandby()
+	post := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		standby.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		return w
+	}
+
+	// Not a replica
+	assert.Equal(t, http.StatusNotFound, post("/api/v1/replication/promote", "").Code)
+
+	replica := &replication.Replica{Primary: ts.URL, Store: standby.store, StateDir: t.TempDir()}
+	standby.SetReplica(replica)
+	_, err = replica.Sync(time.Now())
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	standby.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/networks", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "10.0.0.0/24")
+
+	// Writes go to the primary until the standby is promoted
+	w = post("/api/v1/networks", `{"cidr": "10.1.0.0/24"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	var resp ErrorResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, CodeStandby, resp.Code)
+	assert.Contains(t, resp.Message, ts.URL)
+
+	// Checks only read, though they are posted
+	w = post("/api/v1/check", `{"ips": ["10.0.0.1"]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"managed":true`)
+
+	w = httptest.NewRecorder()
+	standby.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/replication", nil))
+	var status replication.Status
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+	assert.True(t, status.Standby)
+	assert.Equal(t, 1, status.Networks)
+
+	w = post("/api/v1/replication/promote", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+	assert.False(t, status.Standby)
+	assert.NotNil(t, status.PromotedAt)
+
+	assert.Equal(t, http.StatusCreated, post("/api/v1/networks", `{"cidr": "10.1.0.0/24"}`).Code)
+}
+
+func TestReadOnlyReplica(t *testing.T) {
+	primary, cleanup := createTestServer(t)
+	defer cleanup()
+	ts := httptest.NewServer(primary)
+	defer ts.Close()
+	_, err := primary.ipam.AddNetwork("10.0.0.0/24", "primary", nil)
+	require.NoError(t, err)
+
+	// The snapshot is answered with 304 until the data changes
+	w := httptest.NewRecorder()
+	primary.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/snapshot", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"exported_at"`)
+	req := httptest.NewRequest("GET", "/api/v1/snapshot", nil)
+	req.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	primary.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	standby, cleanupStandby := createTestServer(t)
+	defer cleanupStandby()
+	replica := &replication.Replica{Primary: ts.URL, Store: standby.store, ReadOnly: true, MaxAge: 30 * time.Minute}
+	standby.SetReplica(replica)
+	health := func() (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		standby.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/health", nil))
+		var response map[string]interface{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		return w.Code, response
+	}
+
+	// Stale until the first sync
+	code, response := health()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "stale", response["status"])
+
+	_, err = replica.Sync(time.Now().Add(-10 * time.Minute))
+	require.NoError(t, err)
+	code, response = health()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "healthy", response["status"])
+	info := response["replica"].(map[string]interface{})
+	assert.Equal(t, ts.URL, info["primary"])
+	assert.Equal(t, true, info["read_only"])
+	assert.InDelta(t, 600, info["snapshot_age_seconds"], 5)
+
+	_, err = replica.Sync(time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	code, response = health()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "stale", response["status"])
+
+	w = httptest.NewRecorder()
+	standby.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/replication/promote", nil))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.True(t, replica.Standby())
+}
+
 func TestNetworkEndpoints(t *testing.T) {
 	server, cleanup := createTestServer(t)
 	defer cleanup()
@@ -362,7 +529,7 @@ func TestErrorHandling(t *testing.T) {
 	w := httptest.NewRecorder()
 	server.ServeHTTP(w, req)
 
-	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
 
 	// Test allocation from non-existent network
 	allocationData := map[string]interface{}{
@@ -466,3 +633,2759 @@ func TestConcurrentRequests(t *testing.T) {
 		allocatedIPs[ip] = true
 	}
 }
+
+func TestListETags(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	networkData := map[string]interface{}{
+		"cidr": "10.4.0.0/24",
+	}
+	body, _ := json.Marshal(networkData)
+
+	req := httptest.NewRequest("POST", "/api/v1/networks", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var network ipam.Network
+	json.NewDecoder(w.Body).Decode(&network)
+
+	for _, path := range []string{"/api/v1/networks", "/api/v1/allocations"} {
+		// First request returns the body and an ETag
+		req = httptest.NewRequest("GET", path, nil)
+		w = httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		etag := w.Header().Get("ETag")
+		require.NotEmpty(t, etag, path)
+
+		// Unchanged data yields 304 with no body
+		req = httptest.NewRequest("GET", path, nil)
+		req.Header.Set("If-None-Match", etag)
+		w = httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotModified, w.Code, path)
+		assert.Empty(t, w.Body.String())
+		assert.Equal(t, etag, w.Header().Get("ETag"))
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/allocations", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	etag := w.Header().Get("ETag")
+
+	// A mutation changes the ETag
+	allocationData := map[string]interface{}{
+		"network_id": network.ID,
+	}
+	body, _ = json.Marshal(allocationData)
+
+	req = httptest.NewRequest("POST", "/api/v1/allocations", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	req = httptest.NewRequest("GET", "/api/v1/allocations", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+}
+
+func TestErrorResponses(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	decodeError := func(w *httptest.ResponseRecorder) ErrorResponse {
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		var resp ErrorResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		assert.NotEmpty(t, resp.Message)
+		return resp
+	}
+
+	// Unknown network
+	req := httptest.NewRequest("GET", "/api/v1/networks/missing", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, CodeNetworkNotFound, decodeError(w).Code)
+
+	// Unknown allocation
+	req = httptest.NewRequest("GET", "/api/v1/allocations/missing", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, CodeAllocationNotFound, decodeError(w).Code)
+
+	// Malformed body
+	req = httptest.NewRequest("POST", "/api/v1/networks", bytes.NewReader([]byte("{")))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, CodeInvalidJSON, decodeError(w).Code)
+
+	// Exhausted network
+	body, _ := json.Marshal(map[string]interface{}{"cidr": "10.5.0.0/30"})
+	req = httptest.NewRequest("POST", "/api/v1/networks", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	var network ipam.Network
+	json.NewDecoder(w.Body).Decode(&network)
+
+	body, _ = json.Marshal(map[string]interface{}{"network_id": network.ID, "count": 2})
+	req = httptest.NewRequest("POST", "/api/v1/allocations", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	body, _ = json.Marshal(map[string]interface{}{"network_id": network.ID})
+	req = httptest.NewRequest("POST", "/api/v1/allocations", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, CodeNetworkFull, decodeError(w).Code)
+
+	// Network still in use
+	req = httptest.NewRequest("DELETE", fmt.Sprintf("/api/v1/networks/%s", network.ID), nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	resp := decodeError(w)
+	assert.Equal(t, CodeNetworkInUse, resp.Code)
+	assert.Equal(t, map[string]interface{}{"active_allocations": float64(1)}, resp.Details)
+}
+
+func TestValidationErrors(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	fieldsOf := func(w *httptest.ResponseRecorder) []string {
+		var resp struct {
+			Code    string       `json:"code"`
+			Details []FieldError `json:"details"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		assert.Equal(t, CodeValidationFailed, resp.Code)
+
+		var fields []string
+		for _, d := range resp.Details {
+			fields = append(fields, d.Field)
+		}
+		return fields
+	}
+
+	// Network with bad CIDR and tags
+	body, _ := json.Marshal(map[string]interface{}{
+		"cidr": "10.0.0.0/33",
+		"tags": []string{"ok", "bad tag"},
+	})
+	req := httptest.NewRequest("POST", "/api/v1/networks", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, []string{"cidr", "tags[1]"}, fieldsOf(w))
+
+	// Allocation with every field wrong
+	body, _ = json.Marshal(map[string]interface{}{
+		"cidr":     "nope",
+		"count":    -1,
+		"ttl":      -5,
+		"hostname": "-bad-.example.com",
+	})
+	req = httptest.NewRequest("POST", "/api/v1/allocations", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, []string{"cidr", "count", "ttl", "hostname"}, fieldsOf(w))
+
+	// Missing target network
+	body, _ = json.Marshal(map[string]interface{}{"hostname": "web-01"})
+	req = httptest.NewRequest("POST", "/api/v1/allocations", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, []string{"network_id"}, fieldsOf(w))
+}
+
+func TestValidateHostname(t *testing.T) {
+	valid := []string{"web-01", "db1.example.com", "a", "xn--bcher-kva.example"}
+	for _, h := range valid {
+		assert.NoError(t, validateHostname(h), h)
+	}
+
+	invalid := []string{"-web", "web-", "web_01", "a..b", "host.", strings.Repeat("a", 64)}
+	for _, h := range invalid {
+		assert.Error(t, validateHostname(h), h)
+	}
+}
+
+func TestListNetworksFilters(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	for _, n := range []map[string]interface{}{
+		{"cidr": "10.42.0.0/16", "description": "Datacenter", "tags": []string{"prod"}},
+		{"cidr": "10.42.3.0/24", "description": "Web tier", "tags": []string{"prod", "web"}},
+		{"cidr": "172.16.0.0/24", "description": "Lab"},
+		{"cidr": "81.2.69.0/24", "description": "Transit", "tags": []string{"geo-country=GB", "geo-asn=20712"}},
+	} {
+		w := doRequest(t, server, "POST", "/api/v1/networks", n)
+		require.Equal(t, http.StatusCreated, w.Code)
+	}
+
+	cidrs := func(query string) []string {
+		w := doRequest(t, server, "GET", "/api/v1/networks?"+query, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		var out []string
+		for _, n := range decodeArray(t, w) {
+			out = append(out, n["cidr"].(string))
+		}
+		return out
+	}
+
+	assert.ElementsMatch(t, []string{"10.42.0.0/16", "10.42.3.0/24"}, cidrs("contains_ip=10.42.3.7"))
+	assert.Equal(t, []string{"10.42.3.0/24"}, cidrs("tag=prod&tag=web"))
+	assert.Equal(t, []string{"172.16.0.0/24"}, cidrs("cidr=172.16.0.0/24"))
+	assert.Equal(t, []string{"172.16.0.0/24"}, cidrs("q=lab"))
+	assert.Equal(t, []string{"81.2.69.0/24"}, cidrs("country=gb&asn=AS20712"))
+	assert.Empty(t, cidrs("country=DE"))
+	assert.Len(t, cidrs(""), 4)
+
+	w := doRequest(t, server, "GET", "/api/v1/networks?contains_ip=nope", nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	w = doRequest(t, server, "GET", "/api/v1/networks?country=Germany", nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestCountAllocations(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.6.0.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	networkID := decodeObject(t, w)["id"].(string)
+
+	var allocationIDs []string
+	for i := 0; i < 3; i++ {
+		w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID})
+		require.Equal(t, http.StatusCreated, w.Code)
+		allocationIDs = append(allocationIDs, decodeObject(t, w)["id"].(string))
+	}
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations/"+allocationIDs[0]+"/release", nil)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	w = doRequest(t, server, "GET", "/api/v1/networks/"+networkID+"/allocations/count?status=active", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var counts AllocationCounts
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&counts))
+	assert.Equal(t, 2, counts.Count)
+	assert.Equal(t, 2, counts.Active)
+	assert.Equal(t, 1, counts.Released)
+	assert.Equal(t, 3, counts.Total)
+
+	w = doRequest(t, server, "GET", "/api/v1/networks/"+networkID+"/allocations/count", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&counts))
+	assert.Equal(t, "all", counts.Status)
+	assert.Equal(t, 3, counts.Count)
+
+	w = doRequest(t, server, "GET", "/api/v1/networks/"+networkID+"/allocations/count?status=bogus", nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = doRequest(t, server, "GET", "/api/v1/networks/missing/allocations/count", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestPTRZone(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.42.3.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	networkID := decodeObject(t, w)["id"].(string)
+
+	for _, alloc := range []map[string]interface{}{
+		{"network_id": networkID, "hostname": "gw"},
+		{"network_id": networkID},
+		{"network_id": networkID, "hostname": "web.example.com", "count": 2},
+	} {
+		w = doRequest(t, server, "POST", "/api/v1/allocations", alloc)
+		require.Equal(t, http.StatusCreated, w.Code)
+	}
+
+	w = doRequest(t, server, "GET", "/api/v1/networks/"+networkID+"/ptr-zone?domain=example.com&ttl=600", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/dns; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "$ORIGIN 3.42.10.in-addr.arpa.\n"+
+		"$TTL 600\n"+
+		"1\tIN\tPTR\tgw.example.com.\n"+
+		"3\tIN\tPTR\tweb.example.com.\n"+
+		"4\tIN\tPTR\tweb.example.com.\n", w.Body.String())
+
+	w = doRequest(t, server, "GET", "/api/v1/networks/missing/ptr-zone", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestPTRZoneClassless(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "192.0.2.64/30"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	networkID := decodeObject(t, w)["id"].(string)
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "hostname": "gw.example.com"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	ip := decodeObject(t, w)["ip"].(string)
+	octet := ip[strings.LastIndex(ip, ".")+1:]
+
+	// The network's own zone
+	w = doRequest(t, server, "GET", "/api/v1/networks/"+networkID+"/ptr-zone?ttl=600", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "$ORIGIN 64/30.2.0.192.in-addr.arpa.\n"+
+		"$TTL 600\n"+
+		octet+"\tIN\tPTR\tgw.example.com.\n", w.Body.String())
+
+	w = doRequest(t, server, "GET", "/api/v1/networks/"+networkID+"/ptr-zone?classless_style=range", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "$ORIGIN 64-67.2.0.192.in-addr.arpa.\n")
+
+	// The delegation for the /24 zone
+	w = doRequest(t, server, "GET", "/api/v1/networks/"+networkID+"/ptr-zone?delegation=parent&ns=ns1.example.com&ttl=600", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "$ORIGIN 2.0.192.in-addr.arpa.\n"+
+		"$TTL 600\n"+
+		"64/30\tIN\tNS\tns1.example.com.\n"+
+		"64\tIN\tCNAME\t64.64/30\n"+
+		"65\tIN\tCNAME\t65.64/30\n"+
+		"66\tIN\tCNAME\t66.64/30\n"+
+		"67\tIN\tCNAME\t67.64/30\n", w.Body.String())
+
+	// PTR records in the /24 zone, as before
+	w = doRequest(t, server, "GET", "/api/v1/networks/"+networkID+"/ptr-zone?delegation=none", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "$ORIGIN 2.0.192.in-addr.arpa.\n"+"$TTL 3600\n"+octet+"\tIN\tPTR\tgw.example.com.\n")
+
+	w = doRequest(t, server, "GET", "/api/v1/networks/"+networkID+"/ptr-zone?delegation=sideways&classless_style=dots", nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "delegation")
+	assert.Contains(t, w.Body.String(), "classless_style")
+
+	w = doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "198.51.100.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	w = doRequest(t, server, "GET", "/api/v1/networks/"+decodeObject(t, w)["id"].(string)+"/ptr-zone?delegation=parent", nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "longer than /24")
+}
+
+func TestCloudInit(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{
+		"cidr": "10.42.4.0/24",
+		"tags": []string{"dns=10.42.4.53", "domain=example.com"},
+	})
+	require.Equal(t, http.StatusCreated, w.Code)
+	networkID := decodeObject(t, w)["id"].(string)
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "tags": []string{"gateway"}})
+	require.Equal(t, http.StatusCreated, w.Code)
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "hostname": "web01"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	allocID := decodeObject(t, w)["id"].(string)
+
+	w = doRequest(t, server, "GET", "/api/v1/allocations/"+allocID+"/cloud-init?interface=ens3", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/yaml; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "network:\n"+
+		"  version: 2\n"+
+		"  ethernets:\n"+
+		"    ens3:\n"+
+		"      dhcp4: false\n"+
+		"      addresses:\n"+
+		"        - 10.42.4.2/24\n"+
+		"      routes:\n"+
+		"        - to: default\n"+
+		"          via: 10.42.4.1\n"+
+		"      nameservers:\n"+
+		"        addresses:\n"+
+		"          - 10.42.4.53\n"+
+		"        search:\n"+
+		"          - example.com\n", w.Body.String())
+
+	w = doRequest(t, server, "GET", "/api/v2/allocations/"+allocID+"/cloud-init?format=cloud-config", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/cloud-config; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "fqdn: web01.example.com\n")
+
+	w = doRequest(t, server, "GET", "/api/v1/allocations/"+allocID+"/cloud-init?format=ifupdown&interface=eth0%20up", nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"field":"format"`)
+	assert.Contains(t, w.Body.String(), `"field":"interface"`)
+
+	w = doRequest(t, server, "GET", "/api/v1/allocations/missing/cloud-init", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations/"+allocID+"/release", nil)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	w = doRequest(t, server, "GET", "/api/v1/allocations/"+allocID+"/cloud-init", nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestNetworkSettings(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{
+		"cidr":        "10.42.5.0/24",
+		"tags":        []string{"env=lab", "dns=192.0.2.1"},
+		"gateway":     "10.42.5.1",
+		"dns_servers": []string{"10.42.5.53", "10.42.5.54"},
+		"domain":      "lab.example.com",
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	network := decodeObject(t, w)
+	networkID := network["id"].(string)
+	assert.Equal(t, "10.42.5.1", network["gateway"])
+	assert.Equal(t, []interface{}{"10.42.5.53", "10.42.5.54"}, network["dns_servers"])
+	assert.Equal(t, "lab.example.com", network["domain"])
+	assert.Equal(t, []interface{}{"env=lab", "dns=10.42.5.53", "dns=10.42.5.54", "domain=lab.example.com"}, network["tags"])
+
+	// The gateway is reserved, so allocations skip it and carry the settings
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID})
+	require.Equal(t, http.StatusCreated, w.Code)
+	alloc := decodeObject(t, w)
+	assert.NotEqual(t, "10.42.5.1", alloc["ip"])
+	assert.Equal(t, "10.42.5.1", alloc["gateway"])
+	assert.Equal(t, "lab.example.com", alloc["domain"])
+
+	w = doRequest(t, server, "GET", "/api/v2/allocations/"+alloc["id"].(string), nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []interface{}{"10.42.5.53", "10.42.5.54"}, decodeObject(t, w)["dns_servers"])
+	w = doRequest(t, server, "GET", "/api/v2/networks/"+networkID, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "10.42.5.1", decodeObject(t, w)["gateway"])
+
+	allocations, err := server.store.ListAllocations(networkID)
+	require.NoError(t, err)
+	var gateways []string
+	for _, a := range allocations {
+		if slices.Contains(a.Tags, store.GatewayTag) {
+			gateways = append(gateways, a.IP)
+		}
+	}
+	assert.Equal(t, []string{"10.42.5.1"}, gateways)
+
+	// Networks without settings return none
+	w = doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.42.6.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.NotContains(t, w.Body.String(), "gateway")
+
+	w = doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{
+		"cidr":        "10.42.7.0/24",
+		"gateway":     "10.42.8.1",
+		"dns_servers": []string{"ns1"},
+		"domain":      "bad domain",
+	})
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	for _, field := range []string{"gateway", "dns_servers[0]", "domain"} {
+		assert.Contains(t, w.Body.String(), `"field":"`+field+`"`)
+	}
+	w = doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.42.7.0/24", "gateway": "10.42.7.255"})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestDHCPOptions(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{
+		"cidr": "10.42.10.0/24",
+		"dhcp": map[string]interface{}{
+			"boot_file":   "pxelinux.0",
+			"next_server": "10.42.10.5",
+			"options":     map[string]string{"tftp-server-name": "10.42.10.5"},
+		},
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	network := decodeObject(t, w)
+	networkID := network["id"].(string)
+	assert.Equal(t, []interface{}{"boot-file=pxelinux.0", "next-server=10.42.10.5", "dhcp-option=tftp-server-name:10.42.10.5"}, network["tags"])
+	assert.Equal(t, "pxelinux.0", network["dhcp"].(map[string]interface{})["boot_file"])
+
+	// An allocation's own options override the network's one by one
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{
+		"network_id": networkID,
+		"dhcp":       map[string]interface{}{"boot_file": "ipxe/undionly.kpxe", "options": map[string]string{"66": "10.42.10.6"}},
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	alloc := decodeObject(t, w)
+	assert.Equal(t, []interface{}{"boot-file=ipxe/undionly.kpxe", "dhcp-option=66:10.42.10.6"}, alloc["tags"])
+	want := map[string]interface{}{
+		"boot_file":   "ipxe/undionly.kpxe",
+		"next_server": "10.42.10.5",
+		"options":     map[string]interface{}{"tftp-server-name": "10.42.10.5", "66": "10.42.10.6"},
+	}
+	assert.Equal(t, want, alloc["dhcp"])
+
+	w = doRequest(t, server, "GET", "/api/v2/allocations/"+alloc["id"].(string), nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, want, decodeObject(t, w)["dhcp"])
+
+	w = doRequest(t, server, "POST", "/api/v2/networks/"+networkID+"/allocations", map[string]interface{}{})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, "pxelinux.0", decodeObject(t, w)["dhcp"].(map[string]interface{})["boot_file"])
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{
+		"network_id": networkID,
+		"dhcp": map[string]interface{}{
+			"boot_file":   "boot file",
+			"next_server": "2001:db8::5",
+			"options":     map[string]string{"Bad Name": "x", "67": ""},
+		},
+	})
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	for _, field := range []string{"dhcp.boot_file", "dhcp.next_server", "dhcp.options", "dhcp.options.67"} {
+		assert.Contains(t, w.Body.String(), `"field":"`+field+`"`)
+	}
+}
+
+func TestPools(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{
+		"cidr": "10.42.11.0/24",
+		"pools": []map[string]string{
+			{"name": "static", "first": "10.42.11.10", "last": "10.42.11.99"},
+			{"name": "dhcp", "first": "10.42.11.100", "last": "10.42.11.200"},
+		},
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	networkID := decodeObject(t, w)["id"].(string)
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "pool": "dhcp", "hostname": "pxe1"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	alloc := decodeObject(t, w)
+	assert.Equal(t, "10.42.11.100", alloc["ip"])
+	assert.Equal(t, "pxe1", alloc["hostname"])
+	w = doRequest(t, server, "POST", "/api/v2/networks/"+networkID+"/allocations", map[string]interface{}{"pool": "dhcp", "ttl": 3600})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, "10.42.11.101", decodeObject(t, w)["ip"])
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "pool": "static"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, "10.42.11.10", decodeObject(t, w)["ip"])
+
+	w = doRequest(t, server, "POST", "/api/v2/networks/"+networkID+"/allocations", map[string]interface{}{"pool": "lb"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"pool_not_found"`)
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "pool": "dhcp", "count": 2})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = doRequest(t, server, "GET", "/api/v1/networks/"+networkID+"/stats", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var stats NetworkStats
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	require.Len(t, stats.Pools, 2)
+	assert.Equal(t, store.PoolStats{Pool: store.Pool{Name: "dhcp", First: "10.42.11.100", Last: "10.42.11.200"}, Total: 101, Allocated: 2, Available: 99}, stats.Pools[1])
+	assert.Equal(t, uint64(1), stats.Pools[0].Allocated)
+
+	// A PATCH replacing the tags keeps the pools unless it gives some
+	w = doRequest(t, server, "PATCH", "/api/v2/networks/"+networkID, map[string]interface{}{"tags": []string{"env=lab"}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, decodeObject(t, w)["tags"], "pool=dhcp:10.42.11.100-10.42.11.200")
+	w = doRequest(t, server, "PATCH", "/api/v2/networks/"+networkID, map[string]interface{}{"tags": []string{"pool=lb:10.42.12.1-10.42.12.9"}})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{
+		"cidr": "10.42.13.0/24",
+		"pools": []map[string]string{
+			{"name": "a", "first": "10.42.13.10", "last": "10.42.13.99"},
+			{"name": "b", "first": "10.42.13.50", "last": "10.42.13.60"},
+		},
+	})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "overlaps pool a")
+}
+
+func TestReuseDelay(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.42.9.0/24", "reuse_delay": 600})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	network := decodeObject(t, w)
+	networkID := network["id"].(string)
+	assert.Contains(t, network["tags"], "reuse-delay=10m0s")
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID})
+	require.Equal(t, http.StatusCreated, w.Code)
+	first := decodeObject(t, w)
+	w = doRequest(t, server, "POST", "/api/v1/allocations/"+first["id"].(string)+"/release", nil)
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+
+	// The released address is held, so the next allocation skips it
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID})
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.NotEqual(t, first["ip"], decodeObject(t, w)["ip"])
+
+	allocations, err := server.store.ListAllocations(networkID)
+	require.NoError(t, err)
+	var held []*ipam.IPAllocation
+	for _, alloc := range allocations {
+		if alloc.IP == first["ip"] && store.AllocationStatus(alloc, time.Now()) == store.StatusActive {
+			held = append(held, alloc)
+		}
+	}
+	require.Len(t, held, 1)
+	require.NotNil(t, held[0].ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), *held[0].ExpiresAt, time.Minute)
+	assert.True(t, strings.HasPrefix(held[0].Tags[0], store.HoldTagPrefix))
+
+	entries, err := server.store.ListAuditEntries(0)
+	require.NoError(t, err)
+	assert.True(t, slices.ContainsFunc(entries, func(e *ipam.AuditEntry) bool {
+		return e.Action == "ip_held" && e.Resource == held[0].ID
+	}))
+
+	w = doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.42.10.0/24", "reuse_delay": -1})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"field":"reuse_delay"`)
+}
+
+func TestReuseDelayWindow(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+	fake := clock.NewFake(time.Now())
+	server.SetClock(fake)
+
+	allocate := func(networkID string) map[string]interface{} {
+		w := doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		return decodeObject(t, w)
+	}
+	release := func(alloc map[string]interface{}) {
+		w := doRequest(t, server, "POST", "/api/v1/allocations/"+alloc["id"].(string)+"/release", nil)
+		require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	}
+	createNetwork := func(cidr string) (string, *ipam.Network) {
+		w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": cidr, "reuse_delay": 600})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		networkID := decodeObject(t, w)["id"].(string)
+		network, err := server.store.GetNetwork(networkID)
+		require.NoError(t, err)
+		return networkID, network
+	}
+
+	// Inside the window the released address is skipped
+	networkID, _ := createNetwork("10.42.14.0/24")
+	first := allocate(networkID)
+	release(first)
+	second := allocate(networkID)
+	assert.NotEqual(t, first["ip"], second["ip"])
+
+	// Once the delay has passed the same address is handed out again. The
+	// release happens an hour ago by the server's clock, so the hold has
+	// lapsed by the time the allocator looks at it.
+	fake.Set(time.Now().Add(-time.Hour))
+	networkID, network := createNetwork("10.42.15.0/24")
+	first = allocate(networkID)
+	release(first)
+
+	allocations, err := server.store.ListAllocations(networkID)
+	require.NoError(t, err)
+	_, err = store.CheckRequestedIP(network, allocations, first["ip"].(string), fake.Now())
+	assert.ErrorContains(t, err, "is on hold until")
+	_, err = store.CheckRequestedIP(network, allocations, first["ip"].(string), fake.Advance(11*time.Minute))
+	assert.NoError(t, err)
+
+	assert.Equal(t, first["ip"], allocate(networkID)["ip"])
+}
+
+func TestReconcileNetwork(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.42.4.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	networkID := decodeObject(t, w)["id"].(string)
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "count": 2})
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	// 10.42.4.1 and 10.42.4.2 are allocated; .1 and .50 are observed
+	w = doRequest(t, server, "POST", "/api/v1/networks/"+networkID+"/reconcile?format=json",
+		[]interface{}{"10.42.4.1", map[string]string{"private_ip": "10.42.4.50"}, "172.16.0.1"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	report := decodeObject(t, w)
+	assert.Equal(t, float64(2), report["observed"])
+	assert.Equal(t, float64(2), report["recorded"])
+	assert.Equal(t, float64(1), report["matched"])
+	assert.Equal(t, []interface{}{"10.42.4.50"}, report["unrecorded"])
+	unobserved := report["unobserved"].([]interface{})
+	require.Len(t, unobserved, 1)
+	assert.Equal(t, "10.42.4.2", unobserved[0].(map[string]interface{})["ip"])
+
+	req := httptest.NewRequest("POST", "/api/v1/networks/"+networkID+"/reconcile",
+		strings.NewReader("? (10.42.4.1) at 00:11:22:33:44:55 [ether] on eth0\n? (10.42.4.2) at 00:11:22:33:44:56 [ether] on eth0\n"))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	report = decodeObject(t, w)
+	assert.Equal(t, float64(2), report["matched"])
+	assert.Empty(t, report["unrecorded"])
+	assert.Empty(t, report["unobserved"])
+
+	// Importing a scan records unknown hosts, which stay reported until
+	// their discovered tag is removed
+	scan := `<nmaprun scanner="masscan"><host endtime="1760612400"><address addr="10.42.4.60" addrtype="ipv4"/></host>
+<host endtime="1760612400"><address addr="10.42.4.1" addrtype="ipv4"/></host></nmaprun>`
+	req = httptest.NewRequest("POST", "/api/v1/networks/"+networkID+"/reconcile?format=masscan&import=true", strings.NewReader(scan))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	report = decodeObject(t, w)
+	imported := report["import"].(map[string]interface{})
+	// 10.42.4.1 is part of a range, which imports leave alone
+	assert.Equal(t, float64(0), imported["seen"])
+	discovered := imported["discovered"].([]interface{})
+	require.Len(t, discovered, 1)
+	allocationID := discovered[0].(map[string]interface{})["allocation_id"].(string)
+	assert.Equal(t, float64(1), report["matched"])
+	assert.Empty(t, report["unrecorded"])
+	assert.Equal(t, "10.42.4.60", report["discovered"].([]interface{})[0].(map[string]interface{})["ip"])
+
+	w = doRequest(t, server, "GET", "/api/v1/allocations/"+allocationID, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, decodeObject(t, w)["tags"], reconcile.DiscoveredTag)
+
+	w = doRequest(t, server, "POST", "/api/v1/networks/"+networkID+"/reconcile?format=text&import=true", nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = doRequest(t, server, "POST", "/api/v1/networks/"+networkID+"/reconcile?format=csv", nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = doRequest(t, server, "POST", "/api/v1/networks/"+networkID+"/reconcile?format=json", map[string]string{})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = doRequest(t, server, "POST", "/api/v1/networks/missing/reconcile", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestIngestNeighbors(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.42.5.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	networkID := decodeObject(t, w)["id"].(string)
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "tags": []string{"mac=00:11:22:33:44:55"}})
+	require.Equal(t, http.StatusCreated, w.Code)
+	allocationID := decodeObject(t, w)["id"].(string)
+
+	req := httptest.NewRequest("POST", "/api/v1/neighbors",
+		strings.NewReader("Internet  10.42.5.1   -   0011.2233.4466  ARPA   Vlan5\nInternet  10.42.5.9   -   0011.2233.4477  ARPA   Vlan5\n"))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	result := decodeObject(t, w)
+	assert.Equal(t, float64(1), result["matched"])
+	conflicts := result["conflicts"].([]interface{})
+	require.Len(t, conflicts, 1)
+	assert.Equal(t, "00:11:22:33:44:55", conflicts[0].(map[string]interface{})["recorded"])
+	assert.Equal(t, "10.42.5.9", result["unknown"].([]interface{})[0].(map[string]interface{})["ip"])
+
+	w = doRequest(t, server, "GET", "/api/v1/allocations/"+allocationID, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	tags := decodeObject(t, w)["tags"]
+	assert.Contains(t, tags, "observed-mac=00:11:22:33:44:66")
+	assert.Contains(t, tags, "mac-conflict")
+
+	w = doRequest(t, server, "GET", "/api/v1/audit", nil)
+	assert.Contains(t, w.Body.String(), "Observed MAC 00:11:22:33:44:66 for IP 10.42.5.1, recorded 00:11:22:33:44:55")
+
+	w = doRequest(t, server, "POST", "/api/v2/neighbors?format=json", []map[string]string{{"ip": "10.42.5.1", "mac": "00:11:22:33:44:55"}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Empty(t, decodeObject(t, w)["conflicts"])
+
+	w = doRequest(t, server, "POST", "/api/v1/neighbors?format=csv", nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestLabelSelectors(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	var networkIDs []string
+	for _, n := range []map[string]interface{}{
+		{"cidr": "10.50.0.0/24", "tags": []string{"env=prod", "team=web"}},
+		{"cidr": "10.51.0.0/24", "tags": []string{"env=prod", "team=dbre"}},
+		{"cidr": "10.52.0.0/24", "tags": []string{"env=dev", "team=web"}},
+	} {
+		w := doRequest(t, server, "POST", "/api/v1/networks", n)
+		require.Equal(t, http.StatusCreated, w.Code)
+		networkIDs = append(networkIDs, decodeObject(t, w)["id"].(string))
+	}
+
+	cidrs := func(path string) []string {
+		w := doRequest(t, server, "GET", path, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var out []string
+		for _, n := range decodeArray(t, w) {
+			out = append(out, n["cidr"].(string))
+		}
+		return out
+	}
+
+	sel := url.QueryEscape("env=prod,team!=dbre")
+	assert.Equal(t, []string{"10.50.0.0/24"}, cidrs("/api/v1/networks?selector="+sel))
+	assert.ElementsMatch(t, []string{"10.50.0.0/24", "10.52.0.0/24"},
+		cidrs("/api/v1/networks?selector="+url.QueryEscape("team in (web)")))
+
+	w := doRequest(t, server, "GET", "/api/v2/networks?selector="+sel, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, decodeObject(t, w)["items"], 1)
+
+	w = doRequest(t, server, "GET", "/api/v1/networks?selector="+url.QueryEscape("team in (web"), nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	for _, tags := range [][]string{{"role=db"}, {"role=web"}, nil} {
+		w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkIDs[0], "tags": tags})
+		require.Equal(t, http.StatusCreated, w.Code)
+	}
+
+	w = doRequest(t, server, "GET", "/api/v1/allocations?selector="+url.QueryEscape("role!=db"), nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, decodeArray(t, w), 2)
+
+	w = doRequest(t, server, "GET", "/api/v2/networks/"+networkIDs[0]+"/allocations?selector=role", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, decodeObject(t, w)["items"], 2)
+
+	w = doRequest(t, server, "GET", "/api/v1/allocations?selector=,", nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestTransferAllocation(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	createNetwork := func(cidr string) string {
+		w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": cidr})
+		require.Equal(t, http.StatusCreated, w.Code)
+		return decodeObject(t, w)["id"].(string)
+	}
+
+	parentID := createNetwork("10.60.0.0/16")
+	w := doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": parentID, "hostname": "db01"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	allocationID := decodeObject(t, w)["id"].(string)
+
+	childID := createNetwork("10.60.0.0/24")
+	otherID := createNetwork("10.61.0.0/24")
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations/"+allocationID+"/transfer", map[string]string{"network_id": childID})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	moved := decodeObject(t, w)
+	assert.Equal(t, allocationID, moved["id"])
+	assert.Equal(t, childID, moved["network_id"])
+	assert.Equal(t, "10.60.0.1", moved["ip"])
+	assert.Equal(t, "db01", moved["hostname"])
+
+	w = doRequest(t, server, "GET", "/api/v1/allocations?network_id="+parentID, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, decodeArray(t, w))
+
+	w = doRequest(t, server, "GET", "/api/v1/audit", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "allocation_transferred")
+
+	w = doRequest(t, server, "POST", "/api/v2/allocations/"+allocationID+"/transfer", map[string]string{"network_id": otherID})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations/"+allocationID+"/transfer", map[string]string{"network_id": childID})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations/"+allocationID+"/transfer", map[string]string{})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations/"+allocationID+"/transfer", map[string]string{"network_id": "missing"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations/missing/transfer", map[string]string{"network_id": childID})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestLocateIP(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	var networkIDs []string
+	for _, cidr := range []string{"10.70.0.0/16", "10.70.1.0/24"} {
+		w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": cidr})
+		require.Equal(t, http.StatusCreated, w.Code)
+		networkIDs = append(networkIDs, decodeObject(t, w)["id"].(string))
+	}
+
+	w := doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkIDs[1], "hostname": "app01"})
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	w = doRequest(t, server, "GET", "/api/v1/locate?ip=10.70.1.1", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	loc := decodeObject(t, w)
+	assert.Equal(t, "active", loc["status"])
+	assert.Equal(t, "10.70.1.0/24", loc["network"].(map[string]interface{})["cidr"])
+	assert.Len(t, loc["networks"], 2)
+	assert.Equal(t, "app01", loc["allocation"].(map[string]interface{})["hostname"])
+
+	w = doRequest(t, server, "GET", "/api/v2/locate?ip=10.70.9.9", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	loc = decodeObject(t, w)
+	assert.Equal(t, "available", loc["status"])
+	assert.Equal(t, networkIDs[0], loc["network"].(map[string]interface{})["id"])
+	assert.NotContains(t, loc, "allocation")
+
+	w = doRequest(t, server, "GET", "/api/v1/locate?ip=172.31.0.1", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, CodeNetworkNotFound, decodeObject(t, w)["code"])
+
+	w = doRequest(t, server, "GET", "/api/v1/locate?ip=nope", nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestCheckIPs(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.71.0.0/16", "tags": []string{"tenant=acme"}})
+	require.Equal(t, http.StatusCreated, w.Code)
+	w = doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.71.1.0/24", "tags": []string{"env=prod"}})
+	require.Equal(t, http.StatusCreated, w.Code)
+	networkID := decodeObject(t, w)["id"].(string)
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "hostname": "fw01"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	allocationID := decodeObject(t, w)["id"].(string)
+
+	w = doRequest(t, server, "POST", "/api/v1/check", map[string]interface{}{"ips": []string{"10.71.1.1", "10.71.9.9", "8.8.8.8"}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	results := decodeObject(t, w)["results"].([]interface{})
+	require.Len(t, results, 3)
+	assert.Equal(t, map[string]interface{}{
+		"ip": "10.71.1.1", "managed": true, "network_id": networkID, "cidr": "10.71.1.0/24",
+		"tenant": "acme", "tags": []interface{}{"env=prod"},
+		"allocation_id": allocationID, "hostname": "fw01", "status": "active",
+	}, results[0])
+	assert.Equal(t, "10.71.0.0/16", results[1].(map[string]interface{})["cidr"])
+	assert.Equal(t, "available", results[1].(map[string]interface{})["status"])
+	assert.Equal(t, map[string]interface{}{"ip": "8.8.8.8", "managed": false}, results[2])
+
+	w = doRequest(t, server, "POST", "/api/v2/check", map[string]interface{}{"ips": []string{"10.71.1.1", "nope"}})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "ips[1]")
+
+	w = doRequest(t, server, "POST", "/api/v1/check", map[string]interface{}{"ips": []string{}})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestListAllocationsSortAndFilter(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.80.0.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	networkID := decodeObject(t, w)["id"].(string)
+
+	var ids []string
+	for _, alloc := range []map[string]interface{}{
+		{"network_id": networkID, "hostname": "charlie", "tags": []string{"prod"}},
+		{"network_id": networkID, "hostname": "alpha"},
+		{"network_id": networkID, "hostname": "bravo", "tags": []string{"prod"}},
+	} {
+		w = doRequest(t, server, "POST", "/api/v1/allocations", alloc)
+		require.Equal(t, http.StatusCreated, w.Code)
+		ids = append(ids, decodeObject(t, w)["id"].(string))
+	}
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations/"+ids[1]+"/release", nil)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	hostnames := func(items []map[string]interface{}) []string {
+		var out []string
+		for _, item := range items {
+			out = append(out, item["hostname"].(string))
+		}
+		return out
+	}
+
+	w = doRequest(t, server, "GET", "/api/v1/allocations?sort=hostname", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"bravo", "charlie"}, hostnames(decodeArray(t, w)))
+
+	w = doRequest(t, server, "GET", "/api/v1/allocations?sort=ip&order=desc&tag=prod", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"bravo", "charlie"}, hostnames(decodeArray(t, w)))
+
+	w = doRequest(t, server, "GET", "/api/v1/allocations?status=released", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"alpha"}, hostnames(decodeArray(t, w)))
+
+	w = doRequest(t, server, "GET", "/api/v2/allocations?sort=hostname&order=desc&all=true", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var page struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+	assert.Equal(t, []string{"charlie", "bravo", "alpha"}, hostnames(page.Items))
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "hostname": "delta", "ttl": 3600})
+	require.Equal(t, http.StatusCreated, w.Code)
+	w = doRequest(t, server, "GET", "/api/v1/allocations?expiring_within=2h", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"delta"}, hostnames(decodeArray(t, w)))
+	w = doRequest(t, server, "GET", "/api/v1/allocations?expiring_within=30m", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, decodeArray(t, w))
+
+	for _, query := range []string{"sort=size", "order=up", "status=gone", "expiring_within=soon", "expiring_within=0s"} {
+		w = doRequest(t, server, "GET", "/api/v1/allocations?"+query, nil)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code, query)
+	}
+}
+
+func TestHostnameTemplate(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.90.0.0/24", "tags": []string{"domain=example.com"}})
+	require.Equal(t, http.StatusCreated, w.Code)
+	networkID := decodeObject(t, w)["id"].(string)
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "hostname": "web-1.example.com"})
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"cidr": "10.90.0.0/24", "hostname_template": "web-{{seq}}.{{domain}}"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, "web-2.example.com", decodeObject(t, w)["hostname"])
+
+	w = doRequest(t, server, "POST", "/api/v2/networks/"+networkID+"/allocations", map[string]interface{}{"hostname_template": "web-{{seq}}.{{domain}}"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, "web-3.example.com", decodeObject(t, w)["hostname"])
+
+	// An explicit hostname wins over the template
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "hostname": "db01", "hostname_template": "{{pet}}"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "db01", decodeObject(t, w)["hostname"])
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "hostname_template": "web-{{ip}}"})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "hostname_template": "db01"})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestCarveSubnet(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "2001:db8:200::/56"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	parentID := decodeObject(t, w)["id"].(string)
+
+	w = doRequest(t, server, "POST", "/api/v1/networks/"+parentID+"/subnets", map[string]interface{}{"prefix_length": 60, "description": "VLAN 10"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	subnet := decodeObject(t, w)
+	assert.Equal(t, "2001:db8:200::/60", subnet["cidr"])
+	assert.Equal(t, "VLAN 10", subnet["description"])
+
+	w = doRequest(t, server, "POST", "/api/v2/networks/"+parentID+"/subnets", map[string]interface{}{"prefix_length": 60})
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "2001:db8:200:10::/60", decodeObject(t, w)["cidr"])
+
+	w = doRequest(t, server, "POST", "/api/v1/networks/"+parentID+"/subnets", map[string]interface{}{"prefix_length": 62})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = doRequest(t, server, "POST", "/api/v1/networks/"+parentID+"/subnets", map[string]interface{}{"prefix_length": 48})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = doRequest(t, server, "POST", "/api/v1/networks/missing/subnets", map[string]interface{}{"prefix_length": 60})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Fill the remaining /60s of the /56
+	for i := 2; i < 16; i++ {
+		w = doRequest(t, server, "POST", "/api/v1/networks/"+parentID+"/subnets", map[string]interface{}{"prefix_length": 60})
+		require.Equal(t, http.StatusCreated, w.Code)
+	}
+	w = doRequest(t, server, "POST", "/api/v1/networks/"+parentID+"/subnets", map[string]interface{}{"prefix_length": 60})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, CodeNetworkFull, decodeObject(t, w)["code"])
+}
+
+func TestAllocationGroups(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	var networkIDs []string
+	for _, cidr := range []string{"10.95.0.0/24", "10.96.0.0/24", "10.97.0.0/30"} {
+		w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": cidr})
+		require.Equal(t, http.StatusCreated, w.Code)
+		networkIDs = append(networkIDs, decodeObject(t, w)["id"].(string))
+	}
+
+	w := doRequest(t, server, "POST", "/api/v1/groups", map[string]interface{}{
+		"id": "svc-a",
+		"members": []map[string]interface{}{
+			{"network_id": networkIDs[0], "hostname": "svc-a-mgmt"},
+			{"network_id": networkIDs[1], "hostname": "svc-a-data", "tags": []string{"role=data"}},
+		},
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	group := decodeObject(t, w)
+	assert.Equal(t, "svc-a", group["id"])
+	require.Len(t, group["members"], 2)
+
+	w = doRequest(t, server, "GET", "/api/v1/groups/svc-a", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	members := decodeObject(t, w)["members"].([]interface{})
+	require.Len(t, members, 2)
+	assert.Contains(t, members[1].(map[string]interface{})["tags"], "role=data")
+
+	// Group IDs are unique while the group has members
+	w = doRequest(t, server, "POST", "/api/v1/groups", map[string]interface{}{
+		"id": "svc-a", "members": []map[string]interface{}{{"network_id": networkIDs[0]}},
+	})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = doRequest(t, server, "POST", "/api/v1/groups", map[string]interface{}{
+		"id": "bad id", "members": []map[string]interface{}{{"count": -1}},
+	})
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "members[0].count")
+
+	// A failing member rolls back the whole group
+	w = doRequest(t, server, "POST", "/api/v1/groups", map[string]interface{}{
+		"id": "svc-b",
+		"members": []map[string]interface{}{
+			{"network_id": networkIDs[0]},
+			{"network_id": networkIDs[2], "count": 64},
+		},
+	})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = doRequest(t, server, "GET", "/api/v1/groups/svc-b", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = doRequest(t, server, "POST", "/api/v1/groups/svc-a/release", nil)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	w = doRequest(t, server, "GET", "/api/v2/groups/svc-a", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = doRequest(t, server, "DELETE", "/api/v2/groups/svc-a", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestNetworkFreeze(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.98.0.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	networkID := decodeObject(t, w)["id"].(string)
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID})
+	require.Equal(t, http.StatusCreated, w.Code)
+	allocationID := decodeObject(t, w)["id"].(string)
+
+	w = doRequest(t, server, "POST", "/api/v1/networks/"+networkID+"/freeze", map[string]interface{}{"reason": "has spaces"})
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	w = doRequest(t, server, "POST", "/api/v1/networks/"+networkID+"/freeze", map[string]interface{}{"reason": "q4", "until": time.Now().Add(-time.Hour)})
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "until")
+
+	w = doRequest(t, server, "POST", "/api/v1/networks/"+networkID+"/freeze", map[string]interface{}{"reason": "q4-change-freeze", "until": time.Now().Add(time.Hour)})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, decodeObject(t, w)["tags"], "freeze=q4-change-freeze")
+
+	// Allocations and releases are refused while frozen
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"cidr": "10.98.0.0/24"})
+	require.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "q4-change-freeze")
+	assert.Equal(t, CodeNetworkFrozen, decodeObject(t, w)["code"])
+
+	w = doRequest(t, server, "POST", "/api/v2/networks/"+networkID+"/allocations", map[string]interface{}{})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = doRequest(t, server, "DELETE", "/api/v2/allocations/"+allocationID, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = doRequest(t, server, "DELETE", "/api/v2/networks/"+networkID+"/freeze", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, decodeObject(t, w)["tags"], "freeze=q4-change-freeze")
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations/"+allocationID+"/release", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = doRequest(t, server, "POST", "/api/v1/networks/missing/freeze", map[string]interface{}{"reason": "q4"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAuditOnBehalfOf(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+	server.SetAuthTokens([]string{"ops", "gateway"})
+	server.SetAdminPrincipals([]string{"token:" + tokenFingerprint("gateway")})
+
+	as := func(token, onBehalfOf, method, path string, body interface{}) *httptest.ResponseRecorder {
+		var reader io.Reader
+		if body != nil {
+			data, err := json.Marshal(body)
+			require.NoError(t, err)
+			reader = bytes.NewReader(data)
+		}
+		req := httptest.NewRequest(method, path, reader)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		if onBehalfOf != "" {
+			req.Header.Set(OnBehalfOfHeader, onBehalfOf)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+	frozenBy := func(networkID string) string {
+		entries, err := server.store.ListAuditEntries(0)
+		require.NoError(t, err)
+		for _, entry := range entries {
+			if entry.Action == "network_frozen" && entry.Resource == networkID {
+				return entry.User
+			}
+		}
+		return ""
+	}
+
+	w := as("ops", "", "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.94.0.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	opsID := decodeObject(t, w)["id"].(string)
+	w = as("ops", "", "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.94.1.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	gatewayID := decodeObject(t, w)["id"].(string)
+
+	// Entries record the authenticated principal
+	w = as("ops", "", "POST", "/api/v1/networks/"+opsID+"/freeze", map[string]interface{}{"reason": "q4"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "token:"+tokenFingerprint("ops")+"@192.0.2.1", frozenBy(opsID))
+
+	// An admin principal records the end user it acts for
+	w = as("gateway", "alice@example.com", "POST", "/api/v1/networks/"+gatewayID+"/freeze", map[string]interface{}{"reason": "q4"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "alice@example.com via token:"+tokenFingerprint("gateway")+"@192.0.2.1", frozenBy(gatewayID))
+
+	// Others may not, and nothing is changed
+	w = as("ops", "alice@example.com", "DELETE", "/api/v1/networks/"+gatewayID+"/freeze", nil)
+	require.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, CodeForbidden, decodeObject(t, w)["code"])
+	network, err := server.store.GetNetwork(gatewayID)
+	require.NoError(t, err)
+	assert.NotNil(t, store.NetworkFreeze(network, time.Now()))
+
+	w = as("gateway", "alice example", "DELETE", "/api/v1/networks/"+gatewayID+"/freeze", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestNetworkACL(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+	// sha256("ops") starts a92c36e6, sha256("lab") a51146d3
+	server.SetAuthTokens([]string{"ops", "lab"})
+
+	as := func(token, method, path string, body interface{}) *httptest.ResponseRecorder {
+		var reader io.Reader
+		if body != nil {
+			data, err := json.Marshal(body)
+			require.NoError(t, err)
+			reader = bytes.NewReader(data)
+		}
+		req := httptest.NewRequest(method, path, reader)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	w := as("ops", "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.97.0.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	prodID := decodeObject(t, w)["id"].(string)
+	w = as("ops", "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.97.1.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	w = as("lab", "POST", "/api/v1/allocations", map[string]interface{}{"network_id": prodID})
+	require.Equal(t, http.StatusCreated, w.Code)
+	allocationID := decodeObject(t, w)["id"].(string)
+
+	// An ACL needs an admin, and only names known permissions
+	w = as("ops", "PUT", "/api/v1/networks/"+prodID+"/acl", map[string]interface{}{
+		"acl": map[string][]string{"allocate": {"token:a92c36e6"}, "write": {"any"}}})
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "acl.admin")
+	assert.Contains(t, w.Body.String(), `unknown permission \"write\"`)
+
+	w = as("ops", "PUT", "/api/v1/networks/"+prodID+"/acl", map[string]interface{}{
+		"acl": map[string][]string{"admin": {"token:a92c36e6"}, "view": {"any"}}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, map[string]interface{}{
+		"view": []interface{}{"any"}, "allocate": []interface{}{}, "release": []interface{}{}, "admin": []interface{}{"token:a92c36e6"},
+	}, decodeObject(t, w)["acl"])
+
+	// The lab token may still look, but not allocate, release or take over
+	assert.Equal(t, http.StatusOK, as("lab", "GET", "/api/v1/networks/"+prodID, nil).Code)
+	assert.Equal(t, http.StatusOK, as("lab", "GET", "/api/v1/allocations/"+allocationID, nil).Code)
+	w = as("lab", "POST", "/api/v1/allocations", map[string]interface{}{"cidr": "10.97.0.0/24"})
+	require.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "token:a51146d3 lacks allocate permission on 10.97.0.0/24")
+	assert.Equal(t, CodeForbidden, decodeObject(t, w)["code"])
+	assert.Equal(t, http.StatusForbidden, as("lab", "POST", "/api/v2/networks/"+prodID+"/allocations", map[string]interface{}{}).Code)
+	assert.Equal(t, http.StatusForbidden, as("lab", "DELETE", "/api/v2/allocations/"+allocationID, nil).Code)
+	assert.Equal(t, http.StatusForbidden, as("lab", "POST", "/api/v1/networks/"+prodID+"/freeze", map[string]interface{}{"reason": "mine"}).Code)
+	assert.Equal(t, http.StatusForbidden, as("lab", "PUT", "/api/v1/networks/"+prodID+"/acl", map[string]interface{}{"acl": map[string][]string{}}).Code)
+
+	// The lab range stays open to the lab token
+	w = as("lab", "POST", "/api/v1/allocations", map[string]interface{}{"cidr": "10.97.1.0/24"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// Without view, the network disappears from lists
+	w = as("ops", "PUT", "/api/v2/networks/"+prodID+"/acl", map[string]interface{}{
+		"acl": map[string][]string{"admin": {"token:a92c36e6"}}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = as("lab", "GET", "/api/v1/networks", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, decodeArray(t, w), 1)
+	w = as("lab", "GET", "/api/v1/allocations", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, decodeArray(t, w), 1)
+	assert.Equal(t, http.StatusForbidden, as("lab", "GET", "/api/v1/networks/"+prodID+"/stats", nil).Code)
+	assert.Equal(t, http.StatusForbidden, as("lab", "GET", "/api/v1/locate?ip=10.97.0.1", nil).Code)
+	w = as("ops", "GET", "/api/v1/networks", nil)
+	assert.Len(t, decodeArray(t, w), 2)
+
+	// Nor can it be read through the endpoints covering every network
+	for _, path := range []string{"/api/v1/snapshot", "/api/v1/audit/export", "/api/v2/audit-entries/export"} {
+		assert.Equal(t, http.StatusForbidden, as("lab", "GET", path, nil).Code, path)
+	}
+	assert.Equal(t, http.StatusOK, as("ops", "GET", "/api/v1/snapshot", nil).Code)
+	w = as("lab", "POST", "/api/v1/tags/merge", map[string]interface{}{"from": []string{"a", "b"}, "to": "c"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	for _, path := range []string{"/api/v1/audit?limit=0", "/api/v2/audit-entries"} {
+		w = as("lab", "GET", path, nil)
+		require.Equal(t, http.StatusOK, w.Code, path)
+		var entries []*ipam.AuditEntry
+		if path == "/api/v1/audit?limit=0" {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &entries))
+		} else {
+			var page struct {
+				Items []*ipam.AuditEntry `json:"items"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+			entries = page.Items
+		}
+		assert.NotEmpty(t, entries, path)
+		for _, entry := range entries {
+			assert.NotContains(t, []string{prodID, allocationID}, entry.Resource, path)
+		}
+	}
+
+	// ACL tags are only changed through the ACL endpoint
+	w = as("ops", "PATCH", "/api/v2/networks/"+prodID, map[string]interface{}{"tags": []string{"env=prod", "acl-admin=any"}})
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	w = as("ops", "PATCH", "/api/v2/networks/"+prodID, map[string]interface{}{"tags": []string{"env=prod"}})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []interface{}{"env=prod", "acl-admin=token:a92c36e6"}, decodeObject(t, w)["tags"])
+	w = as("ops", "POST", "/api/v1/tags/rename", map[string]interface{}{"from": "acl-admin=token:a92c36e6", "to": "acl-admin=any"})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	w = as("lab", "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.97.2.0/24", "tags": []string{"acl-admin=any"}})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	w = as("lab", "POST", "/api/v1/networks/"+prodID+"/subnets", map[string]interface{}{"prefix_length": 28, "tags": []string{"acl-admin=any"}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = as("ops", "POST", "/api/v1/networks/"+prodID+"/subnets", map[string]interface{}{"prefix_length": 28, "tags": []string{"acl-admin=any"}})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	// The tag list only counts what the caller may view
+	assert.Contains(t, as("ops", "GET", "/api/v1/tags", nil).Body.String(), `"env=prod"`)
+	assert.NotContains(t, as("lab", "GET", "/api/v1/tags", nil).Body.String(), `"env=prod"`)
+
+	// Subnets keep the parent's ACL, and an empty ACL reopens the network
+	w = as("ops", "POST", "/api/v1/networks/"+prodID+"/subnets", map[string]interface{}{"prefix_length": 28})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Contains(t, decodeObject(t, w)["tags"], "acl-admin=token:a92c36e6")
+	w = as("ops", "PUT", "/api/v1/networks/"+prodID+"/acl", map[string]interface{}{"acl": map[string][]string{}})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusNoContent, as("lab", "POST", "/api/v1/allocations/"+allocationID+"/release", nil).Code)
+
+	entries, err := server.store.ListAuditEntries(0)
+	require.NoError(t, err)
+	var changes []string
+	for _, entry := range entries {
+		if entry.Action == "network_acl_changed" {
+			details, _, _ := strings.Cut(entry.Details, " request_id=")
+			changes = append(changes, details)
+		}
+	}
+	assert.Contains(t, changes, "Set ACL of network 10.97.0.0/24: view=any admin=token:a92c36e6")
+	assert.Contains(t, changes, "Set ACL of network 10.97.0.0/24: open")
+}
+
+func TestFakeClock(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	start := time.Now().UTC().Truncate(time.Second)
+	fake := clock.NewFake(start)
+	server.SetClock(fake)
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.99.0.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	networkID := decodeObject(t, w)["id"].(string)
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "ttl": 60})
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	countExpired := func() float64 {
+		w := doRequest(t, server, "GET", "/api/v1/networks/"+networkID+"/allocations/count?status=expired", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		return decodeObject(t, w)["count"].(float64)
+	}
+
+	// The TTL is judged by the server's clock, not the wall clock
+	assert.Equal(t, float64(0), countExpired())
+	fake.Advance(2 * time.Minute)
+	assert.Equal(t, float64(1), countExpired())
+
+	// Freezes end on the server's clock and audit entries are stamped by it
+	w = doRequest(t, server, "POST", "/api/v1/networks/"+networkID+"/freeze", map[string]interface{}{"reason": "q4", "until": fake.Now().Add(time.Hour)})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = doRequest(t, server, "GET", "/api/v1/audit", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var entries []*ipam.AuditEntry
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&entries))
+	var frozenAt time.Time
+	for _, entry := range entries {
+		if entry.Action == "network_frozen" {
+			frozenAt = entry.Timestamp
+		}
+	}
+	assert.True(t, frozenAt.Equal(start.Add(2*time.Minute)), "audit timestamp %s", frozenAt)
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	fake.Advance(time.Hour)
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID})
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestReleaseErrors(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.100.0.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	networkID := decodeObject(t, w)["id"].(string)
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID})
+	require.Equal(t, http.StatusCreated, w.Code)
+	allocationID := decodeObject(t, w)["id"].(string)
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations/"+allocationID+"/release", nil)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	// Releasing again is a conflict, not a missing allocation
+	w = doRequest(t, server, "POST", "/api/v1/allocations/"+allocationID+"/release", nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	body := decodeObject(t, w)
+	assert.Equal(t, CodeAlreadyReleased, body["code"])
+	assert.Contains(t, body["message"], "by api")
+	details, ok := body["details"].(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, "api", details["released_by"])
+	assert.NotEmpty(t, details["released_at"])
+
+	w = doRequest(t, server, "DELETE", "/api/v2/allocations/"+allocationID, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, CodeAlreadyReleased, decodeObject(t, w)["code"])
+
+	w = doRequest(t, server, "DELETE", "/api/v2/allocations/missing", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, CodeAllocationNotFound, decodeObject(t, w)["code"])
+
+	// Engine errors map to the same statuses in every handler
+	w = doRequest(t, server, "POST", "/api/v2/networks/missing/allocations", map[string]interface{}{})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = doRequest(t, server, "POST", "/api/v1/groups", map[string]interface{}{
+		"id": "g1", "members": []map[string]interface{}{{"network_id": networkID, "count": 1000}},
+	})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, CodeNetworkFull, decodeObject(t, w)["code"])
+}
+
+func TestAuditFailedRequests(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.101.0.0/30"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	networkID := decodeObject(t, w)["id"].(string)
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "count": 2})
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	// A full network and a validation reject are both recorded
+	w = doRequest(t, server, "POST", "/api/v2/networks/"+networkID+"/allocations", map[string]interface{}{})
+	require.Equal(t, http.StatusConflict, w.Code)
+	w = doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "bogus"})
+	require.GreaterOrEqual(t, w.Code, 400)
+
+	// Reads are not audited, even when they fail
+	w = doRequest(t, server, "GET", "/api/v1/networks/missing", nil)
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	entries, err := server.store.ListAuditEntries(0)
+	require.NoError(t, err)
+	var failures []*ipam.AuditEntry
+	for _, entry := range entries {
+		if entry.Action == "request_failed" {
+			failures = append(failures, entry)
+		}
+	}
+	require.Len(t, failures, 2)
+
+	// Most recent first
+	assert.Equal(t, "/api/v1/networks", failures[0].Resource)
+	assert.Contains(t, failures[0].Details, "outcome=failure")
+	assert.Contains(t, failures[0].Details, "POST /api/v1/networks")
+
+	assert.Equal(t, networkID, failures[1].Resource)
+	assert.Contains(t, failures[1].Details, "outcome=failure status=409 code="+CodeNetworkFull)
+	assert.Contains(t, failures[1].Details, "POST /api/v2/networks/{id}/allocations")
+	assert.Equal(t, "api", failures[1].User)
+}
+
+func TestApprovalWebhook(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	var requests []approval.Request
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req approval.Request
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+		if req.Hostname != "known-host" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message":"hostname not in CMDB"}`))
+		}
+	}))
+	defer hook.Close()
+
+	hooks, err := approval.ParseWebhooks([]string{"cmdb=" + hook.URL})
+	require.NoError(t, err)
+	server.SetApprovalWebhooks(hooks)
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.102.0.0/24", "tags": []string{"approval-webhook=cmdb"}})
+	require.Equal(t, http.StatusCreated, w.Code)
+	networkID := decodeObject(t, w)["id"].(string)
+	w = doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.102.1.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	w = doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.102.2.0/24", "tags": []string{"approval-webhook=missing"}})
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "hostname": "known-host"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Len(t, requests, 1)
+	assert.Equal(t, "10.102.0.0/24", requests[0].CIDR)
+	assert.NotEmpty(t, requests[0].Requester)
+
+	w = doRequest(t, server, "POST", "/api/v2/networks/"+networkID+"/allocations", map[string]interface{}{"hostname": "rogue"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	body := decodeObject(t, w)
+	assert.Equal(t, CodeAllocationRejected, body["code"])
+	assert.Contains(t, body["message"], "hostname not in CMDB")
+
+	// Networks without a webhook are not checked
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"cidr": "10.102.1.0/24", "hostname": "rogue"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Len(t, requests, 2)
+
+	// An unconfigured webhook fails closed
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"cidr": "10.102.2.0/24"})
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Equal(t, CodeApprovalUnavailable, decodeObject(t, w)["code"])
+
+	// Group members are approved before anything is allocated
+	w = doRequest(t, server, "POST", "/api/v1/groups", map[string]interface{}{
+		"id": "svc", "members": []map[string]interface{}{
+			{"cidr": "10.102.1.0/24"},
+			{"network_id": networkID, "hostname": "rogue"},
+		},
+	})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	allocations, err := server.store.ListAllocations(networkID)
+	require.NoError(t, err)
+	assert.Len(t, allocations, 1)
+}
+
+// vetoHook rejects allocations for one hostname and tags the rest
+type vetoHook struct {
+	hooks.Base
+	released []string
+}
+
+func (h *vetoHook) Name() string { return "test" }
+
+func (h *vetoHook) PreAllocate(_ context.Context, req *ipam.AllocationRequest) error {
+	if req.Hostname == "blocked" {
+		return errors.New("hostname is blocked")
+	}
+	return nil
+}
+
+func (h *vetoHook) PostAllocate(_ context.Context, alloc *ipam.IPAllocation) error {
+	alloc.Tags = append(alloc.Tags, "hooked")
+	return nil
+}
+
+func (h *vetoHook) PostRelease(_ context.Context, alloc *ipam.IPAllocation) error {
+	h.released = append(h.released, alloc.IP)
+	return nil
+}
+
+func TestLifecycleHooks(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	hook := &vetoHook{}
+	server.SetHooks(hooks.Chain{hook})
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.103.0.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	networkID := decodeObject(t, w)["id"].(string)
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "hostname": "blocked"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	body := decodeObject(t, w)
+	assert.Equal(t, CodeOperationVetoed, body["code"])
+	assert.Contains(t, body["message"], "hostname is blocked")
+
+	w = doRequest(t, server, "POST", "/api/v2/networks/"+networkID+"/allocations", map[string]interface{}{"hostname": "web"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	allocation := decodeObject(t, w)
+	assert.Equal(t, []interface{}{"hooked"}, allocation["tags"])
+
+	w = doRequest(t, server, "DELETE", "/api/v2/allocations/"+allocation["id"].(string), nil)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, []string{allocation["ip"].(string)}, hook.released)
+}
+
+func TestApprovalPendingNotification(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	approver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer approver.Close()
+	events := make(chan notify.Event, 1)
+	channel := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event notify.Event
+		json.NewDecoder(r.Body).Decode(&event)
+		events <- event
+	}))
+	defer channel.Close()
+
+	approvals, err := approval.ParseWebhooks([]string{"cmdb=" + approver.URL})
+	require.NoError(t, err)
+	server.SetApprovalWebhooks(approvals)
+	notifier, err := notify.Parse([]string{"ops=" + channel.URL})
+	require.NoError(t, err)
+	server.SetNotifier(notifier)
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.103.0.0/24", "tags": []string{"approval-webhook=cmdb", "notify=ops"}})
+	require.Equal(t, http.StatusCreated, w.Code)
+	networkID := decodeObject(t, w)["id"].(string)
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "hostname": "web-1"})
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	select {
+	case event := <-events:
+		assert.Equal(t, notify.EventApprovalPending, event.Type)
+		assert.Equal(t, "10.103.0.0/24", event.CIDR)
+		assert.Contains(t, event.Message, "web-1")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no approval notification")
+	}
+}
+
+func TestAuthTokens(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+	server.SetAuthTokens([]string{"s3cret", "other"})
+
+	get := func(path, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, authorization := range []string{"", "Bearer wrong", "s3cret", "Basic czNjcmV0"} {
+		w := get("/api/v1/networks", authorization)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, authorization)
+		assert.Equal(t, `Bearer realm="ipam"`, w.Header().Get("WWW-Authenticate"))
+		var resp ErrorResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		assert.Equal(t, CodeUnauthorized, resp.Code)
+	}
+
+	assert.Equal(t, http.StatusOK, get("/api/v1/networks", "Bearer s3cret").Code)
+	assert.Equal(t, http.StatusOK, get("/api/v2/networks", "Bearer other").Code)
+
+	// Health checks and probes stay open for load balancers
+	assert.Equal(t, http.StatusOK, get("/api/v1/health", "").Code)
+	assert.Equal(t, http.StatusOK, get("/livez", "").Code)
+	assert.Equal(t, http.StatusOK, get("/readyz", "").Code)
+
+	server.SetAuthTokens(nil)
+	assert.Equal(t, http.StatusOK, get("/api/v1/networks", "").Code)
+}
+
+func TestAuditPrincipal(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest("POST", "/api/v1/cluster/nodes", nil)
+	req.RemoteAddr = "10.0.0.5:41234"
+	req.Header.Set("Authorization", "Bearer test")
+	assert.Equal(t, "anonymous@10.0.0.5", server.principal(req))
+
+	// sha256("test") starts 9f86d081
+	server.SetAuthTokens([]string{"test"})
+	assert.Equal(t, "token:9f86d081@10.0.0.5", server.principal(req))
+
+	req.RemoteAddr = "@"
+	assert.Equal(t, "token:9f86d081@local", server.principal(req))
+}
+
+func TestSPIFFEAuth(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+	ids, err := spiffe.ParseMappings([]string{"spiffe://example.org/ns/lab/*=lab"})
+	require.NoError(t, err)
+	server.SetSPIFFEIDs(ids)
+
+	svid := func(id string) *x509.Certificate {
+		u, err := url.Parse(id)
+		require.NoError(t, err)
+		return &x509.Certificate{URIs: []*url.URL{u}}
+	}
+	do := func(method, path string, state *tls.ConnectionState, body interface{}) *httptest.ResponseRecorder {
+		var reader io.Reader
+		if body != nil {
+			data, err := json.Marshal(body)
+			require.NoError(t, err)
+			reader = bytes.NewReader(data)
+		}
+		req := httptest.NewRequest(method, path, reader)
+		req.RemoteAddr = "10.0.0.5:41234"
+		req.TLS = state
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+	verified := func(id string) *tls.ConnectionState {
+		cert := svid(id)
+		return &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+
+	// Configuring SPIFFE IDs turns authentication on
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/networks", nil, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/networks", verified("spiffe://example.org/ns/prod/sa/ci"), nil).Code)
+	unverified := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{svid("spiffe://example.org/ns/lab/sa/ci")}}
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/networks", unverified, nil).Code)
+
+	lab := verified("spiffe://example.org/ns/lab/sa/ci")
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/networks", lab, nil).Code)
+
+	// The mapped principal is what ACLs and the audit log name
+	w := do("POST", "/api/v1/networks", lab, map[string]interface{}{"cidr": "10.96.0.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	w = do("PUT", "/api/v1/networks/"+decodeObject(t, w)["id"].(string)+"/acl", lab, map[string]interface{}{
+		"acl": map[string][]string{"admin": {"spiffe:lab"}}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = do("POST", "/api/v1/allocations", lab, map[string]interface{}{"cidr": "10.96.0.0/24"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	req := httptest.NewRequest("GET", "/api/v1/networks", nil)
+	req.RemoteAddr = "10.0.0.5:41234"
+	req.TLS = lab
+	assert.Equal(t, "spiffe:lab@10.0.0.5", server.principal(req))
+
+	// Tokens keep working next to SVIDs
+	server.SetAuthTokens([]string{"ops"})
+	req = httptest.NewRequest("GET", "/api/v1/networks", nil)
+	req.Header.Set("Authorization", "Bearer ops")
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, decodeArray(t, w))
+}
+
+func TestAuditLeaderChanges(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	changes := make(chan store.LeaderChange, 3)
+	changes <- store.LeaderChange{LeaderID: 1, Term: 2}
+	changes <- store.LeaderChange{LeaderID: 2, Term: 3, Previous: 1}
+	changes <- store.LeaderChange{LeaderID: 1, Term: 5, Previous: 2}
+	close(changes)
+	server.auditLeaderChanges(1, changes)
+
+	entries, err := server.store.ListAuditEntries(0)
+	require.NoError(t, err)
+	var details []string
+	for _, entry := range entries {
+		if entry.Action == "leader-change" {
+			assert.Equal(t, "raft", entry.User)
+			assert.Equal(t, "cluster", entry.Resource)
+			details = append(details, entry.Details)
+		}
+	}
+	// Only the elections node 1 won, most recent first
+	assert.Equal(t, []string{
+		"Node 1 became leader in term 5, replacing node 2",
+		"Node 1 became leader in term 2",
+	}, details)
+}
+
+func TestMaxBodySize(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+	server.SetMaxBodySize(64)
+
+	// Small bodies are unaffected
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.104.0.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	big := map[string]interface{}{"cidr": "10.105.0.0/24", "description": strings.Repeat("x", 100)}
+	w = doRequest(t, server, "POST", "/api/v1/networks", big)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	var resp ErrorResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, CodeRequestTooLarge, resp.Code)
+
+	// Bodies of unknown length are cut off while decoding
+	data, err := json.Marshal(big)
+	require.NoError(t, err)
+	req := httptest.NewRequest("POST", "/api/v1/networks", io.MultiReader(bytes.NewReader(data)))
+	req.ContentLength = -1
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	resp = ErrorResponse{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, CodeRequestTooLarge, resp.Code)
+}
+
+func TestCatchUpProgress(t *testing.T) {
+	member := func(id, applied uint64, caughtUp bool, state string) gossip.Member {
+		return gossip.Member{Meta: gossip.Meta{NodeID: id, Ready: true, Applied: applied, CaughtUp: caughtUp}, State: state}
+	}
+	membership := map[uint64]string{1: "node1:5000", 2: "node2:5000", 4: "node4:5000"}
+	members := []gossip.Member{
+		member(1, 1000, true, gossip.StateAlive),
+		member(2, 990, true, gossip.StateAlive),
+		member(3, 5000, false, gossip.StateDead),
+		member(4, 250, false, gossip.StateAlive),
+	}
+
+	// A new node still replaying the log
+	p, ok := catchUpProgress(4, members, membership)
+	require.True(t, ok)
+	assert.True(t, p.Member)
+	assert.False(t, p.CaughtUp)
+	assert.Equal(t, uint64(1000), p.Target, "dead nodes are not a target")
+	assert.Equal(t, uint64(750), p.Behind)
+	assert.Equal(t, 25.0, p.Percent)
+
+	// A caught-up node whose gossiped count is a little behind
+	p, ok = catchUpProgress(2, members, membership)
+	require.True(t, ok)
+	assert.True(t, p.CaughtUp)
+	assert.Zero(t, p.Behind)
+	assert.Equal(t, 100.0, p.Percent)
+
+	// A failed node is not caught up, whatever it last said
+	p, ok = catchUpProgress(3, members, membership)
+	require.True(t, ok)
+	assert.False(t, p.Member)
+	assert.False(t, p.CaughtUp)
+	assert.Equal(t, gossip.StateDead, p.State)
+
+	_, ok = catchUpProgress(5, members, membership)
+	assert.False(t, ok)
+}
+
+func TestTransactions(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	// Allocations can use a network created earlier in the transaction
+	w := doRequest(t, server, "POST", "/api/v1/transactions", map[string]interface{}{
+		"operations": []map[string]interface{}{
+			{"op": "create_network", "cidr": "10.80.0.0/24", "tags": []string{"site:ams"}},
+			{"op": "reserve_range", "cidr": "10.80.0.0/24", "count": 4, "description": "gateways"},
+			{"op": "allocate", "cidr": "10.80.0.0/24", "count": 2},
+		},
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var resp transactionResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Results, 3)
+	require.NotNil(t, resp.Results[0].Network)
+	networkID := resp.Results[0].Network.ID
+	require.Len(t, resp.Results[1].Allocations, 1)
+	assert.Equal(t, "10.80.0.1", resp.Results[1].Allocations[0].IP)
+	assert.Equal(t, "10.80.0.4", resp.Results[1].Allocations[0].EndIP)
+	require.Len(t, resp.Results[2].Allocations, 2)
+	assert.Equal(t, "10.80.0.5", resp.Results[2].Allocations[0].IP)
+	assert.Equal(t, "10.80.0.6", resp.Results[2].Allocations[1].IP)
+
+	w = doRequest(t, server, "GET", "/api/v1/allocations?network_id="+networkID, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, decodeArray(t, w), 3)
+
+	w = doRequest(t, server, "GET", "/api/v1/audit", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "transaction_applied")
+
+	// A failing operation saves nothing, not even the operations before it
+	w = doRequest(t, server, "POST", "/api/v1/transactions", map[string]interface{}{
+		"operations": []map[string]interface{}{
+			{"op": "create_network", "cidr": "10.81.0.0/24"},
+			{"op": "allocate", "network_id": networkID},
+			{"op": "allocate", "network_id": "missing"},
+		},
+	})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, decodeObject(t, w)["message"], "operations[2]")
+
+	w = doRequest(t, server, "GET", "/api/v1/networks", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, decodeArray(t, w), 1)
+	w = doRequest(t, server, "GET", "/api/v1/allocations?network_id="+networkID, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, decodeArray(t, w), 3)
+
+	// Operations are validated before any runs
+	w = doRequest(t, server, "POST", "/api/v1/transactions", map[string]interface{}{
+		"operations": []map[string]interface{}{
+			{"op": "create_network", "cidr": "not-a-cidr"},
+			{"op": "reserve_range", "network_id": networkID},
+			{"op": "delete_network"},
+		},
+	})
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, "operations[0].cidr")
+	assert.Contains(t, body, "operations[1].count")
+	assert.Contains(t, body, "operations[2].op")
+
+	w = doRequest(t, server, "POST", "/api/v1/transactions", map[string]interface{}{"operations": []interface{}{}})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestCapacityReport(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	for _, n := range []struct {
+		cidr string
+		tags []string
+		used int
+	}{
+		{"10.90.0.0/28", []string{"site=ams", "tenant=acme"}, 13},
+		{"10.90.1.0/28", []string{"site=fra", "tenant=acme"}, 2},
+		{"10.90.2.0/28", []string{"site=fra"}, 0},
+	} {
+		w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": n.cidr, "tags": n.tags})
+		require.Equal(t, http.StatusCreated, w.Code)
+		if n.used > 0 {
+			w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"cidr": n.cidr, "count": n.used})
+			require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		}
+	}
+
+	w := doRequest(t, server, "GET", "/api/v1/reports/capacity?top=1", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var capacity report.Capacity
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &capacity))
+	assert.Equal(t, 3, capacity.Totals.Networks)
+	assert.Equal(t, uint64(15), capacity.Totals.AllocatedIPs)
+	assert.Equal(t, 1, capacity.Totals.OverQuota)
+	groups := map[string]int{}
+	for _, g := range capacity.Groups {
+		groups[g.Label+"="+g.Value] = g.Networks
+	}
+	assert.Equal(t, map[string]int{"site=ams": 1, "site=fra": 2, "tenant=": 1, "tenant=acme": 2}, groups)
+	require.Len(t, capacity.NearlyFull, 1)
+	assert.Equal(t, "10.90.0.0/28", capacity.NearlyFull[0].CIDR)
+	assert.True(t, capacity.NearlyFull[0].OverQuota)
+
+	// Network filters narrow the report
+	w = doRequest(t, server, "GET", "/api/v2/reports/capacity?selector=site%3Dfra&group_by=tenant", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	capacity = report.Capacity{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &capacity))
+	assert.Equal(t, 2, capacity.Totals.Networks)
+	require.Len(t, capacity.Groups, 2)
+	assert.Equal(t, "tenant", capacity.Groups[0].Label)
+
+	w = doRequest(t, server, "GET", "/api/v1/reports/capacity?top=0&group_by=a%3Db", nil)
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"top"`)
+	assert.Contains(t, w.Body.String(), `"group_by"`)
+}
+
+func TestGroupedStats(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	for _, n := range []struct {
+		cidr string
+		tags []string
+		used int
+	}{
+		{"10.91.0.0/28", []string{"site=ams", "env=prod"}, 4},
+		{"10.91.1.0/28", []string{"site=ams"}, 2},
+		{"10.91.2.0/28", []string{"site=fra"}, 0},
+	} {
+		w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": n.cidr, "tags": n.tags})
+		require.Equal(t, http.StatusCreated, w.Code)
+		for i := 0; i < n.used; i++ {
+			w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"cidr": n.cidr})
+			require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		}
+	}
+
+	type response struct {
+		GroupBy string          `json:"group_by"`
+		Groups  []*report.Group `json:"groups"`
+	}
+	groups := func(path string) map[string]uint64 {
+		w := doRequest(t, server, "GET", path, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp response
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		allocated := map[string]uint64{}
+		for _, g := range resp.Groups {
+			allocated[g.Value] = g.AllocatedIPs
+		}
+		return allocated
+	}
+
+	assert.Equal(t, map[string]uint64{"ams": 6, "fra": 0}, groups("/api/v1/stats?group_by=site"))
+	assert.Equal(t, map[string]uint64{"env=prod": 4, "site=ams": 6, "site=fra": 0}, groups("/api/v2/stats?group_by=tag"))
+	assert.Equal(t, map[string]uint64{"": 2, "prod": 4}, groups("/api/v1/stats?group_by=env&selector=site%3Dams"))
+
+	w := doRequest(t, server, "GET", "/api/v1/stats", nil)
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"group_by"`)
+}
+
+func TestTags(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{
+		"cidr": "10.90.0.0/24", "tags": []string{"env=production", "site=ams"},
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	networkID := decodeObject(t, w)["id"].(string)
+	for _, tags := range [][]string{{"env=prd"}, {"env=production", "env=prd"}, {"PCI"}} {
+		w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "tags": tags})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	// Without a registry every tag is allowed and in use unregistered
+	w = doRequest(t, server, "GET", "/api/v1/tags", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var report taxonomy.Report
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Empty(t, report.Registered)
+	assert.Len(t, report.Unregistered, 4)
+
+	server.SetTagRegistry(&taxonomy.Registry{Enforce: true, Tags: []taxonomy.Tag{
+		{Name: "env", Color: "green", Values: []string{"prod", "staging"}},
+		{Name: "site"},
+		{Name: "pci"},
+	}})
+
+	// Unknown tags are rejected wherever tags are set
+	w = doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{
+		"cidr": "10.91.0.0/24", "tags": []string{"site=ams", "env=Prod"},
+	})
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "tags[1]")
+	assert.Contains(t, w.Body.String(), `did you mean \"env=prod\"?`)
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "tags": []string{"owner=alice"}})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	w = doRequest(t, server, "POST", "/api/v1/transactions", map[string]interface{}{
+		"operations": []map[string]interface{}{{"op": "allocate", "network_id": networkID, "tags": []string{"prod"}}},
+	})
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "operations[0].tags[0]")
+	w = doRequest(t, server, "PATCH", "/api/v2/networks/"+networkID, map[string]interface{}{"tags": []string{"env=dev"}})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	// Reserved tags are always allowed
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "tags": []string{"site=ams", "reclaim-exempt"}})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	// Merging folds the variants into a registered tag
+	w = doRequest(t, server, "POST", "/api/v1/tags/merge", map[string]interface{}{"from": []string{"env=production", "env=prd"}, "to": "env=prod"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var changed retagResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &changed))
+	assert.Equal(t, taxonomy.Usage{Networks: 1, Allocations: 2}, changed.Usage)
+
+	w = doRequest(t, server, "POST", "/api/v2/tags/rename", map[string]interface{}{"from": "PCI", "to": "pci"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = doRequest(t, server, "GET", "/api/v1/allocations?network_id="+networkID, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var allocations []*ipam.IPAllocation
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &allocations))
+	var tags [][]string
+	for _, a := range allocations {
+		tags = append(tags, a.Tags)
+	}
+	assert.ElementsMatch(t, [][]string{{"env=prod"}, {"env=prod"}, {"pci"}, {"site=ams", "reclaim-exempt"}}, tags)
+
+	w = doRequest(t, server, "GET", "/api/v1/tags", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.True(t, report.Enforce)
+	require.Len(t, report.Registered, 3)
+	assert.Equal(t, "green", report.Registered[0].Color)
+	assert.Equal(t, taxonomy.Usage{Networks: 1, Allocations: 2}, report.Registered[0].Usage)
+	assert.Empty(t, report.Unregistered)
+
+	w = doRequest(t, server, "GET", "/api/v1/audit", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "tags_merged")
+	assert.Contains(t, w.Body.String(), "tag_renamed")
+
+	// The replacement must be a valid, registered tag
+	w = doRequest(t, server, "POST", "/api/v1/tags/rename", map[string]interface{}{"from": "env=prod", "to": "env=live"})
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"to"`)
+	w = doRequest(t, server, "POST", "/api/v1/tags/merge", map[string]interface{}{"from": []string{}, "to": "bad tag"})
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"from"`)
+	assert.Contains(t, w.Body.String(), "invalid tag")
+
+	// Tags the server interprets are neither retagged nor produced
+	for _, req := range []map[string]interface{}{
+		{"from": []string{"pci", "freeze=maintenance"}, "to": "site=ams"},
+		{"from": []string{"pci", "hold=abc"}, "to": "site=ams"},
+		{"from": []string{"reclaim-exempt"}, "to": "pci"},
+		{"from": []string{"mac"}, "to": "site"},
+	} {
+		w = doRequest(t, server, "POST", "/api/v1/tags/merge", req)
+		require.Equal(t, http.StatusUnprocessableEntity, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), "cannot be retagged")
+	}
+	for _, to := range []string{"freeze-until=2030-01-01T00:00:00Z", "gateway", "pool=a:10.0.0.1-10.0.0.2"} {
+		w = doRequest(t, server, "POST", "/api/v1/tags/rename", map[string]interface{}{"from": "pci", "to": to})
+		require.Equal(t, http.StatusUnprocessableEntity, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), `"field":"to"`)
+		assert.Contains(t, w.Body.String(), "cannot be retagged to")
+	}
+}
+
+func TestBulkUpdateAllocations(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.95.0.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	networkID := decodeObject(t, w)["id"].(string)
+	for _, host := range []string{"web-1", "web-2", "db-1"} {
+		w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{
+			"network_id": networkID, "hostname": host, "tags": []string{"env=prod", "owner=team-old"},
+		})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations/bulk-update", map[string]interface{}{
+		"selector": map[string]interface{}{"network_id": networkID, "hostname": "web-*"},
+		"patch":    map[string]interface{}{"add_tags": []string{"tier=frontend"}, "remove_tags": []string{"env=prod"}, "owner": "team-web"},
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"matched": 2, "updated": 2}`, w.Body.String())
+
+	w = doRequest(t, server, "GET", "/api/v1/allocations?network_id="+networkID, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var allocations []*ipam.IPAllocation
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &allocations))
+	tags := map[string][]string{}
+	for _, a := range allocations {
+		tags[a.Hostname] = a.Tags
+	}
+	assert.Equal(t, map[string][]string{
+		"web-1": {"tier=frontend", "owner=team-web"},
+		"web-2": {"tier=frontend", "owner=team-web"},
+		"db-1":  {"env=prod", "owner=team-old"},
+	}, tags)
+
+	// Allocations already patched count as matched but not updated
+	w = doRequest(t, server, "POST", "/api/v2/allocations/bulk-update", map[string]interface{}{
+		"selector": map[string]interface{}{"tags": []string{"owner=team-web"}},
+		"patch":    map[string]interface{}{"add_tags": []string{"tier=frontend"}},
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"matched": 2, "updated": 0}`, w.Body.String())
+
+	w = doRequest(t, server, "GET", "/api/v1/audit", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Updated 2 of 2 allocations matching network_id="+networkID+",hostname=web-*")
+
+	// The selector and patch must not be empty
+	w = doRequest(t, server, "POST", "/api/v1/allocations/bulk-update", map[string]interface{}{
+		"selector": map[string]interface{}{"hostname": "web-[", "status": "gone"},
+		"patch":    map[string]interface{}{"add_tags": []string{"bad tag"}, "owner": "a=b"},
+	})
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	for _, field := range []string{"selector.hostname", "selector.status", "patch.add_tags[0]", "patch.owner"} {
+		assert.Contains(t, w.Body.String(), `"`+field+`"`)
+	}
+	w = doRequest(t, server, "POST", "/api/v1/allocations/bulk-update", map[string]interface{}{})
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"selector"`)
+	assert.Contains(t, w.Body.String(), `"patch"`)
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations/bulk-update", map[string]interface{}{
+		"selector": map[string]interface{}{"network_id": "missing"},
+		"patch":    map[string]interface{}{"add_tags": []string{"pci"}},
+	})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHolds(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+	fake := clock.NewFake(time.Now())
+	server.SetClock(fake)
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.96.0.0/29"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	networkID := decodeObject(t, w)["id"].(string)
+
+	w = doRequest(t, server, "POST", "/api/v1/networks/"+networkID+"/hold", map[string]interface{}{"ttl": 120})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var hold holdResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &hold))
+	assert.Len(t, hold.Token, 32)
+	assert.Equal(t, "10.96.0.1", hold.IP)
+	require.NotNil(t, hold.ExpiresAt)
+
+	// The held address is not handed out to others
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, "10.96.0.2", decodeObject(t, w)["ip"])
+
+	// The token takes it over, keeping the hold's address and ID
+	w = doRequest(t, server, "POST", "/api/v2/networks/"+networkID+"/allocations", map[string]interface{}{
+		"hold_token": hold.Token, "hostname": "web-1", "tags": []string{"env=prod"},
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	alloc := decodeObject(t, w)
+	assert.Equal(t, "10.96.0.1", alloc["ip"])
+	assert.Equal(t, hold.AllocationID, alloc["id"])
+	assert.Equal(t, []interface{}{"env=prod"}, alloc["tags"])
+	assert.Nil(t, alloc["expires_at"])
+
+	// A hold is taken over once
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "hold_token": hold.Token})
+	require.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, CodeHoldNotFound, decodeObject(t, w)["code"])
+
+	// An expired hold cannot be taken over
+	w = doRequest(t, server, "POST", "/api/v1/networks/"+networkID+"/hold", map[string]interface{}{})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &hold))
+	assert.Equal(t, "10.96.0.3", hold.IP)
+	fake.Advance(defaultHoldSeconds*time.Second + time.Minute)
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"cidr": "10.96.0.0/29", "hold_token": hold.Token})
+	require.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, decodeObject(t, w)["message"], "expired")
+
+	w = doRequest(t, server, "GET", "/api/v1/audit", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "ip_held")
+	assert.Contains(t, w.Body.String(), "hold_converted")
+
+	w = doRequest(t, server, "POST", "/api/v1/networks/"+networkID+"/hold", map[string]interface{}{"ttl": -1})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "hold_token": hold.Token, "count": 2})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	w = doRequest(t, server, "POST", "/api/v1/networks/missing/hold", map[string]interface{}{})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHoldLapses(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+	fake := clock.NewFake(time.Now())
+	server.SetClock(fake)
+
+	// Pool allocations judge holds by the server's clock, as the token
+	// takeover does
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{
+		"cidr":  "10.96.1.0/29",
+		"pools": []map[string]string{{"name": "hosts", "first": "10.96.1.1", "last": "10.96.1.6"}},
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	networkID := decodeObject(t, w)["id"].(string)
+	network, err := server.store.GetNetwork(networkID)
+	require.NoError(t, err)
+
+	w = doRequest(t, server, "POST", "/api/v1/networks/"+networkID+"/hold", map[string]interface{}{"ttl": 120})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var hold holdResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &hold))
+	assert.Equal(t, "10.96.1.1", hold.IP)
+
+	// While the hold is live its address is refused
+	allocations, err := server.store.ListAllocations(networkID)
+	require.NoError(t, err)
+	_, err = store.CheckRequestedIP(network, allocations, hold.IP, fake.Now())
+	assert.ErrorContains(t, err, "is on hold until")
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "pool": "hosts"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, "10.96.1.2", decodeObject(t, w)["ip"])
+
+	// Once it lapses the address is free again, and the token is void
+	fake.Advance(121 * time.Second)
+	_, err = store.CheckRequestedIP(network, allocations, hold.IP, fake.Now())
+	assert.NoError(t, err)
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "hold_token": hold.Token})
+	require.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, CodeHoldNotFound, decodeObject(t, w)["code"])
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "pool": "hosts"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, "10.96.1.1", decodeObject(t, w)["ip"])
+}
+
+func TestNextFree(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.97.0.0/29"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	networkID := decodeObject(t, w)["id"].(string)
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	ips := func(resp nextFreeResponse) []string {
+		var ips []string
+		for _, a := range resp.Addresses {
+			ips = append(ips, a.IP)
+		}
+		return ips
+	}
+
+	// A preview commits nothing, so it can be repeated
+	for i := 0; i < 2; i++ {
+		w = doRequest(t, server, "GET", "/api/v1/networks/"+networkID+"/next-free?count=3", nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp nextFreeResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, []string{"10.97.0.2", "10.97.0.3", "10.97.0.4"}, ips(resp))
+		assert.Empty(t, resp.Addresses[0].Token)
+	}
+	w = doRequest(t, server, "GET", "/api/v1/networks/"+networkID+"/stats", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.EqualValues(t, 1, decodeObject(t, w)["allocated_ips"])
+
+	// POSTed, the addresses are held and their tokens take them over
+	w = doRequest(t, server, "POST", "/api/v2/networks/"+networkID+"/next-free?count=2&ttl=60", nil)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var held nextFreeResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &held))
+	assert.Equal(t, []string{"10.97.0.2", "10.97.0.3"}, ips(held))
+	require.NotEmpty(t, held.Addresses[1].Token)
+	assert.NotNil(t, held.Addresses[1].ExpiresAt)
+
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "hold_token": held.Addresses[1].Token})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, "10.97.0.3", decodeObject(t, w)["ip"])
+
+	// Only the addresses left are listed
+	w = doRequest(t, server, "GET", "/api/v1/networks/"+networkID+"/next-free?count=10", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp nextFreeResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, []string{"10.97.0.4", "10.97.0.5", "10.97.0.6"}, ips(resp))
+
+	w = doRequest(t, server, "GET", "/api/v1/networks/"+networkID+"/next-free?count=0&ttl=x", nil)
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"count"`)
+	assert.Contains(t, w.Body.String(), `"ttl"`)
+	w = doRequest(t, server, "GET", "/api/v1/networks/missing/next-free", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAutoCreateNetworks(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	// Off by default
+	w := doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"cidr": "10.98.0.0/24"})
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+	server.SetAutoCreateNetworks(true)
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"cidr": "10.98.0.7/24"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	networkID := decodeObject(t, w)["network_id"].(string)
+
+	w = doRequest(t, server, "GET", "/api/v1/networks/"+networkID, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	network := decodeObject(t, w)
+	assert.Equal(t, "10.98.0.0/24", network["cidr"])
+	assert.Equal(t, []interface{}{store.AutoCreatedTag}, network["tags"])
+
+	// Later allocations by the same CIDR use the network
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"cidr": "10.98.0.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, networkID, decodeObject(t, w)["network_id"])
+	w = doRequest(t, server, "GET", "/api/v1/networks", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, decodeArray(t, w), 1)
+
+	w = doRequest(t, server, "GET", "/api/v1/audit", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "network_auto_created")
+
+	// A network ID is never created
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": "missing"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestPointToPointPolicy(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.99.0.0/31", "tags": []string{"point-to-point=on"}})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	// Larger networks cannot opt in
+	w = doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.99.1.0/30", "tags": []string{"point-to-point=on"}})
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "requires a /31")
+
+	w = doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "2001:db8:99::/64"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	networkID := decodeObject(t, w)["id"].(string)
+	w = doRequest(t, server, "PATCH", "/api/v2/networks/"+networkID, map[string]interface{}{"tags": []string{"point-to-point=on"}})
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "requires a /127")
+
+	w = doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.99.2.0/31", "tags": []string{"point-to-point=maybe"}})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestNetworkStatsBreakdown(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.96.0.0/28"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	networkID := decodeObject(t, w)["id"].(string)
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "tags": []string{"gateway"}})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	w = doRequest(t, server, "POST", "/api/v1/networks/"+networkID+"/hold", map[string]interface{}{})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "count": 2})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	for _, version := range []string{"v1", "v2"} {
+		w = doRequest(t, server, "GET", "/api/"+version+"/networks/"+networkID+"/stats", nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		stats := decodeObject(t, w)
+		assert.EqualValues(t, 16, stats["total_ips"])
+		assert.Equal(t, map[string]interface{}{
+			"total": 16.0, "network": 1.0, "broadcast": 1.0, "gateway": 1.0,
+			"reserved_ranges": 0.0, "holds": 1.0, "allocated": 2.0, "expired": 0.0, "free": 10.0,
+		}, stats["breakdown"])
+		assert.EqualValues(t, 0, stats["expired_ips"])
+	}
+
+	// Once their TTL passes, allocations count as expired until reaped
+	fake := clock.NewFake(time.Now())
+	server.SetClock(fake)
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID, "ttl": 60})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	fake.Advance(2 * time.Minute)
+	w = doRequest(t, server, "GET", "/api/v1/networks/"+networkID+"/stats", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	stats := decodeObject(t, w)
+	assert.EqualValues(t, 1, stats["expired_ips"])
+	breakdown := stats["breakdown"].(map[string]interface{})
+	assert.EqualValues(t, 1, breakdown["expired"])
+	assert.EqualValues(t, 9, breakdown["free"])
+}
+
+func TestAuditExport(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.95.0.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"cidr": "10.95.0.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	w = doRequest(t, server, "GET", "/api/v1/audit/export", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	require.NotEmpty(t, lines)
+	var first ipam.AuditEntry
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+
+	w = doRequest(t, server, "GET", "/api/v2/audit-entries/export?format=csv", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(w.Body.String(), "id,timestamp,action,resource,details,user\n"))
+	assert.Len(t, strings.Split(strings.TrimSpace(w.Body.String()), "\n"), len(lines)+1)
+
+	w = doRequest(t, server, "GET", "/api/v1/audit/export?since="+url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339)), nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	w = doRequest(t, server, "GET", "/api/v1/audit/export?format=xml&since=yesterday", nil)
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"format"`)
+	assert.Contains(t, w.Body.String(), `"since"`)
+}
+
+func TestRequestID(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.94.0.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Regexp(t, `^[0-9a-f]{32}$`, w.Header().Get(RequestIDHeader))
+	networkID := decodeObject(t, w)["id"].(string)
+
+	send := func(method, path, id string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(RequestIDHeader, id)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+	audit := func() string {
+		w := doRequest(t, server, "GET", "/api/v1/audit?limit=0", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	// A caller's ID is returned and carried by the audit entries
+	w = send("POST", "/api/v1/networks/"+networkID+"/freeze", "change-4711", `{"reason": "maintenance"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "change-4711", w.Header().Get(RequestIDHeader))
+	assert.Contains(t, audit(), "Froze network 10.94.0.0/24: maintenance request_id=change-4711")
+
+	// Including those of rejected requests
+	w = send("POST", "/api/v1/allocations", "change-4712", `{"network_id": "`+networkID+`"}`)
+	require.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	assert.Equal(t, "change-4712", w.Header().Get(RequestIDHeader))
+	assert.Contains(t, audit(), "request_id=change-4712")
+
+	// An unusable ID is replaced
+	w = send("GET", "/api/v1/networks", "bad id\tvalue", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Regexp(t, `^[0-9a-f]{32}$`, w.Header().Get(RequestIDHeader))
+}
+
+func TestListAllocationsAcrossNetworks(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+	fake := clock.NewFake(time.Now())
+	server.SetClock(fake)
+
+	var want []string
+	for i := 0; i < 2*maxListWorkers+3; i++ {
+		cidr := fmt.Sprintf("10.93.%d.0/24", i)
+		w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": cidr})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		for j := 0; j < 2; j++ {
+			w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"cidr": cidr})
+			require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+			want = append(want, decodeObject(t, w)["ip"].(string))
+		}
+	}
+	ips := func() []string {
+		w := doRequest(t, server, "GET", "/api/v1/allocations", nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var ips []string
+		for _, alloc := range decodeArray(t, w) {
+			ips = append(ips, alloc["ip"].(string))
+		}
+		return ips
+	}
+	assert.ElementsMatch(t, want, ips())
+
+	// Networks added through the API are listed at once
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.93.100.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	networkID := decodeObject(t, w)["id"].(string)
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": networkID})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Contains(t, ips(), decodeObject(t, w)["ip"])
+
+	// Networks added behind the API's back once the cache expires
+	require.NoError(t, server.store.SaveNetwork(&ipam.Network{ID: "direct", CIDR: "10.93.200.0/24"}))
+	require.NoError(t, server.store.SaveAllocation(&ipam.IPAllocation{ID: "direct-1", NetworkID: "direct", IP: "10.93.200.1", AllocatedAt: fake.Now()}))
+	assert.NotContains(t, ips(), "10.93.200.1")
+	fake.Advance(networkCacheTTL)
+	assert.Contains(t, ips(), "10.93.200.1")
+}
+
+func TestAuditWriter(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+	writer := auditlog.NewWriter(server.store, 0)
+	defer writer.Close()
+	server.SetAuditWriter(writer)
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "bogus"})
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code, w.Body.String())
+
+	// Entries saved in the background are listed and exported right away
+	w = doRequest(t, server, "GET", "/api/v1/audit", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "request_failed")
+	w = doRequest(t, server, "GET", "/api/v2/audit-entries/export", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "request_failed")
+}
+
+func TestEvents(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	for i, action := range []string{"allocate", "release", "allocate"} {
+		require.NoError(t, server.store.SaveAuditEntry(&ipam.AuditEntry{
+			ID: fmt.Sprintf("e%d", i), Timestamp: start.Add(time.Duration(i) * time.Minute), Action: action,
+		}))
+	}
+	list := func(path string) eventsResponse {
+		w := doRequest(t, server, "GET", path, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp eventsResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		return resp
+	}
+
+	page := list("/api/v1/events?limit=2")
+	require.Len(t, page.Events, 2)
+	assert.Equal(t, uint64(1), page.Events[0].Seq)
+	assert.Equal(t, "e0", page.Events[0].ID)
+	assert.Equal(t, "release", page.Events[1].Action)
+	assert.Equal(t, uint64(2), page.LastSeq)
+	assert.True(t, page.HasMore)
+
+	page = list("/api/v2/events?since_seq=2")
+	require.Len(t, page.Events, 1)
+	assert.Equal(t, uint64(3), page.Events[0].Seq)
+	assert.Equal(t, uint64(3), page.LastSeq)
+	assert.False(t, page.HasMore)
+
+	// Caught up: nothing new, and the position is kept
+	w := doRequest(t, server, "GET", "/api/v1/events?since_seq=3", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"events":[],"last_seq":3,"has_more":false}`, w.Body.String())
+
+	// Entries queued in the background are numbered before listing
+	writer := auditlog.NewWriter(server.store, 0)
+	defer writer.Close()
+	server.SetAuditWriter(writer)
+	w = doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "bogus"})
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	page = list("/api/v1/events?since_seq=3")
+	require.NotEmpty(t, page.Events)
+	assert.Equal(t, uint64(4), page.Events[0].Seq)
+	assert.Equal(t, "request_failed", page.Events[len(page.Events)-1].Action)
+
+	for _, query := range []string{"since_seq=-1", "since_seq=x", "limit=0", "limit=1001"} {
+		w := doRequest(t, server, "GET", "/api/v1/events?"+query, nil)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code, query)
+	}
+
+	// Events about networks the caller may not view are skipped, but the
+	// position moves past them. sha256("ops") starts a92c36e6.
+	w = doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.95.0.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	networkID := decodeObject(t, w)["id"].(string)
+	w = doRequest(t, server, "PUT", "/api/v1/networks/"+networkID+"/acl", map[string]interface{}{
+		"acl": map[string][]string{"admin": {"token:a92c36e6"}}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{"cidr": "10.95.1.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	openID := decodeObject(t, w)["id"].(string)
+	server.SetAuthTokens([]string{"ops", "lab"})
+	listAs := func(token string) eventsResponse {
+		req := httptest.NewRequest("GET", "/api/v1/events?since_seq=3", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp eventsResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		return resp
+	}
+	resources := func(page eventsResponse) []string {
+		var ids []string
+		for _, event := range page.Events {
+			ids = append(ids, event.Resource)
+		}
+		return ids
+	}
+	all := listAs("ops")
+	assert.Contains(t, resources(all), networkID)
+	assert.Contains(t, resources(all), openID)
+	page = listAs("lab")
+	assert.NotContains(t, resources(page), networkID)
+	assert.Contains(t, resources(page), openID)
+	assert.Equal(t, all.LastSeq, page.LastSeq)
+}
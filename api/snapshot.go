@@ -0,0 +1,36 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"github.com/jeremyhahn/go-ipam/pkg/snapshot"
+)
+
+// exportSnapshot returns every network and allocation, released ones
+// included, in the export format, for replicas to pull in one request. The
+// ETag covers the networks and allocations but not the export time, so a
+// replica polling an unchanged server gets 304 Not Modified.
+func (s *Server) exportSnapshot(w http.ResponseWriter, r *http.Request) {
+	snap, err := snapshot.FromStore(s.store, s.clock.Now())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	content, err := json.Marshal([]interface{}{snap.Networks, snap.Allocations})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	sum := sha256.Sum256(content)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	json.NewEncoder(w).Encode(snap)
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// carveSubnet creates a child network from the lowest free sub-prefix of the
+// requested length. IPv6 lengths must be nibble aligned.
+func (s *Server) carveSubnet(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var req struct {
+		PrefixLength int      `json:"prefix_length"`
+		Description  string   `json:"description"`
+		Tags         []string `json:"tags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	var errs fieldErrors
+	validateDescription(&errs, req.Description)
+	validateTags(&errs, req.Tags)
+	rejectACLTags(&errs, "tags", req.Tags)
+	s.checkTags(&errs, req.Tags)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	parent, err := s.store.GetNetwork(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	networks, err := s.store.ListNetworks()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	allocations, err := s.store.ListAllocations(parent.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	subnet, err := store.NextSubnet(parent, req.PrefixLength, networks, allocations, s.clock.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoFreeSubnet):
+			writeError(w, http.StatusConflict, err)
+		case errors.Is(err, store.ErrSubnetLength), errors.Is(err, store.ErrNotNibbleAligned):
+			errs.add("prefix_length", "%v", err)
+			writeValidationErrors(w, errs)
+		default:
+			writeError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	if validatePointToPoint(&errs, subnet.String(), req.Tags); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	// The subnet stays under its parent's ACL; only the parent's admins can
+	// change it through the ACL endpoint
+	tags := store.ACLTags(req.Tags, store.NetworkACL(parent))
+
+	network, err := s.ipam.AddNetwork(subnet.String(), req.Description, tags)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.invalidateNetworks()
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(network)
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/jeremyhahn/go-ipam/pkg/taxonomy"
+)
+
+// maxMergeTags bounds the tags one merge folds together
+const maxMergeTags = 64
+
+// SetTagRegistry configures the registry of allowed tags. When it is
+// enforced, new and updated networks and allocations may only carry tags it
+// allows.
+func (s *Server) SetTagRegistry(r *taxonomy.Registry) {
+	s.tagRegistry = r
+}
+
+// checkTags adds an error for each tag an enforced registry does not allow
+func (s *Server) checkTags(errs *fieldErrors, tags []string) {
+	for i, tag := range tags {
+		if err := s.tagRegistry.Check(tag); err != nil {
+			errs.add(fmt.Sprintf("tags[%d]", i), "%v", err)
+		}
+	}
+}
+
+// listTags returns the registry with how many networks and allocations the
+// caller may view carry each entry, and the tags in use that it does not
+// list
+func (s *Server) listTags(w http.ResponseWriter, r *http.Request) {
+	networks, err := s.store.ListNetworks()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	networks = s.visibleNetworks(r, networks)
+	var allocations []*ipam.IPAllocation
+	for _, n := range networks {
+		list, err := s.store.ListAllocations(n.ID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		allocations = append(allocations, list...)
+	}
+
+	json.NewEncoder(w).Encode(s.tagRegistry.BuildReport(networks, allocations))
+}
+
+// retagResponse counts the records a rename or merge changed
+type retagResponse struct {
+	From []string `json:"from"`
+	To   string   `json:"to"`
+	taxonomy.Usage
+}
+
+// renameTag replaces one tag with another on every network and allocation
+func (s *Server) renameTag(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	var errs fieldErrors
+	if req.From == "" {
+		errs.add("from", "is required")
+	}
+	s.validateRetag(&errs, []string{req.From}, req.To)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	s.retag(w, r, "tag_renamed", []string{req.From}, req.To)
+}
+
+// mergeTags replaces several tags with one on every network and allocation
+func (s *Server) mergeTags(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		From []string `json:"from"`
+		To   string   `json:"to"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	var errs fieldErrors
+	if len(req.From) == 0 || len(req.From) > maxMergeTags {
+		errs.add("from", "must list between 1 and %d tags", maxMergeTags)
+	}
+	s.validateRetag(&errs, req.From, req.To)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	s.retag(w, r, "tags_merged", req.From, req.To)
+}
+
+// validateRetag checks the tags a rename or merge replaces and the tag
+// replacing them, which an enforced registry must allow. Neither may be a
+// tag the server interprets, such as a freeze, an ACL or a hold, which
+// retagging would turn on or off behind the endpoints managing it.
+func (s *Server) validateRetag(errs *fieldErrors, from []string, to string) {
+	for i, tag := range from {
+		switch {
+		case tag != "" && !tagPattern.MatchString(tag):
+			errs.add(fmt.Sprintf("from[%d]", i), "invalid tag %q", tag)
+		case !store.IsACLTag(tag) && (store.IsSystemTag(tag) || taxonomy.IsReserved(tag)):
+			errs.add(fmt.Sprintf("from[%d]", i), "%q is interpreted by the server and cannot be retagged", tag)
+		}
+	}
+	rejectACLTags(errs, "from", from)
+	switch {
+	case to == "":
+		errs.add("to", "is required")
+	case !tagPattern.MatchString(to):
+		errs.add("to", "invalid tag %q: use letters, digits and . _ : / = - (max 63 characters)", to)
+	case store.IsACLTag(to):
+		errs.add("to", "network ACLs are changed through /networks/{id}/acl")
+	case store.IsSystemTag(to) || taxonomy.IsReserved(to):
+		errs.add("to", "%q is interpreted by the server and cannot be retagged to", to)
+	default:
+		if err := s.tagRegistry.Check(to); err != nil {
+			errs.add("to", "%v", err)
+		}
+	}
+}
+
+// retag saves the retagged records in one atomic write, preparing them
+// again if other writes changed the records meanwhile, and audits it
+func (s *Server) retag(w http.ResponseWriter, r *http.Request, action string, from []string, to string) {
+	applier, ok := s.store.(batchApplier)
+	if !ok {
+		writeErrorCode(w, http.StatusNotImplemented, CodeInternal, "store does not support retagging", nil)
+		return
+	}
+
+	var changed taxonomy.Usage
+	var err error
+	for attempt := 0; attempt < transactionAttempts; attempt++ {
+		var batch *store.Batch
+		if batch, changed, err = taxonomy.Retag(s.store, from, to, s.clock.Now()); err != nil {
+			break
+		}
+		if changed.Networks+changed.Allocations == 0 {
+			break
+		}
+		if err = applier.ApplyBatch(batch); !errors.Is(err, store.ErrBatchConflict) {
+			break
+		}
+	}
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+
+	s.recordAudit(r, action, to, fmt.Sprintf("Replaced %s with %s on %d networks and %d allocations",
+		strings.Join(from, ", "), to, changed.Networks, changed.Allocations))
+
+	json.NewEncoder(w).Encode(&retagResponse{From: from, To: to, Usage: changed})
+}
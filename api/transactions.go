@@ -0,0 +1,204 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// Transaction operations
+const (
+	opCreateNetwork = "create_network"
+	opReserveRange  = "reserve_range"
+	opAllocate      = "allocate"
+)
+
+var transactionOps = []string{opCreateNetwork, opReserveRange, opAllocate}
+
+// maxTransactionOperations bounds the operations of one transaction
+const maxTransactionOperations = 100
+
+// transactionAttempts is how many times a transaction is prepared when
+// other writes keep changing the records it was prepared from
+const transactionAttempts = 3
+
+// batchApplier is implemented by stores that can save a batch of records
+// all at once
+type batchApplier interface {
+	ApplyBatch(b *store.Batch) error
+}
+
+// transactionOperation is one step of a transaction. create_network uses
+// cidr, description and tags. reserve_range allocates count contiguous
+// addresses as one allocation, and allocate makes count (default 1)
+// single-address allocations; both name their network by network_id or
+// cidr, which may be that of a network created earlier in the transaction.
+type transactionOperation struct {
+	Op string `json:"op"`
+	ipam.AllocationRequest
+}
+
+// transactionResult is what one operation created
+type transactionResult struct {
+	Op          string               `json:"op"`
+	Network     *ipam.Network        `json:"network,omitempty"`
+	Allocations []*ipam.IPAllocation `json:"allocations,omitempty"`
+}
+
+// transactionResponse lists the operations' results in request order
+type transactionResponse struct {
+	Results []*transactionResult `json:"results"`
+}
+
+// createTransaction applies an ordered list of operations all-or-nothing.
+// The operations run against a staged copy of the store, so a later one
+// sees what earlier ones created, and their records are saved in one
+// atomic write: a Pebble batch or a single Raft proposal. If any operation
+// fails nothing is saved.
+func (s *Server) createTransaction(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Operations []transactionOperation `json:"operations"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	if errs := s.validateTransaction(req.Operations); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	applier, ok := s.store.(batchApplier)
+	if !ok {
+		writeErrorCode(w, http.StatusNotImplemented, CodeInternal, "store does not support transactions", nil)
+		return
+	}
+
+	for i := range req.Operations {
+		op := &req.Operations[i]
+		if op.Op == opCreateNetwork {
+			continue
+		}
+		if s.rejectDenied(w, r, op.NetworkID, op.CIDR, store.PermissionAllocate) ||
+			s.rejectFrozen(w, op.NetworkID, op.CIDR) || s.rejectUnapproved(w, r, &op.AllocationRequest) {
+			return
+		}
+	}
+
+	var results []*transactionResult
+	var err error
+	for attempt := 0; attempt < transactionAttempts; attempt++ {
+		var batch *store.Batch
+		results, batch, err = s.stageTransaction(r.Context(), req.Operations)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err)
+			return
+		}
+		if err = applier.ApplyBatch(batch); !errors.Is(err, store.ErrBatchConflict) {
+			break
+		}
+	}
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+	s.invalidateNetworks()
+
+	// Post hooks only see allocations that were saved
+	allocations := 0
+	for _, result := range results {
+		for i, alloc := range result.Allocations {
+			result.Allocations[i] = s.hooks.PostAllocate(r.Context(), s.store, alloc)
+		}
+		allocations += len(result.Allocations)
+	}
+	s.recordAudit(r, "transaction_applied", "transaction",
+		fmt.Sprintf("Applied %d operations creating %d allocations", len(results), allocations))
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&transactionResponse{Results: results})
+}
+
+// stageTransaction runs ops against a staging copy of the store and returns
+// their results and the batch that saves them
+func (s *Server) stageTransaction(ctx context.Context, ops []transactionOperation) ([]*transactionResult, *store.Batch, error) {
+	staging := store.NewStaging(s.store)
+	engine := ipam.New(staging)
+
+	results := make([]*transactionResult, len(ops))
+	for i, op := range ops {
+		result := &transactionResult{Op: op.Op}
+		allocate := func(req ipam.AllocationRequest) error {
+			req.Tags = append([]string(nil), req.Tags...)
+			if err := s.hooks.PreAllocate(ctx, &req); err != nil {
+				return err
+			}
+			alloc, err := engine.AllocateIP(&req)
+			if err != nil {
+				return err
+			}
+			result.Allocations = append(result.Allocations, alloc)
+			return nil
+		}
+
+		var err error
+		switch op.Op {
+		case opCreateNetwork:
+			result.Network, err = engine.AddNetwork(op.CIDR, op.Description, op.Tags)
+		case opReserveRange:
+			err = allocate(op.AllocationRequest)
+		case opAllocate:
+			single := op.AllocationRequest
+			single.Count = 1
+			for n := 0; n < max(op.Count, 1) && err == nil; n++ {
+				err = allocate(single)
+			}
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("operations[%d]: %w", i, err)
+		}
+		results[i] = result
+	}
+	return results, staging.Batch(), nil
+}
+
+// validateTransaction checks every operation, reporting fields as
+// operations[i].field
+func (s *Server) validateTransaction(ops []transactionOperation) fieldErrors {
+	var errs fieldErrors
+	if len(ops) == 0 || len(ops) > maxTransactionOperations {
+		errs.add("operations", "must list between 1 and %d operations", maxTransactionOperations)
+		return errs
+	}
+
+	for i := range ops {
+		op := &ops[i]
+		var opErrs fieldErrors
+		switch op.Op {
+		case opCreateNetwork:
+			opErrs = validateNetworkRequest(op.CIDR, op.Description, op.Tags)
+			rejectACLTags(&opErrs, "tags", op.Tags)
+		case opReserveRange:
+			opErrs = validateAllocationRequest(&op.AllocationRequest)
+			if op.Count < 1 {
+				opErrs.add("count", "is required")
+			}
+		case opAllocate:
+			opErrs = validateAllocationRequest(&op.AllocationRequest)
+		default:
+			errs.add(fmt.Sprintf("operations[%d].op", i), "must be one of %s", strings.Join(transactionOps, ", "))
+		}
+		s.checkTags(&opErrs, op.Tags)
+		for _, e := range opErrs {
+			errs.add(fmt.Sprintf("operations[%d].%s", i, e.Field), "%s", e.Message)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// allocationMover is implemented by stores that can reassign an allocation
+// to another network in place
+type allocationMover interface {
+	MoveAllocation(id, networkID string) error
+}
+
+// transferAllocation moves an allocation to another network that contains
+// its addresses, preserving the allocation ID and history
+func (s *Server) transferAllocation(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var req struct {
+		NetworkID string `json:"network_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	var errs fieldErrors
+	if req.NetworkID == "" {
+		errs.add("network_id", "is required")
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	mover, ok := s.store.(allocationMover)
+	if !ok {
+		writeErrorCode(w, http.StatusNotImplemented, CodeInternal, "store does not support allocation transfer", nil)
+		return
+	}
+
+	allocation, err := s.store.GetAllocation(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	target, err := s.store.GetNetwork(req.NetworkID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	if s.rejectDenied(w, r, target.ID, "", store.PermissionAllocate) ||
+		s.rejectFrozen(w, allocation.NetworkID, "") || s.rejectFrozen(w, target.ID, "") {
+		return
+	}
+
+	targetAllocations, err := s.store.ListAllocations(target.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := store.CheckTransfer(allocation, target, targetAllocations, s.clock.Now()); err != nil {
+		switch {
+		case errors.Is(err, store.ErrTransferConflict):
+			writeError(w, http.StatusConflict, err)
+		case errors.Is(err, store.ErrTransferSameNetwork), errors.Is(err, store.ErrTransferOutOfRange):
+			errs.add("network_id", "%v", err)
+			writeValidationErrors(w, errs)
+		default:
+			writeError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	sourceID := allocation.NetworkID
+	if err := mover.MoveAllocation(id, target.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.recordAudit(r, "allocation_transferred", id,
+		fmt.Sprintf("Transferred %s from network %s to %s", allocation.IP, sourceID, target.ID))
+
+	allocation, err = s.store.GetAllocation(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(allocation)
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The v1 API is frozen. These tests pin the routes, status codes and JSON
+// field names existing automation depends on; changes belong in v2.
+
+func doRequest(t *testing.T, server *Server, method, path string, body interface{}) *httptest.ResponseRecorder {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	return w
+}
+
+func decodeObject(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	var obj map[string]interface{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&obj), w.Body.String())
+	return obj
+}
+
+func decodeArray(t *testing.T, w *httptest.ResponseRecorder) []map[string]interface{} {
+	var arr []map[string]interface{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&arr), w.Body.String())
+	return arr
+}
+
+func assertKeys(t *testing.T, obj map[string]interface{}, keys ...string) {
+	for _, key := range keys {
+		assert.Contains(t, obj, key)
+	}
+}
+
+func TestV1Contract(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	networkKeys := []string{"id", "cidr", "description", "tags", "created_at"}
+	allocationKeys := []string{"id", "network_id", "ip", "status", "allocated_at"}
+
+	// POST /networks -> 201 with a bare network object
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{
+		"cidr":        "10.60.0.0/24",
+		"description": "contract",
+		"tags":        []string{"v1"},
+	})
+	require.Equal(t, http.StatusCreated, w.Code)
+	network := decodeObject(t, w)
+	assertKeys(t, network, networkKeys...)
+	assert.NotContains(t, network, "_links")
+	networkID := network["id"].(string)
+
+	// GET /networks -> bare array
+	w = doRequest(t, server, "GET", "/api/v1/networks", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	networks := decodeArray(t, w)
+	require.Len(t, networks, 1)
+	assertKeys(t, networks[0], networkKeys...)
+
+	// GET /networks/{id}
+	w = doRequest(t, server, "GET", "/api/v1/networks/"+networkID, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assertKeys(t, decodeObject(t, w), networkKeys...)
+
+	// GET /networks/{id}/stats
+	w = doRequest(t, server, "GET", "/api/v1/networks/"+networkID+"/stats", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assertKeys(t, decodeObject(t, w), "total_ips", "allocated_ips", "available_ips", "utilization_percent")
+
+	// POST /allocations -> 201 with a bare allocation object
+	w = doRequest(t, server, "POST", "/api/v1/allocations", map[string]interface{}{
+		"network_id": networkID,
+		"hostname":   "contract-host",
+	})
+	require.Equal(t, http.StatusCreated, w.Code)
+	allocation := decodeObject(t, w)
+	assertKeys(t, allocation, allocationKeys...)
+	allocationID := allocation["id"].(string)
+
+	// GET /allocations -> bare array, filterable by network_id
+	w = doRequest(t, server, "GET", "/api/v1/allocations?network_id="+networkID, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	allocations := decodeArray(t, w)
+	require.Len(t, allocations, 1)
+	assertKeys(t, allocations[0], allocationKeys...)
+
+	// GET /allocations/{id}
+	w = doRequest(t, server, "GET", "/api/v1/allocations/"+allocationID, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assertKeys(t, decodeObject(t, w), allocationKeys...)
+
+	// DELETE /networks/{id} with active allocations -> 409
+	w = doRequest(t, server, "DELETE", "/api/v1/networks/"+networkID, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// POST /allocations/{id}/release -> 204
+	w = doRequest(t, server, "POST", "/api/v1/allocations/"+allocationID+"/release", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	// Released allocations only show with all=true
+	w = doRequest(t, server, "GET", "/api/v1/allocations", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "null\n", w.Body.String())
+
+	w = doRequest(t, server, "GET", "/api/v1/allocations?all=true", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, decodeArray(t, w), 1)
+
+	// GET /audit -> bare array honoring limit
+	w = doRequest(t, server, "GET", "/api/v1/audit?limit=1", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, decodeArray(t, w), 1)
+
+	// GET /health
+	w = doRequest(t, server, "GET", "/api/v1/health", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assertKeys(t, decodeObject(t, w), "status", "service", "cluster_mode")
+
+	// DELETE /networks/{id} -> 204
+	w = doRequest(t, server, "DELETE", "/api/v1/networks/"+networkID, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	// v1 has no PATCH
+	w = doRequest(t, server, "PATCH", "/api/v1/networks/"+networkID, map[string]interface{}{})
+	assert.NotEqual(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,456 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// API v2 uses plural resource names throughout, PATCH for partial updates,
+// paginated list envelopes and embedded _links. v1 stays frozen.
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// Links holds hypermedia links embedded in v2 resources
+type Links map[string]string
+
+// Page is the envelope returned by every v2 list endpoint. Total is omitted
+// when the backing store cannot count the collection cheaply.
+type Page struct {
+	Items      interface{} `json:"items"`
+	Total      *int        `json:"total,omitempty"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	Links      Links       `json:"_links"`
+}
+
+// NetworkResource is a network with the settings hosts in it need and its
+// v2 links
+type NetworkResource struct {
+	*ipam.Network
+	*store.Settings
+	Links Links `json:"_links"`
+}
+
+// AllocationResource is an allocation with its v2 links and, when fetched
+// or created alone, the settings of its network and its DHCP options
+type AllocationResource struct {
+	*ipam.IPAllocation
+	*store.Settings
+	Links Links `json:"_links"`
+}
+
+// networkPatch lists the network fields a PATCH may change; nil means unchanged
+type networkPatch struct {
+	Description *string   `json:"description"`
+	Tags        *[]string `json:"tags"`
+}
+
+// allocationPatch lists the allocation fields a PATCH may change
+type allocationPatch struct {
+	Hostname    *string   `json:"hostname"`
+	Description *string   `json:"description"`
+	Tags        *[]string `json:"tags"`
+}
+
+func (s *Server) setupV2Routes() {
+	v2 := s.router.PathPrefix("/api/v2").Subrouter()
+	v2.Use(jsonMiddleware, s.auditFailures, s.enforceACL)
+
+	v2.HandleFunc("/networks", s.v2ListNetworks).Methods("GET")
+	v2.HandleFunc("/networks", s.createNetwork).Methods("POST")
+	v2.HandleFunc("/networks/{id}", s.v2GetNetwork).Methods("GET")
+	v2.HandleFunc("/networks/{id}", s.v2PatchNetwork).Methods("PATCH")
+	v2.HandleFunc("/networks/{id}", s.deleteNetwork).Methods("DELETE")
+	v2.HandleFunc("/networks/{id}/stats", s.getNetworkStats).Methods("GET")
+	v2.HandleFunc("/networks/{id}/allocations", s.v2ListNetworkAllocations).Methods("GET")
+	v2.HandleFunc("/networks/{id}/allocations", s.v2AllocateInNetwork).Methods("POST")
+	v2.HandleFunc("/networks/{id}/allocations/count", s.countAllocations).Methods("GET")
+	v2.HandleFunc("/networks/{id}/ptr-zone", s.getPTRZone).Methods("GET")
+	v2.HandleFunc("/networks/{id}/reconcile", s.reconcileNetwork).Methods("POST")
+	v2.HandleFunc("/networks/{id}/subnets", s.carveSubnet).Methods("POST")
+	v2.HandleFunc("/networks/{id}/freeze", s.freezeNetwork).Methods("POST")
+	v2.HandleFunc("/networks/{id}/freeze", s.unfreezeNetwork).Methods("DELETE")
+	v2.HandleFunc("/networks/{id}/acl", s.getNetworkACL).Methods("GET")
+	v2.HandleFunc("/networks/{id}/acl", s.setNetworkACL).Methods("PUT")
+	v2.HandleFunc("/networks/{id}/hold", s.createHold).Methods("POST")
+	v2.HandleFunc("/networks/{id}/next-free", s.nextFree).Methods("GET", "POST")
+
+	v2.HandleFunc("/allocations", s.v2ListAllocations).Methods("GET")
+	v2.HandleFunc("/allocations/bulk-update", s.bulkUpdateAllocations).Methods("POST")
+	v2.HandleFunc("/allocations/{id}", s.v2GetAllocation).Methods("GET")
+	v2.HandleFunc("/allocations/{id}", s.v2PatchAllocation).Methods("PATCH")
+	v2.HandleFunc("/allocations/{id}", s.releaseIP).Methods("DELETE")
+	v2.HandleFunc("/allocations/{id}/transfer", s.transferAllocation).Methods("POST")
+	v2.HandleFunc("/allocations/{id}/cloud-init", s.getCloudInit).Methods("GET")
+
+	v2.HandleFunc("/transactions", s.createTransaction).Methods("POST")
+
+	v2.HandleFunc("/groups", s.createGroup).Methods("POST")
+	v2.HandleFunc("/groups/{id}", s.getGroup).Methods("GET")
+	v2.HandleFunc("/groups/{id}", s.releaseGroup).Methods("DELETE")
+
+	v2.HandleFunc("/locate", s.locateIP).Methods("GET")
+	v2.HandleFunc("/check", s.checkIPs).Methods("POST")
+	v2.HandleFunc("/neighbors", s.ingestNeighbors).Methods("POST")
+	v2.HandleFunc("/reports/capacity", s.capacityReport).Methods("GET")
+	v2.HandleFunc("/stats", s.groupedStats).Methods("GET")
+	v2.HandleFunc("/tags", s.listTags).Methods("GET")
+	v2.HandleFunc("/tags/rename", s.renameTag).Methods("POST")
+	v2.HandleFunc("/tags/merge", s.mergeTags).Methods("POST")
+	v2.HandleFunc("/audit-entries", s.v2ListAuditEntries).Methods("GET")
+	v2.HandleFunc("/audit-entries/export", s.exportAuditEntries).Methods("GET")
+	v2.HandleFunc("/events", s.listEvents).Methods("GET")
+	v2.HandleFunc("/snapshot", s.exportSnapshot).Methods("GET")
+	v2.HandleFunc("/health", s.healthCheck).Methods("GET")
+}
+
+func networkLinks(id string) Links {
+	return Links{
+		"self":        "/api/v2/networks/" + id,
+		"stats":       "/api/v2/networks/" + id + "/stats",
+		"allocations": "/api/v2/networks/" + id + "/allocations",
+	}
+}
+
+func allocationLinks(alloc *ipam.IPAllocation) Links {
+	return Links{
+		"self":    "/api/v2/allocations/" + alloc.ID,
+		"network": "/api/v2/networks/" + alloc.NetworkID,
+	}
+}
+
+// parsePage reads the limit and cursor query parameters
+func parsePage(r *http.Request) (offset, limit int, err error) {
+	limit = defaultPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+	}
+
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		raw, decErr := base64.RawURLEncoding.DecodeString(v)
+		if decErr == nil {
+			offset, decErr = strconv.Atoi(string(raw))
+		}
+		if decErr != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid cursor")
+		}
+	}
+
+	return offset, limit, nil
+}
+
+// newPage slices total items [offset, offset+limit) into a Page with links
+// for the current and next page
+func newPage(r *http.Request, total, offset, limit int, items func(start, end int) interface{}) *Page {
+	start := offset
+	if start > total {
+		start = total
+	}
+	end := start + limit
+	if end > total {
+		end = total
+	}
+
+	page := &Page{
+		Items: items(start, end),
+		Total: &total,
+		Links: Links{"self": r.URL.RequestURI()},
+	}
+
+	if end < total {
+		page.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(end)))
+		q := r.URL.Query()
+		q.Set("cursor", page.NextCursor)
+		q.Set("limit", strconv.Itoa(limit))
+		page.Links["next"] = (&url.URL{Path: r.URL.Path, RawQuery: q.Encode()}).RequestURI()
+	}
+
+	return page
+}
+
+func (s *Server) v2ListNetworks(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := parsePage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter, errs := parseNetworkFilter(r)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	networks, err := s.findNetworks(r, filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSONWithETag(w, r, newPage(r, len(networks), offset, limit, func(start, end int) interface{} {
+		items := make([]*NetworkResource, 0, end-start)
+		for _, network := range networks[start:end] {
+			items = append(items, &NetworkResource{Network: network, Links: networkLinks(network.ID)})
+		}
+		return items
+	}))
+}
+
+func (s *Server) v2GetNetwork(w http.ResponseWriter, r *http.Request) {
+	network, err := s.store.GetNetwork(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(&NetworkResource{Network: network, Settings: s.networkSettings(network.ID), Links: networkLinks(network.ID)})
+}
+
+func (s *Server) v2PatchNetwork(w http.ResponseWriter, r *http.Request) {
+	network, err := s.store.GetNetwork(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	var patch networkPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	var errs fieldErrors
+	if patch.Description != nil {
+		validateDescription(&errs, *patch.Description)
+		network.Description = *patch.Description
+	}
+	if patch.Tags != nil {
+		validateTags(&errs, *patch.Tags)
+		s.checkTags(&errs, *patch.Tags)
+		validatePointToPoint(&errs, network.CIDR, *patch.Tags)
+		rejectACLTags(&errs, "tags", *patch.Tags)
+		network.Tags = store.KeepSettingsTags(store.KeepSystemTags(*patch.Tags, network.Tags), network.Tags)
+		validatePools(&errs, "tags", network.CIDR, network.Tags)
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	network.UpdatedAt = s.clock.Now()
+	if err := s.store.SaveNetwork(network); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.recordAudit(r, "network_updated", network.ID, fmt.Sprintf("Updated network %s", network.CIDR))
+
+	json.NewEncoder(w).Encode(&NetworkResource{Network: network, Links: networkLinks(network.ID)})
+}
+
+func (s *Server) v2ListNetworkAllocations(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, err := s.store.GetNetwork(id); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	s.writeAllocationPage(w, r, id)
+}
+
+func (s *Server) v2AllocateInNetwork(w http.ResponseWriter, r *http.Request) {
+	var req allocationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	req.NetworkID = mux.Vars(r)["id"]
+	req.CIDR = ""
+
+	errs := validateAllocationRequest(&req.AllocationRequest)
+	s.checkTags(&errs, req.Tags)
+	validateHoldToken(&errs, &req)
+	validateAllocationDHCP(&errs, &req)
+	validatePool(&errs, &req)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	if errs := s.generateHostname(&req); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	if s.rejectFrozen(w, req.NetworkID, "") {
+		return
+	}
+	if s.rejectUnapproved(w, r, &req.AllocationRequest) {
+		return
+	}
+
+	allocation, err := s.hooks.Allocate(r.Context(), s.store, &req.AllocationRequest, s.allocator(r, &req))
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusBadRequest), err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/v2/allocations/"+allocation.ID)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&AllocationResource{IPAllocation: allocation, Settings: s.allocationSettings(allocation), Links: allocationLinks(allocation)})
+}
+
+func (s *Server) v2ListAllocations(w http.ResponseWriter, r *http.Request) {
+	s.writeAllocationPage(w, r, r.URL.Query().Get("network_id"))
+}
+
+func (s *Server) writeAllocationPage(w http.ResponseWriter, r *http.Request, networkID string) {
+	offset, limit, err := parsePage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	query, errs := parseAllocationQuery(r)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	allocations, err := s.collectAllocations(r, networkID, r.URL.Query().Get("all") == "true" || query.filter.Status != "")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	allocations = query.apply(allocations, s.clock.Now())
+
+	writeJSONWithETag(w, r, newPage(r, len(allocations), offset, limit, func(start, end int) interface{} {
+		items := make([]*AllocationResource, 0, end-start)
+		for _, alloc := range allocations[start:end] {
+			items = append(items, &AllocationResource{IPAllocation: alloc, Links: allocationLinks(alloc)})
+		}
+		return items
+	}))
+}
+
+func (s *Server) v2GetAllocation(w http.ResponseWriter, r *http.Request) {
+	allocation, err := s.store.GetAllocation(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(&AllocationResource{IPAllocation: allocation, Settings: s.allocationSettings(allocation), Links: allocationLinks(allocation)})
+}
+
+func (s *Server) v2PatchAllocation(w http.ResponseWriter, r *http.Request) {
+	allocation, err := s.store.GetAllocation(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	var patch allocationPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	var errs fieldErrors
+	if patch.Hostname != nil {
+		if *patch.Hostname != "" {
+			if err := validateHostname(*patch.Hostname); err != nil {
+				errs.add("hostname", "%v", err)
+			}
+		}
+		allocation.Hostname = *patch.Hostname
+	}
+	if patch.Description != nil {
+		validateDescription(&errs, *patch.Description)
+		allocation.Description = *patch.Description
+	}
+	if patch.Tags != nil {
+		validateTags(&errs, *patch.Tags)
+		s.checkTags(&errs, *patch.Tags)
+		allocation.Tags = keepHoldTags(*patch.Tags, allocation.Tags)
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	if err := s.store.SaveAllocation(allocation); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.recordAudit(r, "allocation_updated", allocation.ID, fmt.Sprintf("Updated allocation %s", allocation.IP))
+
+	json.NewEncoder(w).Encode(&AllocationResource{IPAllocation: allocation, Links: allocationLinks(allocation)})
+}
+
+func (s *Server) v2ListAuditEntries(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := parsePage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Fetch one extra entry so we know whether another page exists
+	entries, err := s.listVisibleAuditEntries(r, offset+limit+1)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	page := newPage(r, len(entries), offset, limit, func(start, end int) interface{} {
+		return entries[start:end]
+	})
+	// The store cannot count audit entries cheaply, so only report a
+	// total once the last page has been reached
+	if page.NextCursor != "" {
+		page.Total = nil
+	}
+
+	writeJSONWithETag(w, r, page)
+}
+
+// recordAudit stores an audit entry for changes made directly by the API
+// layer rather than through the engine
+func (s *Server) recordAudit(r *http.Request, action, resource, details string) {
+	s.recordAuditAs(r, auditUser(r), action, resource, details)
+}
+
+// recordAuditAs is recordAudit for a change made by user, e.g. a request's
+// principal. r is the request making the change, nil for changes no request
+// made; its correlation ID is appended to the details.
+func (s *Server) recordAuditAs(r *http.Request, user, action, resource, details string) {
+	if id := requestID(r); id != "" {
+		details += " request_id=" + id
+	}
+	s.auditLog().SaveAuditEntry(&ipam.AuditEntry{
+		ID:        newAuditID(),
+		Timestamp: s.clock.Now(),
+		Action:    action,
+		Resource:  resource,
+		Details:   details,
+		User:      user,
+	})
+}
+
+func newAuditID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestV2Networks(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	for i := 0; i < 3; i++ {
+		w := doRequest(t, server, "POST", "/api/v2/networks", map[string]interface{}{
+			"cidr": fmt.Sprintf("10.70.%d.0/24", i),
+		})
+		require.Equal(t, http.StatusCreated, w.Code)
+	}
+
+	// First page
+	w := doRequest(t, server, "GET", "/api/v2/networks?limit=2", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	page := decodeObject(t, w)
+	assert.Equal(t, float64(3), page["total"])
+	assert.Len(t, page["items"], 2)
+	require.NotEmpty(t, page["next_cursor"])
+	links := page["_links"].(map[string]interface{})
+	require.Contains(t, links, "next")
+
+	first := page["items"].([]interface{})[0].(map[string]interface{})
+	networkID := first["id"].(string)
+	assert.Equal(t, "/api/v2/networks/"+networkID,
+		first["_links"].(map[string]interface{})["self"])
+
+	// Following the next link returns the remainder
+	w = doRequest(t, server, "GET", links["next"].(string), nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	page = decodeObject(t, w)
+	assert.Len(t, page["items"], 1)
+	assert.NotContains(t, page, "next_cursor")
+
+	// PATCH only touches the supplied fields
+	w = doRequest(t, server, "PATCH", "/api/v2/networks/"+networkID, map[string]interface{}{
+		"description": "patched",
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(t, server, "PATCH", "/api/v2/networks/"+networkID, map[string]interface{}{
+		"tags": []string{"v2"},
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+	network := decodeObject(t, w)
+	assert.Equal(t, "patched", network["description"])
+	assert.Equal(t, []interface{}{"v2"}, network["tags"])
+
+	// PATCH validates its input
+	w = doRequest(t, server, "PATCH", "/api/v2/networks/"+networkID, map[string]interface{}{
+		"tags": []string{"not valid"},
+	})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	// Invalid paging parameters
+	w = doRequest(t, server, "GET", "/api/v2/networks?cursor=!!!", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = doRequest(t, server, "GET", "/api/v2/networks?limit=0", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestV2PatchNetworkKeepsSystemTags(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v1/networks", map[string]interface{}{
+		"cidr":        "10.70.9.0/24",
+		"dns_servers": []string{"10.70.9.53"},
+		"domain":      "lab.example.com",
+		"reuse_delay": 600,
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	networkID := decodeObject(t, w)["id"].(string)
+	w = doRequest(t, server, "POST", "/api/v1/networks/"+networkID+"/freeze", map[string]interface{}{"reason": "q4-change-freeze"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// Replacing the tags leaves the freeze and the settings in place
+	w = doRequest(t, server, "PATCH", "/api/v2/networks/"+networkID, map[string]interface{}{
+		"tags": []string{"v2", "freeze-until=2000-01-01T00:00:00Z"},
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.ElementsMatch(t, []interface{}{"v2", "freeze=q4-change-freeze", "dns=10.70.9.53", "domain=lab.example.com", "reuse-delay=10m0s"},
+		decodeObject(t, w)["tags"])
+
+	w = doRequest(t, server, "POST", "/api/v2/networks/"+networkID+"/allocations", map[string]interface{}{})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "q4-change-freeze")
+
+	// Settings tags given in the patch replace those of their kind
+	w = doRequest(t, server, "PATCH", "/api/v2/networks/"+networkID, map[string]interface{}{
+		"tags": []string{"v2", "dns=10.70.9.54"},
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.ElementsMatch(t, []interface{}{"v2", "dns=10.70.9.54", "freeze=q4-change-freeze", "domain=lab.example.com", "reuse-delay=10m0s"},
+		decodeObject(t, w)["tags"])
+}
+
+func TestV2Allocations(t *testing.T) {
+	server, cleanup := createTestServer(t)
+	defer cleanup()
+
+	w := doRequest(t, server, "POST", "/api/v2/networks", map[string]interface{}{"cidr": "10.71.0.0/24"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	networkID := decodeObject(t, w)["id"].(string)
+
+	// Allocate through the nested collection
+	w = doRequest(t, server, "POST", "/api/v2/networks/"+networkID+"/allocations", map[string]interface{}{
+		"hostname": "web-01",
+	})
+	require.Equal(t, http.StatusCreated, w.Code)
+	allocation := decodeObject(t, w)
+	allocationID := allocation["id"].(string)
+	assert.Equal(t, "/api/v2/allocations/"+allocationID, w.Header().Get("Location"))
+	assert.Equal(t, "/api/v2/networks/"+networkID,
+		allocation["_links"].(map[string]interface{})["network"])
+
+	// Allocating in an unknown network is a 404
+	w = doRequest(t, server, "POST", "/api/v2/networks/missing/allocations", map[string]interface{}{})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Nested and top-level listings agree
+	w = doRequest(t, server, "GET", "/api/v2/networks/"+networkID+"/allocations", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(1), decodeObject(t, w)["total"])
+
+	w = doRequest(t, server, "GET", "/api/v2/allocations?network_id="+networkID, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(1), decodeObject(t, w)["total"])
+
+	// PATCH the hostname
+	w = doRequest(t, server, "PATCH", "/api/v2/allocations/"+allocationID, map[string]interface{}{
+		"hostname": "web-02",
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "web-02", decodeObject(t, w)["hostname"])
+
+	// DELETE releases the allocation
+	w = doRequest(t, server, "DELETE", "/api/v2/allocations/"+allocationID, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = doRequest(t, server, "GET", "/api/v2/allocations/"+allocationID, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotNil(t, decodeObject(t, w)["released_at"])
+
+	// Audit entries are paginated too and include the PATCH
+	w = doRequest(t, server, "GET", "/api/v2/audit-entries?limit=1", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	page := decodeObject(t, w)
+	assert.Len(t, page["items"], 1)
+	assert.NotEmpty(t, page["next_cursor"])
+	assert.NotContains(t, page, "total")
+}
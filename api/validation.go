@@ -0,0 +1,231 @@
+package api
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// Request limits enforced before anything reaches the engine
+const (
+	maxAllocationCount = 65536
+	maxTTLSeconds      = 365 * 24 * 60 * 60
+	maxDescriptionLen  = 1024
+	maxTags            = 64
+)
+
+// CodeValidationFailed is returned with 422 and a list of FieldError details
+const CodeValidationFailed = "validation_failed"
+
+// tagPattern allows simple words plus the separators used by key=value and
+// path-style tags
+var tagPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:/=-]{0,62}$`)
+
+// FieldError describes why a single request field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// fieldErrors accumulates FieldErrors while validating a request
+type fieldErrors []FieldError
+
+func (e *fieldErrors) add(field, format string, args ...interface{}) {
+	*e = append(*e, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// validateNetworkRequest checks the fields accepted when creating a network
+func validateNetworkRequest(cidr, description string, tags []string) fieldErrors {
+	var errs fieldErrors
+
+	if cidr == "" {
+		errs.add("cidr", "is required")
+	} else if _, _, err := net.ParseCIDR(cidr); err != nil {
+		errs.add("cidr", "invalid CIDR %q", cidr)
+	} else {
+		validatePointToPoint(&errs, cidr, tags)
+	}
+
+	validateDescription(&errs, description)
+	validateTags(&errs, tags)
+
+	return errs
+}
+
+// validatePointToPoint checks the point-to-point policy tags give a network
+// with cidr
+func validatePointToPoint(errs *fieldErrors, cidr string, tags []string) {
+	if err := store.CheckPointToPoint(cidr, tags); err != nil {
+		errs.add("tags", "%v", err)
+	}
+}
+
+// validateNetworkSettings checks the gateway, name servers and domain given
+// when creating a network with cidr and tags, and returns the gateway in
+// canonical form. The gateway must be a usable host address of the network.
+func validateNetworkSettings(errs *fieldErrors, cidr string, tags []string, gateway string, dnsServers []string, domain string) string {
+	if gateway != "" {
+		if _, err := netip.ParsePrefix(cidr); err == nil {
+			var err error
+			if gateway, err = store.CheckRequestedIP(&ipam.Network{CIDR: cidr, Tags: tags}, nil, gateway, time.Time{}); err != nil {
+				errs.add("gateway", "%v", err)
+			}
+		}
+	}
+	for i, ns := range dnsServers {
+		if _, err := netip.ParseAddr(ns); err != nil {
+			errs.add(fmt.Sprintf("dns_servers[%d]", i), "invalid IP address %q", ns)
+		}
+	}
+	if domain != "" && !tagPattern.MatchString(store.DomainTagPrefix+domain) {
+		errs.add("domain", "invalid domain %q", domain)
+	}
+	return gateway
+}
+
+// validatePools checks the pools tags give a network with cidr, reporting
+// problems against field
+func validatePools(errs *fieldErrors, field, cidr string, tags []string) {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, store.PoolTagPrefix) && !tagPattern.MatchString(tag) {
+			errs.add(field, "invalid pool tag %q: at most 63 characters", tag)
+			return
+		}
+	}
+	if err := store.CheckPools(cidr, tags); err != nil {
+		errs.add(field, "%v", err)
+	}
+}
+
+// dhcpOptionPattern allows DHCP option names, as Kea spells them, and codes
+var dhcpOptionPattern = regexp.MustCompile(`^([a-z][a-z0-9-]*|[0-9]{1,3})$`)
+
+// validateDHCPOptions checks the DHCP options given in field. Each is stored
+// as a tag, so the tags must be valid too.
+func validateDHCPOptions(errs *fieldErrors, field string, options *store.DHCPOptions) {
+	if options == nil {
+		return
+	}
+	if options.BootFile != "" && !tagPattern.MatchString(store.BootFileTagPrefix+options.BootFile) {
+		errs.add(field+".boot_file", "invalid boot file %q", options.BootFile)
+	}
+	if options.NextServer != "" {
+		if addr, err := netip.ParseAddr(options.NextServer); err != nil || !addr.Unmap().Is4() {
+			errs.add(field+".next_server", "invalid IPv4 address %q", options.NextServer)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(options.Options)) {
+		data := options.Options[name]
+		if !dhcpOptionPattern.MatchString(name) {
+			errs.add(field+".options", "invalid option name %q: use an option name or code", name)
+		} else if data == "" || !tagPattern.MatchString(store.DHCPOptionTagPrefix+name+":"+data) {
+			errs.add(field+".options."+name, "invalid option data %q", data)
+		}
+	}
+}
+
+// validateAllocationDHCP checks the DHCP options of req and stores them in
+// its tags
+func validateAllocationDHCP(errs *fieldErrors, req *allocationRequest) {
+	if req.DHCP == nil {
+		return
+	}
+	validateDHCPOptions(errs, "dhcp", req.DHCP)
+	req.Tags = store.DHCPTags(req.Tags, req.DHCP)
+	if len(req.Tags) > maxTags {
+		errs.add("tags", "at most %d tags are allowed, dhcp included", maxTags)
+	}
+}
+
+// validateAllocationRequest checks the fields accepted when allocating IPs
+func validateAllocationRequest(req *ipam.AllocationRequest) fieldErrors {
+	var errs fieldErrors
+
+	if req.NetworkID == "" && req.CIDR == "" {
+		errs.add("network_id", "network_id or cidr is required")
+	}
+	if req.CIDR != "" {
+		if _, _, err := net.ParseCIDR(req.CIDR); err != nil {
+			errs.add("cidr", "invalid CIDR %q", req.CIDR)
+		}
+	}
+
+	if req.Count < 0 || req.Count > maxAllocationCount {
+		errs.add("count", "must be between 1 and %d", maxAllocationCount)
+	}
+
+	if req.TTL < 0 || req.TTL > maxTTLSeconds {
+		errs.add("ttl", "must be between 0 and %d seconds", maxTTLSeconds)
+	}
+
+	if req.Hostname != "" {
+		if err := validateHostname(req.Hostname); err != nil {
+			errs.add("hostname", "%v", err)
+		}
+	}
+
+	validateDescription(&errs, req.Description)
+	validateTags(&errs, req.Tags)
+
+	return errs
+}
+
+func validateDescription(errs *fieldErrors, description string) {
+	if len(description) > maxDescriptionLen {
+		errs.add("description", "must be at most %d characters", maxDescriptionLen)
+	}
+}
+
+func validateTags(errs *fieldErrors, tags []string) {
+	if len(tags) > maxTags {
+		errs.add("tags", "at most %d tags are allowed", maxTags)
+		return
+	}
+	for i, tag := range tags {
+		if !tagPattern.MatchString(tag) {
+			errs.add(fmt.Sprintf("tags[%d]", i),
+				"invalid tag %q: use letters, digits and . _ : / = - (max 63 characters)", tag)
+		}
+	}
+}
+
+// validateHostname checks a hostname against RFC 1123
+func validateHostname(hostname string) error {
+	if len(hostname) > 253 {
+		return fmt.Errorf("must be at most 253 characters")
+	}
+
+	for _, label := range strings.Split(hostname, ".") {
+		if label == "" {
+			return fmt.Errorf("must not contain empty labels")
+		}
+		if len(label) > 63 {
+			return fmt.Errorf("label %q exceeds 63 characters", label)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("label %q must not start or end with a hyphen", label)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return fmt.Errorf("label %q contains invalid character %q", label, c)
+			}
+		}
+	}
+
+	return nil
+}
+
+// writeValidationErrors writes a 422 response listing every rejected field
+func writeValidationErrors(w http.ResponseWriter, errs fieldErrors) {
+	writeErrorCode(w, http.StatusUnprocessableEntity, CodeValidationFailed,
+		"request validation failed", []FieldError(errs))
+}
@@ -1,17 +1,32 @@
 package cmd
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/jeremyhahn/go-ipam/pkg/hooks"
 	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/naming"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
 	"github.com/spf13/cobra"
 )
 
 var allocateCmd = &cobra.Command{
 	Use:   "allocate",
 	Short: "Allocate IP addresses",
-	Long:  `Allocate one or more IP addresses from a network pool.`,
+	Long: `Allocate one or more IP addresses from a network pool, or one address per
+hostname listed in a file with --from-file.
+
+Use --ip to request a specific address, such as one released earlier. A new
+allocation record is created; the released one stays in the history.
+
+With --quiet, only the address, or the range as start-end, is printed, for
+scripts: IP=$(ipam allocate -c 10.0.0.0/24 -q)`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		networkID, _ := cmd.Flags().GetString("network-id")
 		cidr, _ := cmd.Flags().GetString("cidr")
@@ -20,16 +35,58 @@ var allocateCmd = &cobra.Command{
 		hostname, _ := cmd.Flags().GetString("hostname")
 		tagsStr, _ := cmd.Flags().GetString("tags")
 		ttl, _ := cmd.Flags().GetInt("ttl")
+		fromFile, _ := cmd.Flags().GetString("from-file")
+		outputPath, _ := cmd.Flags().GetString("output")
+		hostnameTemplate, _ := cmd.Flags().GetString("hostname-template")
+		group, _ := cmd.Flags().GetString("group")
+		ip, _ := cmd.Flags().GetString("ip")
+		quiet, _ := cmd.Flags().GetBool("quiet")
 
 		// Validate count
 		if count < 1 {
-			return fmt.Errorf("count must be at least 1")
+			return withExitCode(ExitValidation, fmt.Errorf("count must be at least 1"))
+		}
+		if ip != "" && (count != 1 || fromFile != "") {
+			return withExitCode(ExitValidation, fmt.Errorf("--ip cannot be combined with --count or --from-file"))
+		}
+		if quiet && fromFile != "" {
+			return withExitCode(ExitValidation, fmt.Errorf("--quiet cannot be combined with --from-file"))
 		}
 
 		var tags []string
 		if tagsStr != "" {
 			tags = strings.Split(tagsStr, ",")
 		}
+		if group != "" {
+			tags = append(tags, store.GroupTag(group))
+		}
+
+		if err := checkNotFrozen(networkID, cidr); err != nil {
+			return err
+		}
+
+		if fromFile != "" {
+			if count != 1 || hostname != "" {
+				return withExitCode(ExitValidation, fmt.Errorf("--from-file cannot be combined with --count or --hostname"))
+			}
+			base := ipam.AllocationRequest{
+				NetworkID:   networkID,
+				CIDR:        cidr,
+				Count:       1,
+				Description: description,
+				Tags:        tags,
+				TTL:         ttl,
+			}
+			return allocateFromFile(cmd, base, fromFile, outputPath)
+		}
+
+		if hostname == "" && hostnameTemplate != "" {
+			generated, err := generateHostname(networkID, cidr, hostnameTemplate)
+			if err != nil {
+				return err
+			}
+			hostname = generated
+		}
 
 		req := &ipam.AllocationRequest{
 			NetworkID:   networkID,
@@ -41,11 +98,26 @@ var allocateCmd = &cobra.Command{
 			TTL:         ttl,
 		}
 
-		allocation, err := ipamClient.AllocateIP(req)
+		allocate := ipamClient.AllocateIP
+		if ip != "" {
+			allocate = func(req *ipam.AllocationRequest) (*ipam.IPAllocation, error) {
+				return allocateRequestedIP(req, ip)
+			}
+		}
+		allocation, err := hooks.Registered().Allocate(cmd.Context(), pebbleStore, req, allocate)
 		if err != nil {
 			return fmt.Errorf("failed to allocate IP: %w", err)
 		}
 
+		if quiet {
+			if allocation.EndIP != "" {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s-%s\n", allocation.IP, allocation.EndIP)
+			} else {
+				fmt.Fprintln(cmd.OutOrStdout(), allocation.IP)
+			}
+			return nil
+		}
+
 		if allocation.EndIP != "" {
 			fmt.Fprintf(cmd.OutOrStdout(), "IP range allocated successfully:\n")
 		} else {
@@ -75,6 +147,220 @@ var allocateCmd = &cobra.Command{
 	},
 }
 
+// allocateRequestedIP allocates the specific address ip in the network of
+// req. The allocator only hands out addresses it picks, so the allocation is
+// recorded and audited directly; earlier allocations of ip are left
+// untouched. Held addresses are refused like allocated ones.
+func allocateRequestedIP(req *ipam.AllocationRequest, ip string) (*ipam.IPAllocation, error) {
+	var network *ipam.Network
+	var err error
+	if req.NetworkID != "" {
+		network, err = pebbleStore.GetNetwork(req.NetworkID)
+	} else {
+		network, err = pebbleStore.GetNetworkByCIDR(req.CIDR)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	allocations, err := pebbleStore.ListAllocations(network.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := store.CheckNotFrozen(network, now); err != nil {
+		return nil, err
+	}
+	ip, err = store.CheckRequestedIP(network, allocations, ip, now)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := store.NewAllocationID()
+	if err != nil {
+		return nil, err
+	}
+	allocation := &ipam.IPAllocation{
+		ID:          id,
+		NetworkID:   network.ID,
+		IP:          ip,
+		Hostname:    req.Hostname,
+		Description: req.Description,
+		Tags:        req.Tags,
+		Status:      "allocated",
+		AllocatedAt: now,
+	}
+	if req.TTL > 0 {
+		expires := now.Add(time.Duration(req.TTL) * time.Second)
+		allocation.ExpiresAt = &expires
+	}
+	if err := pebbleStore.SaveAllocation(allocation); err != nil {
+		return nil, err
+	}
+	if err := store.RecordAudit(pebbleStore, cliAuditUser, "ip_allocated", allocation.ID, fmt.Sprintf("Allocated requested IP %s", allocation.IP), now); err != nil {
+		return nil, fmt.Errorf("allocated %s but failed to audit it: %w", allocation.IP, err)
+	}
+	return allocation, nil
+}
+
+// generateHostname renders template into a hostname unused in the network
+func generateHostname(networkID, cidr, template string) (string, error) {
+	if err := naming.Validate(template); err != nil {
+		return "", withExitCode(ExitValidation, err)
+	}
+
+	var network *ipam.Network
+	var err error
+	if networkID != "" {
+		network, err = pebbleStore.GetNetwork(networkID)
+	} else {
+		network, err = pebbleStore.GetNetworkByCIDR(cidr)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get network: %w", err)
+	}
+
+	allocations, err := pebbleStore.ListAllocations(network.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to list allocations: %w", err)
+	}
+
+	hostname, err := naming.NewGenerator(network, allocations, time.Now()).Generate(template)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate hostname: %w", err)
+	}
+	return hostname, nil
+}
+
+// hostMapping is one line of a --from-file batch and the address it received
+type hostMapping struct {
+	Hostname     string   `json:"hostname"`
+	IP           string   `json:"ip"`
+	AllocationID string   `json:"allocation_id"`
+	NetworkID    string   `json:"network_id"`
+	Tags         []string `json:"tags,omitempty"`
+}
+
+// readHostsFile parses one hostname per line, optionally followed by
+// comma-separated tags. Blank lines and lines starting with # are skipped.
+func readHostsFile(path string) ([]hostMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hosts file: %w", err)
+	}
+
+	seen := make(map[string]int)
+	var hosts []hostMapping
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ",")
+		host := hostMapping{Hostname: strings.TrimSpace(fields[0])}
+		for _, tag := range fields[1:] {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				host.Tags = append(host.Tags, tag)
+			}
+		}
+
+		if host.Hostname == "" {
+			return nil, fmt.Errorf("line %d: missing hostname", i+1)
+		}
+		if prev, ok := seen[host.Hostname]; ok {
+			return nil, fmt.Errorf("line %d: duplicate hostname %q (first on line %d)", i+1, host.Hostname, prev)
+		}
+		seen[host.Hostname] = i + 1
+		hosts = append(hosts, host)
+	}
+
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("hosts file %s contains no hostnames", path)
+	}
+	return hosts, nil
+}
+
+// allocateFromFile allocates one address per hostname in path. The batch is
+// all-or-nothing: if any allocation fails, those already made are released.
+func allocateFromFile(cmd *cobra.Command, base ipam.AllocationRequest, path, outputPath string) error {
+	hosts, err := readHostsFile(path)
+	if err != nil {
+		return withExitCode(ExitValidation, err)
+	}
+
+	var outputFormat string
+	if outputPath != "" {
+		switch strings.ToLower(filepath.Ext(outputPath)) {
+		case ".json":
+			outputFormat = "json"
+		case ".csv":
+			outputFormat = "csv"
+		default:
+			return withExitCode(ExitValidation, fmt.Errorf("output file must end in .json or .csv"))
+		}
+	}
+
+	chain := hooks.Registered()
+	for i := range hosts {
+		req := base
+		req.Hostname = hosts[i].Hostname
+		req.Tags = append(append([]string(nil), base.Tags...), hosts[i].Tags...)
+
+		allocation, err := chain.Allocate(cmd.Context(), pebbleStore, &req, ipamClient.AllocateIP)
+		if err != nil {
+			for _, done := range hosts[:i] {
+				releaseWithHooks(cmd.Context(), chain, done.NetworkID, done.IP)
+			}
+			return fmt.Errorf("failed to allocate IP for %s (batch rolled back): %w", hosts[i].Hostname, err)
+		}
+
+		hosts[i].IP = allocation.IP
+		hosts[i].AllocationID = allocation.ID
+		hosts[i].NetworkID = allocation.NetworkID
+		hosts[i].Tags = allocation.Tags
+	}
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Allocated %d IPs:\n", len(hosts))
+	fmt.Fprintf(out, "%-30s %-20s %s\n", "Hostname", "IP", "Allocation ID")
+	fmt.Fprintln(out, strings.Repeat("-", 80))
+	for _, host := range hosts {
+		fmt.Fprintf(out, "%-30s %-20s %s\n", truncate(host.Hostname, 30), host.IP, host.AllocationID)
+	}
+
+	if outputPath == "" {
+		return nil
+	}
+
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to write mapping: %w", err)
+	}
+	defer f.Close()
+
+	if outputFormat == "json" {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(hosts)
+	} else {
+		w := csv.NewWriter(f)
+		w.Write([]string{"hostname", "ip", "allocation_id", "network_id", "tags"})
+		for _, host := range hosts {
+			w.Write([]string{host.Hostname, host.IP, host.AllocationID, host.NetworkID, strings.Join(host.Tags, ",")})
+		}
+		w.Flush()
+		err = w.Error()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write mapping: %w", err)
+	}
+
+	fmt.Fprintf(out, "\nMapping written to %s\n", outputPath)
+	return nil
+}
+
 func init() {
 	allocateCmd.Flags().StringP("network-id", "n", "", "Network ID to allocate from")
 	allocateCmd.Flags().StringP("cidr", "c", "", "Network CIDR to allocate from")
@@ -83,4 +369,10 @@ func init() {
 	allocateCmd.Flags().StringP("hostname", "H", "", "Hostname for the allocation")
 	allocateCmd.Flags().StringP("tags", "t", "", "Comma-separated tags")
 	allocateCmd.Flags().IntP("ttl", "T", 0, "Time to live in seconds")
+	allocateCmd.Flags().StringP("from-file", "f", "", "Allocate one IP per line of a hostnames file (hostname[,tag...])")
+	allocateCmd.Flags().StringP("output", "o", "", "Write the hostname to IP mapping to a .json or .csv file")
+	allocateCmd.Flags().String("hostname-template", "", "Generate a unique hostname when none is given, e.g. web-{{seq}}.{{domain}} or {{pet}}")
+	allocateCmd.Flags().String("group", "", "Add the allocation to a linked group released with \"ipam group release\"")
+	allocateCmd.Flags().String("ip", "", "Allocate this specific address, e.g. one released earlier")
+	allocateCmd.Flags().BoolP("quiet", "q", false, "Print only the allocated address, or range as start-end")
 }
@@ -0,0 +1,249 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/importer"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/plan"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/spf13/cobra"
+)
+
+// formatPlan is the default --format of apply, a plan file
+const formatPlan = "plan"
+
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Converge networks and allocations toward a plan file",
+	Long: `Read a declarative plan of networks, reservations and static allocations and
+converge the database toward it: missing entries are created and drifted
+descriptions, hostnames and tags are updated. With --prune, allocations and
+networks that are not in the plan are removed.
+
+The changes are printed before they are applied; use --dry-run to stop there.
+
+  networks:
+    - cidr: 10.0.0.0/24
+      description: Servers
+      tags: [env=prod]
+      reservations:
+        - ip: 10.0.0.1-10.0.0.9
+          description: Gateways and infrastructure
+      allocations:
+        - ip: 10.0.0.10
+          hostname: db01
+
+With --format menandmice or solarwinds, the plan is read from the CSV
+exports of Men&Mice (Micetro) or SolarWinds IPAM instead. Give the subnet
+export before the IP address export; columns that are not mapped, such as
+custom fields, become key=value tags.`,
+	Example: `  ipam apply -f ipam.yaml --dry-run
+  ipam apply --format solarwinds -f subnets.csv -f addresses.csv --dry-run`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		files, _ := cmd.Flags().GetStringArray("file")
+		format, _ := cmd.Flags().GetString("format")
+		prune, _ := cmd.Flags().GetBool("prune")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		if len(files) == 0 {
+			return withExitCode(ExitValidation, fmt.Errorf("--file must be specified"))
+		}
+		if format == formatPlan && len(files) > 1 {
+			return withExitCode(ExitValidation, fmt.Errorf("only one plan file may be given"))
+		}
+
+		readers := make([]io.Reader, len(files))
+		for i, file := range files {
+			if file == "-" {
+				readers[i] = cmd.InOrStdin()
+				continue
+			}
+			f, err := os.Open(file)
+			if err != nil {
+				return fmt.Errorf("failed to open %s: %w", file, err)
+			}
+			defer f.Close()
+			readers[i] = f
+		}
+
+		var p *plan.Plan
+		var err error
+		if format == formatPlan {
+			p, err = plan.Load(readers[0])
+		} else {
+			p, err = importer.Parse(format, readers...)
+		}
+		if err != nil {
+			return withExitCode(ExitValidation, err)
+		}
+
+		networks, err := pebbleStore.ListNetworks()
+		if err != nil {
+			return fmt.Errorf("failed to list networks: %w", err)
+		}
+		allocations := make(map[string][]*ipam.IPAllocation, len(networks))
+		for _, network := range networks {
+			if allocations[network.ID], err = pebbleStore.ListAllocations(network.ID); err != nil {
+				return fmt.Errorf("failed to list allocations: %w", err)
+			}
+		}
+
+		changes, err := plan.Compute(p, networks, allocations, prune, time.Now())
+		if err != nil {
+			return withExitCode(ExitConflict, err)
+		}
+
+		out := cmd.OutOrStdout()
+		if len(changes) == 0 {
+			fmt.Fprintln(out, "No changes. The database matches the plan.")
+			return nil
+		}
+		for _, change := range changes {
+			fmt.Fprintln(out, change)
+		}
+		if dryRun {
+			fmt.Fprintf(out, "\n%d changes (dry run, nothing applied).\n", len(changes))
+			return nil
+		}
+
+		if err := applyChanges(changes); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "\nApplied %d changes.\n", len(changes))
+		return nil
+	},
+}
+
+// applyChanges performs the changes in order. Networks created along the
+// way are recorded so later allocation changes can reference them. The
+// changes and their audit entries are staged and saved in one atomic write,
+// so a failing change leaves the database as it was; only network deletes,
+// which cannot be staged, follow once the rest is saved.
+func applyChanges(changes []plan.Change) error {
+	now := time.Now()
+	staging := store.NewStaging(pebbleStore)
+	engine := ipam.New(staging)
+	created := make(map[string]*ipam.Network)
+	var deletes []plan.Change
+
+	for _, change := range changes {
+		network := change.CurrentNetwork
+		if network == nil {
+			network = created[change.Network]
+		}
+
+		// Creating and removing addresses, directly or by deleting the
+		// network, is blocked by a maintenance freeze
+		if network != nil && ((change.Action == plan.ActionCreate && change.Kind != "network") || change.Action == plan.ActionDelete) {
+			if err := store.CheckNotFrozen(network, now); err != nil {
+				return fmt.Errorf("failed to apply %q: %w", change.String(), err)
+			}
+		}
+
+		var err error
+		var action, resource string
+		switch {
+		case change.Kind == "network" && change.Action == plan.ActionCreate:
+			// The engine audits the networks it adds
+			spec := change.NetworkSpec
+			network, err = engine.AddNetwork(spec.CIDR, spec.Description, spec.Tags)
+			created[change.Network] = network
+
+		case change.Kind == "network" && change.Action == plan.ActionUpdate:
+			updated := *network
+			updated.Description = change.NetworkSpec.Description
+			updated.Tags = store.KeepSystemTags(change.NetworkSpec.Tags, network.Tags)
+			updated.UpdatedAt = now
+			err = staging.SaveNetwork(&updated)
+			action, resource = "network_updated", network.ID
+
+		case change.Kind == "network" && change.Action == plan.ActionDelete:
+			deletes = append(deletes, change)
+
+		case change.Action == plan.ActionCreate:
+			spec := change.AddressSpec
+			ip, endIP := spec.Range()
+			var id string
+			if id, err = store.NewAllocationID(); err != nil {
+				break
+			}
+			err = staging.SaveAllocation(&ipam.IPAllocation{
+				ID:          id,
+				NetworkID:   network.ID,
+				IP:          ip,
+				EndIP:       endIP,
+				Hostname:    spec.Hostname,
+				Description: spec.Description,
+				Tags:        spec.Tags,
+				Status:      spec.Status(),
+				AllocatedAt: now,
+			})
+			action, resource = "ip_allocated", id
+
+		case change.Action == plan.ActionUpdate:
+			updated := *change.Current
+			updated.Hostname = change.AddressSpec.Hostname
+			updated.Description = change.AddressSpec.Description
+			updated.Tags = change.AddressSpec.Tags
+			updated.Status = change.AddressSpec.Status()
+			err = staging.SaveAllocation(&updated)
+			action, resource = "allocation_updated", updated.ID
+
+		case change.Action == plan.ActionDelete:
+			released := *change.Current
+			released.Status = "released"
+			released.ReleasedAt = &now
+			err = staging.SaveAllocation(&released)
+			action, resource = "ip_released", released.ID
+		}
+		if err == nil && action != "" {
+			err = store.RecordAudit(staging, cliAuditUser, action, resource, "Applied plan change: "+change.String(), now)
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to apply %q: %w", change.String(), err)
+		}
+	}
+
+	if err := pebbleStore.ApplyBatch(staging.Batch()); err != nil {
+		return fmt.Errorf("failed to apply the plan: %w", err)
+	}
+
+	for _, change := range deletes {
+		network := change.CurrentNetwork
+		if err := deleteNetworkAndAllocations(network); err != nil {
+			return fmt.Errorf("failed to apply %q: %w", change.String(), err)
+		}
+		if err := store.RecordAudit(pebbleStore, cliAuditUser, "network_deleted", network.ID, "Applied plan change: "+change.String(), now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteNetworkAndAllocations removes a network along with its allocation
+// history, which the network delete command refuses to do
+func deleteNetworkAndAllocations(network *ipam.Network) error {
+	allocations, err := pebbleStore.ListAllocations(network.ID)
+	if err != nil {
+		return err
+	}
+	for _, alloc := range allocations {
+		if err := pebbleStore.DeleteAllocation(alloc.ID); err != nil {
+			return err
+		}
+	}
+	return pebbleStore.DeleteNetwork(network.ID)
+}
+
+func init() {
+	applyCmd.Flags().StringArrayP("file", "f", nil, "Plan file (YAML or JSON), or - for stdin; with --format, an export file (repeatable)")
+	applyCmd.Flags().String("format", formatPlan, "Format of --file: plan, menandmice or solarwinds")
+	applyCmd.Flags().Bool("prune", false, "Remove allocations and networks missing from the plan")
+	applyCmd.Flags().Bool("dry-run", false, "Print the changes without applying them")
+}
@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/auditlog"
+	"github.com/jeremyhahn/go-ipam/pkg/client"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/reclaim"
+	"github.com/spf13/cobra"
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Anchor, verify and export the tamper-evident audit log",
+	Long: `An anchor signs, with an Ed25519 key, the hash chain of the audit entries
+logged since the anchor before it, and is stored in the log itself. "ipam
+audit verify" recomputes each anchor's chain and proves that no anchored
+entry was altered, removed or inserted. Anchor periodically with "ipam audit
+anchor" or "ipam server --audit-key".
+
+Anchors made by several servers of a cluster verify independently. Anchors
+whose oldest entries were trimmed from a cluster's log, which keeps the last
+10000 entries, are reported as no longer verifiable. Removing the latest
+anchors together with everything logged after them leaves a consistent but
+shorter log; --max-age fails verification when the latest anchor is too old.`,
+}
+
+var auditKeygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Generate an anchor signing key pair",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out, _ := cmd.Flags().GetString("output")
+		if out == "" {
+			return withExitCode(ExitValidation, fmt.Errorf("--output must be specified"))
+		}
+
+		key, err := auditlog.GenerateKey()
+		if err != nil {
+			return fmt.Errorf("failed to generate key: %w", err)
+		}
+		privPEM, err := auditlog.MarshalPrivateKey(key)
+		if err != nil {
+			return err
+		}
+		pubPEM, err := auditlog.MarshalPublicKey(key.Public().(ed25519.PublicKey))
+		if err != nil {
+			return err
+		}
+
+		pubPath := strings.TrimSuffix(out, ".pem") + ".pub.pem"
+		if err := os.WriteFile(out, privPEM, 0600); err != nil {
+			return fmt.Errorf("failed to write key: %w", err)
+		}
+		if err := os.WriteFile(pubPath, pubPEM, 0644); err != nil {
+			return fmt.Errorf("failed to write public key: %w", err)
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Signing key written to %s\nPublic key written to %s\n", out, pubPath)
+		return nil
+	},
+}
+
+var auditAnchorCmd = &cobra.Command{
+	Use:   "anchor",
+	Short: "Sign the current head of the audit log",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keyPath, _ := cmd.Flags().GetString("key")
+		key, err := readSigningKey(keyPath)
+		if err != nil {
+			return err
+		}
+
+		anchor, err := auditlog.AnchorStore(pebbleStore, key, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to anchor audit log: %w", err)
+		}
+		if anchor == nil {
+			fmt.Fprintln(cmd.OutOrStdout(), "Audit log is already anchored.")
+			return nil
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Anchored %d entries (head %s).\n", anchor.Count, anchor.Head)
+		return nil
+	},
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify the audit log against its signed anchors",
+	Example: `  ipam audit verify -p audit.pub.pem
+  ipam audit verify -p audit.pub.pem --max-age 2h
+  ipam audit verify -p audit.pub.pem --server http://node1:8080,http://node2:8080`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keyPath, _ := cmd.Flags().GetString("pubkey")
+		server, _ := cmd.Flags().GetString("server")
+		maxAge, _ := cmd.Flags().GetDuration("max-age")
+		if keyPath == "" {
+			return withExitCode(ExitValidation, fmt.Errorf("--pubkey must be specified"))
+		}
+		data, err := os.ReadFile(keyPath)
+		if err != nil {
+			return fmt.Errorf("failed to read public key: %w", err)
+		}
+		key, err := auditlog.ParsePublicKey(data)
+		if err != nil {
+			return fmt.Errorf("invalid public key %s: %w", keyPath, err)
+		}
+
+		var entries []*ipam.AuditEntry
+		if server != "" {
+			token, _ := cmd.Flags().GetString("token")
+			timeout, _ := cmd.Flags().GetDuration("timeout")
+			if token == "" {
+				token = os.Getenv("IPAM_TOKEN")
+			}
+			entries, err = fetchAuditEntries(server, token, timeout)
+		} else {
+			entries, err = pebbleStore.ListAuditEntries(0)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list audit entries: %w", err)
+		}
+
+		report, err := auditlog.Verify(entries, key)
+		if err != nil {
+			return err
+		}
+		if report.Anchors == 0 {
+			return fmt.Errorf("audit log has no verifiable anchors; nothing can be verified (run \"ipam audit anchor\")")
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "Audit log verified: %d of %d entries covered by %d anchors (latest %s).\n",
+			report.Verified, report.Entries, report.Anchors, report.LastAnchor.Local().Format("2006-01-02 15:04:05"))
+		if report.Trimmed > 0 {
+			fmt.Fprintf(out, "%d anchors cover entries trimmed from the log and can no longer be verified.\n", report.Trimmed)
+		}
+		if report.Unanchored > 0 {
+			fmt.Fprintf(out, "%d entries logged after the latest anchor are not yet verifiable.\n", report.Unanchored)
+		}
+		if age := time.Since(report.LastAnchor); maxAge > 0 && age > maxAge {
+			return fmt.Errorf("latest anchor is %s old, more than --max-age %s; anchoring has stopped or the latest anchors were removed",
+				age.Round(time.Second), maxAge)
+		}
+		return nil
+	},
+}
+
+var auditExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the audit log as JSON lines or CSV",
+	Long: `Write the audit log, oldest first, as one JSON object per line or as CSV,
+for ingestion into a data lake or SIEM. Entries are written as they are read,
+so exporting a large log does not load it into memory.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		sinceStr, _ := cmd.Flags().GetString("since")
+		out, _ := cmd.Flags().GetString("output")
+
+		if format != auditlog.FormatJSONL && format != auditlog.FormatCSV {
+			return withExitCode(ExitValidation, fmt.Errorf("--format must be %s or %s", auditlog.FormatJSONL, auditlog.FormatCSV))
+		}
+		var since time.Time
+		if sinceStr != "" {
+			var err error
+			if since, err = parseSince(sinceStr, time.Now()); err != nil {
+				return withExitCode(ExitValidation, err)
+			}
+		}
+
+		w := cmd.OutOrStdout()
+		if out != "" {
+			f, err := os.Create(out)
+			if err != nil {
+				return fmt.Errorf("failed to create %s: %w", out, err)
+			}
+			defer f.Close()
+			w = f
+		}
+
+		n, err := auditlog.Export(pebbleStore, w, format, since)
+		if err != nil {
+			return fmt.Errorf("failed to export audit log: %w", err)
+		}
+		if out != "" {
+			fmt.Fprintf(cmd.OutOrStdout(), "Exported %d audit entries to %s\n", n, out)
+		}
+		return nil
+	},
+}
+
+// parseSince parses a past point in time given as a duration before now,
+// such as 7d or 12h, or an RFC 3339 timestamp
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := reclaim.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: use a duration such as 7d or an RFC 3339 time", s)
+	}
+	return now.Add(-d), nil
+}
+
+// fetchAuditEntries reads the audit log of a server, or of any node of a
+// cluster given as a comma-separated list, from its JSON lines export
+func fetchAuditEntries(servers, token string, timeout time.Duration) ([]*ipam.AuditEntry, error) {
+	c, err := client.New(client.ParseServers(servers))
+	if err != nil {
+		return nil, withExitCode(ExitValidation, fmt.Errorf("--server: %w", err))
+	}
+	c.Token = token
+	c.HTTPClient = &http.Client{Timeout: timeout}
+
+	req, err := http.NewRequest(http.MethodGet, "/api/v1/audit/export?format="+auditlog.FormatJSONL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", req.URL.Path, resp.Status)
+	}
+
+	var entries []*ipam.AuditEntry
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var entry ipam.AuditEntry
+		if err := dec.Decode(&entry); errors.Is(err, io.EOF) {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+}
+
+// readSigningKey loads the anchor signing key at path
+func readSigningKey(path string) (ed25519.PrivateKey, error) {
+	if path == "" {
+		return nil, withExitCode(ExitValidation, fmt.Errorf("--key must be specified"))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	key, err := auditlog.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key %s: %w", path, err)
+	}
+	return key, nil
+}
+
+func init() {
+	auditKeygenCmd.Flags().StringP("output", "o", "", "Path of the signing key; the public key is written next to it as .pub.pem")
+	auditAnchorCmd.Flags().StringP("key", "k", "", "Signing key generated with \"ipam audit keygen\"")
+	auditVerifyCmd.Flags().StringP("pubkey", "p", "", "Public key of the anchor signing key")
+	auditVerifyCmd.Flags().Duration("max-age", 0, "Fail when the latest anchor is older than this, e.g. twice the anchor interval (0 disables)")
+	auditVerifyCmd.Flags().String("server", "", "Verify the log of this server, or a comma-separated list of a cluster's nodes, instead of the local database")
+	auditVerifyCmd.Flags().String("token", "", "API token, if the server requires one (default $IPAM_TOKEN)")
+	auditVerifyCmd.Flags().Duration("timeout", 5*time.Minute, "HTTP request timeout")
+	auditExportCmd.Flags().String("format", auditlog.FormatJSONL, "Output format: jsonl or csv")
+	auditExportCmd.Flags().String("since", "", "Only entries logged since this RFC 3339 time or this long ago, e.g. 7d")
+	auditExportCmd.Flags().StringP("output", "o", "", "Write to this file instead of standard output")
+
+	auditCmd.AddCommand(auditKeygenCmd)
+	auditCmd.AddCommand(auditAnchorCmd)
+	auditCmd.AddCommand(auditVerifyCmd)
+	auditCmd.AddCommand(auditExportCmd)
+}
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/client"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/spf13/cobra"
+)
+
+// cacheMetaFile records where and when an offline cache was synced
+const cacheMetaFile = "sync.json"
+
+// offlineCommands are the read-only commands that may run against the
+// offline cache
+var offlineCommands = map[string]bool{
+	"ipam list":         true,
+	"ipam stats":        true,
+	"ipam locate":       true,
+	"ipam network list": true,
+	"ipam network show": true,
+}
+
+// cacheMeta is the content of the cache's sync.json
+type cacheMeta struct {
+	Server      string    `json:"server"`
+	SyncedAt    time.Time `json:"synced_at"`
+	Networks    int       `json:"networks"`
+	Allocations int       `json:"allocations"`
+}
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the offline cache",
+	Long: `Keep a local, read-only copy of a remote server's networks and allocations.
+
+After "ipam cache sync", the list, stats, locate, network list and network show
+commands accept --offline to read from the cache when the server is
+unreachable. A warning is printed when the cache is older than --cache-max-age.`,
+}
+
+var cacheSyncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Refresh the offline cache from a server",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		servers, _ := cmd.Flags().GetString("server")
+		token, _ := cmd.Flags().GetString("token")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		if servers == "" {
+			return withExitCode(ExitValidation, fmt.Errorf("--server must be specified"))
+		}
+		if token == "" {
+			token = os.Getenv("IPAM_TOKEN")
+		}
+		// Any of a cluster's nodes will do
+		c, err := client.New(client.ParseServers(servers))
+		if err != nil {
+			return withExitCode(ExitValidation, fmt.Errorf("--server: %w", err))
+		}
+		c.Token = token
+		c.HTTPClient = &http.Client{Timeout: timeout}
+
+		var networks []*ipam.Network
+		if err := c.GetJSON("/api/v1/networks", &networks); err != nil {
+			return fmt.Errorf("failed to fetch networks: %w", err)
+		}
+
+		var allocations []*ipam.IPAllocation
+		if err := c.GetJSON("/api/v1/allocations?all=true", &allocations); err != nil {
+			return fmt.Errorf("failed to fetch allocations: %w", err)
+		}
+
+		server := strings.Join(client.ParseServers(servers), ",")
+		meta := cacheMeta{
+			Server:      server,
+			SyncedAt:    time.Now().UTC(),
+			Networks:    len(networks),
+			Allocations: len(allocations),
+		}
+		if err := writeCache(meta, networks, allocations); err != nil {
+			return fmt.Errorf("failed to write cache: %w", err)
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Cached %d networks and %d allocations from %s in %s\n",
+			meta.Networks, meta.Allocations, server, cachePath)
+		return nil
+	},
+}
+
+// writeCache builds a fresh cache next to cachePath and swaps it into place,
+// so a failed sync leaves the previous cache usable
+func writeCache(meta cacheMeta, networks []*ipam.Network, allocations []*ipam.IPAllocation) error {
+	// The cache may be open from an earlier --offline command in this process
+	if pebbleStore != nil && storePath == cacheDBPath() {
+		pebbleStore.Close()
+		pebbleStore = nil
+	}
+
+	tmp := cachePath + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+
+	st, err := store.NewPebbleStore(filepath.Join(tmp, "db"))
+	if err != nil {
+		return err
+	}
+	for _, network := range networks {
+		if err := st.SaveNetwork(network); err != nil {
+			st.Close()
+			return err
+		}
+	}
+	for _, alloc := range allocations {
+		if err := st.SaveAllocation(alloc); err != nil {
+			st.Close()
+			return err
+		}
+	}
+	if err := st.Close(); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(tmp, cacheMetaFile), data, 0644); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(cachePath); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cachePath)
+}
+
+// cacheDBPath returns the Pebble directory inside the offline cache
+func cacheDBPath() string {
+	return filepath.Join(cachePath, "db")
+}
+
+// openOfflineCache checks that cmd may run offline and that a cache exists,
+// warning when it is stale, and returns the cache's database path
+func openOfflineCache(cmd *cobra.Command) (string, error) {
+	if !offlineCommands[cmd.CommandPath()] {
+		return "", withExitCode(ExitValidation, fmt.Errorf("%s is not available offline", cmd.CommandPath()))
+	}
+
+	data, err := os.ReadFile(filepath.Join(cachePath, cacheMetaFile))
+	if err != nil {
+		return "", fmt.Errorf("no offline cache in %s; run \"ipam cache sync\" first", cachePath)
+	}
+
+	var meta cacheMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return "", fmt.Errorf("invalid offline cache metadata: %w", err)
+	}
+
+	if age := time.Since(meta.SyncedAt); cacheMaxAge > 0 && age > cacheMaxAge {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: offline cache from %s is %s old (synced %s)\n",
+			meta.Server, age.Round(time.Minute), meta.SyncedAt.Local().Format("2006-01-02 15:04"))
+	}
+
+	return cacheDBPath(), nil
+}
+
+func init() {
+	cacheSyncCmd.Flags().String("server", "", "Server URL, e.g. http://ipam.example.com:8080, or a comma-separated list of a cluster's nodes to fail over between")
+	cacheSyncCmd.Flags().String("token", "", "API token, if the server requires one (default $IPAM_TOKEN)")
+	cacheSyncCmd.Flags().Duration("timeout", 30*time.Second, "HTTP request timeout")
+
+	cacheCmd.AddCommand(cacheSyncCmd)
+}
@@ -5,13 +5,33 @@ package cmd
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
-
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/api"
+	"github.com/jeremyhahn/go-ipam/pkg/auditlog"
+	"github.com/jeremyhahn/go-ipam/pkg/config"
+	"github.com/jeremyhahn/go-ipam/pkg/health"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/kea"
+	"github.com/jeremyhahn/go-ipam/pkg/powerdns"
+	"github.com/jeremyhahn/go-ipam/pkg/reclaim"
+	"github.com/jeremyhahn/go-ipam/pkg/replication"
+	"github.com/jeremyhahn/go-ipam/pkg/report"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/jeremyhahn/go-ipam/pkg/webhook"
+	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -42,6 +62,9 @@ func resetGlobalState() {
 
 	// Reset global variables
 	dbPath = ""
+	storePath = ""
+	offline = false
+	cachePath = ""
 	ipamClient = nil
 	ipamStore = nil
 	clusterMode = false
@@ -54,6 +77,12 @@ func executeTestCommand(t *testing.T, args ...string) (string, error) {
 	rootCmd.ResetFlags()
 	rootCmd.PersistentFlags().StringVar(&dbPath, "db", "ipam-data", "Path to database directory")
 	rootCmd.PersistentFlags().BoolVar(&clusterMode, "cluster", false, "Enable cluster mode")
+	rootCmd.PersistentFlags().BoolVar(&offline, "offline", false, "Read from the offline cache instead of the database")
+	rootCmd.PersistentFlags().StringVar(&cachePath, "cache", "ipam-cache", "Path to offline cache directory")
+	rootCmd.PersistentFlags().DurationVar(&cacheMaxAge, "cache-max-age", 24*time.Hour, "Warn when the offline cache is older than this")
+	rootCmd.PersistentFlags().DurationVar(&lockTimeout, "lock-timeout", 0, "Wait this long for another process using the database to finish (0 fails immediately)")
+	rootCmd.PersistentFlags().BoolVar(&discover, "discover", false, "Find the server with mDNS on the local network when --server is not given")
+	rootCmd.PersistentFlags().DurationVar(&discoverTimeout, "discover-timeout", 2*time.Second, "How long --discover waits for servers to answer")
 
 	// Also reset all subcommand flags to their defaults
 	resetSubcommandFlags()
@@ -78,14 +107,238 @@ func resetSubcommandFlags() {
 	allocateCmd.Flags().StringP("hostname", "H", "", "Hostname for the allocation")
 	allocateCmd.Flags().StringP("tags", "t", "", "Comma-separated tags")
 	allocateCmd.Flags().IntP("ttl", "T", 0, "Time to live in seconds")
+	allocateCmd.Flags().StringP("from-file", "f", "", "Allocate one IP per line of a hostnames file (hostname[,tag...])")
+	allocateCmd.Flags().StringP("output", "o", "", "Write the hostname to IP mapping to a .json or .csv file")
+	allocateCmd.Flags().String("hostname-template", "", "Generate a unique hostname when none is given, e.g. web-{{seq}}.{{domain}} or {{pet}}")
+	allocateCmd.Flags().String("group", "", "Add the allocation to a linked group released with \"ipam group release\"")
+	allocateCmd.Flags().String("ip", "", "Allocate this specific address, e.g. one released earlier")
+	allocateCmd.Flags().BoolP("quiet", "q", false, "Print only the allocated address, or range as start-end")
 
 	// Reset stats command flags
 	statsCmd.ResetFlags()
 	statsCmd.Flags().StringP("network-id", "n", "", "Show stats for specific network")
+	statsCmd.Flags().String("format", formatTable, "Output format: table, csv or tsv")
+	statsCmd.Flags().String("columns", "", "Comma-separated columns")
+	statsCmd.Flags().String("group-by", "", "Group by label")
+
+	// Reset network command flags
+	networkAddCmd.ResetFlags()
+	networkAddCmd.Flags().StringP("description", "d", "", "Network description")
+	networkAddCmd.Flags().StringP("tags", "t", "", "Comma-separated tags")
+	networkAddCmd.Flags().Duration("reuse-delay", 0, "Keep released addresses out of circulation this long, e.g. 10m")
+	networkShowCmd.ResetFlags()
+	networkShowCmd.Flags().Int("recent", 10, "Number of recent allocations to show")
+	networkCarveCmd.ResetFlags()
+	networkCarveCmd.Flags().IntP("prefix-len", "p", 64, "Prefix length of the new subnet")
+	networkCarveCmd.Flags().StringP("description", "d", "", "Network description")
+	networkCarveCmd.Flags().StringP("tags", "t", "", "Comma-separated tags")
+	networkFreezeCmd.ResetFlags()
+	networkFreezeCmd.Flags().StringP("reason", "r", "", "Why the network is frozen, e.g. a change ticket")
+	networkFreezeCmd.Flags().String("until", "", "End of the freeze: a duration such as 48h or an RFC 3339 time")
+	networkACLCmd.ResetFlags()
+	networkACLCmd.Flags().StringSlice("view", nil, "Principals that may read the network and its allocations")
+	networkACLCmd.Flags().StringSlice("allocate", nil, "Principals that may allocate and hold addresses")
+	networkACLCmd.Flags().StringSlice("release", nil, "Principals that may release addresses")
+	networkACLCmd.Flags().StringSlice("admin", nil, "Principals that may do anything, changing the ACL included")
+	networkACLCmd.Flags().Bool("clear", false, "Remove the ACL, opening the network to every token")
+
+	// Reset list command flags
+	listCmd.ResetFlags()
+	listCmd.Flags().StringP("network-id", "n", "", "Filter by network ID")
+	listCmd.Flags().BoolP("all", "a", false, "Show released allocations")
+	listCmd.Flags().String("format", formatTable, "Output format: table, csv or tsv")
+	listCmd.Flags().String("columns", "", "Comma-separated columns")
+	listCmd.Flags().String("sort", "", "Sort by ip, hostname, allocated_at or expires_at")
+	listCmd.Flags().Bool("desc", false, "Sort in descending order")
+	listCmd.Flags().String("filter", "", "Filter terms, e.g. status=active,tag=prod")
 
 	// Reset release command flags
 	releaseCmd.ResetFlags()
 	releaseCmd.Flags().StringP("network-id", "n", "", "Network ID (optional, will auto-detect)")
+
+	// Reset reconcile command flags
+	reconcileCmd.ResetFlags()
+	reconcileCmd.Flags().StringP("network-id", "n", "", "Network ID to reconcile")
+	reconcileCmd.Flags().StringP("cidr", "c", "", "Network CIDR to reconcile")
+	reconcileCmd.Flags().StringP("format", "f", "text", "Observation format: text, nmap, masscan or json")
+	reconcileCmd.Flags().Bool("import", false, "Import an nmap or masscan scan: update last-seen and record unknown hosts as discovered")
+
+	// Reset neighbors command flags
+	neighborsCmd.ResetFlags()
+	neighborsCmd.Flags().StringP("format", "f", "text", "Table format: text or json")
+
+	// Reset neutron command flags
+	neutronSyncCmd.ResetFlags()
+	neutronSyncCmd.Flags().String("auth-url", "", "Keystone URL (default $OS_AUTH_URL)")
+	neutronSyncCmd.Flags().String("region", "", "Region of the Neutron endpoint (default $OS_REGION_NAME)")
+	neutronSyncCmd.Flags().String("interface", "", "Endpoint interface: public, internal or admin (default $OS_INTERFACE, else public)")
+	neutronSyncCmd.Flags().Bool("dry-run", false, "Report the changes without making them")
+	neutronSyncCmd.Flags().Duration("timeout", 2*time.Minute, "Time allowed for reading Neutron")
+	neutronSyncCmd.Flags().Bool("json", false, "Print the result as JSON")
+
+	// Reset vms command flags
+	vmsSyncCmd.ResetFlags()
+	vmsSyncCmd.Flags().String("proxmox", "", "Proxmox VE API URL, e.g. https://pve1:8006")
+	vmsSyncCmd.Flags().String("proxmox-token-file", "", "File holding the Proxmox API token")
+	vmsSyncCmd.Flags().String("libvirt", "", "Libvirt connection URI, e.g. qemu:///system (empty for virsh's default)")
+	vmsSyncCmd.Flags().String("libvirt-source", "lease", "Where libvirt finds addresses: lease, agent or arp")
+	vmsSyncCmd.Flags().String("name", "", "Hypervisor name in vm= tags (default proxmox or libvirt)")
+	vmsSyncCmd.Flags().Bool("dry-run", false, "Report the changes without making them")
+	vmsSyncCmd.Flags().Duration("timeout", 2*time.Minute, "Time allowed for reading the hypervisor")
+	vmsSyncCmd.Flags().Bool("json", false, "Print the result as JSON")
+
+	// Reset kea command flags
+	keaSyncCmd.ResetFlags()
+	keaSyncCmd.Flags().String("url", "", "Kea Control Agent URL, e.g. http://kea:8000")
+	keaSyncCmd.Flags().String("credentials-file", "", "File holding the Control Agent's user:password")
+	keaSyncCmd.Flags().StringSlice("service", []string{"dhcp4"}, "Kea services to sync: dhcp4, dhcp6")
+	keaSyncCmd.Flags().Bool("dry-run", false, "Report the changes without making them")
+	keaSyncCmd.Flags().Duration("timeout", 2*time.Minute, "Time allowed for the sync")
+	keaSyncCmd.Flags().Bool("json", false, "Print the result as JSON")
+	keaExportCmd.ResetFlags()
+	keaExportCmd.Flags().String("service", "dhcp4", "Kea service to export the subnets of: dhcp4, dhcp6")
+	keaExportCmd.Flags().StringSlice("pool", []string{kea.DefaultExportPool}, "Pools to export as the subnets' dynamic pools")
+
+	// Reset powerdns command flags
+	powerdnsSyncCmd.ResetFlags()
+	powerdnsSyncCmd.Flags().String("url", "", "PowerDNS API URL, e.g. http://pdns:8081")
+	powerdnsSyncCmd.Flags().String("api-key-file", "", "File holding the PowerDNS API key")
+	powerdnsSyncCmd.Flags().String("server-id", powerdns.DefaultServerID, "PowerDNS server whose zones are synced")
+	powerdnsSyncCmd.Flags().StringSlice("zone", nil, "Only sync these zones (default every primary and native zone; repeatable)")
+	powerdnsSyncCmd.Flags().String("domain", "", "Domain qualifying hostnames in networks without a domain=<name> tag")
+	powerdnsSyncCmd.Flags().Int("ttl", powerdns.DefaultTTL, "TTL of the records created, in seconds")
+	powerdnsSyncCmd.Flags().Int("batch-size", powerdns.DefaultBatchSize, "Record sets changed per zone update at most")
+	powerdnsSyncCmd.Flags().Bool("dry-run", false, "Report the changes without making them")
+	powerdnsSyncCmd.Flags().Duration("timeout", 2*time.Minute, "Time allowed for the sync")
+	powerdnsSyncCmd.Flags().Bool("json", false, "Print the result as JSON")
+
+	// Reset transfer command flags
+	transferCmd.ResetFlags()
+	transferCmd.Flags().StringP("network-id", "n", "", "Current network ID (optional, will auto-detect)")
+	transferCmd.Flags().String("to", "", "Target network ID")
+
+	// Reset whois command flags
+	whoisCmd.ResetFlags()
+	whoisCmd.Flags().Bool("refresh", false, "Ignore cached results")
+	whoisCmd.Flags().Bool("annotate", false, "Tag the matching network with its registry and handle")
+	whoisCmd.Flags().Bool("json", false, "Output as JSON")
+
+	// Reset apply command flags
+	applyCmd.ResetFlags()
+	applyCmd.Flags().StringArrayP("file", "f", nil, "Plan file (YAML or JSON), or - for stdin; with --format, an export file (repeatable)")
+	applyCmd.Flags().String("format", formatPlan, "Format of --file: plan, menandmice or solarwinds")
+	applyCmd.Flags().Bool("prune", false, "Remove allocations and networks missing from the plan")
+	applyCmd.Flags().Bool("dry-run", false, "Print the changes without applying them")
+
+	// Reset plan command flags
+	planCmd.ResetFlags()
+	planCmd.Flags().String("supernet", "", "Address block to divide, e.g. 10.0.0.0/8")
+	planCmd.Flags().Int("sites", 1, "Number of sites")
+	planCmd.Flags().Int("hosts-per-site", 254, "Hosts needed at each site")
+	planCmd.Flags().Int("hosts-per-vlan", 254, "Maximum hosts in one VLAN")
+	planCmd.Flags().Int("site-prefix", 0, "Site prefix length (derived when 0)")
+	planCmd.Flags().Int("vlan-prefix", 0, "VLAN prefix length (derived when 0)")
+	planCmd.Flags().StringP("output", "o", "", "Write the plan as a YAML file for ipam apply")
+	planCmd.Flags().Bool("commit", false, "Create the proposed networks")
+
+	// Reset export and diff command flags
+	exportCmd.ResetFlags()
+	exportCmd.Flags().StringP("output", "o", "", "Write the export to a file instead of stdout")
+	diffCmd.ResetFlags()
+	diffCmd.Flags().Bool("live", false, "Compare the export against the current database")
+	diffCmd.Flags().Bool("json", false, "Output as JSON")
+
+	// Reset report command flags
+	reportCmd.ResetFlags()
+	reportCmd.Flags().String("format", report.FormatHTML, "Report format: html or pdf")
+	reportCmd.Flags().StringP("out", "o", "", "Write the report to a file instead of stdout")
+	reportCmd.Flags().String("template", "", "Render with this template instead of the built-in one")
+	reportCmd.Flags().StringSlice("group-by", report.DefaultGroupBy, "Label keys to break utilization down by")
+	reportCmd.Flags().Int("top", report.DefaultTop, "Number of fullest networks to list")
+	reportCmd.Flags().Int("months", report.DefaultMonths, "Months of allocation trend")
+	reportCmd.Flags().String("window", "90d", "Period of growth exhaustion forecasts are based on")
+	reportCmd.Flags().String("title", report.DefaultTitle, "Report title")
+
+	// Reset cache sync command flags
+	cacheSyncCmd.ResetFlags()
+	cacheSyncCmd.Flags().String("server", "", "Server URL, e.g. http://ipam.example.com:8080, or a comma-separated list of a cluster's nodes to fail over between")
+	cacheSyncCmd.Flags().String("token", "", "API token, if the server requires one (default $IPAM_TOKEN)")
+	cacheSyncCmd.Flags().Duration("timeout", 30*time.Second, "HTTP request timeout")
+
+	// Reset audit command flags
+	auditKeygenCmd.ResetFlags()
+	auditKeygenCmd.Flags().StringP("output", "o", "", "Path of the signing key; the public key is written next to it as .pub.pem")
+	auditAnchorCmd.ResetFlags()
+	auditAnchorCmd.Flags().StringP("key", "k", "", "Signing key generated with \"ipam audit keygen\"")
+	auditVerifyCmd.ResetFlags()
+	auditVerifyCmd.Flags().StringP("pubkey", "p", "", "Public key of the anchor signing key")
+	auditVerifyCmd.Flags().Duration("max-age", 0, "Fail when the latest anchor is older than this, e.g. twice the anchor interval (0 disables)")
+	auditVerifyCmd.Flags().String("server", "", "Verify the log of this server, or a comma-separated list of a cluster's nodes, instead of the local database")
+	auditVerifyCmd.Flags().String("token", "", "API token, if the server requires one (default $IPAM_TOKEN)")
+	auditVerifyCmd.Flags().Duration("timeout", 5*time.Minute, "HTTP request timeout")
+	auditExportCmd.ResetFlags()
+	auditExportCmd.Flags().String("format", auditlog.FormatJSONL, "Output format: jsonl or csv")
+	auditExportCmd.Flags().String("since", "", "Only entries logged since this RFC 3339 time or this long ago, e.g. 7d")
+	auditExportCmd.Flags().StringP("output", "o", "", "Write to this file instead of standard output")
+
+	// Reset health command flags
+	healthCheckCmd.ResetFlags()
+	healthReportCmd.ResetFlags()
+	for _, c := range []*cobra.Command{healthCheckCmd, healthReportCmd} {
+		c.Flags().StringP("network-id", "n", "", "Only this network (default: all networks)")
+		c.Flags().StringP("cidr", "c", "", "Only the network with this CIDR")
+	}
+	healthCheckCmd.Flags().String("ports", "22,80,443", "TCP ports to probe; a refused connection also counts as up")
+	healthCheckCmd.Flags().Bool("icmp", false, "Probe with ICMP echo instead of TCP (IPv4 only; needs root or CAP_NET_RAW)")
+	healthCheckCmd.Flags().Duration("timeout", time.Second, "Timeout per probe")
+	healthCheckCmd.Flags().Duration("stale-after", 30*24*time.Hour, "Tag allocations stale after not answering for this long")
+	healthReportCmd.Flags().Int("days", 30, "Report allocations silent for at least this many days")
+
+	// Reset reclaim command flags
+	reclaimPolicyCmd.ResetFlags()
+	reclaimPolicyCmd.Flags().String("after", "", "Reclaim allocations silent for this long, e.g. 30d or 720h")
+	reclaimPolicyCmd.Flags().String("grace", "", "Wait this long after marking an allocation before releasing it (default 7d)")
+	reclaimPolicyCmd.Flags().StringSlice("tag", nil, "Only reclaim allocations carrying this tag (repeatable)")
+	reclaimPolicyCmd.Flags().Bool("disable", false, "Remove the network's reclamation policy")
+	reclaimRunCmd.ResetFlags()
+	reclaimRunCmd.Flags().Bool("dry-run", false, "Print what would happen without changing anything")
+	reclaimRunCmd.Flags().String("webhook", "", "POST each event as JSON to this URL")
+	reclaimRunCmd.Flags().String("webhook-secret-file", "", "Sign --webhook requests with HMAC-SHA256 using the secret in this file")
+
+	// Reset loadtest command flags
+	loadtestCmd.ResetFlags()
+	loadtestCmd.Flags().String("server", "", "API base URL, e.g. http://localhost:8080")
+	loadtestCmd.Flags().String("token", "", "API token, if the server requires one (default $IPAM_TOKEN)")
+	loadtestCmd.Flags().Int("networks", 10, "Number of /24 test networks")
+	loadtestCmd.Flags().String("cidr", "10.240.0.0/16", "Prefix the test networks are carved from; must not overlap existing networks")
+	loadtestCmd.Flags().String("rate", "100/s", "Requests started per second, minute or hour, e.g. 500/s")
+	loadtestCmd.Flags().Duration("duration", time.Minute, "How long to generate traffic")
+	loadtestCmd.Flags().Int("concurrency", 64, "Maximum requests in flight")
+	loadtestCmd.Flags().Bool("keep", false, "Leave the test networks and allocations in place")
+	loadtestCmd.Flags().Bool("json", false, "Print the report as JSON")
+
+	// Reset replication command flags
+	for _, c := range []*cobra.Command{replicationStatusCmd, replicationPromoteCmd} {
+		c.ResetFlags()
+		c.Flags().String("server", "", "Standby server URL, e.g. https://ipam.eu-west.example.com:8443")
+		c.Flags().String("token", "", "API token, if the server requires one (default $IPAM_TOKEN)")
+		c.Flags().Duration("timeout", 30*time.Second, "HTTP request timeout")
+	}
+
+	// Reset migrate command flags
+	migrateCmd.ResetFlags()
+	migrateCmd.Flags().String("from", "", "Backend to copy from: pebble or cluster")
+	migrateCmd.Flags().String("to", "", "Backend to copy to: pebble or cluster")
+	migrateCmd.Flags().StringVar(&configFile, "config", "", "Cluster configuration file (default ipam-cluster-data/cluster.json)")
+	migrateCmd.Flags().Bool("dry-run", false, "Count what would be copied without copying")
+	migrateCmd.Flags().Duration("wait", 30*time.Second, "How long to wait for the cluster to elect a leader")
+
+	// Reset healthcheck command flags
+	healthcheckCmd.ResetFlags()
+	healthcheckCmd.Flags().String("server", "http://127.0.0.1:8080", "Server URL")
+	healthcheckCmd.Flags().Duration("timeout", 3*time.Second, "Time allowed for each request")
+	healthcheckCmd.Flags().Duration("wait", 0, "Retry until the server is ready or this long has passed (0 checks once)")
+	healthcheckCmd.Flags().Bool("insecure", false, "Skip verifying the server's TLS certificate, e.g. when probing 127.0.0.1")
 }
 
 // runTest runs a test with proper isolation
@@ -238,6 +491,39 @@ func TestNetworkCommands(t *testing.T) {
 	})
 }
 
+func TestNetworkShowCommand(t *testing.T) {
+	runTest(t, "ShowByCIDR", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "10.15.0.0/24", "-d", "Build farm", "-t", "ci,prod")
+		require.NoError(t, err)
+
+		for _, host := range []string{"first", "second", "third"} {
+			_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.15.0.0/24", "-H", host)
+			require.NoError(t, err)
+			time.Sleep(2 * time.Millisecond)
+		}
+
+		output, err := executeTestCommand(t, "--db", dbPath, "network", "show", "10.15.0.0/24", "--recent", "2")
+		require.NoError(t, err)
+		assert.Contains(t, output, "CIDR:        10.15.0.0/24")
+		assert.Contains(t, output, "Description: Build farm")
+		assert.Contains(t, output, "Tags:        ci, prod")
+		assert.Contains(t, output, "Utilization:")
+		assert.Contains(t, output, "third")
+		assert.Contains(t, output, "second")
+		assert.NotContains(t, output, "first")
+	})
+
+	runTest(t, "ShowMissing", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "network", "show", "nope")
+		assert.Error(t, err)
+		assert.Contains(t, output, "failed to get network")
+	})
+}
+
 func TestAllocateCommands(t *testing.T) {
 	runTest(t, "AllocateSingle", func(t *testing.T) {
 		dbPath := setupTestDB(t)
@@ -288,155 +574,1635 @@ func TestAllocateCommands(t *testing.T) {
 	runTest(t, "AllocateNonExistentNetwork", func(t *testing.T) {
 		dbPath := setupTestDB(t)
 
-		// Test allocation from non-existent network
-		output, err := executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.0.0.0/8")
+		// Test allocation from non-existent network
+		output, err := executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.0.0.0/8")
+		assert.Error(t, err)
+		assert.Contains(t, output, "network not found")
+	})
+
+	runTest(t, "AllocateInvalidCount", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		// Setup network
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "10.0.0.0/24")
+		require.NoError(t, err)
+
+		// Test negative count
+		output, err := executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.0.0.0/24", "-k", "-1", "-d", "Temporary", "-H", "server1", "-t", "web,prod")
+		assert.Error(t, err)
+		assert.Contains(t, output, "count must be at least 1")
+	})
+
+	runTest(t, "AllocateIPv6", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		// Setup IPv6 network
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "2001:db8:1::/64")
+		require.NoError(t, err)
+
+		// Allocate IPv6
+		output, err := executeTestCommand(t, "--db", dbPath, "allocate", "-c", "2001:db8:1::/64", "-H", "ipv6-host")
+		require.NoError(t, err)
+		assert.Contains(t, output, "IP allocated successfully")
+		assert.Contains(t, output, "2001:db8:1::1")
+	})
+
+	runTest(t, "AllocateQuiet", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "192.168.103.0/24")
+		require.NoError(t, err)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "allocate", "-c", "192.168.103.0/24", "-H", "server1", "-q")
+		require.NoError(t, err)
+		assert.Equal(t, "192.168.103.1\n", output)
+
+		output, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "192.168.103.0/24", "-k", "3", "--quiet")
+		require.NoError(t, err)
+		assert.Equal(t, "192.168.103.2-192.168.103.4\n", output)
+
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "192.168.103.0/24", "-q", "-f", "hosts.txt")
+		assert.Equal(t, ExitValidation, ExitCode(err))
+	})
+}
+
+func TestAllocateFromFile(t *testing.T) {
+	runTest(t, "BatchWithMapping", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "10.17.0.0/24")
+		require.NoError(t, err)
+
+		dir := t.TempDir()
+		hosts := filepath.Join(dir, "hosts.txt")
+		require.NoError(t, os.WriteFile(hosts, []byte("# rack 12\nweb01,prod,web\n\nweb02\ndb01, prod\n"), 0644))
+		mapping := filepath.Join(dir, "mapping.json")
+
+		output, err := executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.17.0.0/24", "-t", "rack12", "--from-file", hosts, "-o", mapping)
+		require.NoError(t, err)
+		assert.Contains(t, output, "Allocated 3 IPs")
+		assert.Contains(t, output, "web02")
+
+		data, err := os.ReadFile(mapping)
+		require.NoError(t, err)
+		var entries []map[string]interface{}
+		require.NoError(t, json.Unmarshal(data, &entries))
+		require.Len(t, entries, 3)
+		assert.Equal(t, "web01", entries[0]["hostname"])
+		assert.Equal(t, []interface{}{"rack12", "prod", "web"}, entries[0]["tags"])
+		assert.NotEmpty(t, entries[2]["ip"])
+	})
+
+	runTest(t, "BatchRollsBackWhenFull", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "10.18.0.0/30")
+		require.NoError(t, err)
+
+		hosts := filepath.Join(t.TempDir(), "hosts.txt")
+		require.NoError(t, os.WriteFile(hosts, []byte("a\nb\nc\n"), 0644))
+
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.18.0.0/30", "--from-file", hosts)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "rolled back")
+
+		output, err := executeTestCommand(t, "--db", dbPath, "list")
+		require.NoError(t, err)
+		assert.Contains(t, output, "No allocations found")
+	})
+
+	runTest(t, "BatchRejectsDuplicates", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		hosts := filepath.Join(t.TempDir(), "hosts.txt")
+		require.NoError(t, os.WriteFile(hosts, []byte("a\nb\na\n"), 0644))
+
+		_, err := executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.19.0.0/24", "--from-file", hosts)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "duplicate hostname")
+	})
+}
+
+func TestOfflineCache(t *testing.T) {
+	runTest(t, "SyncAndReadOffline", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+		cacheDir := filepath.Join(t.TempDir(), "cache")
+
+		remote, err := store.NewPebbleStore(filepath.Join(t.TempDir(), "remote"))
+		require.NoError(t, err)
+		defer remote.Close()
+		remoteIPAM := ipam.New(remote)
+		network, err := remoteIPAM.AddNetwork("10.20.0.0/24", "Remote", nil)
+		require.NoError(t, err)
+		alloc, err := remoteIPAM.AllocateIP(&ipam.AllocationRequest{NetworkID: network.ID, Count: 1, Hostname: "db01"})
+		require.NoError(t, err)
+
+		srv := httptest.NewServer(api.NewServer(remoteIPAM, remote))
+		output, err := executeTestCommand(t, "--db", dbPath, "--cache", cacheDir, "cache", "sync", "--server", srv.URL)
+		srv.Close()
+		require.NoError(t, err)
+		assert.Contains(t, output, "Cached 1 networks and 1 allocations")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "--cache", cacheDir, "--offline", "list")
+		require.NoError(t, err)
+		assert.Contains(t, output, "db01")
+		assert.NotContains(t, output, "Warning")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "--cache", cacheDir, "--offline", "locate", alloc.IP)
+		require.NoError(t, err)
+		assert.Contains(t, output, "10.20.0.0/24")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "--cache", cacheDir, "--offline", "--cache-max-age", "1ns", "network", "list")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Warning: offline cache")
+
+		_, err = executeTestCommand(t, "--db", dbPath, "--cache", cacheDir, "--offline", "allocate", "-c", "10.20.0.0/24")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "not available offline")
+
+		// The local database is untouched by the cache
+		output, err = executeTestCommand(t, "--db", dbPath, "list")
+		require.NoError(t, err)
+		assert.Contains(t, output, "No allocations found")
+	})
+
+	runTest(t, "MissingCache", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		_, err := executeTestCommand(t, "--db", dbPath, "--cache", filepath.Join(t.TempDir(), "none"), "--offline", "list")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no offline cache")
+	})
+
+	runTest(t, "SyncUnreachable", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		srv := httptest.NewServer(http.NotFoundHandler())
+		srv.Close()
+
+		_, err := executeTestCommand(t, "--db", dbPath, "--cache", filepath.Join(t.TempDir(), "cache"), "cache", "sync", "--server", srv.URL)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to fetch networks")
+	})
+
+	runTest(t, "SyncFailover", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		remote, err := store.NewPebbleStore(filepath.Join(t.TempDir(), "remote"))
+		require.NoError(t, err)
+		defer remote.Close()
+		remoteIPAM := ipam.New(remote)
+		_, err = remoteIPAM.AddNetwork("10.21.0.0/24", "Remote", nil)
+		require.NoError(t, err)
+
+		down := httptest.NewServer(http.NotFoundHandler())
+		down.Close()
+		up := httptest.NewServer(api.NewServer(remoteIPAM, remote))
+		defer up.Close()
+
+		output, err := executeTestCommand(t, "--db", dbPath, "--cache", filepath.Join(t.TempDir(), "cache"), "cache", "sync", "--server", down.URL+","+up.URL)
+		require.NoError(t, err)
+		assert.Contains(t, output, "Cached 1 networks and 0 allocations")
+	})
+}
+
+func TestDiscover(t *testing.T) {
+	runTest(t, "RequiresServerFlag", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		_, err := executeTestCommand(t, "--db", dbPath, "--discover", "network", "list")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "only applies to commands with --server")
+		assert.Equal(t, ExitValidation, ExitCode(err))
+	})
+
+	runTest(t, "ServerGiven", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		remote, err := store.NewPebbleStore(filepath.Join(t.TempDir(), "remote"))
+		require.NoError(t, err)
+		defer remote.Close()
+		srv := httptest.NewServer(api.NewServer(ipam.New(remote), remote))
+		defer srv.Close()
+
+		// A given --server is used without browsing
+		output, err := executeTestCommand(t, "--db", dbPath, "--cache", filepath.Join(t.TempDir(), "cache"), "--discover", "cache", "sync", "--server", srv.URL)
+		require.NoError(t, err)
+		assert.Contains(t, output, "Cached 0 networks")
+		assert.NotContains(t, output, "Discovered")
+	})
+}
+
+func TestApplyCommand(t *testing.T) {
+	runTest(t, "ConvergeAndPrune", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+		dir := t.TempDir()
+
+		planFile := filepath.Join(dir, "ipam.yaml")
+		require.NoError(t, os.WriteFile(planFile, []byte(`networks:
+  - cidr: 10.30.0.0/24
+    description: Servers
+    reservations:
+      - ip: 10.30.0.1-10.30.0.9
+    allocations:
+      - ip: 10.30.0.10
+        hostname: db01
+`), 0644))
+
+		output, err := executeTestCommand(t, "--db", dbPath, "apply", "-f", planFile, "--dry-run")
+		require.NoError(t, err)
+		assert.Contains(t, output, "+ network 10.30.0.0/24")
+		assert.Contains(t, output, "+ reservation 10.30.0.1-10.30.0.9 in 10.30.0.0/24")
+		assert.Contains(t, output, "dry run")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "list")
+		require.NoError(t, err)
+		assert.Contains(t, output, "No allocations found")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "apply", "-f", planFile)
+		require.NoError(t, err)
+		assert.Contains(t, output, "Applied 3 changes")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "list")
+		require.NoError(t, err)
+		assert.Contains(t, output, "db01")
+		assert.Contains(t, output, "10.30.0.1-10.30.0.9")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "apply", "-f", planFile)
+		require.NoError(t, err)
+		assert.Contains(t, output, "No changes")
+
+		// An unplanned allocation is kept unless pruning
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.30.0.0/24", "-H", "adhoc")
+		require.NoError(t, err)
+
+		output, err = executeTestCommand(t, "--db", dbPath, "apply", "-f", planFile)
+		require.NoError(t, err)
+		assert.Contains(t, output, "No changes")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "apply", "-f", planFile, "--prune")
+		require.NoError(t, err)
+		assert.Contains(t, output, "- allocation")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "list")
+		require.NoError(t, err)
+		assert.NotContains(t, output, "adhoc")
+	})
+
+	runTest(t, "AtomicAuditedKeepsSystemTags", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+		planFile := filepath.Join(t.TempDir(), "ipam.yaml")
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "10.32.0.0/24", "-t", "env=dev")
+		require.NoError(t, err)
+		network, err := pebbleStore.GetNetworkByCIDR("10.32.0.0/24")
+		require.NoError(t, err)
+		acl := store.ACLTagPrefix(store.PermissionView) + "ops"
+		network.Tags = append(store.FreezeTags(network.Tags, "maintenance", nil), acl)
+		require.NoError(t, pebbleStore.SaveNetwork(network))
+
+		// The freeze and ACL survive a tag update, which is audited
+		require.NoError(t, os.WriteFile(planFile, []byte(`networks:
+  - cidr: 10.32.0.0/24
+    tags: [env=prod]
+`), 0644))
+		output, err := executeTestCommand(t, "--db", dbPath, "apply", "-f", planFile)
+		require.NoError(t, err)
+		assert.Contains(t, output, "Applied 1 changes")
+		network, err = pebbleStore.GetNetworkByCIDR("10.32.0.0/24")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"env=prod", "freeze=maintenance", acl}, network.Tags)
+		entries, err := pebbleStore.ListAuditEntries(1)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, "network_updated", entries[0].Action)
+		assert.Equal(t, "cli", entries[0].User)
+
+		output, err = executeTestCommand(t, "--db", dbPath, "apply", "-f", planFile)
+		require.NoError(t, err)
+		assert.Contains(t, output, "No changes")
+
+		// A change refused by the freeze leaves the earlier ones unapplied
+		require.NoError(t, os.WriteFile(planFile, []byte(`networks:
+  - cidr: 10.31.0.0/24
+    allocations:
+      - ip: 10.31.0.10
+  - cidr: 10.32.0.0/24
+    tags: [env=prod]
+    allocations:
+      - ip: 10.32.0.10
+`), 0644))
+		_, err = executeTestCommand(t, "--db", dbPath, "apply", "-f", planFile)
+		assert.ErrorIs(t, err, store.ErrNetworkFrozen)
+		_, err = pebbleStore.GetNetworkByCIDR("10.31.0.0/24")
+		assert.ErrorIs(t, err, ipam.ErrNetworkNotFound)
+	})
+
+	runTest(t, "InvalidPlan", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		planFile := filepath.Join(t.TempDir(), "ipam.yaml")
+		require.NoError(t, os.WriteFile(planFile, []byte("networks:\n  - cidr: bogus\n"), 0644))
+
+		_, err := executeTestCommand(t, "--db", dbPath, "apply", "-f", planFile)
+		require.Error(t, err)
+		assert.Equal(t, ExitValidation, ExitCode(err))
+	})
+
+	runTest(t, "ImportSolarWinds", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+		dir := t.TempDir()
+
+		subnets := filepath.Join(dir, "subnets.csv")
+		require.NoError(t, os.WriteFile(subnets, []byte("Address,CIDR,Display Name,Location\n10.31.0.0,24,Servers,AMS-1\n"), 0644))
+		addresses := filepath.Join(dir, "addresses.csv")
+		require.NoError(t, os.WriteFile(addresses, []byte("IP Address,Status,DNS Backward,Owner\n"+
+			"10.31.0.10,Used,db01.example.com,dba\n10.31.0.11,Available,,\n"), 0644))
+
+		output, err := executeTestCommand(t, "--db", dbPath, "apply", "--format", "solarwinds", "-f", subnets, "-f", addresses)
+		require.NoError(t, err)
+		assert.Contains(t, output, "+ network 10.31.0.0/24")
+		assert.Contains(t, output, "Applied 2 changes")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "list", "--filter", "tag=owner=dba")
+		require.NoError(t, err)
+		assert.Contains(t, output, "db01.example.com")
+
+		// Addresses alone lack their subnet
+		_, err = executeTestCommand(t, "--db", dbPath, "apply", "--format", "solarwinds", "-f", addresses)
+		assert.ErrorContains(t, err, "no subnet of the export contains 10.31.0.10")
+		assert.Equal(t, ExitValidation, ExitCode(err))
+
+		_, err = executeTestCommand(t, "--db", dbPath, "apply", "-f", subnets, "-f", addresses)
+		assert.Equal(t, ExitValidation, ExitCode(err))
+	})
+}
+
+func TestReport(t *testing.T) {
+	runTest(t, "HTMLAndPDF", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+		dir := t.TempDir()
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "10.45.0.0/28", "-t", "site=ams")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.45.0.0/28", "-k", "3")
+		require.NoError(t, err)
+
+		htmlPath := filepath.Join(dir, "report.html")
+		output, err := executeTestCommand(t, "--db", dbPath, "report", "--format", "html", "--out", htmlPath)
+		require.NoError(t, err)
+		assert.Contains(t, output, "Wrote HTML report on 1 networks")
+		data, err := os.ReadFile(htmlPath)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "<title>IP Address Utilization Report</title>")
+		assert.Contains(t, string(data), "10.45.0.0/28")
+		assert.Contains(t, string(data), "ams")
+
+		// The extension picks PDF
+		pdfPath := filepath.Join(dir, "report.pdf")
+		output, err = executeTestCommand(t, "--db", dbPath, "report", "--out", pdfPath, "--title", "Monthly Review")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Wrote PDF report")
+		data, err = os.ReadFile(pdfPath)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(data), "%PDF-"))
+		assert.Contains(t, string(data), "(Monthly Review)")
+
+		templatePath := filepath.Join(dir, "custom.tmpl")
+		require.NoError(t, os.WriteFile(templatePath, []byte(`{{.Capacity.Totals.Networks}} networks`), 0644))
+		output, err = executeTestCommand(t, "--db", dbPath, "report", "--template", templatePath)
+		require.NoError(t, err)
+		assert.Contains(t, output, "1 networks")
+
+		_, err = executeTestCommand(t, "--db", dbPath, "report", "--format", "docx")
+		require.Error(t, err)
+		assert.Equal(t, ExitValidation, ExitCode(err))
+	})
+}
+
+func TestExportAndDiff(t *testing.T) {
+	runTest(t, "DiffExportsAndLive", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+		dir := t.TempDir()
+		before := filepath.Join(dir, "before.json")
+		after := filepath.Join(dir, "after.json")
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "10.40.0.0/24")
+		require.NoError(t, err)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "export", "-o", before)
+		require.NoError(t, err)
+		assert.Contains(t, output, "Exported 1 networks and 0 allocations")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "diff", before, "--live")
+		require.NoError(t, err)
+		assert.Contains(t, output, "No differences")
+
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.40.0.0/24", "-H", "web01")
+		require.NoError(t, err)
+
+		output, err = executeTestCommand(t, "--db", dbPath, "diff", before, "--live")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Allocations:")
+		assert.Contains(t, output, "(10.40.0.0/24) web01")
+
+		_, err = executeTestCommand(t, "--db", dbPath, "export", "-o", after)
+		require.NoError(t, err)
+
+		output, err = executeTestCommand(t, "--db", dbPath, "diff", after, before, "--json")
+		require.NoError(t, err)
+		var diff map[string][]map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(output), &diff))
+		require.Len(t, diff["allocations"], 1)
+		assert.Equal(t, "removed", diff["allocations"][0]["change"])
+	})
+
+	runTest(t, "DiffArgs", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		_, err := executeTestCommand(t, "--db", dbPath, "diff", "only-one.json")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "accepts 2 arg(s)")
+	})
+}
+
+func TestAllocateHostnameTemplate(t *testing.T) {
+	runTest(t, "GeneratesUniqueNames", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "10.50.0.0/24", "-t", "domain=lab.example")
+		require.NoError(t, err)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.50.0.0/24", "--hostname-template", "node-{{seq}}.{{domain}}")
+		require.NoError(t, err)
+		assert.Contains(t, output, "node-1.lab.example")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.50.0.0/24", "--hostname-template", "node-{{seq}}.{{domain}}")
+		require.NoError(t, err)
+		assert.Contains(t, output, "node-2.lab.example")
+
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.50.0.0/24", "--hostname-template", "node-1.lab.example")
+		require.Error(t, err)
+		assert.Equal(t, ExitConflict, ExitCode(err))
+	})
+}
+
+func TestNetworkCarve(t *testing.T) {
+	runTest(t, "NibbleAlignedIPv6", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "2001:db8:100::/48")
+		require.NoError(t, err)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "network", "carve", "2001:db8:100::/48", "-p", "56", "-d", "Site A")
+		require.NoError(t, err)
+		assert.Contains(t, output, "2001:db8:100::/56")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "network", "carve", "2001:db8:100::/48", "-p", "56")
+		require.NoError(t, err)
+		assert.Contains(t, output, "2001:db8:100:100::/56")
+
+		_, err = executeTestCommand(t, "--db", dbPath, "network", "carve", "2001:db8:100::/48", "-p", "58")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "nibble aligned")
+		assert.Equal(t, ExitValidation, ExitCode(err))
+	})
+}
+
+func TestNetworkFreeze(t *testing.T) {
+	runTest(t, "FreezeBlocksChanges", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "10.70.0.0/24")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.70.0.0/24", "-H", "db1")
+		require.NoError(t, err)
+
+		_, err = executeTestCommand(t, "--db", dbPath, "network", "freeze", "10.70.0.0/24")
+		require.Error(t, err)
+		assert.Equal(t, ExitValidation, ExitCode(err))
+		_, err = executeTestCommand(t, "--db", dbPath, "network", "freeze", "10.70.0.0/24", "-r", "CHG-1234", "--until", "-1h")
+		require.Error(t, err)
+		assert.Equal(t, ExitValidation, ExitCode(err))
+
+		output, err := executeTestCommand(t, "--db", dbPath, "network", "freeze", "10.70.0.0/24", "-r", "CHG-1234", "--until", "48h")
+		require.NoError(t, err)
+		assert.Contains(t, output, "frozen: CHG-1234 (until ")
+
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.70.0.0/24")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "CHG-1234")
+		assert.Equal(t, ExitConflict, ExitCode(err))
+
+		_, err = executeTestCommand(t, "--db", dbPath, "release", "10.70.0.1")
+		require.Error(t, err)
+		assert.Equal(t, ExitConflict, ExitCode(err))
+
+		output, err = executeTestCommand(t, "--db", dbPath, "network", "list")
+		require.NoError(t, err)
+		assert.Contains(t, output, "FROZEN: CHG-1234")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "stats")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Frozen")
+		assert.Contains(t, output, "CHG-1234")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "network", "unfreeze", "10.70.0.0/24")
+		require.NoError(t, err)
+		assert.Contains(t, output, "unfrozen")
+
+		_, err = executeTestCommand(t, "--db", dbPath, "release", "10.70.0.1")
+		require.NoError(t, err)
+
+		output, err = executeTestCommand(t, "--db", dbPath, "network", "list")
+		require.NoError(t, err)
+		assert.NotContains(t, output, "FROZEN")
+	})
+}
+
+func TestNetworkACL(t *testing.T) {
+	runTest(t, "SetShowClear", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "10.71.0.0/24")
+		require.NoError(t, err)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "network", "acl", "10.71.0.0/24")
+		require.NoError(t, err)
+		assert.Contains(t, output, "open to every token")
+
+		_, err = executeTestCommand(t, "--db", dbPath, "network", "acl", "10.71.0.0/24", "--allocate", "token:a51146d3")
+		require.Error(t, err)
+		assert.Equal(t, ExitValidation, ExitCode(err))
+		assert.Contains(t, err.Error(), "--admin")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "network", "acl", "10.71.0.0/24",
+			"--admin", "token:a92c36e6", "--allocate", "token:a51146d3,token:9f86d081", "--view", "any")
+		require.NoError(t, err)
+		assert.Contains(t, output, "view      any")
+		assert.Contains(t, output, "allocate  token:a51146d3, token:9f86d081")
+		assert.Contains(t, output, "release   -")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "network", "show", "10.71.0.0/24")
+		require.NoError(t, err)
+		assert.Contains(t, output, "ACL:         acl-view=any, acl-allocate=token:a51146d3")
+
+		_, err = executeTestCommand(t, "--db", dbPath, "network", "acl", "10.71.0.0/24", "--clear", "--admin", "token:a92c36e6")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "--clear cannot be combined")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "network", "acl", "10.71.0.0/24", "--clear", "--admin", "")
+		require.NoError(t, err)
+		assert.Contains(t, output, "open to every token")
+	})
+}
+
+func TestGroupCommands(t *testing.T) {
+	runTest(t, "AllocateShowRelease", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "10.60.0.0/24")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "network", "add", "10.61.0.0/24")
+		require.NoError(t, err)
+
+		for _, args := range [][]string{
+			{"-c", "10.60.0.0/24", "-H", "svc-mgmt"},
+			{"-c", "10.61.0.0/24", "-H", "svc-data"},
+		} {
+			_, err := executeTestCommand(t, append([]string{"--db", dbPath, "allocate", "--group", "svc-a"}, args...)...)
+			require.NoError(t, err)
+		}
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.60.0.0/24", "-H", "other")
+		require.NoError(t, err)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "group", "show", "svc-a")
+		require.NoError(t, err)
+		assert.Contains(t, output, "2 allocations")
+		assert.Contains(t, output, "svc-mgmt")
+		assert.Contains(t, output, "svc-data")
+		assert.NotContains(t, output, "other")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "group", "release", "svc-a")
+		require.NoError(t, err)
+		assert.Contains(t, output, "released (2 allocations)")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "list")
+		require.NoError(t, err)
+		assert.Contains(t, output, "other")
+		assert.NotContains(t, output, "svc-mgmt")
+
+		_, err = executeTestCommand(t, "--db", dbPath, "group", "show", "svc-a")
+		require.Error(t, err)
+		assert.Equal(t, ExitNotFound, ExitCode(err))
+	})
+}
+
+func TestPlanCommand(t *testing.T) {
+	runTest(t, "ReviewThenApply", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+		planFile := filepath.Join(t.TempDir(), "sites.yaml")
+
+		output, err := executeTestCommand(t, "--db", dbPath, "plan", "--supernet", "10.0.0.0/8", "--sites", "3", "--hosts-per-site", "500", "-o", planFile)
+		require.NoError(t, err)
+		assert.Contains(t, output, "10.2.0.0/16")
+		assert.Contains(t, output, "  10.2.1.0/24")
+		assert.Contains(t, output, "9 networks proposed")
+
+		// Nothing is created until the plan is applied
+		output, err = executeTestCommand(t, "--db", dbPath, "network", "list")
+		require.NoError(t, err)
+		assert.Contains(t, output, "No networks found")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "apply", "-f", planFile)
+		require.NoError(t, err)
+		assert.Contains(t, output, "Applied 9 changes")
+
+		// A second plan avoids the networks now in use
+		output, err = executeTestCommand(t, "--db", dbPath, "plan", "--supernet", "10.0.0.0/8", "--sites", "1", "--hosts-per-site", "500", "--commit")
+		require.NoError(t, err)
+		assert.Contains(t, output, "10.3.0.0/16")
+		assert.Contains(t, output, "Created 3 networks")
+	})
+
+	runTest(t, "DoesNotFit", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		_, err := executeTestCommand(t, "--db", dbPath, "plan", "--supernet", "10.0.0.0/24", "--sites", "4", "--hosts-per-site", "200")
+		require.Error(t, err)
+		assert.Equal(t, ExitValidation, ExitCode(err))
+	})
+}
+
+func TestListCommand(t *testing.T) {
+	runTest(t, "ListAllocations", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		// Setup
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "10.10.0.0/24")
+		require.NoError(t, err)
+
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.10.0.0/24", "-H", "host1")
+		require.NoError(t, err)
+
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.10.0.0/24", "-H", "host2", "-d", "Database server")
+		require.NoError(t, err)
+
+		// List allocations
+		output, err := executeTestCommand(t, "--db", dbPath, "list")
+		require.NoError(t, err)
+		assert.Contains(t, output, "10.10.0.1")
+		assert.Contains(t, output, "10.10.0.2")
+		assert.Contains(t, output, "host1")
+		assert.Contains(t, output, "host2")
+		assert.Contains(t, output, "Database server")
+		assert.Contains(t, output, "allocated")
+	})
+
+	runTest(t, "ListWithReleasedIPs", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		// Setup
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "10.11.0.0/24")
+		require.NoError(t, err)
+
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.11.0.0/24", "-H", "host1")
+		require.NoError(t, err)
+
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.11.0.0/24", "-H", "host2")
+		require.NoError(t, err)
+
+		// Release an IP
+		_, err = executeTestCommand(t, "--db", dbPath, "release", "10.11.0.1")
+		require.NoError(t, err)
+
+		// List without --all should not show released
+		output, err := executeTestCommand(t, "--db", dbPath, "list")
+		require.NoError(t, err)
+		assert.NotContains(t, output, "10.11.0.1")
+		assert.Contains(t, output, "10.11.0.2")
+
+		// List with --all should show released
+		output, err = executeTestCommand(t, "--db", dbPath, "list", "--all")
+		require.NoError(t, err)
+		assert.Contains(t, output, "10.11.0.1")
+		assert.Contains(t, output, "10.11.0.2")
+		assert.Contains(t, output, "released")
+	})
+
+	runTest(t, "ListCSVColumns", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "10.12.0.0/24")
+		require.NoError(t, err)
+
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.12.0.0/24", "-H", "host1", "-t", "prod,web")
+		require.NoError(t, err)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "list", "--format", "csv", "--columns", "ip,hostname,tags,expires")
+		require.NoError(t, err)
+		assert.Equal(t, "ip,hostname,tags,expires\n10.12.0.1,host1,\"prod,web\",\n", output)
+
+		output, err = executeTestCommand(t, "--db", dbPath, "list", "--format", "tsv", "--columns", "ip,status")
+		require.NoError(t, err)
+		assert.Equal(t, "ip\tstatus\n10.12.0.1\tallocated\n", output)
+
+		_, err = executeTestCommand(t, "--db", dbPath, "list", "--columns", "ip,bogus")
+		assert.Error(t, err)
+
+		_, err = executeTestCommand(t, "--db", dbPath, "list", "--format", "xml")
+		assert.Error(t, err)
+	})
+
+	runTest(t, "ListSortAndFilter", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "10.14.0.0/24")
+		require.NoError(t, err)
+
+		for _, args := range [][]string{
+			{"-H", "charlie", "-t", "prod"},
+			{"-H", "alpha"},
+			{"-H", "bravo", "-t", "prod"},
+		} {
+			_, err = executeTestCommand(t, append([]string{"--db", dbPath, "allocate", "-c", "10.14.0.0/24"}, args...)...)
+			require.NoError(t, err)
+		}
+
+		_, err = executeTestCommand(t, "--db", dbPath, "release", "10.14.0.2")
+		require.NoError(t, err)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "list", "--sort", "hostname", "--format", "csv", "--columns", "hostname")
+		require.NoError(t, err)
+		assert.Equal(t, "hostname\nbravo\ncharlie\n", output)
+
+		output, err = executeTestCommand(t, "--db", dbPath, "list", "--sort", "ip", "--desc", "--filter", "tag=prod", "--format", "csv", "--columns", "ip")
+		require.NoError(t, err)
+		assert.Equal(t, "ip\n10.14.0.3\n10.14.0.1\n", output)
+
+		output, err = executeTestCommand(t, "--db", dbPath, "list", "--filter", "status=released", "--format", "csv", "--columns", "hostname")
+		require.NoError(t, err)
+		assert.Equal(t, "hostname\nalpha\n", output)
+
+		_, err = executeTestCommand(t, "--db", dbPath, "list", "--sort", "size")
+		assert.Error(t, err)
+
+		_, err = executeTestCommand(t, "--db", dbPath, "list", "--filter", "status=gone")
+		assert.Error(t, err)
+	})
+
+	runTest(t, "ListEmpty", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		// List empty allocations
+		output, err := executeTestCommand(t, "--db", dbPath, "list")
+		require.NoError(t, err)
+		assert.Contains(t, output, "No allocations found")
+	})
+}
+
+func TestReleaseCommand(t *testing.T) {
+	runTest(t, "ReleaseIP", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		// Setup
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "172.20.0.0/24")
+		require.NoError(t, err)
+
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.20.0.0/24")
+		require.NoError(t, err)
+
+		// Release IP
+		output, err := executeTestCommand(t, "--db", dbPath, "release", "172.20.0.1")
+		require.NoError(t, err)
+		assert.Contains(t, output, "IP 172.20.0.1 released successfully")
+	})
+
+	runTest(t, "ReleaseNonExistentIP", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		// Try to release non-existent IP
+		output, err := executeTestCommand(t, "--db", dbPath, "release", "1.2.3.4")
+		assert.Error(t, err)
+		assert.Contains(t, output, "not found")
+	})
+
+	runTest(t, "ReleaseAlreadyReleasedIP", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		// Setup
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "172.21.0.0/24")
+		require.NoError(t, err)
+
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.21.0.0/24")
+		require.NoError(t, err)
+
+		// Release IP
+		_, err = executeTestCommand(t, "--db", dbPath, "release", "172.21.0.1")
+		require.NoError(t, err)
+
+		// Releasing again reports the earlier release rather than "not found"
+		output, err := executeTestCommand(t, "--db", dbPath, "release", "172.21.0.1")
+		assert.Error(t, err)
+		assert.Contains(t, output, "already released")
+		assert.Contains(t, output, "172.21.0.1 was released at")
+		assert.Equal(t, ExitConflict, ExitCode(err))
+
+		network, err := pebbleStore.GetNetworkByCIDR("172.21.0.0/24")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "release", "172.21.0.1", "-n", network.ID)
+		assert.ErrorIs(t, err, store.ErrAlreadyReleased)
+
+		// An address that was never allocated is still not found
+		_, err = executeTestCommand(t, "--db", dbPath, "release", "172.21.0.2")
+		assert.Equal(t, ExitNotFound, ExitCode(err))
+	})
+
+	runTest(t, "ReleaseMany", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "172.27.0.0/24")
+		require.NoError(t, err)
+		for i := 0; i < 6; i++ {
+			_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.27.0.0/24")
+			require.NoError(t, err)
+		}
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.27.0.0/24", "-k", "4")
+		require.NoError(t, err)
+		network, err := pebbleStore.GetNetworkByCIDR("172.27.0.0/24")
+		require.NoError(t, err)
+		first, err := pebbleStore.GetAllocationByIP(network.ID, "172.27.0.1")
+		require.NoError(t, err)
+		active := func() []string {
+			allocations, err := pebbleStore.ListAllocations(network.ID)
+			require.NoError(t, err)
+			var ips []string
+			for _, alloc := range allocations {
+				if alloc.ReleasedAt == nil {
+					ips = append(ips, alloc.IP)
+				}
+			}
+			sort.Strings(ips)
+			return ips
+		}
+
+		// The range allocation is only partly inside the last target
+		output, err := executeTestCommand(t, "--db", dbPath, "release", first.ID,
+			"172.27.0.2-172.27.0.3", "172.27.0.4", "172.27.0.8-172.27.0.20", "bogus")
+		assert.Error(t, err)
+		assert.Contains(t, output, "skipped: extends beyond 172.27.0.8-172.27.0.20")
+		assert.Contains(t, output, "not an allocation ID, IP, range or CIDR")
+		assert.Contains(t, output, "Released 4 of 6.")
+		assert.Equal(t, []string{"172.27.0.5", "172.27.0.6", "172.27.0.7"}, active())
+
+		// Releasing a CIDR takes everything left in it
+		output, err = executeTestCommand(t, "--db", dbPath, "release", "172.27.0.0/24", "-n", network.ID)
+		require.NoError(t, err, output)
+		assert.Contains(t, output, "172.27.0.7-172.27.0.10")
+		assert.Empty(t, active())
+
+		// Each release is audited
+		entries, err := pebbleStore.ListAuditEntries(0)
+		require.NoError(t, err)
+		released := 0
+		for _, entry := range entries {
+			if strings.Contains(entry.Action, "release") {
+				released++
+			}
+		}
+		assert.Equal(t, 7, released)
+	})
+
+	runTest(t, "ReleaseWithReuseDelay", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "network", "add", "172.28.0.0/24", "--reuse-delay", "10m")
+		require.NoError(t, err)
+		assert.Contains(t, output, "reuse-delay=10m0s")
+		for i := 0; i < 2; i++ {
+			_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.28.0.0/24")
+			require.NoError(t, err)
+		}
+		network, err := pebbleStore.GetNetworkByCIDR("172.28.0.0/24")
+		require.NoError(t, err)
+		second, err := pebbleStore.GetAllocationByIP(network.ID, "172.28.0.2")
+		require.NoError(t, err)
+
+		// A single address and a batch release both hold the addresses
+		_, err = executeTestCommand(t, "--db", dbPath, "release", "172.28.0.1")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "release", second.ID)
+		require.NoError(t, err)
+
+		output, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.28.0.0/24")
+		require.NoError(t, err)
+		assert.Contains(t, output, "172.28.0.3")
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.28.0.0/24", "--ip", "172.28.0.1")
+		assert.ErrorContains(t, err, "is on hold until")
+
+		entries, err := pebbleStore.ListAuditEntries(0)
+		require.NoError(t, err)
+		held := 0
+		for _, entry := range entries {
+			if entry.Action == "ip_held" {
+				held++
+			}
+		}
+		assert.Equal(t, 2, held)
+
+		_, err = executeTestCommand(t, "--db", dbPath, "network", "add", "172.29.0.0/24", "--reuse-delay", "-1m")
+		assert.Equal(t, ExitValidation, ExitCode(err))
+	})
+}
+
+func TestReallocateReleasedIP(t *testing.T) {
+	runTest(t, "AllocateSpecificReleasedIP", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "172.23.0.0/24")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.23.0.0/24", "-k", "3")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.23.0.0/24", "-H", "old-host")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "release", "172.23.0.4")
+		require.NoError(t, err)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.23.0.0/24", "--ip", "172.23.0.4", "-H", "new-host")
+		require.NoError(t, err)
+		assert.Contains(t, output, "172.23.0.4")
+
+		// The new allocation is active and the released one stays in history
+		network, err := pebbleStore.GetNetworkByCIDR("172.23.0.0/24")
+		require.NoError(t, err)
+		allocations, err := pebbleStore.ListAllocations(network.ID)
+		require.NoError(t, err)
+		var hosts []string
+		for _, alloc := range allocations {
+			if alloc.IP == "172.23.0.4" {
+				hosts = append(hosts, alloc.Hostname+"="+store.AllocationStatus(alloc, time.Now()))
+			}
+		}
+		assert.ElementsMatch(t, []string{"old-host=released", "new-host=active"}, hosts)
+
+		active, err := pebbleStore.GetAllocationByIP(network.ID, "172.23.0.4")
+		require.NoError(t, err)
+		assert.Equal(t, "new-host", active.Hostname)
+
+		// The allocation is audited
+		entries, err := pebbleStore.ListAuditEntries(0)
+		require.NoError(t, err)
+		var audited []string
+		for _, entry := range entries {
+			if entry.Action == "ip_allocated" {
+				audited = append(audited, entry.Resource+" by "+entry.User)
+			}
+		}
+		assert.Equal(t, []string{active.ID + " by cli"}, audited)
+
+		// Held addresses are refused
+		expires := time.Now().Add(time.Minute)
+		require.NoError(t, pebbleStore.SaveAllocation(&ipam.IPAllocation{
+			ID: "hold1", NetworkID: network.ID, IP: "172.23.0.8", Tags: []string{store.HoldTag("t1")},
+			Status: "allocated", AllocatedAt: time.Now(), ExpiresAt: &expires,
+		}))
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.23.0.0/24", "--ip", "172.23.0.8")
+		assert.Equal(t, ExitNoAvailableIPs, ExitCode(err))
+		assert.ErrorContains(t, err, "on hold")
+
+		// Active, outside and conflicting requests are rejected
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.23.0.0/24", "--ip", "172.23.0.4")
+		assert.Equal(t, ExitNoAvailableIPs, ExitCode(err))
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.23.0.0/24", "--ip", "172.23.0.2")
+		assert.Equal(t, ExitNoAvailableIPs, ExitCode(err))
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.23.0.0/24", "--ip", "172.24.0.1")
+		assert.Equal(t, ExitValidation, ExitCode(err))
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.23.0.0/24", "--ip", "172.23.0.9", "-k", "2")
+		assert.Equal(t, ExitValidation, ExitCode(err))
+	})
+}
+
+func TestAuditCommand(t *testing.T) {
+	runTest(t, "Export", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "172.26.0.0/24")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.26.0.0/24")
+		require.NoError(t, err)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "audit", "export")
+		require.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(output), "\n")
+		require.NotEmpty(t, lines)
+		assert.True(t, strings.HasPrefix(lines[0], "{"), lines[0])
+
+		out := filepath.Join(t.TempDir(), "audit.csv")
+		output, err = executeTestCommand(t, "--db", dbPath, "audit", "export", "--format", "csv", "--since", "1h", "-o", out)
+		require.NoError(t, err)
+		assert.Contains(t, output, fmt.Sprintf("Exported %d audit entries", len(lines)))
+		data, err := os.ReadFile(out)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(data), "id,timestamp,action,resource,details,user\n"))
+
+		output, err = executeTestCommand(t, "--db", dbPath, "audit", "export", "--since", "2099-01-01T00:00:00Z")
+		require.NoError(t, err)
+		assert.Empty(t, output)
+
+		_, err = executeTestCommand(t, "--db", dbPath, "audit", "export", "--format", "xml")
+		assert.Equal(t, ExitValidation, ExitCode(err))
+		_, err = executeTestCommand(t, "--db", dbPath, "audit", "export", "--since", "yesterday")
+		assert.Equal(t, ExitValidation, ExitCode(err))
+	})
+
+	runTest(t, "AnchorAndVerify", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+		keyPath := filepath.Join(t.TempDir(), "audit.pem")
+		pubPath := filepath.Join(filepath.Dir(keyPath), "audit.pub.pem")
+
+		output, err := executeTestCommand(t, "--db", dbPath, "audit", "keygen", "-o", keyPath)
+		require.NoError(t, err)
+		assert.Contains(t, output, pubPath)
+
+		_, err = executeTestCommand(t, "--db", dbPath, "network", "add", "172.25.0.0/24")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.25.0.0/24")
+		require.NoError(t, err)
+
+		// Nothing is verifiable before the first anchor
+		_, err = executeTestCommand(t, "--db", dbPath, "audit", "verify", "-p", pubPath)
+		assert.Error(t, err)
+
+		output, err = executeTestCommand(t, "--db", dbPath, "audit", "anchor", "-k", keyPath)
+		require.NoError(t, err)
+		assert.Contains(t, output, "Anchored")
+
+		_, err = executeTestCommand(t, "--db", dbPath, "release", "172.25.0.1")
+		require.NoError(t, err)
+
+		output, err = executeTestCommand(t, "--db", dbPath, "audit", "verify", "-p", pubPath)
+		require.NoError(t, err)
+		assert.Contains(t, output, "Audit log verified")
+		assert.Contains(t, output, "not yet verifiable")
+
+		_, err = executeTestCommand(t, "--db", dbPath, "audit", "verify", "-p", pubPath, "--max-age", "1ns")
+		assert.ErrorContains(t, err, "--max-age")
+
+		// A server's log verifies the same way
+		srv := httptest.NewServer(api.NewServer(ipam.New(pebbleStore), pebbleStore))
+		output, err = executeTestCommand(t, "--db", dbPath, "audit", "verify", "-p", pubPath, "--server", srv.URL)
+		srv.Close()
+		require.NoError(t, err)
+		assert.Contains(t, output, "Audit log verified")
+
+		// Rewriting an anchored entry is detected
+		entries, err := pebbleStore.ListAuditEntries(0)
+		require.NoError(t, err)
+		entry := entries[len(entries)-1]
+		entry.Details = "rewritten"
+		require.NoError(t, pebbleStore.SaveAuditEntry(entry))
+
+		_, err = executeTestCommand(t, "--db", dbPath, "audit", "verify", "-p", pubPath)
+		assert.ErrorIs(t, err, auditlog.ErrTampered)
+	})
+}
+
+func TestReconcileCommand(t *testing.T) {
+	runTest(t, "ReconcileARPDump", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "172.22.0.0/24")
+		require.NoError(t, err)
+
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.22.0.0/24", "-k", "2")
+		require.NoError(t, err)
+
+		arp := filepath.Join(t.TempDir(), "arp.txt")
+		require.NoError(t, os.WriteFile(arp, []byte(
+			"? (172.22.0.1) at 00:11:22:33:44:55 [ether] on eth0\n"+
+				"? (172.22.0.9) at 00:11:22:33:44:66 [ether] on eth0\n"), 0644))
+
+		output, err := executeTestCommand(t, "--db", dbPath, "reconcile", "-c", "172.22.0.0/24", arp)
+		require.NoError(t, err)
+		assert.Contains(t, output, "Matched:    1")
+		assert.Contains(t, output, "In use but not recorded (1):\n  172.22.0.9")
+		assert.Contains(t, output, "Recorded but not observed (1):\n  172.22.0.2")
+	})
+
+	runTest(t, "ReconcileImportScan", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "172.22.1.0/24")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.22.1.0/24", "-H", "web")
+		require.NoError(t, err)
+
+		scan := filepath.Join(t.TempDir(), "scan.xml")
+		require.NoError(t, os.WriteFile(scan, []byte(`<nmaprun><host><status state="up"/><address addr="172.22.1.1" addrtype="ipv4"/></host>
+<host><status state="up"/><address addr="172.22.1.77" addrtype="ipv4"/><hostnames><hostname name="nas" type="PTR"/></hostnames></host></nmaprun>`), 0644))
+
+		output, err := executeTestCommand(t, "--db", dbPath, "reconcile", "-c", "172.22.1.0/24", "-f", "nmap", "--import", scan)
+		require.NoError(t, err)
+		assert.Contains(t, output, "Seen:       1")
+		assert.Contains(t, output, "Discovered: 1")
+		assert.Contains(t, output, "Matched:    1")
+		assert.Contains(t, output, "In use but not recorded (0):")
+		assert.Contains(t, output, "Discovered by scans, not reviewed (1):\n  172.22.1.77")
+
+		_, err = executeTestCommand(t, "--db", dbPath, "reconcile", "-c", "172.22.1.0/24", "--import", scan)
+		assert.Error(t, err)
+	})
+
+	runTest(t, "ReconcileRequiresNetwork", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "reconcile", "-")
+		assert.Error(t, err)
+		assert.Contains(t, output, "either --network-id or --cidr must be specified")
+	})
+}
+
+func TestNeighborsCommand(t *testing.T) {
+	runTest(t, "RecordAndFlagConflicts", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "172.22.2.0/24")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.22.2.0/24", "-t", "mac=00:11:22:33:44:55")
+		require.NoError(t, err)
+
+		table := filepath.Join(t.TempDir(), "neigh.txt")
+		require.NoError(t, os.WriteFile(table, []byte(
+			"172.22.2.1 dev eth0 lladdr 00:11:22:33:44:aa REACHABLE\n"+
+				"172.22.2.8 dev eth0 lladdr 00:11:22:33:44:bb STALE\n"), 0644))
+
+		output, err := executeTestCommand(t, "--db", dbPath, "neighbors", table)
+		require.NoError(t, err)
+		assert.Contains(t, output, "Matched:    1")
+		assert.Contains(t, output, "MAC conflicts (1):")
+		assert.Contains(t, output, "recorded 00:11:22:33:44:55, observed 00:11:22:33:44:aa")
+		assert.Contains(t, output, "In use but not allocated (1):\n  172.22.2.8")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "list", "--filter", "tag=mac-conflict")
+		require.NoError(t, err)
+		assert.Contains(t, output, "172.22.2.1")
+	})
+}
+
+func TestNeutronCommand(t *testing.T) {
+	runTest(t, "Sync", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/v3/auth/tokens":
+				w.Header().Set("X-Subject-Token", "tok")
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprintf(w, `{"token": {"catalog": [{"type": "network", "endpoints": [{"interface": "public", "region": "RegionOne", "url": %q}]}]}}`, server.URL)
+			case "/v2.0/subnets":
+				fmt.Fprint(w, `{"subnets": [{"id": "sn1", "name": "tenant-net", "network_id": "n1", "project_id": "acme", "cidr": "172.26.0.0/24", "gateway_ip": "172.26.0.1"}]}`)
+			case "/v2.0/ports":
+				fmt.Fprint(w, `{"ports": [{"id": "p1", "mac_address": "fa:16:3e:00:00:01", "device_owner": "compute:nova", "dns_name": "vm1", "fixed_ips": [{"subnet_id": "sn1", "ip_address": "172.26.0.10"}]}]}`)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer server.Close()
+		t.Setenv("OS_USERNAME", "admin")
+		t.Setenv("OS_PASSWORD", "secret")
+		t.Setenv("OS_PROJECT_NAME", "admin")
+
+		output, err := executeTestCommand(t, "--db", dbPath, "neutron", "sync", "--auth-url", server.URL, "--dry-run")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Networks created:     1")
+		_, err = pebbleStore.GetNetworkByCIDR("172.26.0.0/24")
+		assert.Error(t, err)
+
+		output, err = executeTestCommand(t, "--db", dbPath, "neutron", "sync", "--auth-url", server.URL)
+		require.NoError(t, err)
+		assert.Contains(t, output, "Allocations created:  1")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "list", "--filter", "tag=neutron-port=p1")
+		require.NoError(t, err)
+		assert.Contains(t, output, "172.26.0.10")
+		assert.Contains(t, output, "vm1")
+	})
+
+	runTest(t, "RequiresAuthURL", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+		t.Setenv("OS_AUTH_URL", "")
+
+		output, err := executeTestCommand(t, "--db", dbPath, "neutron", "sync")
+		assert.Error(t, err)
+		assert.Contains(t, output, "OS_AUTH_URL is required")
+	})
+}
+
+func TestVMsCommand(t *testing.T) {
+	runTest(t, "SyncProxmox", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api2/json/cluster/resources":
+				fmt.Fprint(w, `{"data": [{"type": "lxc", "vmid": 300, "name": "pihole", "node": "pve", "status": "running"}]}`)
+			case "/api2/json/nodes/pve/lxc/300/interfaces":
+				fmt.Fprint(w, `{"data": [{"name": "eth0", "hwaddr": "bc:24:11:00:00:03", "inet": "172.27.0.53/24"}]}`)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer server.Close()
+		tokenFile := filepath.Join(t.TempDir(), "token")
+		require.NoError(t, os.WriteFile(tokenFile, []byte("ipam@pve!sync=secret\n"), 0600))
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "172.27.0.0/24")
+		require.NoError(t, err)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "vms", "sync", "--proxmox", server.URL, "--proxmox-token-file", tokenFile)
+		require.NoError(t, err)
+		assert.Contains(t, output, "Created (1):\n  172.27.0.53")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "list", "--filter", "tag=vm=proxmox/300")
+		require.NoError(t, err)
+		assert.Contains(t, output, "pihole")
+	})
+
+	runTest(t, "RequiresHypervisor", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "vms", "sync")
+		assert.Error(t, err)
+		assert.Contains(t, output, "either --proxmox or --libvirt must be specified")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "vms", "sync", "--libvirt", "", "--libvirt-source", "dhcp")
+		assert.Error(t, err)
+		assert.Contains(t, output, "invalid --libvirt-source")
+	})
+}
+
+func TestKeaCommand(t *testing.T) {
+	runTest(t, "Sync", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		var added []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if user, pass, _ := r.BasicAuth(); user != "ipam" || pass != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			var req struct {
+				Command   string          `json:"command"`
+				Arguments json.RawMessage `json:"arguments"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			switch req.Command {
+			case "config-get":
+				fmt.Fprint(w, `[{"result": 0, "arguments": {"Dhcp4": {"subnet4": [{"id": 5, "subnet": "172.28.0.0/24"}]}}}]`)
+			case "reservation-get-all":
+				fmt.Fprint(w, `[{"result": 3, "text": "0 IPv4 host(s) found."}]`)
+			case "reservation-add":
+				added = append(added, string(req.Arguments))
+				fmt.Fprint(w, `[{"result": 0, "text": "Host added."}]`)
+			}
+		}))
+		defer server.Close()
+		credentials := filepath.Join(t.TempDir(), "credentials")
+		require.NoError(t, os.WriteFile(credentials, []byte("ipam:secret\n"), 0600))
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "172.28.0.0/24")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.28.0.0/24", "-H", "printer", "-t", "mac=00:11:22:33:44:55")
+		require.NoError(t, err)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "kea", "sync", "--url", server.URL, "--credentials-file", credentials)
+		require.NoError(t, err)
+		assert.Contains(t, output, "Added:      1")
+		require.Len(t, added, 1)
+		assert.Contains(t, added[0], `"hw-address":"00:11:22:33:44:55"`)
+		assert.Contains(t, added[0], `"hostname":"printer"`)
+	})
+
+	runTest(t, "Export", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "172.28.1.0/24",
+			"-t", "pool=static:172.28.1.10-172.28.1.99,pool=dhcp:172.28.1.100-172.28.1.200,dns=172.28.1.53")
+		require.NoError(t, err)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "kea", "export")
+		require.NoError(t, err)
+		var config map[string][]kea.SubnetConfig
+		require.NoError(t, json.Unmarshal([]byte(output), &config))
+		assert.Equal(t, []kea.SubnetConfig{{
+			Subnet:     "172.28.1.0/24",
+			Pools:      []kea.PoolConfig{{Pool: "172.28.1.100 - 172.28.1.200"}},
+			OptionData: []kea.OptionData{{Name: "domain-name-servers", Data: "172.28.1.53"}},
+		}}, config["subnet4"])
+
+		output, err = executeTestCommand(t, "--db", dbPath, "kea", "export", "--service", "dhcp6")
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"subnet6": []}`, output)
+	})
+
+	runTest(t, "RequiresURL", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "kea", "sync")
+		assert.Error(t, err)
+		assert.Contains(t, output, "--url is required")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "kea", "sync", "--url", "http://kea:8000", "--service", "dhcp-ddns")
+		assert.Error(t, err)
+		assert.Contains(t, output, "invalid --service")
+	})
+}
+
+func TestPowerDNSCommand(t *testing.T) {
+	runTest(t, "Sync", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		var patched []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-API-Key") != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			switch {
+			case r.URL.Path == "/api/v1/servers/localhost/zones":
+				fmt.Fprint(w, `[{"id": "example.com.", "name": "example.com.", "kind": "Native"}]`)
+			case r.Method == http.MethodGet:
+				fmt.Fprint(w, `{"id": "example.com.", "name": "example.com.", "rrsets": []}`)
+			default:
+				var body bytes.Buffer
+				body.ReadFrom(r.Body)
+				patched = append(patched, body.String())
+				w.WriteHeader(http.StatusNoContent)
+			}
+		}))
+		defer server.Close()
+		keyFile := filepath.Join(t.TempDir(), "pdns-key")
+		require.NoError(t, os.WriteFile(keyFile, []byte("secret\n"), 0600))
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "172.29.0.0/24", "-t", "site=lab")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.29.0.0/24", "-H", "printer")
+		require.NoError(t, err)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "powerdns", "sync", "--url", server.URL, "--api-key-file", keyFile, "--domain", "example.com")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Created:    1")
+		assert.Contains(t, output, "Names without a zone (1):\n  1.0.29.172.in-addr.arpa.")
+		require.Len(t, patched, 1)
+		assert.Contains(t, patched[0], `"name":"printer.example.com."`)
+		assert.Contains(t, patched[0], `"content":"172.29.0.1"`)
+	})
+
+	runTest(t, "RequiresURL", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "powerdns", "sync")
+		assert.Error(t, err)
+		assert.Contains(t, output, "--url is required")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "powerdns", "sync", "--url", "http://pdns:8081")
 		assert.Error(t, err)
-		assert.Contains(t, output, "network not found")
+		assert.Contains(t, output, "--url requires --api-key-file")
 	})
+}
 
-	runTest(t, "AllocateInvalidCount", func(t *testing.T) {
+func TestTransferCommand(t *testing.T) {
+	runTest(t, "TransferToContainingNetwork", func(t *testing.T) {
 		dbPath := setupTestDB(t)
 
-		// Setup network
-		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "10.0.0.0/24")
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "172.23.0.0/16")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.23.0.0/16")
 		require.NoError(t, err)
 
-		// Test negative count
-		output, err := executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.0.0.0/24", "-k", "-1", "-d", "Temporary", "-H", "server1", "-t", "web,prod")
-		assert.Error(t, err)
-		assert.Contains(t, output, "count must be at least 1")
+		_, err = executeTestCommand(t, "--db", dbPath, "network", "add", "172.23.0.0/24")
+		require.NoError(t, err)
+		target, err := pebbleStore.GetNetworkByCIDR("172.23.0.0/24")
+		require.NoError(t, err)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "transfer", "172.23.0.1", "--to", target.ID)
+		require.NoError(t, err)
+		assert.Contains(t, output, "transferred to network 172.23.0.0/24")
+
+		allocations, err := pebbleStore.ListAllocations(target.ID)
+		require.NoError(t, err)
+		require.Len(t, allocations, 1)
+		assert.Equal(t, "172.23.0.1", allocations[0].IP)
 	})
 
-	runTest(t, "AllocateIPv6", func(t *testing.T) {
+	runTest(t, "TransferOutOfRange", func(t *testing.T) {
 		dbPath := setupTestDB(t)
 
-		// Setup IPv6 network
-		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "2001:db8:1::/64")
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "172.24.0.0/24")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.24.0.0/24")
 		require.NoError(t, err)
 
-		// Allocate IPv6
-		output, err := executeTestCommand(t, "--db", dbPath, "allocate", "-c", "2001:db8:1::/64", "-H", "ipv6-host")
+		_, err = executeTestCommand(t, "--db", dbPath, "network", "add", "172.25.0.0/24")
 		require.NoError(t, err)
-		assert.Contains(t, output, "IP allocated successfully")
-		assert.Contains(t, output, "2001:db8:1::1")
+		target, err := pebbleStore.GetNetworkByCIDR("172.25.0.0/24")
+		require.NoError(t, err)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "transfer", "172.24.0.1", "--to", target.ID)
+		assert.Error(t, err)
+		assert.Contains(t, output, "does not contain")
 	})
 }
 
-func TestListCommand(t *testing.T) {
-	runTest(t, "ListAllocations", func(t *testing.T) {
+func TestWhoisCommand(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`{"handle": "NET-192-0-2-0", "name": "EXAMPLE", "startAddress": "198.51.100.0",
+			"endAddress": "198.51.100.255", "port43": "whois.ripe.net",
+			"entities": [{"roles": ["registrant"], "vcardArray": ["vcard", [["fn", {}, "text", "Example Org"]]]}]}`))
+	}))
+	defer srv.Close()
+
+	oldBaseURL := rdapBaseURL
+	rdapBaseURL = srv.URL
+	defer func() { rdapBaseURL = oldBaseURL }()
+
+	runTest(t, "WhoisAnnotate", func(t *testing.T) {
 		dbPath := setupTestDB(t)
+		requests = 0
 
-		// Setup
-		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "10.10.0.0/24")
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "198.51.100.0/24", "-t", "edge")
 		require.NoError(t, err)
 
-		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.10.0.0/24", "-H", "host1")
+		output, err := executeTestCommand(t, "--db", dbPath, "whois", "198.51.100.0/24", "--annotate")
 		require.NoError(t, err)
+		assert.Contains(t, output, "RIR:         RIPE NCC")
+		assert.Contains(t, output, "Org:         Example Org")
+		assert.Contains(t, output, "edge, rir=RIPE-NCC, rdap=NET-192-0-2-0")
 
-		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.10.0.0/24", "-H", "host2", "-d", "Database server")
+		// Served from the local cache
+		_, err = executeTestCommand(t, "--db", dbPath, "whois", "198.51.100.0/24")
 		require.NoError(t, err)
+		assert.Equal(t, 1, requests)
+	})
 
-		// List allocations
-		output, err := executeTestCommand(t, "--db", dbPath, "list")
-		require.NoError(t, err)
-		assert.Contains(t, output, "10.10.0.1")
-		assert.Contains(t, output, "10.10.0.2")
-		assert.Contains(t, output, "host1")
-		assert.Contains(t, output, "host2")
-		assert.Contains(t, output, "Database server")
-		assert.Contains(t, output, "allocated")
+	runTest(t, "WhoisPrivatePrefix", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "whois", "10.0.0.0/8")
+		assert.Error(t, err)
+		assert.Contains(t, output, "not publicly routable")
 	})
+}
 
-	runTest(t, "ListWithReleasedIPs", func(t *testing.T) {
+func TestLocateCommand(t *testing.T) {
+	runTest(t, "LocateAllocatedIP", func(t *testing.T) {
 		dbPath := setupTestDB(t)
 
-		// Setup
-		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "10.11.0.0/24")
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "172.26.0.0/16")
 		require.NoError(t, err)
-
-		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.11.0.0/24", "-H", "host1")
+		_, err = executeTestCommand(t, "--db", dbPath, "network", "add", "172.26.5.0/24")
 		require.NoError(t, err)
-
-		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.11.0.0/24", "-H", "host2")
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.26.5.0/24", "-H", "web01")
 		require.NoError(t, err)
 
-		// Release an IP
-		_, err = executeTestCommand(t, "--db", dbPath, "release", "10.11.0.1")
+		output, err := executeTestCommand(t, "--db", dbPath, "locate", "172.26.5.1")
 		require.NoError(t, err)
+		assert.Contains(t, output, "Network:     172.26.5.0/24")
+		assert.Contains(t, output, "Within:      172.26.0.0/16")
+		assert.Contains(t, output, "Status:      active")
+		assert.Contains(t, output, "Hostname:    web01")
+	})
 
-		// List without --all should not show released
-		output, err := executeTestCommand(t, "--db", dbPath, "list")
-		require.NoError(t, err)
-		assert.NotContains(t, output, "10.11.0.1")
-		assert.Contains(t, output, "10.11.0.2")
+	runTest(t, "LocateOutsideNetworks", func(t *testing.T) {
+		dbPath := setupTestDB(t)
 
-		// List with --all should show released
-		output, err = executeTestCommand(t, "--db", dbPath, "list", "--all")
-		require.NoError(t, err)
-		assert.Contains(t, output, "10.11.0.1")
-		assert.Contains(t, output, "10.11.0.2")
-		assert.Contains(t, output, "released")
+		output, err := executeTestCommand(t, "--db", dbPath, "locate", "203.0.113.1")
+		assert.Error(t, err)
+		assert.Contains(t, output, "failed to locate")
 	})
+}
 
-	runTest(t, "ListEmpty", func(t *testing.T) {
+func TestExitCodes(t *testing.T) {
+	markArgErrors(rootCmd)
+
+	runTest(t, "ExitCodePerErrorClass", func(t *testing.T) {
 		dbPath := setupTestDB(t)
 
-		// List empty allocations
-		output, err := executeTestCommand(t, "--db", dbPath, "list")
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "10.16.0.0/30")
 		require.NoError(t, err)
-		assert.Contains(t, output, "No allocations found")
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.16.0.0/30", "-k", "2")
+		require.NoError(t, err)
+		network, err := pebbleStore.GetNetworkByCIDR("10.16.0.0/30")
+		require.NoError(t, err)
+
+		_, err = executeTestCommand(t, "--db", dbPath, "release", "10.99.0.1")
+		assert.Equal(t, ExitNotFound, ExitCode(err))
+
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.16.0.0/30")
+		assert.Equal(t, ExitNoAvailableIPs, ExitCode(err))
+
+		_, err = executeTestCommand(t, "--db", dbPath, "network", "delete", network.ID)
+		assert.Equal(t, ExitConflict, ExitCode(err))
+
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.16.0.0/30", "-k", "0")
+		assert.Equal(t, ExitValidation, ExitCode(err))
+
+		_, err = executeTestCommand(t, "--db", dbPath, "release")
+		assert.Equal(t, ExitValidation, ExitCode(err))
+
+		_, err = executeTestCommand(t, "--db", dbPath, "list", "--no-such-flag")
+		assert.Equal(t, ExitValidation, ExitCode(err))
+
+		assert.Equal(t, ExitOK, ExitCode(nil))
+		assert.Equal(t, ExitError, ExitCode(fmt.Errorf("boom")))
 	})
 }
 
-func TestReleaseCommand(t *testing.T) {
-	runTest(t, "ReleaseIP", func(t *testing.T) {
+func TestStatsCommand(t *testing.T) {
+	runTest(t, "StatsCSV", func(t *testing.T) {
 		dbPath := setupTestDB(t)
 
-		// Setup
-		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "172.20.0.0/24")
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "10.13.0.0/24")
 		require.NoError(t, err)
 
-		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.20.0.0/24")
+		output, err := executeTestCommand(t, "--db", dbPath, "stats", "--format", "csv", "--columns", "network,total,utilization")
 		require.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(output), "\n")
+		require.Len(t, lines, 2)
+		assert.Equal(t, "network,total,utilization", lines[0])
+		assert.True(t, strings.HasPrefix(lines[1], "10.13.0.0/24,"), lines[1])
+		assert.False(t, strings.HasSuffix(lines[1], "%"))
+	})
 
-		// Release IP
-		output, err := executeTestCommand(t, "--db", dbPath, "release", "172.20.0.1")
+	runTest(t, "StatsBreakdown", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "10.14.0.0/29")
 		require.NoError(t, err)
-		assert.Contains(t, output, "IP 172.20.0.1 released successfully")
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.14.0.0/29", "--tags", "gateway")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.14.0.0/29", "-k", "2")
+		require.NoError(t, err)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "stats", "--format", "csv", "--columns", "total,netaddr,broadcast,gateway,ranges,holds,free")
+		require.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(output), "\n")
+		require.Len(t, lines, 2)
+		assert.Equal(t, "8,1,1,1,0,0,3", lines[1])
 	})
 
-	runTest(t, "ReleaseNonExistentIP", func(t *testing.T) {
+	runTest(t, "StatsExpired", func(t *testing.T) {
 		dbPath := setupTestDB(t)
 
-		// Try to release non-existent IP
-		output, err := executeTestCommand(t, "--db", dbPath, "release", "1.2.3.4")
-		assert.Error(t, err)
-		assert.Contains(t, output, "not found")
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "10.16.0.0/29")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.16.0.0/29", "-k", "2")
+		require.NoError(t, err)
+		network, err := pebbleStore.GetNetworkByCIDR("10.16.0.0/29")
+		require.NoError(t, err)
+		past := time.Now().Add(-time.Hour)
+		require.NoError(t, pebbleStore.SaveAllocation(&ipam.IPAllocation{ID: "expired-1", NetworkID: network.ID, IP: "10.16.0.5", ExpiresAt: &past, AllocatedAt: past}))
+
+		output, err := executeTestCommand(t, "--db", dbPath, "stats", "--format", "csv", "--columns", "network,expired,free")
+		require.NoError(t, err)
+		assert.Equal(t, "network,expired,free\n10.16.0.0/29,1,3\n", output)
+
+		output, err = executeTestCommand(t, "--db", dbPath, "stats")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Expired")
 	})
 
-	runTest(t, "ReleaseAlreadyReleasedIP", func(t *testing.T) {
+	runTest(t, "StatsGroupBy", func(t *testing.T) {
 		dbPath := setupTestDB(t)
 
-		// Setup
-		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "172.21.0.0/24")
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "10.15.0.0/29", "--tags", "site=ams,env=prod")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "network", "add", "10.15.1.0/29", "--tags", "site=ams")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "network", "add", "10.15.2.0/29", "--tags", "")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.15.0.0/29", "-k", "2")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.15.1.0/29")
 		require.NoError(t, err)
 
-		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.21.0.0/24")
+		output, err := executeTestCommand(t, "--db", dbPath, "stats", "--group-by", "site", "--format", "csv", "--columns", "group,networks,total,allocated")
 		require.NoError(t, err)
+		assert.Equal(t, "group,networks,total,allocated\n,1,8,0\nams,2,16,3\n", output)
 
-		// Release IP
-		_, err = executeTestCommand(t, "--db", dbPath, "release", "172.21.0.1")
+		output, err = executeTestCommand(t, "--db", dbPath, "stats", "--group-by", "tag")
 		require.NoError(t, err)
+		assert.Contains(t, output, "(none)")
+		assert.Contains(t, output, "env=prod")
+		assert.Contains(t, output, "site=ams")
 
-		// Try to release again
-		output, err := executeTestCommand(t, "--db", dbPath, "release", "172.21.0.1")
-		assert.Error(t, err)
-		// The error message says "not found" instead of "not allocated"
-		assert.Contains(t, output, "not found")
+		_, err = executeTestCommand(t, "--db", dbPath, "stats", "--group-by", "site=ams")
+		assert.Equal(t, ExitValidation, ExitCode(err))
 	})
-}
 
-func TestStatsCommand(t *testing.T) {
 	runTest(t, "ShowStats", func(t *testing.T) {
 		dbPath := setupTestDB(t)
 
@@ -659,6 +2425,52 @@ func TestServerCommand(t *testing.T) {
 		assert.Contains(t, output, "--cluster")
 		assert.Contains(t, output, "--config")
 	})
+
+	runTest(t, "ServerConfigFile", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "server.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(`
+listen: 127.0.0.1:9090
+store:
+  dsn: /var/lib/ipam
+backups:
+  dir: /var/backups/ipam
+  keep: 3
+health:
+  ports: [22, 443]
+notify:
+  channels:
+    slack: slack+https://hooks.slack.com/services/T0/B0/x
+    ops: https://example.com/hook
+`), 0o600))
+
+		cmd := &cobra.Command{Use: "server"}
+		cmd.Flags().String("address", "", "")
+		cmd.Flags().String("db", "ipam-data", "")
+		cmd.Flags().String("backup-dir", "", "")
+		cmd.Flags().Int("backup-keep", 7, "")
+		cmd.Flags().String("health-ports", "22,80,443", "")
+		cmd.Flags().StringArray("notify", nil, "")
+		require.NoError(t, cmd.ParseFlags([]string{"--db", "/tmp/ipam"}))
+
+		configFile = path
+		defer func() { configFile, serverConfig = "", nil }()
+		require.NoError(t, applyServerConfig(cmd))
+
+		get := func(name string) string { return cmd.Flags().Lookup(name).Value.String() }
+		assert.Equal(t, "127.0.0.1:9090", get("address"))
+		assert.Equal(t, "/tmp/ipam", get("db"), "command line flags override the file")
+		assert.Equal(t, "/var/backups/ipam", get("backup-dir"))
+		assert.Equal(t, "3", get("backup-keep"))
+		assert.Equal(t, "22,443", get("health-ports"))
+		notify, _ := cmd.Flags().GetStringArray("notify")
+		assert.Equal(t, []string{"ops=https://example.com/hook", "slack=slack+https://hooks.slack.com/services/T0/B0/x"}, notify)
+
+		// Invalid files are validation errors
+		require.NoError(t, os.WriteFile(path, []byte("store:\n  driver: postgres\n"), 0o600))
+		err := applyServerConfig(cmd)
+		assert.ErrorContains(t, err, "unknown driver")
+		assert.Equal(t, ExitValidation, ExitCode(err))
+	})
 }
 
 func TestHelpCommands(t *testing.T) {
@@ -712,7 +2524,7 @@ func TestInputValidation(t *testing.T) {
 		// Test missing IP
 		output, _ := executeTestCommand(t, "--db", dbPath, "release")
 		// Either an error or help output with the error message
-		assert.Contains(t, output, "accepts 1 arg(s), received 0")
+		assert.Contains(t, output, "requires at least 1 arg(s), only received 0")
 	})
 
 	runTest(t, "NetworkDeleteMissingID", func(t *testing.T) {
@@ -917,3 +2729,330 @@ func TestIPv6Operations(t *testing.T) {
 		assert.Contains(t, output, "2001:db8:100::a") // ::a is hex for 10
 	})
 }
+
+func TestHealthCommand(t *testing.T) {
+	runTest(t, "CheckAndReport", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer ln.Close()
+		port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+
+		_, err = executeTestCommand(t, "--db", dbPath, "network", "add", "127.0.0.0/24")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "127.0.0.0/24", "-H", "loopback")
+		require.NoError(t, err)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "health", "check", "-c", "127.0.0.0/24", "--ports", port)
+		require.NoError(t, err)
+		assert.Contains(t, output, "Probed 1 allocations: 1 up")
+
+		network, err := pebbleStore.GetNetworkByCIDR("127.0.0.0/24")
+		require.NoError(t, err)
+		allocations, err := pebbleStore.ListAllocations(network.ID)
+		require.NoError(t, err)
+		require.Len(t, allocations, 1)
+		alloc := allocations[0]
+		_, seen := health.LastSeen(alloc)
+		assert.True(t, seen)
+
+		// An allocation that went silent long ago is a reclamation candidate
+		old := time.Now().Add(-45 * 24 * time.Hour)
+		alloc.Tags = []string{health.LastSeenTagPrefix + old.UTC().Format(time.RFC3339), health.StaleTag}
+		require.NoError(t, pebbleStore.SaveAllocation(alloc))
+
+		output, err = executeTestCommand(t, "--db", dbPath, "health", "report", "--days", "30")
+		require.NoError(t, err)
+		assert.Contains(t, output, "127.0.0.1")
+		assert.Contains(t, output, "loopback")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "health", "report", "--days", "60")
+		require.NoError(t, err)
+		assert.Contains(t, output, "No allocations silent for 60 days")
+
+		_, err = executeTestCommand(t, "--db", dbPath, "health", "check", "--ports", "http")
+		assert.Equal(t, ExitValidation, ExitCode(err))
+	})
+}
+
+func TestReclaimCommand(t *testing.T) {
+	runTest(t, "PolicyAndRun", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "172.26.0.0/24")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.26.0.0/24", "-t", "ephemeral")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "172.26.0.0/24", "-t", "prod")
+		require.NoError(t, err)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "reclaim", "policy", "172.26.0.0/24", "--after", "30d", "--grace", "1h", "--tag", "ephemeral")
+		require.NoError(t, err)
+		assert.Contains(t, output, "reclaim after 30d silent, 1h0m0s grace, tagged ephemeral")
+
+		_, err = executeTestCommand(t, "--db", dbPath, "reclaim", "policy", "172.26.0.0/24", "--after", "soon")
+		assert.Equal(t, ExitValidation, ExitCode(err))
+
+		// Both allocations went silent 45 days ago
+		network, err := pebbleStore.GetNetworkByCIDR("172.26.0.0/24")
+		require.NoError(t, err)
+		allocations, err := pebbleStore.ListAllocations(network.ID)
+		require.NoError(t, err)
+		lastSeen := health.LastSeenTagPrefix + time.Now().Add(-45*24*time.Hour).UTC().Format(time.RFC3339)
+		for _, alloc := range allocations {
+			alloc.Tags = append(alloc.Tags, lastSeen, health.StaleTag)
+			require.NoError(t, pebbleStore.SaveAllocation(alloc))
+		}
+
+		// Events are posted to the webhook, signed with the secret
+		var verified []error
+		hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := webhook.Verify(r, []byte("s3cret"), nil)
+			verified = append(verified, err)
+		}))
+		defer hook.Close()
+		secretFile := filepath.Join(t.TempDir(), "webhook-secret")
+		require.NoError(t, os.WriteFile(secretFile, []byte("# reclaim\ns3cret\n"), 0o600))
+
+		output, err = executeTestCommand(t, "--db", dbPath, "reclaim", "run", "--webhook", hook.URL, "--webhook-secret-file", secretFile)
+		require.NoError(t, err)
+		assert.Contains(t, output, "172.26.0.1 (172.26.0.0/24) will be reclaimed")
+		assert.NotContains(t, output, "172.26.0.2")
+		assert.Equal(t, []error{nil}, verified)
+
+		// Still within the grace period
+		output, err = executeTestCommand(t, "--db", dbPath, "reclaim", "run")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Nothing to reclaim")
+
+		alloc, err := pebbleStore.GetAllocationByIP(network.ID, "172.26.0.1")
+		require.NoError(t, err)
+		var tags []string
+		for _, tag := range alloc.Tags {
+			if !strings.HasPrefix(tag, reclaim.PendingTagPrefix) {
+				tags = append(tags, tag)
+			}
+		}
+		alloc.Tags = append(tags, reclaim.PendingTagPrefix+time.Now().Add(-time.Minute).UTC().Format(time.RFC3339))
+		require.NoError(t, pebbleStore.SaveAllocation(alloc))
+
+		output, err = executeTestCommand(t, "--db", dbPath, "reclaim", "run")
+		require.NoError(t, err)
+		assert.Contains(t, output, "172.26.0.1 (172.26.0.0/24) reclaimed")
+
+		_, err = executeTestCommand(t, "--db", dbPath, "release", "172.26.0.1")
+		assert.Equal(t, ExitConflict, ExitCode(err))
+		assert.Contains(t, err.Error(), "by reclaim")
+	})
+}
+
+func TestLoadtestCommand(t *testing.T) {
+	runTest(t, "Run", func(t *testing.T) {
+		remote, err := store.NewPebbleStore(filepath.Join(t.TempDir(), "remote"))
+		require.NoError(t, err)
+		defer remote.Close()
+		srv := httptest.NewServer(api.NewServer(ipam.New(remote), remote))
+		defer srv.Close()
+
+		output, err := executeTestCommand(t, "loadtest", "--server", srv.URL, "--networks", "2", "--rate", "100/s", "--duration", "300ms")
+		require.NoError(t, err)
+		assert.Contains(t, output, "allocate")
+		assert.Contains(t, output, "Throughput:")
+
+		networks, err := remote.ListNetworks()
+		require.NoError(t, err)
+		assert.Empty(t, networks, "test networks are removed")
+	})
+
+	runTest(t, "InvalidFlags", func(t *testing.T) {
+		_, err := executeTestCommand(t, "loadtest", "--server", "http://localhost:8080", "--rate", "fast")
+		assert.Equal(t, ExitValidation, ExitCode(err))
+
+		_, err = executeTestCommand(t, "loadtest", "--rate", "10/s")
+		assert.Equal(t, ExitValidation, ExitCode(err))
+	})
+}
+
+func TestHealthcheckCommand(t *testing.T) {
+	runTest(t, "Ready", func(t *testing.T) {
+		remote, err := store.NewPebbleStore(filepath.Join(t.TempDir(), "remote"))
+		require.NoError(t, err)
+		defer remote.Close()
+		srv := httptest.NewServer(api.NewServer(ipam.New(remote), remote))
+		defer srv.Close()
+
+		output, err := executeTestCommand(t, "healthcheck", "--server", srv.URL)
+		require.NoError(t, err)
+		assert.Empty(t, output)
+	})
+
+	runTest(t, "NotReady", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{"code": "not_ready", "message": "cluster 1 has no leader"}`)
+		}))
+		defer srv.Close()
+
+		_, err := executeTestCommand(t, "healthcheck", "--server", srv.URL)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "503 Service Unavailable: cluster 1 has no leader")
+		assert.Equal(t, ExitError, ExitCode(err))
+
+		srv.Close()
+		_, err = executeTestCommand(t, "healthcheck", "--server", srv.URL)
+		require.Error(t, err)
+		assert.Equal(t, ExitError, ExitCode(err))
+	})
+
+	runTest(t, "Wait", func(t *testing.T) {
+		var requests int
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/readyz", r.URL.Path)
+			if requests++; requests < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		defer srv.Close()
+
+		_, err := executeTestCommand(t, "healthcheck", "--server", srv.URL+"/", "--wait", "10s")
+		require.NoError(t, err)
+		assert.Equal(t, 3, requests)
+	})
+}
+
+func TestReplicationCommand(t *testing.T) {
+	runTest(t, "StatusAndPromote", func(t *testing.T) {
+		primaryStore, err := store.NewPebbleStore(filepath.Join(t.TempDir(), "primary"))
+		require.NoError(t, err)
+		defer primaryStore.Close()
+		primary := httptest.NewServer(api.NewServer(ipam.New(primaryStore), primaryStore))
+		defer primary.Close()
+		_, err = ipam.New(primaryStore).AddNetwork("10.0.0.0/24", "primary", nil)
+		require.NoError(t, err)
+
+		standbyStore, err := store.NewPebbleStore(filepath.Join(t.TempDir(), "standby"))
+		require.NoError(t, err)
+		defer standbyStore.Close()
+		server := api.NewServer(ipam.New(standbyStore), standbyStore)
+		replica := &replication.Replica{Primary: primary.URL, Store: standbyStore, StateDir: t.TempDir()}
+		server.SetReplica(replica)
+		server.SetAuthTokens([]string{"secret"})
+		standby := httptest.NewServer(server)
+		defer standby.Close()
+		_, err = replica.Sync(time.Now())
+		require.NoError(t, err)
+
+		output, err := executeTestCommand(t, "replication", "status", "--server", standby.URL, "--token", "secret")
+		require.NoError(t, err)
+		assert.Contains(t, output, "standby (read-only)")
+		assert.Contains(t, output, "1 networks, 0 allocations")
+
+		_, err = executeTestCommand(t, "replication", "promote", "--server", standby.URL)
+		assert.ErrorContains(t, err, "failed to promote 1 of 1 servers")
+
+		output, err = executeTestCommand(t, "replication", "promote", "--server", standby.URL+"/", "--token", "secret")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Promoted "+standby.URL)
+		assert.False(t, replica.Standby())
+	})
+
+	runTest(t, "NotAReplica", func(t *testing.T) {
+		remote, err := store.NewPebbleStore(filepath.Join(t.TempDir(), "remote"))
+		require.NoError(t, err)
+		defer remote.Close()
+		srv := httptest.NewServer(api.NewServer(ipam.New(remote), remote))
+		defer srv.Close()
+
+		_, err = executeTestCommand(t, "replication", "status", "--server", srv.URL)
+		assert.ErrorContains(t, err, "not a replica")
+
+		_, err = executeTestCommand(t, "replication", "promote")
+		assert.Equal(t, ExitValidation, ExitCode(err))
+	})
+}
+
+func TestMigrateCommand(t *testing.T) {
+	runTest(t, "PebbleToClusterAndBack", func(t *testing.T) {
+		dbPath := setupTestDB(t)
+		dataDir := filepath.Join(t.TempDir(), "cluster")
+		clusterConfig := filepath.Join(dataDir, "cluster.json")
+		defer func() { configFile = "" }()
+
+		_, err := executeTestCommand(t, "--db", dbPath, "network", "add", "10.0.0.0/24", "-d", "Migrated")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "--db", dbPath, "allocate", "-c", "10.0.0.0/24", "--hostname", "web-1")
+		require.NoError(t, err)
+		_, err = executeTestCommand(t, "cluster", "init", "--node-id", "1", "--cluster-id", "100",
+			"--raft-addr", "localhost:5591", "--initial-members", "1:localhost:5591", "--single-node", "--data-dir", dataDir)
+		require.NoError(t, err)
+
+		output, err := executeTestCommand(t, "--db", dbPath, "migrate", "--from", "pebble", "--to", "cluster", "--config", clusterConfig, "--dry-run")
+		require.NoError(t, err)
+		assert.Contains(t, output, "Would copy 1 networks, 1 allocations")
+
+		output, err = executeTestCommand(t, "--db", dbPath, "migrate", "--from", "pebble", "--to", "cluster", "--config", clusterConfig)
+		require.NoError(t, err)
+		assert.Contains(t, output, "Copied and verified 1 networks, 1 allocations")
+		assert.Contains(t, output, "ipam server --cluster --config "+clusterConfig)
+
+		// The cluster now holds data, so a second run must not merge into it
+		_, err = executeTestCommand(t, "--db", dbPath, "migrate", "--from", "pebble", "--to", "cluster", "--config", clusterConfig)
+		assert.Equal(t, ExitConflict, ExitCode(err))
+
+		standalone := setupTestDB(t)
+		output, err = executeTestCommand(t, "--db", standalone, "migrate", "--from", "cluster", "--to", "pebble", "--config", clusterConfig)
+		require.NoError(t, err)
+		assert.Contains(t, output, "Copied and verified 1 networks, 1 allocations")
+
+		output, err = executeTestCommand(t, "--db", standalone, "list", "-a")
+		require.NoError(t, err)
+		assert.Contains(t, output, "web-1")
+	})
+
+	runTest(t, "Validation", func(t *testing.T) {
+		_, err := executeTestCommand(t, "migrate", "--from", "pebble", "--to", "pebble")
+		assert.Equal(t, ExitValidation, ExitCode(err))
+
+		_, err = executeTestCommand(t, "migrate", "--from", "pebble", "--to", "etcd")
+		assert.Equal(t, ExitValidation, ExitCode(err))
+
+		_, err = executeTestCommand(t, "--db", setupTestDB(t), "migrate", "--from", "pebble", "--to", "cluster")
+		assert.ErrorContains(t, err, "no database at")
+	})
+}
+
+func TestDefaultDir(t *testing.T) {
+	runTest(t, "UserDir", func(t *testing.T) {
+		base := t.TempDir()
+		userDir := func() (string, error) { return base, nil }
+		assert.Equal(t, filepath.Join(base, "ipam", "data"), defaultDir("ipam-data", userDir, "data"))
+
+		// No per-user directory
+		noDir := func() (string, error) { return "", fmt.Errorf("$HOME is not defined") }
+		assert.Equal(t, "ipam-data", defaultDir("ipam-data", noDir, "data"))
+	})
+
+	runTest(t, "Legacy", func(t *testing.T) {
+		require.NoError(t, os.Mkdir("ipam-data", 0o755))
+		userDir := func() (string, error) { return t.TempDir(), nil }
+		assert.Equal(t, "ipam-data", defaultDir("ipam-data", userDir, "data"), "an existing directory in the working directory is kept")
+	})
+}
+
+func TestAdvertisedAPIURL(t *testing.T) {
+	c := &config.ClusterConfig{RaftAddr: "node1.example.com:5000"}
+	assert.Equal(t, "http://node1.example.com:8080", advertisedAPIURL(c, "0.0.0.0", 8080, "http"), "a wildcard host is replaced by the Raft host")
+	assert.Equal(t, "https://node1.example.com:8443", advertisedAPIURL(c, "", 8443, "https"))
+	assert.Equal(t, "http://[::1]:8080", advertisedAPIURL(c, "::1", 8080, "http"))
+
+	c.APIURL = "https://ipam.example.com"
+	assert.Equal(t, "https://ipam.example.com", advertisedAPIURL(c, "0.0.0.0", 8080, "http"))
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "512B", formatBytes(512))
+	assert.Equal(t, "1.5KB", formatBytes(1536))
+	assert.Equal(t, "142.0MB", formatBytes(142<<20))
+	assert.Equal(t, "2.0GB", formatBytes(2<<30))
+}
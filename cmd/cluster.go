@@ -21,6 +21,9 @@ var (
 	joinCluster      bool
 	initialMembers   string
 	enableSingleNode bool
+	gossipAddr       string
+	gossipSeeds      []string
+	apiURL           string
 )
 
 var clusterCmd = &cobra.Command{
@@ -56,6 +59,9 @@ and starts the first node. Other nodes can then join this cluster.`,
 			Join:             false,
 			InitialMembers:   members,
 			EnableSingleNode: enableSingleNode,
+			GossipAddr:       gossipAddr,
+			GossipSeeds:      gossipSeeds,
+			APIURL:           apiURL,
 		}
 
 		// Validate configuration
@@ -122,6 +128,9 @@ var clusterJoinCmd = &cobra.Command{
 			DataDir:        dataDir,
 			Join:           true,
 			InitialMembers: members,
+			GossipAddr:     gossipAddr,
+			GossipSeeds:    gossipSeeds,
+			APIURL:         apiURL,
 		}
 
 		// Validate configuration
@@ -213,6 +222,15 @@ var clusterStatusCmd = &cobra.Command{
 			fmt.Fprintf(cmd.OutOrStdout(), "  Node %d: %s%s\n", node.NodeID, node.RaftAddr, leaderMark)
 		}
 
+		storage, err := raftStore.Storage()
+		if err != nil {
+			return fmt.Errorf("failed to measure storage: %w", err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "\nStorage (node %d, %s):\n", storage.NodeID, storage.Dir)
+		fmt.Fprintf(cmd.OutOrStdout(), "  Raft Log:          %s\n", formatBytes(storage.LogBytes))
+		fmt.Fprintf(cmd.OutOrStdout(), "  Snapshots:         %s (%d)\n", formatBytes(storage.SnapshotBytes), storage.Snapshots)
+		fmt.Fprintf(cmd.OutOrStdout(), "  Total:             %s\n", formatBytes(storage.TotalBytes))
+
 		return nil
 	},
 }
@@ -256,6 +274,9 @@ func init() {
 	clusterInitCmd.Flags().StringVar(&dataDir, "data-dir", "ipam-cluster-data", "Directory for cluster data")
 	clusterInitCmd.Flags().StringVar(&initialMembers, "initial-members", "", "Initial cluster members (e.g., '1:host1:5000,2:host2:5000')")
 	clusterInitCmd.Flags().BoolVar(&enableSingleNode, "single-node", false, "Enable single-node cluster mode")
+	clusterInitCmd.Flags().StringVar(&gossipAddr, "gossip-addr", "", "Gossip API addresses and health with other nodes on this host:port (e.g. 0.0.0.0:7946)")
+	clusterInitCmd.Flags().StringSliceVar(&gossipSeeds, "gossip-seeds", nil, "Gossip addresses of other nodes to join through")
+	clusterInitCmd.Flags().StringVar(&apiURL, "api-url", "", "URL other nodes and clients reach this node's API at (default derived from the listen and Raft addresses)")
 
 	// Cluster join flags
 	clusterJoinCmd.Flags().Uint64Var(&nodeID, "node-id", 0, "Unique node ID (must be > 0)")
@@ -263,6 +284,9 @@ func init() {
 	clusterJoinCmd.Flags().StringVar(&raftAddr, "raft-addr", "", "Raft communication address for this node")
 	clusterJoinCmd.Flags().StringVar(&dataDir, "data-dir", "ipam-cluster-data", "Directory for cluster data")
 	clusterJoinCmd.Flags().StringVar(&initialMembers, "initial-members", "", "Existing cluster members (e.g., '1:host1:5000,2:host2:5000')")
+	clusterJoinCmd.Flags().StringVar(&gossipAddr, "gossip-addr", "", "Gossip API addresses and health with other nodes on this host:port (e.g. 0.0.0.0:7946)")
+	clusterJoinCmd.Flags().StringSliceVar(&gossipSeeds, "gossip-seeds", nil, "Gossip addresses of other nodes to join through")
+	clusterJoinCmd.Flags().StringVar(&apiURL, "api-url", "", "URL other nodes and clients reach this node's API at (default derived from the listen and Raft addresses)")
 
 	clusterJoinCmd.MarkFlagRequired("node-id")
 	clusterJoinCmd.MarkFlagRequired("raft-addr")
@@ -285,3 +309,17 @@ func parseNodeID(s string) (uint64, error) {
 	}
 	return id, nil
 }
+
+// formatBytes prints n with a KB, MB or GB suffix (powers of 1024), e.g.
+// "1.5MB"
+func formatBytes(n int64) string {
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}} {
+		if n >= u.size {
+			return fmt.Sprintf("%.1f%s", float64(n)/float64(u.size), u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
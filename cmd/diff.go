@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jeremyhahn/go-ipam/pkg/snapshot"
+	"github.com/spf13/cobra"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff OLD [NEW]",
+	Short: "Compare two exports, or an export with the database",
+	Long: `Show networks and allocations added, removed or changed between two JSON
+exports. With --live, the single export is compared against the current
+database. Released allocations are not compared.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		live, _ := cmd.Flags().GetBool("live")
+		if live {
+			return cobra.ExactArgs(1)(cmd, args)
+		}
+		return cobra.ExactArgs(2)(cmd, args)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		live, _ := cmd.Flags().GetBool("live")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		old, err := readSnapshot(cmd, args[0])
+		if err != nil {
+			return withExitCode(ExitValidation, err)
+		}
+
+		var current *snapshot.Snapshot
+		if live {
+			current, err = liveSnapshot()
+		} else {
+			current, err = readSnapshot(cmd, args[1])
+			if err != nil {
+				err = withExitCode(ExitValidation, err)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		diff := snapshot.Compare(old, current)
+
+		out := cmd.OutOrStdout()
+		if asJSON {
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			return enc.Encode(diff)
+		}
+
+		if diff.Empty() {
+			fmt.Fprintln(out, "No differences.")
+			return nil
+		}
+		printDiffSection(out, "Networks", diff.Networks)
+		printDiffSection(out, "Allocations", diff.Allocations)
+		return nil
+	},
+}
+
+// readSnapshot loads an export file, or stdin for "-"
+func readSnapshot(cmd *cobra.Command, path string) (*snapshot.Snapshot, error) {
+	if path == "-" {
+		return snapshot.Read(cmd.InOrStdin())
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open export: %w", err)
+	}
+	defer f.Close()
+
+	snap, err := snapshot.Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return snap, nil
+}
+
+func printDiffSection(out io.Writer, title string, entries []snapshot.Entry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	signs := map[snapshot.Change]string{snapshot.Added: "+", snapshot.Removed: "-", snapshot.Changed: "~"}
+	fmt.Fprintf(out, "%s:\n", title)
+	for _, e := range entries {
+		line := e.Network
+		if e.Address != "" {
+			line = fmt.Sprintf("%s (%s)", e.Address, e.Network)
+			if e.Hostname != "" {
+				line += " " + e.Hostname
+			}
+		}
+		fmt.Fprintf(out, "  %s %s\n", signs[e.Change], line)
+		for _, f := range e.Fields {
+			fmt.Fprintf(out, "      %s: %q -> %q\n", f.Field, f.Old, f.New)
+		}
+	}
+}
+
+func init() {
+	diffCmd.Flags().Bool("live", false, "Compare the export against the current database")
+	diffCmd.Flags().Bool("json", false, "Output as JSON")
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/mdns"
+	"github.com/spf13/cobra"
+)
+
+var (
+	discover        bool          // Find servers announced with mDNS
+	discoverTimeout time.Duration // How long to wait for announced servers
+)
+
+// discoverServers sets cmd's --server flag, unless given, to the servers
+// announced on the local network with mDNS
+func discoverServers(cmd *cobra.Command) error {
+	flag := cmd.Flags().Lookup("server")
+	if flag == nil {
+		return withExitCode(ExitValidation, fmt.Errorf("--discover only applies to commands with --server, e.g. \"ipam cache sync\""))
+	}
+	if flag.Changed {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(cmd.Context(), discoverTimeout)
+	defer cancel()
+	services, err := mdns.Browse(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to discover servers: %w", err)
+	}
+	if len(services) == 0 {
+		return fmt.Errorf("no server announced itself on the local network within %s", discoverTimeout)
+	}
+	urls := make([]string, len(services))
+	for i, s := range services {
+		urls[i] = s.URL()
+		fmt.Fprintf(cmd.ErrOrStderr(), "Discovered %s at %s\n", s.Instance, urls[i])
+	}
+
+	// Only cache sync fails over between several servers
+	server := urls[0]
+	if cmd == cacheSyncCmd {
+		server = strings.Join(urls, ",")
+	}
+	return cmd.Flags().Set("server", server)
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/jeremyhahn/go-ipam/pkg/hooks"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/migrate"
+	"github.com/jeremyhahn/go-ipam/pkg/naming"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/spf13/cobra"
+)
+
+// Process exit codes, so scripts can branch on the failure class without
+// parsing stderr
+const (
+	ExitOK             = 0
+	ExitError          = 1 // Unclassified failure
+	ExitNotFound       = 2 // Network, allocation or address not found
+	ExitNoAvailableIPs = 3 // Network full or requested address taken
+	ExitConflict       = 4 // Operation conflicts with existing state
+	ExitValidation     = 5 // Invalid arguments, flags or input
+)
+
+// exitError attaches an exit code to an error
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// withExitCode returns err tagged with code
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// exitCodes maps sentinel errors to exit codes
+var exitCodes = []struct {
+	err  error
+	code int
+}{
+	{ipam.ErrNetworkNotFound, ExitNotFound},
+	{ipam.ErrIPNotAllocated, ExitNotFound},
+	{ipam.ErrNetworkFull, ExitNoAvailableIPs},
+	{ipam.ErrIPNotAvailable, ExitNoAvailableIPs},
+	{store.ErrAlreadyReleased, ExitConflict},
+	{store.ErrTransferConflict, ExitConflict},
+	{store.ErrTransferSameNetwork, ExitValidation},
+	{store.ErrTransferOutOfRange, ExitValidation},
+	{store.ErrNoFreeSubnet, ExitNoAvailableIPs},
+	{store.ErrGroupNotFound, ExitNotFound},
+	{store.ErrSubnetLength, ExitValidation},
+	{store.ErrNotNibbleAligned, ExitValidation},
+	{store.ErrIPOutOfRange, ExitValidation},
+	{store.ErrNetworkFrozen, ExitConflict},
+	{store.ErrLocked, ExitConflict},
+	{migrate.ErrTargetNotEmpty, ExitConflict},
+	{hooks.ErrVetoed, ExitConflict},
+	{naming.ErrHostnameTaken, ExitConflict},
+	{naming.ErrExhausted, ExitConflict},
+	{naming.ErrNoDomain, ExitValidation},
+}
+
+// ExitCode returns the process exit code for an error returned by Execute
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+
+	var ee *exitError
+	if errors.As(err, &ee) {
+		return ee.code
+	}
+
+	for _, m := range exitCodes {
+		if errors.Is(err, m.err) {
+			return m.code
+		}
+	}
+	return ExitError
+}
+
+// markArgErrors tags positional argument and flag errors of c and its
+// subcommands as validation failures
+func markArgErrors(c *cobra.Command) {
+	c.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return withExitCode(ExitValidation, err)
+	})
+
+	if validate := c.Args; validate != nil {
+		c.Args = func(cmd *cobra.Command, args []string) error {
+			return withExitCode(ExitValidation, validate(cmd, args))
+		}
+	}
+
+	for _, sub := range c.Commands() {
+		markArgErrors(sub)
+	}
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/snapshot"
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export networks and allocations as JSON",
+	Long: `Write every network and allocation, including released ones, to a JSON
+export. Compare exports with "ipam diff".`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, _ := cmd.Flags().GetString("output")
+
+		snap, err := liveSnapshot()
+		if err != nil {
+			return err
+		}
+
+		var out io.Writer = cmd.OutOrStdout()
+		if output != "" {
+			f, err := os.Create(output)
+			if err != nil {
+				return fmt.Errorf("failed to create export: %w", err)
+			}
+			defer f.Close()
+			out = f
+		}
+
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(snap); err != nil {
+			return fmt.Errorf("failed to write export: %w", err)
+		}
+
+		if output != "" {
+			fmt.Fprintf(cmd.OutOrStdout(), "Exported %d networks and %d allocations to %s\n",
+				len(snap.Networks), len(snap.Allocations), output)
+		}
+		return nil
+	},
+}
+
+// liveSnapshot reads the current networks and allocations from the store
+func liveSnapshot() (*snapshot.Snapshot, error) {
+	return snapshot.FromStore(pebbleStore, time.Now())
+}
+
+func init() {
+	exportCmd.Flags().StringP("output", "o", "", "Write the export to a file instead of stdout")
+}
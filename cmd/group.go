@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/hooks"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/spf13/cobra"
+)
+
+var groupCmd = &cobra.Command{
+	Use:   "group",
+	Short: "Manage linked allocation groups",
+	Long: `Allocations created with "ipam allocate --group NAME" form a group that can
+be listed and released as a unit, e.g. a service's management, data and
+virtual IPs.`,
+}
+
+var groupShowCmd = &cobra.Command{
+	Use:   "show [NAME]",
+	Short: "List the active allocations of a group",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		members, err := store.GroupMembers(pebbleStore, args[0], time.Now())
+		if err != nil {
+			return fmt.Errorf("group %s: %w", args[0], err)
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "Group %s (%d allocations):\n", args[0], len(members))
+		fmt.Fprintf(out, "%-20s %-20s %-20s %s\n", "IP", "Hostname", "Description", "ID")
+		fmt.Fprintln(out, strings.Repeat("-", 100))
+		for _, member := range members {
+			ip := member.IP
+			if member.EndIP != "" {
+				ip = member.IP + "-" + member.EndIP
+			}
+			fmt.Fprintf(out, "%-20s %-20s %-20s %s\n",
+				truncate(ip, 20), truncate(member.Hostname, 20), truncate(member.Description, 20), member.ID)
+		}
+		return nil
+	},
+}
+
+var groupReleaseCmd = &cobra.Command{
+	Use:   "release [NAME]",
+	Short: "Release every allocation of a group",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		members, err := store.GroupMembers(pebbleStore, args[0], time.Now())
+		if err != nil {
+			return fmt.Errorf("group %s: %w", args[0], err)
+		}
+
+		for _, member := range members {
+			if err := checkNotFrozen(member.NetworkID, ""); err != nil {
+				return err
+			}
+		}
+
+		chain := hooks.Registered()
+		for _, member := range members {
+			if err := releaseWithHooks(cmd.Context(), chain, member.NetworkID, member.IP); err != nil {
+				return fmt.Errorf("failed to release %s: %w", member.IP, err)
+			}
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Group %s released (%d allocations).\n", args[0], len(members))
+		return nil
+	},
+}
+
+func init() {
+	groupCmd.AddCommand(groupShowCmd)
+	groupCmd.AddCommand(groupReleaseCmd)
+}
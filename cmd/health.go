@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/health"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/spf13/cobra"
+)
+
+var healthCmd = &cobra.Command{
+	Use:   "health",
+	Short: "Probe allocated addresses and find stale allocations",
+	Long: `Probe allocated addresses for reachability and record the result in their
+tags: last-seen=<time> after a successful probe, and stale once an address
+has not answered for --stale-after. Run "ipam health check" from cron, or
+start the server with --health-interval, then list reclamation candidates
+with "ipam health report".`,
+}
+
+var healthCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Probe allocated addresses now",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		prober, err := proberFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+		staleAfter, _ := cmd.Flags().GetDuration("stale-after")
+
+		networks, err := healthNetworks(cmd)
+		if err != nil {
+			return err
+		}
+
+		var up, down, stale int
+		now := time.Now()
+		for _, network := range networks {
+			results, err := health.CheckNetwork(cmd.Context(), pebbleStore, network.ID, prober, now, staleAfter)
+			if err != nil {
+				return fmt.Errorf("failed to check %s: %w", network.CIDR, err)
+			}
+			for _, r := range results {
+				switch {
+				case r.Up:
+					up++
+				case r.Stale():
+					stale++
+					down++
+				default:
+					down++
+				}
+			}
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Probed %d allocations: %d up, %d not answering (%d stale).\n", up+down, up, down, stale)
+		return nil
+	},
+}
+
+var healthReportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "List allocations that have not answered probes for N days",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		days, _ := cmd.Flags().GetInt("days")
+		if days < 1 {
+			return withExitCode(ExitValidation, fmt.Errorf("--days must be at least 1"))
+		}
+
+		networks, err := healthNetworks(cmd)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		out := cmd.OutOrStdout()
+		total := 0
+		for _, network := range networks {
+			allocations, err := pebbleStore.ListAllocations(network.ID)
+			if err != nil {
+				return fmt.Errorf("failed to list allocations: %w", err)
+			}
+			candidates := health.Candidates(allocations, now, time.Duration(days)*24*time.Hour)
+			if len(candidates) == 0 {
+				continue
+			}
+			if total == 0 {
+				fmt.Fprintf(out, "%-20s %-20s %-20s %-20s %s\n", "Network", "IP", "Hostname", "Last Seen", "Silent (days)")
+				fmt.Fprintln(out, strings.Repeat("-", 100))
+			}
+			for _, c := range candidates {
+				lastSeen := "never"
+				if c.LastSeen != nil {
+					lastSeen = c.LastSeen.Local().Format("2006-01-02 15:04")
+				}
+				fmt.Fprintf(out, "%-20s %-20s %-20s %-20s %d\n", network.CIDR, c.Allocation.IP,
+					truncate(c.Allocation.Hostname, 20), lastSeen, int(c.Silent.Hours()/24))
+				total++
+			}
+		}
+
+		if total == 0 {
+			fmt.Fprintf(out, "No allocations silent for %d days or more.\n", days)
+		}
+		return nil
+	},
+}
+
+// healthNetworks returns the network selected by --network-id or --cidr, or
+// every network when neither is given
+func healthNetworks(cmd *cobra.Command) ([]*ipam.Network, error) {
+	networkID, _ := cmd.Flags().GetString("network-id")
+	cidr, _ := cmd.Flags().GetString("cidr")
+
+	var network *ipam.Network
+	var err error
+	switch {
+	case networkID != "":
+		network, err = pebbleStore.GetNetwork(networkID)
+	case cidr != "":
+		network, err = pebbleStore.GetNetworkByCIDR(cidr)
+	default:
+		networks, err := pebbleStore.ListNetworks()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list networks: %w", err)
+		}
+		return networks, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get network: %w", err)
+	}
+	return []*ipam.Network{network}, nil
+}
+
+// proberFromFlags builds the prober selected by --icmp, --ports and --timeout
+func proberFromFlags(cmd *cobra.Command) (health.Prober, error) {
+	icmp, _ := cmd.Flags().GetBool("icmp")
+	portsStr, _ := cmd.Flags().GetString("ports")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+
+	if icmp {
+		return &health.ICMPProber{Timeout: timeout}, nil
+	}
+	ports, err := parsePorts(portsStr)
+	if err != nil {
+		return nil, withExitCode(ExitValidation, err)
+	}
+	return &health.TCPProber{Ports: ports, Timeout: timeout}, nil
+}
+
+// parsePorts parses a comma-separated list of TCP ports
+func parsePorts(s string) ([]int, error) {
+	var ports []int
+	for _, field := range strings.Split(s, ",") {
+		port, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %q", field)
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+func init() {
+	for _, c := range []*cobra.Command{healthCheckCmd, healthReportCmd} {
+		c.Flags().StringP("network-id", "n", "", "Only this network (default: all networks)")
+		c.Flags().StringP("cidr", "c", "", "Only the network with this CIDR")
+	}
+	healthCheckCmd.Flags().String("ports", "22,80,443", "TCP ports to probe; a refused connection also counts as up")
+	healthCheckCmd.Flags().Bool("icmp", false, "Probe with ICMP echo instead of TCP (IPv4 only; needs root or CAP_NET_RAW)")
+	healthCheckCmd.Flags().Duration("timeout", time.Second, "Timeout per probe")
+	healthCheckCmd.Flags().Duration("stale-after", 30*24*time.Hour, "Tag allocations stale after not answering for this long")
+	healthReportCmd.Flags().Int("days", 30, "Report allocations silent for at least this many days")
+
+	healthCmd.AddCommand(healthCheckCmd)
+	healthCmd.AddCommand(healthReportCmd)
+}
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/kea"
+	"github.com/spf13/cobra"
+)
+
+var keaCmd = &cobra.Command{
+	Use:   "kea",
+	Short: "Keep Kea DHCP host reservations in lockstep with allocations",
+	Long: `Push static host reservations from allocations with a mac=<MAC> tag into
+an ISC Kea DHCP server. Run "ipam kea sync" once, or start the server with
+--kea-url to keep them in sync. "ipam kea export" prints the subnet
+configuration of the networks.`,
+}
+
+var keaSyncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Sync Kea host reservations now",
+	Long: `Reserve the address of every active single-address allocation with a
+mac=<MAC> tag, in a network whose CIDR is a Kea subnet, for that MAC,
+with the allocation's hostname.
+
+Reservations made by the sync carry a user context naming their
+allocation. They are replaced when the allocation's MAC or hostname
+changes, and deleted when it is released or loses its MAC. Reservations
+made by other means are never changed: an allocation whose address or
+MAC one of them holds is reported as a conflict.
+
+Kea is driven through its Control Agent and needs the host_cmds hook
+loaded, with a hosts database to keep the reservations across restarts.`,
+	Example: `  ipam kea sync --url http://kea:8000 --dry-run
+  ipam kea sync --url https://kea:8000 --credentials-file /etc/ipam/kea-credentials --service dhcp4,dhcp6`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		syncer, err := keaSyncerFromFlags(cmd, "")
+		if err != nil {
+			return err
+		}
+		if syncer == nil {
+			return withExitCode(ExitValidation, errors.New("--url is required"))
+		}
+		syncer.Store = pebbleStore
+		syncer.DryRun = dryRun
+
+		ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+		defer cancel()
+		result, err := syncer.Sync(ctx, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to sync Kea reservations: %w", err)
+		}
+
+		out := cmd.OutOrStdout()
+		if asJSON {
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			return enc.Encode(result)
+		}
+		if dryRun {
+			fmt.Fprintln(out, "Dry run: no changes made.")
+		}
+		fmt.Fprintf(out, "Subnets:    %d\n", result.Subnets)
+		fmt.Fprintf(out, "Added:      %d\n", result.Added)
+		fmt.Fprintf(out, "Updated:    %d\n", result.Updated)
+		fmt.Fprintf(out, "Deleted:    %d\n", result.Deleted)
+		fmt.Fprintf(out, "Unchanged:  %d\n", result.Unchanged)
+
+		fmt.Fprintf(out, "\nConflicts (%d):\n", len(result.Conflicts))
+		for _, c := range result.Conflicts {
+			fmt.Fprintf(out, "  %-40s %-17s %s\n", c.IP, c.MAC, c.Reason)
+		}
+		fmt.Fprintf(out, "\nKea subnets without a network (%d):\n", len(result.Unmatched))
+		for _, subnet := range result.Unmatched {
+			fmt.Fprintf(out, "  %s\n", subnet)
+		}
+		return nil
+	},
+}
+
+var keaExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Print the Kea subnet configuration of the networks",
+	Long: `Print the subnet4 (or subnet6) list of a Kea configuration for the
+networks of that family, to paste into the DHCP server's configuration.
+
+The addresses of each network's pools named with --pool (the pool=dhcp:
+<first>-<last> tags) become the subnet's dynamic pools. The network's
+gateway, name servers and domain become the routers, name server and
+domain options, and its DHCP options (boot-file=, next-server= and
+dhcp-option= tags) are exported as they are.`,
+	Example: `  ipam kea export
+  ipam kea export --service dhcp6 --pool dhcp,guests`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		service, _ := cmd.Flags().GetString("service")
+		pools, _ := cmd.Flags().GetStringSlice("pool")
+		if !slices.Contains(kea.Services, service) {
+			return withExitCode(ExitValidation, fmt.Errorf("invalid --service %q: must be %s", service, strings.Join(kea.Services, " or ")))
+		}
+
+		subnets, err := kea.Export(pebbleStore, service, pools, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to export Kea subnets: %w", err)
+		}
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string]any{"subnet" + strings.TrimPrefix(service, "dhcp"): subnets})
+	},
+}
+
+// keaSyncerFromFlags returns a Kea syncer for the flags named with prefix,
+// e.g. "kea-" for the server's, or nil when no URL is given
+func keaSyncerFromFlags(cmd *cobra.Command, prefix string) (*kea.Syncer, error) {
+	url, _ := cmd.Flags().GetString(prefix + "url")
+	credentialsFile, _ := cmd.Flags().GetString(prefix + "credentials-file")
+	services, _ := cmd.Flags().GetStringSlice(prefix + "service")
+	if url == "" {
+		if credentialsFile != "" {
+			return nil, withExitCode(ExitValidation, fmt.Errorf("--%scredentials-file requires --%surl", prefix, prefix))
+		}
+		return nil, nil
+	}
+	for _, service := range services {
+		if !slices.Contains(kea.Services, service) {
+			return nil, withExitCode(ExitValidation, fmt.Errorf("invalid --%sservice %q: must be %s", prefix, service, strings.Join(kea.Services, " or ")))
+		}
+	}
+
+	client := kea.NewClient(url)
+	if credentialsFile != "" {
+		lines, err := readTokens(credentialsFile)
+		if err != nil {
+			return nil, fmt.Errorf("kea credentials: %w", err)
+		}
+		var ok bool
+		if client.Username, client.Password, ok = strings.Cut(lines[0], ":"); !ok {
+			return nil, withExitCode(ExitValidation, fmt.Errorf("kea credentials file %s must hold user:password", credentialsFile))
+		}
+	}
+	return &kea.Syncer{Client: client, Services: services}, nil
+}
+
+func init() {
+	keaSyncCmd.Flags().String("url", "", "Kea Control Agent URL, e.g. http://kea:8000")
+	keaSyncCmd.Flags().String("credentials-file", "", "File holding the Control Agent's user:password")
+	keaSyncCmd.Flags().StringSlice("service", []string{"dhcp4"}, "Kea services to sync: dhcp4, dhcp6")
+	keaSyncCmd.Flags().Bool("dry-run", false, "Report the changes without making them")
+	keaSyncCmd.Flags().Duration("timeout", 2*time.Minute, "Time allowed for the sync")
+	keaSyncCmd.Flags().Bool("json", false, "Print the result as JSON")
+	keaCmd.AddCommand(keaSyncCmd)
+
+	keaExportCmd.Flags().String("service", "dhcp4", "Kea service to export the subnets of: dhcp4, dhcp6")
+	keaExportCmd.Flags().StringSlice("pool", []string{kea.DefaultExportPool}, "Pools to export as the subnets' dynamic pools")
+	keaCmd.AddCommand(keaExportCmd)
+}
@@ -6,107 +6,153 @@ import (
 	"time"
 
 	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
 	"github.com/spf13/cobra"
 )
 
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List allocations",
-	Long:  `List all IP allocations, optionally filtered by network.`,
+	Long:  `List all IP allocations, optionally filtered by network, status or tag and sorted.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		networkID, _ := cmd.Flags().GetString("network-id")
 		showAll, _ := cmd.Flags().GetBool("all")
+		format, _ := cmd.Flags().GetString("format")
+		columns, _ := cmd.Flags().GetString("columns")
+		sortField, _ := cmd.Flags().GetString("sort")
+		desc, _ := cmd.Flags().GetBool("desc")
+		filterStr, _ := cmd.Flags().GetString("filter")
+
+		tbl, err := newTable(format, columns, listColumns, listDefaultColumns)
+		if err != nil {
+			return err
+		}
 
-		var allAllocations []*struct {
-			allocation *ipam.IPAllocation
-			network    *ipam.Network
+		filter, err := store.ParseAllocationFilter(filterStr)
+		if err != nil {
+			return withExitCode(ExitValidation, err)
+		}
+		if sortField != "" && !store.ValidAllocationSortField(sortField) {
+			return withExitCode(ExitValidation, fmt.Errorf("invalid sort field %q: must be one of %s", sortField, strings.Join(store.AllocationSortFields, ", ")))
+		}
+		// A status filter decides on its own whether released entries show
+		if filter.Status != "" {
+			showAll = true
 		}
 
+		var networks []*ipam.Network
 		if networkID != "" {
 			network, err := pebbleStore.GetNetwork(networkID)
 			if err != nil {
 				return fmt.Errorf("failed to get network: %w", err)
 			}
-
-			allocations, err := pebbleStore.ListAllocations(networkID)
-			if err != nil {
-				return fmt.Errorf("failed to list allocations: %w", err)
-			}
-
-			for _, alloc := range allocations {
-				if !showAll && alloc.ReleasedAt != nil {
-					continue
-				}
-				allAllocations = append(allAllocations, &struct {
-					allocation *ipam.IPAllocation
-					network    *ipam.Network
-				}{alloc, network})
-			}
+			networks = append(networks, network)
 		} else {
 			// List all allocations from all networks
-			networks, err := pebbleStore.ListNetworks()
+			networks, err = pebbleStore.ListNetworks()
 			if err != nil {
 				return fmt.Errorf("failed to list networks: %w", err)
 			}
+		}
 
-			for _, network := range networks {
-				allocations, err := pebbleStore.ListAllocations(network.ID)
-				if err != nil {
-					continue
+		networksByID := make(map[string]*ipam.Network, len(networks))
+		var allAllocations []*ipam.IPAllocation
+		for _, network := range networks {
+			networksByID[network.ID] = network
+
+			allocations, err := pebbleStore.ListAllocations(network.ID)
+			if err != nil {
+				if networkID != "" {
+					return fmt.Errorf("failed to list allocations: %w", err)
 				}
+				continue
+			}
 
-				for _, alloc := range allocations {
-					if !showAll && alloc.ReleasedAt != nil {
-						continue
-					}
-					allAllocations = append(allAllocations, &struct {
-						allocation *ipam.IPAllocation
-						network    *ipam.Network
-					}{alloc, network})
+			for _, alloc := range allocations {
+				if !showAll && alloc.ReleasedAt != nil {
+					continue
 				}
+				allAllocations = append(allAllocations, alloc)
 			}
 		}
 
-		if len(allAllocations) == 0 {
+		now := time.Now()
+		allAllocations = store.FilterAllocations(allAllocations, filter, now)
+		if sortField != "" {
+			store.SortAllocations(allAllocations, sortField, desc)
+		}
+
+		if len(allAllocations) == 0 && tbl.isTable() {
 			fmt.Fprintln(cmd.OutOrStdout(), "No allocations found.")
 			return nil
 		}
 
-		fmt.Fprintf(cmd.OutOrStdout(), "%-20s %-20s %-10s %-20s %-20s %s\n",
-			"IP", "Network", "Status", "Hostname", "Description", "Allocated")
-		fmt.Fprintln(cmd.OutOrStdout(), strings.Repeat("-", 110))
-
-		for _, item := range allAllocations {
-			alloc := item.allocation
-			network := item.network
-
-			ipStr := alloc.IP
-			if alloc.EndIP != "" {
-				ipStr = fmt.Sprintf("%s-%s", alloc.IP, alloc.EndIP)
-			}
-
-			status := alloc.Status
-			if alloc.ReleasedAt != nil {
-				status = "released"
-			} else if alloc.ExpiresAt != nil && alloc.ExpiresAt.Before(time.Now()) {
-				status = "expired"
-			}
-
-			fmt.Fprintf(cmd.OutOrStdout(), "%-20s %-20s %-10s %-20s %-20s %s\n",
-				truncate(ipStr, 20),
-				network.CIDR,
-				status,
-				truncate(alloc.Hostname, 20),
-				truncate(alloc.Description, 20),
-				alloc.AllocatedAt.Format("2006-01-02 15:04"),
-			)
+		rows := make([]map[string]string, 0, len(allAllocations))
+		for _, alloc := range allAllocations {
+			rows = append(rows, allocationRow(alloc, networksByID[alloc.NetworkID], now))
 		}
 
-		return nil
+		return tbl.render(cmd.OutOrStdout(), rows)
 	},
 }
 
+// listColumns are the columns selectable with list --columns
+var listColumns = []outputColumn{
+	{Name: "ip", Header: "IP", Width: 20, Truncate: true},
+	{Name: "network", Header: "Network", Width: 20},
+	{Name: "status", Header: "Status", Width: 10},
+	{Name: "hostname", Header: "Hostname", Width: 20, Truncate: true},
+	{Name: "description", Header: "Description", Width: 20, Truncate: true},
+	{Name: "allocated", Header: "Allocated", Width: 16},
+	{Name: "expires", Header: "Expires", Width: 16},
+	{Name: "released", Header: "Released", Width: 16},
+	{Name: "tags", Header: "Tags", Width: 20, Truncate: true},
+	{Name: "id", Header: "ID", Width: 36},
+	{Name: "network_id", Header: "Network ID", Width: 36},
+}
+
+var listDefaultColumns = []string{"ip", "network", "status", "hostname", "description", "allocated"}
+
+// allocationRow returns the list column values of an allocation
+func allocationRow(alloc *ipam.IPAllocation, network *ipam.Network, now time.Time) map[string]string {
+	ipStr := alloc.IP
+	if alloc.EndIP != "" {
+		ipStr = fmt.Sprintf("%s-%s", alloc.IP, alloc.EndIP)
+	}
+
+	status := alloc.Status
+	if alloc.ReleasedAt != nil {
+		status = "released"
+	} else if alloc.ExpiresAt != nil && alloc.ExpiresAt.Before(now) {
+		status = "expired"
+	}
+
+	row := map[string]string{
+		"ip":          ipStr,
+		"network":     network.CIDR,
+		"status":      status,
+		"hostname":    alloc.Hostname,
+		"description": alloc.Description,
+		"allocated":   alloc.AllocatedAt.Format("2006-01-02 15:04"),
+		"tags":        strings.Join(alloc.Tags, ","),
+		"id":          alloc.ID,
+		"network_id":  alloc.NetworkID,
+	}
+	if alloc.ExpiresAt != nil {
+		row["expires"] = alloc.ExpiresAt.Format("2006-01-02 15:04")
+	}
+	if alloc.ReleasedAt != nil {
+		row["released"] = alloc.ReleasedAt.Format("2006-01-02 15:04")
+	}
+	return row
+}
+
 func init() {
 	listCmd.Flags().StringP("network-id", "n", "", "Filter by network ID")
 	listCmd.Flags().BoolP("all", "a", false, "Show released allocations")
+	listCmd.Flags().String("format", formatTable, "Output format: table, csv or tsv")
+	listCmd.Flags().String("columns", "", "Comma-separated columns (ip, network, status, hostname, description, allocated, expires, released, tags, id, network_id)")
+	listCmd.Flags().String("sort", "", "Sort by ip, hostname, allocated_at or expires_at")
+	listCmd.Flags().Bool("desc", false, "Sort in descending order")
+	listCmd.Flags().String("filter", "", "Filter terms, e.g. status=active,tag=prod,country=DE")
 }
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/loadtest"
+	"github.com/spf13/cobra"
+)
+
+var loadtestCmd = &cobra.Command{
+	Use:   "loadtest",
+	Short: "Generate allocate/release traffic against a server and report latency",
+	Long: `Generate realistic allocate and release traffic against a running server and
+report latency percentiles and error rates per operation.
+
+The test creates --networks /24 networks carved from --cidr, tagged
+loadtest, and keeps each about half full. They are removed afterwards
+unless --keep is given. Point it at a staging server: the traffic is real.`,
+	Example: `  ipam loadtest --server http://localhost:8080 --networks 10 --rate 500/s --duration 2m`,
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg := loadtest.Config{}
+		cfg.Server, _ = cmd.Flags().GetString("server")
+		cfg.Token, _ = cmd.Flags().GetString("token")
+		cfg.Networks, _ = cmd.Flags().GetInt("networks")
+		cfg.CIDR, _ = cmd.Flags().GetString("cidr")
+		cfg.Duration, _ = cmd.Flags().GetDuration("duration")
+		cfg.Concurrency, _ = cmd.Flags().GetInt("concurrency")
+		cfg.Keep, _ = cmd.Flags().GetBool("keep")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		rate, _ := cmd.Flags().GetString("rate")
+		var err error
+		if cfg.Rate, err = loadtest.ParseRate(rate); err != nil {
+			return withExitCode(ExitValidation, err)
+		}
+		if cfg.Token == "" {
+			cfg.Token = os.Getenv("IPAM_TOKEN")
+		}
+		if err := cfg.Validate(); err != nil {
+			return withExitCode(ExitValidation, err)
+		}
+
+		// Ctrl-C ends the traffic early but still cleans up
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+		defer stop()
+
+		out := cmd.OutOrStdout()
+		if !asJSON {
+			fmt.Fprintf(out, "Running %s of %.0f requests/s against %s across %d networks...\n",
+				cfg.Duration, cfg.Rate, cfg.Server, cfg.Networks)
+		}
+
+		report, err := loadtest.Run(ctx, cfg)
+		if report != nil {
+			if asJSON {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				enc.Encode(report)
+			} else {
+				printLoadtestReport(out, report, cfg.Concurrency)
+			}
+		}
+		return err
+	},
+}
+
+// printLoadtestReport prints a report as a table
+func printLoadtestReport(out io.Writer, report *loadtest.Report, concurrency int) {
+	ms := func(d time.Duration) string { return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond)) }
+
+	fmt.Fprintf(out, "\n%-10s %10s %10s %10s %10s %10s %10s\n", "Operation", "Requests", "Errors", "p50", "p90", "p99", "Max")
+	for _, s := range report.Operations {
+		fmt.Fprintf(out, "%-10s %10d %9.2f%% %10s %10s %10s %10s\n", s.Op, s.Requests, s.ErrorRate()*100,
+			ms(s.P50), ms(s.P90), ms(s.P99), ms(s.Max))
+	}
+	fmt.Fprintf(out, "\nThroughput: %.1f requests/s over %s\n", report.Throughput(), report.Duration.Round(time.Millisecond))
+	for _, s := range report.Operations {
+		if s.Errors > 0 {
+			fmt.Fprintf(out, "%s responses: %v\n", s.Op, s.Statuses)
+		}
+	}
+	if report.Dropped > 0 {
+		fmt.Fprintf(out, "Dropped %d requests with %d in flight; the server is not keeping up with the rate.\n",
+			report.Dropped, concurrency)
+	}
+}
+
+func init() {
+	loadtestCmd.Flags().String("server", "", "API base URL, e.g. http://localhost:8080")
+	loadtestCmd.Flags().String("token", "", "API token, if the server requires one (default $IPAM_TOKEN)")
+	loadtestCmd.Flags().Int("networks", 10, "Number of /24 test networks")
+	loadtestCmd.Flags().String("cidr", "10.240.0.0/16", "Prefix the test networks are carved from; must not overlap existing networks")
+	loadtestCmd.Flags().String("rate", "100/s", "Requests started per second, minute or hour, e.g. 500/s")
+	loadtestCmd.Flags().Duration("duration", time.Minute, "How long to generate traffic")
+	loadtestCmd.Flags().Int("concurrency", 64, "Maximum requests in flight")
+	loadtestCmd.Flags().Bool("keep", false, "Leave the test networks and allocations in place")
+	loadtestCmd.Flags().Bool("json", false, "Print the report as JSON")
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/spf13/cobra"
+)
+
+var locateCmd = &cobra.Command{
+	Use:   "locate [IP]",
+	Short: "Find the network and allocation of an IP address",
+	Long: `Show the most specific network containing an IP address, every enclosing
+network, and the allocation covering the address if there is one.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		loc, err := store.Locate(pebbleStore, args[0], time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to locate %s: %w", args[0], err)
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "IP:          %s\n", loc.IP)
+		fmt.Fprintf(out, "Network:     %s (%s)\n", loc.Network.CIDR, loc.Network.ID)
+		for _, parent := range loc.Networks[1:] {
+			fmt.Fprintf(out, "Within:      %s (%s)\n", parent.CIDR, parent.ID)
+		}
+		fmt.Fprintf(out, "Status:      %s\n", loc.Status)
+
+		if alloc := loc.Allocation; alloc != nil {
+			fmt.Fprintf(out, "Allocation:  %s\n", alloc.ID)
+			if alloc.NetworkID != loc.Network.ID {
+				fmt.Fprintf(out, "Held by:     %s\n", alloc.NetworkID)
+			}
+			if alloc.Hostname != "" {
+				fmt.Fprintf(out, "Hostname:    %s\n", alloc.Hostname)
+			}
+			if alloc.Description != "" {
+				fmt.Fprintf(out, "Description: %s\n", alloc.Description)
+			}
+			fmt.Fprintf(out, "Allocated:   %s\n", alloc.AllocatedAt.Format("2006-01-02 15:04:05"))
+		}
+
+		return nil
+	},
+}
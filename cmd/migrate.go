@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/migrate"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/spf13/cobra"
+)
+
+// Store backends ipam migrate moves data between
+const (
+	backendPebble  = "pebble"
+	backendCluster = "cluster"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Move all data between the standalone database and a cluster",
+	Long: `Copy every network, allocation (released ones included) and audit entry
+from one store backend to the other, keeping their IDs, and verify the copy.
+
+The standalone database is --db; the cluster node is the one described by
+the --config cluster configuration, e.g. from "ipam cluster init". Stop any
+server using either first. The target must be empty, and the source is left
+untouched, so a failed migration can be retried into a fresh target.`,
+	Example: `  # Graduate a standalone deployment to a cluster
+  ipam cluster init --node-id 1 --cluster-id 100 --raft-addr 10.0.0.1:5000 --initial-members 1:10.0.0.1:5000,2:10.0.0.2:5000,3:10.0.0.3:5000 --data-dir /var/lib/ipam-cluster
+  ipam --db /var/lib/ipam migrate --from pebble --to cluster --config /var/lib/ipam-cluster/cluster.json
+
+  # And back
+  ipam --db /var/lib/ipam-standalone migrate --from cluster --to pebble --config /var/lib/ipam-cluster/cluster.json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		from, _ := cmd.Flags().GetString("from")
+		to, _ := cmd.Flags().GetString("to")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		wait, _ := cmd.Flags().GetDuration("wait")
+
+		for _, backend := range []string{from, to} {
+			if backend != backendPebble && backend != backendCluster {
+				return withExitCode(ExitValidation, fmt.Errorf("--from and --to must be %s or %s, not %q", backendPebble, backendCluster, backend))
+			}
+		}
+		if from == to {
+			return withExitCode(ExitValidation, fmt.Errorf("--from and --to must differ"))
+		}
+
+		// Opening a missing database would create an empty one
+		if from == backendPebble {
+			if _, err := os.Stat(dbPath); err != nil {
+				return fmt.Errorf("no database at %s; set --db", dbPath)
+			}
+		}
+
+		src, srcName, err := openBackend(from, wait)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		dst, dstName, err := openBackend(to, wait)
+		if err != nil {
+			return err
+		}
+		defer dst.Close()
+
+		out := cmd.OutOrStdout()
+		if dryRun {
+			plan, err := migrate.Plan(dst, src)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Would copy %d networks, %d allocations and %d audit entries from %s to %s\n",
+				plan.Networks, plan.Allocations, plan.AuditEntries, srcName, dstName)
+			return nil
+		}
+
+		fmt.Fprintf(out, "Migrating from %s to %s...\n", srcName, dstName)
+		result, err := migrate.Copy(dst, src)
+		if err != nil {
+			return fmt.Errorf("migration failed; %s is unchanged, discard %s before retrying: %w", srcName, dstName, err)
+		}
+		fmt.Fprintf(out, "Copied and verified %d networks, %d allocations and %d audit entries\n",
+			result.Networks, result.Allocations, result.AuditEntries)
+
+		if to == backendCluster {
+			fmt.Fprintf(out, "\nStart the cluster with:\n  ipam server --cluster --config %s\n", clusterConfigPath())
+		} else {
+			fmt.Fprintf(out, "\nStart the standalone server with:\n  ipam --db %s server\n", dbPath)
+		}
+		return nil
+	},
+}
+
+// closableStore is a store ipam migrate opened itself
+type closableStore interface {
+	ipam.Store
+	Close() error
+}
+
+// openBackend opens the standalone database or this node of the cluster,
+// waiting up to wait for the cluster to elect a leader, and describes it
+func openBackend(backend string, wait time.Duration) (closableStore, string, error) {
+	if backend == backendPebble {
+		s, err := openPebbleStore(dbPath)
+		if err != nil {
+			return nil, "", err
+		}
+		return s, fmt.Sprintf("the standalone database %s", dbPath), nil
+	}
+
+	c, err := loadClusterConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, "", withExitCode(ExitValidation, fmt.Errorf("invalid cluster configuration: %w", err))
+	}
+	s, err := store.NewRaftStore(c.NodeID, c.ClusterID, c.RaftAddr, c.Join, c.InitialMembers, c.DataDir)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to start cluster node: %w (is a server using %s?)", err, c.DataDir)
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		err := s.Ping()
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			s.Close()
+			return nil, "", fmt.Errorf("%w after %s; start the other members' servers so the cluster has a quorum", err, wait)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return s, fmt.Sprintf("cluster %d (node %d)", c.ClusterID, c.NodeID), nil
+}
+
+func init() {
+	migrateCmd.Flags().String("from", "", "Backend to copy from: pebble or cluster")
+	migrateCmd.Flags().String("to", "", "Backend to copy to: pebble or cluster")
+	migrateCmd.Flags().StringVar(&configFile, "config", "", "Cluster configuration file (default ipam-cluster-data/cluster.json)")
+	migrateCmd.Flags().Bool("dry-run", false, "Count what would be copied without copying")
+	migrateCmd.Flags().Duration("wait", 30*time.Second, "How long to wait for the cluster to elect a leader")
+}
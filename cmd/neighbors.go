@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/neighbor"
+	"github.com/spf13/cobra"
+)
+
+var neighborsCmd = &cobra.Command{
+	Use:   "neighbors [FILE]",
+	Short: "Record MAC addresses from an ARP or NDP table",
+	Long: `Record the MAC addresses of an ARP or NDP table, e.g. collected from a
+router over SNMP, SSH or gNMI, on the allocations holding its addresses.
+
+Each allocation found gets observed-mac=<MAC> and last-seen=<time> tags.
+An allocation whose recorded mac=<MAC> tag differs from the observed MAC,
+or that the table lists with several MACs, is tagged mac-conflict until
+the recorded MAC is observed again.
+
+FILE may be arp -an, ip neigh, Cisco show ip arp or show ipv6 neighbors,
+Junos show arp or Windows arp -a output, or a JSON array of {"ip", "mac"}
+objects (--format json). Use "-" to read from stdin.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+
+		var r io.Reader = cmd.InOrStdin()
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to open neighbor table: %w", err)
+			}
+			defer f.Close()
+			r = f
+		}
+
+		entries, err := neighbor.Parse(r, format)
+		if err != nil {
+			return withExitCode(ExitValidation, fmt.Errorf("failed to parse neighbor table: %w", err))
+		}
+
+		in := &neighbor.Ingester{Store: pebbleStore}
+		result, err := in.Ingest(entries, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to record neighbor table: %w", err)
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "Entries:    %d\n", result.Entries)
+		fmt.Fprintf(out, "Matched:    %d\n", result.Matched)
+		fmt.Fprintf(out, "Updated:    %d\n", result.Updated)
+
+		fmt.Fprintf(out, "\nMAC conflicts (%d):\n", len(result.Conflicts))
+		for _, c := range result.Conflicts {
+			recorded := c.Recorded
+			if recorded == "" {
+				recorded = "-"
+			}
+			fmt.Fprintf(out, "  %-40s %-20s recorded %s, observed %s\n", c.IP, c.AllocationID, recorded, strings.Join(c.Observed, ", "))
+		}
+
+		fmt.Fprintf(out, "\nIn use but not allocated (%d):\n", len(result.Unknown))
+		for _, e := range result.Unknown {
+			fmt.Fprintf(out, "  %-40s %s\n", e.IP, e.MAC)
+		}
+		return nil
+	},
+}
+
+func init() {
+	neighborsCmd.Flags().StringP("format", "f", "text", "Table format: text or json")
+}
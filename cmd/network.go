@@ -3,7 +3,10 @@ package cmd
 import (
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
 	"github.com/spf13/cobra"
 )
 
@@ -22,11 +25,22 @@ var networkAddCmd = &cobra.Command{
 		description, _ := cmd.Flags().GetString("description")
 		tagsStr, _ := cmd.Flags().GetString("tags")
 
+		reuseDelay, _ := cmd.Flags().GetDuration("reuse-delay")
+
 		var tags []string
 		if tagsStr != "" {
 			tags = strings.Split(tagsStr, ",")
 		}
+		if reuseDelay < 0 {
+			return withExitCode(ExitValidation, fmt.Errorf("--reuse-delay must not be negative"))
+		}
+		if reuseDelay > 0 {
+			tags = append(tags, store.ReuseDelayTag(reuseDelay))
+		}
 
+		if err := store.CheckPointToPoint(cidr, tags); err != nil {
+			return withExitCode(ExitValidation, err)
+		}
 		network, err := ipamClient.AddNetwork(cidr, description, tags)
 		if err != nil {
 			return fmt.Errorf("failed to add network: %w", err)
@@ -60,6 +74,7 @@ var networkListCmd = &cobra.Command{
 		fmt.Fprintf(cmd.OutOrStdout(), "%-12s %-20s %-30s %s\n", "ID", "CIDR", "Description", "Tags")
 		fmt.Fprintln(cmd.OutOrStdout(), strings.Repeat("-", 80))
 
+		now := time.Now()
 		for _, network := range networks {
 			tagsStr := strings.Join(network.Tags, ", ")
 			fmt.Fprintf(cmd.OutOrStdout(), "%-12s %-20s %-30s %s\n",
@@ -68,6 +83,263 @@ var networkListCmd = &cobra.Command{
 				truncate(network.Description, 30),
 				tagsStr,
 			)
+			if f := store.NetworkFreeze(network, now); f != nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "%-12s FROZEN: %s\n", "", f)
+			}
+		}
+		return nil
+	},
+}
+
+var networkShowCmd = &cobra.Command{
+	Use:   "show [ID|CIDR]",
+	Short: "Show network details",
+	Long:  `Show a network's details, utilization and most recent allocations.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		recent, _ := cmd.Flags().GetInt("recent")
+
+		network, err := ipamStore.GetNetwork(args[0])
+		if err != nil {
+			var byCIDR error
+			if network, byCIDR = ipamStore.GetNetworkByCIDR(args[0]); byCIDR != nil {
+				return fmt.Errorf("failed to get network: %w", err)
+			}
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "ID:          %s\n", network.ID)
+		fmt.Fprintf(out, "CIDR:        %s\n", network.CIDR)
+		fmt.Fprintf(out, "Description: %s\n", network.Description)
+		fmt.Fprintf(out, "Tags:        %s\n", strings.Join(network.Tags, ", "))
+		fmt.Fprintf(out, "Created:     %s\n", network.CreatedAt.Format("2006-01-02 15:04:05"))
+		if !network.UpdatedAt.IsZero() {
+			fmt.Fprintf(out, "Updated:     %s\n", network.UpdatedAt.Format("2006-01-02 15:04:05"))
+		}
+		if f := store.NetworkFreeze(network, time.Now()); f != nil {
+			fmt.Fprintf(out, "Frozen:      %s\n", f)
+		}
+		if acl := store.NetworkACL(network); !acl.Empty() {
+			fmt.Fprintf(out, "ACL:         %s\n", strings.Join(acl.Tags(), ", "))
+		}
+
+		if stats, err := ipamClient.GetNetworkStats(network.ID); err == nil {
+			fmt.Fprintf(out, "\nUtilization: %.1f%% (%d allocated, %d available, %d reserved of %d)\n",
+				stats.UtilizationPercent, stats.AllocatedIPs, stats.AvailableIPs, stats.ReservedIPs, stats.TotalIPs)
+		} else {
+			fmt.Fprintf(out, "\nUtilization: unavailable (%v)\n", err)
+		}
+
+		allocations, err := ipamStore.ListAllocations(network.ID)
+		if err != nil {
+			return fmt.Errorf("failed to list allocations: %w", err)
+		}
+		store.SortAllocations(allocations, "allocated_at", true)
+		if len(allocations) > recent {
+			allocations = allocations[:recent]
+		}
+
+		fmt.Fprintf(out, "\nRecent allocations:\n")
+		if len(allocations) == 0 {
+			fmt.Fprintln(out, "  none")
+			return nil
+		}
+
+		now := time.Now()
+		for _, alloc := range allocations {
+			row := allocationRow(alloc, network, now)
+			fmt.Fprintf(out, "  %-32s %-10s %-20s %s\n",
+				row["ip"], row["status"], truncate(alloc.Hostname, 20), row["allocated"])
+		}
+		return nil
+	},
+}
+
+var networkCarveCmd = &cobra.Command{
+	Use:   "carve [ID|CIDR]",
+	Short: "Create a subnet from the next free sub-prefix of a network",
+	Long: `Create a child network from the lowest sub-prefix of the given length that
+does not overlap existing networks or active allocations in the parent.
+
+IPv6 lengths must be nibble aligned (/52, /56, /60, /64, ...) so that reverse
+DNS can be delegated on the subnet boundary.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		bits, _ := cmd.Flags().GetInt("prefix-len")
+		description, _ := cmd.Flags().GetString("description")
+		tagsStr, _ := cmd.Flags().GetString("tags")
+
+		var tags []string
+		if tagsStr != "" {
+			tags = strings.Split(tagsStr, ",")
+		}
+
+		parent, err := ipamStore.GetNetwork(args[0])
+		if err != nil {
+			var byCIDR error
+			if parent, byCIDR = ipamStore.GetNetworkByCIDR(args[0]); byCIDR != nil {
+				return fmt.Errorf("failed to get network: %w", err)
+			}
+		}
+
+		networks, err := ipamStore.ListNetworks()
+		if err != nil {
+			return fmt.Errorf("failed to list networks: %w", err)
+		}
+		allocations, err := ipamStore.ListAllocations(parent.ID)
+		if err != nil {
+			return fmt.Errorf("failed to list allocations: %w", err)
+		}
+
+		subnet, err := store.NextSubnet(parent, bits, networks, allocations, time.Now())
+		if err != nil {
+			return fmt.Errorf("cannot carve /%d from %s: %w", bits, parent.CIDR, err)
+		}
+
+		if err := store.CheckPointToPoint(subnet.String(), tags); err != nil {
+			return withExitCode(ExitValidation, err)
+		}
+		network, err := ipamClient.AddNetwork(subnet.String(), description, tags)
+		if err != nil {
+			return fmt.Errorf("failed to add network: %w", err)
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Subnet carved from %s:\n", parent.CIDR)
+		fmt.Fprintf(cmd.OutOrStdout(), "  ID:          %s\n", network.ID)
+		fmt.Fprintf(cmd.OutOrStdout(), "  CIDR:        %s\n", network.CIDR)
+		if network.Description != "" {
+			fmt.Fprintf(cmd.OutOrStdout(), "  Description: %s\n", network.Description)
+		}
+		return nil
+	},
+}
+
+var networkFreezeCmd = &cobra.Command{
+	Use:   "freeze [ID|CIDR]",
+	Short: "Block allocations and releases in a network",
+	Long: `Put a network under a maintenance freeze: allocating, releasing and
+transferring addresses in it fails until the freeze is lifted with
+"ipam network unfreeze" or its --until time passes.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		reason, _ := cmd.Flags().GetString("reason")
+		untilStr, _ := cmd.Flags().GetString("until")
+
+		if reason == "" {
+			return withExitCode(ExitValidation, fmt.Errorf("--reason must be specified"))
+		}
+
+		now := time.Now()
+		var until *time.Time
+		if untilStr != "" {
+			t, err := parseUntil(untilStr, now)
+			if err != nil {
+				return withExitCode(ExitValidation, err)
+			}
+			until = &t
+		}
+
+		network, err := findNetwork(args[0])
+		if err != nil {
+			return err
+		}
+
+		network.Tags = store.FreezeTags(network.Tags, reason, until)
+		network.UpdatedAt = now
+		if err := ipamStore.SaveNetwork(network); err != nil {
+			return fmt.Errorf("failed to freeze network: %w", err)
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Network %s frozen: %s\n", network.CIDR, store.NetworkFreeze(network, now))
+		return nil
+	},
+}
+
+var networkUnfreezeCmd = &cobra.Command{
+	Use:   "unfreeze [ID|CIDR]",
+	Short: "Lift a network's maintenance freeze",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		network, err := findNetwork(args[0])
+		if err != nil {
+			return err
+		}
+
+		network.Tags = store.UnfreezeTags(network.Tags)
+		network.UpdatedAt = time.Now()
+		if err := ipamStore.SaveNetwork(network); err != nil {
+			return fmt.Errorf("failed to unfreeze network: %w", err)
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Network %s unfrozen.\n", network.CIDR)
+		return nil
+	},
+}
+
+var networkACLCmd = &cobra.Command{
+	Use:   "acl [ID|CIDR]",
+	Short: "Show or set which API tokens may use a network",
+	Long: `Show a network's ACL, or replace it when any of --view, --allocate,
+--release, --admin or --clear is given.
+
+Principals are API token fingerprints as the audit log records them, e.g.
+token:9f86d081 (printf %s "$TOKEN" | sha256sum | cut -c1-8), SPIFFE
+principals such as spiffe:lab (see --spiffe-id of "ipam server"),
+"anonymous" when the server runs without tokens, or "any". A network without an ACL is
+open to every token; once it has one, the API only allows what it grants,
+admin granting everything, changing the ACL included. This command works
+on the database directly, so it can always reopen a network.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		clearACL, _ := cmd.Flags().GetBool("clear")
+
+		acl := make(store.ACL)
+		set := clearACL
+		for _, p := range store.Permissions {
+			principals, _ := cmd.Flags().GetStringSlice(string(p))
+			for _, principal := range principals {
+				if principal == "" || strings.ContainsAny(principal, " \t=") {
+					return withExitCode(ExitValidation, fmt.Errorf("invalid principal %q for --%s", principal, p))
+				}
+			}
+			if len(principals) > 0 {
+				acl[p] = principals
+				set = true
+			}
+		}
+		switch {
+		case clearACL && !acl.Empty():
+			return withExitCode(ExitValidation, fmt.Errorf("--clear cannot be combined with principals"))
+		case !acl.Empty() && len(acl[store.PermissionAdmin]) == 0:
+			return withExitCode(ExitValidation, fmt.Errorf("--admin must be specified, or the ACL could not be changed through the API"))
+		}
+
+		network, err := findNetwork(args[0])
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		if set {
+			network.Tags = store.ACLTags(network.Tags, acl)
+			network.UpdatedAt = time.Now()
+			if err := ipamStore.SaveNetwork(network); err != nil {
+				return fmt.Errorf("failed to set network ACL: %w", err)
+			}
+		}
+
+		acl = store.NetworkACL(network)
+		if acl.Empty() {
+			fmt.Fprintf(out, "Network %s is open to every token.\n", network.CIDR)
+			return nil
+		}
+		fmt.Fprintf(out, "ACL of network %s:\n", network.CIDR)
+		for _, p := range store.Permissions {
+			principals := "-"
+			if len(acl[p]) > 0 {
+				principals = strings.Join(acl[p], ", ")
+			}
+			fmt.Fprintf(out, "  %-9s %s\n", p, principals)
 		}
 		return nil
 	},
@@ -87,7 +359,7 @@ var networkDeleteCmd = &cobra.Command{
 		}
 
 		if len(allocations) > 0 {
-			return fmt.Errorf("cannot delete network with active allocations")
+			return withExitCode(ExitConflict, fmt.Errorf("cannot delete network with active allocations"))
 		}
 
 		if err := ipamStore.DeleteNetwork(id); err != nil {
@@ -102,10 +374,66 @@ var networkDeleteCmd = &cobra.Command{
 func init() {
 	networkCmd.AddCommand(networkAddCmd)
 	networkCmd.AddCommand(networkListCmd)
+	networkCmd.AddCommand(networkShowCmd)
+	networkCmd.AddCommand(networkCarveCmd)
+	networkCmd.AddCommand(networkFreezeCmd)
+	networkCmd.AddCommand(networkUnfreezeCmd)
+	networkCmd.AddCommand(networkACLCmd)
 	networkCmd.AddCommand(networkDeleteCmd)
 
 	networkAddCmd.Flags().StringP("description", "d", "", "Network description")
 	networkAddCmd.Flags().StringP("tags", "t", "", "Comma-separated tags")
+	networkAddCmd.Flags().Duration("reuse-delay", 0, "Keep released addresses out of circulation this long, e.g. 10m")
+
+	networkShowCmd.Flags().Int("recent", 10, "Number of recent allocations to show")
+
+	networkCarveCmd.Flags().IntP("prefix-len", "p", 64, "Prefix length of the new subnet")
+	networkCarveCmd.Flags().StringP("description", "d", "", "Network description")
+	networkCarveCmd.Flags().StringP("tags", "t", "", "Comma-separated tags")
+
+	networkFreezeCmd.Flags().StringP("reason", "r", "", "Why the network is frozen, e.g. a change ticket")
+	networkFreezeCmd.Flags().String("until", "", "End of the freeze: a duration such as 48h or an RFC 3339 time")
+
+	networkACLCmd.Flags().StringSlice("view", nil, "Principals that may read the network and its allocations")
+	networkACLCmd.Flags().StringSlice("allocate", nil, "Principals that may allocate and hold addresses")
+	networkACLCmd.Flags().StringSlice("release", nil, "Principals that may release addresses")
+	networkACLCmd.Flags().StringSlice("admin", nil, "Principals that may do anything, changing the ACL included")
+	networkACLCmd.Flags().Bool("clear", false, "Remove the ACL, opening the network to every token")
+}
+
+// findNetwork looks a network up by ID, then by CIDR
+func findNetwork(idOrCIDR string) (*ipam.Network, error) {
+	network, err := ipamStore.GetNetwork(idOrCIDR)
+	if err != nil {
+		var byCIDR error
+		if network, byCIDR = ipamStore.GetNetworkByCIDR(idOrCIDR); byCIDR != nil {
+			return nil, fmt.Errorf("failed to get network: %w", err)
+		}
+	}
+	return network, nil
+}
+
+// parseUntil parses a future point in time given as a duration from now or
+// an RFC 3339 timestamp
+func parseUntil(s string, now time.Time) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		d, durErr := time.ParseDuration(s)
+		if durErr != nil {
+			return time.Time{}, fmt.Errorf("invalid time %q: use a duration such as 48h or an RFC 3339 time", s)
+		}
+		t = now.Add(d)
+	}
+	if !t.After(now) {
+		return time.Time{}, fmt.Errorf("time %q is not in the future", s)
+	}
+	return t, nil
+}
+
+// checkNotFrozen fails when the network identified by networkID, or cidr
+// when networkID is empty, is under a maintenance freeze
+func checkNotFrozen(networkID, cidr string) error {
+	return store.CheckNetworkNotFrozen(pebbleStore, networkID, cidr, time.Now())
 }
 
 func truncate(s string, max int) string {
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/neutron"
+	"github.com/spf13/cobra"
+)
+
+var neutronCmd = &cobra.Command{
+	Use:   "neutron",
+	Short: "Mirror OpenStack Neutron subnets and ports",
+	Long: `Mirror the subnets and ports of an OpenStack cloud, so private-cloud address
+space is visible alongside the rest of the plan and checked for conflicts
+with it. Run "ipam neutron sync" from cron, or after changes to the cloud.`,
+}
+
+var neutronSyncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Import subnets and ports from Neutron",
+	Long: `Import the subnets and ports the credentials may see. Each subnet becomes a
+network tagged neutron-subnet=<id>, neutron-network=<id> and
+tenant=<project>, named after the subnet. Each fixed IP of a port becomes
+an allocation tagged neutron-port=<id> and mac=<MAC>, and gateway for the
+subnet's gateway_ip.
+
+A subnet is reported as a conflict, and not imported, when its CIDR is
+registered already or contains a registered network; it may sit inside a
+registered network, such as the cloud's supernet. A fixed IP is reported
+when another allocation holds the address. Allocations of deleted ports
+are released; networks of deleted subnets are kept and listed as stale.
+
+Credentials are read from the OS_* variables of an OpenStack RC file:
+OS_APPLICATION_CREDENTIAL_ID and OS_APPLICATION_CREDENTIAL_SECRET, or
+OS_USERNAME, OS_PASSWORD, OS_PROJECT_NAME and their domains.`,
+	Example: `  source admin-openrc.sh
+  ipam neutron sync --dry-run
+  ipam neutron sync --region RegionOne`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		authURL, _ := cmd.Flags().GetString("auth-url")
+		region, _ := cmd.Flags().GetString("region")
+		iface, _ := cmd.Flags().GetString("interface")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		if authURL == "" {
+			authURL = os.Getenv("OS_AUTH_URL")
+		}
+		if authURL == "" {
+			return withExitCode(ExitValidation, errors.New("--auth-url or OS_AUTH_URL is required"))
+		}
+		if region == "" {
+			region = os.Getenv("OS_REGION_NAME")
+		}
+		if iface == "" {
+			iface = os.Getenv("OS_INTERFACE")
+		}
+
+		client := neutron.NewClient(authURL, neutron.CredentialsFromEnv(os.Getenv))
+		client.Region = region
+		client.Interface = iface
+
+		ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+		defer cancel()
+		subnets, err := client.Subnets(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read Neutron subnets: %w", err)
+		}
+		ports, err := client.Ports(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read Neutron ports: %w", err)
+		}
+
+		syncer := &neutron.Syncer{Store: pebbleStore, AddNetwork: ipamClient.AddNetwork, DryRun: dryRun}
+		result, err := syncer.Sync(subnets, ports, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to sync Neutron: %w", err)
+		}
+
+		out := cmd.OutOrStdout()
+		if asJSON {
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			return enc.Encode(result)
+		}
+		if dryRun {
+			fmt.Fprintln(out, "Dry run: no changes made.")
+		}
+		fmt.Fprintf(out, "Subnets:              %d\n", result.Subnets)
+		fmt.Fprintf(out, "Networks created:     %d\n", result.NetworksCreated)
+		fmt.Fprintf(out, "Networks updated:     %d\n", result.NetworksUpdated)
+		fmt.Fprintf(out, "Allocations created:  %d\n", result.AllocationsCreated)
+		fmt.Fprintf(out, "Allocations updated:  %d\n", result.AllocationsUpdated)
+		fmt.Fprintf(out, "Allocations released: %d\n", result.AllocationsReleased)
+
+		fmt.Fprintf(out, "\nConflicts (%d):\n", len(result.Conflicts))
+		for _, c := range result.Conflicts {
+			source := "subnet " + c.SubnetID
+			if c.PortID != "" {
+				source = "port " + c.PortID
+			}
+			fmt.Fprintf(out, "  %-40s %-44s %s\n", c.Address, source, c.Reason)
+		}
+
+		fmt.Fprintf(out, "\nSubnets no longer in Neutron (%d):\n", len(result.Stale))
+		for _, cidr := range result.Stale {
+			fmt.Fprintf(out, "  %s\n", cidr)
+		}
+		if len(result.Frozen) > 0 {
+			fmt.Fprintf(out, "\nFrozen networks left alone (%d):\n", len(result.Frozen))
+			for _, cidr := range result.Frozen {
+				fmt.Fprintf(out, "  %s\n", cidr)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	neutronSyncCmd.Flags().String("auth-url", "", "Keystone URL (default $OS_AUTH_URL)")
+	neutronSyncCmd.Flags().String("region", "", "Region of the Neutron endpoint (default $OS_REGION_NAME)")
+	neutronSyncCmd.Flags().String("interface", "", "Endpoint interface: public, internal or admin (default $OS_INTERFACE, else public)")
+	neutronSyncCmd.Flags().Bool("dry-run", false, "Report the changes without making them")
+	neutronSyncCmd.Flags().Duration("timeout", 2*time.Minute, "Time allowed for reading Neutron")
+	neutronSyncCmd.Flags().Bool("json", false, "Print the result as JSON")
+	neutronCmd.AddCommand(neutronSyncCmd)
+}
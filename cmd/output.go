@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Output formats accepted by --format
+const (
+	formatTable = "table"
+	formatCSV   = "csv"
+	formatTSV   = "tsv"
+)
+
+// outputColumn describes one selectable column of tabular output
+type outputColumn struct {
+	Name     string // Identifier used with --columns and as the CSV header
+	Header   string // Header in table format
+	Width    int    // Padded width in table format
+	Truncate bool   // Truncate values longer than Width in table format
+}
+
+// table renders rows in the format and column order chosen on the command
+// line. Rows are keyed by column name.
+type table struct {
+	format  string
+	columns []outputColumn
+}
+
+// newTable resolves --format and --columns against the columns a command
+// offers. An empty columns string selects defaults.
+func newTable(format, columns string, available []outputColumn, defaults []string) (*table, error) {
+	switch format {
+	case "", formatTable:
+		format = formatTable
+	case formatCSV, formatTSV:
+	default:
+		return nil, withExitCode(ExitValidation, fmt.Errorf("invalid format %q: must be table, csv or tsv", format))
+	}
+
+	byName := make(map[string]outputColumn, len(available))
+	var names []string
+	for _, c := range available {
+		byName[c.Name] = c
+		names = append(names, c.Name)
+	}
+
+	selected := defaults
+	if columns != "" {
+		selected = strings.Split(columns, ",")
+	}
+
+	t := &table{format: format}
+	for _, name := range selected {
+		name = strings.TrimSpace(name)
+		c, ok := byName[name]
+		if !ok {
+			return nil, withExitCode(ExitValidation, fmt.Errorf("unknown column %q: available columns are %s", name, strings.Join(names, ", ")))
+		}
+		t.columns = append(t.columns, c)
+	}
+	return t, nil
+}
+
+// isTable reports whether output is the human-readable fixed-width format
+func (t *table) isTable() bool {
+	return t.format == formatTable
+}
+
+// render writes a header followed by rows
+func (t *table) render(w io.Writer, rows []map[string]string) error {
+	if t.isTable() {
+		t.renderTable(w, rows)
+		return nil
+	}
+
+	cw := csv.NewWriter(w)
+	if t.format == formatTSV {
+		cw.Comma = '\t'
+	}
+
+	record := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		record[i] = c.Name
+	}
+	if err := cw.Write(record); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		for i, c := range t.columns {
+			record[i] = row[c.Name]
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func (t *table) renderTable(w io.Writer, rows []map[string]string) {
+	values := make([]string, len(t.columns))
+	rule := 0
+	for i, c := range t.columns {
+		values[i] = c.Header
+		rule += c.Width
+		if i < len(t.columns)-1 {
+			rule++
+		}
+	}
+	t.writeLine(w, values)
+	fmt.Fprintln(w, strings.Repeat("-", rule))
+
+	for _, row := range rows {
+		for i, c := range t.columns {
+			values[i] = row[c.Name]
+			if c.Truncate {
+				values[i] = truncate(values[i], c.Width)
+			}
+		}
+		t.writeLine(w, values)
+	}
+}
+
+// writeLine pads every column but the last to its width
+func (t *table) writeLine(w io.Writer, values []string) {
+	var b strings.Builder
+	for i, v := range values {
+		if i == len(values)-1 {
+			b.WriteString(v)
+		} else {
+			fmt.Fprintf(&b, "%-*s ", t.columns[i].Width, v)
+		}
+	}
+	fmt.Fprintln(w, b.String())
+}
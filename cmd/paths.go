@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// defaultDir returns the default location of a directory the CLI keeps.
+// A directory named legacy in the working directory, where earlier
+// releases kept it, is still used. Otherwise it is name under the per-user
+// directory returned by base, such as os.UserConfigDir, so commands find the
+// same data from any working directory: ~/.config/ipam on Linux,
+// ~/Library/Application Support/ipam on macOS and %AppData%\ipam on
+// Windows.
+func defaultDir(legacy string, base func() (string, error), name string) string {
+	if fi, err := os.Stat(legacy); err == nil && fi.IsDir() {
+		return legacy
+	}
+	dir, err := base()
+	if err != nil {
+		// No home directory, e.g. a service account
+		return legacy
+	}
+	return filepath.Join(dir, "ipam", name)
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/plan"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Propose a hierarchical address plan",
+	Long: `Split a supernet into per-site blocks and each site into per-VLAN subnets,
+skipping ranges already used by existing networks. Site blocks are rounded to
+an octet (IPv4) or nibble (IPv6) boundary when the supernet has room.
+
+The proposal is only printed. Review it, then either save it with -o and
+create the networks with "ipam apply -f", or re-run with --commit.`,
+	Example: `  ipam plan --supernet 10.0.0.0/8 --sites 12 --hosts-per-site 500
+  ipam plan --supernet 10.0.0.0/8 --sites 12 --hosts-per-site 500 -o sites.yaml`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		supernet, _ := cmd.Flags().GetString("supernet")
+		sites, _ := cmd.Flags().GetInt("sites")
+		hostsPerSite, _ := cmd.Flags().GetInt("hosts-per-site")
+		hostsPerVLAN, _ := cmd.Flags().GetInt("hosts-per-vlan")
+		sitePrefix, _ := cmd.Flags().GetInt("site-prefix")
+		vlanPrefix, _ := cmd.Flags().GetInt("vlan-prefix")
+		output, _ := cmd.Flags().GetString("output")
+		commit, _ := cmd.Flags().GetBool("commit")
+
+		if supernet == "" {
+			return withExitCode(ExitValidation, fmt.Errorf("--supernet must be specified"))
+		}
+
+		networks, err := pebbleStore.ListNetworks()
+		if err != nil {
+			return fmt.Errorf("failed to list networks: %w", err)
+		}
+
+		p, err := plan.Generate(plan.Layout{
+			Supernet:     supernet,
+			Sites:        sites,
+			HostsPerSite: hostsPerSite,
+			HostsPerVLAN: hostsPerVLAN,
+			SitePrefix:   sitePrefix,
+			VLANPrefix:   vlanPrefix,
+		}, networks)
+		if err != nil {
+			return withExitCode(ExitValidation, err)
+		}
+
+		out := cmd.OutOrStdout()
+		for _, network := range p.Networks {
+			cidr := network.CIDR
+			if slices.ContainsFunc(network.Tags, func(tag string) bool { return strings.HasPrefix(tag, "vlan=") }) {
+				cidr = "  " + cidr
+			}
+			fmt.Fprintf(out, "%-24s %s\n", cidr, network.Description)
+		}
+		fmt.Fprintf(out, "\n%d networks proposed.\n", len(p.Networks))
+
+		if output != "" {
+			data, err := yaml.Marshal(p)
+			if err != nil {
+				return fmt.Errorf("failed to encode plan: %w", err)
+			}
+			if err := os.WriteFile(output, data, 0644); err != nil {
+				return fmt.Errorf("failed to write plan: %w", err)
+			}
+			fmt.Fprintf(out, "Plan written to %s; create it with: ipam apply -f %s\n", output, output)
+		}
+
+		if !commit {
+			return nil
+		}
+
+		changes, err := plan.Compute(p, networks, map[string][]*ipam.IPAllocation{}, false, time.Now())
+		if err != nil {
+			return withExitCode(ExitConflict, err)
+		}
+		if err := applyChanges(changes); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Created %d networks.\n", len(changes))
+		return nil
+	},
+}
+
+func init() {
+	planCmd.Flags().String("supernet", "", "Address block to divide, e.g. 10.0.0.0/8")
+	planCmd.Flags().Int("sites", 1, "Number of sites")
+	planCmd.Flags().Int("hosts-per-site", 254, "Hosts needed at each site")
+	planCmd.Flags().Int("hosts-per-vlan", plan.DefaultHostsPerVLAN, "Maximum hosts in one VLAN")
+	planCmd.Flags().Int("site-prefix", 0, "Site prefix length (derived when 0)")
+	planCmd.Flags().Int("vlan-prefix", 0, "VLAN prefix length (derived when 0)")
+	planCmd.Flags().StringP("output", "o", "", "Write the plan as a YAML file for ipam apply")
+	planCmd.Flags().Bool("commit", false, "Create the proposed networks")
+}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/powerdns"
+	"github.com/spf13/cobra"
+)
+
+var powerdnsCmd = &cobra.Command{
+	Use:   "powerdns",
+	Short: "Keep PowerDNS records in lockstep with allocations",
+	Long: `Push A, AAAA and PTR records for allocations with hostnames into a
+PowerDNS Authoritative server through its HTTP API. Run "ipam powerdns sync"
+once, or start the server with --powerdns-url to keep them in sync.`,
+}
+
+var powerdnsSyncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Sync PowerDNS records now",
+	Long: `Give every active allocation with a hostname an A or AAAA record in the
+most specific zone the server hosts for its name, and a PTR record in the
+most specific reverse zone. Hostnames are qualified with the network's
+domain=<name> tag, else --domain.
+
+Record sets made by the sync carry a comment by the go-ipam account. They
+are replaced when they drift from the allocations, and deleted once no
+allocation calls for them. Record sets made by other means are never
+changed: one the allocations would change is reported as a conflict.
+Secondary zones are skipped.`,
+	Example: `  ipam powerdns sync --url http://pdns:8081 --api-key-file /etc/ipam/pdns-key --dry-run
+  ipam powerdns sync --url http://pdns:8081 --api-key-file /etc/ipam/pdns-key --zone lan.example.com --zone 30.168.192.in-addr.arpa`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		syncer, err := powerdnsSyncerFromFlags(cmd, "")
+		if err != nil {
+			return err
+		}
+		if syncer == nil {
+			return withExitCode(ExitValidation, errors.New("--url is required"))
+		}
+		syncer.Store = pebbleStore
+		syncer.DryRun = dryRun
+
+		ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+		defer cancel()
+		result, err := syncer.Sync(ctx, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to sync PowerDNS records: %w", err)
+		}
+
+		out := cmd.OutOrStdout()
+		if asJSON {
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			return enc.Encode(result)
+		}
+		if dryRun {
+			fmt.Fprintln(out, "Dry run: no changes made.")
+		}
+		fmt.Fprintf(out, "Zones:      %d\n", result.Zones)
+		fmt.Fprintf(out, "Created:    %d\n", result.Created)
+		fmt.Fprintf(out, "Updated:    %d\n", result.Updated)
+		fmt.Fprintf(out, "Deleted:    %d\n", result.Deleted)
+		fmt.Fprintf(out, "Unchanged:  %d\n", result.Unchanged)
+
+		fmt.Fprintf(out, "\nConflicts (%d):\n", len(result.Conflicts))
+		for _, c := range result.Conflicts {
+			fmt.Fprintf(out, "  %-40s %-5s %s\n", c.Name, c.Type, c.Reason)
+		}
+		fmt.Fprintf(out, "\nNames without a zone (%d):\n", len(result.Unzoned))
+		for _, name := range result.Unzoned {
+			fmt.Fprintf(out, "  %s\n", name)
+		}
+		return nil
+	},
+}
+
+// powerdnsSyncerFromFlags returns a PowerDNS syncer for the flags named
+// with prefix, e.g. "powerdns-" for the server's, or nil when no URL is
+// given
+func powerdnsSyncerFromFlags(cmd *cobra.Command, prefix string) (*powerdns.Syncer, error) {
+	url, _ := cmd.Flags().GetString(prefix + "url")
+	keyFile, _ := cmd.Flags().GetString(prefix + "api-key-file")
+	if url == "" {
+		if keyFile != "" {
+			return nil, withExitCode(ExitValidation, fmt.Errorf("--%sapi-key-file requires --%surl", prefix, prefix))
+		}
+		return nil, nil
+	}
+	if keyFile == "" {
+		return nil, withExitCode(ExitValidation, fmt.Errorf("--%surl requires --%sapi-key-file", prefix, prefix))
+	}
+	ttl, _ := cmd.Flags().GetInt(prefix + "ttl")
+	if ttl <= 0 {
+		return nil, withExitCode(ExitValidation, fmt.Errorf("--%sttl must be positive", prefix))
+	}
+	batchSize, _ := cmd.Flags().GetInt(prefix + "batch-size")
+	if batchSize <= 0 {
+		return nil, withExitCode(ExitValidation, fmt.Errorf("--%sbatch-size must be positive", prefix))
+	}
+
+	keys, err := readTokens(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("powerdns api key: %w", err)
+	}
+	client := powerdns.NewClient(url, keys[0])
+	client.ServerID, _ = cmd.Flags().GetString(prefix + "server-id")
+
+	syncer := &powerdns.Syncer{Client: client, TTL: ttl, BatchSize: batchSize}
+	syncer.Zones, _ = cmd.Flags().GetStringSlice(prefix + "zone")
+	syncer.Domain, _ = cmd.Flags().GetString(prefix + "domain")
+	return syncer, nil
+}
+
+func init() {
+	powerdnsSyncCmd.Flags().String("url", "", "PowerDNS API URL, e.g. http://pdns:8081")
+	powerdnsSyncCmd.Flags().String("api-key-file", "", "File holding the PowerDNS API key")
+	powerdnsSyncCmd.Flags().String("server-id", powerdns.DefaultServerID, "PowerDNS server whose zones are synced")
+	powerdnsSyncCmd.Flags().StringSlice("zone", nil, "Only sync these zones (default every primary and native zone; repeatable)")
+	powerdnsSyncCmd.Flags().String("domain", "", "Domain qualifying hostnames in networks without a domain=<name> tag")
+	powerdnsSyncCmd.Flags().Int("ttl", powerdns.DefaultTTL, "TTL of the records created, in seconds")
+	powerdnsSyncCmd.Flags().Int("batch-size", powerdns.DefaultBatchSize, "Record sets changed per zone update at most")
+	powerdnsSyncCmd.Flags().Bool("dry-run", false, "Report the changes without making them")
+	powerdnsSyncCmd.Flags().Duration("timeout", 2*time.Minute, "Time allowed for the sync")
+	powerdnsSyncCmd.Flags().Bool("json", false, "Print the result as JSON")
+	powerdnsCmd.AddCommand(powerdnsSyncCmd)
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/hooks"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/reclaim"
+	"github.com/jeremyhahn/go-ipam/pkg/webhook"
+	"github.com/spf13/cobra"
+)
+
+var reclaimCmd = &cobra.Command{
+	Use:   "reclaim",
+	Short: "Release allocations that stopped answering health checks",
+	Long: `Release allocations that have not answered "ipam health check" probes for
+a network's reclaim-after period. An allocation due for reclamation is first
+tagged reclaim-pending=<time> and released only if it is still silent once
+the grace period has passed. Tag an allocation reclaim-exempt to keep it.`,
+}
+
+var reclaimPolicyCmd = &cobra.Command{
+	Use:   "policy [ID|CIDR]",
+	Short: "Set or remove a network's reclamation policy",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		disable, _ := cmd.Flags().GetBool("disable")
+		afterStr, _ := cmd.Flags().GetString("after")
+		graceStr, _ := cmd.Flags().GetString("grace")
+		tags, _ := cmd.Flags().GetStringSlice("tag")
+
+		var policy *reclaim.Policy
+		if !disable {
+			if afterStr == "" {
+				return withExitCode(ExitValidation, fmt.Errorf("--after or --disable must be specified"))
+			}
+			policy = &reclaim.Policy{Tags: tags}
+			var err error
+			if policy.After, err = reclaim.ParseDuration(afterStr); err != nil {
+				return withExitCode(ExitValidation, fmt.Errorf("--after: %w", err))
+			}
+			policy.Grace = reclaim.DefaultGrace
+			if graceStr != "" {
+				if policy.Grace, err = reclaim.ParseDuration(graceStr); err != nil {
+					return withExitCode(ExitValidation, fmt.Errorf("--grace: %w", err))
+				}
+			}
+		}
+
+		network, err := findNetwork(args[0])
+		if err != nil {
+			return err
+		}
+
+		network.Tags = reclaim.PolicyTags(network.Tags, policy)
+		network.UpdatedAt = time.Now()
+		if err := ipamStore.SaveNetwork(network); err != nil {
+			return fmt.Errorf("failed to save network: %w", err)
+		}
+
+		if policy == nil {
+			fmt.Fprintf(cmd.OutOrStdout(), "Reclamation disabled for network %s.\n", network.CIDR)
+		} else {
+			fmt.Fprintf(cmd.OutOrStdout(), "Network %s: %s\n", network.CIDR, policy)
+		}
+		return nil
+	},
+}
+
+var reclaimRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Apply the reclamation policies now",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		webhookURL, _ := cmd.Flags().GetString("webhook")
+		secretFile, _ := cmd.Flags().GetString("webhook-secret-file")
+
+		signer, err := webhookSigner(secretFile)
+		if err != nil {
+			return err
+		}
+		notifier, err := reclaimNotifier(webhookURL, signer)
+		if err != nil {
+			return withExitCode(ExitValidation, err)
+		}
+
+		r := &reclaim.Reclaimer{
+			Store:    pebbleStore,
+			Release:  reclaimRelease(hooks.Registered(), ipamClient, pebbleStore),
+			Notifier: notifier,
+			DryRun:   dryRun,
+		}
+		events, err := r.Reclaim(cmd.Context(), time.Now())
+
+		out := cmd.OutOrStdout()
+		prefix := ""
+		if dryRun {
+			prefix = "(dry run) "
+		}
+		for _, event := range events {
+			fmt.Fprintf(out, "%s%s\n", prefix, event)
+		}
+		if len(events) == 0 {
+			fmt.Fprintln(out, "Nothing to reclaim.")
+		}
+		return err
+	},
+}
+
+// reclaimNotifier returns a webhook notifier for rawURL signing with
+// signer, or nil when rawURL is empty
+func reclaimNotifier(rawURL string, signer *webhook.Signer) (reclaim.Notifier, error) {
+	if rawURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q", rawURL)
+	}
+	return &reclaim.Webhook{URL: rawURL, Signer: signer}, nil
+}
+
+// reclaimRelease releases allocations through client, running chain around
+// each release and holding the addresses for the reuse delay of their
+// network
+func reclaimRelease(chain hooks.Chain, client *ipam.IPAM, st ipam.Store) func(context.Context, *ipam.IPAllocation) error {
+	return func(ctx context.Context, alloc *ipam.IPAllocation) error {
+		return chain.Release(ctx, st, alloc, func() error {
+			return releaseQuarantined(client, st, alloc, "reclaim")
+		})
+	}
+}
+
+func init() {
+	reclaimPolicyCmd.Flags().String("after", "", "Reclaim allocations silent for this long, e.g. 30d or 720h")
+	reclaimPolicyCmd.Flags().String("grace", "", "Wait this long after marking an allocation before releasing it (default 7d)")
+	reclaimPolicyCmd.Flags().StringSlice("tag", nil, "Only reclaim allocations carrying this tag (repeatable)")
+	reclaimPolicyCmd.Flags().Bool("disable", false, "Remove the network's reclamation policy")
+	reclaimRunCmd.Flags().Bool("dry-run", false, "Print what would happen without changing anything")
+	reclaimRunCmd.Flags().String("webhook", "", "POST each event as JSON to this URL")
+	reclaimRunCmd.Flags().String("webhook-secret-file", "", "Sign --webhook requests with HMAC-SHA256 using the secret in this file")
+
+	reclaimCmd.AddCommand(reclaimPolicyCmd)
+	reclaimCmd.AddCommand(reclaimRunCmd)
+}
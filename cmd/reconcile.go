@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/reconcile"
+	"github.com/spf13/cobra"
+)
+
+var reconcileCmd = &cobra.Command{
+	Use:   "reconcile [FILE]",
+	Short: "Compare allocations with observed addresses",
+	Long: `Compare a network's allocations with a list of addresses observed on the
+network and report addresses in use but not recorded, and recorded
+allocations that were not observed.
+
+FILE may be a plain address list, ARP table dump (arp -an, ip neigh),
+nmap or masscan XML output (--format nmap, --format masscan) or a JSON
+array (--format json). Use "-" to read from stdin.
+
+With --import, an nmap or masscan scan is imported before comparing:
+allocations of hosts found up get their last-seen tag updated, and hosts
+without an allocation get one tagged discovered. Discovered allocations
+are reported until the tag is removed, so that scheduled scans keep the
+network aligned while unknown hosts stay visible for review.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		networkID, _ := cmd.Flags().GetString("network-id")
+		cidr, _ := cmd.Flags().GetString("cidr")
+		format, _ := cmd.Flags().GetString("format")
+		importScan, _ := cmd.Flags().GetBool("import")
+		if importScan && format != reconcile.FormatNmap && format != reconcile.FormatMasscan {
+			return withExitCode(ExitValidation, fmt.Errorf("--import needs --format nmap or masscan"))
+		}
+
+		var network *ipam.Network
+		var err error
+		switch {
+		case networkID != "":
+			network, err = pebbleStore.GetNetwork(networkID)
+		case cidr != "":
+			network, err = pebbleStore.GetNetworkByCIDR(cidr)
+		default:
+			return withExitCode(ExitValidation, fmt.Errorf("either --network-id or --cidr must be specified"))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get network: %w", err)
+		}
+
+		var r io.Reader = cmd.InOrStdin()
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to open observation file: %w", err)
+			}
+			defer f.Close()
+			r = f
+		}
+
+		var observed []netip.Addr
+		var hosts []reconcile.Host
+		if importScan {
+			hosts, err = reconcile.ParseScan(r)
+			observed = reconcile.Addrs(hosts)
+		} else {
+			observed, err = reconcile.Parse(r, format)
+		}
+		if err != nil {
+			return withExitCode(ExitValidation, fmt.Errorf("failed to parse observation file: %w", err))
+		}
+
+		out := cmd.OutOrStdout()
+		if importScan {
+			if err := checkNotFrozen(network.ID, ""); err != nil {
+				return err
+			}
+			result, err := reconcile.Import(pebbleStore, network, hosts, time.Now())
+			if err != nil {
+				return fmt.Errorf("failed to import scan: %w", err)
+			}
+			fmt.Fprintf(out, "Scanned:    %d\n", result.Scanned)
+			fmt.Fprintf(out, "Seen:       %d\n", result.Seen)
+			fmt.Fprintf(out, "Discovered: %d\n", len(result.Discovered))
+			if len(result.Skipped) > 0 {
+				fmt.Fprintf(out, "Skipped:    %s\n", strings.Join(result.Skipped, ", "))
+			}
+			fmt.Fprintln(out)
+		}
+
+		allocations, err := pebbleStore.ListAllocations(network.ID)
+		if err != nil {
+			return fmt.Errorf("failed to list allocations: %w", err)
+		}
+
+		report, err := reconcile.Compare(network, allocations, observed, time.Now())
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "Network:    %s (%s)\n", report.CIDR, report.NetworkID)
+		fmt.Fprintf(out, "Observed:   %d\n", report.Observed)
+		fmt.Fprintf(out, "Recorded:   %d\n", report.Recorded)
+		fmt.Fprintf(out, "Matched:    %d\n", report.Matched)
+
+		fmt.Fprintf(out, "\nIn use but not recorded (%d):\n", len(report.Unrecorded))
+		for _, ip := range report.Unrecorded {
+			fmt.Fprintf(out, "  %s\n", ip)
+		}
+
+		fmt.Fprintf(out, "\nRecorded but not observed (%d):\n", len(report.Unobserved))
+		for _, u := range report.Unobserved {
+			fmt.Fprintf(out, "  %-40s %-20s %s\n", u.IP, u.AllocationID, u.Hostname)
+		}
+
+		if len(report.Discovered) > 0 {
+			fmt.Fprintf(out, "\nDiscovered by scans, not reviewed (%d):\n", len(report.Discovered))
+			for _, d := range report.Discovered {
+				fmt.Fprintf(out, "  %-40s %-20s %s\n", d.IP, d.AllocationID, d.Hostname)
+			}
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	reconcileCmd.Flags().StringP("network-id", "n", "", "Network ID to reconcile")
+	reconcileCmd.Flags().StringP("cidr", "c", "", "Network CIDR to reconcile")
+	reconcileCmd.Flags().StringP("format", "f", "text", "Observation format: text, nmap, masscan or json")
+	reconcileCmd.Flags().Bool("import", false, "Import an nmap or masscan scan: update last-seen and record unknown hosts as discovered")
+}
@@ -1,50 +1,64 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/jeremyhahn/go-ipam/pkg/hooks"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
 	"github.com/spf13/cobra"
 )
 
 var releaseCmd = &cobra.Command{
-	Use:   "release [IP]",
-	Short: "Release an allocated IP address",
-	Args:  cobra.ExactArgs(1),
+	Use:   "release [IP|ID|RANGE|CIDR]...",
+	Short: "Release allocated IP addresses",
+	Long: `Release allocated IP addresses.
+
+Name a single IP, or any number of allocation IDs, IPs, dash ranges such as
+10.0.0.10-10.0.0.20 and CIDRs. Ranges and CIDRs release the allocations
+lying within them; an allocation only partly inside is skipped. Everything
+is released in a single batch, with a result for each allocation.`,
+	Args: cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ip := args[0]
 		networkID, _ := cmd.Flags().GetString("network-id")
+		if _, err := netip.ParseAddr(ip); err != nil || len(args) > 1 {
+			return releaseMany(cmd, args, networkID)
+		}
 
 		if networkID == "" {
-			// Try to find the network containing this IP
-			networks, err := pebbleStore.ListNetworks()
+			// Find the network holding this IP
+			loc, err := store.Locate(pebbleStore, ip, time.Now())
 			if err != nil {
-				return fmt.Errorf("failed to list networks: %w", err)
+				return withExitCode(ExitNotFound, fmt.Errorf("IP %s not found in any network", ip))
 			}
-
-			for _, network := range networks {
-				allocations, err := pebbleStore.ListAllocations(network.ID)
-				if err != nil {
-					continue
-				}
-
-				for _, alloc := range allocations {
-					if alloc.IP == ip && alloc.ReleasedAt == nil {
-						networkID = network.ID
-						break
+			if loc.Allocation == nil {
+				for _, network := range loc.Networks {
+					if err := checkReleased(network.ID, ip); err != nil {
+						return err
 					}
 				}
-
-				if networkID != "" {
-					break
-				}
+				return withExitCode(ExitNotFound, fmt.Errorf("IP %s not found in any network", ip))
 			}
+			networkID = loc.Allocation.NetworkID
+		}
 
-			if networkID == "" {
-				return fmt.Errorf("IP %s not found in any network", ip)
-			}
+		if err := checkNotFrozen(networkID, ""); err != nil {
+			return err
 		}
 
-		if err := ipamClient.ReleaseIP(networkID, ip); err != nil {
+		if err := releaseWithHooks(cmd.Context(), hooks.Registered(), networkID, ip); err != nil {
+			if errors.Is(err, ipam.ErrIPNotAllocated) {
+				if err := checkReleased(networkID, ip); err != nil {
+					return err
+				}
+			}
 			return fmt.Errorf("failed to release IP: %w", err)
 		}
 
@@ -53,6 +67,205 @@ var releaseCmd = &cobra.Command{
 	},
 }
 
+// releaseResult is the outcome of releasing one allocation, or of a target
+// that named none
+type releaseResult struct {
+	target   string
+	alloc    *ipam.IPAllocation
+	pre      *ipam.IPAllocation // Copy the pre hooks ran on, set once they let it through
+	result   string
+	released bool
+}
+
+// releaseMany releases the allocations targets name in one batch and
+// prints the result for each. Allocations that are frozen, vetoed by a
+// hook or already released are reported and left alone; the rest are
+// released together or not at all.
+func releaseMany(cmd *cobra.Command, targets []string, networkID string) error {
+	ctx := cmd.Context()
+	chain := hooks.Registered()
+	now := time.Now()
+
+	var results []*releaseResult
+	seen := make(map[string]bool)
+	for _, target := range targets {
+		allocations, partial, err := resolveRelease(target, networkID, now)
+		if err != nil {
+			results = append(results, &releaseResult{target: target, result: err.Error()})
+			continue
+		}
+		if len(allocations) == 0 && len(partial) == 0 {
+			results = append(results, &releaseResult{target: target, result: "nothing allocated"})
+		}
+		for _, alloc := range partial {
+			results = append(results, &releaseResult{target: target, alloc: alloc, result: "skipped: extends beyond " + target})
+		}
+		for _, alloc := range allocations {
+			if seen[alloc.ID] {
+				continue
+			}
+			seen[alloc.ID] = true
+			r := &releaseResult{target: target, alloc: alloc}
+			results = append(results, r)
+			if err := checkNotFrozen(alloc.NetworkID, ""); err != nil {
+				r.result = err.Error()
+				continue
+			}
+			pre := *alloc
+			pre.Tags = slices.Clone(alloc.Tags)
+			if err := chain.PreRelease(ctx, &pre); err != nil {
+				r.result = err.Error()
+				continue
+			}
+			r.pre = &pre
+		}
+	}
+
+	// Release through a staging store, so that the releases and their audit
+	// entries are saved together
+	staging := store.NewStaging(pebbleStore)
+	engine := ipam.New(staging)
+	var staged []*releaseResult
+	for _, r := range results {
+		if r.pre == nil {
+			continue
+		}
+		if err := engine.ReleaseIP(r.alloc.NetworkID, r.alloc.IP); err != nil {
+			r.result = err.Error()
+			continue
+		}
+		hold, err := store.Quarantine(staging, r.alloc, now)
+		if err == nil && hold != nil {
+			err = store.RecordAudit(staging, cliAuditUser, "ip_held", hold.ID, quarantineDetails(hold), now)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to hold %s for the reuse delay: %w", r.alloc.IP, err)
+		}
+		staged = append(staged, r)
+	}
+	if len(staged) > 0 {
+		if err := pebbleStore.ApplyBatch(staging.Batch()); err != nil {
+			return fmt.Errorf("failed to release: %w", err)
+		}
+		for _, r := range staged {
+			r.result, r.released = "released", true
+			if released, err := pebbleStore.GetAllocation(r.alloc.ID); err == nil {
+				chain.PostRelease(ctx, pebbleStore, released, r.pre)
+			}
+		}
+	}
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "%-34s %-34s %-34s %s\n", "Target", "IP", "Allocation ID", "Result")
+	fmt.Fprintln(out, strings.Repeat("-", 120))
+	for _, r := range results {
+		ip, id := "-", "-"
+		if r.alloc != nil {
+			ip, id = r.alloc.IP, r.alloc.ID
+			if r.alloc.EndIP != "" {
+				ip = r.alloc.IP + "-" + r.alloc.EndIP
+			}
+		}
+		fmt.Fprintf(out, "%-34s %-34s %-34s %s\n", truncate(r.target, 34), truncate(ip, 34), id, r.result)
+	}
+
+	failed := len(results) - len(staged)
+	fmt.Fprintf(out, "\nReleased %d of %d.\n", len(staged), len(results))
+	if failed > 0 {
+		return fmt.Errorf("%d of %d could not be released", failed, len(results))
+	}
+	return nil
+}
+
+// resolveRelease returns the unreleased allocations target names, by ID,
+// address, dash range or CIDR, in networkID when set. Ranges and CIDRs
+// return the allocations only partly inside apart; an address selects the
+// allocation covering it.
+func resolveRelease(target, networkID string, now time.Time) (allocations, partial []*ipam.IPAllocation, err error) {
+	if alloc, err := pebbleStore.GetAllocation(target); err == nil {
+		if networkID != "" && alloc.NetworkID != networkID {
+			return nil, nil, fmt.Errorf("allocation %s is not in network %s", target, networkID)
+		}
+		if err := store.CheckReleasable(pebbleStore, alloc); err != nil {
+			return nil, nil, err
+		}
+		return []*ipam.IPAllocation{alloc}, nil, nil
+	}
+
+	first, last, err := store.ParseAddressRange(target)
+	if err != nil {
+		return nil, nil, fmt.Errorf("not an allocation ID, IP, range or CIDR")
+	}
+	if first != last || strings.Contains(target, "/") {
+		return store.AllocationsWithin(pebbleStore, networkID, first, last)
+	}
+
+	// A single address
+	if networkID == "" {
+		loc, err := store.Locate(pebbleStore, target, now)
+		if err != nil {
+			return nil, nil, fmt.Errorf("not in any network")
+		}
+		if loc.Allocation == nil {
+			for _, network := range loc.Networks {
+				if err := checkReleased(network.ID, first.String()); err != nil {
+					return nil, nil, err
+				}
+			}
+			return nil, nil, nil
+		}
+		return []*ipam.IPAllocation{loc.Allocation}, nil, nil
+	}
+	within, partial, err := store.AllocationsWithin(pebbleStore, networkID, first, last)
+	if err == nil && len(within)+len(partial) == 0 {
+		err = checkReleased(networkID, first.String())
+	}
+	return append(within, partial...), nil, err
+}
+
+// checkReleased returns ErrAlreadyReleased, naming when and by whom, if ip
+// was allocated in networkID and has since been released
+func checkReleased(networkID, ip string) error {
+	alloc, err := store.LastReleased(pebbleStore, networkID, ip)
+	if err != nil || alloc == nil {
+		return err
+	}
+	return store.CheckReleasable(pebbleStore, alloc)
+}
+
+// releaseWithHooks releases ip in networkID, running chain around the
+// release and holding the address for the reuse delay of the network when
+// the address has an active allocation
+func releaseWithHooks(ctx context.Context, chain hooks.Chain, networkID, ip string) error {
+	alloc, err := pebbleStore.GetAllocationByIP(networkID, ip)
+	if err != nil || alloc.ReleasedAt != nil {
+		return ipamClient.ReleaseIP(networkID, ip)
+	}
+	return chain.Release(ctx, pebbleStore, alloc, func() error {
+		return releaseQuarantined(ipamClient, pebbleStore, alloc, cliAuditUser)
+	})
+}
+
+// releaseQuarantined releases alloc through client and holds its addresses
+// for the reuse delay of its network, if it has one, auditing the hold as
+// user
+func releaseQuarantined(client *ipam.IPAM, st ipam.Store, alloc *ipam.IPAllocation, user string) error {
+	now := time.Now()
+	hold, err := store.ReleaseQuarantined(st, alloc, now, func() error {
+		return client.ReleaseIP(alloc.NetworkID, alloc.IP)
+	})
+	if err != nil || hold == nil {
+		return err
+	}
+	return store.RecordAudit(st, user, "ip_held", hold.ID, quarantineDetails(hold), now)
+}
+
+// quarantineDetails describes hold, the reuse delay of a released address,
+// in the audit log
+func quarantineDetails(hold *ipam.IPAllocation) string {
+	return fmt.Sprintf("Held %s for the reuse delay until %s", hold.IP, hold.ExpiresAt.UTC().Format(time.RFC3339))
+}
+
 func init() {
 	releaseCmd.Flags().StringP("network-id", "n", "", "Network ID (optional, will auto-detect)")
 }
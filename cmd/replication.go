@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/gossip"
+	"github.com/jeremyhahn/go-ipam/pkg/replication"
+	"github.com/spf13/cobra"
+)
+
+var replicationCmd = &cobra.Command{
+	Use:   "replication",
+	Short: "Inspect and promote a standby server",
+	Long: `A server started with --replicate-from is a read-only standby that pulls
+the primary's networks and allocations, e.g. in another region. When the
+primary is lost, promote the standby to take over writes.`,
+}
+
+var replicationStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show a standby's replication status",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := newReplicationClient(cmd)
+		if err != nil {
+			return err
+		}
+		var status replication.Status
+		if err := c.do(http.MethodGet, c.server, "/api/v1/replication", &status); err != nil {
+			return err
+		}
+		printReplicationStatus(cmd.OutOrStdout(), c.server, &status)
+		return nil
+	},
+}
+
+var replicationPromoteCmd = &cobra.Command{
+	Use:   "promote",
+	Short: "Promote a standby so it accepts writes",
+	Long: `Stop a standby replicating and let it accept writes, for disaster recovery
+when the primary is lost. The promotion is permanent: a promoted server no
+longer follows its primary, even after a restart.
+
+When the standby is a cluster whose nodes gossip (--gossip-addr), every node
+it knows of is promoted; otherwise promote each node with --server.
+
+Make sure the old primary stays down or is fenced off first, so clients do
+not write to both.`,
+	Example: `  ipam replication promote --server https://ipam.eu-west.example.com:8443`,
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := newReplicationClient(cmd)
+		if err != nil {
+			return err
+		}
+		out := cmd.OutOrStdout()
+
+		servers := []string{c.server}
+		// The cluster endpoints exist only in cluster mode
+		var cluster struct {
+			Members []gossip.Member `json:"members"`
+		}
+		if c.do(http.MethodGet, c.server, "/api/v1/cluster/status", &cluster) == nil {
+			for _, m := range cluster.Members {
+				if m.State == gossip.StateAlive && strings.TrimSuffix(m.APIAddr, "/") != c.server {
+					servers = append(servers, strings.TrimSuffix(m.APIAddr, "/"))
+				}
+			}
+		}
+
+		var failed int
+		for _, server := range servers {
+			var status replication.Status
+			if err := c.do(http.MethodPost, server, "/api/v1/replication/promote", &status); err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Failed to promote %s: %v\n", server, err)
+				failed++
+				continue
+			}
+			fmt.Fprintf(out, "Promoted %s at %s; it no longer replicates from %s\n",
+				server, status.PromotedAt.Local().Format(time.RFC3339), status.Primary)
+		}
+		if failed > 0 {
+			return fmt.Errorf("failed to promote %d of %d servers", failed, len(servers))
+		}
+		return nil
+	},
+}
+
+// replicationClient calls the replication endpoints of a server
+type replicationClient struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+func newReplicationClient(cmd *cobra.Command) (*replicationClient, error) {
+	server, _ := cmd.Flags().GetString("server")
+	token, _ := cmd.Flags().GetString("token")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	if server == "" {
+		return nil, withExitCode(ExitValidation, fmt.Errorf("--server must be specified"))
+	}
+	if token == "" {
+		token = os.Getenv("IPAM_TOKEN")
+	}
+	return &replicationClient{
+		server: strings.TrimSuffix(server, "/"),
+		token:  token,
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// do sends a request without a body to server and decodes the JSON response
+// into out, turning API errors into their message
+func (c *replicationClient) do(method, server, path string, out interface{}) error {
+	req, err := http.NewRequest(method, server+path, nil)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("%s %s: %s", method, path, apiErr.Message)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// printReplicationStatus prints a standby's status for people
+func printReplicationStatus(out io.Writer, server string, s *replication.Status) {
+	when := func(t *time.Time) string {
+		if t == nil {
+			return "never"
+		}
+		return fmt.Sprintf("%s (%s ago)", t.Local().Format(time.RFC3339), time.Since(*t).Round(time.Second))
+	}
+
+	fmt.Fprintf(out, "Server:      %s\n", server)
+	fmt.Fprintf(out, "Primary:     %s\n", s.Primary)
+	switch {
+	case s.Standby && s.ReadOnly:
+		fmt.Fprintf(out, "Role:        read-only replica (cannot be promoted)\n")
+	case s.Standby:
+		fmt.Fprintf(out, "Role:        standby (read-only)\n")
+	default:
+		fmt.Fprintf(out, "Role:        promoted at %s\n", s.PromotedAt.Local().Format(time.RFC3339))
+	}
+	fmt.Fprintf(out, "Last sync:   %s\n", when(s.LastSync))
+	if s.LastError != "" {
+		fmt.Fprintf(out, "Last error:  %s (at %s)\n", s.LastError, when(s.LastAttempt))
+	}
+	fmt.Fprintf(out, "Replicated:  %d networks, %d allocations\n", s.Networks, s.Allocations)
+}
+
+func init() {
+	for _, c := range []*cobra.Command{replicationStatusCmd, replicationPromoteCmd} {
+		c.Flags().String("server", "", "Standby server URL, e.g. https://ipam.eu-west.example.com:8443")
+		c.Flags().String("token", "", "API token, if the server requires one (default $IPAM_TOKEN)")
+		c.Flags().Duration("timeout", 30*time.Second, "HTTP request timeout")
+		replicationCmd.AddCommand(c)
+	}
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/reclaim"
+	"github.com/jeremyhahn/go-ipam/pkg/report"
+	"github.com/spf13/cobra"
+)
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Generate an HTML or PDF utilization report",
+	Long: `Generate a utilization and allocation report for management reviews:
+totals and utilization per site, tenant or other label, the monthly trend of
+allocations, and when growing networks are expected to run out of addresses.
+
+The report is rendered from a built-in template; --template replaces it with
+an html/template (HTML) or a text/template whose output is laid out on A4
+pages in a fixed-width font (PDF).`,
+	Example: `  ipam report --format html --out report.html
+  ipam report --out report.pdf --group-by site,env --months 12`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		output, _ := cmd.Flags().GetString("out")
+		templatePath, _ := cmd.Flags().GetString("template")
+		groupBy, _ := cmd.Flags().GetStringSlice("group-by")
+		top, _ := cmd.Flags().GetInt("top")
+		months, _ := cmd.Flags().GetInt("months")
+		windowStr, _ := cmd.Flags().GetString("window")
+		title, _ := cmd.Flags().GetString("title")
+
+		// The output's extension picks the format unless given
+		if !cmd.Flags().Changed("format") && strings.EqualFold(filepath.Ext(output), ".pdf") {
+			format = report.FormatPDF
+		}
+		if !slices.Contains(report.Formats, format) {
+			return withExitCode(ExitValidation, fmt.Errorf("--format must be %s", strings.Join(report.Formats, " or ")))
+		}
+		window, err := reclaim.ParseDuration(windowStr)
+		if err != nil {
+			return withExitCode(ExitValidation, fmt.Errorf("--window: %w", err))
+		}
+		if top < 1 || months < 1 {
+			return withExitCode(ExitValidation, fmt.Errorf("--top and --months must be at least 1"))
+		}
+
+		var custom string
+		if templatePath != "" {
+			data, err := os.ReadFile(templatePath)
+			if err != nil {
+				return fmt.Errorf("failed to read template: %w", err)
+			}
+			custom = string(data)
+		}
+
+		networks, err := pebbleStore.ListNetworks()
+		if err != nil {
+			return fmt.Errorf("failed to list networks: %w", err)
+		}
+		opts := report.DocumentOptions{
+			Options: report.Options{GroupBy: groupBy, Top: top},
+			Title:   title,
+			Months:  months,
+			Window:  window,
+		}
+		doc, err := report.BuildDocument(pebbleStore, ipamClient, networks, opts, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to build report: %w", err)
+		}
+
+		var out io.Writer = cmd.OutOrStdout()
+		if output != "" {
+			f, err := os.Create(output)
+			if err != nil {
+				return fmt.Errorf("failed to create report: %w", err)
+			}
+			defer f.Close()
+			out = f
+		}
+		if err := report.Render(out, format, doc, custom); err != nil {
+			return fmt.Errorf("failed to render report: %w", err)
+		}
+
+		if output != "" {
+			fmt.Fprintf(cmd.OutOrStdout(), "Wrote %s report on %d networks to %s\n",
+				strings.ToUpper(format), doc.Capacity.Totals.Networks, output)
+		}
+		return nil
+	},
+}
+
+func init() {
+	reportCmd.Flags().String("format", report.FormatHTML, "Report format: html or pdf (pdf when --out ends in .pdf)")
+	reportCmd.Flags().StringP("out", "o", "", "Write the report to a file instead of stdout")
+	reportCmd.Flags().String("template", "", "Render with this template instead of the built-in one")
+	reportCmd.Flags().StringSlice("group-by", report.DefaultGroupBy, "Label keys to break utilization down by")
+	reportCmd.Flags().Int("top", report.DefaultTop, "Number of fullest networks to list")
+	reportCmd.Flags().Int("months", report.DefaultMonths, "Months of allocation trend")
+	reportCmd.Flags().String("window", "90d", "Period of growth exhaustion forecasts are based on")
+	reportCmd.Flags().String("title", report.DefaultTitle, "Report title")
+}
@@ -1,9 +1,11 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/jeremyhahn/go-ipam/pkg/ipam"
 	"github.com/jeremyhahn/go-ipam/pkg/store"
@@ -15,25 +17,75 @@ var (
 	ipamClient  *ipam.IPAM
 	pebbleStore *store.PebbleStore
 	ipamStore   ipam.Store // Generic store interface for cluster mode
+	storePath   string     // Directory pebbleStore was opened from
+
+	offline     bool
+	cachePath   string
+	cacheMaxAge time.Duration
+
+	lockTimeout time.Duration // How long to wait for another process to release the database
 )
 
+// lockRetryInterval is how often a locked database is retried
+const lockRetryInterval = 100 * time.Millisecond
+
+// cliAuditUser is the user recorded for changes the CLI makes to the store
+// directly, outside the allocator
+const cliAuditUser = "cli"
+
 var rootCmd = &cobra.Command{
 	Use:   "ipam",
 	Short: "IP Address Management CLI",
 	Long:  `A CLI tool for managing IP address allocations across IPv4 and IPv6 networks.`,
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// A server config file may set --db and --cluster
+		if cmd == serverCmd {
+			if err := applyServerConfig(cmd); err != nil {
+				return err
+			}
+		}
+
+		if discover {
+			if err := discoverServers(cmd); err != nil {
+				return err
+			}
+		}
+
 		// Skip initialization for cluster commands and server in cluster mode
 		if cmd.Name() == "cluster" || (cmd.Name() == "server" && clusterMode) {
 			return nil
 		}
 
+		// cache sync and migrate open their stores themselves, and loadtest,
+		// replication, healthcheck and audit verify --server only talk to a
+		// server
+		if cmd.Parent() == cacheCmd || cmd == migrateCmd || cmd == loadtestCmd || cmd.Parent() == replicationCmd || cmd == healthcheckCmd ||
+			(cmd == auditVerifyCmd && cmd.Flags().Changed("server")) {
+			return nil
+		}
+
+		path := dbPath
+		if offline {
+			var err error
+			if path, err = openOfflineCache(cmd); err != nil {
+				return err
+			}
+		}
+
+		// Reopen if switching between the database and the offline cache
+		if pebbleStore != nil && storePath != path {
+			pebbleStore.Close()
+			pebbleStore = nil
+		}
+
 		// Only create a new store if we don't have one
 		if pebbleStore == nil {
 			var err error
-			pebbleStore, err = store.NewPebbleStore(dbPath)
+			pebbleStore, err = openPebbleStore(path)
 			if err != nil {
-				return fmt.Errorf("failed to initialize store: %w", err)
+				return err
 			}
+			storePath = path
 			ipamStore = pebbleStore
 			ipamClient = ipam.New(ipamStore)
 		}
@@ -47,7 +99,34 @@ var rootCmd = &cobra.Command{
 	},
 }
 
+// openPebbleStore opens the database at path. Only one process can have it
+// open, so when another ipam command or a server holds it, retry for up to
+// --lock-timeout before failing with advice.
+func openPebbleStore(path string) (*store.PebbleStore, error) {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		s, err := store.NewPebbleStore(path)
+		switch {
+		case err == nil:
+			return s, nil
+		case !errors.Is(err, store.ErrLocked):
+			return nil, fmt.Errorf("failed to initialize store: %w", err)
+		case time.Now().After(deadline):
+			hint := "retry with --lock-timeout 30s to wait for it"
+			if lockTimeout > 0 {
+				hint = fmt.Sprintf("still locked after waiting %s", lockTimeout)
+			}
+			return nil, fmt.Errorf("%w (%s). It may be a running \"ipam server\"; "+
+				"send requests to its API instead, or run commands one at a time", err, hint)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// Execute runs the root command. Use ExitCode to map the returned error to
+// a process exit status.
 func Execute() error {
+	markArgErrors(rootCmd)
 	return rootCmd.Execute()
 }
 
@@ -62,7 +141,13 @@ func isTestMode() bool {
 }
 
 func init() {
-	rootCmd.PersistentFlags().StringVar(&dbPath, "db", "ipam-data", "Path to database directory")
+	rootCmd.PersistentFlags().StringVar(&dbPath, "db", defaultDir("ipam-data", os.UserConfigDir, "data"), "Path to database directory")
+	rootCmd.PersistentFlags().BoolVar(&offline, "offline", false, "Read from the offline cache instead of the database")
+	rootCmd.PersistentFlags().StringVar(&cachePath, "cache", defaultDir("ipam-cache", os.UserCacheDir, "cache"), "Path to offline cache directory")
+	rootCmd.PersistentFlags().DurationVar(&cacheMaxAge, "cache-max-age", 24*time.Hour, "Warn when the offline cache is older than this")
+	rootCmd.PersistentFlags().DurationVar(&lockTimeout, "lock-timeout", 0, "Wait this long for another process using the database to finish (0 fails immediately)")
+	rootCmd.PersistentFlags().BoolVar(&discover, "discover", false, "Find the server with mDNS on the local network when --server is not given")
+	rootCmd.PersistentFlags().DurationVar(&discoverTimeout, "discover-timeout", 2*time.Second, "How long --discover waits for servers to answer")
 
 	// Add subcommands
 	rootCmd.AddCommand(networkCmd)
@@ -70,6 +155,29 @@ func init() {
 	rootCmd.AddCommand(releaseCmd)
 	rootCmd.AddCommand(listCmd)
 	rootCmd.AddCommand(statsCmd)
+	rootCmd.AddCommand(reportCmd)
+	rootCmd.AddCommand(reconcileCmd)
+	rootCmd.AddCommand(neighborsCmd)
+	rootCmd.AddCommand(neutronCmd)
+	rootCmd.AddCommand(vmsCmd)
+	rootCmd.AddCommand(keaCmd)
+	rootCmd.AddCommand(powerdnsCmd)
+	rootCmd.AddCommand(transferCmd)
+	rootCmd.AddCommand(groupCmd)
+	rootCmd.AddCommand(whoisCmd)
+	rootCmd.AddCommand(locateCmd)
+	rootCmd.AddCommand(cacheCmd)
+	rootCmd.AddCommand(applyCmd)
+	rootCmd.AddCommand(planCmd)
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(diffCmd)
+	rootCmd.AddCommand(auditCmd)
+	rootCmd.AddCommand(healthCmd)
+	rootCmd.AddCommand(reclaimCmd)
+	rootCmd.AddCommand(loadtestCmd)
+	rootCmd.AddCommand(replicationCmd)
 	rootCmd.AddCommand(serverCmd)
+	rootCmd.AddCommand(healthcheckCmd)
 	rootCmd.AddCommand(clusterCmd)
 }
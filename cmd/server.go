@@ -1,19 +1,48 @@
 package cmd
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/jeremyhahn/go-ipam/api"
+	"github.com/jeremyhahn/go-ipam/pkg/approval"
+	"github.com/jeremyhahn/go-ipam/pkg/auditlog"
+	"github.com/jeremyhahn/go-ipam/pkg/cdc"
 	"github.com/jeremyhahn/go-ipam/pkg/config"
+	"github.com/jeremyhahn/go-ipam/pkg/daemon"
+	"github.com/jeremyhahn/go-ipam/pkg/geoip"
+	"github.com/jeremyhahn/go-ipam/pkg/gossip"
+	"github.com/jeremyhahn/go-ipam/pkg/health"
+	"github.com/jeremyhahn/go-ipam/pkg/hooks"
 	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/kea"
+	"github.com/jeremyhahn/go-ipam/pkg/mdns"
+	"github.com/jeremyhahn/go-ipam/pkg/notify"
+	"github.com/jeremyhahn/go-ipam/pkg/powerdns"
+	"github.com/jeremyhahn/go-ipam/pkg/reclaim"
+	"github.com/jeremyhahn/go-ipam/pkg/replication"
+	"github.com/jeremyhahn/go-ipam/pkg/snapshot"
+	"github.com/jeremyhahn/go-ipam/pkg/snmp"
+	"github.com/jeremyhahn/go-ipam/pkg/spiffe"
 	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/jeremyhahn/go-ipam/pkg/taxonomy"
+	"github.com/jeremyhahn/go-ipam/pkg/webhook"
 	"github.com/spf13/cobra"
 )
 
@@ -21,6 +50,10 @@ var (
 	configFile string
 )
 
+// shutdownTimeout bounds how long in-flight requests may take to finish
+// after SIGINT or SIGTERM
+const shutdownTimeout = 30 * time.Second
+
 var serverCmd = &cobra.Command{
 	Use:   "server",
 	Short: "Start the IPAM API server",
@@ -39,43 +72,759 @@ var serverCmd = &cobra.Command{
 			}
 		}
 
+		opts, err := serverOptionsFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+
 		// Check if running in cluster mode
 		if clusterMode {
-			return runClusterServer(host, port)
+			return runClusterServer(host, port, opts)
 		}
 
 		// Standard mode - use PebbleDB
-		return runStandardServer(host, port)
+		return runStandardServer(host, port, opts)
 	},
 }
 
-func runStandardServer(host string, port int) error {
-	// Initialize API server with PebbleDB store
-	server := api.NewServer(ipamClient, pebbleStore)
+// serverOptions are the optional server features shared by both modes
+type serverOptions struct {
+	// anchorKey, when set, signs the head of the audit log every
+	// anchorInterval
+	anchorKey      ed25519.PrivateKey
+	anchorInterval time.Duration
 
-	addr := fmt.Sprintf("%s:%d", host, port)
-	fmt.Printf("Starting IPAM server (standalone mode) on %s\n", addr)
-	fmt.Printf("API available at: http://%s/api/v1\n", addr)
+	// auditSync writes each audit entry before responding; otherwise up to
+	// auditQueueSize entries wait to be written in the background
+	auditSync      bool
+	auditQueueSize int
 
-	log.Fatal(http.ListenAndServe(addr, server))
-	return nil
+	// audit is the background audit writer, see startAuditWriter
+	audit *auditlog.Writer
+
+	// approvals are the webhooks networks can require allocation approval from
+	approvals *approval.Webhooks
+
+	// healthProber, when set, probes allocated addresses every
+	// healthInterval and tags them stale after healthStaleAfter of silence
+	healthProber     health.Prober
+	healthInterval   time.Duration
+	healthStaleAfter time.Duration
+
+	// reclaimInterval, when set, applies the networks' reclamation
+	// policies that often, posting events to reclaimNotifier
+	reclaimInterval time.Duration
+	reclaimNotifier reclaim.Notifier
+
+	// notifier holds the channels networks tagged notify=<name> subscribe
+	// to; their utilization is checked every notifyInterval
+	notifier       *notify.Notifier
+	notifyInterval time.Duration
+	expiryWarning  time.Duration
+
+	// cdc, when set, mirrors networks and allocations into an external
+	// database every cdcInterval
+	cdc         *cdc.Sink
+	cdcInterval time.Duration
+
+	// kea, when set, pushes the reservations of allocations with a MAC
+	// into Kea every keaInterval
+	kea         *kea.Syncer
+	keaInterval time.Duration
+
+	// powerdns, when set, pushes the records of allocations with a
+	// hostname into PowerDNS every powerdnsInterval
+	powerdns         *powerdns.Syncer
+	powerdnsInterval time.Duration
+
+	// geoip, when set, tags public networks and allocations with their
+	// country and AS every geoipInterval
+	geoip         *geoip.DB
+	geoipInterval time.Duration
+
+	// snmp, when set, answers SNMP polls for network utilization on
+	// snmpConn
+	snmp     *snmp.Agent
+	snmpConn net.PacketConn
+
+	// mdns announces the API on the local network as mdnsName, the host
+	// name when empty
+	mdns     bool
+	mdnsName string
+
+	// leader, in cluster mode, reports whether this node leads the
+	// cluster, so that work writing outside it runs on one node only
+	leader func() bool
+
+	// tagRegistry, when set, lists the allowed tags, enforced or not
+	tagRegistry *taxonomy.Registry
+
+	// autoCreateNetworks creates the networks allocations name by CIDR
+	// when they are not registered
+	autoCreateNetworks bool
+
+	// tlsCert and tlsKey, when set, serve HTTPS
+	tlsCert string
+	tlsKey  string
+
+	// authTokens, when set, are the bearer tokens API clients must send
+	authTokens []string
+
+	// spiffeBundle, when set, verifies client X.509 SVIDs, which are
+	// accepted instead of tokens when spiffeIDs maps their SPIFFE ID
+	spiffeBundle *x509.CertPool
+	spiffeIDs    *spiffe.Mapper
+
+	// adminPrincipals may name the end user they act for with
+	// X-On-Behalf-Of
+	adminPrincipals []string
+
+	// accessLog logs every request
+	accessLog bool
+
+	// backupDir, when set, receives a JSON export every backupInterval,
+	// keeping the newest backupKeep
+	backupDir      string
+	backupInterval time.Duration
+	backupKeep     int
+
+	// replicateFrom, when set, makes the server a standby that pulls the
+	// primary at this URL every replicateInterval until promoted
+	replicateFrom     string
+	replicateInterval time.Duration
+	replicateToken    string
+
+	// replicateReadOnly refuses promotion, and replicateMaxAge reports the
+	// standby stale in /health when it last synced longer ago
+	replicateReadOnly bool
+	replicateMaxAge   time.Duration
+
+	// pidFile, when set, holds the server's process ID while it runs
+	pidFile string
+
+	// unixSocket, when set, is a Unix domain socket served in addition to
+	// TCP, with unixSocketMode permissions and owned by unixSocketGroup
+	unixSocket      string
+	unixSocketMode  os.FileMode
+	unixSocketGroup string
+
+	// limits bound slow and oversized requests
+	readHeaderTimeout time.Duration
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	maxBodySize       int64
 }
 
-func runClusterServer(host string, port int) error {
-	// Load cluster configuration
-	if configFile == "" {
-		// Try default location
-		configFile = filepath.Join("ipam-cluster-data", "cluster.json")
+func serverOptionsFromFlags(cmd *cobra.Command) (serverOptions, error) {
+	var opts serverOptions
+
+	keyPath, _ := cmd.Flags().GetString("audit-key")
+	interval, _ := cmd.Flags().GetDuration("audit-anchor-interval")
+	if keyPath != "" {
+		if interval <= 0 {
+			return opts, withExitCode(ExitValidation, fmt.Errorf("--audit-anchor-interval must be positive"))
+		}
+		key, err := readSigningKey(keyPath)
+		if err != nil {
+			return opts, err
+		}
+		opts.anchorKey = key
+		opts.anchorInterval = interval
+	}
+
+	opts.auditSync, _ = cmd.Flags().GetBool("audit-sync")
+	opts.auditQueueSize, _ = cmd.Flags().GetInt("audit-queue-size")
+	if opts.auditQueueSize <= 0 {
+		return opts, withExitCode(ExitValidation, fmt.Errorf("--audit-queue-size must be positive"))
 	}
 
-	configData, err := os.ReadFile(configFile)
+	secretFile, _ := cmd.Flags().GetString("webhook-secret-file")
+	signer, err := webhookSigner(secretFile)
 	if err != nil {
-		return fmt.Errorf("failed to read cluster config: %w", err)
+		return opts, err
 	}
 
-	var clusterConfig config.ClusterConfig
-	if err := json.Unmarshal(configData, &clusterConfig); err != nil {
-		return fmt.Errorf("failed to parse cluster config: %w", err)
+	webhooks, _ := cmd.Flags().GetStringArray("approval-webhook")
+	approvals, err := approval.ParseWebhooks(webhooks)
+	if err != nil {
+		return opts, withExitCode(ExitValidation, err)
+	}
+	approvals.Signer = signer
+	opts.approvals = approvals
+
+	healthInterval, _ := cmd.Flags().GetDuration("health-interval")
+	if healthInterval > 0 {
+		ports, _ := cmd.Flags().GetString("health-ports")
+		parsed, err := parsePorts(ports)
+		if err != nil {
+			return opts, withExitCode(ExitValidation, fmt.Errorf("--health-ports: %w", err))
+		}
+		opts.healthProber = &health.TCPProber{Ports: parsed, Timeout: time.Second}
+		opts.healthInterval = healthInterval
+		opts.healthStaleAfter, _ = cmd.Flags().GetDuration("health-stale-after")
+	}
+
+	opts.reclaimInterval, _ = cmd.Flags().GetDuration("reclaim-interval")
+	webhookURL, _ := cmd.Flags().GetString("reclaim-webhook")
+	if opts.reclaimNotifier, err = reclaimNotifier(webhookURL, signer); err != nil {
+		return opts, withExitCode(ExitValidation, fmt.Errorf("--reclaim-webhook: %w", err))
+	}
+
+	channels, _ := cmd.Flags().GetStringArray("notify")
+	if opts.notifier, err = notify.Parse(channels); err != nil {
+		return opts, withExitCode(ExitValidation, fmt.Errorf("--notify: %w", err))
+	}
+	opts.notifier.SetSigner(signer)
+	opts.notifyInterval, _ = cmd.Flags().GetDuration("notify-interval")
+
+	if paths, _ := cmd.Flags().GetStringArray("geoip-db"); len(paths) > 0 {
+		opts.geoipInterval, _ = cmd.Flags().GetDuration("geoip-interval")
+		if opts.geoipInterval <= 0 {
+			return opts, withExitCode(ExitValidation, fmt.Errorf("--geoip-interval must be positive"))
+		}
+		db, err := geoip.Open(paths...)
+		if err != nil {
+			return opts, withExitCode(ExitValidation, fmt.Errorf("--geoip-db: %w", err))
+		}
+		opts.geoip = db
+	}
+
+	if listen, _ := cmd.Flags().GetString("snmp-listen"); listen != "" {
+		communityFile, _ := cmd.Flags().GetString("snmp-community-file")
+		if communityFile == "" {
+			return opts, withExitCode(ExitValidation, fmt.Errorf("--snmp-listen requires --snmp-community-file"))
+		}
+		communities, err := readTokens(communityFile)
+		if err != nil {
+			return opts, fmt.Errorf("snmp community: %w", err)
+		}
+		oid, _ := cmd.Flags().GetString("snmp-oid")
+		base, err := snmp.ParseOID(oid)
+		if err != nil {
+			return opts, withExitCode(ExitValidation, fmt.Errorf("--snmp-oid: %w", err))
+		}
+		if opts.snmpConn, err = net.ListenPacket("udp", listen); err != nil {
+			return opts, fmt.Errorf("failed to listen on %s: %w", listen, err)
+		}
+		opts.snmp = &snmp.Agent{Community: communities[0], Base: base, MaxAge: snmp.DefaultMaxAge}
+	}
+
+	if dsn, _ := cmd.Flags().GetString("cdc-dsn"); dsn != "" {
+		table, _ := cmd.Flags().GetString("cdc-table")
+		if err := cdc.ValidateTable(table); err != nil {
+			return opts, withExitCode(ExitValidation, fmt.Errorf("--cdc-table: %w", err))
+		}
+		opts.cdcInterval, _ = cmd.Flags().GetDuration("cdc-interval")
+		if opts.cdcInterval <= 0 {
+			return opts, withExitCode(ExitValidation, fmt.Errorf("--cdc-interval must be positive"))
+		}
+		db, dialect, err := cdc.Open(dsn)
+		if err != nil {
+			return opts, withExitCode(ExitValidation, fmt.Errorf("--cdc-dsn: %w", err))
+		}
+		opts.cdc = &cdc.Sink{DB: db, Dialect: dialect, Table: table}
+	}
+
+	if opts.kea, err = keaSyncerFromFlags(cmd, "kea-"); err != nil {
+		return opts, err
+	}
+	if opts.kea != nil {
+		opts.keaInterval, _ = cmd.Flags().GetDuration("kea-interval")
+		if opts.keaInterval <= 0 {
+			return opts, withExitCode(ExitValidation, fmt.Errorf("--kea-interval must be positive"))
+		}
+	}
+
+	if opts.powerdns, err = powerdnsSyncerFromFlags(cmd, "powerdns-"); err != nil {
+		return opts, err
+	}
+	if opts.powerdns != nil {
+		opts.powerdnsInterval, _ = cmd.Flags().GetDuration("powerdns-interval")
+		if opts.powerdnsInterval <= 0 {
+			return opts, withExitCode(ExitValidation, fmt.Errorf("--powerdns-interval must be positive"))
+		}
+	}
+	opts.expiryWarning, _ = cmd.Flags().GetDuration("expiry-warning")
+
+	opts.mdns, _ = cmd.Flags().GetBool("mdns")
+	opts.mdnsName, _ = cmd.Flags().GetString("mdns-name")
+	if opts.mdnsName != "" && !opts.mdns {
+		return opts, withExitCode(ExitValidation, fmt.Errorf("--mdns-name requires --mdns"))
+	}
+
+	registry, _ := cmd.Flags().GetString("tag-registry")
+	enforce, _ := cmd.Flags().GetBool("enforce-tags")
+	if registry != "" {
+		if opts.tagRegistry, err = taxonomy.LoadRegistry(registry); err != nil {
+			return opts, withExitCode(ExitValidation, fmt.Errorf("--tag-registry: %w", err))
+		}
+		opts.tagRegistry.Enforce = enforce
+	} else if enforce {
+		return opts, withExitCode(ExitValidation, fmt.Errorf("--enforce-tags requires --tag-registry"))
+	}
+	opts.autoCreateNetworks, _ = cmd.Flags().GetBool("auto-create-networks")
+
+	opts.tlsCert, _ = cmd.Flags().GetString("tls-cert")
+	opts.tlsKey, _ = cmd.Flags().GetString("tls-key")
+	if (opts.tlsCert == "") != (opts.tlsKey == "") {
+		return opts, withExitCode(ExitValidation, fmt.Errorf("--tls-cert and --tls-key must be given together"))
+	}
+
+	if tokenFile, _ := cmd.Flags().GetString("auth-token-file"); tokenFile != "" {
+		if opts.authTokens, err = readTokens(tokenFile); err != nil {
+			return opts, err
+		}
+	}
+
+	opts.adminPrincipals, _ = cmd.Flags().GetStringArray("admin-principal")
+
+	bundle, _ := cmd.Flags().GetString("spiffe-bundle")
+	ids, _ := cmd.Flags().GetStringArray("spiffe-id")
+	switch {
+	case bundle == "" && len(ids) > 0:
+		return opts, withExitCode(ExitValidation, fmt.Errorf("--spiffe-id requires --spiffe-bundle"))
+	case bundle != "" && len(ids) == 0:
+		return opts, withExitCode(ExitValidation, fmt.Errorf("--spiffe-bundle requires at least one --spiffe-id"))
+	case bundle != "" && opts.tlsCert == "":
+		return opts, withExitCode(ExitValidation, fmt.Errorf("--spiffe-bundle requires --tls-cert and --tls-key"))
+	case bundle != "":
+		if opts.spiffeBundle, err = spiffe.LoadBundle(bundle); err != nil {
+			return opts, withExitCode(ExitValidation, err)
+		}
+		if opts.spiffeIDs, err = spiffe.ParseMappings(ids); err != nil {
+			return opts, withExitCode(ExitValidation, fmt.Errorf("--spiffe-id: %w", err))
+		}
+	}
+
+	if logFile, _ := cmd.Flags().GetString("log-file"); logFile != "" {
+		f, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+		if err != nil {
+			return opts, fmt.Errorf("failed to open log file: %w", err)
+		}
+		log.SetOutput(f)
+	}
+	opts.accessLog, _ = cmd.Flags().GetBool("access-log")
+
+	opts.pidFile, _ = cmd.Flags().GetString("pid-file")
+
+	opts.unixSocket, _ = cmd.Flags().GetString("unix-socket")
+	opts.unixSocketGroup, _ = cmd.Flags().GetString("unix-socket-group")
+	modeFlag, _ := cmd.Flags().GetString("unix-socket-mode")
+	mode, err := config.ParseFileMode(modeFlag)
+	if err != nil {
+		return opts, withExitCode(ExitValidation, fmt.Errorf("invalid --unix-socket-mode: %w", err))
+	}
+	opts.unixSocketMode = mode
+
+	opts.readHeaderTimeout, _ = cmd.Flags().GetDuration("read-header-timeout")
+	opts.readTimeout, _ = cmd.Flags().GetDuration("read-timeout")
+	opts.writeTimeout, _ = cmd.Flags().GetDuration("write-timeout")
+	opts.idleTimeout, _ = cmd.Flags().GetDuration("idle-timeout")
+	sizeFlag, _ := cmd.Flags().GetString("max-body-size")
+	if opts.maxBodySize, err = config.ParseSize(sizeFlag); err != nil {
+		return opts, withExitCode(ExitValidation, fmt.Errorf("invalid --max-body-size: %w", err))
+	}
+
+	opts.backupDir, _ = cmd.Flags().GetString("backup-dir")
+	opts.backupInterval, _ = cmd.Flags().GetDuration("backup-interval")
+	opts.backupKeep, _ = cmd.Flags().GetInt("backup-keep")
+	if opts.backupDir != "" && opts.backupInterval <= 0 {
+		return opts, withExitCode(ExitValidation, fmt.Errorf("--backup-interval must be positive"))
+	}
+
+	opts.replicateFrom, _ = cmd.Flags().GetString("replicate-from")
+	opts.replicateInterval, _ = cmd.Flags().GetDuration("replicate-interval")
+	if opts.replicateFrom != "" {
+		if u, err := url.Parse(opts.replicateFrom); err != nil || u.Host == "" {
+			return opts, withExitCode(ExitValidation, fmt.Errorf("invalid --replicate-from %q", opts.replicateFrom))
+		}
+		if opts.replicateInterval <= 0 {
+			return opts, withExitCode(ExitValidation, fmt.Errorf("--replicate-interval must be positive"))
+		}
+		if tokenFile, _ := cmd.Flags().GetString("replicate-token-file"); tokenFile != "" {
+			tokens, err := readTokens(tokenFile)
+			if err != nil {
+				return opts, err
+			}
+			opts.replicateToken = tokens[0]
+		}
+		opts.replicateReadOnly, _ = cmd.Flags().GetBool("replicate-read-only")
+		opts.replicateMaxAge, _ = cmd.Flags().GetDuration("replicate-max-age")
+		if opts.replicateMaxAge < 0 {
+			return opts, withExitCode(ExitValidation, fmt.Errorf("--replicate-max-age must not be negative"))
+		}
+	}
+
+	return opts, nil
+}
+
+// apply configures server and starts background work against st. On a
+// standby replica the work that writes waits for promotion, as the primary
+// does it meanwhile.
+func (o serverOptions) apply(server *api.Server, client *ipam.IPAM, st ipam.Store, replica *replication.Replica) {
+	server.SetAuditWriter(o.audit)
+	server.SetApprovalWebhooks(o.approvals)
+	server.SetNotifier(o.notifier)
+	server.SetTagRegistry(o.tagRegistry)
+	server.SetAutoCreateNetworks(o.autoCreateNetworks)
+	server.SetAuthTokens(o.authTokens)
+	server.SetSPIFFEIDs(o.spiffeIDs)
+	server.SetAdminPrincipals(o.adminPrincipals)
+	server.SetMaxBodySize(o.maxBodySize)
+	if o.backupDir != "" {
+		fmt.Printf("Backing up to %s every %s\n", o.backupDir, o.backupInterval)
+		go snapshot.RunBackups(st, o.backupDir, o.backupKeep, o.backupInterval, nil)
+	}
+	if o.snmp != nil {
+		// Read-only, so a standby answers polls too
+		fmt.Printf("SNMP agent available at: udp://%s (%s)\n", o.snmpConn.LocalAddr(), o.snmp.Base)
+		o.snmp.Store = st
+		o.snmp.Stats = client.GetNetworkStats
+		go func() {
+			if err := o.snmp.Serve(o.snmpConn); err != nil {
+				log.Printf("snmp: %v", err)
+			}
+		}()
+	}
+
+	if replica == nil {
+		o.start(client, st)
+		return
+	}
+	server.SetReplica(replica)
+	role := "Standby"
+	if replica.ReadOnly {
+		role = "Read-only replica"
+	}
+	fmt.Printf("%s: replicating from %s every %s\n", role, replica.Primary, o.replicateInterval)
+	go replication.Run(replica, o.replicateInterval, nil)
+	go func() {
+		<-replica.Promoted()
+		log.Printf("Promoted; no longer replicating from %s", replica.Primary)
+		o.start(client, st)
+	}()
+}
+
+// startAuditWriter starts writing audit entries to st in the background,
+// unless they are written synchronously, and returns the IPAM client to
+// serve with, one saving its audit entries through the writer. Close
+// o.audit on shutdown to write the entries still queued.
+func (o *serverOptions) startAuditWriter(client *ipam.IPAM, st ipam.Store) *ipam.IPAM {
+	if o.auditSync {
+		return client
+	}
+	o.audit = auditlog.NewWriter(st, o.auditQueueSize)
+	return ipam.New(o.audit)
+}
+
+// start starts the background work that writes to st
+func (o serverOptions) start(client *ipam.IPAM, st ipam.Store) {
+	if o.anchorKey != nil {
+		fmt.Printf("Anchoring the audit log every %s\n", o.anchorInterval)
+		// Anchor through the writer, which flushes before reading the log
+		var auditStore ipam.Store = st
+		if o.audit != nil {
+			auditStore = o.audit
+		}
+		go auditlog.Run(auditStore, o.anchorKey, o.anchorInterval, nil)
+	}
+	if o.healthProber != nil {
+		fmt.Printf("Probing allocated addresses every %s\n", o.healthInterval)
+		go health.Run(st, o.healthProber, o.healthInterval, o.healthStaleAfter, nil)
+	}
+	if o.reclaimInterval > 0 {
+		fmt.Printf("Applying reclamation policies every %s\n", o.reclaimInterval)
+		notifiers := reclaim.Notifiers{notify.Reclaim(o.notifier, st)}
+		if o.reclaimNotifier != nil {
+			notifiers = append(notifiers, o.reclaimNotifier)
+		}
+		r := &reclaim.Reclaimer{Store: st, Release: reclaimRelease(hooks.Registered(), client, st), Notifier: notifiers}
+		go reclaim.Run(r, o.reclaimInterval, nil)
+	}
+	if len(o.notifier.Channels) > 0 && o.notifyInterval > 0 {
+		fmt.Printf("Checking network utilization and expiring allocations every %s\n", o.notifyInterval)
+		w := &notify.Watcher{Store: st, Stats: client.GetNetworkStats, Notifier: o.notifier, ExpiryWarning: o.expiryWarning}
+		go w.Run(o.notifyInterval, nil)
+	}
+	if o.geoip != nil {
+		fmt.Printf("Tagging public networks and allocations with GeoIP data every %s\n", o.geoipInterval)
+		go geoip.Run(&geoip.Annotator{Store: st, Source: o.geoip}, o.geoipInterval, nil)
+	}
+	if o.cdc != nil {
+		fmt.Printf("Mirroring changes to table %s every %s\n", o.cdc.Table, o.cdcInterval)
+		o.cdc.Store = st
+		o.cdc.Active = o.leader
+		go cdc.Run(o.cdc, o.cdcInterval, nil)
+	}
+	if o.kea != nil {
+		fmt.Printf("Pushing DHCP reservations to Kea every %s\n", o.keaInterval)
+		o.kea.Store = st
+		o.kea.Active = o.leader
+		go kea.Run(o.kea, o.keaInterval, nil)
+	}
+	if o.powerdns != nil {
+		fmt.Printf("Pushing DNS records to PowerDNS every %s\n", o.powerdnsInterval)
+		o.powerdns.Store = st
+		o.powerdns.Active = o.leader
+		go powerdns.Run(o.powerdns, o.powerdnsInterval, nil)
+	}
+}
+
+// announce announces the API listening on host:port on the local network
+// with mDNS when --mdns is set, returning a function that withdraws the
+// announcement. A wildcard host announces the addresses of every
+// interface.
+func (o serverOptions) announce(host string, port int) (func(), error) {
+	if !o.mdns {
+		return func() {}, nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("mdns: %w", err)
+	}
+	hostname, _, _ = strings.Cut(hostname, ".")
+	r := &mdns.Responder{Instance: o.mdnsName, Host: hostname, Port: port, Scheme: o.scheme()}
+	if r.Instance == "" {
+		r.Instance = hostname
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && !ip.IsUnspecified() {
+		r.Addrs = []netip.Addr{ip.Unmap()}
+	}
+	stop, err := r.Start()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Announcing the API with mDNS as %q (%s)\n", r.Instance, mdns.ServiceType)
+	return stop, nil
+}
+
+// replica returns the standby replica --replicate-from configures, keeping
+// its state in dir, or nil when the server is a primary. A replica
+// promoted earlier stays promoted.
+func (o serverOptions) replica(st ipam.Store, dir string, active func() bool) *replication.Replica {
+	if o.replicateFrom == "" {
+		return nil
+	}
+	if at, ok := replication.PromotedAt(dir); ok {
+		fmt.Printf("Promoted at %s; not replicating from %s\n", at.Local().Format(time.RFC3339), o.replicateFrom)
+		return nil
+	}
+	return &replication.Replica{
+		Primary:  o.replicateFrom,
+		Token:    o.replicateToken,
+		Store:    st,
+		Client:   &http.Client{Timeout: time.Minute},
+		Active:   active,
+		StateDir: dir,
+		ReadOnly: o.replicateReadOnly,
+		MaxAge:   o.replicateMaxAge,
+	}
+}
+
+// serve serves handler on the sockets passed by systemd socket activation,
+// or on addr, until the server fails or receives SIGINT or SIGTERM. A
+// signal shuts the server down gracefully and returns nil.
+func (o serverOptions) serve(addr string, handler http.Handler) error {
+	if o.accessLog {
+		handler = accessLogHandler(handler)
+	}
+
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: o.readHeaderTimeout,
+		ReadTimeout:       o.readTimeout,
+		WriteTimeout:      o.writeTimeout,
+		IdleTimeout:       o.idleTimeout,
+	}
+	if o.tlsCert != "" {
+		// Load the certificate up front so a bad one fails before readiness
+		cert, err := tls.LoadX509KeyPair(o.tlsCert, o.tlsKey)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		if o.spiffeBundle != nil {
+			// Clients without an SVID may still send a token
+			srv.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			srv.TLSConfig.ClientCAs = o.spiffeBundle
+		}
+	}
+
+	listeners, err := daemon.Listeners()
+	if err != nil {
+		return err
+	}
+	if listeners != nil {
+		for _, ln := range listeners {
+			fmt.Printf("Using socket %s from systemd\n", ln.Addr())
+		}
+	} else {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		listeners = []net.Listener{ln}
+	}
+
+	closeAll := func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}
+
+	// The Unix socket is local, so its file permissions rather than TLS
+	// protect it
+	var unix net.Listener
+	if o.unixSocket != "" {
+		if unix, err = daemon.ListenUnix(o.unixSocket, o.unixSocketMode, o.unixSocketGroup); err != nil {
+			closeAll()
+			return fmt.Errorf("failed to listen on %s: %w", o.unixSocket, err)
+		}
+		fmt.Printf("API available at: unix://%s\n", o.unixSocket)
+	}
+
+	if o.pidFile != "" {
+		if err := daemon.WritePIDFile(o.pidFile); err != nil {
+			closeAll()
+			if unix != nil {
+				unix.Close()
+			}
+			return err
+		}
+		defer daemon.RemovePIDFile(o.pidFile)
+	}
+
+	errs := make(chan error, len(listeners)+1)
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			if srv.TLSConfig != nil {
+				errs <- srv.ServeTLS(ln, "", "")
+			} else {
+				errs <- srv.Serve(ln)
+			}
+		}(ln)
+	}
+	if unix != nil {
+		go func() { errs <- srv.Serve(unix) }()
+	}
+	if err := daemon.Notify("READY=1"); err != nil {
+		log.Print(err)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	select {
+	case err := <-errs:
+		srv.Close()
+		return err
+	case sig := <-stop:
+		log.Printf("Received %s, shutting down", sig)
+		daemon.Notify("STOPPING=1")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return srv.Shutdown(ctx)
+	}
+}
+
+// scheme returns the URL scheme the server is reached with
+func (o serverOptions) scheme() string {
+	if o.tlsCert != "" {
+		return "https"
+	}
+	return "http"
+}
+
+// accessLogHandler logs each request with its status, duration and the
+// correlation ID the API gave it
+func accessLogHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		id := w.Header().Get(api.RequestIDHeader)
+		if id == "" {
+			id = "-"
+		}
+		log.Printf("%s %s %s %d %s %s", r.RemoteAddr, r.Method, r.URL.RequestURI(), rec.status, time.Since(start).Round(time.Microsecond), id)
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// webhookSigner returns a signer using the secret on the first line of
+// path, or nil when path is empty
+func webhookSigner(path string) (*webhook.Signer, error) {
+	if path == "" {
+		return nil, nil
+	}
+	secrets, err := readTokens(path)
+	if err != nil {
+		return nil, fmt.Errorf("webhook secret: %w", err)
+	}
+	return webhook.NewSigner([]byte(secrets[0])), nil
+}
+
+// readTokens reads API tokens, one per line, ignoring blank lines and
+// comments
+func readTokens(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token file: %w", err)
+	}
+	var tokens []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			tokens = append(tokens, line)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, withExitCode(ExitValidation, fmt.Errorf("token file %s has no tokens", path))
+	}
+	return tokens, nil
+}
+
+func runStandardServer(host string, port int, opts serverOptions) error {
+	client := opts.startAuditWriter(ipamClient, pebbleStore)
+	if opts.audit != nil {
+		defer opts.audit.Close()
+	}
+
+	// Initialize API server with PebbleDB store
+	server := api.NewServer(client, pebbleStore)
+	opts.apply(server, client, pebbleStore, opts.replica(pebbleStore, storePath, nil))
+
+	addr := fmt.Sprintf("%s:%d", host, port)
+	fmt.Printf("Starting IPAM server (standalone mode) on %s\n", addr)
+	fmt.Printf("API available at: %s://%s/api/v1\n", opts.scheme(), addr)
+
+	stopAnnouncing, err := opts.announce(host, port)
+	if err != nil {
+		return err
+	}
+	defer stopAnnouncing()
+
+	if err := opts.serve(addr, server); err != nil {
+		log.Fatal(err)
+	}
+	return nil
+}
+
+func runClusterServer(host string, port int, opts serverOptions) error {
+	clusterConfig, err := loadClusterConfig()
+	if err != nil {
+		return err
 	}
 
 	// Override API address if specified
@@ -104,11 +853,50 @@ func runClusterServer(host string, port int) error {
 	}
 	defer raftStore.Close()
 
-	// Create IPAM client with Raft store
-	ipamClient := ipam.New(raftStore)
+	// Create IPAM client with Raft store. Audit entries are written before
+	// the store closes.
+	ipamClient := opts.startAuditWriter(ipam.New(raftStore), raftStore)
+	if opts.audit != nil {
+		defer opts.audit.Close()
+	}
 
 	// Initialize API server with Raft store
 	server := api.NewServer(ipamClient, raftStore)
+	// Only the leader pulls from the primary; Raft copies its writes to the
+	// rest of the standby cluster
+	leader := func() bool {
+		info, err := raftStore.GetClusterInfo()
+		return err == nil && info.HasLeader && info.LeaderID == clusterConfig.NodeID
+	}
+	opts.leader = leader
+	opts.apply(server, ipamClient, raftStore, opts.replica(raftStore, clusterConfig.DataDir, leader))
+
+	// Share API addresses and health with the other nodes
+	var g *gossip.Gossip
+	if clusterConfig.GossipAddr != "" {
+		g, err = gossip.Start(gossip.Config{
+			BindAddr: clusterConfig.GossipAddr,
+			Seeds:    clusterConfig.GossipSeeds,
+			Meta: gossip.Meta{
+				NodeID:   clusterConfig.NodeID,
+				APIAddr:  advertisedAPIURL(clusterConfig, host, port, opts.scheme()),
+				RaftAddr: clusterConfig.RaftAddr,
+			},
+			Ready: func() bool { return raftStore.Ping() == nil },
+			Progress: func() (uint64, bool) {
+				p, err := raftStore.Progress()
+				if err != nil {
+					return 0, false
+				}
+				return p.Applied, p.CaughtUp
+			},
+		})
+		if err != nil {
+			return err
+		}
+		defer g.Close()
+		server.SetGossip(g)
+	}
 
 	// Use the provided address or fall back to configured one
 	addr := fmt.Sprintf("%s:%d", host, port)
@@ -117,12 +905,68 @@ func runClusterServer(host string, port int) error {
 	fmt.Printf("  Cluster ID:  %d\n", clusterConfig.ClusterID)
 	fmt.Printf("  Raft Addr:   %s\n", clusterConfig.RaftAddr)
 	fmt.Printf("  API Addr:    %s\n", addr)
-	fmt.Printf("API available at: http://%s/api/v1\n", addr)
+	if g != nil {
+		fmt.Printf("  Gossip Addr: %s\n", g.Addr())
+	}
+	fmt.Printf("API available at: %s://%s/api/v1\n", opts.scheme(), addr)
 
-	log.Fatal(http.ListenAndServe(addr, server))
+	stopAnnouncing, err := opts.announce(host, port)
+	if err != nil {
+		return err
+	}
+	defer stopAnnouncing()
+
+	if err := opts.serve(addr, server); err != nil {
+		log.Fatal(err)
+	}
 	return nil
 }
 
+// advertisedAPIURL returns the URL other nodes and clients reach this
+// node's API at: the configured one, or the listen address with a wildcard
+// host replaced by the Raft host
+func advertisedAPIURL(c *config.ClusterConfig, host string, port int, scheme string) string {
+	if c.APIURL != "" {
+		return c.APIURL
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host, _, _ = net.SplitHostPort(c.RaftAddr)
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+// clusterConfigPath returns the cluster configuration file loadClusterConfig
+// reads
+func clusterConfigPath() string {
+	if configFile != "" {
+		return configFile
+	}
+	return filepath.Join("ipam-cluster-data", "cluster.json")
+}
+
+// loadClusterConfig returns the cluster section of a YAML server config, or
+// reads a JSON cluster config from --config or the default location
+func loadClusterConfig() (*config.ClusterConfig, error) {
+	if serverConfig != nil {
+		if serverConfig.Cluster == nil {
+			return nil, withExitCode(ExitValidation, fmt.Errorf("%s has no cluster section", configFile))
+		}
+		c := *serverConfig.Cluster
+		return &c, nil
+	}
+
+	configData, err := os.ReadFile(clusterConfigPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster config: %w", err)
+	}
+
+	var clusterConfig config.ClusterConfig
+	if err := json.Unmarshal(configData, &clusterConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse cluster config: %w", err)
+	}
+	return &clusterConfig, nil
+}
+
 func parseAddress(address string) (string, int, error) {
 	host, portStr, err := net.SplitHostPort(address)
 	if err != nil {
@@ -141,5 +985,69 @@ func init() {
 	serverCmd.Flags().IntP("port", "p", 8080, "Server port")
 	serverCmd.Flags().StringP("host", "H", "0.0.0.0", "Server host")
 	serverCmd.Flags().StringP("address", "a", "", "Server address (host:port)")
-	serverCmd.Flags().StringVar(&configFile, "config", "", "Path to cluster configuration file")
+	serverCmd.Flags().StringVar(&configFile, "config", "", "Server configuration file (YAML), or a JSON cluster configuration file")
+	serverCmd.Flags().String("audit-key", "", "Sign the audit log periodically with this key from \"ipam audit keygen\"")
+	serverCmd.Flags().Duration("audit-anchor-interval", time.Hour, "How often to anchor the audit log when --audit-key is set")
+	serverCmd.Flags().Bool("audit-sync", false, "Write each audit entry before responding, e.g. for compliance, instead of in the background")
+	serverCmd.Flags().Int("audit-queue-size", auditlog.DefaultQueueSize, "How many audit entries wait to be written in the background before requests wait for them")
+	serverCmd.Flags().StringArray("approval-webhook", nil, "Approval webhook as name=url for networks tagged approval-webhook=<name> (repeatable)")
+	serverCmd.Flags().Duration("health-interval", 0, "Probe allocated addresses this often (0 disables health checks)")
+	serverCmd.Flags().String("health-ports", "22,80,443", "TCP ports probed by health checks")
+	serverCmd.Flags().Duration("health-stale-after", 30*24*time.Hour, "Tag allocations stale after not answering health checks for this long")
+	serverCmd.Flags().Duration("reclaim-interval", 0, "Apply network reclamation policies this often (0 disables reclamation)")
+	serverCmd.Flags().String("reclaim-webhook", "", "POST reclamation events as JSON to this URL")
+	serverCmd.Flags().String("webhook-secret-file", "", "Sign approval, reclamation and notification webhook requests with HMAC-SHA256 using the secret in this file")
+	serverCmd.Flags().StringArray("notify", nil, "Notification channel as name=url for networks tagged notify=<name>: an http(s), slack+https or smtp URL (repeatable)")
+	serverCmd.Flags().Duration("notify-interval", 5*time.Minute, "How often to check network utilization and expiring allocations for notifications")
+	serverCmd.Flags().String("tls-cert", "", "Serve HTTPS with this certificate (PEM)")
+	serverCmd.Flags().String("tls-key", "", "Private key of --tls-cert (PEM)")
+	serverCmd.Flags().String("auth-token-file", "", "Require API clients to send one of the bearer tokens in this file (one per line)")
+	serverCmd.Flags().String("spiffe-bundle", "", "Accept client X.509 SVIDs issued by the authorities in this PEM trust bundle (needs --tls-cert)")
+	serverCmd.Flags().StringArray("spiffe-id", nil, "Map SPIFFE IDs to an ACL principal as spiffe://<domain>/<path>[/*]=<name>, authorizing them as spiffe:<name> (repeatable)")
+	serverCmd.Flags().StringArray("admin-principal", nil, "Let this caller, e.g. token:9f86d081 or spiffe:gateway, name the end user it acts for with X-On-Behalf-Of (repeatable)")
+	serverCmd.Flags().String("log-file", "", "Append the server log to this file instead of stderr")
+	serverCmd.Flags().Bool("access-log", false, "Log every API request")
+	serverCmd.Flags().String("pid-file", "", "Write the server's process ID to this file while it runs")
+	serverCmd.Flags().String("unix-socket", "", "Also serve the API on this Unix domain socket")
+	serverCmd.Flags().String("unix-socket-mode", "0660", "File permissions of --unix-socket (octal)")
+	serverCmd.Flags().String("unix-socket-group", "", "Group owning --unix-socket (name or ID)")
+	serverCmd.Flags().Duration("read-header-timeout", 10*time.Second, "Time allowed to read request headers (0 is unlimited)")
+	serverCmd.Flags().Duration("read-timeout", time.Minute, "Time allowed to read a whole request (0 is unlimited)")
+	serverCmd.Flags().Duration("write-timeout", time.Minute, "Time allowed to write a response (0 is unlimited)")
+	serverCmd.Flags().Duration("idle-timeout", 2*time.Minute, "How long idle keep-alive connections stay open (0 uses --read-timeout)")
+	serverCmd.Flags().String("max-body-size", "16MB", "Largest accepted request body, e.g. 512KB or 16MB (0 is unlimited)")
+	serverCmd.Flags().String("backup-dir", "", "Write periodic JSON exports to this directory")
+	serverCmd.Flags().Duration("backup-interval", 24*time.Hour, "How often to back up when --backup-dir is set")
+	serverCmd.Flags().Int("backup-keep", 7, "Number of backups to keep (0 keeps all)")
+	serverCmd.Flags().String("replicate-from", "", "Run as a read-only standby of the primary server at this URL until promoted")
+	serverCmd.Flags().Duration("replicate-interval", 30*time.Second, "How often a standby pulls changes from --replicate-from")
+	serverCmd.Flags().String("replicate-token-file", "", "File holding the API token sent to --replicate-from")
+	serverCmd.Flags().Bool("replicate-read-only", false, "Never promote this standby, e.g. a copy serving a branch office")
+	serverCmd.Flags().Duration("replicate-max-age", 0, "Report the standby stale in /health, with 503, when it last synced longer ago (0 never)")
+	serverCmd.Flags().Duration("expiry-warning", 24*time.Hour, "Notify this long before an allocation's TTL runs out (0 disables; networks override with notify-expiry=<duration>)")
+	serverCmd.Flags().StringArray("geoip-db", nil, "MaxMind DB file, e.g. GeoLite2-Country.mmdb or GeoLite2-ASN.mmdb, to tag public networks and allocations from (repeatable)")
+	serverCmd.Flags().Duration("geoip-interval", geoip.DefaultInterval, "How often public networks and allocations are tagged from --geoip-db")
+	serverCmd.Flags().String("snmp-listen", "", "Answer read-only SNMPv1/v2c polls for network utilization on this UDP address, e.g. 0.0.0.0:161")
+	serverCmd.Flags().String("snmp-community-file", "", "File holding the community SNMP polls must carry (first line)")
+	serverCmd.Flags().String("snmp-oid", snmp.DefaultOID, "Base OID the SNMP agent publishes network utilization below")
+	serverCmd.Flags().String("cdc-dsn", "", "Mirror networks and allocations into an append-only change table of this database: postgres://… or mysql://<DSN>")
+	serverCmd.Flags().String("cdc-table", cdc.DefaultTable, "Change table --cdc-dsn writes, created when missing")
+	serverCmd.Flags().Duration("cdc-interval", cdc.DefaultInterval, "How often changes are mirrored to --cdc-dsn")
+	serverCmd.Flags().String("kea-url", "", "Push DHCP host reservations for allocations with a mac= tag to this Kea Control Agent, e.g. http://kea:8000")
+	serverCmd.Flags().String("kea-credentials-file", "", "File holding --kea-url's user:password")
+	serverCmd.Flags().StringSlice("kea-service", []string{"dhcp4"}, "Kea services --kea-url syncs: dhcp4, dhcp6")
+	serverCmd.Flags().Duration("kea-interval", kea.DefaultInterval, "How often reservations are pushed to --kea-url")
+	serverCmd.Flags().String("powerdns-url", "", "Push A, AAAA and PTR records for allocations with hostnames to this PowerDNS API, e.g. http://pdns:8081")
+	serverCmd.Flags().String("powerdns-api-key-file", "", "File holding --powerdns-url's API key")
+	serverCmd.Flags().String("powerdns-server-id", powerdns.DefaultServerID, "PowerDNS server whose zones --powerdns-url syncs")
+	serverCmd.Flags().StringSlice("powerdns-zone", nil, "Only sync these zones to --powerdns-url (default every primary and native zone; repeatable)")
+	serverCmd.Flags().String("powerdns-domain", "", "Domain qualifying hostnames in networks without a domain=<name> tag")
+	serverCmd.Flags().Int("powerdns-ttl", powerdns.DefaultTTL, "TTL of the records pushed to --powerdns-url, in seconds")
+	serverCmd.Flags().Int("powerdns-batch-size", powerdns.DefaultBatchSize, "Record sets changed per PowerDNS zone update at most")
+	serverCmd.Flags().Duration("powerdns-interval", powerdns.DefaultInterval, "How often records are pushed to --powerdns-url")
+	serverCmd.Flags().Bool("mdns", false, "Announce the API on the local network with mDNS/DNS-SD as "+mdns.ServiceType+", for \"ipam --discover\"")
+	serverCmd.Flags().String("mdns-name", "", "Service instance name --mdns announces (default the host name)")
+	serverCmd.Flags().String("tag-registry", "", "YAML file listing the tags networks and allocations may carry")
+	serverCmd.Flags().Bool("enforce-tags", false, "Reject tags --tag-registry does not list")
+	serverCmd.Flags().Bool("auto-create-networks", false, "Create the network an allocation names by CIDR when it is not registered (for labs)")
 }
@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// serverConfig is the YAML server configuration given to "ipam server
+// --config", if any
+var serverConfig *config.ServerConfig
+
+// applyServerConfig loads a YAML --config file and sets each server flag it
+// configures, unless the flag was given on the command line. JSON files are
+// cluster configurations and are read by runClusterServer instead.
+func applyServerConfig(cmd *cobra.Command) error {
+	serverConfig = nil
+	if configFile == "" || strings.HasSuffix(strings.ToLower(configFile), ".json") {
+		return nil
+	}
+
+	c, err := config.LoadServerConfig(configFile)
+	if err != nil {
+		return withExitCode(ExitValidation, err)
+	}
+	serverConfig = c
+
+	flags := cmd.Flags()
+	// Record the flags given on the command line before setting any, as
+	// setting a repeatable flag marks it changed
+	given := map[string]bool{}
+	flags.Visit(func(f *pflag.Flag) { given[f.Name] = true })
+	set := func(name, value string) error {
+		if value == "" || given[name] {
+			return nil
+		}
+		if err := flags.Set(name, value); err != nil {
+			return withExitCode(ExitValidation, fmt.Errorf("%s: %s: %w", configFile, name, err))
+		}
+		return nil
+	}
+	duration := func(d time.Duration) string {
+		if d == 0 {
+			return ""
+		}
+		return d.String()
+	}
+	boolean := func(b bool) string {
+		if !b {
+			return ""
+		}
+		return "true"
+	}
+	// pairs renders a map as sorted name=value flag values
+	pairs := func(m map[string]string) []string {
+		var values []string
+		for name, value := range m {
+			values = append(values, name+"="+value)
+		}
+		sort.Strings(values)
+		return values
+	}
+
+	var ports []string
+	for _, port := range c.Health.Ports {
+		ports = append(ports, strconv.Itoa(port))
+	}
+	backupKeep := ""
+	if c.Backups.Keep != 0 {
+		backupKeep = strconv.Itoa(c.Backups.Keep)
+	}
+	auditQueueSize := ""
+	if c.Audit.QueueSize != 0 {
+		auditQueueSize = strconv.Itoa(c.Audit.QueueSize)
+	}
+	powerdnsTTL := ""
+	if c.PowerDNS.TTL != 0 {
+		powerdnsTTL = strconv.Itoa(c.PowerDNS.TTL)
+	}
+	powerdnsBatchSize := ""
+	if c.PowerDNS.BatchSize != 0 {
+		powerdnsBatchSize = strconv.Itoa(c.PowerDNS.BatchSize)
+	}
+
+	settings := []struct{ name, value string }{
+		{"address", c.Listen},
+		{"pid-file", c.PIDFile},
+		{"db", c.Store.DSN},
+		{"cluster", boolean(c.Store.Driver == "raft")},
+		{"tls-cert", c.TLS.Cert},
+		{"tls-key", c.TLS.Key},
+		{"unix-socket", c.UnixSocket.Path},
+		{"unix-socket-mode", c.UnixSocket.Mode},
+		{"unix-socket-group", c.UnixSocket.Group},
+		{"auth-token-file", c.Auth.TokenFile},
+		{"spiffe-bundle", c.Auth.SPIFFEBundle},
+		{"log-file", c.Logging.File},
+		{"access-log", boolean(c.Logging.Access)},
+		{"read-header-timeout", duration(c.Limits.ReadHeaderTimeout)},
+		{"read-timeout", duration(c.Limits.ReadTimeout)},
+		{"write-timeout", duration(c.Limits.WriteTimeout)},
+		{"idle-timeout", duration(c.Limits.IdleTimeout)},
+		{"max-body-size", c.Limits.MaxBodySize},
+		{"backup-dir", c.Backups.Dir},
+		{"backup-interval", duration(c.Backups.Interval)},
+		{"backup-keep", backupKeep},
+		{"replicate-from", c.Replication.Primary},
+		{"replicate-interval", duration(c.Replication.Interval)},
+		{"replicate-token-file", c.Replication.TokenFile},
+		{"replicate-read-only", boolean(c.Replication.ReadOnly)},
+		{"replicate-max-age", duration(c.Replication.MaxAge)},
+		{"audit-key", c.Audit.Key},
+		{"audit-anchor-interval", duration(c.Audit.AnchorInterval)},
+		{"audit-sync", boolean(c.Audit.Sync)},
+		{"audit-queue-size", auditQueueSize},
+		{"health-interval", duration(c.Health.Interval)},
+		{"health-ports", strings.Join(ports, ",")},
+		{"health-stale-after", duration(c.Health.StaleAfter)},
+		{"reclaim-interval", duration(c.Reclaim.Interval)},
+		{"reclaim-webhook", c.Reclaim.Webhook},
+		{"webhook-secret-file", c.WebhookSecretFile},
+		{"notify-interval", duration(c.Notify.Interval)},
+		{"expiry-warning", duration(c.Notify.ExpiryWarning)},
+		{"tag-registry", c.Tags.Registry},
+		{"enforce-tags", boolean(c.Tags.Enforce)},
+		{"auto-create-networks", boolean(c.Networks.AutoCreate)},
+		{"geoip-interval", duration(c.GeoIP.Interval)},
+		{"snmp-listen", c.SNMP.Listen},
+		{"snmp-community-file", c.SNMP.CommunityFile},
+		{"snmp-oid", c.SNMP.OID},
+		{"cdc-dsn", c.CDC.DSN},
+		{"cdc-table", c.CDC.Table},
+		{"cdc-interval", duration(c.CDC.Interval)},
+		{"kea-url", c.Kea.URL},
+		{"kea-credentials-file", c.Kea.CredentialsFile},
+		{"kea-interval", duration(c.Kea.Interval)},
+		{"powerdns-url", c.PowerDNS.URL},
+		{"powerdns-api-key-file", c.PowerDNS.APIKeyFile},
+		{"powerdns-server-id", c.PowerDNS.ServerID},
+		{"powerdns-domain", c.PowerDNS.Domain},
+		{"powerdns-ttl", powerdnsTTL},
+		{"powerdns-batch-size", powerdnsBatchSize},
+		{"powerdns-interval", duration(c.PowerDNS.Interval)},
+		{"mdns", boolean(c.MDNS.Enabled)},
+		{"mdns-name", c.MDNS.Name},
+	}
+	for _, s := range settings {
+		if err := set(s.name, s.value); err != nil {
+			return err
+		}
+	}
+	for _, value := range pairs(c.ApprovalWebhooks) {
+		if err := set("approval-webhook", value); err != nil {
+			return err
+		}
+	}
+	for _, value := range pairs(c.Notify.Channels) {
+		if err := set("notify", value); err != nil {
+			return err
+		}
+	}
+	for _, value := range c.Auth.Admins {
+		if err := set("admin-principal", value); err != nil {
+			return err
+		}
+	}
+	for _, value := range pairs(c.Auth.SPIFFEIDs) {
+		if err := set("spiffe-id", value); err != nil {
+			return err
+		}
+	}
+	for _, path := range c.GeoIP.Databases {
+		if err := set("geoip-db", path); err != nil {
+			return err
+		}
+	}
+	for _, service := range c.Kea.Services {
+		if err := set("kea-service", service); err != nil {
+			return err
+		}
+	}
+	for _, zone := range c.PowerDNS.Zones {
+		if err := set("powerdns-zone", zone); err != nil {
+			return err
+		}
+	}
+	return nil
+}
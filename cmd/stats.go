@@ -3,17 +3,27 @@ package cmd
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/report"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
 	"github.com/spf13/cobra"
 )
 
 var statsCmd = &cobra.Command{
 	Use:   "stats",
 	Short: "Show network statistics",
-	Long:  `Display utilization statistics for networks.`,
+	Long: `Display utilization statistics for networks.
+
+With --group-by, utilization is added up per value of a label such as site
+or tenant, or per tag with --group-by tag, one row per group instead of per
+network. A network with several tags counts in the group of each.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		networkID, _ := cmd.Flags().GetString("network-id")
+		format, _ := cmd.Flags().GetString("format")
+		columns, _ := cmd.Flags().GetString("columns")
+		groupBy, _ := cmd.Flags().GetString("group-by")
 
 		var networks []*ipam.Network
 
@@ -31,36 +41,159 @@ var statsCmd = &cobra.Command{
 			}
 		}
 
-		if len(networks) == 0 {
+		if groupBy != "" {
+			return groupedStats(cmd, networks, groupBy, format, columns)
+		}
+
+		tbl, err := newTable(format, columns, statsColumns, statsDefaultColumns)
+		if err != nil {
+			return err
+		}
+
+		if len(networks) == 0 && tbl.isTable() {
 			fmt.Fprintln(cmd.OutOrStdout(), "No networks found.")
 			return nil
 		}
 
-		fmt.Fprintf(cmd.OutOrStdout(), "%-20s %-15s %-15s %-15s %-15s %s\n",
-			"Network", "Total IPs", "Allocated", "Available", "Reserved", "Utilization")
-		fmt.Fprintln(cmd.OutOrStdout(), strings.Repeat("-", 95))
-
+		now := time.Now()
+		rows := make([]map[string]string, 0, len(networks))
 		for _, network := range networks {
 			stats, err := ipamClient.GetNetworkStats(network.ID)
 			if err != nil {
-				fmt.Fprintf(cmd.OutOrStdout(), "%-20s Error: %v\n", network.CIDR, err)
+				if tbl.isTable() {
+					rows = append(rows, map[string]string{"network": network.CIDR, "total": fmt.Sprintf("Error: %v", err)})
+				} else {
+					fmt.Fprintf(cmd.ErrOrStderr(), "%s: %v\n", network.CIDR, err)
+				}
 				continue
 			}
 
-			fmt.Fprintf(cmd.OutOrStdout(), "%-20s %-15d %-15d %-15d %-15d %.1f%%\n",
-				network.CIDR,
-				stats.TotalIPs,
-				stats.AllocatedIPs,
-				stats.AvailableIPs,
-				stats.ReservedIPs,
-				stats.UtilizationPercent,
-			)
+			allocations, err := pebbleStore.ListAllocations(network.ID)
+			if err != nil {
+				return fmt.Errorf("failed to list allocations: %w", err)
+			}
+			breakdown, err := store.BreakDownAddresses(network, allocations, now)
+			if err != nil {
+				return err
+			}
+
+			var frozen string
+			if f := store.NetworkFreeze(network, now); f != nil {
+				frozen = f.String()
+			}
+
+			utilization := fmt.Sprintf("%.1f", stats.UtilizationPercent)
+			if tbl.isTable() {
+				utilization += "%"
+			}
+
+			rows = append(rows, map[string]string{
+				"network":     network.CIDR,
+				"id":          network.ID,
+				"description": network.Description,
+				"total":       fmt.Sprint(stats.TotalIPs),
+				"allocated":   fmt.Sprint(stats.AllocatedIPs),
+				"expired":     fmt.Sprint(breakdown.Expired),
+				"available":   fmt.Sprint(stats.AvailableIPs),
+				"reserved":    fmt.Sprint(stats.ReservedIPs),
+				"utilization": utilization,
+				"frozen":      frozen,
+				"netaddr":     fmt.Sprint(breakdown.Network),
+				"broadcast":   fmt.Sprint(breakdown.Broadcast),
+				"gateway":     fmt.Sprint(breakdown.Gateway),
+				"ranges":      fmt.Sprint(breakdown.ReservedRanges),
+				"holds":       fmt.Sprint(breakdown.Holds),
+				"free":        fmt.Sprint(breakdown.Free),
+			})
 		}
 
-		return nil
+		return tbl.render(cmd.OutOrStdout(), rows)
 	},
 }
 
+// statsColumns are the columns selectable with stats --columns
+var statsColumns = []outputColumn{
+	{Name: "network", Header: "Network", Width: 20},
+	{Name: "total", Header: "Total IPs", Width: 15},
+	{Name: "allocated", Header: "Allocated", Width: 15},
+	{Name: "expired", Header: "Expired", Width: 10},
+	{Name: "available", Header: "Available", Width: 15},
+	{Name: "reserved", Header: "Reserved", Width: 15},
+	{Name: "utilization", Header: "Utilization", Width: 11},
+	{Name: "frozen", Header: "Frozen", Width: 30, Truncate: true},
+	{Name: "netaddr", Header: "Network Addr", Width: 12},
+	{Name: "broadcast", Header: "Broadcast", Width: 9},
+	{Name: "gateway", Header: "Gateway", Width: 7},
+	{Name: "ranges", Header: "Reserved Ranges", Width: 15},
+	{Name: "holds", Header: "Holds", Width: 15},
+	{Name: "free", Header: "Free", Width: 15},
+	{Name: "id", Header: "ID", Width: 36},
+	{Name: "description", Header: "Description", Width: 20, Truncate: true},
+}
+
+var statsDefaultColumns = []string{"network", "total", "allocated", "expired", "available", "reserved", "utilization", "frozen"}
+
+// statsGroupColumns are the columns selectable with stats --group-by
+var statsGroupColumns = []outputColumn{
+	{Name: "group", Header: "Group", Width: 30, Truncate: true},
+	{Name: "networks", Header: "Networks", Width: 10},
+	{Name: "total", Header: "Total IPs", Width: 15},
+	{Name: "allocated", Header: "Allocated", Width: 15},
+	{Name: "available", Header: "Available", Width: 15},
+	{Name: "reserved", Header: "Reserved", Width: 15},
+	{Name: "utilization", Header: "Utilization", Width: 11},
+	{Name: "over_quota", Header: "Over Quota", Width: 10},
+}
+
+var statsGroupDefaultColumns = []string{"group", "networks", "total", "allocated", "available", "reserved", "utilization", "over_quota"}
+
+// groupedStats prints the utilization of networks per value of label, or
+// per tag, the way GET /api/v1/stats computes it
+func groupedStats(cmd *cobra.Command, networks []*ipam.Network, label, format, columns string) error {
+	if strings.ContainsAny(label, "=, ") {
+		return withExitCode(ExitValidation, fmt.Errorf("invalid --group-by label %q", label))
+	}
+	tbl, err := newTable(format, columns, statsGroupColumns, statsGroupDefaultColumns)
+	if err != nil {
+		return err
+	}
+	if len(networks) == 0 && tbl.isTable() {
+		fmt.Fprintln(cmd.OutOrStdout(), "No networks found.")
+		return nil
+	}
+
+	groups, err := report.GroupUsage(ipamClient, networks, label)
+	if err != nil {
+		return fmt.Errorf("failed to get network stats: %w", err)
+	}
+
+	rows := make([]map[string]string, 0, len(groups))
+	for _, g := range groups {
+		name := g.Value
+		if name == "" && tbl.isTable() {
+			name = "(none)"
+		}
+		utilization := fmt.Sprintf("%.1f", g.UtilizationPercent)
+		if tbl.isTable() {
+			utilization += "%"
+		}
+		rows = append(rows, map[string]string{
+			"group":       name,
+			"networks":    fmt.Sprint(g.Networks),
+			"total":       fmt.Sprint(g.TotalIPs),
+			"allocated":   fmt.Sprint(g.AllocatedIPs),
+			"available":   fmt.Sprint(g.AvailableIPs),
+			"reserved":    fmt.Sprint(g.ReservedIPs),
+			"utilization": utilization,
+			"over_quota":  fmt.Sprint(g.OverQuota),
+		})
+	}
+	return tbl.render(cmd.OutOrStdout(), rows)
+}
+
 func init() {
 	statsCmd.Flags().StringP("network-id", "n", "", "Show stats for specific network")
+	statsCmd.Flags().String("format", formatTable, "Output format: table, csv or tsv")
+	statsCmd.Flags().String("columns", "", "Comma-separated columns (network, total, allocated, expired, available, reserved, utilization, frozen, netaddr, broadcast, gateway, ranges, holds, free, id, description; with --group-by: group, networks, total, allocated, available, reserved, utilization, over_quota)")
+	statsCmd.Flags().String("group-by", "", "Add up utilization per value of a label (e.g. site or tenant), or per tag with \"tag\"")
 }
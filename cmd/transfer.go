@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/spf13/cobra"
+)
+
+var transferCmd = &cobra.Command{
+	Use:   "transfer [IP]",
+	Short: "Move an allocation to another network",
+	Long: `Move an active allocation to a different network record without changing
+its address, keeping the allocation ID and history. Use this after splitting
+or merging networks instead of releasing and re-allocating.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ip := args[0]
+		networkID, _ := cmd.Flags().GetString("network-id")
+		targetID, _ := cmd.Flags().GetString("to")
+
+		if targetID == "" {
+			return withExitCode(ExitValidation, fmt.Errorf("--to must be specified"))
+		}
+
+		allocation, err := findActiveAllocation(networkID, ip)
+		if err != nil {
+			return err
+		}
+
+		target, err := pebbleStore.GetNetwork(targetID)
+		if err != nil {
+			return fmt.Errorf("failed to get target network: %w", err)
+		}
+
+		if err := checkNotFrozen(allocation.NetworkID, ""); err != nil {
+			return err
+		}
+		if err := store.CheckNotFrozen(target, time.Now()); err != nil {
+			return err
+		}
+
+		targetAllocations, err := pebbleStore.ListAllocations(target.ID)
+		if err != nil {
+			return fmt.Errorf("failed to list allocations: %w", err)
+		}
+
+		if err := store.CheckTransfer(allocation, target, targetAllocations, time.Now()); err != nil {
+			return fmt.Errorf("cannot transfer %s: %w", ip, err)
+		}
+
+		if err := pebbleStore.MoveAllocation(allocation.ID, target.ID); err != nil {
+			return fmt.Errorf("failed to transfer allocation: %w", err)
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Allocation %s (%s) transferred to network %s.\n", allocation.ID, ip, target.CIDR)
+		return nil
+	},
+}
+
+// findActiveAllocation returns the unreleased allocation of ip, locating
+// its network when networkID is empty
+func findActiveAllocation(networkID, ip string) (*ipam.IPAllocation, error) {
+	if networkID != "" {
+		alloc, err := pebbleStore.GetAllocationByIP(networkID, ip)
+		if err != nil || alloc.ReleasedAt != nil {
+			return nil, withExitCode(ExitNotFound, fmt.Errorf("IP %s not found in network %s", ip, networkID))
+		}
+		return alloc, nil
+	}
+
+	loc, err := store.Locate(pebbleStore, ip, time.Now())
+	if err != nil || loc.Allocation == nil {
+		return nil, withExitCode(ExitNotFound, fmt.Errorf("IP %s not found in any network", ip))
+	}
+	return loc.Allocation, nil
+}
+
+func init() {
+	transferCmd.Flags().StringP("network-id", "n", "", "Current network ID (optional, will auto-detect)")
+	transferCmd.Flags().String("to", "", "Target network ID")
+}
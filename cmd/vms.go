@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/vmsync"
+	"github.com/spf13/cobra"
+)
+
+var vmsCmd = &cobra.Command{
+	Use:   "vms",
+	Short: "Reconcile allocations against hypervisor VMs",
+	Long: `Reconcile allocations against the addresses Proxmox VE or libvirt report
+for their virtual machines. Run "ipam vms sync" from cron to record new VMs
+and flag the allocations of deleted ones.`,
+}
+
+var vmsSyncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Record VM addresses and flag stale VM allocations",
+	Long: `Read the addresses of every VM and container of a Proxmox VE cluster
+(--proxmox) or a libvirt host (--libvirt) and reconcile them against
+allocations.
+
+An address without an allocation gets one in the most specific network
+containing it, named after the VM and tagged vm=<hypervisor>/<id>,
+mac=<MAC> and last-seen. An address an allocation holds already has its
+last-seen tag updated. Addresses outside every network are listed.
+
+An allocation tagged vm=<hypervisor>/<id> is tagged stale when its VM is
+deleted or reports other addresses, so "ipam health report" and
+reclamation pick it up; it is never released. VMs whose addresses are
+unknown, such as stopped VMs or VMs without a guest agent, are left alone.
+
+Proxmox needs an API token (USER@REALM!TOKENID=SECRET) with VM.Audit and
+VM.Monitor, read from --proxmox-token-file. Proxmox VMs report addresses
+through the QEMU guest agent. Libvirt is read with virsh domifaddr, from
+DHCP leases of libvirt networks by default.`,
+	Example: `  ipam vms sync --proxmox https://pve1:8006 --proxmox-token-file /etc/ipam/pve-token --dry-run
+  ipam vms sync --libvirt qemu:///system --libvirt-source agent
+  ipam vms sync --libvirt qemu+ssh://root@kvm2/system --name kvm2`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		proxmoxURL, _ := cmd.Flags().GetString("proxmox")
+		tokenFile, _ := cmd.Flags().GetString("proxmox-token-file")
+		libvirtURI, _ := cmd.Flags().GetString("libvirt")
+		source, _ := cmd.Flags().GetString("libvirt-source")
+		name, _ := cmd.Flags().GetString("name")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		var read func(context.Context) ([]vmsync.VM, error)
+		switch {
+		case proxmoxURL != "" && cmd.Flags().Changed("libvirt"):
+			return withExitCode(ExitValidation, errors.New("--proxmox and --libvirt are mutually exclusive"))
+		case proxmoxURL != "":
+			if tokenFile == "" {
+				return withExitCode(ExitValidation, errors.New("--proxmox-token-file is required with --proxmox"))
+			}
+			tokens, err := readTokens(tokenFile)
+			if err != nil {
+				return err
+			}
+			read = vmsync.NewProxmox(proxmoxURL, tokens[0]).VMs
+			if name == "" {
+				name = "proxmox"
+			}
+		case cmd.Flags().Changed("libvirt"):
+			switch source {
+			case vmsync.SourceLease, vmsync.SourceAgent, vmsync.SourceARP:
+			default:
+				return withExitCode(ExitValidation, fmt.Errorf("invalid --libvirt-source %q: must be lease, agent or arp", source))
+			}
+			read = (&vmsync.Libvirt{URI: libvirtURI, Source: source}).VMs
+			if name == "" {
+				name = "libvirt"
+			}
+		default:
+			return withExitCode(ExitValidation, errors.New("either --proxmox or --libvirt must be specified"))
+		}
+
+		ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+		defer cancel()
+		vms, err := read(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read VMs: %w", err)
+		}
+
+		syncer := &vmsync.Syncer{Store: pebbleStore, Hypervisor: name, DryRun: dryRun}
+		result, err := syncer.Sync(vms, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to sync VMs: %w", err)
+		}
+
+		out := cmd.OutOrStdout()
+		if asJSON {
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			return enc.Encode(result)
+		}
+		if dryRun {
+			fmt.Fprintln(out, "Dry run: no changes made.")
+		}
+		fmt.Fprintf(out, "VMs:      %d\n", result.VMs)
+		fmt.Fprintf(out, "Matched:  %d\n", result.Matched)
+
+		fmt.Fprintf(out, "\nCreated (%d):\n", len(result.Created))
+		for _, c := range result.Created {
+			fmt.Fprintf(out, "  %-40s %-20s %s\n", c.IP, c.VM, c.Hostname)
+		}
+		fmt.Fprintf(out, "\nFlagged stale (%d):\n", len(result.Stale))
+		for _, c := range result.Stale {
+			fmt.Fprintf(out, "  %-40s %-20s %s\n", c.IP, c.VM, c.Hostname)
+		}
+		fmt.Fprintf(out, "\nOutside every network (%d):\n", len(result.Unmanaged))
+		for _, ip := range result.Unmanaged {
+			fmt.Fprintf(out, "  %s\n", ip)
+		}
+		if len(result.Frozen) > 0 {
+			fmt.Fprintf(out, "\nLeft alone in frozen networks (%d):\n", len(result.Frozen))
+			for _, ip := range result.Frozen {
+				fmt.Fprintf(out, "  %s\n", ip)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	vmsSyncCmd.Flags().String("proxmox", "", "Proxmox VE API URL, e.g. https://pve1:8006")
+	vmsSyncCmd.Flags().String("proxmox-token-file", "", "File holding the Proxmox API token")
+	vmsSyncCmd.Flags().String("libvirt", "", "Libvirt connection URI, e.g. qemu:///system (empty for virsh's default)")
+	vmsSyncCmd.Flags().String("libvirt-source", vmsync.SourceLease, "Where libvirt finds addresses: lease, agent or arp")
+	vmsSyncCmd.Flags().String("name", "", "Hypervisor name in vm= tags (default proxmox or libvirt)")
+	vmsSyncCmd.Flags().Bool("dry-run", false, "Report the changes without making them")
+	vmsSyncCmd.Flags().Duration("timeout", 2*time.Minute, "Time allowed for reading the hypervisor")
+	vmsSyncCmd.Flags().Bool("json", false, "Print the result as JSON")
+	vmsCmd.AddCommand(vmsSyncCmd)
+}
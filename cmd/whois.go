@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/rdap"
+	"github.com/spf13/cobra"
+)
+
+// rdapBaseURL is overridden in tests
+var rdapBaseURL = rdap.DefaultBaseURL
+
+var whoisCmd = &cobra.Command{
+	Use:   "whois [CIDR]",
+	Short: "Look up registration data for a public prefix",
+	Long: `Query RDAP for the registry, organization and abuse contact of a public
+address block. Results are cached under the database directory for a week.
+
+With --annotate, the matching network is tagged with rir=<registry> and
+rdap=<handle> so its provenance is visible in listings.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		refresh, _ := cmd.Flags().GetBool("refresh")
+		annotate, _ := cmd.Flags().GetBool("annotate")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		client := rdap.NewClient(rdap.NewCache(filepath.Join(dbPath, "rdap-cache")))
+		client.BaseURL = rdapBaseURL
+		client.Refresh = refresh
+
+		info, err := client.Lookup(cmd.Context(), args[0])
+		if err != nil {
+			return fmt.Errorf("whois lookup failed: %w", err)
+		}
+
+		out := cmd.OutOrStdout()
+		if asJSON {
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(info); err != nil {
+				return err
+			}
+		} else {
+			fmt.Fprintf(out, "Prefix:      %s\n", info.Prefix)
+			fmt.Fprintf(out, "Range:       %s - %s\n", info.StartAddress, info.EndAddress)
+			fmt.Fprintf(out, "Handle:      %s\n", info.Handle)
+			fmt.Fprintf(out, "Name:        %s\n", info.Name)
+			fmt.Fprintf(out, "RIR:         %s\n", info.RIR)
+			fmt.Fprintf(out, "Org:         %s\n", info.Org)
+			fmt.Fprintf(out, "Abuse:       %s\n", info.AbuseEmail)
+			if info.Country != "" {
+				fmt.Fprintf(out, "Country:     %s\n", info.Country)
+			}
+		}
+
+		if !annotate {
+			return nil
+		}
+
+		network, err := pebbleStore.GetNetworkByCIDR(info.Prefix)
+		if err != nil {
+			return fmt.Errorf("failed to get network %s: %w", info.Prefix, err)
+		}
+
+		tags := make([]string, 0, len(network.Tags)+2)
+		for _, tag := range network.Tags {
+			if !strings.HasPrefix(tag, "rir=") && !strings.HasPrefix(tag, "rdap=") {
+				tags = append(tags, tag)
+			}
+		}
+		if info.RIR != "" {
+			tags = append(tags, "rir="+strings.ReplaceAll(info.RIR, " ", "-"))
+		}
+		if info.Handle != "" {
+			tags = append(tags, "rdap="+info.Handle)
+		}
+		network.Tags = tags
+		network.UpdatedAt = time.Now()
+
+		if err := pebbleStore.SaveNetwork(network); err != nil {
+			return fmt.Errorf("failed to update network: %w", err)
+		}
+
+		fmt.Fprintf(out, "\nNetwork %s tagged: %s\n", network.ID, strings.Join(tags, ", "))
+		return nil
+	},
+}
+
+func init() {
+	whoisCmd.Flags().Bool("refresh", false, "Ignore cached results")
+	whoisCmd.Flags().Bool("annotate", false, "Tag the matching network with its registry and handle")
+	whoisCmd.Flags().Bool("json", false, "Output as JSON")
+}
@@ -0,0 +1,149 @@
+#!/bin/sh
+# Container entrypoint for the IPAM image
+#
+#   docker-entrypoint.sh server       Start the server in $IPAM_MODE (default)
+#   docker-entrypoint.sh healthcheck  Exit 0 if /readyz answers 200
+#   docker-entrypoint.sh <args>       Run "ipam <args>", e.g. "network list"
+#
+# Environment:
+#   IPAM_MODE             standalone or cluster (default standalone)
+#   IPAM_DATA_DIR         Data volume (default /data)
+#   IPAM_HOST, IPAM_PORT  API listen address (default 0.0.0.0:8080)
+#   IPAM_CONFIG           YAML server configuration file; the settings
+#                         below are then taken from it instead, except
+#                         IPAM_PORT, which the health check still uses
+#   IPAM_AUTH_TOKEN_FILE  Require bearer tokens from this file
+#   IPAM_TLS_CERT, IPAM_TLS_KEY
+#                         Serve HTTPS
+#
+# Cluster mode:
+#   IPAM_NODE_ID          This node's ID, unique and greater than 0 (required)
+#   IPAM_CLUSTER_ID       Cluster ID (default 1)
+#   IPAM_RAFT_ADDR        This node's Raft address, host:port (required)
+#   IPAM_INITIAL_MEMBERS  Members as id:host:port,... (required)
+#   IPAM_JOIN             true to join a running cluster instead of founding it
+#   IPAM_GOSSIP_ADDR      Gossip API addresses and health on host:port
+#   IPAM_GOSSIP_SEEDS     Other nodes' gossip addresses, host:port,...
+#   IPAM_API_URL          URL this node's API is reached at, if not derived
+#                         from IPAM_PORT and the Raft host
+#
+# In a Kubernetes StatefulSet, set these instead of the four above to derive
+# them from the pod's stable DNS name, <statefulset>-<ordinal>.<service>:
+#   IPAM_PEER_DNS         Domain of the headless service, e.g.
+#                         ipam-headless.ipam.svc.cluster.local
+#   IPAM_REPLICAS         Number of founding members (default 3)
+#   IPAM_RAFT_PORT        Raft port (default 5000)
+#
+# A cluster node's configuration is written to $IPAM_DATA_DIR/cluster on the
+# first start and reused afterwards, so the container can be recreated.
+
+set -e
+
+data=${IPAM_DATA_DIR:-/data}
+cluster_dir=$data/cluster
+
+fail() {
+    echo "docker-entrypoint: $*" >&2
+    exit 1
+}
+
+healthcheck() {
+    scheme=http
+    [ -n "$IPAM_TLS_CERT" ] && scheme=https
+    # The certificate names the service, not 127.0.0.1
+    exec ipam healthcheck --insecure --server "$scheme://127.0.0.1:${IPAM_PORT:-8080}"
+}
+
+# server_flags prints the flags shared by both modes
+server_flags() {
+    printf -- '--host %s --port %s' "${IPAM_HOST:-0.0.0.0}" "${IPAM_PORT:-8080}"
+    [ -n "$IPAM_AUTH_TOKEN_FILE" ] && printf -- ' --auth-token-file %s' "$IPAM_AUTH_TOKEN_FILE"
+    [ -n "$IPAM_TLS_CERT" ] && printf -- ' --tls-cert %s --tls-key %s' "$IPAM_TLS_CERT" "$IPAM_TLS_KEY"
+    return 0
+}
+
+# gossip_flags prints the cluster init/join flags for node discovery
+gossip_flags() {
+    [ -n "$IPAM_GOSSIP_ADDR" ] && printf -- ' --gossip-addr %s' "$IPAM_GOSSIP_ADDR"
+    [ -n "$IPAM_GOSSIP_SEEDS" ] && printf -- ' --gossip-seeds %s' "$IPAM_GOSSIP_SEEDS"
+    [ -n "$IPAM_API_URL" ] && printf -- ' --api-url %s' "$IPAM_API_URL"
+    return 0
+}
+
+standalone() {
+    if [ -n "$IPAM_CONFIG" ]; then
+        exec ipam server --config "$IPAM_CONFIG" "$@"
+    fi
+    exec ipam --db "$data/db" server $(server_flags) "$@"
+}
+
+# peer_dns sets the cluster variables from the pod's ordinal: pod <name>-N
+# is node N+1, and the members are ordinals 0 to IPAM_REPLICAS-1
+peer_dns() {
+    host=$(hostname)
+    ordinal=${host##*-}
+    case "$ordinal" in
+    '' | *[!0-9]*) fail "hostname $host does not end in a StatefulSet ordinal" ;;
+    esac
+    base=${host%-*}
+    port=${IPAM_RAFT_PORT:-5000}
+
+    IPAM_NODE_ID=$((ordinal + 1))
+    IPAM_RAFT_ADDR=$host.$IPAM_PEER_DNS:$port
+    IPAM_INITIAL_MEMBERS=
+    i=0
+    while [ "$i" -lt "${IPAM_REPLICAS:-3}" ]; do
+        IPAM_INITIAL_MEMBERS=$IPAM_INITIAL_MEMBERS${IPAM_INITIAL_MEMBERS:+,}$((i + 1)):$base-$i.$IPAM_PEER_DNS:$port
+        i=$((i + 1))
+    done
+
+    # Raft listens on its advertised name, which resolves only once the
+    # headless service has published this pod
+    waited=0
+    until nslookup "$host.$IPAM_PEER_DNS" >/dev/null 2>&1; do
+        [ "$waited" -ge 120 ] && fail "$host.$IPAM_PEER_DNS does not resolve"
+        sleep 2
+        waited=$((waited + 2))
+    done
+}
+
+cluster() {
+    if [ -n "$IPAM_CONFIG" ]; then
+        exec ipam server --cluster --config "$IPAM_CONFIG" "$@"
+    fi
+
+    [ -n "$IPAM_PEER_DNS" ] && peer_dns
+    if [ ! -f "$cluster_dir/cluster.json" ]; then
+        [ -n "$IPAM_NODE_ID" ] || fail "IPAM_NODE_ID is required in cluster mode"
+        [ -n "$IPAM_RAFT_ADDR" ] || fail "IPAM_RAFT_ADDR is required in cluster mode"
+        [ -n "$IPAM_INITIAL_MEMBERS" ] || fail "IPAM_INITIAL_MEMBERS is required in cluster mode"
+
+        action=init
+        [ "$IPAM_JOIN" = true ] && action=join
+        ipam cluster "$action" \
+            --node-id "$IPAM_NODE_ID" \
+            --cluster-id "${IPAM_CLUSTER_ID:-1}" \
+            --raft-addr "$IPAM_RAFT_ADDR" \
+            --initial-members "$IPAM_INITIAL_MEMBERS" \
+            --data-dir "$cluster_dir" \
+            $(gossip_flags)
+    fi
+    exec ipam server --cluster --config "$cluster_dir/cluster.json" $(server_flags) "$@"
+}
+
+case "$1" in
+healthcheck)
+    healthcheck
+    ;;
+server)
+    shift
+    case "${IPAM_MODE:-standalone}" in
+    standalone) standalone "$@" ;;
+    cluster) cluster "$@" ;;
+    *) fail "IPAM_MODE must be standalone or cluster, not $IPAM_MODE" ;;
+    esac
+    ;;
+*)
+    exec ipam "$@"
+    ;;
+esac
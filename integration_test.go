@@ -335,6 +335,39 @@ func TestSingleNodeCluster(t *testing.T) {
 		resp.Body.Close()
 		assert.Equal(t, "192.168.1.1", allocResult["ip"])
 		assert.Equal(t, "cluster-test-host", allocResult["hostname"])
+
+		// Compact the node's Raft log into a snapshot
+		resp, err = http.Post(apiURL+"/cluster/compact", "application/json", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var compactResult map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&compactResult)
+		resp.Body.Close()
+		assert.Equal(t, true, compactResult["compacted"])
+		assert.NotNil(t, compactResult["after"])
+
+		// A single node is always caught up with itself
+		resp, err = http.Get(apiURL + "/cluster/nodes/1/progress")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var progress map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&progress)
+		resp.Body.Close()
+		assert.Equal(t, true, progress["caught_up"])
+
+		// The node's election is in the audit log
+		resp, err = http.Get(apiURL + "/audit?limit=100")
+		require.NoError(t, err)
+		var auditEntries []map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&auditEntries)
+		resp.Body.Close()
+		actions := make(map[string]bool)
+		for _, entry := range auditEntries {
+			actions[entry["action"].(string)] = true
+		}
+		assert.True(t, actions["leader-change"])
 	})
 }
 
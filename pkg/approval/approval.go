@@ -0,0 +1,180 @@
+// Package approval calls external webhooks that approve or reject an
+// allocation before it is made, e.g. to check a CMDB.
+//
+// A network opts in with an approval-webhook=<name> tag. The URL for each
+// name is configured on the server rather than in the tag, so anyone able to
+// tag a network cannot point the server at arbitrary addresses.
+package approval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/webhook"
+)
+
+// TagPrefix names the webhook that approves allocations in a network
+const TagPrefix = "approval-webhook="
+
+// DefaultTimeout bounds a webhook call when Webhooks.Client is nil
+const DefaultTimeout = 5 * time.Second
+
+// maxMessage bounds how much of a rejection body is reported
+const maxMessage = 1024
+
+var (
+	// ErrRejected is returned when a webhook answers with a status other
+	// than 200
+	ErrRejected = errors.New("allocation rejected by approval webhook")
+
+	// ErrUnavailable is returned when a network's webhook is not configured
+	// or cannot be reached. Allocations fail closed.
+	ErrUnavailable = errors.New("approval webhook unavailable")
+)
+
+// Request is the JSON body posted to a webhook
+type Request struct {
+	NetworkID   string   `json:"network_id"`
+	CIDR        string   `json:"cidr"`
+	IP          string   `json:"ip,omitempty"`
+	Count       int      `json:"count"`
+	Hostname    string   `json:"hostname,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Requester   string   `json:"requester"`
+}
+
+// NewRequest describes req in network for a webhook. ip is the requested
+// address, or empty when the allocator picks it.
+func NewRequest(network *ipam.Network, req *ipam.AllocationRequest, ip, requester string) Request {
+	count := req.Count
+	if count < 1 {
+		count = 1
+	}
+	return Request{
+		NetworkID:   network.ID,
+		CIDR:        network.CIDR,
+		IP:          ip,
+		Count:       count,
+		Hostname:    req.Hostname,
+		Description: req.Description,
+		Tags:        req.Tags,
+		Requester:   requester,
+	}
+}
+
+// Webhook returns the name of network's approval webhook, or "" when its
+// allocations need no approval
+func Webhook(network *ipam.Network) string {
+	for _, tag := range network.Tags {
+		if name, ok := strings.CutPrefix(tag, TagPrefix); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// Webhooks maps webhook names to URLs. Requests are signed by Signer when
+// set.
+type Webhooks struct {
+	URLs   map[string]string
+	Client *http.Client
+	Signer *webhook.Signer
+}
+
+// ParseWebhooks parses name=url specs, e.g. from repeated command line flags
+func ParseWebhooks(specs []string) (*Webhooks, error) {
+	w := &Webhooks{URLs: make(map[string]string)}
+	for _, spec := range specs {
+		name, rawURL, ok := strings.Cut(spec, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid webhook %q: expected name=url", spec)
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook %q: URL must be http or https", spec)
+		}
+		if _, dup := w.URLs[name]; dup {
+			return nil, fmt.Errorf("webhook %q is defined twice", name)
+		}
+		w.URLs[name] = rawURL
+	}
+	return w, nil
+}
+
+// Check asks network's webhook to approve req. It returns nil when the
+// network has no webhook or the webhook answers 200, ErrRejected with the
+// webhook's message otherwise, and ErrUnavailable when the webhook is not
+// configured or cannot be reached. A nil Webhooks has none configured.
+func (w *Webhooks) Check(ctx context.Context, network *ipam.Network, req Request) error {
+	name := Webhook(network)
+	if name == "" {
+		return nil
+	}
+	var target string
+	if w != nil {
+		target = w.URLs[name]
+	}
+	if target == "" {
+		return fmt.Errorf("%w: webhook %q of network %s is not configured", ErrUnavailable, name, network.CIDR)
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrUnavailable, name, err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if err := w.Signer.Sign(httpReq, body); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrUnavailable, name, err)
+	}
+
+	client := http.DefaultClient
+	if w.Client != nil {
+		client = w.Client
+	} else {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+		httpReq = httpReq.WithContext(ctx)
+	}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrUnavailable, name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	return fmt.Errorf("%w %s: %s", ErrRejected, name, rejectionMessage(resp))
+}
+
+// rejectionMessage extracts the reason from a rejecting response: the
+// message field of a JSON body, the body text, or the status
+func rejectionMessage(resp *http.Response) string {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxMessage))
+	var body struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(data, &body) == nil && body.Message != "" {
+		return body.Message
+	}
+	if text := strings.TrimSpace(string(data)); text != "" && !strings.HasPrefix(text, "{") {
+		return text
+	}
+	return resp.Status
+}
@@ -0,0 +1,91 @@
+package approval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWebhooks(t *testing.T) {
+	w, err := ParseWebhooks([]string{"cmdb=https://cmdb.example.com/check?site=1", "lab=http://10.0.0.1:8080/"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://cmdb.example.com/check?site=1", w.URLs["cmdb"])
+	assert.Len(t, w.URLs, 2)
+
+	for _, spec := range []string{"cmdb", "=https://x", "cmdb=ftp://x", "cmdb=https://", "cmdb=not a url"} {
+		_, err := ParseWebhooks([]string{spec})
+		assert.Error(t, err, spec)
+	}
+	_, err = ParseWebhooks([]string{"a=http://x", "a=http://y"})
+	assert.Error(t, err)
+}
+
+func TestCheck(t *testing.T) {
+	var got Request
+	replays := webhook.NewReplays()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := webhook.Verify(r, []byte("s3cret"), replays); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		switch got.Hostname {
+		case "ok":
+			w.WriteHeader(http.StatusOK)
+		case "json":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message":"host not in CMDB"}`))
+		case "text":
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("asset retired\n"))
+		default:
+			w.WriteHeader(http.StatusTeapot)
+		}
+	}))
+	defer srv.Close()
+
+	hooks, err := ParseWebhooks([]string{"cmdb=" + srv.URL})
+	require.NoError(t, err)
+	hooks.Signer = webhook.NewSigner([]byte("s3cret"))
+	network := &ipam.Network{ID: "net1", CIDR: "10.0.0.0/24", Tags: []string{"env=prod", TagPrefix + "cmdb"}}
+	assert.Equal(t, "cmdb", Webhook(network))
+
+	check := func(hostname string) error {
+		req := NewRequest(network, &ipam.AllocationRequest{Hostname: hostname, Tags: []string{"web"}}, "", "alice")
+		return hooks.Check(context.Background(), network, req)
+	}
+
+	require.NoError(t, check("ok"))
+	assert.Equal(t, Request{NetworkID: "net1", CIDR: "10.0.0.0/24", Count: 1, Hostname: "ok", Tags: []string{"web"}, Requester: "alice"}, got)
+
+	assert.Equal(t, 1, replays.Len())
+
+	err = check("json")
+	assert.True(t, errors.Is(err, ErrRejected))
+	assert.Contains(t, err.Error(), "host not in CMDB")
+
+	err = check("text")
+	assert.True(t, errors.Is(err, ErrRejected))
+	assert.Contains(t, err.Error(), "asset retired")
+
+	err = check("other")
+	assert.True(t, errors.Is(err, ErrRejected))
+	assert.Contains(t, err.Error(), "418")
+
+	// Networks without a webhook need no approval, even with none configured
+	var none *Webhooks
+	assert.NoError(t, none.Check(context.Background(), &ipam.Network{ID: "net2"}, Request{}))
+
+	// Unknown and unreachable webhooks fail closed
+	assert.True(t, errors.Is(none.Check(context.Background(), network, Request{}), ErrUnavailable))
+	srv.Close()
+	assert.True(t, errors.Is(check("ok"), ErrUnavailable))
+}
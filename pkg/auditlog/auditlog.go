@@ -0,0 +1,372 @@
+// Package auditlog makes the audit log tamper evident. Anchors stored in the
+// log itself each sign the hash chain of the entries logged since the
+// anchor before them, and name that anchor, so anyone holding the public
+// key can prove that no anchored entry was altered, removed or inserted,
+// even when several servers anchor the same log or the oldest entries have
+// been trimmed.
+package auditlog
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// AnchorAction is the audit action of anchor entries
+const AnchorAction = "audit_anchor"
+
+// AnchorLag is how far behind the clock Run anchors, so that entries still
+// queued or being retried on a cluster's other servers are stored before
+// the anchor covering their timestamps is signed
+const AnchorLag = time.Minute
+
+// ErrTampered is returned by Verify when the log no longer matches an anchor
+var ErrTampered = errors.New("audit log does not match its signed anchors")
+
+// Anchor signs the segment of the log following the anchor Prev: the Count
+// entries, anchors excluded, logged after Since, the Until of Prev, and at
+// or before Until. Head is their hash chain, seeded with PrevHead, the Head
+// of Prev. An anchor is stored as the Details of an AnchorAction entry.
+type Anchor struct {
+	Prev      string    `json:"prev,omitempty"`
+	PrevHead  string    `json:"prev_head,omitempty"`
+	Since     time.Time `json:"since"`
+	Until     time.Time `json:"until"`
+	First     time.Time `json:"first"`
+	Count     int       `json:"count"`
+	Head      string    `json:"head"`
+	Signature string    `json:"signature"`
+}
+
+// Sort orders entries oldest first, breaking timestamp ties by ID
+func Sort(entries []*ipam.AuditEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].Timestamp.Equal(entries[j].Timestamp) {
+			return entries[i].Timestamp.Before(entries[j].Timestamp)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+}
+
+// Hash returns the chain hash of entry following prev, the hash of the
+// entry before it or the seed of the chain
+func Hash(prev []byte, entry *ipam.AuditEntry) []byte {
+	h := sha256.New()
+	h.Write(prev)
+	json.NewEncoder(h).Encode([]string{
+		entry.ID,
+		entry.Timestamp.UTC().Format(time.RFC3339Nano),
+		entry.Action,
+		entry.Resource,
+		entry.Details,
+		entry.User,
+	})
+	return h.Sum(nil)
+}
+
+// signedMessage is what an anchor's signature covers
+func (a *Anchor) signedMessage() []byte {
+	return []byte(fmt.Sprintf("go-ipam audit anchor\n%s\n%s\n%s\n%s\n%s\n%d\n%s",
+		a.Prev, a.PrevHead,
+		a.Since.UTC().Format(time.RFC3339Nano),
+		a.Until.UTC().Format(time.RFC3339Nano),
+		a.First.UTC().Format(time.RFC3339Nano),
+		a.Count, a.Head))
+}
+
+// chain returns the hex hash chain of entries seeded with the hex head seed
+func chain(seed string, entries []*ipam.AuditEntry) string {
+	head, _ := hex.DecodeString(seed)
+	for _, entry := range entries {
+		head = Hash(head, entry)
+	}
+	return hex.EncodeToString(head)
+}
+
+// segment returns the entries of sorted, which holds no anchors, logged
+// after since and at or before until
+func segment(sorted []*ipam.AuditEntry, since, until time.Time) []*ipam.AuditEntry {
+	from := sort.Search(len(sorted), func(i int) bool { return sorted[i].Timestamp.After(since) })
+	to := sort.Search(len(sorted), func(i int) bool { return sorted[i].Timestamp.After(until) })
+	if to < from {
+		to = from
+	}
+	return sorted[from:to]
+}
+
+// parsed is an anchor entry with its decoded anchor
+type parsed struct {
+	entry  *ipam.AuditEntry
+	anchor *Anchor
+}
+
+// split separates the anchors of the sorted log from its other entries. An
+// anchor that cannot be decoded is returned as an error.
+func split(sorted []*ipam.AuditEntry) ([]parsed, []*ipam.AuditEntry, error) {
+	var anchors []parsed
+	others := make([]*ipam.AuditEntry, 0, len(sorted))
+	for _, entry := range sorted {
+		if entry.Action != AnchorAction {
+			others = append(others, entry)
+			continue
+		}
+		var a Anchor
+		if err := json.Unmarshal([]byte(entry.Details), &a); err != nil {
+			return nil, nil, fmt.Errorf("%w: anchor %s is malformed: %v", ErrTampered, entry.ID, err)
+		}
+		anchors = append(anchors, parsed{entry, &a})
+	}
+	return anchors, others, nil
+}
+
+// NewAnchor signs the entries of the log logged at or before until that no
+// anchor covers yet, chaining the new anchor to the latest one in the log.
+// It returns nil when there are no such entries.
+func NewAnchor(entries []*ipam.AuditEntry, until time.Time, key ed25519.PrivateKey) (*Anchor, error) {
+	sorted := append([]*ipam.AuditEntry(nil), entries...)
+	Sort(sorted)
+	anchors, others, err := split(sorted)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Anchor{Until: until}
+	for _, p := range anchors {
+		if p.anchor.Until.After(a.Since) || a.Prev == "" {
+			a.Prev, a.PrevHead, a.Since = p.entry.ID, p.anchor.Head, p.anchor.Until
+		}
+	}
+	covered := segment(others, a.Since, until)
+	if len(covered) == 0 {
+		return nil, nil
+	}
+	a.First = covered[0].Timestamp
+	a.Count = len(covered)
+	a.Head = chain(a.PrevHead, covered)
+	a.Signature = hex.EncodeToString(ed25519.Sign(key, a.signedMessage()))
+	return a, nil
+}
+
+// AnchorStore appends an anchor to s covering the entries logged at or
+// before until since the latest anchor. It returns nil without writing when
+// there are none.
+func AnchorStore(s ipam.Store, key ed25519.PrivateKey, until time.Time) (*Anchor, error) {
+	entries, err := s.ListAuditEntries(0)
+	if err != nil {
+		return nil, err
+	}
+	anchor, err := NewAnchor(entries, until, key)
+	if err != nil || anchor == nil {
+		return nil, err
+	}
+
+	details, err := json.Marshal(anchor)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	err = s.SaveAuditEntry(&ipam.AuditEntry{
+		ID:        hex.EncodeToString(id),
+		Timestamp: until,
+		Action:    AnchorAction,
+		Resource:  "audit",
+		Details:   string(details),
+		User:      "system",
+	})
+	if err != nil {
+		return nil, err
+	}
+	return anchor, nil
+}
+
+// Run anchors the log of s every interval, up to AnchorLag ago, until stop
+// is closed, logging failures
+func Run(s ipam.Store, key ed25519.PrivateKey, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := AnchorStore(s, key, time.Now().Add(-AnchorLag)); err != nil {
+				log.Printf("audit anchor failed: %v", err)
+			}
+		}
+	}
+}
+
+// Report summarizes a verified log
+type Report struct {
+	// Entries is the number of entries in the log, anchors included
+	Entries int
+
+	// Anchors is the number of anchors verified
+	Anchors int
+
+	// Trimmed is the number of anchors that can no longer be verified
+	// because the oldest entries they cover were trimmed from the log
+	Trimmed int
+
+	// Verified is the number of entries, anchors included, the verified
+	// anchors vouch for
+	Verified int
+
+	// Unanchored is the number of entries logged after the latest verified
+	// anchor, which no anchor vouches for yet
+	Unanchored int
+
+	// LastAnchor is the Until of the latest verified anchor
+	LastAnchor time.Time
+}
+
+// Verify checks every anchor of entries against key and against the
+// segment of the log it covers. It returns ErrTampered, naming the first
+// bad anchor, when an anchored entry was altered, removed or inserted, when
+// an anchor was removed from between two others, or when an anchor was
+// forged.
+//
+// Entries are trimmed oldest first, so an anchor whose segment no longer
+// matches is counted as Trimmed rather than tampered when the log no longer
+// holds the oldest entry it covered nor anything before it. Removing the
+// latest anchors along with every entry after them leaves a shorter but
+// consistent log; check that LastAnchor is recent to detect it.
+func Verify(entries []*ipam.AuditEntry, key ed25519.PublicKey) (*Report, error) {
+	sorted := append([]*ipam.AuditEntry(nil), entries...)
+	Sort(sorted)
+
+	report := &Report{Entries: len(sorted)}
+	anchors, others, err := split(sorted)
+	if err != nil {
+		return report, err
+	}
+	var oldest time.Time
+	if len(sorted) > 0 {
+		oldest = sorted[0].Timestamp
+	}
+
+	byID := make(map[string]*Anchor, len(anchors))
+	for _, p := range anchors {
+		sig, err := hex.DecodeString(p.anchor.Signature)
+		if err != nil || !ed25519.Verify(key, p.anchor.signedMessage(), sig) {
+			return report, fmt.Errorf("%w: anchor %s has an invalid signature", ErrTampered, p.entry.ID)
+		}
+		byID[p.entry.ID] = p.anchor
+	}
+
+	// Segments overlap when servers anchor concurrently, so mark the
+	// entries covered rather than adding up the anchors' counts
+	covered := make(map[*ipam.AuditEntry]bool)
+	for _, p := range anchors {
+		a := p.anchor
+		if a.Prev != "" {
+			prev, ok := byID[a.Prev]
+			switch {
+			case !ok && !oldest.After(a.Since):
+				return report, fmt.Errorf("%w: anchor %s follows anchor %s, which is missing from the log",
+					ErrTampered, p.entry.ID, a.Prev)
+			case ok && prev.Head != a.PrevHead:
+				return report, fmt.Errorf("%w: anchor %s does not follow anchor %s", ErrTampered, p.entry.ID, a.Prev)
+			}
+		}
+
+		seg := segment(others, a.Since, a.Until)
+		if len(seg) != a.Count || chain(a.PrevHead, seg) != a.Head {
+			if oldest.After(a.First) {
+				report.Trimmed++
+				continue
+			}
+			return report, fmt.Errorf("%w: anchor %s covered %d entries from %s to %s but the log now has %d different ones",
+				ErrTampered, p.entry.ID, a.Count, a.First.Format(time.RFC3339), a.Until.Format(time.RFC3339), len(seg))
+		}
+		for _, entry := range seg {
+			covered[entry] = true
+		}
+		report.Anchors++
+		if a.Until.After(report.LastAnchor) {
+			report.LastAnchor = a.Until
+		}
+	}
+
+	report.Verified = len(covered) + report.Anchors
+	report.Unanchored = len(others) - len(segment(others, time.Time{}, report.LastAnchor))
+	return report, nil
+}
+
+// GenerateKey returns a new anchor signing key
+func GenerateKey() (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	return key, err
+}
+
+// MarshalPrivateKey encodes key as a PKCS #8 PEM block
+func MarshalPrivateKey(key ed25519.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// MarshalPublicKey encodes key as a PKIX PEM block
+func MarshalPublicKey(key ed25519.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// ParsePrivateKey decodes a PEM private key written by MarshalPrivateKey
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("expected a PEM encoded PRIVATE KEY")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an Ed25519 key")
+	}
+	return edKey, nil
+}
+
+// ParsePublicKey decodes a PEM public key written by MarshalPublicKey. A
+// private key is accepted too and yields its public half.
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block != nil && block.Type == "PRIVATE KEY" {
+		key, err := ParsePrivateKey(data)
+		if err != nil {
+			return nil, err
+		}
+		return key.Public().(ed25519.PublicKey), nil
+	}
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("expected a PEM encoded PUBLIC KEY")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not an Ed25519 key")
+	}
+	return edKey, nil
+}
@@ -0,0 +1,221 @@
+package auditlog
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store/pebbletest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func addEntries(t *testing.T, s ipam.Store, start time.Time, from, n int) {
+	for i := from; i < from+n; i++ {
+		require.NoError(t, s.SaveAuditEntry(&ipam.AuditEntry{
+			ID:        fmt.Sprintf("e%02d", i),
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+			Action:    "allocate",
+			Resource:  fmt.Sprintf("alloc%d", i),
+			Details:   fmt.Sprintf("10.0.0.%d", i),
+			User:      "system",
+		}))
+	}
+}
+
+func TestAnchorAndVerify(t *testing.T) {
+	s := pebbletest.New(t)
+	key, err := GenerateKey()
+	require.NoError(t, err)
+	pub := key.Public().(ed25519.PublicKey)
+
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	addEntries(t, s, start, 0, 3)
+
+	anchor, err := AnchorStore(s, key, start.Add(time.Minute))
+	require.NoError(t, err)
+	require.NotNil(t, anchor)
+	assert.Equal(t, 3, anchor.Count)
+
+	// Nothing new to anchor
+	again, err := AnchorStore(s, key, start.Add(2*time.Minute))
+	require.NoError(t, err)
+	assert.Nil(t, again)
+
+	addEntries(t, s, start.Add(time.Hour), 3, 2)
+	_, err = AnchorStore(s, key, start.Add(2*time.Hour))
+	require.NoError(t, err)
+	addEntries(t, s, start.Add(3*time.Hour), 5, 1)
+
+	entries, err := s.ListAuditEntries(0)
+	require.NoError(t, err)
+	report, err := Verify(entries, pub)
+	require.NoError(t, err)
+	assert.Equal(t, 8, report.Entries)
+	assert.Equal(t, 2, report.Anchors)
+	assert.Equal(t, 7, report.Verified)
+	assert.Equal(t, 1, report.Unanchored)
+	assert.Zero(t, report.Trimmed)
+	assert.True(t, report.LastAnchor.Equal(start.Add(2*time.Hour)))
+}
+
+func TestVerifyConcurrentAnchors(t *testing.T) {
+	s := pebbletest.New(t)
+	key, err := GenerateKey()
+	require.NoError(t, err)
+	pub := key.Public().(ed25519.PublicKey)
+
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	addEntries(t, s, start, 0, 3)
+	_, err = AnchorStore(s, key, start.Add(time.Minute))
+	require.NoError(t, err)
+	addEntries(t, s, start.Add(time.Minute), 3, 3)
+
+	// Two servers sign the same log, one a little further than the other,
+	// and their anchors land back to back
+	entries, err := s.ListAuditEntries(0)
+	require.NoError(t, err)
+	for i, until := range []time.Duration{4 * time.Second, 10 * time.Second} {
+		a, err := NewAnchor(entries, start.Add(time.Minute+until), key)
+		require.NoError(t, err)
+		require.NotNil(t, a)
+		require.NoError(t, s.SaveAuditEntry(&ipam.AuditEntry{
+			ID:        fmt.Sprintf("anchor%d", i),
+			Timestamp: start.Add(2 * time.Minute),
+			Action:    AnchorAction,
+			Details:   mustJSON(t, a),
+		}))
+	}
+	addEntries(t, s, start.Add(2*time.Minute), 6, 1)
+	_, err = AnchorStore(s, key, start.Add(time.Hour))
+	require.NoError(t, err)
+
+	entries, err = s.ListAuditEntries(0)
+	require.NoError(t, err)
+	report, err := Verify(entries, pub)
+	require.NoError(t, err)
+	assert.Equal(t, 4, report.Anchors)
+	assert.Equal(t, 11, report.Verified)
+	assert.Zero(t, report.Unanchored)
+}
+
+func TestVerifyTrimmed(t *testing.T) {
+	s := pebbletest.New(t)
+	key, err := GenerateKey()
+	require.NoError(t, err)
+	pub := key.Public().(ed25519.PublicKey)
+
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		addEntries(t, s, start.Add(time.Duration(i)*time.Hour), 3*i, 3)
+		_, err = AnchorStore(s, key, start.Add(time.Duration(i)*time.Hour+time.Minute))
+		require.NoError(t, err)
+	}
+	entries, err := s.ListAuditEntries(0)
+	require.NoError(t, err)
+	Sort(entries)
+	require.Len(t, entries, 12)
+
+	// Trimming the first segment, its anchor and part of the second
+	// segment leaves the second anchor unverifiable but not tampered
+	report, err := Verify(entries[5:], pub)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Trimmed)
+	assert.Equal(t, 1, report.Anchors)
+	assert.Equal(t, 4, report.Verified)
+	assert.Zero(t, report.Unanchored)
+
+	// Removing the middle anchor is not trimming
+	_, err = Verify(append(append([]*ipam.AuditEntry(nil), entries[:7]...), entries[8:]...), pub)
+	assert.True(t, errors.Is(err, ErrTampered), "got %v", err)
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	s := pebbletest.New(t)
+	key, err := GenerateKey()
+	require.NoError(t, err)
+	pub := key.Public().(ed25519.PublicKey)
+
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	addEntries(t, s, start, 0, 4)
+	_, err = AnchorStore(s, key, start.Add(time.Hour))
+	require.NoError(t, err)
+
+	entries, err := s.ListAuditEntries(0)
+	require.NoError(t, err)
+	Sort(entries)
+
+	tamper := func(name string, mutate func([]*ipam.AuditEntry) []*ipam.AuditEntry) {
+		t.Run(name, func(t *testing.T) {
+			copied := make([]*ipam.AuditEntry, len(entries))
+			for i, e := range entries {
+				c := *e
+				copied[i] = &c
+			}
+			_, err := Verify(mutate(copied), pub)
+			assert.True(t, errors.Is(err, ErrTampered), "got %v", err)
+		})
+	}
+
+	tamper("Altered", func(e []*ipam.AuditEntry) []*ipam.AuditEntry {
+		e[1].Details = "10.0.0.99"
+		return e
+	})
+	tamper("Removed", func(e []*ipam.AuditEntry) []*ipam.AuditEntry {
+		return append(e[:2], e[3:]...)
+	})
+	tamper("Inserted", func(e []*ipam.AuditEntry) []*ipam.AuditEntry {
+		return append(e, &ipam.AuditEntry{ID: "x", Timestamp: start.Add(1500 * time.Millisecond), Action: "release"})
+	})
+	tamper("Forged", func(e []*ipam.AuditEntry) []*ipam.AuditEntry {
+		e[1].Details = "10.0.0.99"
+		other, _ := GenerateKey()
+		forged, err := NewAnchor(e[:len(e)-1], start.Add(time.Hour), other)
+		require.NoError(t, err)
+		e[len(e)-1].Details = mustJSON(t, forged)
+		return e
+	})
+
+	// Entries after the last anchor are not covered, so changing them is
+	// not detectable yet
+	_, err = Verify(append(entries, &ipam.AuditEntry{ID: "late", Timestamp: start.Add(2 * time.Hour)}), pub)
+	assert.NoError(t, err)
+}
+
+func TestKeyEncoding(t *testing.T) {
+	key, err := GenerateKey()
+	require.NoError(t, err)
+
+	privPEM, err := MarshalPrivateKey(key)
+	require.NoError(t, err)
+	pubPEM, err := MarshalPublicKey(key.Public().(ed25519.PublicKey))
+	require.NoError(t, err)
+
+	parsed, err := ParsePrivateKey(privPEM)
+	require.NoError(t, err)
+	assert.True(t, key.Equal(parsed))
+
+	pub, err := ParsePublicKey(pubPEM)
+	require.NoError(t, err)
+	assert.True(t, key.Public().(ed25519.PublicKey).Equal(pub))
+
+	// A private key file also works for verification
+	pub, err = ParsePublicKey(privPEM)
+	require.NoError(t, err)
+	assert.True(t, key.Public().(ed25519.PublicKey).Equal(pub))
+
+	_, err = ParsePrivateKey(pubPEM)
+	assert.Error(t, err)
+	_, err = ParsePublicKey([]byte("not a key"))
+	assert.Error(t, err)
+}
+
+func mustJSON(t *testing.T, v interface{}) string {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return string(data)
+}
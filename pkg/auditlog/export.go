@@ -0,0 +1,63 @@
+package auditlog
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// Export formats: one JSON object per line, or CSV with a header row
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+)
+
+// csvHeader names the CSV columns, in AuditEntry order
+var csvHeader = []string{"id", "timestamp", "action", "resource", "details", "user"}
+
+// Export writes the audit entries of s logged at or after since to w in
+// format, oldest first, for ingestion by other systems. Entries are written
+// as they are read, so the log never has to fit in memory. It returns the
+// number of entries written.
+func Export(s ipam.Store, w io.Writer, format string, since time.Time) (int, error) {
+	var write func(*ipam.AuditEntry) error
+	var flush func() error
+	switch format {
+	case FormatJSONL:
+		enc := json.NewEncoder(w)
+		write = func(e *ipam.AuditEntry) error { return enc.Encode(e) }
+		flush = func() error { return nil }
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return 0, err
+		}
+		write = func(e *ipam.AuditEntry) error {
+			return cw.Write([]string{e.ID, e.Timestamp.UTC().Format(time.RFC3339Nano), e.Action, e.Resource, e.Details, e.User})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		return 0, fmt.Errorf("unknown export format %q: use %s or %s", format, FormatJSONL, FormatCSV)
+	}
+
+	n := 0
+	err := store.ScanAuditEntries(s, since, func(e *ipam.AuditEntry) error {
+		if err := write(e); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	if ferr := flush(); err == nil {
+		err = ferr
+	}
+	return n, err
+}
@@ -0,0 +1,50 @@
+package auditlog
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store/pebbletest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExport(t *testing.T) {
+	s := pebbletest.New(t)
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	addEntries(t, s, start, 0, 3)
+	require.NoError(t, s.SaveAuditEntry(&ipam.AuditEntry{
+		ID: "e03", Timestamp: start.Add(3 * time.Second), Action: "network_add", Details: "a, \"quoted\"\nvalue",
+	}))
+
+	var buf bytes.Buffer
+	n, err := Export(s, &buf, FormatJSONL, start.Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	var entry ipam.AuditEntry
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "e01", entry.ID)
+	require.NoError(t, json.Unmarshal([]byte(lines[2]), &entry))
+	assert.Equal(t, "a, \"quoted\"\nvalue", entry.Details)
+
+	buf.Reset()
+	n, err = Export(s, &buf, FormatCSV, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 5)
+	assert.Equal(t, []string{"id", "timestamp", "action", "resource", "details", "user"}, records[0])
+	assert.Equal(t, []string{"e00", "2026-10-16T12:00:00Z", "allocate", "alloc0", "10.0.0.0", "system"}, records[1])
+	assert.Equal(t, "a, \"quoted\"\nvalue", records[4][4])
+
+	_, err = Export(s, &buf, "xml", time.Time{})
+	assert.Error(t, err)
+}
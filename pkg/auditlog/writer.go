@@ -0,0 +1,171 @@
+package auditlog
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// DefaultQueueSize is how many audit entries a Writer queues before writers
+// wait for the store
+const DefaultQueueSize = 1024
+
+// maxWriteBatch bounds how many queued entries are written at once
+const maxWriteBatch = 256
+
+// writeAttempts bounds how often a batch is written before its entries are
+// given up on, e.g. while a Raft cluster has no leader
+const writeAttempts = 5
+
+// Writer saves audit entries in the background, so that a request does not
+// wait for its audit entry to be stored, a Raft proposal of its own in
+// cluster mode. Queued entries are written together, in one batch when the
+// store supports it.
+//
+// A Writer is itself a store wrapping the one it writes to. SaveAuditEntry
+// queues the entry and always succeeds; ListAuditEntries flushes the queue
+// first, so that the log reads as if every entry was written immediately.
+// The queue is bounded: when it is full, SaveAuditEntry waits for room
+// rather than dropping the entry. Entries the store keeps refusing are
+// logged and lost, which is why compliance setups should write
+// synchronously instead.
+type Writer struct {
+	ipam.Store
+
+	queue   chan *ipam.AuditEntry
+	flushes chan chan struct{}
+	done    chan struct{}
+
+	// mu is held for reading while queuing and for writing to close the
+	// queue, so that no entry is queued after Close
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewWriter starts writing the audit entries saved to it to s, queuing up
+// to size entries; DefaultQueueSize when size is not positive. Close it to
+// write the queued entries before exiting.
+func NewWriter(s ipam.Store, size int) *Writer {
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	w := &Writer{
+		Store:   s,
+		queue:   make(chan *ipam.AuditEntry, size),
+		flushes: make(chan chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// SaveAuditEntry queues entry, waiting while the queue is full. Once the
+// Writer is closed, entries are written immediately.
+func (w *Writer) SaveAuditEntry(entry *ipam.AuditEntry) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return w.Store.SaveAuditEntry(entry)
+	}
+	w.queue <- entry
+	return nil
+}
+
+// ListAuditEntries flushes the queue and lists the stored entries
+func (w *Writer) ListAuditEntries(limit int) ([]*ipam.AuditEntry, error) {
+	w.Flush()
+	return w.Store.ListAuditEntries(limit)
+}
+
+// Flush returns once the entries queued before it was called are written,
+// or given up on
+func (w *Writer) Flush() {
+	ack := make(chan struct{})
+	select {
+	case w.flushes <- ack:
+		<-ack
+	case <-w.done:
+	}
+}
+
+// Close writes the queued entries and stops the background writer
+func (w *Writer) Close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+	<-w.done
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+	for {
+		select {
+		case entry, ok := <-w.queue:
+			if !ok {
+				return
+			}
+			w.write(w.drain([]*ipam.AuditEntry{entry}))
+		case ack := <-w.flushes:
+			for batch := w.drain(nil); len(batch) > 0; batch = w.drain(nil) {
+				w.write(batch)
+			}
+			close(ack)
+		}
+	}
+}
+
+// drain appends the entries waiting in the queue to batch, up to
+// maxWriteBatch, without waiting for more
+func (w *Writer) drain(batch []*ipam.AuditEntry) []*ipam.AuditEntry {
+	for len(batch) < maxWriteBatch {
+		select {
+		case entry, ok := <-w.queue:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, entry)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// write stores entries, retrying failures with a growing delay. A failure
+// may come after the entries were stored, e.g. a Raft proposal that timed
+// out but was committed; the stores keep an entry written twice once, by
+// its ID, so retrying is safe.
+func (w *Writer) write(entries []*ipam.AuditEntry) {
+	applier, batches := w.Store.(interface{ ApplyBatch(*store.Batch) error })
+	delay := 50 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		var err error
+		if batches {
+			err = applier.ApplyBatch(&store.Batch{AuditEntries: entries})
+		} else {
+			for i, entry := range entries {
+				if err = w.Store.SaveAuditEntry(entry); err != nil {
+					entries = entries[i:]
+					break
+				}
+			}
+		}
+		if err == nil {
+			return
+		}
+		if attempt == writeAttempts {
+			for _, entry := range entries {
+				log.Printf("audit entry lost: %s %s %s by %s: %v", entry.Action, entry.Resource, entry.Details, entry.User, err)
+			}
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
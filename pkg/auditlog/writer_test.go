@@ -0,0 +1,63 @@
+package auditlog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/jeremyhahn/go-ipam/pkg/store/pebbletest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gatedStore holds batches back until its gate is opened
+type gatedStore struct {
+	*store.PebbleStore
+	gate    chan struct{}
+	batches int
+}
+
+func (s *gatedStore) ApplyBatch(b *store.Batch) error {
+	<-s.gate
+	s.batches++
+	return s.PebbleStore.ApplyBatch(b)
+}
+
+func TestWriter(t *testing.T) {
+	s := pebbletest.New(t)
+	w := NewWriter(s, 4)
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	addEntries(t, w, start, 0, 10)
+
+	// Listing through the writer sees every entry saved before
+	entries, err := w.ListAuditEntries(0)
+	require.NoError(t, err)
+	assert.Len(t, entries, 10)
+
+	// Once closed, entries are written immediately
+	w.Close()
+	addEntries(t, w, start, 10, 1)
+	entries, err = s.ListAuditEntries(0)
+	require.NoError(t, err)
+	assert.Len(t, entries, 11)
+}
+
+func TestWriterOffRequestPath(t *testing.T) {
+	s := &gatedStore{PebbleStore: pebbletest.New(t), gate: make(chan struct{})}
+	w := NewWriter(s, 8)
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	// Saving returns while the store is still busy
+	addEntries(t, w, start, 0, 5)
+	entries, err := s.PebbleStore.ListAuditEntries(0)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// Closing writes what is queued, the waiting entries in one batch
+	close(s.gate)
+	w.Close()
+	entries, err = s.PebbleStore.ListAuditEntries(0)
+	require.NoError(t, err)
+	assert.Len(t, entries, 5)
+	assert.LessOrEqual(t, s.batches, 2)
+}
@@ -0,0 +1,304 @@
+// Package client sends requests for the IPAM REST API to any of several
+// servers, e.g. the nodes of a cluster, so callers need no load balancer in
+// front of them.
+//
+// Requests go to the healthy servers in turn. A server that cannot be
+// reached, or that answers 503 because it cannot serve right now (a cluster
+// node without a leader, a standby refusing writes), is skipped for
+// RetryAfter or until a health check finds it ready again, and the request
+// is retried on the next server. Redirects, e.g. to a cluster's leader, are
+// followed.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultRetryAfter is how long a failed server is skipped when no health
+// check finds it ready sooner
+const DefaultRetryAfter = 30 * time.Second
+
+// readyzPath is the servers' readiness probe
+const readyzPath = "/readyz"
+
+// ErrNoServers is returned when no server could serve a request
+var ErrNoServers = errors.New("no server could serve the request")
+
+// Client sends API requests to one of several servers
+type Client struct {
+	// Token is sent as a bearer token when the servers require one
+	Token string
+
+	// HTTPClient sends each attempt; nil uses http.DefaultClient. Its
+	// timeout bounds each server tried, not the whole request.
+	HTTPClient *http.Client
+
+	// RetryAfter is how long a failed server is skipped; 0 is
+	// DefaultRetryAfter
+	RetryAfter time.Duration
+
+	servers []*server
+	next    atomic.Uint64
+}
+
+// server is one of the client's servers and its health
+type server struct {
+	base *url.URL
+
+	mu        sync.Mutex
+	downUntil time.Time
+	lastErr   error
+}
+
+// Server reports the health of one of a client's servers
+type Server struct {
+	URL       string `json:"url"`
+	Healthy   bool   `json:"healthy"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// New returns a client for the servers' base URLs, e.g.
+// http://node1.example.com:8080
+func New(servers []string) (*Client, error) {
+	if len(servers) == 0 {
+		return nil, errors.New("no servers")
+	}
+	c := &Client{}
+	for _, s := range servers {
+		u, err := url.Parse(strings.TrimSuffix(s, "/"))
+		if err != nil {
+			return nil, fmt.Errorf("server %q: %w", s, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("server %q: want a URL like http://host:port", s)
+		}
+		c.servers = append(c.servers, &server{base: u})
+	}
+	return c, nil
+}
+
+// ParseServers splits a comma-separated list of server URLs, as given on
+// the command line
+func ParseServers(list string) []string {
+	var servers []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			servers = append(servers, s)
+		}
+	}
+	return servers
+}
+
+// Do sends req, whose URL is a path such as /api/v1/networks, to the next
+// healthy server, failing over to the others as needed. A request that
+// may have reached a server is only retried elsewhere when repeating it is
+// harmless: its method is idempotent or the server answered 503. When
+// every server answers 503 the last response is returned.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return nil, errors.New("request body cannot be resent to another server; create the request with http.NewRequest")
+	}
+
+	candidates := c.order()
+	var lastErr error
+	for i, s := range candidates {
+		target := *s.base
+		target.Path += req.URL.Path
+		target.RawQuery = req.URL.RawQuery
+		attempt := req.Clone(req.Context())
+		attempt.URL = &target
+		attempt.Host = ""
+		if i > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attempt.Body = body
+		}
+		if c.Token != "" {
+			attempt.Header.Set("Authorization", "Bearer "+c.Token)
+		}
+
+		resp, err := c.httpClient().Do(attempt)
+		if err != nil {
+			s.failed(err, c.retryAfter())
+			if req.Context().Err() != nil || !resendable(req.Method, err) {
+				return nil, err
+			}
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode == http.StatusServiceUnavailable && i < len(candidates)-1 {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			s.failed(fmt.Errorf("%s", resp.Status), c.retryAfter())
+			continue
+		}
+		s.succeeded()
+		return resp, nil
+	}
+	return nil, fmt.Errorf("%w: %v", ErrNoServers, lastErr)
+}
+
+// GetJSON decodes the response to a GET of path into v, turning API errors
+// into their message
+func (c *Client) GetJSON(path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("GET %s: %s", path, apiErr.Message)
+		}
+		return fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Check probes every server's readiness and records which can serve
+func (c *Client) Check(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, s := range c.servers {
+		wg.Add(1)
+		go func(s *server) {
+			defer wg.Done()
+			if err := c.probe(ctx, s); err != nil {
+				s.failed(err, c.retryAfter())
+				return
+			}
+			s.succeeded()
+		}(s)
+	}
+	wg.Wait()
+}
+
+// Run checks the servers' health every interval until stop is closed
+func (c *Client) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			c.Check(ctx)
+			cancel()
+		}
+	}
+}
+
+// Servers reports the servers' health as last seen
+func (c *Client) Servers() []Server {
+	now := time.Now()
+	servers := make([]Server, len(c.servers))
+	for i, s := range c.servers {
+		s.mu.Lock()
+		servers[i] = Server{URL: s.base.String(), Healthy: !now.Before(s.downUntil)}
+		if s.lastErr != nil {
+			servers[i].LastError = s.lastErr.Error()
+		}
+		s.mu.Unlock()
+	}
+	return servers
+}
+
+// order lists the servers to try: the healthy ones in turn, starting from
+// the next in the rotation, then the failed ones as a last resort
+func (c *Client) order() []*server {
+	start := int((c.next.Add(1) - 1) % uint64(len(c.servers)))
+	now := time.Now()
+	var healthy, failed []*server
+	for i := range c.servers {
+		s := c.servers[(start+i)%len(c.servers)]
+		s.mu.Lock()
+		down := now.Before(s.downUntil)
+		s.mu.Unlock()
+		if down {
+			failed = append(failed, s)
+		} else {
+			healthy = append(healthy, s)
+		}
+	}
+	return append(healthy, failed...)
+}
+
+// probe asks s whether it is ready to serve
+func (c *Client) probe(ctx context.Context, s *server) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.base.String()+readyzPath, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", readyzPath, resp.Status)
+	}
+	return nil
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (c *Client) retryAfter() time.Duration {
+	if c.RetryAfter > 0 {
+		return c.RetryAfter
+	}
+	return DefaultRetryAfter
+}
+
+func (s *server) failed(err error, retryAfter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.downUntil = time.Now().Add(retryAfter)
+	s.lastErr = err
+}
+
+func (s *server) succeeded() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.downUntil = time.Time{}
+	s.lastErr = nil
+}
+
+// resendable reports whether a request that failed with err may be sent
+// to another server. Idempotent requests always may; others only when the
+// connection was never made, so the failed server cannot have acted on it.
+func resendable(method string, err error) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
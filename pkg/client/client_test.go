@@ -0,0 +1,189 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServer answers like one API server, ready or not, counting the
+// requests other than probes
+type fakeServer struct {
+	name     string
+	ready    atomic.Bool
+	requests atomic.Int32
+	bodies   []string
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == readyzPath {
+		if !f.ready.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		return
+	}
+	f.requests.Add(1)
+	if !f.ready.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"code":"not_ready","message":"no leader"}`))
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	f.bodies = append(f.bodies, string(body))
+	w.Header().Set("X-Server", f.name)
+	w.Write([]byte(`{"server":"` + f.name + `","auth":"` + r.Header.Get("Authorization") + `","query":"` + r.URL.RawQuery + `"}`))
+}
+
+func startServers(t *testing.T, names ...string) ([]*fakeServer, []string) {
+	var fakes []*fakeServer
+	var urls []string
+	for _, name := range names {
+		f := &fakeServer{name: name}
+		f.ready.Store(true)
+		srv := httptest.NewServer(f)
+		t.Cleanup(srv.Close)
+		fakes = append(fakes, f)
+		urls = append(urls, srv.URL)
+	}
+	return fakes, urls
+}
+
+// deadURL returns the URL of a port nothing listens on
+func deadURL(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	url := "http://" + ln.Addr().String()
+	ln.Close()
+	return url
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(nil)
+	assert.Error(t, err)
+	_, err = New([]string{"node1:8080"})
+	assert.Error(t, err)
+	_, err = New([]string{"ftp://node1"})
+	assert.Error(t, err)
+
+	c, err := New([]string{"http://node1:8080/", "https://node2:8443"})
+	require.NoError(t, err)
+	assert.Equal(t, []Server{
+		{URL: "http://node1:8080", Healthy: true},
+		{URL: "https://node2:8443", Healthy: true},
+	}, c.Servers())
+
+	assert.Equal(t, []string{"http://a:1", "http://b:2"}, ParseServers(" http://a:1, ,http://b:2,"))
+	assert.Nil(t, ParseServers(""))
+}
+
+func TestRoundRobin(t *testing.T) {
+	fakes, urls := startServers(t, "a", "b", "c")
+	c, err := New(urls)
+	require.NoError(t, err)
+	c.Token = "s3cret"
+
+	for i := 0; i < 6; i++ {
+		var resp map[string]string
+		require.NoError(t, c.GetJSON("/api/v1/networks?limit=5", &resp))
+		assert.Equal(t, "Bearer s3cret", resp["auth"])
+		assert.Equal(t, "limit=5", resp["query"])
+	}
+	for _, f := range fakes {
+		assert.Equal(t, int32(2), f.requests.Load(), f.name)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	fakes, urls := startServers(t, "a", "b")
+	c, err := New(append([]string{deadURL(t)}, urls...))
+	require.NoError(t, err)
+
+	// Unreachable and unready servers are skipped, even for writes
+	fakes[0].ready.Store(false)
+	req, err := http.NewRequest(http.MethodPost, "/api/v1/networks", bytes.NewBufferString(`{"cidr":"10.0.0.0/24"}`))
+	require.NoError(t, err)
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "b", resp.Header.Get("X-Server"))
+	assert.Equal(t, []string{`{"cidr":"10.0.0.0/24"}`}, fakes[1].bodies)
+
+	servers := c.Servers()
+	assert.False(t, servers[0].Healthy)
+	assert.NotEmpty(t, servers[0].LastError)
+	assert.False(t, servers[1].Healthy)
+	assert.True(t, servers[2].Healthy)
+
+	// Failed servers are not tried again until they recover
+	fakes[0].ready.Store(true)
+	before := fakes[0].requests.Load()
+	for i := 0; i < 3; i++ {
+		var v map[string]string
+		require.NoError(t, c.GetJSON("/api/v1/networks", &v))
+		assert.Equal(t, "b", v["server"])
+	}
+	assert.Equal(t, before, fakes[0].requests.Load())
+
+	c.Check(context.Background())
+	servers = c.Servers()
+	assert.False(t, servers[0].Healthy)
+	assert.True(t, servers[1].Healthy)
+	assert.True(t, servers[2].Healthy)
+}
+
+func TestAllServersUnavailable(t *testing.T) {
+	fakes, urls := startServers(t, "a", "b")
+	for _, f := range fakes {
+		f.ready.Store(false)
+	}
+	c, err := New(urls)
+	require.NoError(t, err)
+
+	// The last server's answer is returned
+	var v map[string]string
+	err = c.GetJSON("/api/v1/networks", &v)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no leader")
+
+	c, err = New([]string{deadURL(t)})
+	require.NoError(t, err)
+	err = c.GetJSON("/api/v1/networks", &v)
+	assert.ErrorIs(t, err, ErrNoServers)
+}
+
+func TestRedirectToLeader(t *testing.T) {
+	fakes, urls := startServers(t, "leader")
+	follower := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, urls[0]+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer follower.Close()
+
+	c, err := New([]string{follower.URL})
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, "/api/v1/allocations", bytes.NewBufferString(`{"network_id":"n1"}`))
+	require.NoError(t, err)
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "leader", resp.Header.Get("X-Server"))
+	assert.Equal(t, []string{`{"network_id":"n1"}`}, fakes[0].bodies)
+}
+
+func TestResendable(t *testing.T) {
+	dial := &net.OpError{Op: "dial", Err: io.EOF}
+	read := &net.OpError{Op: "read", Err: io.EOF}
+	assert.True(t, resendable(http.MethodGet, read))
+	assert.True(t, resendable(http.MethodDelete, read))
+	assert.True(t, resendable(http.MethodPost, dial))
+	assert.False(t, resendable(http.MethodPost, read))
+	assert.False(t, resendable(http.MethodPatch, io.ErrUnexpectedEOF))
+}
@@ -0,0 +1,26 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+	assert.Equal(t, start, f.Now())
+
+	assert.Equal(t, start.Add(90*time.Second), f.Advance(90*time.Second))
+	assert.Equal(t, start.Add(90*time.Second), f.Now())
+
+	f.Set(start)
+	assert.Equal(t, start, f.Now())
+}
+
+func TestSystem(t *testing.T) {
+	before := time.Now()
+	now := System.Now()
+	assert.False(t, now.Before(before))
+}
@@ -0,0 +1,233 @@
+// Package cloudinit renders the network configuration of an allocation for
+// provisioning: a network-config version 2 document, which netplan and
+// cloud-init both read, or a #cloud-config snippet setting the hostname.
+//
+// The gateway is the address of the network's allocation tagged gateway.
+// Name servers come from the network's dns=<address> tags and the search
+// domain from its domain=<name> tag.
+package cloudinit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/neighbor"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats
+const (
+	FormatNetworkConfig = "network-config"
+	FormatNetplan       = "netplan"
+	FormatCloudConfig   = "cloud-config"
+)
+
+// DNSTagPrefix names a name server of a network; networks may carry
+// several
+const DNSTagPrefix = store.DNSTagPrefix
+
+// DefaultInterface is the interface configured when none is given
+const DefaultInterface = "eth0"
+
+var (
+	// ErrRange is returned for allocations of an address range, which
+	// have no single address to configure
+	ErrRange = errors.New("range allocations have no single address to configure")
+
+	// ErrInactive is returned for released and expired allocations
+	ErrInactive = errors.New("allocation is not active")
+)
+
+// Config is the network configuration of one interface
+type Config struct {
+	Interface string
+
+	// MAC, when set, matches the interface by address and renames it to
+	// Interface
+	MAC string
+
+	// Address is the allocated address with the network's prefix length
+	Address netip.Prefix
+	Gateway netip.Addr
+
+	Nameservers []netip.Addr
+	Search      []string
+
+	Hostname string
+	FQDN     string
+}
+
+// New returns the configuration of alloc, an active single-address
+// allocation of network; allocations are the network's allocations, which
+// the gateway is looked up in
+func New(network *ipam.Network, allocations []*ipam.IPAllocation, alloc *ipam.IPAllocation, iface string, now time.Time) (*Config, error) {
+	if alloc.EndIP != "" && alloc.EndIP != alloc.IP {
+		return nil, ErrRange
+	}
+	if store.AllocationStatus(alloc, now) != store.StatusActive {
+		return nil, ErrInactive
+	}
+	prefix, err := netip.ParsePrefix(network.CIDR)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q: %w", network.CIDR, err)
+	}
+	addr, err := netip.ParseAddr(alloc.IP)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP %q: %w", alloc.IP, err)
+	}
+	addr = addr.Unmap()
+
+	if iface == "" {
+		iface = DefaultInterface
+	}
+	c := &Config{Interface: iface, Address: netip.PrefixFrom(addr, prefix.Bits()), Hostname: alloc.Hostname}
+	for _, tag := range alloc.Tags {
+		if value, ok := strings.CutPrefix(tag, neighbor.MACTagPrefix); ok {
+			if mac, err := neighbor.NormalizeMAC(value); err == nil {
+				c.MAC = mac
+			}
+		}
+	}
+
+	var domain string
+	for _, tag := range network.Tags {
+		if value, ok := strings.CutPrefix(tag, DNSTagPrefix); ok {
+			if ns, err := netip.ParseAddr(value); err == nil && !slices.Contains(c.Nameservers, ns.Unmap()) {
+				c.Nameservers = append(c.Nameservers, ns.Unmap())
+			}
+		}
+		if value, ok := strings.CutPrefix(tag, store.DomainTagPrefix); ok {
+			domain = value
+		}
+	}
+	if domain != "" {
+		c.Search = []string{domain}
+	}
+	if host, _, _ := strings.Cut(c.Hostname, "."); host != c.Hostname {
+		c.Hostname, c.FQDN = host, alloc.Hostname
+	} else if c.Hostname != "" && domain != "" {
+		c.FQDN = c.Hostname + "." + domain
+	}
+
+	for _, other := range allocations {
+		if other.ID == alloc.ID || other.EndIP != "" || !slices.Contains(other.Tags, store.GatewayTag) ||
+			store.AllocationStatus(other, now) != store.StatusActive {
+			continue
+		}
+		if gw, err := netip.ParseAddr(other.IP); err == nil && gw.Is4() == addr.Is4() {
+			c.Gateway = gw.Unmap()
+			break
+		}
+	}
+	return c, nil
+}
+
+// networkConfig is a network-config version 2 document
+type networkConfig struct {
+	Network struct {
+		Version   int                 `yaml:"version"`
+		Ethernets map[string]ethernet `yaml:"ethernets"`
+	} `yaml:"network"`
+}
+
+type ethernet struct {
+	Match       *match       `yaml:"match,omitempty"`
+	SetName     string       `yaml:"set-name,omitempty"`
+	DHCP4       *bool        `yaml:"dhcp4,omitempty"`
+	DHCP6       *bool        `yaml:"dhcp6,omitempty"`
+	Addresses   []string     `yaml:"addresses"`
+	Routes      []route      `yaml:"routes,omitempty"`
+	Nameservers *nameservers `yaml:"nameservers,omitempty"`
+}
+
+type match struct {
+	MACAddress string `yaml:"macaddress"`
+}
+
+type route struct {
+	To  string `yaml:"to"`
+	Via string `yaml:"via"`
+}
+
+type nameservers struct {
+	Addresses []string `yaml:"addresses,omitempty"`
+	Search    []string `yaml:"search,omitempty"`
+}
+
+// RenderNetworkConfig writes c as a network-config version 2 document
+func (c *Config) RenderNetworkConfig(w io.Writer) error {
+	off := false
+	e := ethernet{Addresses: []string{c.Address.String()}}
+	if c.Address.Addr().Is4() {
+		e.DHCP4 = &off
+	} else {
+		e.DHCP6 = &off
+	}
+	if c.MAC != "" {
+		e.Match = &match{MACAddress: c.MAC}
+		e.SetName = c.Interface
+	}
+	if c.Gateway.IsValid() {
+		e.Routes = []route{{To: "default", Via: c.Gateway.String()}}
+	}
+	if len(c.Nameservers) > 0 || len(c.Search) > 0 {
+		e.Nameservers = &nameservers{Search: c.Search}
+		for _, ns := range c.Nameservers {
+			e.Nameservers.Addresses = append(e.Nameservers.Addresses, ns.String())
+		}
+	}
+
+	var doc networkConfig
+	doc.Network.Version = 2
+	doc.Network.Ethernets = map[string]ethernet{c.Interface: e}
+	return encode(w, "", doc)
+}
+
+// cloudConfig is the part of a #cloud-config document RenderCloudConfig
+// writes
+type cloudConfig struct {
+	Hostname       string `yaml:"hostname,omitempty"`
+	FQDN           string `yaml:"fqdn,omitempty"`
+	PreferFQDN     bool   `yaml:"prefer_fqdn_over_hostname,omitempty"`
+	ManageEtcHosts bool   `yaml:"manage_etc_hosts"`
+}
+
+// RenderCloudConfig writes the hostname and /etc/hosts settings of c as
+// #cloud-config user data; addresses and name servers belong in
+// network-config
+func (c *Config) RenderCloudConfig(w io.Writer) error {
+	doc := cloudConfig{Hostname: c.Hostname, FQDN: c.FQDN, PreferFQDN: c.FQDN != "", ManageEtcHosts: c.Hostname != ""}
+	return encode(w, "#cloud-config\n", doc)
+}
+
+// Render writes c in format: network-config (or netplan) or cloud-config
+func (c *Config) Render(w io.Writer, format string) error {
+	switch format {
+	case FormatNetworkConfig, FormatNetplan, "":
+		return c.RenderNetworkConfig(w)
+	case FormatCloudConfig:
+		return c.RenderCloudConfig(w)
+	default:
+		return fmt.Errorf("unknown format %q: use %s, %s or %s", format, FormatNetworkConfig, FormatNetplan, FormatCloudConfig)
+	}
+}
+
+// encode writes header and v as YAML indented by two spaces
+func encode(w io.Writer, header string, v any) error {
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	return enc.Close()
+}
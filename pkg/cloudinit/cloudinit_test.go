@@ -0,0 +1,79 @@
+package cloudinit
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	network := &ipam.Network{ID: "lan", CIDR: "192.168.10.0/24", Tags: []string{"dns=192.168.10.53", "dns=1.1.1.1", "domain=lab.example.com", "dns=bogus"}}
+	web := &ipam.IPAllocation{ID: "web", IP: "192.168.10.20", Hostname: "web01", Tags: []string{"mac=BC-24-11-00-00-01"}}
+	allocations := []*ipam.IPAllocation{
+		{ID: "gw6", IP: "fd00::1", Tags: []string{"gateway"}},
+		{ID: "old", IP: "192.168.10.2", Tags: []string{"gateway"}, ReleasedAt: &now},
+		{ID: "gw", IP: "192.168.10.1", Tags: []string{"gateway"}},
+		web,
+	}
+
+	c, err := New(network, allocations, web, "", now)
+	require.NoError(t, err)
+	var sb strings.Builder
+	require.NoError(t, c.Render(&sb, FormatNetplan))
+	assert.Equal(t, `network:
+  version: 2
+  ethernets:
+    eth0:
+      match:
+        macaddress: bc:24:11:00:00:01
+      set-name: eth0
+      dhcp4: false
+      addresses:
+        - 192.168.10.20/24
+      routes:
+        - to: default
+          via: 192.168.10.1
+      nameservers:
+        addresses:
+          - 192.168.10.53
+          - 1.1.1.1
+        search:
+          - lab.example.com
+`, sb.String())
+
+	sb.Reset()
+	require.NoError(t, c.Render(&sb, FormatCloudConfig))
+	assert.Equal(t, `#cloud-config
+hostname: web01
+fqdn: web01.lab.example.com
+prefer_fqdn_over_hostname: true
+manage_etc_hosts: true
+`, sb.String())
+
+	// A bare IPv6 allocation without a gateway or name servers
+	v6 := &ipam.IPAllocation{ID: "v6", IP: "2001:db8::10"}
+	c, err = New(&ipam.Network{CIDR: "2001:db8::/64"}, []*ipam.IPAllocation{v6}, v6, "ens3", now)
+	require.NoError(t, err)
+	sb.Reset()
+	require.NoError(t, c.Render(&sb, ""))
+	assert.Equal(t, "network:\n  version: 2\n  ethernets:\n    ens3:\n      dhcp6: false\n      addresses:\n        - 2001:db8::10/64\n", sb.String())
+	assert.Error(t, c.Render(&sb, "ifupdown"))
+
+	_, err = New(network, allocations, &ipam.IPAllocation{IP: "192.168.10.100", EndIP: "192.168.10.150"}, "", now)
+	assert.ErrorIs(t, err, ErrRange)
+	_, err = New(network, allocations, allocations[1], "", now)
+	assert.ErrorIs(t, err, ErrInactive)
+}
+
+func TestHostname(t *testing.T) {
+	alloc := &ipam.IPAllocation{IP: "10.0.0.5", Hostname: "db01.corp.example.com"}
+	c, err := New(&ipam.Network{CIDR: "10.0.0.0/24", Tags: []string{"domain=lab.example.com"}}, nil, alloc, "", time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, "db01", c.Hostname)
+	assert.Equal(t, "db01.corp.example.com", c.FQDN)
+}
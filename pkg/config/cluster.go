@@ -10,29 +10,41 @@ import (
 // ClusterConfig holds configuration for cluster mode
 type ClusterConfig struct {
 	// NodeID is the unique identifier for this node (1-based)
-	NodeID uint64 `json:"node_id"`
+	NodeID uint64 `json:"node_id" yaml:"node_id"`
 
 	// ClusterID identifies the IPAM cluster
-	ClusterID uint64 `json:"cluster_id"`
+	ClusterID uint64 `json:"cluster_id" yaml:"cluster_id"`
 
 	// RaftAddr is the address for Raft communication (e.g., "localhost:5000")
-	RaftAddr string `json:"raft_addr"`
+	RaftAddr string `json:"raft_addr" yaml:"raft_addr"`
 
 	// APIAddr is the address for the API server (e.g., "localhost:8080")
-	APIAddr string `json:"api_addr"`
+	APIAddr string `json:"api_addr" yaml:"api_addr"`
 
 	// DataDir is the directory for storing Raft data
-	DataDir string `json:"data_dir"`
+	DataDir string `json:"data_dir" yaml:"data_dir"`
 
 	// Join indicates whether this node is joining an existing cluster
-	Join bool `json:"join"`
+	Join bool `json:"join" yaml:"join"`
 
 	// InitialMembers is a map of nodeID -> raftAddr for initial cluster members
 	// Required when starting a new cluster or joining an existing one
-	InitialMembers map[uint64]string `json:"initial_members"`
+	InitialMembers map[uint64]string `json:"initial_members" yaml:"initial_members"`
 
 	// EnableSingleNode allows running a single-node cluster for testing
-	EnableSingleNode bool `json:"enable_single_node"`
+	EnableSingleNode bool `json:"enable_single_node" yaml:"enable_single_node"`
+
+	// GossipAddr, when set, is the host:port this node gossips its API
+	// address and health on (e.g., "0.0.0.0:7946")
+	GossipAddr string `json:"gossip_addr,omitempty" yaml:"gossip_addr"`
+
+	// GossipSeeds are gossip addresses of other nodes to join through
+	GossipSeeds []string `json:"gossip_seeds,omitempty" yaml:"gossip_seeds"`
+
+	// APIURL is the URL other nodes and clients reach this node's API at,
+	// gossiped to the others. By default it is derived from the API and
+	// Raft addresses.
+	APIURL string `json:"api_url,omitempty" yaml:"api_url"`
 }
 
 // Validate checks if the cluster configuration is valid
@@ -84,6 +96,17 @@ func (c *ClusterConfig) Validate() error {
 		}
 	}
 
+	if c.GossipAddr != "" {
+		if _, _, err := net.SplitHostPort(c.GossipAddr); err != nil {
+			return fmt.Errorf("invalid gossip address: %w", err)
+		}
+	}
+	for _, seed := range c.GossipSeeds {
+		if _, _, err := net.SplitHostPort(seed); err != nil {
+			return fmt.Errorf("invalid gossip seed %q: %w", seed, err)
+		}
+	}
+
 	// Validate all member addresses
 	for nodeID, addr := range c.InitialMembers {
 		if nodeID == 0 {
@@ -0,0 +1,472 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ServerConfig is the YAML file loaded by "ipam server --config". Every
+// setting has a command line flag of the same meaning; flags given on the
+// command line override the file.
+type ServerConfig struct {
+	// Listen is the API address as host:port
+	Listen string `yaml:"listen"`
+
+	// PIDFile holds the server's process ID while it runs
+	PIDFile string `yaml:"pid_file"`
+
+	TLS        TLSConfig        `yaml:"tls"`
+	UnixSocket UnixSocketConfig `yaml:"unix_socket"`
+	Auth       AuthConfig       `yaml:"auth"`
+	Store      StoreConfig      `yaml:"store"`
+	Logging    LoggingConfig    `yaml:"logging"`
+	Limits     LimitsConfig     `yaml:"limits"`
+	Backups    BackupConfig     `yaml:"backups"`
+
+	// Replication makes the server a standby of another one
+	Replication ReplicationConfig `yaml:"replication"`
+
+	// Cluster is required when Store.Driver is raft
+	Cluster *ClusterConfig `yaml:"cluster"`
+
+	Audit            AuditConfig       `yaml:"audit"`
+	ApprovalWebhooks map[string]string `yaml:"approval_webhooks"`
+	Health           HealthConfig      `yaml:"health"`
+	Reclaim          ReclaimConfig     `yaml:"reclaim"`
+	Notify           NotifyConfig      `yaml:"notify"`
+	Tags             TagsConfig        `yaml:"tags"`
+	Networks         NetworksConfig    `yaml:"networks"`
+
+	// GeoIP tags public networks and allocations with their country and AS
+	GeoIP GeoIPConfig `yaml:"geoip"`
+
+	// SNMP answers polls for network utilization from monitoring systems
+	SNMP SNMPConfig `yaml:"snmp"`
+
+	// CDC mirrors networks and allocations into an external database
+	CDC CDCConfig `yaml:"cdc"`
+
+	// Kea pushes DHCP host reservations for allocations with a MAC into
+	// Kea
+	Kea KeaConfig `yaml:"kea"`
+
+	// PowerDNS pushes A, AAAA and PTR records for allocations with
+	// hostnames into PowerDNS
+	PowerDNS PowerDNSConfig `yaml:"powerdns"`
+
+	// MDNS announces the API on the local network
+	MDNS MDNSConfig `yaml:"mdns"`
+
+	// WebhookSecretFile holds the secret approval, reclamation and
+	// notification webhook requests are signed with
+	WebhookSecretFile string `yaml:"webhook_secret_file"`
+}
+
+// TLSConfig enables HTTPS when both files are set
+type TLSConfig struct {
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+}
+
+// UnixSocketConfig serves the API on a Unix domain socket as well, for
+// agents on the same host
+type UnixSocketConfig struct {
+	Path string `yaml:"path"`
+
+	// Mode is the socket's octal file mode, e.g. "0660"
+	Mode string `yaml:"mode"`
+
+	// Group owns the socket, as a name or numeric ID
+	Group string `yaml:"group"`
+}
+
+// ParseFileMode parses an octal permission string such as "0660"
+func ParseFileMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("%q is not an octal file mode such as 0660", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// AuthConfig requires API clients to send a bearer token or, with SPIFFE
+// configured, to present an X.509 SVID
+type AuthConfig struct {
+	// TokenFile lists the accepted tokens, one per line
+	TokenFile string `yaml:"token_file"`
+
+	// SPIFFEBundle is the PEM trust bundle client SVIDs are verified
+	// against; it needs TLS
+	SPIFFEBundle string `yaml:"spiffe_bundle"`
+
+	// SPIFFEIDs maps SPIFFE IDs, or every ID below a path ending in /*, to
+	// the principal network ACLs name them by, prefixed spiffe:
+	SPIFFEIDs map[string]string `yaml:"spiffe_ids"`
+
+	// Admins are the principals, e.g. token:9f86d081 or spiffe:gateway,
+	// that may name the end user they act for with X-On-Behalf-Of
+	Admins []string `yaml:"admins"`
+}
+
+// StoreConfig selects the storage backend
+type StoreConfig struct {
+	// Driver is pebble (default) or raft
+	Driver string `yaml:"driver"`
+
+	// DSN is the pebble database directory
+	DSN string `yaml:"dsn"`
+}
+
+// LoggingConfig controls the server log
+type LoggingConfig struct {
+	// File receives the log instead of stderr
+	File string `yaml:"file"`
+
+	// Access logs every API request
+	Access bool `yaml:"access"`
+}
+
+// LimitsConfig bounds how long clients may take and how much they may send
+type LimitsConfig struct {
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+
+	// MaxBodySize is a size such as "16MB"
+	MaxBodySize string `yaml:"max_body_size"`
+}
+
+// ParseSize parses a byte count with an optional KB, MB or GB suffix (powers
+// of 1024), e.g. "512KB" or "16MB"
+func ParseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+
+	number, unit := strings.ToUpper(strings.TrimSpace(s)), int64(1)
+	for _, u := range units {
+		if trimmed, ok := strings.CutSuffix(number, u.suffix); ok {
+			number, unit = strings.TrimSpace(trimmed), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 || n > (1<<62)/unit {
+		return 0, fmt.Errorf("%q is not a size such as 16MB", s)
+	}
+	return n * unit, nil
+}
+
+// BackupConfig writes periodic JSON exports
+type BackupConfig struct {
+	Dir      string        `yaml:"dir"`
+	Interval time.Duration `yaml:"interval"`
+	Keep     int           `yaml:"keep"`
+}
+
+// ReplicationConfig pulls a primary server's data into a read-only standby
+type ReplicationConfig struct {
+	// Primary is the primary server's URL
+	Primary  string        `yaml:"primary"`
+	Interval time.Duration `yaml:"interval"`
+
+	// TokenFile holds the API token sent to the primary
+	TokenFile string `yaml:"token_file"`
+
+	// ReadOnly refuses promotion
+	ReadOnly bool `yaml:"read_only"`
+
+	// MaxAge reports the standby stale when it last synced longer ago
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// AuditConfig anchors the audit log and chooses how entries are written
+type AuditConfig struct {
+	Key            string        `yaml:"key"`
+	AnchorInterval time.Duration `yaml:"anchor_interval"`
+
+	// Sync writes each entry before responding instead of in the background
+	Sync      bool `yaml:"sync"`
+	QueueSize int  `yaml:"queue_size"`
+}
+
+// HealthConfig probes allocated addresses
+type HealthConfig struct {
+	Interval   time.Duration `yaml:"interval"`
+	Ports      []int         `yaml:"ports"`
+	StaleAfter time.Duration `yaml:"stale_after"`
+}
+
+// ReclaimConfig applies reclamation policies
+type ReclaimConfig struct {
+	Interval time.Duration `yaml:"interval"`
+	Webhook  string        `yaml:"webhook"`
+}
+
+// NotifyConfig defines notification channels
+type NotifyConfig struct {
+	Channels      map[string]string `yaml:"channels"`
+	Interval      time.Duration     `yaml:"interval"`
+	ExpiryWarning time.Duration     `yaml:"expiry_warning"`
+}
+
+// TagsConfig manages the tags networks and allocations may carry
+type TagsConfig struct {
+	// Registry is the YAML file listing the allowed tags
+	Registry string `yaml:"registry"`
+
+	// Enforce rejects tags the registry does not list
+	Enforce bool `yaml:"enforce"`
+}
+
+// NetworksConfig sets how networks are registered
+type NetworksConfig struct {
+	// AutoCreate creates the network an allocation names by CIDR when it
+	// is not registered yet
+	AutoCreate bool `yaml:"auto_create"`
+}
+
+// GeoIPConfig tags public networks and allocations from MaxMind DB files
+type GeoIPConfig struct {
+	// Databases are the .mmdb files, e.g. a country and an ASN database
+	Databases []string      `yaml:"databases"`
+	Interval  time.Duration `yaml:"interval"`
+}
+
+// SNMPConfig runs a read-only SNMPv1/v2c agent
+type SNMPConfig struct {
+	// Listen is the UDP address as host:port
+	Listen string `yaml:"listen"`
+
+	// CommunityFile holds the community polls must carry
+	CommunityFile string `yaml:"community_file"`
+
+	// OID is the base OID objects are published below
+	OID string `yaml:"oid"`
+}
+
+// CDCConfig mirrors networks and allocations into a change table of a
+// PostgreSQL or MySQL database
+type CDCConfig struct {
+	// DSN is postgres://… or mysql://<DSN>
+	DSN string `yaml:"dsn"`
+
+	// Table is the change table, created when missing
+	Table string `yaml:"table"`
+
+	Interval time.Duration `yaml:"interval"`
+}
+
+// KeaConfig pushes host reservations into an ISC Kea DHCP server through
+// its Control Agent
+type KeaConfig struct {
+	// URL is the Control Agent, e.g. http://kea:8000
+	URL string `yaml:"url"`
+
+	// CredentialsFile holds the Control Agent's user:password
+	CredentialsFile string `yaml:"credentials_file"`
+
+	// Services are the Kea services synced, dhcp4 and/or dhcp6
+	Services []string `yaml:"services"`
+
+	Interval time.Duration `yaml:"interval"`
+}
+
+// PowerDNSConfig pushes records into a PowerDNS Authoritative server
+// through its HTTP API
+type PowerDNSConfig struct {
+	// URL is the API, e.g. http://pdns:8081
+	URL string `yaml:"url"`
+
+	// APIKeyFile holds the API key
+	APIKeyFile string `yaml:"api_key_file"`
+
+	ServerID string `yaml:"server_id"`
+
+	// Zones, when set, limits the sync to these zones
+	Zones []string `yaml:"zones"`
+
+	// Domain qualifies hostnames in networks without a domain=<name> tag
+	Domain string `yaml:"domain"`
+
+	// TTL of the records, in seconds
+	TTL       int           `yaml:"ttl"`
+	BatchSize int           `yaml:"batch_size"`
+	Interval  time.Duration `yaml:"interval"`
+}
+
+// MDNSConfig announces the API with mDNS/DNS-SD
+type MDNSConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Name is the service instance name, the host name when empty
+	Name string `yaml:"name"`
+}
+
+// LoadServerConfig reads and validates a server configuration file.
+// Unknown keys are rejected so typos do not silently fall back to defaults.
+func LoadServerConfig(path string) (*ServerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c ServerConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// Validate checks the settings that flags cannot check on their own
+func (c *ServerConfig) Validate() error {
+	if c.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+			return fmt.Errorf("listen: %w", err)
+		}
+	}
+
+	if (c.TLS.Cert == "") != (c.TLS.Key == "") {
+		return fmt.Errorf("tls: cert and key must be set together")
+	}
+
+	if (c.Auth.SPIFFEBundle == "") != (len(c.Auth.SPIFFEIDs) == 0) {
+		return fmt.Errorf("auth: spiffe_bundle and spiffe_ids must be set together")
+	}
+	if c.Auth.SPIFFEBundle != "" && c.TLS.Cert == "" {
+		return fmt.Errorf("auth: spiffe_bundle needs tls")
+	}
+
+	if c.UnixSocket.Path == "" && (c.UnixSocket.Mode != "" || c.UnixSocket.Group != "") {
+		return fmt.Errorf("unix_socket: path is required")
+	}
+	if c.UnixSocket.Mode != "" {
+		if _, err := ParseFileMode(c.UnixSocket.Mode); err != nil {
+			return fmt.Errorf("unix_socket: %w", err)
+		}
+	}
+
+	for name, d := range map[string]time.Duration{
+		"read_header_timeout": c.Limits.ReadHeaderTimeout,
+		"read_timeout":        c.Limits.ReadTimeout,
+		"write_timeout":       c.Limits.WriteTimeout,
+		"idle_timeout":        c.Limits.IdleTimeout,
+	} {
+		if d < 0 {
+			return fmt.Errorf("limits: %s must not be negative", name)
+		}
+	}
+	if c.Limits.MaxBodySize != "" {
+		if _, err := ParseSize(c.Limits.MaxBodySize); err != nil {
+			return fmt.Errorf("limits: max_body_size: %w", err)
+		}
+	}
+
+	switch c.Store.Driver {
+	case "", "pebble":
+		if c.Cluster != nil {
+			return fmt.Errorf("cluster: only used with store driver raft")
+		}
+	case "raft":
+		if c.Cluster == nil {
+			return fmt.Errorf("store: driver raft needs a cluster section")
+		}
+		if c.Store.DSN != "" {
+			return fmt.Errorf("store: dsn is not used with driver raft; set cluster.data_dir")
+		}
+		if err := c.Cluster.Validate(); err != nil {
+			return fmt.Errorf("cluster: %w", err)
+		}
+	default:
+		return fmt.Errorf("store: unknown driver %q: must be pebble or raft", c.Store.Driver)
+	}
+
+	if c.Backups.Keep < 0 {
+		return fmt.Errorf("backups: keep must not be negative")
+	}
+	if c.Backups.Dir == "" && (c.Backups.Interval != 0 || c.Backups.Keep != 0) {
+		return fmt.Errorf("backups: dir is required")
+	}
+
+	if c.Replication.Primary == "" && (c.Replication.Interval != 0 || c.Replication.TokenFile != "" || c.Replication.ReadOnly || c.Replication.MaxAge != 0) {
+		return fmt.Errorf("replication: primary is required")
+	}
+	if c.Replication.Primary != "" {
+		if u, err := url.Parse(c.Replication.Primary); err != nil || u.Host == "" {
+			return fmt.Errorf("replication: invalid primary %q", c.Replication.Primary)
+		}
+	}
+	if c.Replication.Interval < 0 || c.Replication.MaxAge < 0 {
+		return fmt.Errorf("replication: interval and max_age must not be negative")
+	}
+
+	if c.Reclaim.Webhook != "" {
+		if u, err := url.Parse(c.Reclaim.Webhook); err != nil || u.Host == "" {
+			return fmt.Errorf("reclaim: invalid webhook %q", c.Reclaim.Webhook)
+		}
+	}
+
+	if c.Tags.Enforce && c.Tags.Registry == "" {
+		return fmt.Errorf("tags: registry is required to enforce tags")
+	}
+
+	if len(c.GeoIP.Databases) == 0 && c.GeoIP.Interval != 0 {
+		return fmt.Errorf("geoip: databases are required")
+	}
+	if c.GeoIP.Interval < 0 {
+		return fmt.Errorf("geoip: interval must not be negative")
+	}
+
+	if c.SNMP.Listen == "" && (c.SNMP.CommunityFile != "" || c.SNMP.OID != "") {
+		return fmt.Errorf("snmp: listen is required")
+	}
+	if c.SNMP.Listen != "" && c.SNMP.CommunityFile == "" {
+		return fmt.Errorf("snmp: community_file is required")
+	}
+
+	if c.CDC.DSN == "" && (c.CDC.Table != "" || c.CDC.Interval != 0) {
+		return fmt.Errorf("cdc: dsn is required")
+	}
+	if c.CDC.Interval < 0 {
+		return fmt.Errorf("cdc: interval must not be negative")
+	}
+
+	if c.Kea.URL == "" && (c.Kea.CredentialsFile != "" || len(c.Kea.Services) > 0 || c.Kea.Interval != 0) {
+		return fmt.Errorf("kea: url is required")
+	}
+	if c.Kea.Interval < 0 {
+		return fmt.Errorf("kea: interval must not be negative")
+	}
+
+	pdns := c.PowerDNS
+	if pdns.URL == "" && (pdns.APIKeyFile != "" || pdns.ServerID != "" || len(pdns.Zones) > 0 || pdns.Domain != "" ||
+		pdns.TTL != 0 || pdns.BatchSize != 0 || pdns.Interval != 0) {
+		return fmt.Errorf("powerdns: url is required")
+	}
+	if pdns.URL != "" && pdns.APIKeyFile == "" {
+		return fmt.Errorf("powerdns: api_key_file is required")
+	}
+	if pdns.TTL < 0 || pdns.BatchSize < 0 || pdns.Interval < 0 {
+		return fmt.Errorf("powerdns: ttl, batch_size and interval must not be negative")
+	}
+
+	if c.MDNS.Name != "" && !c.MDNS.Enabled {
+		return fmt.Errorf("mdns: name requires enabled")
+	}
+	return nil
+}
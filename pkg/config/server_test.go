@@ -0,0 +1,211 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadServerConfig(t *testing.T) {
+	path := writeConfig(t, `
+listen: 0.0.0.0:8443
+tls:
+  cert: /etc/ipam/tls.crt
+  key: /etc/ipam/tls.key
+auth:
+  token_file: /etc/ipam/tokens
+  spiffe_bundle: /etc/ipam/spiffe-bundle.pem
+  spiffe_ids:
+    spiffe://example.org/ns/lab/*: lab
+store:
+  driver: raft
+cluster:
+  node_id: 1
+  cluster_id: 100
+  raft_addr: 10.0.0.1:5000
+  data_dir: /var/lib/ipam
+  initial_members:
+    1: 10.0.0.1:5000
+backups:
+  dir: /var/backups/ipam
+  interval: 6h
+  keep: 14
+health:
+  ports: [22, 443]
+webhook_secret_file: /etc/ipam/webhook-secret
+notify:
+  channels:
+    ops: https://example.com/hook
+tags:
+  registry: /etc/ipam/tags.yaml
+  enforce: true
+networks:
+  auto_create: true
+geoip:
+  databases: [/var/lib/GeoIP/GeoLite2-Country.mmdb, /var/lib/GeoIP/GeoLite2-ASN.mmdb]
+snmp:
+  listen: 0.0.0.0:161
+  community_file: /etc/ipam/snmp-community
+cdc:
+  dsn: postgres://bi@db.example.com/reporting
+  interval: 5m
+kea:
+  url: http://kea:8000
+  credentials_file: /etc/ipam/kea-credentials
+  services: [dhcp4, dhcp6]
+powerdns:
+  url: http://pdns:8081
+  api_key_file: /etc/ipam/pdns-key
+  zones: [lan.example.com, 30.168.192.in-addr.arpa]
+  ttl: 300
+mdns:
+  enabled: true
+  name: lab ipam
+audit:
+  sync: true
+  queue_size: 256
+`)
+	c, err := LoadServerConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, "0.0.0.0:8443", c.Listen)
+	assert.Equal(t, "/etc/ipam/tls.key", c.TLS.Key)
+	assert.Equal(t, "/etc/ipam/tokens", c.Auth.TokenFile)
+	assert.Equal(t, map[string]string{"spiffe://example.org/ns/lab/*": "lab"}, c.Auth.SPIFFEIDs)
+	require.NotNil(t, c.Cluster)
+	assert.Equal(t, uint64(100), c.Cluster.ClusterID)
+	assert.Equal(t, map[uint64]string{1: "10.0.0.1:5000"}, c.Cluster.InitialMembers)
+	assert.Equal(t, 6*time.Hour, c.Backups.Interval)
+	assert.Equal(t, 14, c.Backups.Keep)
+	assert.Equal(t, []int{22, 443}, c.Health.Ports)
+	assert.Equal(t, "/etc/ipam/webhook-secret", c.WebhookSecretFile)
+	assert.Equal(t, "https://example.com/hook", c.Notify.Channels["ops"])
+	assert.Equal(t, TagsConfig{Registry: "/etc/ipam/tags.yaml", Enforce: true}, c.Tags)
+	assert.True(t, c.Networks.AutoCreate)
+	assert.Equal(t, []string{"/var/lib/GeoIP/GeoLite2-Country.mmdb", "/var/lib/GeoIP/GeoLite2-ASN.mmdb"}, c.GeoIP.Databases)
+	assert.Equal(t, SNMPConfig{Listen: "0.0.0.0:161", CommunityFile: "/etc/ipam/snmp-community"}, c.SNMP)
+	assert.Equal(t, CDCConfig{DSN: "postgres://bi@db.example.com/reporting", Interval: 5 * time.Minute}, c.CDC)
+	assert.Equal(t, KeaConfig{URL: "http://kea:8000", CredentialsFile: "/etc/ipam/kea-credentials", Services: []string{"dhcp4", "dhcp6"}}, c.Kea)
+	assert.Equal(t, PowerDNSConfig{URL: "http://pdns:8081", APIKeyFile: "/etc/ipam/pdns-key", Zones: []string{"lan.example.com", "30.168.192.in-addr.arpa"}, TTL: 300}, c.PowerDNS)
+	assert.Equal(t, MDNSConfig{Enabled: true, Name: "lab ipam"}, c.MDNS)
+	assert.True(t, c.Audit.Sync)
+	assert.Equal(t, 256, c.Audit.QueueSize)
+
+	// Unknown keys are rejected
+	_, err = LoadServerConfig(writeConfig(t, "listne: 0.0.0.0:8080\n"))
+	assert.ErrorContains(t, err, "listne")
+}
+
+func TestServerConfigValidate(t *testing.T) {
+	cluster := &ClusterConfig{NodeID: 1, ClusterID: 100, RaftAddr: "localhost:5000", DataDir: "data", EnableSingleNode: true}
+	gossip := *cluster
+	gossip.GossipAddr = "0.0.0.0:7946"
+	gossip.GossipSeeds = []string{"10.0.0.2:7946"}
+	badSeed := gossip
+	badSeed.GossipSeeds = []string{"10.0.0.2"}
+
+	tests := []struct {
+		name   string
+		config ServerConfig
+		err    string
+	}{
+		{"empty", ServerConfig{}, ""},
+		{"listen", ServerConfig{Listen: "8080"}, "listen"},
+		{"tls key missing", ServerConfig{TLS: TLSConfig{Cert: "tls.crt"}}, "cert and key"},
+		{"spiffe", ServerConfig{TLS: TLSConfig{Cert: "tls.crt", Key: "tls.key"}, Auth: AuthConfig{SPIFFEBundle: "bundle.pem", SPIFFEIDs: map[string]string{"spiffe://example.org/web": "web"}}}, ""},
+		{"spiffe without ids", ServerConfig{TLS: TLSConfig{Cert: "tls.crt", Key: "tls.key"}, Auth: AuthConfig{SPIFFEBundle: "bundle.pem"}}, "set together"},
+		{"spiffe without tls", ServerConfig{Auth: AuthConfig{SPIFFEBundle: "bundle.pem", SPIFFEIDs: map[string]string{"spiffe://example.org/web": "web"}}}, "needs tls"},
+		{"unknown driver", ServerConfig{Store: StoreConfig{Driver: "postgres"}}, "unknown driver"},
+		{"raft without cluster", ServerConfig{Store: StoreConfig{Driver: "raft"}}, "cluster section"},
+		{"raft with dsn", ServerConfig{Store: StoreConfig{Driver: "raft", DSN: "data"}, Cluster: cluster}, "dsn"},
+		{"raft", ServerConfig{Store: StoreConfig{Driver: "raft"}, Cluster: cluster}, ""},
+		{"cluster without raft", ServerConfig{Cluster: cluster}, "only used"},
+		{"gossip", ServerConfig{Store: StoreConfig{Driver: "raft"}, Cluster: &gossip}, ""},
+		{"gossip seed", ServerConfig{Store: StoreConfig{Driver: "raft"}, Cluster: &badSeed}, "gossip seed"},
+		{"unix socket", ServerConfig{UnixSocket: UnixSocketConfig{Path: "/run/ipam/ipam.sock", Mode: "0600", Group: "ipam"}}, ""},
+		{"unix socket mode", ServerConfig{UnixSocket: UnixSocketConfig{Path: "/run/ipam/ipam.sock", Mode: "rw"}}, "octal"},
+		{"unix socket without path", ServerConfig{UnixSocket: UnixSocketConfig{Group: "ipam"}}, "path is required"},
+		{"limits", ServerConfig{Limits: LimitsConfig{ReadTimeout: time.Minute, MaxBodySize: "1MB"}}, ""},
+		{"negative timeout", ServerConfig{Limits: LimitsConfig{IdleTimeout: -time.Second}}, "idle_timeout"},
+		{"max body size", ServerConfig{Limits: LimitsConfig{MaxBodySize: "lots"}}, "max_body_size"},
+		{"backups without dir", ServerConfig{Backups: BackupConfig{Keep: 3}}, "dir is required"},
+		{"reclaim webhook", ServerConfig{Reclaim: ReclaimConfig{Webhook: "not a url"}}, "invalid webhook"},
+		{"replication", ServerConfig{Replication: ReplicationConfig{Primary: "https://ipam.us-east.example.com", Interval: time.Minute}}, ""},
+		{"replication primary", ServerConfig{Replication: ReplicationConfig{Primary: "ipam.us-east.example.com"}}, "invalid primary"},
+		{"read-only replica", ServerConfig{Replication: ReplicationConfig{Primary: "https://ipam.example.com", ReadOnly: true, MaxAge: time.Hour}}, ""},
+		{"replication max age", ServerConfig{Replication: ReplicationConfig{Primary: "https://ipam.example.com", MaxAge: -time.Hour}}, "max_age"},
+		{"replication without primary", ServerConfig{Replication: ReplicationConfig{TokenFile: "/etc/ipam/primary-token"}}, "primary is required"},
+		{"tags", ServerConfig{Tags: TagsConfig{Registry: "tags.yaml", Enforce: true}}, ""},
+		{"geoip without databases", ServerConfig{GeoIP: GeoIPConfig{Interval: time.Hour}}, "databases are required"},
+		{"snmp", ServerConfig{SNMP: SNMPConfig{Listen: "0.0.0.0:1161", CommunityFile: "community", OID: "1.3.6.1.4.1.99999"}}, ""},
+		{"snmp without community", ServerConfig{SNMP: SNMPConfig{Listen: "0.0.0.0:161"}}, "community_file is required"},
+		{"snmp without listen", ServerConfig{SNMP: SNMPConfig{OID: "1.3.6.1.4.1.99999"}}, "listen is required"},
+		{"cdc", ServerConfig{CDC: CDCConfig{DSN: "mysql://bi@tcp(db:3306)/reporting", Table: "changes"}}, ""},
+		{"cdc without dsn", ServerConfig{CDC: CDCConfig{Interval: time.Minute}}, "dsn is required"},
+		{"kea", ServerConfig{Kea: KeaConfig{URL: "http://kea:8000", Services: []string{"dhcp6"}}}, ""},
+		{"kea without url", ServerConfig{Kea: KeaConfig{CredentialsFile: "kea-credentials"}}, "url is required"},
+		{"kea negative interval", ServerConfig{Kea: KeaConfig{URL: "http://kea:8000", Interval: -time.Minute}}, "interval must not be negative"},
+		{"powerdns", ServerConfig{PowerDNS: PowerDNSConfig{URL: "http://pdns:8081", APIKeyFile: "pdns-key", Domain: "example.com"}}, ""},
+		{"powerdns without url", ServerConfig{PowerDNS: PowerDNSConfig{Zones: []string{"example.com"}}}, "url is required"},
+		{"powerdns without api key", ServerConfig{PowerDNS: PowerDNSConfig{URL: "http://pdns:8081"}}, "api_key_file is required"},
+		{"powerdns negative ttl", ServerConfig{PowerDNS: PowerDNSConfig{URL: "http://pdns:8081", APIKeyFile: "pdns-key", TTL: -1}}, "must not be negative"},
+		{"mdns name without enabled", ServerConfig{MDNS: MDNSConfig{Name: "lab"}}, "name requires enabled"},
+		{"enforce without registry", ServerConfig{Tags: TagsConfig{Enforce: true}}, "registry is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseFileMode(t *testing.T) {
+	mode, err := ParseFileMode("0660")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o660), mode)
+	mode, err = ParseFileMode("600")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), mode)
+
+	for _, s := range []string{"", "0689", "01777", "rw-rw----"} {
+		_, err := ParseFileMode(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for s, want := range map[string]int64{
+		"0":      0,
+		"1024":   1024,
+		"512B":   512,
+		"64KB":   64 << 10,
+		"16MB":   16 << 20,
+		"16 mb":  16 << 20,
+		"2GB":    2 << 30,
+		" 1KB ":  1024,
+		"100000": 100000,
+	} {
+		got, err := ParseSize(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, want, got, s)
+	}
+
+	for _, s := range []string{"", "MB", "-1MB", "1.5MB", "1TB", "99999999999GB"} {
+		_, err := ParseSize(s)
+		assert.Error(t, err, s)
+	}
+}
@@ -0,0 +1,243 @@
+// Package coredns answers A, AAAA and PTR queries from the allocations of
+// a go-ipam server, for lab environments that want DNS without a separate
+// sync pipeline. Records caches the servers' networks and allocations and
+// reloads them when the event stream reports a change; the CoreDNS plugin
+// registered as "ipam" serves queries from it.
+//
+// The plugin is built into CoreDNS with the coredns build tag: add
+//
+//	ipam:github.com/jeremyhahn/go-ipam/pkg/coredns
+//
+// to CoreDNS's plugin.cfg and build it with -tags coredns.
+package coredns
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/client"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/rdns"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/miekg/dns"
+)
+
+// DefaultTTL is the TTL of answers by default
+const DefaultTTL = 60
+
+// DefaultRefresh is how often the event stream is polled by default
+const DefaultRefresh = 10 * time.Second
+
+// eventPageSize is the most events read per request
+const eventPageSize = 1000
+
+// record is an address or PTR target and when its allocation expires
+type record struct {
+	addr      netip.Addr
+	target    string
+	expiresAt *time.Time
+}
+
+// Records caches the records the servers' allocations call for
+type Records struct {
+	// Client reads the networks, allocations and events
+	Client *client.Client
+
+	// Domain qualifies the hostnames of allocations in networks without a
+	// domain=<name> tag
+	Domain string
+
+	mu      sync.RWMutex
+	forward map[string][]record
+	reverse map[string][]record
+	seq     uint64
+}
+
+// eventPage is a page of the servers' event stream
+type eventPage struct {
+	Events []struct {
+		Seq uint64 `json:"seq"`
+	} `json:"events"`
+	LastSeq uint64 `json:"last_seq"`
+	HasMore bool   `json:"has_more"`
+}
+
+// Start skips the events recorded so far and loads the records
+func (r *Records) Start(now time.Time) error {
+	seq, _, err := r.events(0)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.seq = seq
+	r.mu.Unlock()
+	return r.Load(now)
+}
+
+// Load reads every network and allocation and replaces the cached records
+// with those of the allocations active at now
+func (r *Records) Load(now time.Time) error {
+	var networks []*ipam.Network
+	if err := r.Client.GetJSON("/api/v1/networks", &networks); err != nil {
+		return fmt.Errorf("failed to fetch networks: %w", err)
+	}
+	var allocations []*ipam.IPAllocation
+	if err := r.Client.GetJSON("/api/v1/allocations", &allocations); err != nil {
+		return fmt.Errorf("failed to fetch allocations: %w", err)
+	}
+
+	domains := make(map[string]string, len(networks))
+	for _, network := range networks {
+		domains[network.ID] = r.Domain
+		for _, tag := range network.Tags {
+			if value, ok := strings.CutPrefix(tag, "domain="); ok {
+				domains[network.ID] = value
+			}
+		}
+	}
+	forward := make(map[string][]record)
+	reverse := make(map[string][]record)
+	for _, alloc := range allocations {
+		if alloc.Hostname == "" || store.AllocationStatus(alloc, now) != store.StatusActive {
+			continue
+		}
+		ips, err := rdns.ExpandRange(alloc.IP, alloc.EndIP)
+		if err != nil {
+			log.Printf("ipam: skipping allocation %s: %v", alloc.ID, err)
+			continue
+		}
+		name := strings.ToLower(rdns.QualifyHostname(alloc.Hostname, domains[alloc.NetworkID]))
+		for _, ip := range ips {
+			addr, _ := netip.AddrFromSlice(ip)
+			forward[name] = append(forward[name], record{addr: addr.Unmap(), expiresAt: alloc.ExpiresAt})
+			ptr := rdns.ReverseName(ip)
+			reverse[ptr] = append(reverse[ptr], record{target: name, expiresAt: alloc.ExpiresAt})
+		}
+	}
+
+	r.mu.Lock()
+	r.forward, r.reverse = forward, reverse
+	r.mu.Unlock()
+	return nil
+}
+
+// Refresh reloads the records when the event stream reports changes since
+// the last refresh, or when it cannot be read
+func (r *Records) Refresh(now time.Time) error {
+	r.mu.RLock()
+	since := r.seq
+	r.mu.RUnlock()
+
+	seq, changed, err := r.events(since)
+	if err != nil {
+		log.Printf("ipam: reading events failed, reloading: %v", err)
+	} else if !changed {
+		return nil
+	}
+	if err := r.Load(now); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.seq = seq
+	r.mu.Unlock()
+	return nil
+}
+
+// events reads the event stream after since to its end, returning the last
+// sequence number and whether there were any events
+func (r *Records) events(since uint64) (uint64, bool, error) {
+	changed := false
+	for {
+		var page eventPage
+		if err := r.Client.GetJSON(fmt.Sprintf("/api/v1/events?since_seq=%d&limit=%d", since, eventPageSize), &page); err != nil {
+			return since, changed, fmt.Errorf("failed to fetch events: %w", err)
+		}
+		changed = changed || len(page.Events) > 0
+		since = page.LastSeq
+		if !page.HasMore {
+			return since, changed, nil
+		}
+	}
+}
+
+// Run refreshes the records every interval until stop is closed
+func (r *Records) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := r.Refresh(time.Now()); err != nil {
+				log.Printf("ipam: refresh failed: %v", err)
+			}
+		}
+	}
+}
+
+// Reply answers the first question of req with the records of its name,
+// with ttl. found is false when no allocation has the name, in which case
+// the reply is NXDOMAIN; a name without records of the queried type gets
+// an empty answer.
+func (r *Records) Reply(req *dns.Msg, ttl uint32, now time.Time) (reply *dns.Msg, found bool) {
+	reply = new(dns.Msg)
+	reply.SetReply(req)
+	reply.Authoritative = true
+	if len(req.Question) == 0 {
+		reply.Rcode = dns.RcodeFormatError
+		return reply, false
+	}
+	q := req.Question[0]
+	name := strings.ToLower(dns.Fqdn(q.Name))
+
+	r.mu.RLock()
+	forward, reverse := live(r.forward[name], now), live(r.reverse[name], now)
+	r.mu.RUnlock()
+	if len(forward) == 0 && len(reverse) == 0 {
+		reply.Rcode = dns.RcodeNameError
+		return reply, false
+	}
+
+	hdr := func(rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{Name: q.Name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: ttl}
+	}
+	seen := make(map[string]bool)
+	for _, rec := range forward {
+		if seen[rec.addr.String()] {
+			continue
+		}
+		seen[rec.addr.String()] = true
+		switch {
+		case q.Qtype == dns.TypeA && rec.addr.Is4():
+			reply.Answer = append(reply.Answer, &dns.A{Hdr: hdr(dns.TypeA), A: net.IP(rec.addr.AsSlice())})
+		case q.Qtype == dns.TypeAAAA && rec.addr.Is6():
+			reply.Answer = append(reply.Answer, &dns.AAAA{Hdr: hdr(dns.TypeAAAA), AAAA: net.IP(rec.addr.AsSlice())})
+		}
+	}
+	if q.Qtype == dns.TypePTR {
+		for _, rec := range reverse {
+			if !seen[rec.target] {
+				seen[rec.target] = true
+				reply.Answer = append(reply.Answer, &dns.PTR{Hdr: hdr(dns.TypePTR), Ptr: rec.target})
+			}
+		}
+	}
+	return reply, true
+}
+
+// live returns the records whose allocations have not expired by now
+func live(records []record, now time.Time) []record {
+	var kept []record
+	for _, rec := range records {
+		if rec.expiresAt == nil || now.Before(*rec.expiresAt) {
+			kept = append(kept, rec)
+		}
+	}
+	return kept
+}
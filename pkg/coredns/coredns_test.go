@@ -0,0 +1,134 @@
+package coredns
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/client"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAPI serves networks, allocations and events like a go-ipam server
+type fakeAPI struct {
+	networks    []*ipam.Network
+	allocations []*ipam.IPAllocation
+	lastSeq     uint64
+	eventsDown  bool
+	loads       int
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/api/v1/networks":
+		json.NewEncoder(w).Encode(f.networks)
+	case "/api/v1/allocations":
+		f.loads++
+		json.NewEncoder(w).Encode(f.allocations)
+	case "/api/v1/events":
+		if f.eventsDown {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		since, _ := strconv.ParseUint(r.URL.Query().Get("since_seq"), 10, 64)
+		events := []map[string]any{}
+		for seq := since + 1; seq <= f.lastSeq; seq++ {
+			events = append(events, map[string]any{"seq": seq, "action": "allocate"})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"events": events, "last_seq": max(since, f.lastSeq), "has_more": false})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func query(t *testing.T, r *Records, name string, qtype uint16, now time.Time) (*dns.Msg, bool) {
+	t.Helper()
+	req := new(dns.Msg)
+	req.SetQuestion(name, qtype)
+	return r.Reply(req, 30, now)
+}
+
+func TestRecords(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	soon := now.Add(time.Hour)
+	api := &fakeAPI{
+		networks: []*ipam.Network{
+			{ID: "lab", CIDR: "10.60.0.0/24", Tags: []string{"domain=lab.example.com"}},
+			{ID: "v6", CIDR: "2001:db8::/64"},
+		},
+		allocations: []*ipam.IPAllocation{
+			{ID: "web", NetworkID: "lab", IP: "10.60.0.10", Hostname: "Web"},
+			{ID: "web-v6", NetworkID: "v6", IP: "2001:db8::10", Hostname: "web.lab.example.com"},
+			{ID: "temp", NetworkID: "lab", IP: "10.60.0.20", Hostname: "temp", ExpiresAt: &soon},
+			{ID: "anon", NetworkID: "lab", IP: "10.60.0.30"},
+		},
+		lastSeq: 41,
+	}
+	server := httptest.NewServer(api)
+	defer server.Close()
+	cl, err := client.New([]string{server.URL})
+	require.NoError(t, err)
+	records := &Records{Client: cl, Domain: "example.com"}
+	require.NoError(t, records.Start(now))
+	assert.Equal(t, uint64(41), records.seq)
+
+	reply, found := query(t, records, "web.LAB.example.com.", dns.TypeA, now)
+	assert.True(t, found)
+	assert.True(t, reply.Authoritative)
+	require.Len(t, reply.Answer, 1)
+	assert.Equal(t, "web.LAB.example.com.\t30\tIN\tA\t10.60.0.10", reply.Answer[0].String())
+
+	reply, _ = query(t, records, "web.lab.example.com.", dns.TypeAAAA, now)
+	require.Len(t, reply.Answer, 1)
+	assert.Equal(t, "2001:db8::10", reply.Answer[0].(*dns.AAAA).AAAA.String())
+
+	reply, found = query(t, records, "10.0.60.10.in-addr.arpa.", dns.TypePTR, now)
+	assert.True(t, found)
+	require.Len(t, reply.Answer, 1)
+	assert.Equal(t, "web.lab.example.com.", reply.Answer[0].(*dns.PTR).Ptr)
+
+	// A name without records of the type has an empty answer
+	reply, found = query(t, records, "web.lab.example.com.", dns.TypeMX, now)
+	assert.True(t, found)
+	assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+	assert.Empty(t, reply.Answer)
+
+	reply, found = query(t, records, "nobody.lab.example.com.", dns.TypeA, now)
+	assert.False(t, found)
+	assert.Equal(t, dns.RcodeNameError, reply.Rcode)
+
+	// Allocations stop answering when they expire, without a reload
+	_, found = query(t, records, "temp.lab.example.com.", dns.TypeA, now)
+	assert.True(t, found)
+	_, found = query(t, records, "temp.lab.example.com.", dns.TypeA, soon)
+	assert.False(t, found)
+
+	// Without events the cache is kept; an event reloads it
+	require.NoError(t, records.Refresh(now))
+	assert.Equal(t, 1, api.loads)
+	api.allocations = append(api.allocations, &ipam.IPAllocation{ID: "db", NetworkID: "lab", IP: "10.60.0.40", Hostname: "db"})
+	api.lastSeq = 42
+	require.NoError(t, records.Refresh(now))
+	assert.Equal(t, 2, api.loads)
+	assert.Equal(t, uint64(42), records.seq)
+	_, found = query(t, records, "db.lab.example.com.", dns.TypeA, now)
+	assert.True(t, found)
+
+	// Without the event stream every refresh reloads
+	api.eventsDown = true
+	require.NoError(t, records.Refresh(now))
+	assert.Equal(t, 3, api.loads)
+
+	// Hostnames in networks without a domain tag take Domain
+	api.networks[1].Tags = nil
+	api.allocations[1].Hostname = "api"
+	require.NoError(t, records.Load(now))
+	_, found = query(t, records, "api.example.com.", dns.TypeAAAA, now)
+	assert.True(t, found)
+}
@@ -0,0 +1,145 @@
+//go:build coredns
+
+package coredns
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/fall"
+	"github.com/coredns/coredns/request"
+	"github.com/jeremyhahn/go-ipam/pkg/client"
+	"github.com/miekg/dns"
+)
+
+// pluginName is the plugin's name in the Corefile
+const pluginName = "ipam"
+
+func init() { plugin.Register(pluginName, setup) }
+
+// IPAM is the CoreDNS plugin answering queries for its zones from Records
+type IPAM struct {
+	Next    plugin.Handler
+	Zones   []string
+	Records *Records
+	TTL     uint32
+	Fall    fall.F
+}
+
+// ServeDNS implements plugin.Handler
+func (p *IPAM) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	state := request.Request{W: w, Req: r}
+	qname := state.Name()
+	if plugin.Zones(p.Zones).Matches(qname) == "" {
+		return plugin.NextOrFailure(p.Name(), p.Next, ctx, w, r)
+	}
+
+	reply, found := p.Records.Reply(r, p.TTL, time.Now())
+	if !found && p.Fall.Through(qname) {
+		return plugin.NextOrFailure(p.Name(), p.Next, ctx, w, r)
+	}
+	w.WriteMsg(reply)
+	return dns.RcodeSuccess, nil
+}
+
+// Name implements plugin.Handler
+func (p *IPAM) Name() string { return pluginName }
+
+// setup parses
+//
+//	ipam URL[,URL...] [ZONES...] {
+//	    token_file FILE
+//	    domain DOMAIN
+//	    ttl SECONDS
+//	    refresh DURATION
+//	    fallthrough [ZONES...]
+//	}
+//
+// where the URLs are go-ipam servers, e.g. a cluster's nodes
+func setup(c *caddy.Controller) error {
+	p := &IPAM{TTL: DefaultTTL}
+	refresh := DefaultRefresh
+	var token string
+
+	c.Next()
+	args := c.RemainingArgs()
+	if len(args) == 0 {
+		return plugin.Error(pluginName, c.ArgErr())
+	}
+	cl, err := client.New(client.ParseServers(args[0]))
+	if err != nil {
+		return plugin.Error(pluginName, err)
+	}
+	p.Zones = plugin.OriginsFromArgsOrServerBlock(args[1:], c.ServerBlockKeys)
+	p.Records = &Records{Client: cl}
+
+	for c.NextBlock() {
+		switch c.Val() {
+		case "token_file":
+			if !c.NextArg() {
+				return plugin.Error(pluginName, c.ArgErr())
+			}
+			data, err := os.ReadFile(c.Val())
+			if err != nil {
+				return plugin.Error(pluginName, err)
+			}
+			token = strings.TrimSpace(string(data))
+		case "domain":
+			if !c.NextArg() {
+				return plugin.Error(pluginName, c.ArgErr())
+			}
+			p.Records.Domain = c.Val()
+		case "ttl":
+			if !c.NextArg() {
+				return plugin.Error(pluginName, c.ArgErr())
+			}
+			ttl, err := strconv.ParseUint(c.Val(), 10, 32)
+			if err != nil {
+				return plugin.Error(pluginName, c.Errf("invalid ttl %q", c.Val()))
+			}
+			p.TTL = uint32(ttl)
+		case "refresh":
+			if !c.NextArg() {
+				return plugin.Error(pluginName, c.ArgErr())
+			}
+			if refresh, err = time.ParseDuration(c.Val()); err != nil || refresh <= 0 {
+				return plugin.Error(pluginName, c.Errf("invalid refresh %q", c.Val()))
+			}
+		case "fallthrough":
+			p.Fall.SetZonesFromArgs(c.RemainingArgs())
+		default:
+			return plugin.Error(pluginName, c.Errf("unknown property %q", c.Val()))
+		}
+	}
+	cl.Token = token
+	cl.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+	stop := make(chan struct{})
+	c.OnStartup(func() error {
+		// An unreachable server is retried on every refresh rather than
+		// keeping CoreDNS from starting
+		if err := p.Records.Start(time.Now()); err != nil {
+			log.Printf("ipam: %v", err)
+		}
+		go p.Records.Run(refresh, stop)
+		return nil
+	})
+	c.OnShutdown(func() error {
+		close(stop)
+		return nil
+	})
+
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		p.Next = next
+		return p
+	})
+	return nil
+}
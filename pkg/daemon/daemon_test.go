@@ -0,0 +1,33 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ipam.pid")
+	require.NoError(t, WritePIDFile(path))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(os.Getpid())+"\n", string(data))
+
+	// A stale PID file is replaced; a running process's is not
+	require.NoError(t, os.WriteFile(path, []byte("999999999\n"), 0o644))
+	require.NoError(t, WritePIDFile(path))
+	require.NoError(t, os.WriteFile(path, []byte(strconv.Itoa(os.Getppid())), 0o644))
+	assert.ErrorContains(t, WritePIDFile(path), "running process")
+
+	// Another process's PID file is left alone
+	require.NoError(t, RemovePIDFile(path))
+	assert.FileExists(t, path)
+	require.NoError(t, os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())), 0o644))
+	require.NoError(t, RemovePIDFile(path))
+	assert.NoFileExists(t, path)
+	assert.NoError(t, RemovePIDFile(path))
+}
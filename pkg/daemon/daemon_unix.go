@@ -0,0 +1,22 @@
+//go:build !windows
+
+package daemon
+
+import (
+	"errors"
+	"syscall"
+)
+
+// closeOnExec keeps an inherited descriptor from leaking into children
+func closeOnExec(fd int) {
+	syscall.CloseOnExec(fd)
+}
+
+// running reports whether a process with pid exists
+func running(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,109 @@
+//go:build !windows
+
+package daemon
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListeners(t *testing.T) {
+	// Not socket activated
+	t.Setenv("LISTEN_PID", "")
+	t.Setenv("LISTEN_FDS", "")
+	lns, err := Listeners()
+	require.NoError(t, err)
+	assert.Nil(t, lns)
+
+	// Meant for another process
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	lns, err = Listeners()
+	require.NoError(t, err)
+	assert.Nil(t, lns)
+	_, set := os.LookupEnv("LISTEN_FDS")
+	assert.False(t, set, "activation environment is cleared")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer f.Close()
+
+	lns, err = listeners(dup(t, f), 1, []string{"ipam.socket"})
+	require.NoError(t, err)
+	require.Len(t, lns, 1)
+	defer lns[0].Close()
+	assert.Equal(t, ln.Addr().String(), lns[0].Addr().String())
+
+	// Descriptors that are not sockets are rejected
+	other, err := os.CreateTemp(t.TempDir(), "fd")
+	require.NoError(t, err)
+	defer other.Close()
+	_, err = listeners(dup(t, other), 1, nil)
+	assert.Error(t, err)
+}
+
+// dup returns a raw copy of f's descriptor, as systemd would pass it;
+// listeners takes ownership of it
+func dup(t *testing.T, f *os.File) int {
+	fd, err := syscall.Dup(int(f.Fd()))
+	require.NoError(t, err)
+	return fd
+}
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	assert.NoError(t, Notify("READY=1"))
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", path)
+	require.NoError(t, Notify("READY=1"))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "READY=1", string(buf[:n]))
+}
+
+func TestListenUnix(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ipam.sock")
+
+	ln, err := ListenUnix(path, 0o660, strconv.Itoa(os.Getgid()))
+	require.NoError(t, err)
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o660), fi.Mode().Perm())
+
+	// A socket in use is not replaced
+	_, err = ListenUnix(path, 0o660, "")
+	assert.ErrorContains(t, err, "in use")
+
+	// A stale socket is
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	ln, err = ListenUnix(path, 0o600, "")
+	require.NoError(t, err)
+	ln.Close()
+	assert.NoFileExists(t, path, "the socket is removed on close")
+
+	// Other files are left alone
+	require.NoError(t, os.WriteFile(path, nil, 0o600))
+	_, err = ListenUnix(path, 0o660, "")
+	assert.ErrorContains(t, err, "not a socket")
+
+	_, err = ListenUnix(filepath.Join(dir, "other.sock"), 0o660, "no-such-group-ipam")
+	assert.Error(t, err)
+}
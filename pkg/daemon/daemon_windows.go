@@ -0,0 +1,28 @@
+package daemon
+
+import "syscall"
+
+// closeOnExec does nothing: Windows has no socket activation, so there are
+// no inherited descriptors to close
+func closeOnExec(fd int) {}
+
+// running reports whether a process with pid exists
+func running(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	const processQueryLimitedInformation = 0x1000
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		// Access denied means the process exists but belongs to someone else
+		return err == syscall.ERROR_ACCESS_DENIED
+	}
+	defer syscall.CloseHandle(h)
+
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return true
+	}
+	const stillActive = 259
+	return code == stillActive
+}
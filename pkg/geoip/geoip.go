@@ -0,0 +1,234 @@
+// Package geoip annotates public networks and allocations with the country
+// and autonomous system their addresses belong to, read from MaxMind DB
+// files such as GeoLite2-Country and GeoLite2-ASN, so that they can be
+// filtered and reported on by country, e.g. for data-residency reports.
+//
+// Annotations are tags, like the health prober's, so they travel with the
+// record through every store, export and mirror: geo-country=<ISO 3166-1
+// code> and geo-asn=<number>. Private, carrier-grade NAT and other
+// non-public addresses are left alone.
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/rdap"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// DefaultInterval is how often networks and allocations are annotated by
+// default. GeoIP databases are updated weekly at most.
+const DefaultInterval = 24 * time.Hour
+
+// Record is what is known about an address
+type Record struct {
+	// Country is the ISO 3166-1 alpha-2 code of the country the address
+	// is located in, or else registered to
+	Country string `json:"country,omitempty"`
+	ASN     uint   `json:"asn,omitempty"`
+	Org     string `json:"org,omitempty"`
+}
+
+// Source looks up addresses. A Record without a country or ASN means the
+// address is unknown.
+type Source interface {
+	Lookup(addr netip.Addr) (Record, error)
+}
+
+// mmdbRecord holds the fields of the GeoIP2 and GeoLite2 Country, City and
+// ASN databases that Lookup reads
+type mmdbRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+	ASN uint   `maxminddb:"autonomous_system_number"`
+	Org string `maxminddb:"autonomous_system_organization"`
+}
+
+// DB looks addresses up in one or more MaxMind DB files, typically a
+// country and an ASN database
+type DB struct {
+	readers []*maxminddb.Reader
+}
+
+// Open opens the MaxMind DB files at paths
+func Open(paths ...string) (*DB, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no GeoIP database given")
+	}
+	db := &DB{}
+	for _, path := range paths {
+		r, err := maxminddb.Open(path)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to open GeoIP database %s: %w", path, err)
+		}
+		db.readers = append(db.readers, r)
+	}
+	return db, nil
+}
+
+// Lookup returns what the databases know about addr, the first database
+// knowing a field winning
+func (db *DB) Lookup(addr netip.Addr) (Record, error) {
+	var rec Record
+	addr = addr.Unmap()
+	for _, r := range db.readers {
+		if addr.Is6() && r.Metadata.IPVersion == 4 {
+			continue
+		}
+		var m mmdbRecord
+		if err := r.Lookup(net.IP(addr.AsSlice()), &m); err != nil {
+			return Record{}, fmt.Errorf("failed to look up %s: %w", addr, err)
+		}
+		country := m.Country.ISOCode
+		if country == "" {
+			country = m.RegisteredCountry.ISOCode
+		}
+		if rec.Country == "" {
+			rec.Country = country
+		}
+		if rec.ASN == 0 {
+			rec.ASN, rec.Org = m.ASN, m.Org
+		}
+	}
+	return rec, nil
+}
+
+// Close closes the database files
+func (db *DB) Close() error {
+	var errs []error
+	for _, r := range db.readers {
+		errs = append(errs, r.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Tags returns tags with the geo-country and geo-asn tags of rec in place
+// of the ones they carry
+func Tags(tags []string, rec Record) []string {
+	out := make([]string, 0, len(tags)+2)
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, store.CountryTagPrefix) && !strings.HasPrefix(tag, store.ASNTagPrefix) {
+			out = append(out, tag)
+		}
+	}
+	if tag, err := store.CountryTag(rec.Country); err == nil {
+		out = append(out, tag)
+	}
+	if rec.ASN != 0 {
+		out = append(out, store.ASNTagPrefix+strconv.FormatUint(uint64(rec.ASN), 10))
+	}
+	return out
+}
+
+// Result counts the records whose tags an Annotate changed
+type Result struct {
+	Networks    int
+	Allocations int
+}
+
+// Annotator tags the public networks and allocations of Store with what
+// Source knows about them
+type Annotator struct {
+	Store  ipam.Store
+	Source Source
+}
+
+// Annotate tags every public network, by its first address, and its active
+// allocations, by their own, saving the records whose tags changed
+func (a *Annotator) Annotate(now time.Time) (Result, error) {
+	var result Result
+	networks, err := a.Store.ListNetworks()
+	if err != nil {
+		return result, fmt.Errorf("failed to list networks: %w", err)
+	}
+	for _, network := range networks {
+		prefix, err := netip.ParsePrefix(network.CIDR)
+		if err != nil || !rdap.IsPublic(prefix.Addr()) {
+			continue
+		}
+		rec, err := a.Source.Lookup(prefix.Masked().Addr())
+		if err != nil {
+			return result, err
+		}
+		if tags := Tags(network.Tags, rec); !slices.Equal(tags, network.Tags) {
+			// Save onto the current record in case it changed meanwhile
+			current, err := a.Store.GetNetwork(network.ID)
+			if err != nil {
+				continue
+			}
+			current.Tags = Tags(current.Tags, rec)
+			current.UpdatedAt = now
+			if err := a.Store.SaveNetwork(current); err != nil {
+				return result, fmt.Errorf("failed to save network %s: %w", network.ID, err)
+			}
+			result.Networks++
+		}
+
+		allocations, err := a.Store.ListAllocations(network.ID)
+		if err != nil {
+			return result, fmt.Errorf("failed to list allocations: %w", err)
+		}
+		for _, alloc := range allocations {
+			addr, err := netip.ParseAddr(alloc.IP)
+			if alloc.ReleasedAt != nil || err != nil {
+				continue
+			}
+			rec, err := a.Source.Lookup(addr)
+			if err != nil {
+				return result, err
+			}
+			tags := Tags(alloc.Tags, rec)
+			if slices.Equal(tags, alloc.Tags) {
+				continue
+			}
+			// Save onto the current record in case it changed meanwhile
+			current, err := a.Store.GetAllocation(alloc.ID)
+			if err != nil || current.ReleasedAt != nil {
+				continue
+			}
+			current.Tags = Tags(current.Tags, rec)
+			if err := a.Store.SaveAllocation(current); err != nil {
+				return result, fmt.Errorf("failed to save allocation %s: %w", alloc.ID, err)
+			}
+			result.Allocations++
+		}
+	}
+	return result, nil
+}
+
+// Run annotates now and every interval until stop is closed, logging
+// failures
+func Run(a *Annotator, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		result, err := a.Annotate(time.Now())
+		switch {
+		case err != nil:
+			log.Printf("geoip: annotation failed: %v", err)
+		case result.Networks > 0 || result.Allocations > 0:
+			log.Printf("geoip: annotated %d networks and %d allocations", result.Networks, result.Allocations)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package geoip
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encode encodes v in the MaxMind DB data format: strings, uint16, uint32,
+// and maps of them, up to 284 bytes or entries
+func encode(v any) []byte {
+	control := func(typ, size int) []byte {
+		if size >= 29 {
+			return []byte{byte(typ<<5 | 29), byte(size - 29)}
+		}
+		return []byte{byte(typ<<5 | size)}
+	}
+	switch v := v.(type) {
+	case string:
+		return append(control(2, len(v)), v...)
+	case uint16:
+		return append(control(5, 2), byte(v>>8), byte(v))
+	case uint32:
+		return append(control(6, 4), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		out := control(7, len(v))
+		for _, k := range keys {
+			out = append(out, encode(k)...)
+			out = append(out, encode(v[k])...)
+		}
+		return out
+	}
+	panic("unsupported type")
+}
+
+// writeDB writes an IPv4 MaxMind DB mapping each prefix to its record and
+// returns its path
+func writeDB(t *testing.T, records map[string]map[string]any) string {
+	t.Helper()
+	type node struct {
+		child [2]*node
+		data  int // Offset in the data section of a leaf, -1 otherwise
+	}
+	root := &node{data: -1}
+	var data []byte
+	for cidr, record := range records {
+		prefix := netip.MustParsePrefix(cidr)
+		ip := prefix.Addr().As4()
+		n := root
+		for i := 0; i < prefix.Bits(); i++ {
+			bit := ip[i/8] >> (7 - i%8) & 1
+			if n.child[bit] == nil {
+				n.child[bit] = &node{data: -1}
+			}
+			n = n.child[bit]
+		}
+		n.data = len(data)
+		data = append(data, encode(record)...)
+	}
+
+	// Number the inner nodes, then write two 24-bit records for each
+	var inner []*node
+	var walk func(n *node)
+	walk = func(n *node) {
+		if n == nil || n.data >= 0 {
+			return
+		}
+		inner = append(inner, n)
+		walk(n.child[0])
+		walk(n.child[1])
+	}
+	walk(root)
+	numbers := make(map[*node]int, len(inner))
+	for i, n := range inner {
+		numbers[n] = i
+	}
+	count := len(inner)
+	var tree []byte
+	for _, n := range inner {
+		for _, c := range n.child {
+			value := count // Not found
+			switch {
+			case c != nil && c.data >= 0:
+				value = count + 16 + c.data
+			case c != nil:
+				value = numbers[c]
+			}
+			tree = append(tree, byte(value>>16), byte(value>>8), byte(value))
+		}
+	}
+
+	db := append(tree, make([]byte, 16)...)
+	db = append(db, data...)
+	db = append(db, "\xAB\xCD\xEFMaxMind.com"...)
+	db = append(db, encode(map[string]any{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"database_type":               "Test",
+		"ip_version":                  uint16(4),
+		"node_count":                  uint32(count),
+		"record_size":                 uint16(24),
+	})...)
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	require.NoError(t, os.WriteFile(path, db, 0o600))
+	return path
+}
+
+func country(code string) map[string]any {
+	return map[string]any{"iso_code": code}
+}
+
+func openTestDB(t *testing.T) *DB {
+	countries := writeDB(t, map[string]map[string]any{
+		"81.2.69.0/24":   {"country": country("GB"), "registered_country": country("GB")},
+		"2.125.160.0/24": {"registered_country": country("SE")},
+	})
+	asns := writeDB(t, map[string]map[string]any{
+		"81.2.69.0/24": {"autonomous_system_number": uint32(20712), "autonomous_system_organization": "Andrews & Arnold Ltd"},
+	})
+	db, err := Open(countries, asns)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestLookup(t *testing.T) {
+	db := openTestDB(t)
+
+	rec, err := db.Lookup(netip.MustParseAddr("81.2.69.142"))
+	require.NoError(t, err)
+	assert.Equal(t, Record{Country: "GB", ASN: 20712, Org: "Andrews & Arnold Ltd"}, rec)
+
+	// The registered country stands in for an unknown location
+	rec, err = db.Lookup(netip.MustParseAddr("2.125.160.216"))
+	require.NoError(t, err)
+	assert.Equal(t, Record{Country: "SE"}, rec)
+
+	rec, err = db.Lookup(netip.MustParseAddr("8.8.8.8"))
+	require.NoError(t, err)
+	assert.Equal(t, Record{}, rec)
+
+	// IPv4 databases know nothing of IPv6 addresses
+	rec, err = db.Lookup(netip.MustParseAddr("2001:db8::1"))
+	require.NoError(t, err)
+	assert.Equal(t, Record{}, rec)
+
+	_, err = Open(filepath.Join(t.TempDir(), "missing.mmdb"))
+	assert.Error(t, err)
+}
+
+func TestAnnotate(t *testing.T) {
+	s, err := store.NewPebbleStore(t.TempDir())
+	require.NoError(t, err)
+	defer s.Close()
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	released := now.Add(-time.Hour)
+
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "aaisp", CIDR: "81.2.69.0/24", Tags: []string{"prod", "geo-country=FR"}}))
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "se", CIDR: "2.125.160.0/24"}))
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "lan", CIDR: "10.0.0.0/24"}))
+	require.NoError(t, s.SaveAllocation(&ipam.IPAllocation{ID: "web", NetworkID: "aaisp", IP: "81.2.69.10", Tags: []string{"web"}}))
+	require.NoError(t, s.SaveAllocation(&ipam.IPAllocation{ID: "old", NetworkID: "aaisp", IP: "81.2.69.11", ReleasedAt: &released}))
+	require.NoError(t, s.SaveAllocation(&ipam.IPAllocation{ID: "host", NetworkID: "lan", IP: "10.0.0.10"}))
+
+	a := &Annotator{Store: s, Source: openTestDB(t)}
+	result, err := a.Annotate(now)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Networks: 2, Allocations: 1}, result)
+
+	network, err := s.GetNetwork("aaisp")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"prod", "geo-country=GB", "geo-asn=20712"}, network.Tags)
+	assert.Equal(t, now, network.UpdatedAt)
+	network, err = s.GetNetwork("se")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"geo-country=SE"}, network.Tags)
+	alloc, err := s.GetAllocation("web")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web", "geo-country=GB", "geo-asn=20712"}, alloc.Tags)
+
+	// Released allocations and private networks are left alone
+	alloc, err = s.GetAllocation("old")
+	require.NoError(t, err)
+	assert.Empty(t, alloc.Tags)
+	network, err = s.GetNetwork("lan")
+	require.NoError(t, err)
+	assert.Empty(t, network.Tags)
+	alloc, err = s.GetAllocation("host")
+	require.NoError(t, err)
+	assert.Empty(t, alloc.Tags)
+
+	// Annotating again changes nothing
+	result, err = a.Annotate(now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, Result{}, result)
+}
@@ -0,0 +1,320 @@
+// Package gossip shares cluster metadata that Raft does not carry, such as
+// each node's API address, readiness and replication progress, over a SWIM
+// gossip protocol (hashicorp/memberlist). Gossip also detects failed nodes
+// independently of Raft, so a node's liveness is known even when it is not
+// in the Raft membership or the cluster has no leader.
+package gossip
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// Node states, as seen by the local node
+const (
+	StateAlive = "alive"
+	StateDead  = "dead" // Stopped answering
+	StateLeft  = "left" // Shut down cleanly
+)
+
+// Meta is what each node gossips about itself
+type Meta struct {
+	NodeID   uint64 `json:"node_id"`
+	APIAddr  string `json:"api_addr"`
+	RaftAddr string `json:"raft_addr"`
+
+	// Ready reports whether the node can serve requests, see Config.Ready
+	Ready bool `json:"ready"`
+
+	// Applied and CaughtUp report how far the node has applied the Raft
+	// log, see Config.Progress
+	Applied  uint64 `json:"applied,omitempty"`
+	CaughtUp bool   `json:"caught_up,omitempty"`
+
+	// Leaving is announced by Close just before the node leaves, so the
+	// others can tell a departure from a failure
+	Leaving bool `json:"leaving,omitempty"`
+}
+
+// Member is a node known through gossip
+type Member struct {
+	Meta
+	GossipAddr string    `json:"gossip_addr"`
+	State      string    `json:"state"`
+	Since      time.Time `json:"since"` // When State last changed
+}
+
+// Config configures gossip for one node
+type Config struct {
+	// BindAddr is the host:port gossip listens on, TCP and UDP. Port 0
+	// picks a free port.
+	BindAddr string
+
+	// AdvertiseAddr is the host:port other nodes reach this one at, when
+	// it differs from BindAddr, e.g. behind NAT
+	AdvertiseAddr string
+
+	// Seeds are gossip addresses of other nodes to join through; any one
+	// that answers is enough
+	Seeds []string
+
+	// Meta describes this node. Ready, Applied and CaughtUp are kept up to
+	// date from the Ready and Progress functions.
+	Meta Meta
+
+	// Ready, when set, is polled every ReadyInterval and its result
+	// gossiped as Meta.Ready
+	Ready         func() bool
+	ReadyInterval time.Duration
+
+	// Progress, when set, is polled every ReadyInterval too and its results
+	// gossiped as Meta.Applied and Meta.CaughtUp
+	Progress func() (applied uint64, caughtUp bool)
+
+	// LogOutput receives memberlist's log, by default discarded
+	LogOutput io.Writer
+}
+
+// Gossip is a running gossip member
+type Gossip struct {
+	ml   *memberlist.Memberlist
+	stop chan struct{}
+	done chan struct{}
+
+	mu      sync.RWMutex
+	meta    Meta
+	members map[string]*Member // By node name, including departed nodes
+}
+
+// Start joins the gossip pool. Seeds that cannot be reached are logged and
+// retried in the background, so the first node of a cluster can start
+// before the others.
+func Start(cfg Config) (*Gossip, error) {
+	host, port, err := splitHostPort(cfg.BindAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gossip address %q: %w", cfg.BindAddr, err)
+	}
+
+	g := &Gossip{
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		meta:    cfg.Meta,
+		members: map[string]*Member{},
+	}
+	g.meta = cfg.poll(g.meta)
+
+	mc := memberlist.DefaultLANConfig()
+	mc.Name = nodeName(cfg.Meta.NodeID)
+	mc.BindAddr = host
+	mc.BindPort = port
+	mc.AdvertisePort = port
+	if cfg.AdvertiseAddr != "" {
+		if mc.AdvertiseAddr, mc.AdvertisePort, err = splitHostPort(cfg.AdvertiseAddr); err != nil {
+			return nil, fmt.Errorf("invalid gossip advertise address %q: %w", cfg.AdvertiseAddr, err)
+		}
+		// memberlist advertises an IP, not a name
+		if net.ParseIP(mc.AdvertiseAddr) == nil {
+			ips, err := net.LookupIP(mc.AdvertiseAddr)
+			if err != nil || len(ips) == 0 {
+				return nil, fmt.Errorf("failed to resolve gossip advertise address %q: %v", cfg.AdvertiseAddr, err)
+			}
+			mc.AdvertiseAddr = ips[0].String()
+		}
+	}
+	mc.Delegate = (*delegate)(g)
+	mc.Events = (*events)(g)
+	mc.LogOutput = cfg.LogOutput
+	if mc.LogOutput == nil {
+		mc.LogOutput = io.Discard
+	}
+
+	if g.ml, err = memberlist.Create(mc); err != nil {
+		return nil, fmt.Errorf("failed to start gossip: %w", err)
+	}
+
+	interval := cfg.ReadyInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	go g.run(cfg, interval)
+	return g, nil
+}
+
+// run joins the seeds until one answers and keeps the polled Meta fields
+// current
+func (g *Gossip) run(cfg Config, interval time.Duration) {
+	defer close(g.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	joined := len(cfg.Seeds) == 0
+	for {
+		if !joined {
+			if _, err := g.ml.Join(cfg.Seeds); err != nil {
+				log.Printf("gossip: failed to join %v: %v", cfg.Seeds, err)
+			} else {
+				joined = true
+			}
+		}
+		g.refresh(cfg)
+
+		select {
+		case <-g.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll returns meta with the fields cfg polls for updated
+func (cfg Config) poll(meta Meta) Meta {
+	if cfg.Ready != nil {
+		meta.Ready = cfg.Ready()
+	}
+	if cfg.Progress != nil {
+		meta.Applied, meta.CaughtUp = cfg.Progress()
+	}
+	return meta
+}
+
+// refresh polls this node's state and gossips any change
+func (g *Gossip) refresh(cfg Config) {
+	g.mu.RLock()
+	meta := g.meta
+	g.mu.RUnlock()
+	updated := cfg.poll(meta)
+	if updated == meta {
+		return
+	}
+
+	g.mu.Lock()
+	g.meta = updated
+	g.mu.Unlock()
+	if err := g.ml.UpdateNode(5 * time.Second); err != nil {
+		log.Printf("gossip: failed to announce metadata: %v", err)
+	}
+}
+
+// Addr returns the address gossip listens on
+func (g *Gossip) Addr() string {
+	return g.ml.LocalNode().Address()
+}
+
+// Members returns every node seen, this one included, ordered by node ID.
+// Nodes that failed or left stay listed with their last known metadata.
+func (g *Gossip) Members() []Member {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	members := make([]Member, 0, len(g.members))
+	for _, m := range g.members {
+		members = append(members, *m)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].NodeID < members[j].NodeID })
+	return members
+}
+
+// Close leaves the pool, telling the other nodes this one is gone rather
+// than failed, and stops gossip
+func (g *Gossip) Close() error {
+	close(g.stop)
+	<-g.done
+
+	g.mu.Lock()
+	g.meta.Leaving = true
+	g.mu.Unlock()
+	err := g.ml.UpdateNode(5 * time.Second)
+	if leaveErr := g.ml.Leave(5 * time.Second); err == nil {
+		err = leaveErr
+	}
+	if shutdownErr := g.ml.Shutdown(); err == nil {
+		err = shutdownErr
+	}
+	return err
+}
+
+// observe records a node's metadata from a memberlist event. gone is set
+// when the node left or failed.
+func (g *Gossip) observe(n *memberlist.Node, gone bool) {
+	var meta Meta
+	if err := json.Unmarshal(n.Meta, &meta); err != nil {
+		// Not one of ours, or a newer format
+		return
+	}
+	state := StateAlive
+	if gone {
+		state = StateDead
+		if meta.Leaving {
+			state = StateLeft
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	m, ok := g.members[n.Name]
+	if !ok {
+		m = &Member{}
+		g.members[n.Name] = m
+	}
+	if !ok || m.State != state {
+		m.Since = time.Now()
+	}
+	m.Meta = meta
+	m.GossipAddr = n.Address()
+	m.State = state
+}
+
+// nodeName names a node in the gossip pool
+func nodeName(nodeID uint64) string {
+	return "node-" + strconv.FormatUint(nodeID, 10)
+}
+
+func splitHostPort(addr string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port %q", portStr)
+	}
+	if host == "" {
+		host = "0.0.0.0"
+	}
+	return host, port, nil
+}
+
+// delegate supplies this node's metadata to memberlist
+type delegate Gossip
+
+func (d *delegate) NodeMeta(limit int) []byte {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	data, _ := json.Marshal(d.meta)
+	if len(data) > limit {
+		log.Printf("gossip: metadata of %d bytes exceeds the limit of %d", len(data), limit)
+		return nil
+	}
+	return data
+}
+
+func (d *delegate) NotifyMsg([]byte)                           {}
+func (d *delegate) GetBroadcasts(overhead, limit int) [][]byte { return nil }
+func (d *delegate) LocalState(join bool) []byte                { return nil }
+func (d *delegate) MergeRemoteState(buf []byte, join bool)     {}
+
+// events tracks membership changes
+type events Gossip
+
+func (e *events) NotifyJoin(n *memberlist.Node)   { (*Gossip)(e).observe(n, false) }
+func (e *events) NotifyLeave(n *memberlist.Node)  { (*Gossip)(e).observe(n, true) }
+func (e *events) NotifyUpdate(n *memberlist.Node) { (*Gossip)(e).observe(n, false) }
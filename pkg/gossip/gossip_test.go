@@ -0,0 +1,95 @@
+package gossip
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startNode starts gossip for node id on a free loopback port
+func startNode(t *testing.T, id uint64, ready func() bool, seeds ...string) *Gossip {
+	t.Helper()
+	g, err := Start(Config{
+		BindAddr:      "127.0.0.1:0",
+		Seeds:         seeds,
+		Meta:          Meta{NodeID: id, APIAddr: "http://127.0.0.1:808" + string(rune('0'+id)), RaftAddr: "127.0.0.1:500" + string(rune('0'+id))},
+		Ready:         ready,
+		ReadyInterval: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	return g
+}
+
+// states returns each member's state by node ID
+func states(g *Gossip) map[uint64]string {
+	s := map[uint64]string{}
+	for _, m := range g.Members() {
+		s[m.NodeID] = m.State
+	}
+	return s
+}
+
+func TestGossip(t *testing.T) {
+	var ready atomic.Bool
+	ready.Store(true)
+
+	g1 := startNode(t, 1, nil)
+	defer g1.Close()
+	g2 := startNode(t, 2, ready.Load, g1.Addr())
+	defer g2.Close()
+	g3 := startNode(t, 3, nil, g1.Addr())
+
+	// Every node learns every other's metadata
+	allAlive := map[uint64]string{1: StateAlive, 2: StateAlive, 3: StateAlive}
+	for _, g := range []*Gossip{g1, g2, g3} {
+		require.Eventually(t, func() bool { return assert.ObjectsAreEqual(allAlive, states(g)) }, 10*time.Second, 50*time.Millisecond)
+	}
+	members := g3.Members()
+	assert.Equal(t, uint64(2), members[1].NodeID)
+	assert.Equal(t, "http://127.0.0.1:8082", members[1].APIAddr)
+	assert.Equal(t, "127.0.0.1:5002", members[1].RaftAddr)
+	assert.Equal(t, g2.Addr(), members[1].GossipAddr)
+	assert.True(t, members[1].Ready)
+
+	// Readiness changes spread
+	ready.Store(false)
+	require.Eventually(t, func() bool { return !g1.Members()[1].Ready }, 10*time.Second, 50*time.Millisecond)
+
+	// A node that leaves stays listed
+	require.NoError(t, g3.Close())
+	require.Eventually(t, func() bool { return states(g1)[3] == StateLeft }, 10*time.Second, 50*time.Millisecond)
+	assert.Len(t, g1.Members(), 3)
+
+	// Progress spreads too
+	var applied atomic.Int64
+	applied.Store(40)
+	g4, err := Start(Config{
+		BindAddr:      "127.0.0.1:0",
+		Seeds:         []string{g1.Addr()},
+		Meta:          Meta{NodeID: 4},
+		Progress:      func() (uint64, bool) { return uint64(applied.Load()), applied.Load() >= 100 },
+		ReadyInterval: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer g4.Close()
+	require.Eventually(t, func() bool {
+		m := g1.Members()
+		return len(m) == 4 && m[3].Applied == 40 && !m[3].CaughtUp
+	}, 10*time.Second, 50*time.Millisecond)
+	applied.Store(100)
+	require.Eventually(t, func() bool {
+		m := g1.Members()[3]
+		return m.Applied == 100 && m.CaughtUp
+	}, 10*time.Second, 50*time.Millisecond)
+}
+
+func TestStartErrors(t *testing.T) {
+	_, err := Start(Config{BindAddr: "localhost"})
+	assert.ErrorContains(t, err, "invalid gossip address")
+
+	_, err = Start(Config{BindAddr: "127.0.0.1:0", AdvertiseAddr: "no-such-host.invalid:7946"})
+	assert.ErrorContains(t, err, "advertise address")
+}
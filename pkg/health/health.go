@@ -0,0 +1,373 @@
+// Package health probes allocated addresses for reachability and tracks when
+// each was last seen, so allocations nobody uses any more can be reclaimed.
+//
+// Results are recorded in allocation tags so they travel with the allocation
+// through every store and export: last-seen=<RFC3339 time> is the last
+// successful probe, and stale marks an allocation that has not responded
+// within the stale threshold.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+const (
+	LastSeenTagPrefix = "last-seen="
+	StaleTag          = "stale"
+)
+
+// DefaultPorts are probed by a TCPProber without ports
+var DefaultPorts = []int{22, 80, 443}
+
+// maxConcurrentProbes bounds the probes in flight during a Check
+const maxConcurrentProbes = 32
+
+// ErrUnreachable is returned by probes that got no answer
+var ErrUnreachable = errors.New("host unreachable")
+
+// Prober tests whether the host at an address is up
+type Prober interface {
+	Probe(ctx context.Context, addr netip.Addr) error
+}
+
+// TCPProber connects to a list of ports. A host is up when any connection
+// succeeds or is refused, since a refusal is also an answer from the host.
+type TCPProber struct {
+	Ports   []int
+	Timeout time.Duration
+}
+
+func (p *TCPProber) Probe(ctx context.Context, addr netip.Addr) error {
+	ports := p.Ports
+	if len(ports) == 0 {
+		ports = DefaultPorts
+	}
+	dialer := net.Dialer{Timeout: p.Timeout}
+	for _, port := range ports {
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(addr.String(), strconv.Itoa(port)))
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if refused(err) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no answer on ports %s", ErrUnreachable, joinPorts(ports))
+}
+
+func joinPorts(ports []int) string {
+	s := make([]string, len(ports))
+	for i, port := range ports {
+		s[i] = strconv.Itoa(port)
+	}
+	return strings.Join(s, ",")
+}
+
+// ICMPProber sends an ICMP echo request. It needs a raw socket, so the
+// process must run as root or with CAP_NET_RAW. Only IPv4 is supported.
+type ICMPProber struct {
+	Timeout time.Duration
+}
+
+func (p *ICMPProber) Probe(ctx context.Context, addr netip.Addr) error {
+	if !addr.Is4() {
+		return fmt.Errorf("ICMP probes support IPv4 only")
+	}
+	dialer := net.Dialer{Timeout: p.Timeout}
+	conn, err := dialer.DialContext(ctx, "ip4:icmp", addr.String())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	id := uint16(os.Getpid())
+	if _, err := conn.Write(echoRequest(id, 1)); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(p.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrUnreachable, err)
+		}
+		if isEchoReply(buf[:n], id) {
+			return nil
+		}
+	}
+}
+
+// echoRequest builds an ICMP echo request
+func echoRequest(id, seq uint16) []byte {
+	msg := []byte{8, 0, 0, 0, byte(id >> 8), byte(id), byte(seq >> 8), byte(seq), 'i', 'p', 'a', 'm'}
+	sum := checksum(msg)
+	msg[2], msg[3] = byte(sum>>8), byte(sum)
+	return msg
+}
+
+// isEchoReply reports whether packet, with or without its IPv4 header, is an
+// echo reply for id
+func isEchoReply(packet []byte, id uint16) bool {
+	if len(packet) >= 20 && packet[0]>>4 == 4 {
+		packet = packet[int(packet[0]&0x0f)*4:]
+	}
+	return len(packet) >= 8 && packet[0] == 0 && uint16(packet[4])<<8|uint16(packet[5]) == id
+}
+
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// LastSeen returns when alloc last answered a probe
+func LastSeen(alloc *ipam.IPAllocation) (time.Time, bool) {
+	for _, tag := range alloc.Tags {
+		if value, ok := strings.CutPrefix(tag, LastSeenTagPrefix); ok {
+			t, err := time.Parse(time.RFC3339, value)
+			return t, err == nil
+		}
+	}
+	return time.Time{}, false
+}
+
+// IsStale reports whether alloc is tagged stale
+func IsStale(alloc *ipam.IPAllocation) bool {
+	return slices.Contains(alloc.Tags, StaleTag)
+}
+
+// Seen returns alloc's tags after its host was seen up at, by a probe or
+// otherwise, e.g. a network scan: last-seen=at in place of an earlier
+// last-seen, without the stale tag. A later last-seen is kept.
+func Seen(alloc *ipam.IPAllocation, at time.Time) []string {
+	if last, ok := LastSeen(alloc); ok && !last.Before(at.Truncate(time.Second)) {
+		return alloc.Tags
+	}
+	return updatedTags(alloc, true, at, 0)
+}
+
+// silentSince returns when alloc was last known to be in use: its last
+// successful probe, or its allocation when it has never answered
+func silentSince(alloc *ipam.IPAllocation) time.Time {
+	if t, ok := LastSeen(alloc); ok {
+		return t
+	}
+	return alloc.AllocatedAt
+}
+
+// Result is the outcome of probing one allocation
+type Result struct {
+	Allocation *ipam.IPAllocation
+	Up         bool
+	Err        error
+
+	// Tags are the allocation's tags updated with the outcome
+	Tags []string
+}
+
+// Changed reports whether the probe changed the allocation's tags
+func (r *Result) Changed() bool {
+	return !slices.Equal(r.Tags, r.Allocation.Tags)
+}
+
+// Stale reports whether the allocation is stale after the probe
+func (r *Result) Stale() bool {
+	return slices.Contains(r.Tags, StaleTag)
+}
+
+// Check probes the active single-address allocations among allocations.
+// An allocation that answers gets last-seen=now and loses the stale tag; one
+// that has been silent for longer than staleAfter is tagged stale. Ranges
+// are pools rather than hosts and are not probed.
+func Check(ctx context.Context, prober Prober, allocations []*ipam.IPAllocation, now time.Time, staleAfter time.Duration) []*Result {
+	var results []*Result
+	for _, alloc := range allocations {
+		if alloc.ReleasedAt != nil || alloc.EndIP != "" || (alloc.ExpiresAt != nil && alloc.ExpiresAt.Before(now)) {
+			continue
+		}
+		results = append(results, &Result{Allocation: alloc})
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentProbes)
+	for _, r := range results {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(r *Result) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			addr, err := netip.ParseAddr(r.Allocation.IP)
+			if err != nil {
+				r.Err = fmt.Errorf("invalid IP %q", r.Allocation.IP)
+			} else {
+				r.Err = prober.Probe(ctx, addr.Unmap())
+			}
+			r.Up = r.Err == nil
+			r.Tags = updatedTags(r.Allocation, r.Up, now, staleAfter)
+		}(r)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// updatedTags returns alloc's tags after a probe at now
+func updatedTags(alloc *ipam.IPAllocation, up bool, now time.Time, staleAfter time.Duration) []string {
+	tags := make([]string, 0, len(alloc.Tags)+1)
+	for _, tag := range alloc.Tags {
+		if tag == StaleTag || (up && strings.HasPrefix(tag, LastSeenTagPrefix)) {
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	switch {
+	case up:
+		tags = append(tags, LastSeenTagPrefix+now.UTC().Format(time.RFC3339))
+	case now.Sub(silentSince(alloc)) > staleAfter:
+		tags = append(tags, StaleTag)
+	}
+	return tags
+}
+
+// isHealthTag reports whether tag is one the check maintains
+func isHealthTag(tag string) bool {
+	return tag == StaleTag || strings.HasPrefix(tag, LastSeenTagPrefix)
+}
+
+// withHealthTags returns tags with their last-seen= and stale tags replaced
+// by those of probed
+func withHealthTags(tags, probed []string) []string {
+	merged := slices.DeleteFunc(slices.Clone(tags), isHealthTag)
+	for _, tag := range probed {
+		if isHealthTag(tag) {
+			merged = append(merged, tag)
+		}
+	}
+	return merged
+}
+
+// CheckStore probes the allocations of every network in s and saves the
+// updated tags. It returns the results, including unchanged ones.
+func CheckStore(ctx context.Context, s ipam.Store, prober Prober, now time.Time, staleAfter time.Duration) ([]*Result, error) {
+	networks, err := s.ListNetworks()
+	if err != nil {
+		return nil, err
+	}
+	var all []*Result
+	for _, network := range networks {
+		results, err := CheckNetwork(ctx, s, network.ID, prober, now, staleAfter)
+		if err != nil {
+			return all, err
+		}
+		all = append(all, results...)
+	}
+	return all, nil
+}
+
+// CheckNetwork probes the allocations of one network and saves the updated
+// tags
+func CheckNetwork(ctx context.Context, s ipam.Store, networkID string, prober Prober, now time.Time, staleAfter time.Duration) ([]*Result, error) {
+	allocations, err := s.ListAllocations(networkID)
+	if err != nil {
+		return nil, err
+	}
+	results := Check(ctx, prober, allocations, now, staleAfter)
+	for _, r := range results {
+		if !r.Changed() {
+			continue
+		}
+		// Save onto the current record in case it changed while probing,
+		// changing only the tags the check maintains
+		current, err := s.GetAllocation(r.Allocation.ID)
+		if err != nil || current.ReleasedAt != nil {
+			continue
+		}
+		current.Tags = withHealthTags(current.Tags, r.Tags)
+		if err := s.SaveAllocation(current); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// Run checks every network of s each interval until stop is closed, logging
+// failures
+func Run(s ipam.Store, prober Prober, interval, staleAfter time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := CheckStore(context.Background(), s, prober, time.Now(), staleAfter); err != nil {
+				log.Printf("health check failed: %v", err)
+			}
+		}
+	}
+}
+
+// Candidate is an allocation that has not answered probes for a while
+type Candidate struct {
+	Allocation *ipam.IPAllocation
+	LastSeen   *time.Time
+	Silent     time.Duration
+}
+
+// Candidates returns the active allocations silent for at least olderThan,
+// longest silent first. Allocations never probed successfully count from
+// their allocation time; only allocations probed at least once (carrying a
+// last-seen or stale tag) are considered.
+func Candidates(allocations []*ipam.IPAllocation, now time.Time, olderThan time.Duration) []Candidate {
+	var out []Candidate
+	for _, alloc := range allocations {
+		if alloc.ReleasedAt != nil || alloc.EndIP != "" {
+			continue
+		}
+		seen, ok := LastSeen(alloc)
+		if !ok && !IsStale(alloc) {
+			continue
+		}
+		silent := now.Sub(silentSince(alloc))
+		if silent < olderThan {
+			continue
+		}
+		c := Candidate{Allocation: alloc, Silent: silent}
+		if ok {
+			c.LastSeen = &seen
+		}
+		out = append(out, c)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Silent > out[j].Silent })
+	return out
+}
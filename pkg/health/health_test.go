@@ -0,0 +1,165 @@
+package health
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProber answers for the addresses in up
+type fakeProber map[string]bool
+
+func (p fakeProber) Probe(_ context.Context, addr netip.Addr) error {
+	if p[addr.String()] {
+		return nil
+	}
+	return ErrUnreachable
+}
+
+func TestCheck(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-60 * 24 * time.Hour)
+	recent := now.Add(-time.Hour)
+	past := now.Add(-time.Minute)
+
+	allocations := []*ipam.IPAllocation{
+		{ID: "up", IP: "10.0.0.1", AllocatedAt: old, Tags: []string{"env=prod", StaleTag, LastSeenTagPrefix + old.Format(time.RFC3339)}},
+		{ID: "silent-old", IP: "10.0.0.2", AllocatedAt: old},
+		{ID: "silent-new", IP: "10.0.0.3", AllocatedAt: recent},
+		{ID: "seen-recently", IP: "10.0.0.4", AllocatedAt: old, Tags: []string{LastSeenTagPrefix + recent.Format(time.RFC3339)}},
+		{ID: "released", IP: "10.0.0.5", ReleasedAt: &past},
+		{ID: "expired", IP: "10.0.0.6", ExpiresAt: &past},
+		{ID: "range", IP: "10.0.0.10", EndIP: "10.0.0.20"},
+	}
+
+	results := Check(context.Background(), fakeProber{"10.0.0.1": true}, allocations, now, 30*24*time.Hour)
+	byID := make(map[string]*Result)
+	for _, r := range results {
+		byID[r.Allocation.ID] = r
+	}
+	require.Len(t, byID, 4)
+
+	assert.True(t, byID["up"].Up)
+	assert.Equal(t, []string{"env=prod", "last-seen=2026-10-16T12:00:00Z"}, byID["up"].Tags)
+	assert.False(t, byID["up"].Stale())
+
+	assert.False(t, byID["silent-old"].Up)
+	assert.True(t, byID["silent-old"].Stale())
+
+	assert.False(t, byID["silent-new"].Stale())
+	assert.False(t, byID["silent-new"].Changed())
+
+	assert.False(t, byID["seen-recently"].Stale())
+	assert.False(t, byID["seen-recently"].Changed())
+}
+
+func TestCheckNetworkAndCandidates(t *testing.T) {
+	dir, err := os.MkdirTemp("", "health-test-*")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	s, err := store.NewPebbleStore(filepath.Join(dir, "db"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "net1", CIDR: "10.0.0.0/24"}))
+	require.NoError(t, s.SaveAllocation(&ipam.IPAllocation{ID: "a1", NetworkID: "net1", IP: "10.0.0.1", AllocatedAt: now.Add(-40 * 24 * time.Hour)}))
+	require.NoError(t, s.SaveAllocation(&ipam.IPAllocation{ID: "a2", NetworkID: "net1", IP: "10.0.0.2", AllocatedAt: now.Add(-90 * 24 * time.Hour)}))
+	require.NoError(t, s.SaveAllocation(&ipam.IPAllocation{ID: "a3", NetworkID: "net1", IP: "10.0.0.3", AllocatedAt: now.Add(-90 * 24 * time.Hour)}))
+
+	results, err := CheckStore(context.Background(), s, fakeProber{"10.0.0.3": true}, now, 30*24*time.Hour)
+	require.NoError(t, err)
+	assert.Len(t, results, 3)
+
+	a3, err := s.GetAllocation("a3")
+	require.NoError(t, err)
+	seen, ok := LastSeen(a3)
+	require.True(t, ok)
+	assert.True(t, seen.Equal(now))
+
+	allocations, err := s.ListAllocations("net1")
+	require.NoError(t, err)
+	candidates := Candidates(allocations, now, 30*24*time.Hour)
+	require.Len(t, candidates, 2)
+	assert.Equal(t, "a2", candidates[0].Allocation.ID)
+	assert.Equal(t, "a1", candidates[1].Allocation.ID)
+	assert.Nil(t, candidates[0].LastSeen)
+
+	// A later probe that succeeds clears the stale flag
+	_, err = CheckNetwork(context.Background(), s, "net1", fakeProber{"10.0.0.2": true}, now.Add(time.Hour), 30*24*time.Hour)
+	require.NoError(t, err)
+	a2, err := s.GetAllocation("a2")
+	require.NoError(t, err)
+	assert.False(t, IsStale(a2))
+}
+
+// editingProber retags an allocation while it is being probed, as a
+// concurrent API request would
+type editingProber struct {
+	store ipam.Store
+	id    string
+	tags  []string
+}
+
+func (p *editingProber) Probe(_ context.Context, addr netip.Addr) error {
+	alloc, err := p.store.GetAllocation(p.id)
+	if err != nil {
+		return err
+	}
+	alloc.Tags = p.tags
+	return p.store.SaveAllocation(alloc)
+}
+
+func TestCheckNetworkKeepsConcurrentTags(t *testing.T) {
+	s, err := store.NewPebbleStore(t.TempDir())
+	require.NoError(t, err)
+	defer s.Close()
+
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "net1", CIDR: "10.0.0.0/24"}))
+	require.NoError(t, s.SaveAllocation(&ipam.IPAllocation{ID: "a1", NetworkID: "net1", IP: "10.0.0.1",
+		Tags: []string{"env=prod", "stale"}, AllocatedAt: now.Add(-40 * 24 * time.Hour)}))
+
+	// The tags change between the probe and the save; the check only
+	// replaces its own
+	prober := &editingProber{store: s, id: "a1", tags: []string{"env=staging", "owner=alice", "stale"}}
+	_, err = CheckNetwork(context.Background(), s, "net1", prober, now, 30*24*time.Hour)
+	require.NoError(t, err)
+
+	a1, err := s.GetAllocation("a1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"env=staging", "owner=alice", "last-seen=2026-10-16T12:00:00Z"}, a1.Tags)
+}
+
+func TestTCPProber(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	p := &TCPProber{Ports: []int{port}, Timeout: time.Second}
+	assert.NoError(t, p.Probe(context.Background(), netip.MustParseAddr("127.0.0.1")))
+
+	// A refused connection still proves the host is up
+	ln.Close()
+	assert.NoError(t, p.Probe(context.Background(), netip.MustParseAddr("127.0.0.1")))
+}
+
+func TestEchoRequest(t *testing.T) {
+	msg := echoRequest(0x1234, 1)
+	assert.Equal(t, uint16(0), checksum(msg))
+
+	reply := append([]byte(nil), msg...)
+	reply[0] = 0
+	assert.True(t, isEchoReply(reply, 0x1234))
+	assert.False(t, isEchoReply(reply, 0x4321))
+	assert.False(t, isEchoReply(msg, 0x1234))
+}
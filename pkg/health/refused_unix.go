@@ -0,0 +1,14 @@
+//go:build !windows
+
+package health
+
+import (
+	"errors"
+	"syscall"
+)
+
+// refused reports whether a dial failed because the port is closed, which
+// still proves the host is up
+func refused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
@@ -0,0 +1,15 @@
+package health
+
+import (
+	"errors"
+	"syscall"
+)
+
+// wsaeconnrefused is the Winsock error for a connection refused
+const wsaeconnrefused = syscall.Errno(10061)
+
+// refused reports whether a dial failed because the port is closed, which
+// still proves the host is up
+func refused(err error) bool {
+	return errors.Is(err, wsaeconnrefused) || errors.Is(err, syscall.ECONNREFUSED)
+}
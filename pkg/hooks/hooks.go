@@ -0,0 +1,205 @@
+// Package hooks lets custom business logic take part in the allocation
+// lifecycle without patching the allocator. Hooks are compiled in and
+// registered from an init function, typically in a package imported for its
+// side effects:
+//
+//	type cmdbHook struct{ hooks.Base }
+//
+//	func (cmdbHook) Name() string { return "cmdb" }
+//
+//	func (cmdbHook) PreAllocate(ctx context.Context, req *ipam.AllocationRequest) error {
+//		if req.Hostname == "" {
+//			return errors.New("a hostname is required")
+//		}
+//		req.Tags = append(req.Tags, "cmdb=pending")
+//		return nil
+//	}
+//
+//	func init() { hooks.Register(cmdbHook{}) }
+//
+// The API server and the CLI run every registered hook, in registration
+// order, around each allocation and release.
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// ErrVetoed is returned when a pre hook rejects an operation
+var ErrVetoed = errors.New("operation vetoed by hook")
+
+// Hook is called around allocations and releases. Pre hooks may change the
+// request or allocation metadata and veto the operation by returning an
+// error. Post hooks run once the operation has succeeded; changes they make
+// to the hostname, description or tags are saved, and their errors are
+// logged without undoing the operation.
+type Hook interface {
+	// Name identifies the hook in registrations, vetoes and logs
+	Name() string
+
+	PreAllocate(ctx context.Context, req *ipam.AllocationRequest) error
+	PostAllocate(ctx context.Context, alloc *ipam.IPAllocation) error
+	PreRelease(ctx context.Context, alloc *ipam.IPAllocation) error
+	PostRelease(ctx context.Context, alloc *ipam.IPAllocation) error
+}
+
+// Base implements every Hook method except Name as a no-op. Embed it to
+// implement only the stages a hook needs.
+type Base struct{}
+
+func (Base) PreAllocate(context.Context, *ipam.AllocationRequest) error { return nil }
+func (Base) PostAllocate(context.Context, *ipam.IPAllocation) error     { return nil }
+func (Base) PreRelease(context.Context, *ipam.IPAllocation) error       { return nil }
+func (Base) PostRelease(context.Context, *ipam.IPAllocation) error      { return nil }
+
+var (
+	mu         sync.Mutex
+	registered Chain
+)
+
+// Register adds h to the hooks run by the API server and CLI. It panics if
+// h is nil or its name is already registered.
+func Register(h Hook) {
+	mu.Lock()
+	defer mu.Unlock()
+	if h == nil {
+		panic("hooks: Register hook is nil")
+	}
+	for _, other := range registered {
+		if other.Name() == h.Name() {
+			panic("hooks: Register called twice for hook " + h.Name())
+		}
+	}
+	registered = append(registered, h)
+}
+
+// Registered returns the registered hooks in registration order
+func Registered() Chain {
+	mu.Lock()
+	defer mu.Unlock()
+	return slices.Clone(registered)
+}
+
+// Chain runs hooks in order
+type Chain []Hook
+
+// Allocate runs the pre hooks on req, allocates with allocate and runs the
+// post hooks on the result, saving their metadata changes to s
+func (c Chain) Allocate(ctx context.Context, s ipam.Store, req *ipam.AllocationRequest, allocate func(*ipam.AllocationRequest) (*ipam.IPAllocation, error)) (*ipam.IPAllocation, error) {
+	if err := c.PreAllocate(ctx, req); err != nil {
+		return nil, err
+	}
+
+	alloc, err := allocate(req)
+	if err != nil {
+		return nil, err
+	}
+	return c.PostAllocate(ctx, s, alloc), nil
+}
+
+// PreAllocate runs the pre hooks on req, for callers that allocate in
+// stages, e.g. several allocations saved together
+func (c Chain) PreAllocate(ctx context.Context, req *ipam.AllocationRequest) error {
+	for _, h := range c {
+		if err := h.PreAllocate(ctx, req); err != nil {
+			return fmt.Errorf("%w %s: %v", ErrVetoed, h.Name(), err)
+		}
+	}
+	return nil
+}
+
+// PostAllocate runs the post hooks on alloc once it is saved, saving their
+// metadata changes to s, and returns the allocation as saved
+func (c Chain) PostAllocate(ctx context.Context, s ipam.Store, alloc *ipam.IPAllocation) *ipam.IPAllocation {
+	if len(c) == 0 {
+		return alloc
+	}
+	return c.post(ctx, s, alloc, clone(alloc), Hook.PostAllocate)
+}
+
+// Release runs the pre hooks on alloc, releases it with release and runs the
+// post hooks on the released allocation, saving metadata changes made by
+// either to s
+func (c Chain) Release(ctx context.Context, s ipam.Store, alloc *ipam.IPAllocation, release func() error) error {
+	if len(c) == 0 {
+		return release()
+	}
+
+	pre := clone(alloc)
+	if err := c.PreRelease(ctx, pre); err != nil {
+		return err
+	}
+
+	if err := release(); err != nil {
+		return err
+	}
+
+	released, err := s.GetAllocation(alloc.ID)
+	if err != nil {
+		log.Printf("hooks: post-release hooks skipped for %s: %v", alloc.ID, err)
+		return nil
+	}
+	c.PostRelease(ctx, s, released, pre)
+	return nil
+}
+
+// PreRelease runs the pre hooks on pre, a copy of an allocation about to be
+// released, for callers that release in stages, e.g. several allocations
+// saved together. Hand pre to PostRelease once the release is saved.
+func (c Chain) PreRelease(ctx context.Context, pre *ipam.IPAllocation) error {
+	for _, h := range c {
+		if err := h.PreRelease(ctx, pre); err != nil {
+			return fmt.Errorf("%w %s: %v", ErrVetoed, h.Name(), err)
+		}
+	}
+	return nil
+}
+
+// PostRelease runs the post hooks on released, the allocation as saved,
+// saving the metadata the pre hooks gave pre and the changes of the post
+// hooks to s
+func (c Chain) PostRelease(ctx context.Context, s ipam.Store, released, pre *ipam.IPAllocation) {
+	if len(c) == 0 {
+		return
+	}
+	changed := clone(released)
+	changed.Hostname, changed.Description, changed.Tags = pre.Hostname, pre.Description, pre.Tags
+	c.post(ctx, s, released, changed, Hook.PostRelease)
+}
+
+// post runs stage of every hook on changed, a copy of the stored
+// allocation, and saves metadata that differs from stored
+func (c Chain) post(ctx context.Context, s ipam.Store, stored, changed *ipam.IPAllocation, stage func(Hook, context.Context, *ipam.IPAllocation) error) *ipam.IPAllocation {
+	for _, h := range c {
+		if err := stage(h, ctx, changed); err != nil {
+			log.Printf("hooks: %s failed for allocation %s: %v", h.Name(), stored.ID, err)
+		}
+	}
+
+	if changed.Hostname == stored.Hostname && changed.Description == stored.Description && slices.Equal(changed.Tags, stored.Tags) {
+		return stored
+	}
+
+	// Only metadata may change; the addresses and lifecycle stay the
+	// allocator's
+	saved := clone(stored)
+	saved.Hostname, saved.Description, saved.Tags = changed.Hostname, changed.Description, changed.Tags
+	if err := s.SaveAllocation(saved); err != nil {
+		log.Printf("hooks: failed to save changes to allocation %s: %v", stored.ID, err)
+		return stored
+	}
+	return saved
+}
+
+func clone(alloc *ipam.IPAllocation) *ipam.IPAllocation {
+	c := *alloc
+	c.Tags = slices.Clone(alloc.Tags)
+	return &c
+}
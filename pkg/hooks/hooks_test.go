@@ -0,0 +1,123 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store/pebbletest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testHook records the stages it sees and applies the configured changes
+type testHook struct {
+	Base
+	name  string
+	calls *[]string
+
+	vetoAllocate error
+	vetoRelease  error
+	tagAllocated string
+	tagReleased  string
+}
+
+func (h *testHook) Name() string { return h.name }
+
+func (h *testHook) PreAllocate(_ context.Context, req *ipam.AllocationRequest) error {
+	*h.calls = append(*h.calls, h.name+":pre-allocate")
+	return h.vetoAllocate
+}
+
+func (h *testHook) PostAllocate(_ context.Context, alloc *ipam.IPAllocation) error {
+	*h.calls = append(*h.calls, h.name+":post-allocate")
+	if h.tagAllocated != "" {
+		alloc.Tags = append(alloc.Tags, h.tagAllocated)
+		alloc.IP = "10.99.99.99" // not metadata; must not be saved
+	}
+	return nil
+}
+
+func (h *testHook) PreRelease(_ context.Context, alloc *ipam.IPAllocation) error {
+	*h.calls = append(*h.calls, h.name+":pre-release")
+	return h.vetoRelease
+}
+
+func (h *testHook) PostRelease(_ context.Context, alloc *ipam.IPAllocation) error {
+	*h.calls = append(*h.calls, h.name+":post-release")
+	if h.tagReleased != "" {
+		alloc.Tags = append(alloc.Tags, h.tagReleased)
+	}
+	return errors.New("logged, not returned")
+}
+
+func TestChain(t *testing.T) {
+	s := pebbletest.New(t)
+	var calls []string
+	first := &testHook{name: "first", calls: &calls, tagAllocated: "cmdb=registered", tagReleased: "cmdb=retired"}
+	second := &testHook{name: "second", calls: &calls}
+	chain := Chain{first, second}
+
+	allocate := func(req *ipam.AllocationRequest) (*ipam.IPAllocation, error) {
+		alloc := &ipam.IPAllocation{ID: "a1", NetworkID: "net1", IP: "10.0.0.1", Hostname: req.Hostname, Tags: req.Tags, AllocatedAt: time.Now()}
+		return alloc, s.SaveAllocation(alloc)
+	}
+
+	alloc, err := chain.Allocate(context.Background(), s, &ipam.AllocationRequest{Hostname: "web"}, allocate)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first:pre-allocate", "second:pre-allocate", "first:post-allocate", "second:post-allocate"}, calls)
+	assert.Equal(t, []string{"cmdb=registered"}, alloc.Tags)
+	assert.Equal(t, "10.0.0.1", alloc.IP)
+
+	stored, err := s.GetAllocation("a1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cmdb=registered"}, stored.Tags)
+	assert.Equal(t, "10.0.0.1", stored.IP)
+
+	// A veto stops the operation before it happens
+	second.vetoRelease = errors.New("still in use")
+	released := false
+	release := func() error {
+		released = true
+		now := time.Now()
+		stored.ReleasedAt = &now
+		return s.SaveAllocation(stored)
+	}
+	err = chain.Release(context.Background(), s, stored, release)
+	assert.True(t, errors.Is(err, ErrVetoed))
+	assert.Contains(t, err.Error(), "second: still in use")
+	assert.False(t, released)
+
+	second.vetoRelease = nil
+	calls = nil
+	require.NoError(t, chain.Release(context.Background(), s, stored, release))
+	assert.True(t, released)
+	assert.Equal(t, []string{"first:pre-release", "second:pre-release", "first:post-release", "second:post-release"}, calls)
+
+	stored, err = s.GetAllocation("a1")
+	require.NoError(t, err)
+	assert.NotNil(t, stored.ReleasedAt)
+	assert.Equal(t, []string{"cmdb=registered", "cmdb=retired"}, stored.Tags)
+
+	second.vetoAllocate = errors.New("no hostname")
+	_, err = chain.Allocate(context.Background(), s, &ipam.AllocationRequest{}, func(*ipam.AllocationRequest) (*ipam.IPAllocation, error) {
+		t.Fatal("allocate called after a veto")
+		return nil, nil
+	})
+	assert.True(t, errors.Is(err, ErrVetoed))
+}
+
+func TestRegister(t *testing.T) {
+	defer func() { registered = nil }()
+
+	var calls []string
+	Register(&testHook{name: "one", calls: &calls})
+	Register(&testHook{name: "two", calls: &calls})
+	assert.Len(t, Registered(), 2)
+	assert.Equal(t, "one", Registered()[0].Name())
+
+	assert.Panics(t, func() { Register(&testHook{name: "one", calls: &calls}) })
+	assert.Panics(t, func() { Register(nil) })
+}
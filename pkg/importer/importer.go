@@ -0,0 +1,469 @@
+// Package importer converts the CSV exports of other IPAM products into an
+// address plan, which "ipam apply" then converges the database toward.
+//
+// Both the subnet and the IP address exports of Men&Mice (Micetro) and
+// SolarWinds IPAM are read, alone or together. Columns are found by their
+// header, so exports may have any column order and any set of custom
+// fields. A row with an address becomes an allocation, or a reservation
+// when its state says reserved, in the network its row names or else the
+// most specific network of the export containing it; a row with a subnet
+// only becomes a network. Free addresses are skipped.
+//
+// Every column the importer does not map, such as a custom field, is
+// carried over as a key=value tag, e.g. "Cost Center: R&D 4" becomes
+// cost-center=R-D-4, so the metadata stays searchable with label
+// selectors. Keys the server interprets get an imported- prefix. MAC
+// addresses become mac=<MAC> tags.
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/jeremyhahn/go-ipam/pkg/neighbor"
+	"github.com/jeremyhahn/go-ipam/pkg/plan"
+	"github.com/jeremyhahn/go-ipam/pkg/taxonomy"
+)
+
+// Supported export formats
+const (
+	FormatMenAndMice = "menandmice"
+	FormatSolarWinds = "solarwinds"
+)
+
+// Formats lists the supported formats
+var Formats = []string{FormatMenAndMice, FormatSolarWinds}
+
+// maxTagLen is the longest tag the API accepts
+const maxTagLen = 63
+
+// dialect names the columns of one product's exports, all lower-case
+type dialect struct {
+	// network holds a subnet in CIDR form; networkAddress and
+	// prefixLength hold it split in two
+	network        []string
+	networkAddress []string
+	prefixLength   []string
+
+	address            []string
+	hostname           []string
+	networkDescription []string
+	description        []string
+	mac                []string
+
+	// status holds the address state; reserved and free list the states
+	// of reservations and of addresses to skip
+	status   []string
+	reserved []string
+	free     []string
+
+	// ignore lists columns that are neither mapped nor metadata, such as
+	// usage counters and scan results
+	ignore []string
+}
+
+var dialects = map[string]*dialect{
+	FormatMenAndMice: {
+		network:            []string{"range", "subnet", "cidr"},
+		address:            []string{"address", "ip address"},
+		hostname:           []string{"dns hosts", "dns host", "hostname", "name"},
+		networkDescription: []string{"title", "description"},
+		description:        []string{"description", "comment", "title"},
+		mac:                []string{"mac address", "mac"},
+		status:             []string{"state", "status"},
+		reserved:           []string{"reserved", "claimed", "held"},
+		free:               []string{"free", "available", "unassigned"},
+		ignore: []string{
+			"ptr status", "usage", "utilization", "last seen", "last discovered",
+			"last known mac address", "ping status", "dhcp reservations", "dhcp leases",
+			"lease expiry", "type", "locked", "auto assign", "folder",
+		},
+	},
+	FormatSolarWinds: {
+		network:            []string{"subnet", "subnet cidr"},
+		networkAddress:     []string{"address", "subnet address", "network address"},
+		prefixLength:       []string{"cidr", "prefix length"},
+		address:            []string{"ip address", "ipaddress"},
+		hostname:           []string{"dns backward", "dnsbackward", "dns hostname", "dns", "hostname", "system name", "sysname"},
+		networkDescription: []string{"display name", "displayname", "friendlyname", "friendly name", "comments", "description"},
+		description:        []string{"comments", "description"},
+		mac:                []string{"mac address", "mac"},
+		status:             []string{"status"},
+		reserved:           []string{"reserved"},
+		free:               []string{"available", "free"},
+		ignore: []string{
+			"type", "alloc policy", "allocation policy", "last sync", "last response",
+			"response time", "scan status", "last discovery", "lastdiscovery", "ip node id",
+			"ipnodeid", "subnet id", "subnetid", "parent id", "parentid", "group type",
+			"used", "available", "reserved", "transient", "total", "total count",
+			"used count", "available count", "reserved count", "transient count",
+			"percent used", "percentused", "used %", "address mask", "mask",
+		},
+	},
+}
+
+// Parse reads the exports in format into a validated plan. Files are read
+// in order, so a subnet export should precede the address export relying
+// on it.
+func Parse(format string, files ...io.Reader) (*plan.Plan, error) {
+	d, ok := dialects[format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported format %q: use %s", format, strings.Join(Formats, " or "))
+	}
+
+	b := &builder{dialect: d, networks: make(map[netip.Prefix]*network)}
+	for i, r := range files {
+		if err := b.read(r); err != nil {
+			if len(files) > 1 {
+				return nil, fmt.Errorf("file %d: %w", i+1, err)
+			}
+			return nil, err
+		}
+	}
+	if err := b.place(); err != nil {
+		return nil, err
+	}
+
+	p := b.plan()
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// network is a network of the export and the addresses placed in it
+type network struct {
+	spec      plan.NetworkSpec
+	addresses map[netip.Addr]bool
+}
+
+// address is an address row waiting to be placed in its network
+type address struct {
+	addr     netip.Addr
+	network  netip.Prefix // Zero when the row names none
+	reserved bool
+	spec     plan.AddressSpec
+}
+
+type builder struct {
+	*dialect
+	networks  map[netip.Prefix]*network
+	addresses []address
+}
+
+// row is a CSV record with its header
+type row struct {
+	header []string
+	fields []string
+	used   map[int]bool
+}
+
+// get returns the first non-empty column of names and marks it used
+func (r *row) get(names []string) string {
+	for _, name := range names {
+		for i, column := range r.header {
+			if column == name && i < len(r.fields) {
+				r.used[i] = true
+				if value := strings.TrimSpace(r.fields[i]); value != "" {
+					return value
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// skip marks every column of names used
+func (r *row) skip(names ...[]string) {
+	for _, list := range names {
+		for i, column := range r.header {
+			if slices.Contains(list, column) {
+				r.used[i] = true
+			}
+		}
+	}
+}
+
+func (b *builder) read(r io.Reader) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("invalid CSV: %w", err)
+	}
+	for i, column := range header {
+		header[i] = normalizeHeader(column)
+	}
+
+	for {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid CSV: %w", err)
+		}
+		line, _ := cr.FieldPos(0)
+		if err := b.add(&row{header: header, fields: fields, used: make(map[int]bool)}); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+}
+
+// add records one row of an export
+func (b *builder) add(r *row) error {
+	prefix, err := b.subnet(r)
+	if err != nil {
+		return err
+	}
+	ip := r.get(b.address)
+
+	if ip == "" {
+		if !prefix.IsValid() {
+			if r.allEmpty() {
+				return nil
+			}
+			return errors.New("neither a subnet nor an address")
+		}
+		n := b.networkFor(prefix)
+		if description := r.get(b.networkDescription); n.spec.Description == "" {
+			n.spec.Description = description
+		}
+		r.skip(b.status, b.ignore)
+		n.spec.Tags = mergeTags(n.spec.Tags, r.metadata())
+		return nil
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return fmt.Errorf("invalid address %q", ip)
+	}
+	addr = addr.Unmap()
+	if prefix.IsValid() {
+		if !prefix.Contains(addr) {
+			return fmt.Errorf("%s is outside %s", addr, prefix)
+		}
+		b.networkFor(prefix)
+	}
+
+	status := strings.ToLower(r.get(b.status))
+	if slices.Contains(b.free, status) {
+		return nil
+	}
+	spec := plan.AddressSpec{
+		IP:          addr.String(),
+		Hostname:    hostname(r.get(b.hostname)),
+		Description: r.get(b.description),
+	}
+	var tags []string
+	if mac := r.get(b.mac); mac != "" {
+		normalized, err := neighbor.NormalizeMAC(mac)
+		if err != nil {
+			return err
+		}
+		tags = append(tags, neighbor.MACTagPrefix+normalized)
+	}
+	r.skip(b.networkDescription, b.ignore)
+	spec.Tags = mergeTags(tags, r.metadata())
+
+	b.addresses = append(b.addresses, address{
+		addr:     addr,
+		network:  prefix,
+		reserved: slices.Contains(b.reserved, status),
+		spec:     spec,
+	})
+	return nil
+}
+
+// subnet returns the network a row names, if any
+func (b *builder) subnet(r *row) (netip.Prefix, error) {
+	if cidr := r.get(b.network); cidr != "" {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid subnet %q: only CIDR subnets can be imported", cidr)
+		}
+		return prefix.Masked(), nil
+	}
+
+	start, bits := r.get(b.networkAddress), r.get(b.prefixLength)
+	if start == "" && bits == "" {
+		return netip.Prefix{}, nil
+	}
+	prefix, err := netip.ParsePrefix(start + "/" + strings.TrimPrefix(bits, "/"))
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid subnet %q with prefix length %q", start, bits)
+	}
+	return prefix.Masked(), nil
+}
+
+// networkFor returns the network of prefix, adding it when new
+func (b *builder) networkFor(prefix netip.Prefix) *network {
+	n, ok := b.networks[prefix]
+	if !ok {
+		n = &network{spec: plan.NetworkSpec{CIDR: prefix.String()}, addresses: make(map[netip.Addr]bool)}
+		b.networks[prefix] = n
+	}
+	return n
+}
+
+// place puts each address row into its network
+func (b *builder) place() error {
+	for _, a := range b.addresses {
+		prefix := a.network
+		if !prefix.IsValid() {
+			for candidate := range b.networks {
+				if candidate.Contains(a.addr) && candidate.Bits() > prefix.Bits() {
+					prefix = candidate
+				}
+			}
+			if !prefix.IsValid() {
+				return fmt.Errorf("no subnet of the export contains %s; import the subnet export too", a.addr)
+			}
+		}
+
+		n := b.networks[prefix]
+		if n.addresses[a.addr] {
+			return fmt.Errorf("address %s is listed twice", a.addr)
+		}
+		n.addresses[a.addr] = true
+		if a.reserved {
+			n.spec.Reservations = append(n.spec.Reservations, a.spec)
+		} else {
+			n.spec.Allocations = append(n.spec.Allocations, a.spec)
+		}
+	}
+	return nil
+}
+
+// plan returns the networks in address order, with their addresses sorted
+func (b *builder) plan() *plan.Plan {
+	prefixes := make([]netip.Prefix, 0, len(b.networks))
+	for prefix := range b.networks {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		if c := prefixes[i].Addr().Compare(prefixes[j].Addr()); c != 0 {
+			return c < 0
+		}
+		return prefixes[i].Bits() < prefixes[j].Bits()
+	})
+
+	p := &plan.Plan{}
+	for _, prefix := range prefixes {
+		spec := b.networks[prefix].spec
+		sortAddresses(spec.Reservations)
+		sortAddresses(spec.Allocations)
+		p.Networks = append(p.Networks, spec)
+	}
+	return p
+}
+
+func sortAddresses(specs []plan.AddressSpec) {
+	sort.Slice(specs, func(i, j int) bool {
+		return netip.MustParseAddr(specs[i].IP).Less(netip.MustParseAddr(specs[j].IP))
+	})
+}
+
+// allEmpty reports whether every field of the row is blank
+func (r *row) allEmpty() bool {
+	for _, field := range r.fields {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// metadata returns the unused, non-empty columns as key=value tags
+func (r *row) metadata() []string {
+	var tags []string
+	for i, column := range r.header {
+		if r.used[i] || i >= len(r.fields) {
+			continue
+		}
+		if tag, ok := metadataTag(column, r.fields[i]); ok {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// metadataTag returns the tag carrying value of column, with characters
+// tags do not allow replaced by hyphens, or false when either is empty.
+// Keys the server interprets, such as group or domain, are prefixed with
+// imported- so that metadata never changes behavior.
+func metadataTag(column, value string) (string, bool) {
+	key := strings.TrimLeft(sanitize(strings.ToLower(column), false), "._:/")
+	value = sanitize(value, true)
+	if key == "" || value == "" {
+		return "", false
+	}
+	if slices.Contains(taxonomy.Reserved, key) {
+		key = "imported-" + key
+	}
+	tag := key + "=" + value
+	if len(tag) > maxTagLen {
+		tag = strings.TrimRight(tag[:maxTagLen], "-")
+	}
+	return tag, true
+}
+
+// sanitize replaces runs of characters tags do not allow with a hyphen.
+// Values may contain '=', keys may not.
+func sanitize(s string, value bool) string {
+	var sb strings.Builder
+	hyphen := false
+	for _, c := range s {
+		ok := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			strings.ContainsRune("._:/", c) || (value && c == '=')
+		if !ok {
+			hyphen = sb.Len() > 0
+			continue
+		}
+		if hyphen {
+			sb.WriteByte('-')
+			hyphen = false
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+// normalizeHeader lower-cases a column name and collapses its spaces and
+// underscores, dropping a UTF-8 byte order mark
+func normalizeHeader(column string) string {
+	column = strings.TrimPrefix(column, "\uFEFF")
+	column = strings.ReplaceAll(column, "_", " ")
+	return strings.ToLower(strings.Join(strings.Fields(column), " "))
+}
+
+// hostname returns the first of the names a column lists, without the
+// trailing dot of a fully qualified name
+func hostname(names string) string {
+	fields := strings.FieldsFunc(names, func(c rune) bool { return c == ',' || c == ';' || c == ' ' })
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(fields[0], ".")
+}
+
+// mergeTags appends the tags of add missing from tags
+func mergeTags(tags, add []string) []string {
+	for _, tag := range add {
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
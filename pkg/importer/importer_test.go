@@ -0,0 +1,121 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jeremyhahn/go-ipam/pkg/plan"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMenAndMice(t *testing.T) {
+	ranges := "\uFEFFRange,Title,Utilization,Location,Cost Center\n" +
+		"10.1.0.0/24,Office LAN,42%,\"Building 5, Floor 2\",R&D 4\n" +
+		"10.1.0.0/16,Campus,3%,,\n"
+	addresses := "Address,State,DNS Hosts,Description,PTR Status,MAC Address,Owner\n" +
+		"10.1.0.10,Assigned,\"web01.example.com., www.example.com.\",Web server,OK,00-11-22-33-44-55,jane\n" +
+		"10.1.0.1,Reserved,,Gateway,,,\n" +
+		"10.1.0.11,Free,,,,,\n" +
+		"10.1.7.1,Assigned,lab01,,,,\n"
+
+	p, err := Parse(FormatMenAndMice, strings.NewReader(ranges), strings.NewReader(addresses))
+	require.NoError(t, err)
+	assert.Equal(t, []plan.NetworkSpec{
+		{
+			CIDR:        "10.1.0.0/16",
+			Description: "Campus",
+			Allocations: []plan.AddressSpec{{IP: "10.1.7.1", Hostname: "lab01"}},
+		},
+		{
+			CIDR:         "10.1.0.0/24",
+			Description:  "Office LAN",
+			Tags:         []string{"location=Building-5-Floor-2", "cost-center=R-D-4"},
+			Reservations: []plan.AddressSpec{{IP: "10.1.0.1", Description: "Gateway"}},
+			Allocations: []plan.AddressSpec{{
+				IP:          "10.1.0.10",
+				Hostname:    "web01.example.com",
+				Description: "Web server",
+				Tags:        []string{"mac=00:11:22:33:44:55", "owner=jane"},
+			}},
+		},
+	}, specs(p))
+
+	// Addresses need a subnet
+	_, err = Parse(FormatMenAndMice, strings.NewReader(addresses))
+	assert.ErrorContains(t, err, "no subnet of the export contains")
+	_, err = Parse(FormatMenAndMice, strings.NewReader("Range,Title\n10.1.0.5-10.1.0.20,Pool\n"))
+	assert.ErrorContains(t, err, "line 2: invalid subnet")
+}
+
+func TestParseSolarWinds(t *testing.T) {
+	subnets := "Address,CIDR,Display Name,VLAN,Used,Percent Used,Site_Code\n" +
+		"192.168.10.0,24,Servers,110,12,5,AMS-1\n"
+	addresses := "IP Address,Status,DNS Backward,MAC Address,Comments,Last Response,Subnet,Department\n" +
+		"192.168.10.20,Used,db01.example.com,0011.2233.4466,Primary database,2026-10-01,192.168.10.0/24,finance\n" +
+		"192.168.10.21,Reserved,,,Future replica,,192.168.10.0/24,\n" +
+		"192.168.10.22,Available,,,,,192.168.10.0/24,\n" +
+		"10.9.0.5,Used,orphan,,,,10.9.0.0/29,\n"
+
+	p, err := Parse(FormatSolarWinds, strings.NewReader(subnets), strings.NewReader(addresses))
+	require.NoError(t, err)
+	assert.Equal(t, []plan.NetworkSpec{
+		// Subnets an address row names are created too
+		{CIDR: "10.9.0.0/29", Allocations: []plan.AddressSpec{{IP: "10.9.0.5", Hostname: "orphan"}}},
+		{
+			CIDR:         "192.168.10.0/24",
+			Description:  "Servers",
+			Tags:         []string{"vlan=110", "site-code=AMS-1"},
+			Reservations: []plan.AddressSpec{{IP: "192.168.10.21", Description: "Future replica"}},
+			Allocations: []plan.AddressSpec{{
+				IP:          "192.168.10.20",
+				Hostname:    "db01.example.com",
+				Description: "Primary database",
+				Tags:        []string{"mac=00:11:22:33:44:66", "department=finance"},
+			}},
+		},
+	}, specs(p))
+
+	_, err = Parse(FormatSolarWinds, strings.NewReader("IP Address,Subnet\n10.0.0.1,10.0.0.1/24\n10.0.0.1,10.0.0.0/24\n"))
+	assert.ErrorContains(t, err, "listed twice")
+	_, err = Parse(FormatSolarWinds, strings.NewReader("IP Address,Subnet\n10.0.1.1,10.0.0.0/24\n"))
+	assert.ErrorContains(t, err, "line 2: 10.0.1.1 is outside 10.0.0.0/24")
+	_, err = Parse("infoblox", strings.NewReader(""))
+	assert.Error(t, err)
+}
+
+func TestMetadataTag(t *testing.T) {
+	tag, ok := metadataTag("  _Asset Tag", "A/B=1 (spare)")
+	assert.True(t, ok)
+	assert.Equal(t, "asset-tag=A/B=1-spare", tag)
+
+	tag, _ = metadataTag("notes", strings.Repeat("x", 100))
+	assert.Len(t, tag, maxTagLen)
+
+	tag, _ = metadataTag("group", "web")
+	assert.Equal(t, "imported-group=web", tag)
+
+	_, ok = metadataTag("notes", " ? ")
+	assert.False(t, ok)
+}
+
+// specs returns the networks of p as written by Parse, comparable to
+// literals
+func specs(p *plan.Plan) []plan.NetworkSpec {
+	var out []plan.NetworkSpec
+	for _, n := range p.Networks {
+		n.Reservations = strip(n.Reservations)
+		n.Allocations = strip(n.Allocations)
+		out = append(out, n)
+	}
+	return out
+}
+
+// strip drops the unexported fields Validate sets
+func strip(specs []plan.AddressSpec) []plan.AddressSpec {
+	var out []plan.AddressSpec
+	for _, s := range specs {
+		out = append(out, plan.AddressSpec{IP: s.IP, Hostname: s.Hostname, Description: s.Description, Tags: s.Tags})
+	}
+	return out
+}
@@ -0,0 +1,131 @@
+package kea
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// DefaultExportPool is the pool whose addresses Export hands to Kea to
+// lease out dynamically
+const DefaultExportPool = "dhcp"
+
+// SubnetConfig is a subnet of Kea's configuration, as listed under subnet4
+// or subnet6
+type SubnetConfig struct {
+	Subnet     string       `json:"subnet"`
+	Pools      []PoolConfig `json:"pools,omitempty"`
+	OptionData []OptionData `json:"option-data,omitempty"`
+
+	// BootFileName and NextServer are DHCPv4 only
+	BootFileName string `json:"boot-file-name,omitempty"`
+	NextServer   string `json:"next-server,omitempty"`
+}
+
+// PoolConfig is a dynamic pool of a Kea subnet
+type PoolConfig struct {
+	Pool string `json:"pool"`
+}
+
+// Export returns the Kea configuration of the subnets of service, dhcp4 or
+// dhcp6, for the networks of s of that family, ordered by CIDR. The
+// network's pools named in pools become the subnet's dynamic pools, and its
+// settings become options: the gateway, name servers and domain, and its
+// DHCP options, see store.TagDHCPOptions.
+func Export(s ipam.Store, service string, pools []string, now time.Time) ([]SubnetConfig, error) {
+	if !slices.Contains(Services, service) {
+		return nil, fmt.Errorf("unknown Kea service %q", service)
+	}
+	v4 := service == "dhcp4"
+	networks, err := s.ListNetworks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+
+	type exported struct {
+		prefix netip.Prefix
+		config SubnetConfig
+	}
+	var subnets []exported
+	for _, network := range networks {
+		prefix, err := netip.ParsePrefix(network.CIDR)
+		if err != nil || prefix.Addr().Is4() != v4 {
+			continue
+		}
+		allocations, err := s.ListAllocations(network.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list allocations: %w", err)
+		}
+
+		config := SubnetConfig{Subnet: prefix.Masked().String()}
+		for _, pool := range store.Pools(network.Tags) {
+			if slices.Contains(pools, pool.Name) {
+				config.Pools = append(config.Pools, PoolConfig{Pool: pool.First + " - " + pool.Last})
+			}
+		}
+		if settings := store.NetworkSettings(network, allocations, now); settings != nil {
+			config.OptionData = settingsOptions(settings, v4)
+			if settings.DHCP != nil {
+				if v4 {
+					config.BootFileName, config.NextServer = settings.DHCP.BootFile, settings.DHCP.NextServer
+				}
+				// Options given explicitly win over those of the settings
+				config.OptionData = slices.DeleteFunc(config.OptionData, func(o OptionData) bool {
+					_, ok := settings.DHCP.Options[o.Name]
+					return ok
+				})
+				config.OptionData = append(config.OptionData, optionData(settings.DHCP.Options)...)
+			}
+		}
+		subnets = append(subnets, exported{prefix.Masked(), config})
+	}
+	slices.SortFunc(subnets, func(a, b exported) int {
+		if c := a.prefix.Addr().Compare(b.prefix.Addr()); c != 0 {
+			return c
+		}
+		return a.prefix.Bits() - b.prefix.Bits()
+	})
+
+	configs := make([]SubnetConfig, 0, len(subnets))
+	for _, subnet := range subnets {
+		configs = append(configs, subnet.config)
+	}
+	return configs, nil
+}
+
+// settingsOptions returns the standard options giving settings: routers,
+// name servers and domain for DHCPv4, name servers and the domain search
+// list for DHCPv6, which has no routers option. Name servers of the other
+// family are left out.
+func settingsOptions(settings *store.Settings, v4 bool) []OptionData {
+	var data []OptionData
+	if v4 && settings.Gateway != "" {
+		data = append(data, OptionData{Name: "routers", Data: settings.Gateway})
+	}
+	var servers []string
+	for _, ns := range settings.DNSServers {
+		if addr, err := netip.ParseAddr(ns); err == nil && addr.Is4() == v4 {
+			servers = append(servers, ns)
+		}
+	}
+	if len(servers) > 0 {
+		name := "dns-servers"
+		if v4 {
+			name = "domain-name-servers"
+		}
+		data = append(data, OptionData{Name: name, Data: strings.Join(servers, ", ")})
+	}
+	if settings.Domain != "" {
+		name := "domain-search"
+		if v4 {
+			name = "domain-name"
+		}
+		data = append(data, OptionData{Name: name, Data: settings.Domain})
+	}
+	return data
+}
@@ -0,0 +1,241 @@
+// Package kea keeps the host reservations of an ISC Kea DHCP server in
+// lockstep with allocations. Each active single-address allocation with a
+// mac=<MAC> tag, in a network whose CIDR is a Kea subnet, is reserved for
+// that MAC with the allocation's DHCP options, and reservations of released
+// allocations are deleted.
+//
+// Kea is driven through the Control Agent's REST API and needs the
+// host_cmds hook, with a hosts database to keep the reservations across
+// restarts. Reservations the sync creates carry a user context naming
+// their allocation, and only those are ever changed or deleted.
+package kea
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// DefaultInterval is how often the server syncs reservations by default
+const DefaultInterval = time.Minute
+
+// Kea command results
+const (
+	resultSuccess = 0
+	resultEmpty   = 3
+)
+
+// userContextKey is the user context key marking reservations the sync
+// manages
+const userContextKey = "go-ipam"
+
+// Client sends commands to a Kea Control Agent
+type Client struct {
+	// URL is the Control Agent, e.g. http://kea:8000
+	URL string
+
+	// Username and Password, when set, authenticate with HTTP basic
+	// authentication
+	Username string
+	Password string
+
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client for the Control Agent at url with a request
+// timeout
+func NewClient(url string) *Client {
+	return &Client{URL: url, HTTPClient: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Subnet is a subnet of the DHCP server's configuration
+type Subnet struct {
+	ID     int64  `json:"id"`
+	Subnet string `json:"subnet"`
+}
+
+// Reservation is a host reservation
+type Reservation struct {
+	SubnetID    int64          `json:"subnet-id"`
+	HWAddress   string         `json:"hw-address,omitempty"`
+	IPAddress   string         `json:"ip-address,omitempty"`
+	IPAddresses []string       `json:"ip-addresses,omitempty"`
+	Hostname    string         `json:"hostname,omitempty"`
+	UserContext map[string]any `json:"user-context,omitempty"`
+
+	// BootFileName and NextServer are DHCPv4 only
+	BootFileName string       `json:"boot-file-name,omitempty"`
+	NextServer   string       `json:"next-server,omitempty"`
+	OptionData   []OptionData `json:"option-data,omitempty"`
+}
+
+// OptionData is a DHCP option a reservation hands out, named or by code
+type OptionData struct {
+	Name string `json:"name,omitempty"`
+	Code int    `json:"code,omitempty"`
+	Data string `json:"data"`
+}
+
+// Address returns the reserved address, the first for DHCPv6
+func (r *Reservation) Address() string {
+	if r.IPAddress != "" {
+		return r.IPAddress
+	}
+	if len(r.IPAddresses) > 0 {
+		return r.IPAddresses[0]
+	}
+	return ""
+}
+
+// AllocationID returns the allocation a managed reservation was made for
+func (r *Reservation) AllocationID() (string, bool) {
+	owner, ok := r.UserContext[userContextKey].(map[string]any)
+	if !ok {
+		return "", false
+	}
+	id, ok := owner["allocation-id"].(string)
+	return id, ok && id != ""
+}
+
+// Subnets returns the subnets configured in service, dhcp4 or dhcp6,
+// including those of shared networks
+func (c *Client) Subnets(ctx context.Context, service string) ([]Subnet, error) {
+	var config map[string]json.RawMessage
+	if _, err := c.command(ctx, service, "config-get", nil, &config); err != nil {
+		return nil, err
+	}
+	key, list := "Dhcp4", "subnet4"
+	if service == "dhcp6" {
+		key, list = "Dhcp6", "subnet6"
+	}
+
+	var server map[string]json.RawMessage
+	if err := json.Unmarshal(config[key], &server); err != nil {
+		return nil, fmt.Errorf("invalid %s configuration: %w", service, err)
+	}
+	var subnets []Subnet
+	if raw, ok := server[list]; ok {
+		if err := json.Unmarshal(raw, &subnets); err != nil {
+			return nil, fmt.Errorf("invalid %s configuration: %w", service, err)
+		}
+	}
+	if raw, ok := server["shared-networks"]; ok {
+		var shared []map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &shared); err != nil {
+			return nil, fmt.Errorf("invalid %s configuration: %w", service, err)
+		}
+		for _, network := range shared {
+			var more []Subnet
+			if err := json.Unmarshal(network[list], &more); err != nil && network[list] != nil {
+				return nil, fmt.Errorf("invalid %s configuration: %w", service, err)
+			}
+			subnets = append(subnets, more...)
+		}
+	}
+	return subnets, nil
+}
+
+// Reservations returns the reservations of a subnet
+func (c *Client) Reservations(ctx context.Context, service string, subnetID int64) ([]Reservation, error) {
+	var reply struct {
+		Hosts []Reservation `json:"hosts"`
+	}
+	result, err := c.command(ctx, service, "reservation-get-all", map[string]any{"subnet-id": subnetID}, &reply)
+	if err != nil || result == resultEmpty {
+		return nil, err
+	}
+	for i := range reply.Hosts {
+		reply.Hosts[i].SubnetID = subnetID
+	}
+	return reply.Hosts, nil
+}
+
+// AddReservation adds r
+func (c *Client) AddReservation(ctx context.Context, service string, r Reservation) error {
+	_, err := c.command(ctx, service, "reservation-add", map[string]any{"reservation": r}, nil)
+	return err
+}
+
+// DeleteReservation deletes the reservation of address in a subnet
+func (c *Client) DeleteReservation(ctx context.Context, service string, subnetID int64, address string) error {
+	_, err := c.command(ctx, service, "reservation-del", map[string]any{"subnet-id": subnetID, "ip-address": address}, nil)
+	return err
+}
+
+// command sends a command to service and decodes the arguments of a
+// successful answer into v, returning the result code: success, or empty
+// when nothing matched
+func (c *Client) command(ctx context.Context, service, command string, arguments, v any) (int, error) {
+	body := map[string]any{"command": command, "service": []string{service}}
+	if arguments != nil {
+		body["arguments"] = arguments
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("kea %s failed: %w", command, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		io.Copy(io.Discard, resp.Body)
+		return 0, fmt.Errorf("kea %s failed: %s", command, resp.Status)
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return 0, fmt.Errorf("kea %s failed: %w", command, err)
+	}
+
+	// The Control Agent answers with one response per service; daemons
+	// answering directly with a single one
+	var answer struct {
+		Result    int             `json:"result"`
+		Text      string          `json:"text"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if raw = bytes.TrimSpace(raw); len(raw) > 0 && raw[0] == '[' {
+		var answers []json.RawMessage
+		if err := json.Unmarshal(raw, &answers); err != nil || len(answers) == 0 {
+			return 0, fmt.Errorf("kea %s: invalid response", command)
+		}
+		raw = answers[0]
+	}
+	if err := json.Unmarshal(raw, &answer); err != nil {
+		return 0, fmt.Errorf("kea %s: invalid response: %w", command, err)
+	}
+	switch answer.Result {
+	case resultSuccess:
+	case resultEmpty:
+		return resultEmpty, nil
+	default:
+		if answer.Text == "" {
+			answer.Text = fmt.Sprintf("result %d", answer.Result)
+		}
+		return answer.Result, fmt.Errorf("kea %s failed: %s", command, answer.Text)
+	}
+	if v != nil && len(answer.Arguments) > 0 {
+		if err := json.Unmarshal(answer.Arguments, v); err != nil {
+			return 0, fmt.Errorf("kea %s: invalid response: %w", command, err)
+		}
+	}
+	return resultSuccess, nil
+}
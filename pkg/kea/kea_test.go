@@ -0,0 +1,254 @@
+package kea
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKea is a Control Agent with the host_cmds hook, keeping reservations
+// in memory
+type fakeKea struct {
+	hosts    map[int64][]Reservation
+	commands []string
+}
+
+func (f *fakeKea) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, pass, _ := r.BasicAuth(); user != "ipam" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		Command   string          `json:"command"`
+		Service   []string        `json:"service"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	f.commands = append(f.commands, req.Command)
+	answer := map[string]any{"result": 0}
+	switch req.Command {
+	case "config-get":
+		answer["arguments"] = map[string]any{"Dhcp4": map[string]any{
+			"subnet4": []any{map[string]any{"id": 1, "subnet": "192.168.20.0/24", "pools": []any{}}},
+			"shared-networks": []any{map[string]any{"name": "floor", "subnet4": []any{
+				map[string]any{"id": 7, "subnet": "10.20.0.0/24"},
+			}}},
+		}}
+	case "reservation-get-all":
+		var args struct {
+			SubnetID int64 `json:"subnet-id"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		if len(f.hosts[args.SubnetID]) == 0 {
+			answer = map[string]any{"result": 3, "text": "0 IPv4 host(s) found."}
+			break
+		}
+		answer["arguments"] = map[string]any{"hosts": f.hosts[args.SubnetID]}
+	case "reservation-add":
+		var args struct {
+			Reservation Reservation `json:"reservation"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		for _, h := range f.hosts[args.Reservation.SubnetID] {
+			if h.IPAddress == args.Reservation.IPAddress || h.HWAddress == args.Reservation.HWAddress {
+				json.NewEncoder(w).Encode([]any{map[string]any{"result": 1, "text": "Database duplicate entry error"}})
+				return
+			}
+		}
+		f.hosts[args.Reservation.SubnetID] = append(f.hosts[args.Reservation.SubnetID], args.Reservation)
+	case "reservation-del":
+		var args struct {
+			SubnetID  int64  `json:"subnet-id"`
+			IPAddress string `json:"ip-address"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		var kept []Reservation
+		for _, h := range f.hosts[args.SubnetID] {
+			if h.IPAddress != args.IPAddress {
+				kept = append(kept, h)
+			}
+		}
+		f.hosts[args.SubnetID] = kept
+	default:
+		answer = map[string]any{"result": 2, "text": "'" + req.Command + "' command not supported."}
+	}
+	json.NewEncoder(w).Encode([]any{answer})
+}
+
+func TestSync(t *testing.T) {
+	s, err := store.NewPebbleStore(t.TempDir())
+	require.NoError(t, err)
+	defer s.Close()
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "lan", CIDR: "192.168.20.0/24"}))
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "other", CIDR: "172.16.0.0/24"}))
+	for _, alloc := range []*ipam.IPAllocation{
+		{ID: "printer", NetworkID: "lan", IP: "192.168.20.10", Hostname: "printer", Tags: []string{"mac=00-11-22-33-44-01"}},
+		{ID: "nas", NetworkID: "lan", IP: "192.168.20.11", Tags: []string{"mac=00:11:22:33:44:02"}},
+		{ID: "nomac", NetworkID: "lan", IP: "192.168.20.12"},
+		{ID: "pool", NetworkID: "lan", IP: "192.168.20.100", EndIP: "192.168.20.150", Tags: []string{"mac=00:11:22:33:44:03"}},
+		{ID: "taken", NetworkID: "lan", IP: "192.168.20.13", Tags: []string{"mac=00:11:22:33:44:04"}},
+		{ID: "manual", NetworkID: "lan", IP: "192.168.20.14", Tags: []string{"mac=00:11:22:33:44:05"}},
+	} {
+		require.NoError(t, s.SaveAllocation(alloc))
+	}
+
+	kea := &fakeKea{hosts: map[int64][]Reservation{1: {
+		{SubnetID: 1, IPAddress: "192.168.20.13", HWAddress: "00:11:22:33:44:99"},
+		{SubnetID: 1, IPAddress: "192.168.20.14", HWAddress: "00:11:22:33:44:05", Hostname: "by-hand"},
+		{SubnetID: 1, IPAddress: "192.168.20.50", HWAddress: "00:11:22:33:44:50",
+			UserContext: map[string]any{"go-ipam": map[string]any{"allocation-id": "released-long-ago"}}},
+	}}}
+	server := httptest.NewServer(kea)
+	defer server.Close()
+	client := NewClient(server.URL)
+	client.Username, client.Password = "ipam", "secret"
+	syncer := &Syncer{Store: s, Client: client}
+
+	syncer.DryRun = true
+	result, err := syncer.Sync(context.Background(), now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Added)
+	assert.Equal(t, 1, result.Deleted)
+	assert.Len(t, kea.hosts[1], 3)
+
+	syncer.DryRun = false
+	result, err = syncer.Sync(context.Background(), now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Subnets)
+	assert.Equal(t, []string{"10.20.0.0/24"}, result.Unmatched)
+	assert.Equal(t, 2, result.Added)
+	assert.Equal(t, 1, result.Deleted)
+	assert.Equal(t, 1, result.Unchanged)
+	assert.Equal(t, []Conflict{{Subnet: "192.168.20.0/24", IP: "192.168.20.13", MAC: "00:11:22:33:44:04", Reason: "address is reserved in Kea for 00:11:22:33:44:99"}}, result.Conflicts)
+
+	sort.Slice(kea.hosts[1], func(i, j int) bool { return kea.hosts[1][i].IPAddress < kea.hosts[1][j].IPAddress })
+	require.Len(t, kea.hosts[1], 4)
+	assert.Equal(t, Reservation{SubnetID: 1, IPAddress: "192.168.20.10", HWAddress: "00:11:22:33:44:01", Hostname: "printer",
+		UserContext: map[string]any{"go-ipam": map[string]any{"allocation-id": "printer"}}}, kea.hosts[1][0])
+
+	// A changed MAC replaces the reservation; a released allocation's goes
+	printer, err := s.GetAllocation("printer")
+	require.NoError(t, err)
+	printer.Tags = []string{"mac=00:11:22:33:44:aa"}
+	require.NoError(t, s.SaveAllocation(printer))
+	nas, err := s.GetAllocation("nas")
+	require.NoError(t, err)
+	nas.ReleasedAt = &now
+	require.NoError(t, s.SaveAllocation(nas))
+
+	result, err = syncer.Sync(context.Background(), now)
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.Added)
+	assert.Equal(t, 1, result.Updated)
+	assert.Equal(t, 1, result.Deleted)
+	sort.Slice(kea.hosts[1], func(i, j int) bool { return kea.hosts[1][i].IPAddress < kea.hosts[1][j].IPAddress })
+	require.Len(t, kea.hosts[1], 3)
+	assert.Equal(t, "00:11:22:33:44:aa", kea.hosts[1][0].HWAddress)
+	assert.Equal(t, "by-hand", kea.hosts[1][2].Hostname)
+
+	// DHCP options replace the reservation: the network's, overridden one by
+	// one by the allocation's own
+	lan, err := s.GetNetwork("lan")
+	require.NoError(t, err)
+	lan.Tags = []string{"boot-file=pxelinux.0", "next-server=192.168.20.5", "dhcp-option=tftp-server-name:192.168.20.5"}
+	require.NoError(t, s.SaveNetwork(lan))
+	printer.Tags = append(printer.Tags, "boot-file=ipxe/undionly.kpxe", "dhcp-option=66:192.168.20.6")
+	require.NoError(t, s.SaveAllocation(printer))
+
+	result, err = syncer.Sync(context.Background(), now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Updated)
+	var reserved Reservation
+	for _, h := range kea.hosts[1] {
+		if h.IPAddress == "192.168.20.10" {
+			reserved = h
+		}
+	}
+	assert.Equal(t, "ipxe/undionly.kpxe", reserved.BootFileName)
+	assert.Equal(t, "192.168.20.5", reserved.NextServer)
+	assert.Equal(t, []OptionData{{Code: 66, Data: "192.168.20.6"}, {Name: "tftp-server-name", Data: "192.168.20.5"}}, reserved.OptionData)
+
+	result, err = syncer.Sync(context.Background(), now)
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.Updated)
+	assert.Equal(t, 2, result.Unchanged)
+
+	client.Password = "wrong"
+	_, err = syncer.Sync(context.Background(), now)
+	assert.ErrorContains(t, err, "401 Unauthorized")
+}
+
+func TestExport(t *testing.T) {
+	s, err := store.NewPebbleStore(t.TempDir())
+	require.NoError(t, err)
+	defer s.Close()
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "lan", CIDR: "192.168.20.0/24", Tags: []string{
+		"pool=static:192.168.20.10-192.168.20.99", "pool=dhcp:192.168.20.100-192.168.20.200",
+		"dns=192.168.20.53", "dns=2001:db8::53", "domain=lab.example.com",
+		"boot-file=pxelinux.0", "next-server=192.168.20.5", "dhcp-option=domain-name:pxe.example.com",
+	}}))
+	require.NoError(t, s.SaveAllocation(&ipam.IPAllocation{ID: "gw", NetworkID: "lan", IP: "192.168.20.1", Tags: []string{store.GatewayTag}}))
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "dmz", CIDR: "10.0.0.0/24"}))
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "v6", CIDR: "2001:db8::/64", Tags: []string{
+		"pool=dhcp:2001:db8::100-2001:db8::1ff", "dns=2001:db8::53", "domain=lab.example.com",
+		"boot-file=ignored.efi",
+	}}))
+
+	subnets, err := Export(s, "dhcp4", []string{DefaultExportPool}, now)
+	require.NoError(t, err)
+	assert.Equal(t, []SubnetConfig{
+		{Subnet: "10.0.0.0/24"},
+		{
+			Subnet: "192.168.20.0/24",
+			Pools:  []PoolConfig{{Pool: "192.168.20.100 - 192.168.20.200"}},
+			OptionData: []OptionData{
+				{Name: "routers", Data: "192.168.20.1"},
+				{Name: "domain-name-servers", Data: "192.168.20.53"},
+				{Name: "domain-name", Data: "pxe.example.com"},
+			},
+			BootFileName: "pxelinux.0",
+			NextServer:   "192.168.20.5",
+		},
+	}, subnets)
+
+	subnets, err = Export(s, "dhcp4", []string{"static", "dhcp"}, now)
+	require.NoError(t, err)
+	assert.Len(t, subnets[1].Pools, 2)
+
+	subnets, err = Export(s, "dhcp6", []string{DefaultExportPool}, now)
+	require.NoError(t, err)
+	assert.Equal(t, []SubnetConfig{{
+		Subnet: "2001:db8::/64",
+		Pools:  []PoolConfig{{Pool: "2001:db8::100 - 2001:db8::1ff"}},
+		OptionData: []OptionData{
+			{Name: "dns-servers", Data: "2001:db8::53"},
+			{Name: "domain-search", Data: "lab.example.com"},
+		},
+	}}, subnets)
+
+	_, err = Export(s, "dhcp-ddns", nil, now)
+	assert.Error(t, err)
+}
+
+func TestCommandError(t *testing.T) {
+	server := httptest.NewServer(&fakeKea{})
+	defer server.Close()
+	client := NewClient(server.URL)
+	client.Username, client.Password = "ipam", "secret"
+
+	_, err := client.command(context.Background(), "dhcp4", "lease4-get-all", nil, nil)
+	assert.EqualError(t, err, "kea lease4-get-all failed: 'lease4-get-all' command not supported.")
+}
@@ -0,0 +1,311 @@
+package kea
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"net/netip"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/neighbor"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// Services are the Kea services reservations can be synced to
+var Services = []string{"dhcp4", "dhcp6"}
+
+// Result is the outcome of a sync
+type Result struct {
+	// Subnets counts the Kea subnets matching a network
+	Subnets int `json:"subnets"`
+
+	Added     int `json:"added"`
+	Updated   int `json:"updated"`
+	Deleted   int `json:"deleted"`
+	Unchanged int `json:"unchanged"`
+
+	Conflicts []Conflict `json:"conflicts"`
+
+	// Unmatched lists the Kea subnets no network has the CIDR of
+	Unmatched []string `json:"unmatched"`
+}
+
+// Conflict is an allocation that could not be reserved
+type Conflict struct {
+	Subnet string `json:"subnet"`
+	IP     string `json:"ip"`
+	MAC    string `json:"mac"`
+	Reason string `json:"reason"`
+}
+
+// Syncer pushes the reservations of Store's allocations into Kea
+type Syncer struct {
+	Store  ipam.Store
+	Client *Client
+
+	// Services lists the Kea services to sync, dhcp4 when empty
+	Services []string
+
+	// DryRun reports the changes without making them
+	DryRun bool
+
+	// Active, when set, limits syncing by Run to when it returns true,
+	// e.g. on a cluster's Raft leader
+	Active func() bool
+}
+
+// wanted is the reservation an allocation calls for
+type wanted struct {
+	alloc      *ipam.IPAllocation
+	mac        string
+	hostname   string
+	bootFile   string
+	nextServer string
+	options    []OptionData
+}
+
+// Sync brings the reservations of every Kea subnet whose CIDR is a network
+// in line with the network's allocations. Reservations hand out the DHCP
+// options of their allocation, see store.AllocationDHCPOptions. A
+// reservation the sync made is replaced when its allocation's MAC, hostname
+// or DHCP options changed, and deleted once
+// the allocation is released or loses its MAC. Reservations made by other
+// means are never changed: an allocation whose address or MAC one holds
+// is reported as a conflict, unless it reserves the same address for the
+// same MAC.
+func (s *Syncer) Sync(ctx context.Context, now time.Time) (*Result, error) {
+	networks, err := s.Store.ListNetworks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+	byCIDR := make(map[netip.Prefix]*ipam.Network)
+	for _, network := range networks {
+		if prefix, err := netip.ParsePrefix(network.CIDR); err == nil {
+			byCIDR[prefix.Masked()] = network
+		}
+	}
+
+	services := s.Services
+	if len(services) == 0 {
+		services = []string{"dhcp4"}
+	}
+	result := &Result{Conflicts: []Conflict{}, Unmatched: []string{}}
+	for _, service := range services {
+		subnets, err := s.Client.Subnets(ctx, service)
+		if err != nil {
+			return result, err
+		}
+		for _, subnet := range subnets {
+			prefix, err := netip.ParsePrefix(subnet.Subnet)
+			network, ok := byCIDR[prefix.Masked()]
+			if err != nil || !ok {
+				result.Unmatched = append(result.Unmatched, subnet.Subnet)
+				continue
+			}
+			result.Subnets++
+			if err := s.syncSubnet(ctx, service, subnet, network, now, result); err != nil {
+				return result, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// syncSubnet brings the reservations of one subnet in line with network
+func (s *Syncer) syncSubnet(ctx context.Context, service string, subnet Subnet, network *ipam.Network, now time.Time, result *Result) error {
+	allocations, err := s.Store.ListAllocations(network.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list allocations: %w", err)
+	}
+	desired := make(map[netip.Addr]wanted)
+	for _, alloc := range allocations {
+		if store.AllocationStatus(alloc, now) != store.StatusActive || (alloc.EndIP != "" && alloc.EndIP != alloc.IP) {
+			continue
+		}
+		addr, err := netip.ParseAddr(alloc.IP)
+		if err != nil {
+			continue
+		}
+		for _, tag := range alloc.Tags {
+			if value, ok := strings.CutPrefix(tag, neighbor.MACTagPrefix); ok {
+				if mac, err := neighbor.NormalizeMAC(value); err == nil {
+					want := wanted{alloc: alloc, mac: mac, hostname: alloc.Hostname}
+					if options := store.AllocationDHCPOptions(network, alloc); options != nil {
+						if addr.Unmap().Is4() {
+							want.bootFile, want.nextServer = options.BootFile, options.NextServer
+						}
+						want.options = optionData(options.Options)
+					}
+					desired[addr.Unmap()] = want
+					break
+				}
+			}
+		}
+	}
+
+	reservations, err := s.Client.Reservations(ctx, service, subnet.ID)
+	if err != nil {
+		return err
+	}
+
+	// Remove the managed reservations that are stale first, so that their
+	// address and MAC are free for the reservations replacing them
+	byAddr := make(map[netip.Addr]*Reservation)
+	byMAC := make(map[string]*Reservation)
+	replaced := make(map[netip.Addr]bool)
+	for i := range reservations {
+		r := &reservations[i]
+		addr, err := netip.ParseAddr(r.Address())
+		if err != nil {
+			continue
+		}
+		addr = addr.Unmap()
+		mac, _ := neighbor.NormalizeMAC(r.HWAddress)
+		if id, ok := r.AllocationID(); ok {
+			want, found := desired[addr]
+			if found && want.alloc.ID == id && want.mac == mac && want.hostname == r.Hostname &&
+				want.bootFile == r.BootFileName && want.nextServer == r.NextServer && sameOptions(r.OptionData, want.options) {
+				byAddr[addr] = r
+				continue
+			}
+			if !s.DryRun {
+				if err := s.Client.DeleteReservation(ctx, service, subnet.ID, addr.String()); err != nil {
+					return err
+				}
+			}
+			if found {
+				replaced[addr] = true
+			} else {
+				result.Deleted++
+			}
+			continue
+		}
+		byAddr[addr] = r
+		if mac != "" {
+			byMAC[mac] = r
+		}
+	}
+
+	addrs := make([]netip.Addr, 0, len(desired))
+	for addr := range desired {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].Less(addrs[j]) })
+	for _, addr := range addrs {
+		want := desired[addr]
+		conflict := Conflict{Subnet: subnet.Subnet, IP: addr.String(), MAC: want.mac}
+		if r, ok := byAddr[addr]; ok {
+			mac, _ := neighbor.NormalizeMAC(r.HWAddress)
+			if _, managed := r.AllocationID(); managed || mac == want.mac {
+				result.Unchanged++
+				continue
+			}
+			conflict.Reason = "address is reserved in Kea for " + reservedFor(r)
+			result.Conflicts = append(result.Conflicts, conflict)
+			continue
+		}
+		if r, ok := byMAC[want.mac]; ok {
+			conflict.Reason = "MAC is reserved in Kea for " + r.Address()
+			result.Conflicts = append(result.Conflicts, conflict)
+			continue
+		}
+
+		r := Reservation{
+			SubnetID:    subnet.ID,
+			HWAddress:   want.mac,
+			Hostname:    want.hostname,
+			UserContext: map[string]any{userContextKey: map[string]any{"allocation-id": want.alloc.ID}},
+
+			BootFileName: want.bootFile,
+			NextServer:   want.nextServer,
+			OptionData:   want.options,
+		}
+		if addr.Is4() {
+			r.IPAddress = addr.String()
+		} else {
+			r.IPAddresses = []string{addr.String()}
+		}
+		if !s.DryRun {
+			if err := s.Client.AddReservation(ctx, service, r); err != nil {
+				if ctx.Err() != nil {
+					return err
+				}
+				conflict.Reason = err.Error()
+				result.Conflicts = append(result.Conflicts, conflict)
+				continue
+			}
+		}
+		if replaced[addr] {
+			result.Updated++
+			delete(replaced, addr)
+		} else {
+			result.Added++
+		}
+	}
+	// Replaced reservations that could not be added again are gone
+	result.Deleted += len(replaced)
+	return nil
+}
+
+// optionData returns the option data of options, by option name or code,
+// ordered by name
+func optionData(options map[string]string) []OptionData {
+	var data []OptionData
+	for _, name := range slices.Sorted(maps.Keys(options)) {
+		if code, err := strconv.Atoi(name); err == nil {
+			data = append(data, OptionData{Code: code, Data: options[name]})
+		} else {
+			data = append(data, OptionData{Name: name, Data: options[name]})
+		}
+	}
+	return data
+}
+
+// sameOptions reports whether the option data of a reservation, which Kea
+// reports with both the name and the code of an option, is want
+func sameOptions(data, want []OptionData) bool {
+	if len(data) != len(want) {
+		return false
+	}
+	for _, w := range want {
+		if !slices.ContainsFunc(data, func(o OptionData) bool {
+			return o.Data == w.Data && ((w.Name != "" && o.Name == w.Name) || (w.Code != 0 && o.Code == w.Code))
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// reservedFor describes whom r reserves its address for
+func reservedFor(r *Reservation) string {
+	if r.HWAddress != "" {
+		return r.HWAddress
+	}
+	return "another client"
+}
+
+// Run syncs reservations every interval until stop is closed
+func Run(s *Syncer, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if s.Active != nil && !s.Active() {
+				continue
+			}
+			if _, err := s.Sync(context.Background(), time.Now()); err != nil {
+				log.Printf("kea: sync failed: %v", err)
+			}
+		}
+	}
+}
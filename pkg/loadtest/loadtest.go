@@ -0,0 +1,414 @@
+// Package loadtest drives allocate and release traffic against a running
+// IPAM server and measures its latency, so operators can size a deployment
+// before it carries production load.
+//
+// The test creates its own networks, carved as /24s from a base prefix, and
+// keeps each about half full: the more addresses a network holds, the more
+// likely the next request against it is a release. The networks and any
+// remaining allocations are removed afterwards.
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operations measured by the test
+const (
+	OpAllocate = "allocate"
+	OpRelease  = "release"
+)
+
+// Config describes a load test
+type Config struct {
+	// Server is the API base URL, e.g. http://localhost:8080
+	Server string
+
+	// Token, when set, is sent as a bearer token
+	Token string
+
+	// Networks is the number of test networks, carved as /24s from CIDR
+	Networks int
+	CIDR     string
+
+	// Rate is the number of requests started per second
+	Rate float64
+
+	// Duration is how long traffic is generated
+	Duration time.Duration
+
+	// Concurrency caps the requests in flight; a request that would exceed
+	// it is dropped and counted, as the server is not keeping up
+	Concurrency int
+
+	// Keep leaves the test networks and allocations in place
+	Keep bool
+
+	// Client makes the requests, by default with a 30 second timeout
+	Client *http.Client
+}
+
+// Report summarizes a load test
+type Report struct {
+	Duration time.Duration `json:"duration"`
+
+	// Dropped counts requests not started because Concurrency were in flight
+	Dropped int `json:"dropped"`
+
+	Operations []*OpStats `json:"operations"`
+}
+
+// OpStats are the results of one operation
+type OpStats struct {
+	Op       string         `json:"op"`
+	Requests int            `json:"requests"`
+	Errors   int            `json:"errors"`
+	Statuses map[string]int `json:"statuses"`
+	P50      time.Duration  `json:"p50"`
+	P90      time.Duration  `json:"p90"`
+	P99      time.Duration  `json:"p99"`
+	Max      time.Duration  `json:"max"`
+
+	latencies []time.Duration
+}
+
+// ErrorRate returns the share of requests that failed, from 0 to 1
+func (s *OpStats) ErrorRate() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Requests)
+}
+
+// Throughput returns the requests completed per second
+func (r *Report) Throughput() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	total := 0
+	for _, s := range r.Operations {
+		total += s.Requests
+	}
+	return float64(total) / r.Duration.Seconds()
+}
+
+// ParseRate parses a request rate such as "500/s", "100/m" or "500"
+// (per second)
+func ParseRate(s string) (float64, error) {
+	number, unit, _ := strings.Cut(strings.TrimSpace(s), "/")
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate %q: must be a positive number per s, m or h, e.g. 500/s", s)
+	}
+	switch unit {
+	case "", "s":
+		return n, nil
+	case "m":
+		return n / 60, nil
+	case "h":
+		return n / 3600, nil
+	default:
+		return 0, fmt.Errorf("invalid rate %q: unit must be s, m or h", s)
+	}
+}
+
+// Validate checks the configuration
+func (c *Config) Validate() error {
+	if c.Server == "" {
+		return fmt.Errorf("server is required")
+	}
+	if c.Networks < 1 {
+		return fmt.Errorf("networks must be at least 1")
+	}
+	prefix, err := netip.ParsePrefix(c.CIDR)
+	if err != nil || !prefix.Addr().Is4() || prefix.Bits() > 24 {
+		return fmt.Errorf("cidr %q must be an IPv4 prefix of /24 or larger", c.CIDR)
+	}
+	if available := 1 << (24 - prefix.Bits()); c.Networks > available {
+		return fmt.Errorf("cidr %s holds only %d /24 networks", c.CIDR, available)
+	}
+	if c.Rate <= 0 {
+		return fmt.Errorf("rate must be positive")
+	}
+	if c.Duration <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+	if c.Concurrency < 1 {
+		return fmt.Errorf("concurrency must be at least 1")
+	}
+	return nil
+}
+
+// networkCIDRs returns the /24s the test creates
+func (c *Config) networkCIDRs() []string {
+	base := netip.MustParsePrefix(c.CIDR).Masked().Addr().As4()
+	start := uint32(base[0])<<24 | uint32(base[1])<<16 | uint32(base[2])<<8
+
+	cidrs := make([]string, c.Networks)
+	for i := range cidrs {
+		n := start + uint32(i)<<8
+		cidrs[i] = fmt.Sprintf("%d.%d.%d.0/24", n>>24, n>>16&0xff, n>>8&0xff)
+	}
+	return cidrs
+}
+
+// maxLive is the number of allocations at which a network only releases;
+// with release probability proportional to fill, networks settle at half
+const maxLive = 128
+
+// network is a test network and the allocations the test holds in it
+type network struct {
+	id   string
+	live []string
+}
+
+// runner holds the state of a running test
+type runner struct {
+	cfg    Config
+	client *http.Client
+	rand   *rand.Rand
+
+	mu       sync.Mutex
+	networks []*network
+	stats    map[string]*OpStats
+}
+
+// Run creates the test networks, generates traffic for cfg.Duration, and
+// cleans up unless cfg.Keep is set. Cancelling ctx ends the traffic early;
+// cleanup still runs.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	r := &runner{
+		cfg:    cfg,
+		client: cfg.Client,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		stats: map[string]*OpStats{
+			OpAllocate: {Op: OpAllocate, Statuses: map[string]int{}},
+			OpRelease:  {Op: OpRelease, Statuses: map[string]int{}},
+		},
+	}
+	if r.client == nil {
+		r.client = &http.Client{Timeout: 30 * time.Second}
+	}
+	r.cfg.Server = strings.TrimSuffix(cfg.Server, "/")
+
+	if err := r.setup(ctx); err != nil {
+		r.cleanup()
+		return nil, err
+	}
+
+	report := r.traffic(ctx)
+
+	if !cfg.Keep {
+		if err := r.cleanup(); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// setup creates the test networks
+func (r *runner) setup(ctx context.Context) error {
+	for _, cidr := range r.cfg.networkCIDRs() {
+		var created struct {
+			ID string `json:"id"`
+		}
+		body := map[string]interface{}{"cidr": cidr, "description": "ipam loadtest", "tags": []string{"loadtest"}}
+		status, err := r.do(ctx, "POST", "/api/v1/networks", body, &created)
+		if err == nil && status != http.StatusCreated {
+			err = fmt.Errorf("status %d", status)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create network %s: %w", cidr, err)
+		}
+		r.networks = append(r.networks, &network{id: created.ID})
+	}
+	return nil
+}
+
+// traffic starts requests at the configured rate until the duration ends
+func (r *runner) traffic(ctx context.Context) *Report {
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.Duration)
+	defer cancel()
+
+	interval := time.Duration(float64(time.Second) / r.cfg.Rate)
+	if interval <= 0 {
+		interval = 1
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	slots := make(chan struct{}, r.cfg.Concurrency)
+	var wg sync.WaitGroup
+	report := &Report{}
+	start := time.Now()
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			report.Dropped++
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			r.request(context.Background())
+		}()
+	}
+	wg.Wait()
+	report.Duration = time.Since(start)
+
+	for _, op := range []string{OpAllocate, OpRelease} {
+		report.Operations = append(report.Operations, r.stats[op].summarize())
+	}
+	return report
+}
+
+// request picks a network and allocates in it or releases from it
+func (r *runner) request(ctx context.Context) {
+	r.mu.Lock()
+	n := r.networks[r.rand.Intn(len(r.networks))]
+	var release string
+	if len(n.live) > 0 && r.rand.Intn(maxLive) < len(n.live) {
+		i := r.rand.Intn(len(n.live))
+		release = n.live[i]
+		n.live[i] = n.live[len(n.live)-1]
+		n.live = n.live[:len(n.live)-1]
+	}
+	r.mu.Unlock()
+
+	start := time.Now()
+	if release != "" {
+		status, err := r.do(ctx, "POST", "/api/v1/allocations/"+release+"/release", nil, nil)
+		r.record(OpRelease, time.Since(start), status, err, http.StatusNoContent)
+		return
+	}
+
+	var alloc struct {
+		ID string `json:"id"`
+	}
+	status, err := r.do(ctx, "POST", "/api/v1/allocations", map[string]interface{}{"network_id": n.id, "hostname": "loadtest"}, &alloc)
+	r.record(OpAllocate, time.Since(start), status, err, http.StatusCreated)
+	if err == nil && status == http.StatusCreated {
+		r.mu.Lock()
+		n.live = append(n.live, alloc.ID)
+		r.mu.Unlock()
+	}
+}
+
+// record adds a request's outcome to its operation's stats
+func (r *runner) record(op string, latency time.Duration, status int, err error, want int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.stats[op]
+	s.Requests++
+	s.latencies = append(s.latencies, latency)
+	key := strconv.Itoa(status)
+	if err != nil {
+		key = "error"
+	}
+	s.Statuses[key]++
+	if err != nil || status != want {
+		s.Errors++
+	}
+}
+
+// cleanup releases the allocations still held and deletes the networks
+func (r *runner) cleanup() error {
+	ctx := context.Background()
+	var failed int
+	for _, n := range r.networks {
+		for _, id := range n.live {
+			if status, err := r.do(ctx, "POST", "/api/v1/allocations/"+id+"/release", nil, nil); err != nil || status != http.StatusNoContent {
+				failed++
+			}
+		}
+		n.live = nil
+		if status, err := r.do(ctx, "DELETE", "/api/v1/networks/"+n.id, nil, nil); err != nil || status != http.StatusNoContent {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("cleanup: %d requests failed; remove networks tagged loadtest by hand", failed)
+	}
+	return nil
+}
+
+// do sends a JSON request and decodes a successful response into out
+func (r *runner) do(ctx context.Context, method, path string, body, out interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, r.cfg.Server+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.cfg.Token)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, err
+		}
+	}
+	// Drain so the connection is reused
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+// summarize computes the latency percentiles
+func (s *OpStats) summarize() *OpStats {
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+	s.P50 = percentile(s.latencies, 50)
+	s.P90 = percentile(s.latencies, 90)
+	s.P99 = percentile(s.latencies, 99)
+	if len(s.latencies) > 0 {
+		s.Max = s.latencies[len(s.latencies)-1]
+	}
+	return s
+}
+
+// percentile returns the nearest-rank percentile p of sorted latencies
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
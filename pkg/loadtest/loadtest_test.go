@@ -0,0 +1,99 @@
+package loadtest
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/api"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRate(t *testing.T) {
+	for s, want := range map[string]float64{"500/s": 500, "500": 500, "120/m": 2, "3600/h": 1, "0.5/s": 0.5} {
+		got, err := ParseRate(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, want, got, s)
+	}
+	for _, s := range []string{"", "0/s", "-5/s", "fast", "10/d"} {
+		_, err := ParseRate(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := Config{Server: "http://localhost:8080", Networks: 10, CIDR: "10.240.0.0/16", Rate: 100, Duration: time.Minute, Concurrency: 10}
+	require.NoError(t, valid.Validate())
+	assert.Equal(t, []string{"10.240.0.0/24", "10.240.1.0/24"}, (&Config{Networks: 2, CIDR: "10.240.0.0/16"}).networkCIDRs())
+
+	for name, mutate := range map[string]func(*Config){
+		"server":      func(c *Config) { c.Server = "" },
+		"networks":    func(c *Config) { c.Networks = 0 },
+		"cidr":        func(c *Config) { c.CIDR = "10.240.0.0/25" },
+		"ipv6":        func(c *Config) { c.CIDR = "fd00::/48" },
+		"too many":    func(c *Config) { c.CIDR = "10.240.0.0/22"; c.Networks = 5 },
+		"rate":        func(c *Config) { c.Rate = 0 },
+		"duration":    func(c *Config) { c.Duration = 0 },
+		"concurrency": func(c *Config) { c.Concurrency = 0 },
+	} {
+		c := valid
+		mutate(&c)
+		assert.Error(t, c.Validate(), name)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, 50*time.Millisecond, percentile(latencies, 50))
+	assert.Equal(t, 99*time.Millisecond, percentile(latencies, 99))
+	assert.Equal(t, time.Millisecond, percentile(latencies[:1], 99))
+	assert.Equal(t, time.Duration(0), percentile(nil, 50))
+}
+
+func TestRun(t *testing.T) {
+	s, err := store.NewPebbleStore(t.TempDir())
+	require.NoError(t, err)
+	defer s.Close()
+	server := api.NewServer(ipam.New(s), s)
+	server.SetAuthTokens([]string{"secret"})
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	report, err := Run(context.Background(), Config{
+		Server:      ts.URL,
+		Token:       "secret",
+		Networks:    2,
+		CIDR:        "10.240.0.0/16",
+		Rate:        200,
+		Duration:    500 * time.Millisecond,
+		Concurrency: 8,
+	})
+	require.NoError(t, err)
+
+	require.Len(t, report.Operations, 2)
+	allocate := report.Operations[0]
+	assert.Equal(t, OpAllocate, allocate.Op)
+	assert.Positive(t, allocate.Requests)
+	assert.Zero(t, allocate.Errors, allocate.Statuses)
+	assert.Equal(t, allocate.Requests, allocate.Statuses["201"])
+	assert.LessOrEqual(t, allocate.P50, allocate.P99)
+	assert.LessOrEqual(t, allocate.P99, allocate.Max)
+	assert.Zero(t, report.Operations[1].Errors)
+	assert.Positive(t, report.Throughput())
+
+	// The test networks are removed afterwards
+	networks, err := s.ListNetworks()
+	require.NoError(t, err)
+	assert.Empty(t, networks)
+
+	// A missing token fails setup
+	_, err = Run(context.Background(), Config{Server: ts.URL, Networks: 1, CIDR: "10.240.0.0/16", Rate: 10, Duration: time.Second, Concurrency: 1})
+	assert.ErrorContains(t, err, "status 401")
+}
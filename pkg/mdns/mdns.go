@@ -0,0 +1,541 @@
+// Package mdns announces the API on the local network with multicast DNS
+// service discovery (RFC 6762, RFC 6763), and finds the servers announced
+// there, so that clients in small deployments need no configured address.
+//
+// A Responder publishes the service instance as _ipam._tcp.local: a PTR
+// record naming the instance, an SRV record with its host and port, a TXT
+// record with the URL scheme, and the host's A and AAAA records. Browse
+// sends a query for the service type and collects the answers.
+//
+// Only IPv4 multicast is used; the AAAA records are published over it all
+// the same.
+package mdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// ServiceType is the DNS-SD service type the API is announced as
+	ServiceType = "_ipam._tcp"
+
+	// Port is the mDNS port
+	Port = 5353
+)
+
+// TTLs of the published records, as RFC 6762 recommends: records naming
+// hosts or addresses expire sooner than the others
+const (
+	hostTTL    = 120
+	serviceTTL = 4500
+
+	// legacyTTL caps TTLs in replies to queries from ports other than
+	// Port, which come from plain DNS resolvers (RFC 6762 section 6.7)
+	legacyTTL = 10
+)
+
+// cacheFlush marks the class of records only the responder publishes
+const cacheFlush = 1 << 15
+
+// queryInterval is how often Browse repeats its query, as mDNS may drop
+// packets
+const queryInterval = time.Second
+
+// servicesName lists the service types on the network (RFC 6763
+// section 9)
+const servicesName = "_services._dns-sd._udp.local."
+
+// serviceName is the name the service type's instances are listed under
+const serviceName = ServiceType + ".local."
+
+// group is the IPv4 mDNS multicast group
+var group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: Port}
+
+// Responder announces a server's API and answers queries for it
+type Responder struct {
+	// Instance is the service instance name browsers list, e.g. the host
+	// name
+	Instance string
+
+	// Host is the host name, without .local, the addresses are published
+	// under
+	Host string
+
+	// Port is the API's TCP port
+	Port int
+
+	// Scheme is the API's URL scheme, http or https
+	Scheme string
+
+	// Addrs are the API's addresses; nil publishes the addresses of the
+	// host's multicast interfaces
+	Addrs []netip.Addr
+}
+
+// Start announces the service on the mDNS group and answers queries for it
+// in the background. Calling stop withdraws the announcement and stops
+// answering.
+func (r *Responder) Start() (stop func(), err error) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join the mDNS group: %w", err)
+	}
+	go func() {
+		if err := r.Serve(conn); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("mdns: %v", err)
+		}
+	}()
+
+	// Announce twice, a second apart, in case the first is lost (RFC 6762
+	// section 8.3)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 2; i++ {
+			r.announce(conn, serviceTTL, hostTTL)
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		// Records with TTL 0 tell caches to drop them
+		r.announce(conn, 0, 0)
+		conn.Close()
+	}, nil
+}
+
+// Serve answers queries arriving on conn until reading from it fails,
+// e.g. because it was closed, and returns that error. Replies go to the
+// mDNS group, or to the querier when it asks for a unicast reply or sent
+// the query from another port than Port.
+func (r *Responder) Serve(conn net.PacketConn) error {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		req := new(dns.Msg)
+		if req.Unpack(buf[:n]) != nil {
+			continue
+		}
+		resp := r.Answer(req)
+		if resp == nil {
+			continue
+		}
+
+		to := net.Addr(group)
+		if udp, ok := addr.(*net.UDPAddr); ok && udp.Port != Port {
+			legacyReply(req, resp)
+			to = addr
+		} else if unicastRequested(req) {
+			to = addr
+		}
+		packet, err := resp.Pack()
+		if err != nil {
+			log.Printf("mdns: failed to pack reply: %v", err)
+			continue
+		}
+		if _, err := conn.WriteTo(packet, to); err != nil {
+			log.Printf("mdns: failed to reply to %s: %v", addr, err)
+		}
+	}
+}
+
+// Answer returns the reply to the query req, or nil when the responder has
+// no records it asks for
+func (r *Responder) Answer(req *dns.Msg) *dns.Msg {
+	if req.Response || req.Opcode != dns.OpcodeQuery {
+		return nil
+	}
+	resp := new(dns.Msg)
+	resp.Response = true
+	resp.Authoritative = true
+
+	instance, host := strings.ToLower(r.instanceName()), r.hostName()
+	for _, q := range req.Question {
+		switch strings.ToLower(q.Name) {
+		case servicesName:
+			if asks(q, dns.TypePTR) {
+				resp.Answer = append(resp.Answer, r.typePTR(serviceTTL))
+			}
+		case serviceName:
+			if asks(q, dns.TypePTR) {
+				resp.Answer = append(resp.Answer, r.instancePTR(serviceTTL))
+				resp.Extra = append(resp.Extra, r.srv(hostTTL), r.txt(serviceTTL))
+				resp.Extra = append(resp.Extra, r.addrRecords(hostTTL, dns.TypeANY)...)
+			}
+		case instance:
+			if asks(q, dns.TypeSRV) {
+				resp.Answer = append(resp.Answer, r.srv(hostTTL))
+				resp.Extra = append(resp.Extra, r.addrRecords(hostTTL, dns.TypeANY)...)
+			}
+			if asks(q, dns.TypeTXT) {
+				resp.Answer = append(resp.Answer, r.txt(serviceTTL))
+			}
+		case host:
+			resp.Answer = append(resp.Answer, r.addrRecords(hostTTL, q.Qtype)...)
+		}
+	}
+	if len(resp.Answer) == 0 {
+		return nil
+	}
+	return resp
+}
+
+// announce sends every record of the service to the mDNS group, those
+// naming the host or its addresses with addrTTL
+func (r *Responder) announce(conn net.PacketConn, ttl, addrTTL uint32) {
+	msg := new(dns.Msg)
+	msg.Response = true
+	msg.Authoritative = true
+	msg.Answer = append(msg.Answer, r.typePTR(ttl), r.instancePTR(ttl), r.srv(addrTTL), r.txt(ttl))
+	msg.Answer = append(msg.Answer, r.addrRecords(addrTTL, dns.TypeANY)...)
+	packet, err := msg.Pack()
+	if err == nil {
+		_, err = conn.WriteTo(packet, group)
+	}
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("mdns: failed to announce: %v", err)
+	}
+}
+
+// instanceName is the service instance's domain name
+func (r *Responder) instanceName() string {
+	return escapeLabel(r.Instance) + "." + serviceName
+}
+
+// hostName is the domain name the addresses are published under
+func (r *Responder) hostName() string {
+	return strings.ToLower(r.Host) + ".local."
+}
+
+func (r *Responder) typePTR(ttl uint32) dns.RR {
+	return &dns.PTR{Hdr: header(servicesName, dns.TypePTR, ttl, false), Ptr: serviceName}
+}
+
+func (r *Responder) instancePTR(ttl uint32) dns.RR {
+	return &dns.PTR{Hdr: header(serviceName, dns.TypePTR, ttl, false), Ptr: r.instanceName()}
+}
+
+func (r *Responder) srv(ttl uint32) dns.RR {
+	return &dns.SRV{Hdr: header(r.instanceName(), dns.TypeSRV, ttl, true), Port: uint16(r.Port), Target: r.hostName()}
+}
+
+func (r *Responder) txt(ttl uint32) dns.RR {
+	return &dns.TXT{Hdr: header(r.instanceName(), dns.TypeTXT, ttl, true), Txt: []string{"scheme=" + r.Scheme}}
+}
+
+// addrRecords returns the A and AAAA records of qtype, or both for ANY
+func (r *Responder) addrRecords(ttl uint32, qtype uint16) []dns.RR {
+	var rrs []dns.RR
+	for _, addr := range r.addrs() {
+		switch {
+		case addr.Is4() && (qtype == dns.TypeA || qtype == dns.TypeANY):
+			rrs = append(rrs, &dns.A{Hdr: header(r.hostName(), dns.TypeA, ttl, true), A: addr.AsSlice()})
+		case addr.Is6() && (qtype == dns.TypeAAAA || qtype == dns.TypeANY):
+			rrs = append(rrs, &dns.AAAA{Hdr: header(r.hostName(), dns.TypeAAAA, ttl, true), AAAA: addr.AsSlice()})
+		}
+	}
+	return rrs
+}
+
+// addrs returns Addrs, or the addresses of the host's up multicast
+// interfaces other than loopback. IPv6 link-local addresses are left out,
+// as URLs cannot use them without naming an interface.
+func (r *Responder) addrs() []netip.Addr {
+	if r.Addrs != nil {
+		return r.Addrs
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		log.Printf("mdns: failed to list interfaces: %v", err)
+		return nil
+	}
+	var addrs []netip.Addr
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		ifAddrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range ifAddrs {
+			ipNet, ok := a.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			addr, ok := netip.AddrFromSlice(ipNet.IP)
+			if ok && !(addr.Is6() && !addr.Is4In6() && addr.IsLinkLocalUnicast()) {
+				addrs = append(addrs, addr.Unmap())
+			}
+		}
+	}
+	return addrs
+}
+
+// header returns a record header; unique records carry the cache flush bit
+func header(name string, rrtype uint16, ttl uint32, unique bool) dns.RR_Header {
+	class := uint16(dns.ClassINET)
+	if unique {
+		class |= cacheFlush
+	}
+	return dns.RR_Header{Name: name, Rrtype: rrtype, Class: class, Ttl: ttl}
+}
+
+// asks reports whether question q asks for records of rrtype
+func asks(q dns.Question, rrtype uint16) bool {
+	return q.Qtype == rrtype || q.Qtype == dns.TypeANY
+}
+
+// unicastRequested reports whether a question of req asks for a unicast
+// reply, with the top bit of its class
+func unicastRequested(req *dns.Msg) bool {
+	for _, q := range req.Question {
+		if q.Qclass&cacheFlush != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// legacyReply makes resp a reply a plain DNS resolver accepts: it echoes
+// the query's ID and questions, caps TTLs and clears cache flush bits
+func legacyReply(req, resp *dns.Msg) {
+	resp.Id = req.Id
+	resp.Question = req.Question
+	for _, rr := range append(resp.Answer, resp.Extra...) {
+		hdr := rr.Header()
+		hdr.Class &^= cacheFlush
+		hdr.Ttl = min(hdr.Ttl, legacyTTL)
+	}
+}
+
+// escapeLabel returns a label in presentation format, escaped the way
+// names read from messages are, so that they compare equal
+func escapeLabel(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case strings.IndexByte(`.();@" \`, c) >= 0:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&b, "\\%03d", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// unescapeLabel reverses the escapes of a label in presentation format:
+// \X is X and \DDD the byte with decimal value DDD
+func unescapeLabel(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 10, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		i++
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// Service is an announced server
+type Service struct {
+	// Instance is the service instance name
+	Instance string `json:"instance"`
+
+	// Host is the server's host name
+	Host string `json:"host"`
+
+	// Port is the API's TCP port
+	Port int `json:"port"`
+
+	// Addrs are the server's addresses
+	Addrs []netip.Addr `json:"addrs"`
+
+	// Scheme is the API's URL scheme
+	Scheme string `json:"scheme"`
+}
+
+// URL returns the API's base URL, with an IPv4 address of the server when
+// it has one, else an IPv6 address, else its host name
+func (s Service) URL() string {
+	host := s.Host
+	for _, addr := range s.Addrs {
+		if addr.Is4() {
+			host = addr.String()
+			break
+		}
+		if host == s.Host {
+			host = addr.String()
+		}
+	}
+	scheme := s.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	return scheme + "://" + net.JoinHostPort(host, strconv.Itoa(s.Port))
+}
+
+// Browse queries the local network for announced servers until ctx is
+// done, and returns those that answered, in order of instance name
+func Browse(ctx context.Context) ([]Service, error) {
+	return browse(ctx, group)
+}
+
+// browse sends the query to addr
+func browse(ctx context.Context, addr *net.UDPAddr) ([]Service, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// Asking from another port than Port gets unicast replies
+	req := new(dns.Msg)
+	req.SetQuestion(serviceName, dns.TypePTR)
+	req.RecursionDesired = false
+	query, err := req.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	var c collector
+	buf := make([]byte, 65535)
+	next := time.Now()
+	for ctx.Err() == nil {
+		if !time.Now().Before(next) {
+			if _, err := conn.WriteTo(query, addr); err != nil {
+				return nil, fmt.Errorf("failed to send query: %w", err)
+			}
+			next = time.Now().Add(queryInterval)
+		}
+		deadline := next
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetReadDeadline(deadline)
+		n, _, err := conn.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		msg := new(dns.Msg)
+		if msg.Unpack(buf[:n]) == nil && msg.Response {
+			c.add(msg)
+		}
+	}
+	return c.services(), nil
+}
+
+// collector gathers the records of replies to a browse query
+type collector struct {
+	instances map[string]bool
+	srv       map[string]*dns.SRV
+	txt       map[string][]string
+	addrs     map[string][]netip.Addr
+}
+
+// add records the service records in msg. Withdrawn records, with TTL 0,
+// are ignored.
+func (c *collector) add(msg *dns.Msg) {
+	if c.instances == nil {
+		c.instances = make(map[string]bool)
+		c.srv = make(map[string]*dns.SRV)
+		c.txt = make(map[string][]string)
+		c.addrs = make(map[string][]netip.Addr)
+	}
+	for _, rr := range append(msg.Answer, msg.Extra...) {
+		hdr := rr.Header()
+		if hdr.Ttl == 0 {
+			continue
+		}
+		name := strings.ToLower(hdr.Name)
+		switch rr := rr.(type) {
+		case *dns.PTR:
+			if name == serviceName {
+				c.instances[strings.ToLower(rr.Ptr)] = true
+			}
+		case *dns.SRV:
+			c.srv[name] = rr
+		case *dns.TXT:
+			c.txt[name] = rr.Txt
+		case *dns.A:
+			c.addAddr(name, rr.A)
+		case *dns.AAAA:
+			c.addAddr(name, rr.AAAA)
+		}
+	}
+}
+
+func (c *collector) addAddr(name string, ip net.IP) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return
+	}
+	addr = addr.Unmap()
+	for _, a := range c.addrs[name] {
+		if a == addr {
+			return
+		}
+	}
+	c.addrs[name] = append(c.addrs[name], addr)
+}
+
+// services returns the instances whose SRV records were received
+func (c *collector) services() []Service {
+	var services []Service
+	for name := range c.instances {
+		srv, ok := c.srv[name]
+		if !ok {
+			continue
+		}
+		labels := dns.SplitDomainName(srv.Hdr.Name)
+		host := strings.ToLower(srv.Target)
+		s := Service{
+			Instance: unescapeLabel(labels[0]),
+			Host:     strings.TrimSuffix(host, "."),
+			Port:     int(srv.Port),
+			Addrs:    c.addrs[host],
+		}
+		for _, kv := range c.txt[name] {
+			if value, ok := strings.CutPrefix(kv, "scheme="); ok {
+				s.Scheme = value
+			}
+		}
+		sort.Slice(s.Addrs, func(i, j int) bool { return s.Addrs[i].Less(s.Addrs[j]) })
+		services = append(services, s)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Instance < services[j].Instance })
+	return services
+}
@@ -0,0 +1,103 @@
+package mdns
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testResponder() *Responder {
+	return &Responder{
+		Instance: "ipam on lab.1",
+		Host:     "Lab1",
+		Port:     8443,
+		Scheme:   "https",
+		Addrs:    []netip.Addr{netip.MustParseAddr("2001:db8::5"), netip.MustParseAddr("192.0.2.5")},
+	}
+}
+
+func TestAnswer(t *testing.T) {
+	r := testResponder()
+	ask := func(name string, qtype uint16) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(name, qtype)
+		return r.Answer(req)
+	}
+
+	resp := ask("_ipam._tcp.local.", dns.TypePTR)
+	require.NotNil(t, resp)
+	assert.True(t, resp.Authoritative)
+	require.Len(t, resp.Answer, 1)
+	assert.Equal(t, `ipam\ on\ lab\.1._ipam._tcp.local.`, resp.Answer[0].(*dns.PTR).Ptr)
+	require.Len(t, resp.Extra, 4)
+	srv := resp.Extra[0].(*dns.SRV)
+	assert.Equal(t, uint16(8443), srv.Port)
+	assert.Equal(t, "lab1.local.", srv.Target)
+	assert.Equal(t, uint16(dns.ClassINET|cacheFlush), srv.Hdr.Class)
+	assert.Equal(t, []string{"scheme=https"}, resp.Extra[1].(*dns.TXT).Txt)
+
+	resp = ask("_services._dns-sd._udp.local.", dns.TypePTR)
+	require.NotNil(t, resp)
+	assert.Equal(t, "_ipam._tcp.local.", resp.Answer[0].(*dns.PTR).Ptr)
+
+	resp = ask("LAB1.local.", dns.TypeA)
+	require.NotNil(t, resp)
+	require.Len(t, resp.Answer, 1)
+	assert.Equal(t, "192.0.2.5", resp.Answer[0].(*dns.A).A.String())
+
+	resp = ask(`ipam\ on\ lab\.1._ipam._tcp.local.`, dns.TypeTXT)
+	require.NotNil(t, resp)
+	assert.Len(t, resp.Answer, 1)
+
+	// Other services and record types go unanswered
+	assert.Nil(t, ask("_http._tcp.local.", dns.TypePTR))
+	assert.Nil(t, ask("lab1.local.", dns.TypeMX))
+}
+
+func TestBrowse(t *testing.T) {
+	// A unicast socket stands in for the multicast group; queries from an
+	// ephemeral port get unicast replies
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+	go testResponder().Serve(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	services, err := browse(ctx, conn.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+	require.Len(t, services, 1)
+	s := services[0]
+	assert.Equal(t, "ipam on lab.1", s.Instance)
+	assert.Equal(t, "lab1.local", s.Host)
+	assert.Equal(t, 8443, s.Port)
+	assert.Equal(t, "https", s.Scheme)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.0.2.5"), netip.MustParseAddr("2001:db8::5")}, s.Addrs)
+	assert.Equal(t, "https://192.0.2.5:8443", s.URL())
+}
+
+func TestLegacyReply(t *testing.T) {
+	req := new(dns.Msg)
+	req.SetQuestion("_ipam._tcp.local.", dns.TypePTR)
+	resp := testResponder().Answer(req)
+	legacyReply(req, resp)
+	assert.Equal(t, req.Id, resp.Id)
+	assert.Equal(t, req.Question, resp.Question)
+	for _, rr := range append(resp.Answer, resp.Extra...) {
+		assert.Equal(t, uint16(dns.ClassINET), rr.Header().Class)
+		assert.LessOrEqual(t, rr.Header().Ttl, uint32(legacyTTL))
+	}
+}
+
+func TestServiceURL(t *testing.T) {
+	s := Service{Host: "lab1.local", Port: 8080}
+	assert.Equal(t, "http://lab1.local:8080", s.URL())
+	s.Addrs = []netip.Addr{netip.MustParseAddr("2001:db8::5")}
+	assert.Equal(t, "http://[2001:db8::5]:8080", s.URL())
+}
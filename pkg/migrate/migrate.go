@@ -0,0 +1,142 @@
+// Package migrate copies all data from one store backend to another, e.g.
+// from a standalone Pebble database into a new Raft cluster.
+package migrate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/snapshot"
+)
+
+// ErrTargetNotEmpty is returned when the target store already holds data
+var ErrTargetNotEmpty = errors.New("target store is not empty")
+
+// Result counts the records copied
+type Result struct {
+	Networks     int `json:"networks"`
+	Allocations  int `json:"allocations"`
+	AuditEntries int `json:"audit_entries"`
+}
+
+// Plan counts what Copy would copy from src, and checks dst is empty
+func Plan(dst, src ipam.Store) (Result, error) {
+	if err := checkEmpty(dst); err != nil {
+		return Result{}, err
+	}
+	snap, err := snapshot.FromStore(src, time.Time{})
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to read source: %w", err)
+	}
+	audit, err := src.ListAuditEntries(0)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to read source audit log: %w", err)
+	}
+	return Result{Networks: len(snap.Networks), Allocations: len(snap.Allocations), AuditEntries: len(audit)}, nil
+}
+
+// Copy copies every network, allocation (released ones included) and audit
+// entry from src to the empty store dst, keeping their IDs, then reads dst
+// back to verify it matches. src is only read. If Copy fails, dst may hold
+// part of the data and should be discarded.
+func Copy(dst, src ipam.Store) (Result, error) {
+	var result Result
+	if err := checkEmpty(dst); err != nil {
+		return result, err
+	}
+
+	snap, err := snapshot.FromStore(src, time.Time{})
+	if err != nil {
+		return result, fmt.Errorf("failed to read source: %w", err)
+	}
+	audit, err := src.ListAuditEntries(0)
+	if err != nil {
+		return result, fmt.Errorf("failed to read source audit log: %w", err)
+	}
+
+	for _, network := range snap.Networks {
+		if err := dst.SaveNetwork(network); err != nil {
+			return result, fmt.Errorf("failed to copy network %s: %w", network.CIDR, err)
+		}
+		result.Networks++
+	}
+	for _, alloc := range snap.Allocations {
+		if err := dst.SaveAllocation(alloc); err != nil {
+			return result, fmt.Errorf("failed to copy allocation %s: %w", alloc.IP, err)
+		}
+		result.Allocations++
+	}
+	// Oldest first, as they were written
+	for i := len(audit) - 1; i >= 0; i-- {
+		if err := dst.SaveAuditEntry(audit[i]); err != nil {
+			return result, fmt.Errorf("failed to copy audit entry %s: %w", audit[i].ID, err)
+		}
+		result.AuditEntries++
+	}
+
+	if err := verify(dst, snap, audit); err != nil {
+		return result, fmt.Errorf("verification failed: %w", err)
+	}
+	return result, nil
+}
+
+// checkEmpty refuses to merge into a store that already holds networks, so
+// a migration never mixes two data sets
+func checkEmpty(s ipam.Store) error {
+	networks, err := s.ListNetworks()
+	if err != nil {
+		return fmt.Errorf("failed to read target: %w", err)
+	}
+	if len(networks) > 0 {
+		return fmt.Errorf("%w: it holds %d networks", ErrTargetNotEmpty, len(networks))
+	}
+	return nil
+}
+
+// verify checks that dst holds exactly the records of snap and audit
+func verify(dst ipam.Store, snap *snapshot.Snapshot, audit []*ipam.AuditEntry) error {
+	copied, err := snapshot.FromStore(dst, time.Time{})
+	if err != nil {
+		return err
+	}
+	if err := sameRecords("networks", snap.Networks, copied.Networks, func(n *ipam.Network) string { return n.ID }); err != nil {
+		return err
+	}
+	if err := sameRecords("allocations", snap.Allocations, copied.Allocations, func(a *ipam.IPAllocation) string { return a.ID }); err != nil {
+		return err
+	}
+	copiedAudit, err := dst.ListAuditEntries(0)
+	if err != nil {
+		return err
+	}
+	return sameRecords("audit entries", audit, copiedAudit, func(e *ipam.AuditEntry) string { return e.ID })
+}
+
+// sameRecords checks that want and got hold the same records, compared as
+// JSON, regardless of order
+func sameRecords[T any](kind string, want, got []T, id func(T) string) error {
+	if len(want) != len(got) {
+		return fmt.Errorf("%s: copied %d, target holds %d", kind, len(want), len(got))
+	}
+	byID := map[string]string{}
+	for _, record := range got {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		byID[id(record)] = string(data)
+	}
+	for _, record := range want {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if byID[id(record)] != string(data) {
+			return fmt.Errorf("%s: %s differs in the target", kind, id(record))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package migrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store/pebbletest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopy(t *testing.T) {
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	released := now.Add(time.Hour)
+
+	src := pebbletest.New(t)
+	require.NoError(t, src.SaveNetwork(&ipam.Network{ID: "net-1", CIDR: "10.0.0.0/24", Tags: []string{"env=prod"}, CreatedAt: now}))
+	require.NoError(t, src.SaveNetwork(&ipam.Network{ID: "net-2", CIDR: "2001:db8::/64", CreatedAt: now}))
+	require.NoError(t, src.SaveAllocation(&ipam.IPAllocation{ID: "alloc-1", NetworkID: "net-1", IP: "10.0.0.1", Hostname: "web-1", Status: "allocated", AllocatedAt: now}))
+	require.NoError(t, src.SaveAllocation(&ipam.IPAllocation{ID: "alloc-2", NetworkID: "net-1", IP: "10.0.0.2", Status: "released", AllocatedAt: now, ReleasedAt: &released}))
+	require.NoError(t, src.SaveAllocation(&ipam.IPAllocation{ID: "alloc-3", NetworkID: "net-2", IP: "2001:db8::10", Status: "allocated", AllocatedAt: now}))
+	for i, action := range []string{"create", "allocate", "release"} {
+		require.NoError(t, src.SaveAuditEntry(&ipam.AuditEntry{ID: action, Timestamp: now.Add(time.Duration(i) * time.Minute), Action: action}))
+	}
+
+	dst := pebbletest.New(t)
+	plan, err := Plan(dst, src)
+	require.NoError(t, err)
+	want := Result{Networks: 2, Allocations: 3, AuditEntries: 3}
+	assert.Equal(t, want, plan)
+
+	result, err := Copy(dst, src)
+	require.NoError(t, err)
+	assert.Equal(t, want, result)
+
+	alloc, err := dst.GetAllocationByIP("net-1", "10.0.0.2")
+	require.NoError(t, err)
+	assert.Equal(t, "alloc-2", alloc.ID)
+	assert.Equal(t, released, *alloc.ReleasedAt)
+	audit, err := dst.ListAuditEntries(0)
+	require.NoError(t, err)
+	require.Len(t, audit, 3)
+	assert.Equal(t, "release", audit[0].ID, "most recent first, as in the source")
+
+	// The source is untouched
+	networks, err := src.ListNetworks()
+	require.NoError(t, err)
+	assert.Len(t, networks, 2)
+
+	// Never merge into existing data
+	_, err = Copy(dst, src)
+	assert.ErrorIs(t, err, ErrTargetNotEmpty)
+	_, err = Plan(dst, src)
+	assert.ErrorIs(t, err, ErrTargetNotEmpty)
+}
+
+func TestSameRecords(t *testing.T) {
+	id := func(n *ipam.Network) string { return n.ID }
+	a := []*ipam.Network{{ID: "net-1", CIDR: "10.0.0.0/24"}}
+
+	assert.NoError(t, sameRecords("networks", a, []*ipam.Network{{ID: "net-1", CIDR: "10.0.0.0/24"}}, id))
+	assert.ErrorContains(t, sameRecords("networks", a, nil, id), "copied 1, target holds 0")
+	assert.ErrorContains(t, sameRecords("networks", a, []*ipam.Network{{ID: "net-1", CIDR: "10.0.1.0/24"}}, id), "net-1 differs")
+}
@@ -0,0 +1,156 @@
+// Package naming generates unique hostnames for allocations from templates
+// such as "web-{{seq}}.{{domain}}".
+package naming
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// Template placeholders:
+//
+//	{{seq}}    the lowest positive integer giving an unused name
+//	{{pet}}    a random adjective-noun pair, e.g. "brave-otter"
+//	{{domain}} the network's domain, from its domain=<name> tag
+const (
+	placeholderSeq    = "{{seq}}"
+	placeholderPet    = "{{pet}}"
+	placeholderDomain = "{{domain}}"
+)
+
+// maxAttempts bounds the search for an unused name
+const maxAttempts = 100000
+
+var (
+	// ErrHostnameTaken is returned when a template without {{seq}} or
+	// {{pet}} renders to a hostname already in use
+	ErrHostnameTaken = errors.New("hostname is already in use in this network")
+
+	// ErrNoDomain is returned when a template uses {{domain}} but the
+	// network has no domain
+	ErrNoDomain = errors.New("template uses {{domain}} but the network has no domain=<name> tag")
+
+	// ErrExhausted is returned when no unused hostname was found
+	ErrExhausted = errors.New("no unused hostname available for template")
+
+	placeholderPattern = regexp.MustCompile(`\{\{[^}]*\}\}`)
+)
+
+// Validate checks that template only uses known placeholders
+func Validate(template string) error {
+	if strings.TrimSpace(template) == "" {
+		return fmt.Errorf("template must not be empty")
+	}
+	for _, p := range placeholderPattern.FindAllString(template, -1) {
+		if p != placeholderSeq && p != placeholderPet && p != placeholderDomain {
+			return fmt.Errorf("unknown placeholder %s: use {{seq}}, {{pet}} or {{domain}}", p)
+		}
+	}
+	return nil
+}
+
+// Generator renders templates into hostnames unused in one network
+type Generator struct {
+	// Domain replaces {{domain}}
+	Domain string
+	// Taken holds the lower-cased hostnames already in use
+	Taken map[string]bool
+	// Rand picks {{pet}} names; a time-seeded source is used when nil
+	Rand *rand.Rand
+}
+
+// NewGenerator returns a Generator for a network and its allocations.
+// Released and expired allocations do not hold on to their hostnames.
+func NewGenerator(network *ipam.Network, allocations []*ipam.IPAllocation, now time.Time) *Generator {
+	g := &Generator{Taken: make(map[string]bool, len(allocations))}
+	for _, tag := range network.Tags {
+		if value, ok := strings.CutPrefix(tag, "domain="); ok {
+			g.Domain = value
+		}
+	}
+	for _, alloc := range allocations {
+		if alloc.Hostname == "" || alloc.ReleasedAt != nil || (alloc.ExpiresAt != nil && alloc.ExpiresAt.Before(now)) {
+			continue
+		}
+		g.Taken[strings.ToLower(alloc.Hostname)] = true
+	}
+	return g
+}
+
+// Generate renders template into a hostname that is not taken and marks it
+// as taken, so repeated calls yield distinct names
+func (g *Generator) Generate(template string) (string, error) {
+	if err := Validate(template); err != nil {
+		return "", err
+	}
+	if strings.Contains(template, placeholderDomain) {
+		if g.Domain == "" {
+			return "", ErrNoDomain
+		}
+		template = strings.ReplaceAll(template, placeholderDomain, g.Domain)
+	}
+
+	hasSeq := strings.Contains(template, placeholderSeq)
+	hasPet := strings.Contains(template, placeholderPet)
+
+	rng := g.Rand
+	if rng == nil && hasPet {
+		rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	attempts := maxAttempts
+	if !hasSeq && !hasPet {
+		attempts = 1
+	}
+
+	for i := 1; i <= attempts; i++ {
+		name := template
+		if hasSeq {
+			name = strings.ReplaceAll(name, placeholderSeq, strconv.Itoa(i))
+		}
+		if hasPet {
+			pet := adjectives[rng.Intn(len(adjectives))] + "-" + nouns[rng.Intn(len(nouns))]
+			name = strings.ReplaceAll(name, placeholderPet, pet)
+		}
+
+		key := strings.ToLower(name)
+		if !g.Taken[key] {
+			if g.Taken == nil {
+				g.Taken = make(map[string]bool)
+			}
+			g.Taken[key] = true
+			return name, nil
+		}
+	}
+
+	if !hasSeq && !hasPet {
+		return "", fmt.Errorf("%w: %s", ErrHostnameTaken, template)
+	}
+	return "", ErrExhausted
+}
+
+var adjectives = []string{
+	"amber", "bold", "brave", "bright", "calm", "clever", "cool", "crisp",
+	"eager", "fair", "fast", "gentle", "glad", "grand", "happy", "keen",
+	"kind", "lively", "lucky", "mellow", "merry", "mighty", "noble", "proud",
+	"quick", "quiet", "rapid", "sharp", "shy", "silent", "smart", "snowy",
+	"solid", "steady", "stout", "sunny", "swift", "tidy", "vivid", "warm",
+	"wild", "wise", "witty", "young", "zesty",
+}
+
+var nouns = []string{
+	"badger", "bear", "beaver", "bison", "cobra", "condor", "crane", "deer",
+	"dingo", "eagle", "falcon", "ferret", "finch", "fox", "gecko", "heron",
+	"ibex", "jackal", "koala", "lemur", "lion", "llama", "lynx", "marten",
+	"mole", "moose", "newt", "okapi", "orca", "otter", "owl", "panda",
+	"puffin", "quail", "raven", "robin", "seal", "shrew", "sloth", "stork",
+	"tapir", "tiger", "toad", "viper", "walrus", "wolf", "wombat", "yak",
+	"zebra",
+}
@@ -0,0 +1,65 @@
+package naming
+
+import (
+	"errors"
+	"math/rand"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate("web-{{seq}}.{{domain}}"))
+	assert.NoError(t, Validate("{{pet}}"))
+	assert.Error(t, Validate(""))
+	assert.Error(t, Validate("web-{{ip}}"))
+}
+
+func TestGenerateSeq(t *testing.T) {
+	now := time.Now()
+	network := &ipam.Network{ID: "n1", CIDR: "10.0.0.0/24", Tags: []string{"env=prod", "domain=example.com"}}
+	allocations := []*ipam.IPAllocation{
+		{Hostname: "web-1.example.com"},
+		{Hostname: "WEB-2.example.com"},
+		{Hostname: "web-3.example.com", ReleasedAt: &now},
+	}
+
+	g := NewGenerator(network, allocations, now)
+	name, err := g.Generate("web-{{seq}}.{{domain}}")
+	require.NoError(t, err)
+	assert.Equal(t, "web-3.example.com", name)
+
+	name, err = g.Generate("web-{{seq}}.{{domain}}")
+	require.NoError(t, err)
+	assert.Equal(t, "web-4.example.com", name)
+}
+
+func TestGeneratePet(t *testing.T) {
+	g := &Generator{Rand: rand.New(rand.NewSource(1))}
+	seen := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		name, err := g.Generate("{{pet}}")
+		require.NoError(t, err)
+		assert.Regexp(t, regexp.MustCompile(`^[a-z]+-[a-z]+$`), name)
+		assert.False(t, seen[name])
+		seen[name] = true
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	g := NewGenerator(&ipam.Network{ID: "n1"}, []*ipam.IPAllocation{{Hostname: "gateway"}}, time.Now())
+
+	_, err := g.Generate("web-{{seq}}.{{domain}}")
+	assert.True(t, errors.Is(err, ErrNoDomain))
+
+	_, err = g.Generate("gateway")
+	assert.True(t, errors.Is(err, ErrHostnameTaken))
+
+	name, err := g.Generate("router")
+	require.NoError(t, err)
+	assert.Equal(t, "router", name)
+}
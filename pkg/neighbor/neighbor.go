@@ -0,0 +1,348 @@
+// Package neighbor ingests ARP and NDP tables collected from routers and
+// switches, e.g. over SNMP, SSH or gNMI, to keep the MAC address observed
+// on each allocation current and to flag allocations whose observed MAC
+// differs from the one recorded for them.
+//
+// Like health probes, observations are tags, so they travel with the
+// allocation through every store and export: observed-mac=<MAC> is the
+// MAC last observed for the address, which also moves last-seen on, and
+// mac-conflict marks an allocation whose recorded mac=<MAC> tag, or the
+// table itself, disagrees with what was observed.
+package neighbor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/health"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+const (
+	// MACTagPrefix records the MAC an allocation is expected to have, e.g.
+	// that of a DHCP reservation
+	MACTagPrefix = "mac="
+
+	// ObservedMACTagPrefix is the MAC last observed for an allocation
+	ObservedMACTagPrefix = "observed-mac="
+
+	// ConflictTag marks allocations observed with a MAC other than the
+	// recorded one, or with several MACs at once
+	ConflictTag = "mac-conflict"
+)
+
+// Supported table formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Entry is one row of an ARP or NDP table
+type Entry struct {
+	IP  netip.Addr `json:"ip"`
+	MAC string     `json:"mac"`
+}
+
+// NormalizeMAC returns mac in lower-case, colon-separated form. It accepts
+// the colon, hyphen and Cisco dotted forms.
+func NormalizeMAC(mac string) (string, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) != 6 {
+		return "", fmt.Errorf("invalid MAC address %q", mac)
+	}
+	return hw.String(), nil
+}
+
+// usable reports whether a table may carry mac for a host: incomplete
+// entries show all zeros, and broadcast and multicast addresses are not
+// hosts
+func usable(mac string) bool {
+	hw, _ := net.ParseMAC(mac)
+	return mac != "00:00:00:00:00:00" && hw[0]&1 == 0
+}
+
+// Parse reads table entries from r in the given format:
+//
+//   - text: lines holding an IP address and a MAC address in any columns,
+//     which covers arp -an, ip neigh, Cisco show ip arp and show ipv6
+//     neighbors, Junos show arp and Windows arp -a output; lines without
+//     both, such as incomplete entries and headers, are skipped
+//   - json: an array of objects with "ip" and "mac" fields
+//
+// Entries without a usable host MAC are dropped.
+func Parse(r io.Reader, format string) ([]Entry, error) {
+	var entries []Entry
+	var err error
+	switch format {
+	case "", FormatText:
+		entries, err = parseText(r)
+	case FormatJSON:
+		entries, err = parseJSON(r)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(entries, func(e Entry) bool { return !usable(e.MAC) }), nil
+}
+
+func parseText(r io.Reader) ([]Entry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, line := range strings.Split(string(data), "\n") {
+		var e Entry
+		fields := strings.FieldsFunc(line, func(c rune) bool {
+			return strings.ContainsRune(" \t\r,;()[]", c)
+		})
+		for _, field := range fields {
+			if addr, err := netip.ParseAddr(field); err == nil && !e.IP.IsValid() {
+				e.IP = addr.Unmap().WithZone("")
+			} else if mac, err := NormalizeMAC(field); err == nil && e.MAC == "" {
+				e.MAC = mac
+			}
+		}
+		if e.IP.IsValid() && e.MAC != "" {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+func parseJSON(r io.Reader) ([]Entry, error) {
+	var items []struct {
+		IP  string `json:"ip"`
+		MAC string `json:"mac"`
+	}
+	if err := json.NewDecoder(r).Decode(&items); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	entries := make([]Entry, 0, len(items))
+	for i, item := range items {
+		addr, err := netip.ParseAddr(item.IP)
+		if err != nil {
+			return nil, fmt.Errorf("item %d: invalid address %q", i, item.IP)
+		}
+		mac, err := NormalizeMAC(item.MAC)
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %w", i, err)
+		}
+		entries = append(entries, Entry{IP: addr.Unmap(), MAC: mac})
+	}
+	return entries, nil
+}
+
+// RecordedMAC returns the MAC recorded for alloc, normalized when valid
+func RecordedMAC(alloc *ipam.IPAllocation) (string, bool) {
+	for _, tag := range alloc.Tags {
+		if value, ok := strings.CutPrefix(tag, MACTagPrefix); ok {
+			if mac, err := NormalizeMAC(value); err == nil {
+				return mac, true
+			}
+			return strings.ToLower(value), true
+		}
+	}
+	return "", false
+}
+
+// Conflict is an allocation observed with a MAC other than the recorded
+// one, or with several MACs
+type Conflict struct {
+	IP           string   `json:"ip"`
+	AllocationID string   `json:"allocation_id"`
+	NetworkID    string   `json:"network_id"`
+	Hostname     string   `json:"hostname,omitempty"`
+	Recorded     string   `json:"recorded,omitempty"`
+	Observed     []string `json:"observed"`
+}
+
+// Result is the outcome of ingesting a table
+type Result struct {
+	// Entries counts the distinct addresses in the table
+	Entries int `json:"entries"`
+
+	// Matched counts those with an active allocation, and Updated the
+	// allocations whose tags changed
+	Matched int `json:"matched"`
+	Updated int `json:"updated"`
+
+	Conflicts []Conflict `json:"conflicts"`
+
+	// Unknown lists addresses inside a network but without an active
+	// allocation holding them
+	Unknown []Entry `json:"unknown"`
+}
+
+// Ingester applies tables to the allocations of Store
+type Ingester struct {
+	Store ipam.Store
+
+	// Allow, when set, limits ingestion to the networks it returns true
+	// for, e.g. those the caller may change
+	Allow func(network *ipam.Network) bool
+}
+
+// Ingest records entries, seen at now, on the active single-address
+// allocations holding their addresses: observed-mac and last-seen are
+// updated, and mac-conflict is set when the recorded MAC differs from the
+// observed one or the table lists several MACs for the address, and
+// cleared otherwise. Ranges are pools rather than hosts and are left
+// alone; addresses outside every network are ignored.
+func (in *Ingester) Ingest(entries []Entry, now time.Time) (*Result, error) {
+	observed := make(map[netip.Addr][]string)
+	var order []netip.Addr
+	for _, e := range entries {
+		if _, ok := observed[e.IP]; !ok {
+			order = append(order, e.IP)
+		}
+		if !slices.Contains(observed[e.IP], e.MAC) {
+			observed[e.IP] = append(observed[e.IP], e.MAC)
+		}
+	}
+	sort.Slice(order, func(i, j int) bool { return order[i].Less(order[j]) })
+
+	index, err := in.index(now)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Entries: len(order), Conflicts: []Conflict{}, Unknown: []Entry{}}
+	for _, addr := range order {
+		macs := observed[addr]
+		alloc, ok := index.allocations[addr]
+		if !ok {
+			if index.unallocated(addr) {
+				for _, mac := range macs {
+					result.Unknown = append(result.Unknown, Entry{IP: addr, MAC: mac})
+				}
+			}
+			continue
+		}
+		result.Matched++
+
+		// Save onto the current record in case it changed meanwhile
+		current, err := in.Store.GetAllocation(alloc.ID)
+		if err != nil || current.ReleasedAt != nil {
+			continue
+		}
+		recorded, hasRecorded := RecordedMAC(current)
+		conflict := len(macs) > 1 || (hasRecorded && recorded != macs[0])
+		if conflict {
+			result.Conflicts = append(result.Conflicts, Conflict{
+				IP:           addr.String(),
+				AllocationID: current.ID,
+				NetworkID:    current.NetworkID,
+				Hostname:     current.Hostname,
+				Recorded:     recorded,
+				Observed:     macs,
+			})
+		}
+
+		tags := observedTags(current, macs[len(macs)-1], conflict, now)
+		if slices.Equal(tags, current.Tags) {
+			continue
+		}
+		current.Tags = tags
+		if err := in.Store.SaveAllocation(current); err != nil {
+			return result, fmt.Errorf("failed to save allocation %s: %w", current.ID, err)
+		}
+		result.Updated++
+	}
+	return result, nil
+}
+
+// observedTags returns alloc's tags after mac was observed for it at now
+func observedTags(alloc *ipam.IPAllocation, mac string, conflict bool, now time.Time) []string {
+	seen := *alloc
+	seen.Tags = health.Seen(alloc, now)
+
+	tags := make([]string, 0, len(seen.Tags)+2)
+	for _, tag := range seen.Tags {
+		if tag == ConflictTag || strings.HasPrefix(tag, ObservedMACTagPrefix) {
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	tags = append(tags, ObservedMACTagPrefix+mac)
+	if conflict {
+		tags = append(tags, ConflictTag)
+	}
+	return tags
+}
+
+// allocationIndex finds the network and the active allocation of an
+// address
+type allocationIndex struct {
+	prefixes    []netip.Prefix
+	ranges      [][2]netip.Addr
+	allocations map[netip.Addr]*ipam.IPAllocation // Single addresses
+}
+
+// unallocated reports whether addr is inside a network but neither a
+// single-address allocation nor a range holds it
+func (idx *allocationIndex) unallocated(addr netip.Addr) bool {
+	for _, r := range idx.ranges {
+		if !addr.Less(r[0]) && !r[1].Less(addr) {
+			return false
+		}
+	}
+	for _, prefix := range idx.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// index reads the allowed networks and their active single-address
+// allocations at now
+func (in *Ingester) index(now time.Time) (*allocationIndex, error) {
+	networks, err := in.Store.ListNetworks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+	idx := &allocationIndex{allocations: make(map[netip.Addr]*ipam.IPAllocation)}
+	for _, network := range networks {
+		if in.Allow != nil && !in.Allow(network) {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(network.CIDR)
+		if err != nil {
+			continue
+		}
+		idx.prefixes = append(idx.prefixes, prefix.Masked())
+
+		allocations, err := in.Store.ListAllocations(network.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list allocations: %w", err)
+		}
+		for _, alloc := range allocations {
+			if store.AllocationStatus(alloc, now) != store.StatusActive {
+				continue
+			}
+			first, err := netip.ParseAddr(alloc.IP)
+			if err != nil {
+				continue
+			}
+			if alloc.EndIP == "" {
+				idx.allocations[first.Unmap()] = alloc
+			} else if last, err := netip.ParseAddr(alloc.EndIP); err == nil {
+				idx.ranges = append(idx.ranges, [2]netip.Addr{first.Unmap(), last.Unmap()})
+			}
+		}
+	}
+	return idx, nil
+}
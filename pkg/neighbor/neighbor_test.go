@@ -0,0 +1,138 @@
+package neighbor
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/health"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func entry(ip, mac string) Entry {
+	return Entry{IP: netip.MustParseAddr(ip), MAC: mac}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+		want   []Entry
+	}{
+		{
+			name:   "arp -an",
+			format: FormatText,
+			input: "? (192.168.1.1) at 00:11:22:33:44:55 [ether] on eth0\n" +
+				"? (192.168.1.9) at <incomplete> on eth0\n",
+			want: []Entry{entry("192.168.1.1", "00:11:22:33:44:55")},
+		},
+		{
+			name:   "ip neigh",
+			format: "",
+			input: "10.1.0.254 dev eth0 lladdr 00:11:22:33:44:55 REACHABLE\n" +
+				"fe80::1 dev eth0 lladdr 00:11:22:33:44:66 router STALE\n" +
+				"10.1.0.7 dev eth0 FAILED\n",
+			want: []Entry{entry("10.1.0.254", "00:11:22:33:44:55"), entry("fe80::1", "00:11:22:33:44:66")},
+		},
+		{
+			name:   "cisco show ip arp",
+			format: FormatText,
+			input: "Protocol  Address          Age (min)  Hardware Addr   Type   Interface\n" +
+				"Internet  10.2.0.1                -   0011.2233.44aa  ARPA   Vlan20\n" +
+				"Internet  10.2.0.255              -   ffff.ffff.ffff  ARPA   Vlan20\n",
+			want: []Entry{entry("10.2.0.1", "00:11:22:33:44:aa")},
+		},
+		{
+			name:   "windows arp -a",
+			format: FormatText,
+			input:  "  10.3.0.5           00-11-22-33-44-bb     dynamic\n  224.0.0.22         01-00-5e-00-00-16     static\n",
+			want:   []Entry{entry("10.3.0.5", "00:11:22:33:44:bb")},
+		},
+		{
+			name:   "json",
+			format: FormatJSON,
+			input:  `[{"ip": "2001:db8::5", "mac": "00:11:22:33:44:CC"}]`,
+			want:   []Entry{entry("2001:db8::5", "00:11:22:33:44:cc")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.input), tt.format)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := Parse(strings.NewReader("[]"), "csv")
+	assert.Error(t, err)
+	_, err = Parse(strings.NewReader(`[{"ip": "10.0.0.1", "mac": "nope"}]`), FormatJSON)
+	assert.Error(t, err)
+}
+
+func TestIngest(t *testing.T) {
+	s, err := store.NewPebbleStore(t.TempDir())
+	require.NoError(t, err)
+	defer s.Close()
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	seen := health.LastSeenTagPrefix + now.Format(time.RFC3339)
+
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "lan", CIDR: "10.0.0.0/24"}))
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "dmz", CIDR: "10.9.0.0/24"}))
+	for _, alloc := range []*ipam.IPAllocation{
+		{ID: "web", NetworkID: "lan", IP: "10.0.0.10", Tags: []string{"prod", "mac=00-11-22-33-44-55", health.StaleTag}},
+		{ID: "db", NetworkID: "lan", IP: "10.0.0.11", Tags: []string{"mac=00:11:22:33:44:56", ConflictTag}},
+		{ID: "vip", NetworkID: "lan", IP: "10.0.0.12"},
+		{ID: "pool", NetworkID: "lan", IP: "10.0.0.100", EndIP: "10.0.0.199"},
+		{ID: "fw", NetworkID: "dmz", IP: "10.9.0.1"},
+	} {
+		require.NoError(t, s.SaveAllocation(alloc))
+	}
+
+	in := &Ingester{Store: s, Allow: func(network *ipam.Network) bool { return network.ID != "dmz" }}
+	result, err := in.Ingest([]Entry{
+		entry("10.0.0.10", "00:11:22:33:44:55"),
+		entry("10.0.0.11", "00:11:22:33:44:99"),
+		entry("10.0.0.12", "00:11:22:33:44:01"),
+		entry("10.0.0.12", "00:11:22:33:44:02"),
+		entry("10.0.0.150", "00:11:22:33:44:03"),
+		entry("10.0.0.77", "00:11:22:33:44:04"),
+		entry("10.9.0.1", "00:11:22:33:44:05"),
+		entry("172.16.0.1", "00:11:22:33:44:06"),
+	}, now)
+	require.NoError(t, err)
+	assert.Equal(t, 7, result.Entries)
+	assert.Equal(t, 3, result.Matched)
+	assert.Equal(t, 3, result.Updated)
+	// 10.0.0.150 is part of a range, which is left alone
+	assert.Equal(t, []Entry{entry("10.0.0.77", "00:11:22:33:44:04")}, result.Unknown)
+	assert.Equal(t, []Conflict{
+		{IP: "10.0.0.11", AllocationID: "db", NetworkID: "lan", Recorded: "00:11:22:33:44:56", Observed: []string{"00:11:22:33:44:99"}},
+		{IP: "10.0.0.12", AllocationID: "vip", NetworkID: "lan", Observed: []string{"00:11:22:33:44:01", "00:11:22:33:44:02"}},
+	}, result.Conflicts)
+
+	web, err := s.GetAllocation("web")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"prod", "mac=00-11-22-33-44-55", seen, "observed-mac=00:11:22:33:44:55"}, web.Tags)
+	db, err := s.GetAllocation("db")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mac=00:11:22:33:44:56", seen, "observed-mac=00:11:22:33:44:99", ConflictTag}, db.Tags)
+
+	// Networks not allowed are left alone
+	fw, err := s.GetAllocation("fw")
+	require.NoError(t, err)
+	assert.Empty(t, fw.Tags)
+
+	// The recorded MAC showing up again clears the conflict
+	result, err = in.Ingest([]Entry{entry("10.0.0.11", "00:11:22:33:44:56")}, now)
+	require.NoError(t, err)
+	assert.Empty(t, result.Conflicts)
+	db, err = s.GetAllocation("db")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mac=00:11:22:33:44:56", seen, "observed-mac=00:11:22:33:44:56"}, db.Tags)
+}
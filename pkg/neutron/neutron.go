@@ -0,0 +1,297 @@
+// Package neutron imports the subnets and ports of an OpenStack cloud, so
+// that private-cloud address space shows up, and is conflict-checked,
+// alongside the rest of the address plan.
+//
+// The client authenticates against Keystone v3 with a password or an
+// application credential and reads Neutron's v2.0 API. Sync then mirrors
+// each subnet as a network tagged neutron-subnet=<id> and each fixed IP of
+// a port as an allocation tagged neutron-port=<id>.
+package neutron
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Credentials authenticate against Keystone, with either an application
+// credential or a user's password scoped to a project
+type Credentials struct {
+	ApplicationCredentialID     string
+	ApplicationCredentialSecret string
+
+	Username          string
+	UserDomainName    string
+	Password          string
+	ProjectName       string
+	ProjectDomainName string
+}
+
+// CredentialsFromEnv reads the OS_* variables of an OpenStack RC file
+func CredentialsFromEnv(getenv func(string) string) Credentials {
+	c := Credentials{
+		ApplicationCredentialID:     getenv("OS_APPLICATION_CREDENTIAL_ID"),
+		ApplicationCredentialSecret: getenv("OS_APPLICATION_CREDENTIAL_SECRET"),
+		Username:                    getenv("OS_USERNAME"),
+		UserDomainName:              getenv("OS_USER_DOMAIN_NAME"),
+		Password:                    getenv("OS_PASSWORD"),
+		ProjectName:                 getenv("OS_PROJECT_NAME"),
+		ProjectDomainName:           getenv("OS_PROJECT_DOMAIN_NAME"),
+	}
+	if c.ProjectName == "" {
+		c.ProjectName = getenv("OS_TENANT_NAME")
+	}
+	if c.UserDomainName == "" {
+		c.UserDomainName = "Default"
+	}
+	if c.ProjectDomainName == "" {
+		c.ProjectDomainName = "Default"
+	}
+	return c
+}
+
+// Subnet is a Neutron subnet
+type Subnet struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	NetworkID string `json:"network_id"`
+	ProjectID string `json:"project_id"`
+	CIDR      string `json:"cidr"`
+	GatewayIP string `json:"gateway_ip"`
+}
+
+// Port is a Neutron port and the addresses it holds
+type Port struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	NetworkID   string    `json:"network_id"`
+	ProjectID   string    `json:"project_id"`
+	MACAddress  string    `json:"mac_address"`
+	DeviceOwner string    `json:"device_owner"`
+	DNSName     string    `json:"dns_name"`
+	FixedIPs    []FixedIP `json:"fixed_ips"`
+}
+
+// FixedIP is an address of a port in one of its subnets
+type FixedIP struct {
+	SubnetID  string `json:"subnet_id"`
+	IPAddress string `json:"ip_address"`
+}
+
+// Client reads subnets and ports from Neutron
+type Client struct {
+	// AuthURL is the Keystone endpoint, e.g. https://keystone:5000/v3
+	AuthURL     string
+	Credentials Credentials
+
+	// Region and Interface select the Neutron endpoint of the service
+	// catalog; any region and the public interface when empty
+	Region    string
+	Interface string
+
+	HTTPClient *http.Client
+
+	token    string
+	endpoint string
+}
+
+// NewClient returns a client for the Keystone at authURL with a request
+// timeout
+func NewClient(authURL string, credentials Credentials) *Client {
+	return &Client{
+		AuthURL:     authURL,
+		Credentials: credentials,
+		HTTPClient:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Subnets returns every subnet the credentials may see
+func (c *Client) Subnets(ctx context.Context) ([]Subnet, error) {
+	var subnets []Subnet
+	err := c.list(ctx, "subnets", func(page json.RawMessage) error {
+		var items []Subnet
+		if err := json.Unmarshal(page, &items); err != nil {
+			return err
+		}
+		subnets = append(subnets, items...)
+		return nil
+	})
+	return subnets, err
+}
+
+// Ports returns every port the credentials may see
+func (c *Client) Ports(ctx context.Context) ([]Port, error) {
+	var ports []Port
+	err := c.list(ctx, "ports", func(page json.RawMessage) error {
+		var items []Port
+		if err := json.Unmarshal(page, &items); err != nil {
+			return err
+		}
+		ports = append(ports, items...)
+		return nil
+	})
+	return ports, err
+}
+
+// list reads every page of a Neutron collection, following its next links
+func (c *Client) list(ctx context.Context, collection string, add func(json.RawMessage) error) error {
+	if err := c.authenticate(ctx); err != nil {
+		return err
+	}
+
+	next := c.endpoint + "/v2.0/" + collection
+	for next != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, next, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Auth-Token", c.token)
+		req.Header.Set("Accept", "application/json")
+
+		var page map[string]json.RawMessage
+		if _, err := c.send(req, &page); err != nil {
+			return fmt.Errorf("failed to list %s: %w", collection, err)
+		}
+		if err := add(page[collection]); err != nil {
+			return fmt.Errorf("invalid %s response: %w", collection, err)
+		}
+
+		var links []struct {
+			Rel  string `json:"rel"`
+			Href string `json:"href"`
+		}
+		next = ""
+		if raw, ok := page[collection+"_links"]; ok {
+			if err := json.Unmarshal(raw, &links); err != nil {
+				return fmt.Errorf("invalid %s response: %w", collection, err)
+			}
+		}
+		for _, link := range links {
+			if link.Rel == "next" {
+				next = link.Href
+			}
+		}
+	}
+	return nil
+}
+
+// authenticate gets a token and the Neutron endpoint from Keystone once
+func (c *Client) authenticate(ctx context.Context) error {
+	if c.token != "" {
+		return nil
+	}
+
+	body, err := json.Marshal(c.authRequest())
+	if err != nil {
+		return err
+	}
+	target := strings.TrimSuffix(c.AuthURL, "/")
+	if !strings.HasSuffix(target, "/v3") {
+		target += "/v3"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target+"/auth/tokens", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var token struct {
+		Token struct {
+			Catalog []struct {
+				Type      string `json:"type"`
+				Endpoints []struct {
+					Interface string `json:"interface"`
+					Region    string `json:"region"`
+					RegionID  string `json:"region_id"`
+					URL       string `json:"url"`
+				} `json:"endpoints"`
+			} `json:"catalog"`
+		} `json:"token"`
+	}
+	resp, err := c.send(req, &token)
+	if err != nil {
+		return fmt.Errorf("keystone authentication failed: %w", err)
+	}
+
+	iface := c.Interface
+	if iface == "" {
+		iface = "public"
+	}
+	for _, service := range token.Token.Catalog {
+		if service.Type != "network" {
+			continue
+		}
+		for _, e := range service.Endpoints {
+			if e.Interface == iface && (c.Region == "" || c.Region == e.Region || c.Region == e.RegionID) {
+				c.endpoint = strings.TrimSuffix(strings.TrimSuffix(e.URL, "/"), "/v2.0")
+				break
+			}
+		}
+	}
+	if c.endpoint == "" {
+		return fmt.Errorf("no %s network endpoint in the service catalog", iface)
+	}
+	c.token = resp.Header.Get("X-Subject-Token")
+	if c.token == "" {
+		return errors.New("keystone returned no token")
+	}
+	return nil
+}
+
+// authRequest returns the body of a Keystone v3 token request
+func (c *Client) authRequest() map[string]any {
+	cred := c.Credentials
+	if cred.ApplicationCredentialID != "" {
+		return map[string]any{"auth": map[string]any{
+			"identity": map[string]any{
+				"methods": []string{"application_credential"},
+				"application_credential": map[string]any{
+					"id":     cred.ApplicationCredentialID,
+					"secret": cred.ApplicationCredentialSecret,
+				},
+			},
+		}}
+	}
+	return map[string]any{"auth": map[string]any{
+		"identity": map[string]any{
+			"methods": []string{"password"},
+			"password": map[string]any{"user": map[string]any{
+				"name":     cred.Username,
+				"domain":   map[string]any{"name": cred.UserDomainName},
+				"password": cred.Password,
+			}},
+		},
+		"scope": map[string]any{"project": map[string]any{
+			"name":   cred.ProjectName,
+			"domain": map[string]any{"name": cred.ProjectDomainName},
+		}},
+	}}
+}
+
+// send sends req and decodes a successful JSON response into v
+func (c *Client) send(req *http.Request, v any) (*http.Response, error) {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("%s %s returned %s", req.Method, req.URL.Path, resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<20)).Decode(v); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,211 @@
+package neutron
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/identity/v3/auth/tokens":
+			var body struct {
+				Auth struct {
+					Identity struct {
+						Methods               []string `json:"methods"`
+						ApplicationCredential struct {
+							ID     string `json:"id"`
+							Secret string `json:"secret"`
+						} `json:"application_credential"`
+					} `json:"identity"`
+				} `json:"auth"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			if body.Auth.Identity.ApplicationCredential.Secret != "s3cret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("X-Subject-Token", "tok")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]any{"token": map[string]any{"catalog": []any{
+				map[string]any{"type": "compute", "endpoints": []any{
+					map[string]any{"interface": "public", "region": "RegionOne", "url": server.URL + "/compute"},
+				}},
+				map[string]any{"type": "network", "endpoints": []any{
+					map[string]any{"interface": "public", "region": "RegionTwo", "url": server.URL + "/wrong"},
+					map[string]any{"interface": "internal", "region": "RegionOne", "url": server.URL + "/wrong"},
+					map[string]any{"interface": "public", "region": "RegionOne", "url": server.URL + "/network/v2.0/"},
+				}},
+			}}})
+		case "/network/v2.0/subnets":
+			assert.Equal(t, "tok", r.Header.Get("X-Auth-Token"))
+			if r.URL.Query().Get("marker") == "" {
+				json.NewEncoder(w).Encode(map[string]any{
+					"subnets":       []Subnet{{ID: "a", CIDR: "10.0.0.0/24"}},
+					"subnets_links": []any{map[string]string{"rel": "next", "href": server.URL + "/network/v2.0/subnets?marker=a"}},
+				})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{
+				"subnets":       []Subnet{{ID: "b", CIDR: "10.0.1.0/24"}},
+				"subnets_links": []any{map[string]string{"rel": "previous", "href": server.URL + "/network/v2.0/subnets?marker=b"}},
+			})
+		case "/network/v2.0/ports":
+			json.NewEncoder(w).Encode(map[string]any{"ports": []Port{{ID: "p", FixedIPs: []FixedIP{{SubnetID: "a", IPAddress: "10.0.0.5"}}}}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL+"/identity", Credentials{ApplicationCredentialID: "id", ApplicationCredentialSecret: "s3cret"})
+	c.Region = "RegionOne"
+	subnets, err := c.Subnets(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []Subnet{{ID: "a", CIDR: "10.0.0.0/24"}, {ID: "b", CIDR: "10.0.1.0/24"}}, subnets)
+	ports, err := c.Ports(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, ports, 1)
+
+	c = NewClient(server.URL+"/identity/v3", Credentials{ApplicationCredentialID: "id", ApplicationCredentialSecret: "wrong"})
+	_, err = c.Subnets(context.Background())
+	assert.ErrorContains(t, err, "401 Unauthorized")
+	c = NewClient(server.URL+"/identity", Credentials{ApplicationCredentialID: "id", ApplicationCredentialSecret: "s3cret"})
+	c.Interface = "admin"
+	_, err = c.Subnets(context.Background())
+	assert.ErrorContains(t, err, "no admin network endpoint")
+}
+
+func TestCredentialsFromEnv(t *testing.T) {
+	env := map[string]string{"OS_USERNAME": "admin", "OS_PASSWORD": "pw", "OS_TENANT_NAME": "ops"}
+	c := CredentialsFromEnv(func(k string) string { return env[k] })
+	assert.Equal(t, Credentials{Username: "admin", Password: "pw", ProjectName: "ops", UserDomainName: "Default", ProjectDomainName: "Default"}, c)
+}
+
+func TestSync(t *testing.T) {
+	s, err := store.NewPebbleStore(t.TempDir())
+	require.NoError(t, err)
+	defer s.Close()
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "cloud", CIDR: "10.0.0.0/16", Description: "Cloud"}))
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "legacy", CIDR: "10.0.9.0/24"}))
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "dc", CIDR: "172.16.5.0/24"}))
+	require.NoError(t, s.SaveAllocation(&ipam.IPAllocation{ID: "manual", NetworkID: "cloud", IP: "10.0.1.30", Status: "allocated"}))
+	added := 0
+	syncer := &Syncer{Store: s, AddNetwork: func(cidr, description string, tags []string) (*ipam.Network, error) {
+		added++
+		network := &ipam.Network{ID: cidr, CIDR: cidr, Description: description, Tags: tags}
+		return network, s.SaveNetwork(network)
+	}}
+
+	subnets := []Subnet{
+		{ID: "sn-web", Name: "web", NetworkID: "net-1", ProjectID: "acme", CIDR: "10.0.1.0/24", GatewayIP: "10.0.1.1"},
+		{ID: "sn-big", NetworkID: "net-2", CIDR: "10.0.8.0/22"},
+		{ID: "sn-dc", NetworkID: "net-3", CIDR: "172.16.5.0/24"},
+	}
+	ports := []Port{
+		{ID: "router", ProjectID: "acme", MACAddress: "FA:16:3E:00:00:01", DeviceOwner: "network:router_interface", FixedIPs: []FixedIP{{SubnetID: "sn-web", IPAddress: "10.0.1.1"}}},
+		{ID: "vm1", ProjectID: "acme", Name: "vm1-port", DNSName: "vm1", MACAddress: "fa:16:3e:00:00:02", DeviceOwner: "compute:nova", FixedIPs: []FixedIP{{SubnetID: "sn-web", IPAddress: "10.0.1.20"}}},
+		{ID: "vm2", FixedIPs: []FixedIP{{SubnetID: "sn-web", IPAddress: "10.0.1.30"}}},
+	}
+
+	// A dry run writes nothing
+	syncer.DryRun = true
+	result, err := syncer.Sync(subnets, ports, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.NetworksCreated)
+	assert.Equal(t, 2, result.AllocationsCreated)
+	assert.Equal(t, 0, added)
+
+	syncer.DryRun = false
+	result, err = syncer.Sync(subnets, ports, now)
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.Subnets)
+	assert.Equal(t, 1, result.NetworksCreated)
+	assert.Equal(t, 2, result.AllocationsCreated)
+	assert.Equal(t, []Conflict{
+		{SubnetID: "sn-big", Address: "10.0.8.0/22", Reason: "contains network legacy (10.0.9.0/24)"},
+		{SubnetID: "sn-dc", Address: "172.16.5.0/24", Reason: "already registered as network dc"},
+	}, result.Conflicts[:2])
+	// The supernet's allocations are checked too
+	require.Len(t, result.Conflicts, 3)
+	assert.Equal(t, "vm2", result.Conflicts[2].PortID)
+	assert.Contains(t, result.Conflicts[2].Reason, "held by allocation manual")
+
+	network, err := s.GetNetworkByCIDR("10.0.1.0/24")
+	require.NoError(t, err)
+	assert.Equal(t, "web", network.Description)
+	assert.Equal(t, []string{"tenant=acme", "neutron-subnet=sn-web", "neutron-network=net-1"}, network.Tags)
+	allocations, err := s.ListAllocations(network.ID)
+	require.NoError(t, err)
+	require.Len(t, allocations, 2)
+	byIP := map[string]*ipam.IPAllocation{}
+	for _, alloc := range allocations {
+		byIP[alloc.IP] = alloc
+	}
+	assert.Equal(t, "Neutron port (network:router_interface)", byIP["10.0.1.1"].Description)
+	assert.Equal(t, []string{"tenant=acme", "neutron-port=router", "mac=fa:16:3e:00:00:01", "gateway"}, byIP["10.0.1.1"].Tags)
+	assert.Equal(t, "vm1", byIP["10.0.1.20"].Hostname)
+
+	// Both allocations are audited, and the dry run left no entries
+	entries, err := s.ListAuditEntries(0)
+	require.NoError(t, err)
+	var audited []string
+	for _, entry := range entries {
+		audited = append(audited, entry.Action+" "+entry.Resource+" by "+entry.User)
+	}
+	assert.ElementsMatch(t, []string{
+		"ip_allocated " + byIP["10.0.1.1"].ID + " by neutron",
+		"ip_allocated " + byIP["10.0.1.20"].ID + " by neutron",
+	}, audited)
+
+	// Unchanged: nothing to do
+	result, err = syncer.Sync(subnets, ports, now)
+	require.NoError(t, err)
+	assert.Zero(t, result.NetworksCreated+result.NetworksUpdated+result.AllocationsCreated+result.AllocationsUpdated+result.AllocationsReleased)
+
+	// A renamed subnet, a moved address and a deleted port; tags added by
+	// hand stay
+	network.Tags = append(network.Tags, "owner=cloud-team")
+	require.NoError(t, s.SaveNetwork(network))
+	subnets[0].Name = "web-tier"
+	ports = []Port{ports[0], {ID: "vm1", FixedIPs: []FixedIP{{SubnetID: "sn-web", IPAddress: "10.0.1.21"}}}}
+	result, err = syncer.Sync(subnets, ports, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.NetworksUpdated)
+	assert.Equal(t, 1, result.AllocationsCreated)
+	assert.Equal(t, 1, result.AllocationsReleased)
+	network, err = s.GetNetworkByCIDR("10.0.1.0/24")
+	require.NoError(t, err)
+	assert.Equal(t, "web-tier", network.Description)
+	assert.Equal(t, []string{"tenant=acme", "owner=cloud-team", "neutron-subnet=sn-web", "neutron-network=net-1"}, network.Tags)
+
+	// A frozen network's allocations are left alone
+	frozen := *network
+	frozen.Tags = store.FreezeTags(network.Tags, "change window", nil)
+	require.NoError(t, s.SaveNetwork(&frozen))
+	result, err = syncer.Sync(subnets[1:], nil, now)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.1.0/24"}, result.Frozen)
+	assert.Zero(t, result.AllocationsReleased)
+	require.NoError(t, s.SaveNetwork(network))
+
+	// A deleted subnet is reported and its ports released
+	result, err = syncer.Sync(subnets[1:], nil, now)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.1.0/24"}, result.Stale)
+	assert.Equal(t, 2, result.AllocationsReleased)
+	_, err = s.GetNetworkByCIDR("10.0.1.0/24")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,433 @@
+package neutron
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/neighbor"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+const (
+	// SubnetTagPrefix marks the network mirroring a Neutron subnet
+	SubnetTagPrefix = "neutron-subnet="
+
+	// NetworkTagPrefix is the Neutron network a subnet belongs to
+	NetworkTagPrefix = "neutron-network="
+
+	// PortTagPrefix marks the allocation mirroring a port's fixed IP
+	PortTagPrefix = "neutron-port="
+
+	// tenantTagPrefix is the label reports group tenants by
+	tenantTagPrefix = "tenant="
+
+	// auditUser is the user recorded for the audit entries of Sync's
+	// changes
+	auditUser = "neutron"
+)
+
+// Conflict is a subnet or port address Sync could not record because
+// other space or allocations already hold it
+type Conflict struct {
+	SubnetID string `json:"subnet_id"`
+	PortID   string `json:"port_id,omitempty"`
+	Address  string `json:"address"`
+	Reason   string `json:"reason"`
+}
+
+// Result is the outcome of a sync
+type Result struct {
+	Subnets int `json:"subnets"`
+
+	NetworksCreated     int `json:"networks_created"`
+	NetworksUpdated     int `json:"networks_updated"`
+	AllocationsCreated  int `json:"allocations_created"`
+	AllocationsUpdated  int `json:"allocations_updated"`
+	AllocationsReleased int `json:"allocations_released"`
+
+	Conflicts []Conflict `json:"conflicts"`
+
+	// Stale lists the CIDRs of networks mirroring subnets that no longer
+	// exist. They are kept, with their allocations released, for review.
+	Stale []string `json:"stale"`
+
+	// Frozen lists the CIDRs of networks under a maintenance freeze, whose
+	// allocations were left as they are
+	Frozen []string `json:"frozen"`
+}
+
+// Syncer mirrors subnets and ports into Store
+type Syncer struct {
+	Store ipam.Store
+
+	// AddNetwork registers a network for a new subnet
+	AddNetwork func(cidr, description string, tags []string) (*ipam.Network, error)
+
+	// DryRun counts the changes without making them
+	DryRun bool
+}
+
+// synced is a subnet and the network mirroring it
+type synced struct {
+	subnet      Subnet
+	network     *ipam.Network
+	allocations []*ipam.IPAllocation
+
+	// held are the allocations of the networks the subnet sits in
+	held []*ipam.IPAllocation
+}
+
+// Sync brings the mirrored networks and allocations in line with subnets
+// and ports. A subnet gets a network unless its CIDR is registered already
+// or it contains a registered network, which is reported as a conflict;
+// it may sit inside a registered network, such as the cloud's supernet.
+// Each fixed IP of a port gets an allocation, unless another allocation
+// holds the address, and allocations of ports that are gone or moved are
+// released. Networks only gain and lose neutron- tags, mac= and gateway
+// tags, so tags added by hand stay. The allocations of networks under a
+// maintenance freeze are left alone until it ends. Every change other than
+// adding a network, which AddNetwork audits, is audited.
+func (s *Syncer) Sync(subnets []Subnet, ports []Port, now time.Time) (*Result, error) {
+	networks, err := s.Store.ListNetworks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+	bySubnet := make(map[string]*ipam.Network)
+	for _, network := range networks {
+		if id, ok := tagValue(network.Tags, SubnetTagPrefix); ok {
+			bySubnet[id] = network
+		}
+	}
+
+	sort.Slice(subnets, func(i, j int) bool { return subnets[i].CIDR < subnets[j].CIDR })
+	result := &Result{Subnets: len(subnets), Conflicts: []Conflict{}, Stale: []string{}, Frozen: []string{}}
+	mirrored := make(map[string]*synced)
+	for _, subnet := range subnets {
+		network, err := s.syncSubnet(subnet, bySubnet[subnet.ID], networks, result)
+		if err != nil {
+			return result, err
+		}
+		if network == nil {
+			continue
+		}
+		entry := &synced{subnet: subnet, network: network}
+		if network.ID != "" {
+			if entry.allocations, err = s.Store.ListAllocations(network.ID); err != nil {
+				return result, fmt.Errorf("failed to list allocations: %w", err)
+			}
+		}
+		if entry.held, err = s.enclosingAllocations(network, networks); err != nil {
+			return result, err
+		}
+		mirrored[subnet.ID] = entry
+	}
+
+	// Subnets that are gone keep their network, but their ports are gone
+	// too
+	for id, network := range bySubnet {
+		if _, ok := mirrored[id]; ok || slices.ContainsFunc(subnets, func(s Subnet) bool { return s.ID == id }) {
+			continue
+		}
+		result.Stale = append(result.Stale, network.CIDR)
+		allocations, err := s.Store.ListAllocations(network.ID)
+		if err != nil {
+			return result, fmt.Errorf("failed to list allocations: %w", err)
+		}
+		mirrored[id] = &synced{network: network, allocations: allocations}
+	}
+	sort.Strings(result.Stale)
+
+	wanted := make(map[string][]portAddress)
+	for _, port := range ports {
+		for _, fixed := range port.FixedIPs {
+			if entry, ok := mirrored[fixed.SubnetID]; ok && entry.subnet.ID != "" {
+				wanted[fixed.SubnetID] = append(wanted[fixed.SubnetID], portAddress{port: port, ip: fixed.IPAddress})
+			}
+		}
+	}
+
+	ids := make([]string, 0, len(mirrored))
+	for id := range mirrored {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return mirrored[ids[i]].network.CIDR < mirrored[ids[j]].network.CIDR })
+	for _, id := range ids {
+		if err := s.syncPorts(mirrored[id], wanted[id], now, result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// syncSubnet creates or updates the network of subnet and returns it, or
+// returns nil after recording a conflict. In a dry run, a network that
+// would be created has no ID.
+func (s *Syncer) syncSubnet(subnet Subnet, current *ipam.Network, networks []*ipam.Network, result *Result) (*ipam.Network, error) {
+	prefix, err := netip.ParsePrefix(subnet.CIDR)
+	if err != nil {
+		result.Conflicts = append(result.Conflicts, Conflict{SubnetID: subnet.ID, Address: subnet.CIDR, Reason: "invalid CIDR"})
+		return nil, nil
+	}
+	prefix = prefix.Masked()
+	cidr := prefix.String()
+
+	if current == nil {
+		for _, other := range networks {
+			otherPrefix, err := netip.ParsePrefix(other.CIDR)
+			if err != nil {
+				continue
+			}
+			otherPrefix = otherPrefix.Masked()
+			switch {
+			case otherPrefix == prefix:
+				result.Conflicts = append(result.Conflicts, Conflict{SubnetID: subnet.ID, Address: cidr, Reason: fmt.Sprintf("already registered as network %s", other.ID)})
+				return nil, nil
+			case otherPrefix.Bits() > prefix.Bits() && prefix.Contains(otherPrefix.Addr()):
+				result.Conflicts = append(result.Conflicts, Conflict{SubnetID: subnet.ID, Address: cidr, Reason: fmt.Sprintf("contains network %s (%s)", other.ID, other.CIDR)})
+				return nil, nil
+			}
+		}
+
+		var tags []string
+		if subnet.ProjectID != "" {
+			tags = append(tags, tenantTagPrefix+subnet.ProjectID)
+		}
+		tags = subnetTags(tags, subnet)
+		result.NetworksCreated++
+		if s.DryRun {
+			return &ipam.Network{CIDR: cidr, Tags: tags}, nil
+		}
+		network, err := s.AddNetwork(cidr, subnet.Name, tags)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add network %s: %w", cidr, err)
+		}
+		return network, nil
+	}
+
+	updated := *current
+	updated.Tags = subnetTags(current.Tags, subnet)
+	if subnet.Name != "" {
+		updated.Description = subnet.Name
+	}
+	if updated.Description == current.Description && slices.Equal(updated.Tags, current.Tags) {
+		return current, nil
+	}
+	result.NetworksUpdated++
+	if s.DryRun {
+		return current, nil
+	}
+	updated.UpdatedAt = time.Now()
+	if err := s.Store.SaveNetwork(&updated); err != nil {
+		return nil, fmt.Errorf("failed to save network %s: %w", current.CIDR, err)
+	}
+	if err := store.RecordAudit(s.Store, auditUser, "network_updated", updated.ID, fmt.Sprintf("Updated network %s from Neutron subnet %s", updated.CIDR, subnet.ID), updated.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// enclosingAllocations returns the allocations of the networks containing
+// network, such as the cloud's supernet, which the subnet's ports must not
+// collide with either
+func (s *Syncer) enclosingAllocations(network *ipam.Network, networks []*ipam.Network) ([]*ipam.IPAllocation, error) {
+	prefix, err := netip.ParsePrefix(network.CIDR)
+	if err != nil {
+		return nil, err
+	}
+	var held []*ipam.IPAllocation
+	for _, other := range networks {
+		otherPrefix, err := netip.ParsePrefix(other.CIDR)
+		if err != nil || other.ID == network.ID || otherPrefix.Bits() >= prefix.Bits() || !otherPrefix.Masked().Contains(prefix.Addr()) {
+			continue
+		}
+		allocations, err := s.Store.ListAllocations(other.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list allocations: %w", err)
+		}
+		held = append(held, allocations...)
+	}
+	return held, nil
+}
+
+// subnetTags returns tags with the neutron- tags of subnet
+func subnetTags(tags []string, subnet Subnet) []string {
+	out := withoutPrefixes(tags, SubnetTagPrefix, NetworkTagPrefix)
+	out = append(out, SubnetTagPrefix+subnet.ID)
+	if subnet.NetworkID != "" {
+		out = append(out, NetworkTagPrefix+subnet.NetworkID)
+	}
+	return out
+}
+
+// portAddress is a fixed IP of a port
+type portAddress struct {
+	port Port
+	ip   string
+}
+
+// syncPorts brings the allocations of a mirrored network in line with the
+// fixed IPs wanted in it, unless the network is frozen
+func (s *Syncer) syncPorts(entry *synced, wanted []portAddress, now time.Time, result *Result) error {
+	network := entry.network
+	if store.CheckNotFrozen(network, now) != nil {
+		result.Frozen = append(result.Frozen, network.CIDR)
+		return nil
+	}
+	key := func(portID, ip string) string { return portID + " " + ip }
+
+	wantedKeys := make(map[string]bool)
+	for _, w := range wanted {
+		if addr, err := netip.ParseAddr(w.ip); err == nil {
+			wantedKeys[key(w.port.ID, addr.Unmap().String())] = true
+		}
+	}
+
+	// Release first, so that addresses moving between ports are free
+	existing := make(map[string]*ipam.IPAllocation)
+	for _, alloc := range entry.allocations {
+		portID, ok := tagValue(alloc.Tags, PortTagPrefix)
+		if !ok || store.AllocationStatus(alloc, now) != store.StatusActive {
+			continue
+		}
+		k := key(portID, alloc.IP)
+		if wantedKeys[k] {
+			existing[k] = alloc
+			continue
+		}
+		result.AllocationsReleased++
+		if s.DryRun {
+			continue
+		}
+		released := *alloc
+		released.Status = "released"
+		released.ReleasedAt = &now
+		if err := s.Store.SaveAllocation(&released); err != nil {
+			return fmt.Errorf("failed to release allocation %s: %w", alloc.ID, err)
+		}
+		if err := store.RecordAudit(s.Store, auditUser, "ip_released", alloc.ID, fmt.Sprintf("Released %s of Neutron port %s", alloc.IP, portID), now); err != nil {
+			return err
+		}
+		*alloc = released
+	}
+
+	sort.Slice(wanted, func(i, j int) bool { return wanted[i].port.ID < wanted[j].port.ID })
+	for _, w := range wanted {
+		conflict := Conflict{SubnetID: entry.subnet.ID, PortID: w.port.ID, Address: w.ip}
+		addr, err := netip.ParseAddr(w.ip)
+		if err != nil {
+			conflict.Reason = "invalid address"
+			result.Conflicts = append(result.Conflicts, conflict)
+			continue
+		}
+		ip := addr.Unmap().String()
+
+		if current, ok := existing[key(w.port.ID, ip)]; ok {
+			updated := *current
+			w.apply(&updated, entry.subnet)
+			if updated.Hostname == current.Hostname && updated.Description == current.Description && slices.Equal(updated.Tags, current.Tags) {
+				continue
+			}
+			result.AllocationsUpdated++
+			if s.DryRun {
+				continue
+			}
+			if err := s.Store.SaveAllocation(&updated); err != nil {
+				return fmt.Errorf("failed to save allocation %s: %w", current.ID, err)
+			}
+			if err := store.RecordAudit(s.Store, auditUser, "allocation_updated", current.ID, fmt.Sprintf("Updated allocation %s from Neutron port %s", ip, w.port.ID), now); err != nil {
+				return err
+			}
+			continue
+		}
+
+		ip, err = store.CheckRequestedIP(network, append(entry.held, entry.allocations...), ip, now)
+		if err != nil {
+			if !errors.Is(err, ipam.ErrIPNotAvailable) && !errors.Is(err, store.ErrIPOutOfRange) {
+				return err
+			}
+			conflict.Reason = err.Error()
+			result.Conflicts = append(result.Conflicts, conflict)
+			continue
+		}
+		id, err := store.NewAllocationID()
+		if err != nil {
+			return err
+		}
+		alloc := &ipam.IPAllocation{
+			ID:          id,
+			NetworkID:   network.ID,
+			IP:          ip,
+			Status:      "allocated",
+			AllocatedAt: now,
+		}
+		if w.port.ProjectID != "" {
+			alloc.Tags = []string{tenantTagPrefix + w.port.ProjectID}
+		}
+		w.apply(alloc, entry.subnet)
+		result.AllocationsCreated++
+		entry.allocations = append(entry.allocations, alloc)
+		if s.DryRun {
+			continue
+		}
+		if err := s.Store.SaveAllocation(alloc); err != nil {
+			return fmt.Errorf("failed to save allocation for %s: %w", ip, err)
+		}
+		if err := store.RecordAudit(s.Store, auditUser, "ip_allocated", alloc.ID, fmt.Sprintf("Allocated %s to Neutron port %s", ip, w.port.ID), now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply sets the hostname, description and neutron tags of alloc from the
+// port
+func (w portAddress) apply(alloc *ipam.IPAllocation, subnet Subnet) {
+	if hostname := strings.TrimSuffix(w.port.DNSName, "."); hostname != "" {
+		alloc.Hostname = hostname
+	} else if w.port.Name != "" {
+		alloc.Hostname = w.port.Name
+	}
+	alloc.Description = "Neutron port"
+	if w.port.DeviceOwner != "" {
+		alloc.Description += " (" + w.port.DeviceOwner + ")"
+	}
+
+	tags := withoutPrefixes(alloc.Tags, PortTagPrefix, neighbor.MACTagPrefix)
+	tags = slices.DeleteFunc(tags, func(tag string) bool { return tag == store.GatewayTag })
+	tags = append(tags, PortTagPrefix+w.port.ID)
+	if mac, err := neighbor.NormalizeMAC(w.port.MACAddress); err == nil {
+		tags = append(tags, neighbor.MACTagPrefix+mac)
+	}
+	if w.ip == subnet.GatewayIP {
+		tags = append(tags, store.GatewayTag)
+	}
+	alloc.Tags = tags
+}
+
+// tagValue returns the value of the first tag with prefix
+func tagValue(tags []string, prefix string) (string, bool) {
+	for _, tag := range tags {
+		if value, ok := strings.CutPrefix(tag, prefix); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// withoutPrefixes returns a copy of tags without those having any of
+// prefixes
+func withoutPrefixes(tags []string, prefixes ...string) []string {
+	out := make([]string, 0, len(tags)+3)
+	for _, tag := range tags {
+		if !slices.ContainsFunc(prefixes, func(p string) bool { return strings.HasPrefix(tag, p) }) {
+			out = append(out, tag)
+		}
+	}
+	return out
+}
@@ -0,0 +1,155 @@
+package plan
+
+import (
+	"fmt"
+	"math/bits"
+	"net/netip"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// DefaultHostsPerVLAN sizes VLANs to a /24 when no size is given
+const DefaultHostsPerVLAN = 254
+
+// Layout describes a hierarchical address plan to generate: a supernet
+// split into per-site blocks, each split into per-VLAN subnets
+type Layout struct {
+	Supernet     string
+	Sites        int
+	HostsPerSite int
+	// HostsPerVLAN caps the hosts in one VLAN; DefaultHostsPerVLAN if zero
+	HostsPerVLAN int
+	// SitePrefix and VLANPrefix override the derived prefix lengths
+	SitePrefix int
+	VLANPrefix int
+}
+
+// Generate proposes a plan for layout. Site blocks are rounded to an octet
+// (IPv4) or nibble (IPv6) boundary when the supernet has room, and skip
+// anything overlapping the existing networks.
+func Generate(layout Layout, existing []*ipam.Network) (*Plan, error) {
+	supernet, err := netip.ParsePrefix(layout.Supernet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid supernet %q", layout.Supernet)
+	}
+	supernet = supernet.Masked()
+	if layout.Sites < 1 {
+		return nil, fmt.Errorf("sites must be at least 1")
+	}
+	if layout.HostsPerSite < 1 {
+		return nil, fmt.Errorf("hosts per site must be at least 1")
+	}
+	hostsPerVLAN := layout.HostsPerVLAN
+	if hostsPerVLAN == 0 {
+		hostsPerVLAN = DefaultHostsPerVLAN
+	}
+	if hostsPerVLAN < 1 {
+		return nil, fmt.Errorf("hosts per VLAN must be at least 1")
+	}
+
+	addrBits := supernet.Addr().BitLen()
+	vlanBits := layout.VLANPrefix
+	if vlanBits == 0 {
+		if supernet.Addr().Is6() {
+			vlanBits = 64
+		} else {
+			vlanBits = ipv4PrefixFor(hostsPerVLAN)
+		}
+	}
+	if vlanBits <= supernet.Bits() || vlanBits > addrBits {
+		return nil, fmt.Errorf("VLAN prefix /%d does not fit in %s", vlanBits, supernet)
+	}
+	if supernet.Addr().Is4() {
+		if usable := ipv4Usable(vlanBits); usable < hostsPerVLAN {
+			hostsPerVLAN = usable
+		}
+	}
+
+	vlansPerSite := (layout.HostsPerSite + hostsPerVLAN - 1) / hostsPerVLAN
+
+	siteBits := layout.SitePrefix
+	if siteBits == 0 {
+		// Smallest block holding the VLANs, then widened to a readable boundary
+		siteBits = vlanBits - bits.Len(uint(vlansPerSite-1))
+		if siteBits == vlanBits {
+			siteBits--
+		}
+		step := 8
+		if supernet.Addr().Is6() {
+			step = 4
+		}
+		if rounded := siteBits / step * step; rounded > supernet.Bits() && fits(supernet, rounded, layout.Sites) {
+			siteBits = rounded
+		}
+	}
+	if siteBits <= supernet.Bits() || siteBits >= vlanBits {
+		return nil, fmt.Errorf("site prefix /%d must be longer than %s and shorter than the VLAN prefix /%d", siteBits, supernet, vlanBits)
+	}
+	if vlansPerSite > 1<<min(vlanBits-siteBits, 30) {
+		return nil, fmt.Errorf("a /%d site cannot hold %d /%d VLANs", siteBits, vlansPerSite, vlanBits)
+	}
+
+	parent := &ipam.Network{ID: "supernet", CIDR: supernet.String()}
+	taken := append([]*ipam.Network(nil), existing...)
+	now := time.Now()
+	width := len(fmt.Sprint(layout.Sites))
+
+	p := &Plan{}
+	for i := 1; i <= layout.Sites; i++ {
+		site, err := store.NextSubnet(parent, siteBits, taken, nil, now)
+		if err != nil {
+			return nil, fmt.Errorf("site %d of %d: %w", i, layout.Sites, err)
+		}
+		name := fmt.Sprintf("site-%0*d", width, i)
+		siteNetwork := &ipam.Network{ID: name, CIDR: site.String()}
+		taken = append(taken, siteNetwork)
+		p.Networks = append(p.Networks, NetworkSpec{
+			CIDR:        site.String(),
+			Description: fmt.Sprintf("Site %0*d", width, i),
+			Tags:        []string{"site=" + name},
+		})
+
+		for v := 1; v <= vlansPerSite; v++ {
+			vlan, err := store.NextSubnet(siteNetwork, vlanBits, taken, nil, now)
+			if err != nil {
+				return nil, fmt.Errorf("%s VLAN %d: %w", name, v, err)
+			}
+			taken = append(taken, &ipam.Network{ID: fmt.Sprintf("%s-vlan-%d", name, v), CIDR: vlan.String()})
+			p.Networks = append(p.Networks, NetworkSpec{
+				CIDR:        vlan.String(),
+				Description: fmt.Sprintf("Site %0*d VLAN %d", width, i, v),
+				Tags:        []string{"site=" + name, fmt.Sprintf("vlan=%d", v)},
+			})
+		}
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ipv4PrefixFor returns the longest IPv4 prefix with at least hosts usable
+// addresses
+func ipv4PrefixFor(hosts int) int {
+	for prefix := 30; prefix > 0; prefix-- {
+		if ipv4Usable(prefix) >= hosts {
+			return prefix
+		}
+	}
+	return 0
+}
+
+// ipv4Usable returns the host addresses of an IPv4 prefix, excluding the
+// network and broadcast addresses
+func ipv4Usable(prefix int) int {
+	return 1<<(32-prefix) - 2
+}
+
+// fits reports whether count blocks of length prefix fit in supernet
+func fits(supernet netip.Prefix, prefix, count int) bool {
+	shift := prefix - supernet.Bits()
+	return shift >= 31 || count <= 1<<shift
+}
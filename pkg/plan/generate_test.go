@@ -0,0 +1,59 @@
+package plan
+
+import (
+	"testing"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func cidrs(p *Plan) []string {
+	out := make([]string, len(p.Networks))
+	for i, n := range p.Networks {
+		out[i] = n.CIDR
+	}
+	return out
+}
+
+func TestGenerate(t *testing.T) {
+	t.Run("SitesRoundedToOctet", func(t *testing.T) {
+		p, err := Generate(Layout{Supernet: "10.0.0.0/8", Sites: 12, HostsPerSite: 500}, nil)
+		require.NoError(t, err)
+		require.Len(t, p.Networks, 36)
+		assert.Equal(t, []string{"10.0.0.0/16", "10.0.0.0/24", "10.0.1.0/24", "10.1.0.0/16"}, cidrs(p)[:4])
+		assert.Equal(t, "10.11.1.0/24", p.Networks[35].CIDR)
+		assert.Equal(t, "Site 12 VLAN 2", p.Networks[35].Description)
+		assert.Equal(t, []string{"site=site-12", "vlan=2"}, p.Networks[35].Tags)
+	})
+
+	t.Run("SkipsExistingNetworks", func(t *testing.T) {
+		existing := []*ipam.Network{{ID: "n1", CIDR: "10.0.0.0/8"}, {ID: "n2", CIDR: "10.0.5.0/24"}}
+		p, err := Generate(Layout{Supernet: "10.0.0.0/8", Sites: 2, HostsPerSite: 100}, existing)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"10.1.0.0/16", "10.1.0.0/24", "10.2.0.0/16", "10.2.0.0/24"}, cidrs(p))
+	})
+
+	t.Run("UnroundedWhenTight", func(t *testing.T) {
+		p, err := Generate(Layout{Supernet: "192.168.0.0/20", Sites: 4, HostsPerSite: 1000, HostsPerVLAN: 500}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"192.168.0.0/22", "192.168.0.0/23", "192.168.2.0/23", "192.168.4.0/22"}, cidrs(p)[:4])
+	})
+
+	t.Run("IPv6", func(t *testing.T) {
+		p, err := Generate(Layout{Supernet: "2001:db8::/32", Sites: 3, HostsPerSite: 2000}, nil)
+		require.NoError(t, err)
+		// Eight /64 VLANs per site, rounded to a nibble boundary
+		assert.Equal(t, "2001:db8::/60", p.Networks[0].CIDR)
+		assert.Equal(t, "2001:db8::/64", p.Networks[1].CIDR)
+		assert.Equal(t, "2001:db8:0:10::/60", p.Networks[9].CIDR)
+	})
+
+	t.Run("DoesNotFit", func(t *testing.T) {
+		_, err := Generate(Layout{Supernet: "10.0.0.0/24", Sites: 4, HostsPerSite: 200}, nil)
+		assert.Error(t, err)
+
+		_, err = Generate(Layout{Supernet: "10.0.0.0/8", Sites: 0, HostsPerSite: 10}, nil)
+		assert.Error(t, err)
+	})
+}
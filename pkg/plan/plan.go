@@ -0,0 +1,390 @@
+// Package plan reads a declarative address plan and computes the changes
+// needed to converge stored networks and allocations toward it.
+package plan
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"gopkg.in/yaml.v3"
+)
+
+// StatusReserved marks allocations created from a plan's reservations
+const StatusReserved = store.ReservationStatus
+
+// Plan is the desired state of the address plan
+type Plan struct {
+	Networks []NetworkSpec `yaml:"networks" json:"networks"`
+}
+
+// NetworkSpec declares a network and the addresses it must contain
+type NetworkSpec struct {
+	CIDR         string        `yaml:"cidr" json:"cidr"`
+	Description  string        `yaml:"description,omitempty" json:"description,omitempty"`
+	Tags         []string      `yaml:"tags,omitempty" json:"tags,omitempty"`
+	Reservations []AddressSpec `yaml:"reservations,omitempty" json:"reservations,omitempty"`
+	Allocations  []AddressSpec `yaml:"allocations,omitempty" json:"allocations,omitempty"`
+}
+
+// AddressSpec declares a static allocation or a reservation. Reservations
+// may cover a range written as "start-end".
+type AddressSpec struct {
+	IP          string   `yaml:"ip" json:"ip"`
+	Hostname    string   `yaml:"hostname,omitempty" json:"hostname,omitempty"`
+	Description string   `yaml:"description,omitempty" json:"description,omitempty"`
+	Tags        []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+
+	start, end netip.Addr
+	reserved   bool
+}
+
+// Address returns the spec's address or range in display form
+func (a *AddressSpec) Address() string {
+	if a.start == a.end {
+		return a.start.String()
+	}
+	return a.start.String() + "-" + a.end.String()
+}
+
+// Load reads a YAML (or JSON) plan and validates it
+func Load(r io.Reader) (*Plan, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	var p Plan
+	if err := dec.Decode(&p); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("invalid plan: %w", err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Validate normalizes CIDRs and addresses, and checks that every address
+// lies inside its network without overlapping another one
+func (p *Plan) Validate() error {
+	seen := make(map[string]bool)
+	for i := range p.Networks {
+		n := &p.Networks[i]
+
+		prefix, err := netip.ParsePrefix(n.CIDR)
+		if err != nil {
+			return fmt.Errorf("networks[%d]: invalid CIDR %q", i, n.CIDR)
+		}
+		n.CIDR = prefix.Masked().String()
+		if seen[n.CIDR] {
+			return fmt.Errorf("networks[%d]: duplicate network %s", i, n.CIDR)
+		}
+		seen[n.CIDR] = true
+		if err := store.CheckPointToPoint(n.CIDR, n.Tags); err != nil {
+			return fmt.Errorf("networks[%d]: %w", i, err)
+		}
+
+		var specs []*AddressSpec
+		for j := range n.Reservations {
+			spec := &n.Reservations[j]
+			spec.reserved = true
+			if err := spec.parse(prefix, true); err != nil {
+				return fmt.Errorf("%s reservations[%d]: %w", n.CIDR, j, err)
+			}
+			specs = append(specs, spec)
+		}
+		for j := range n.Allocations {
+			spec := &n.Allocations[j]
+			if err := spec.parse(prefix, false); err != nil {
+				return fmt.Errorf("%s allocations[%d]: %w", n.CIDR, j, err)
+			}
+			specs = append(specs, spec)
+		}
+
+		sort.Slice(specs, func(a, b int) bool { return specs[a].start.Less(specs[b].start) })
+		for j := 1; j < len(specs); j++ {
+			if !specs[j-1].end.Less(specs[j].start) {
+				return fmt.Errorf("%s: %s overlaps %s", n.CIDR, specs[j].Address(), specs[j-1].Address())
+			}
+		}
+	}
+	return nil
+}
+
+// parse reads the spec's address, allowing a range when allowRange is set
+func (a *AddressSpec) parse(prefix netip.Prefix, allowRange bool) error {
+	startStr, endStr, isRange := strings.Cut(a.IP, "-")
+	if isRange && !allowRange {
+		return fmt.Errorf("address ranges are only allowed in reservations")
+	}
+
+	start, err := netip.ParseAddr(strings.TrimSpace(startStr))
+	if err != nil {
+		return fmt.Errorf("invalid address %q", a.IP)
+	}
+	end := start
+	if isRange {
+		if end, err = netip.ParseAddr(strings.TrimSpace(endStr)); err != nil {
+			return fmt.Errorf("invalid address %q", a.IP)
+		}
+		if end.Less(start) {
+			return fmt.Errorf("range %q ends before it starts", a.IP)
+		}
+	}
+
+	if !prefix.Contains(start) || !prefix.Contains(end) {
+		return fmt.Errorf("%s is outside %s", a.IP, prefix)
+	}
+
+	a.start, a.end = start, end
+	return nil
+}
+
+// Action is the kind of change applied to a resource
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+// Change is one step toward the plan. Network changes carry the spec and/or
+// the current network; address changes also carry the allocation.
+type Change struct {
+	Action  Action   `json:"action"`
+	Kind    string   `json:"kind"`
+	Network string   `json:"network"`
+	Address string   `json:"address,omitempty"`
+	Fields  []string `json:"fields,omitempty"`
+
+	NetworkSpec    *NetworkSpec       `json:"-"`
+	AddressSpec    *AddressSpec       `json:"-"`
+	CurrentNetwork *ipam.Network      `json:"-"`
+	Current        *ipam.IPAllocation `json:"-"`
+}
+
+// String renders the change as a line of a diff
+func (c Change) String() string {
+	sign := map[Action]string{ActionCreate: "+", ActionUpdate: "~", ActionDelete: "-"}[c.Action]
+	s := fmt.Sprintf("%s %s %s", sign, c.Kind, c.Network)
+	if c.Address != "" {
+		s = fmt.Sprintf("%s %s %s in %s", sign, c.Kind, c.Address, c.Network)
+	}
+	if len(c.Fields) > 0 {
+		s += " (" + strings.Join(c.Fields, ", ") + ")"
+	}
+	return s
+}
+
+// Compute returns the changes that converge networks and their allocations
+// (keyed by network ID) toward p, in the order they must be applied.
+// Allocations and networks missing from the plan are deleted only when
+// prune is set; otherwise an unplanned allocation overlapping a planned
+// address is a conflict.
+func Compute(p *Plan, networks []*ipam.Network, allocations map[string][]*ipam.IPAllocation, prune bool, now time.Time) ([]Change, error) {
+	byCIDR := make(map[string]*ipam.Network, len(networks))
+	for _, network := range networks {
+		if prefix, err := netip.ParsePrefix(network.CIDR); err == nil {
+			byCIDR[prefix.Masked().String()] = network
+		}
+	}
+
+	var deletes, upserts, networkDeletes []Change
+	planned := make(map[string]bool, len(p.Networks))
+
+	for i := range p.Networks {
+		spec := &p.Networks[i]
+		planned[spec.CIDR] = true
+
+		current := byCIDR[spec.CIDR]
+		if current == nil {
+			upserts = append(upserts, Change{Action: ActionCreate, Kind: "network", Network: spec.CIDR, NetworkSpec: spec})
+		} else if fields := networkDrift(spec, current); len(fields) > 0 {
+			upserts = append(upserts, Change{Action: ActionUpdate, Kind: "network", Network: spec.CIDR, Fields: fields, NetworkSpec: spec, CurrentNetwork: current})
+		}
+
+		var active []*ipam.IPAllocation
+		if current != nil {
+			for _, alloc := range allocations[current.ID] {
+				if alloc.ReleasedAt == nil && (alloc.ExpiresAt == nil || !alloc.ExpiresAt.Before(now)) {
+					active = append(active, alloc)
+				}
+			}
+		}
+
+		matched := make(map[*ipam.IPAllocation]bool)
+		var pending []*AddressSpec
+		for _, addr := range addressSpecs(spec) {
+			alloc := findAllocation(active, addr)
+			if alloc == nil {
+				pending = append(pending, addr)
+				upserts = append(upserts, Change{Action: ActionCreate, Kind: addr.kind(), Network: spec.CIDR, Address: addr.Address(), NetworkSpec: spec, AddressSpec: addr, CurrentNetwork: current})
+				continue
+			}
+			matched[alloc] = true
+			if fields := addressDrift(addr, alloc); len(fields) > 0 {
+				upserts = append(upserts, Change{Action: ActionUpdate, Kind: addr.kind(), Network: spec.CIDR, Address: addr.Address(), Fields: fields, NetworkSpec: spec, AddressSpec: addr, CurrentNetwork: current, Current: alloc})
+			}
+		}
+
+		for _, alloc := range active {
+			if matched[alloc] {
+				continue
+			}
+			if prune {
+				deletes = append(deletes, Change{Action: ActionDelete, Kind: allocationKind(alloc), Network: spec.CIDR, Address: allocationAddress(alloc), CurrentNetwork: current, Current: alloc})
+				continue
+			}
+			for _, addr := range pending {
+				if overlaps(addr, alloc) {
+					return nil, fmt.Errorf("%s in %s conflicts with allocation %s (%s); use prune to replace it",
+						addr.Address(), spec.CIDR, alloc.ID, allocationAddress(alloc))
+				}
+			}
+		}
+	}
+
+	if prune {
+		for _, network := range networks {
+			prefix, err := netip.ParsePrefix(network.CIDR)
+			if err != nil || planned[prefix.Masked().String()] {
+				continue
+			}
+			networkDeletes = append(networkDeletes, Change{Action: ActionDelete, Kind: "network", Network: network.CIDR, CurrentNetwork: network})
+		}
+	}
+
+	changes := append(deletes, upserts...)
+	return append(changes, networkDeletes...), nil
+}
+
+// addressSpecs returns a network's reservations and allocations
+func addressSpecs(spec *NetworkSpec) []*AddressSpec {
+	specs := make([]*AddressSpec, 0, len(spec.Reservations)+len(spec.Allocations))
+	for i := range spec.Reservations {
+		specs = append(specs, &spec.Reservations[i])
+	}
+	for i := range spec.Allocations {
+		specs = append(specs, &spec.Allocations[i])
+	}
+	return specs
+}
+
+func (a *AddressSpec) kind() string {
+	if a.reserved {
+		return "reservation"
+	}
+	return "allocation"
+}
+
+// Status returns the allocation status the spec is stored with
+func (a *AddressSpec) Status() string {
+	if a.reserved {
+		return StatusReserved
+	}
+	return "allocated"
+}
+
+// Range returns the first and last address of the spec. EndIP is empty for
+// single addresses.
+func (a *AddressSpec) Range() (ip, endIP string) {
+	if a.start == a.end {
+		return a.start.String(), ""
+	}
+	return a.start.String(), a.end.String()
+}
+
+func allocationKind(alloc *ipam.IPAllocation) string {
+	if alloc.Status == StatusReserved {
+		return "reservation"
+	}
+	return "allocation"
+}
+
+func allocationAddress(alloc *ipam.IPAllocation) string {
+	if alloc.EndIP != "" {
+		return alloc.IP + "-" + alloc.EndIP
+	}
+	return alloc.IP
+}
+
+// allocationBounds returns the first and last address of alloc
+func allocationBounds(alloc *ipam.IPAllocation) (netip.Addr, netip.Addr, bool) {
+	start, err := netip.ParseAddr(alloc.IP)
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, false
+	}
+	end := start
+	if alloc.EndIP != "" {
+		if end, err = netip.ParseAddr(alloc.EndIP); err != nil {
+			return netip.Addr{}, netip.Addr{}, false
+		}
+	}
+	return start, end, true
+}
+
+// findAllocation returns the active allocation covering exactly addr
+func findAllocation(active []*ipam.IPAllocation, addr *AddressSpec) *ipam.IPAllocation {
+	for _, alloc := range active {
+		if start, end, ok := allocationBounds(alloc); ok && start == addr.start && end == addr.end {
+			return alloc
+		}
+	}
+	return nil
+}
+
+func overlaps(addr *AddressSpec, alloc *ipam.IPAllocation) bool {
+	start, end, ok := allocationBounds(alloc)
+	return ok && !addr.end.Less(start) && !end.Less(addr.start)
+}
+
+func networkDrift(spec *NetworkSpec, current *ipam.Network) []string {
+	var fields []string
+	if spec.Description != current.Description {
+		fields = append(fields, "description")
+	}
+	// Freezes and ACLs are managed apart from the plan
+	if !sameTags(store.KeepSystemTags(spec.Tags, nil), store.KeepSystemTags(current.Tags, nil)) {
+		fields = append(fields, "tags")
+	}
+	return fields
+}
+
+func addressDrift(spec *AddressSpec, current *ipam.IPAllocation) []string {
+	var fields []string
+	if spec.Status() != current.Status {
+		fields = append(fields, "status")
+	}
+	if spec.Hostname != current.Hostname {
+		fields = append(fields, "hostname")
+	}
+	if spec.Description != current.Description {
+		fields = append(fields, "description")
+	}
+	if !sameTags(spec.Tags, current.Tags) {
+		fields = append(fields, "tags")
+	}
+	return fields
+}
+
+// sameTags compares tag lists ignoring order
+func sameTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
@@ -0,0 +1,124 @@
+package plan
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPlan = `
+networks:
+  - cidr: 10.0.0.0/24
+    description: Servers
+    tags: [env=prod]
+    reservations:
+      - ip: 10.0.0.1-10.0.0.9
+        description: Infrastructure
+    allocations:
+      - ip: 10.0.0.10
+        hostname: db01
+      - ip: 10.0.0.11
+        hostname: web01
+  - cidr: 10.1.0.0/24
+`
+
+func TestLoad(t *testing.T) {
+	p, err := Load(strings.NewReader(testPlan))
+	require.NoError(t, err)
+	require.Len(t, p.Networks, 2)
+	assert.Equal(t, "10.0.0.1-10.0.0.9", p.Networks[0].Reservations[0].Address())
+
+	ip, endIP := p.Networks[0].Reservations[0].Range()
+	assert.Equal(t, "10.0.0.1", ip)
+	assert.Equal(t, "10.0.0.9", endIP)
+	assert.Equal(t, StatusReserved, p.Networks[0].Reservations[0].Status())
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown field": "networks:\n  - cidr: 10.0.0.0/24\n    vlan: 10\n",
+		"bad cidr":      "networks:\n  - cidr: 10.0.0.0/33\n",
+		"duplicate":     "networks:\n  - cidr: 10.0.0.0/24\n  - cidr: 10.0.0.1/24\n",
+		"outside":       "networks:\n  - cidr: 10.0.0.0/24\n    allocations:\n      - ip: 10.0.1.1\n",
+		"range alloc":   "networks:\n  - cidr: 10.0.0.0/24\n    allocations:\n      - ip: 10.0.0.1-10.0.0.2\n",
+		"overlap":       "networks:\n  - cidr: 10.0.0.0/24\n    reservations:\n      - ip: 10.0.0.1-10.0.0.9\n    allocations:\n      - ip: 10.0.0.5\n",
+	}
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Load(strings.NewReader(input))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func changeLines(changes []Change) []string {
+	lines := make([]string, len(changes))
+	for i, c := range changes {
+		lines[i] = c.String()
+	}
+	return lines
+}
+
+func TestCompute(t *testing.T) {
+	p, err := Load(strings.NewReader(testPlan))
+	require.NoError(t, err)
+	now := time.Now()
+
+	networks := []*ipam.Network{
+		{ID: "n1", CIDR: "10.0.0.0/24", Description: "Old", Tags: []string{"env=prod"}},
+		{ID: "n2", CIDR: "10.2.0.0/24"},
+	}
+	allocations := map[string][]*ipam.IPAllocation{
+		"n1": {
+			{ID: "a1", NetworkID: "n1", IP: "10.0.0.10", Hostname: "db01", Status: "allocated"},
+			{ID: "a2", NetworkID: "n1", IP: "10.0.0.11", Hostname: "web-old", Status: "allocated"},
+			{ID: "a3", NetworkID: "n1", IP: "10.0.0.50", Status: "allocated"},
+			{ID: "a4", NetworkID: "n1", IP: "10.0.0.60", Status: "allocated", ReleasedAt: &now},
+		},
+	}
+
+	t.Run("WithoutPrune", func(t *testing.T) {
+		changes, err := Compute(p, networks, allocations, false, now)
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"~ network 10.0.0.0/24 (description)",
+			"+ reservation 10.0.0.1-10.0.0.9 in 10.0.0.0/24",
+			"~ allocation 10.0.0.11 in 10.0.0.0/24 (hostname)",
+			"+ network 10.1.0.0/24",
+		}, changeLines(changes))
+	})
+
+	t.Run("WithPrune", func(t *testing.T) {
+		changes, err := Compute(p, networks, allocations, true, now)
+		require.NoError(t, err)
+		lines := changeLines(changes)
+		assert.Equal(t, "- allocation 10.0.0.50 in 10.0.0.0/24", lines[0])
+		assert.Equal(t, "- network 10.2.0.0/24", lines[len(lines)-1])
+		assert.Len(t, lines, 6)
+	})
+
+	t.Run("Conflict", func(t *testing.T) {
+		conflicting := map[string][]*ipam.IPAllocation{
+			"n1": {{ID: "a5", NetworkID: "n1", IP: "10.0.0.5", Status: "allocated"}},
+		}
+		_, err := Compute(p, networks, conflicting, false, now)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "conflicts with allocation a5")
+
+		changes, err := Compute(p, networks, conflicting, true, now)
+		require.NoError(t, err)
+		assert.Equal(t, "- allocation 10.0.0.5 in 10.0.0.0/24", changes[0].String())
+	})
+
+	t.Run("Converged", func(t *testing.T) {
+		p, err := Load(strings.NewReader("networks:\n  - cidr: 10.0.0.0/24\n    tags: [b, a]\n"))
+		require.NoError(t, err)
+		changes, err := Compute(p, []*ipam.Network{{ID: "n1", CIDR: "10.0.0.0/24", Tags: []string{"a", "b"}}}, nil, false, now)
+		require.NoError(t, err)
+		assert.Empty(t, changes)
+	})
+}
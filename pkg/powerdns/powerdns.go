@@ -0,0 +1,172 @@
+// Package powerdns keeps the A, AAAA and PTR records of a PowerDNS
+// Authoritative server in lockstep with allocations, through its HTTP API.
+// Each active allocation with a hostname gets a forward record in the
+// most specific zone the server hosts for its name, and a PTR record in
+// the most specific reverse zone.
+//
+// Record sets the sync creates carry a comment by the go-ipam account, and
+// only those are ever changed or deleted, so records managed by hand or by
+// other tools are left alone.
+package powerdns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultInterval is how often the server syncs records by default
+const DefaultInterval = 5 * time.Minute
+
+// DefaultServerID is the server the API of a standalone PowerDNS names
+const DefaultServerID = "localhost"
+
+// Account is the comment account marking record sets the sync manages
+const Account = "go-ipam"
+
+// Client talks to the PowerDNS Authoritative HTTP API
+type Client struct {
+	// URL is the API's base URL, e.g. http://pdns:8081
+	URL string
+
+	// APIKey is sent in the X-API-Key header
+	APIKey string
+
+	// ServerID is the server whose zones are managed, DefaultServerID when
+	// empty
+	ServerID string
+
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client for the API at url with a request timeout
+func NewClient(url, apiKey string) *Client {
+	return &Client{URL: url, APIKey: apiKey, HTTPClient: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Zone is a zone the server hosts
+type Zone struct {
+	// ID identifies the zone in API paths
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+
+	RRSets []RRSet `json:"rrsets,omitempty"`
+}
+
+// RRSet is the records of one name and type
+type RRSet struct {
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	TTL      int       `json:"ttl,omitempty"`
+	Records  []Record  `json:"records"`
+	Comments []Comment `json:"comments"`
+
+	// ChangeType is REPLACE or DELETE when patching a zone
+	ChangeType string `json:"changetype,omitempty"`
+}
+
+// Record is one record of an RRSet
+type Record struct {
+	Content  string `json:"content"`
+	Disabled bool   `json:"disabled"`
+}
+
+// Comment is a comment on an RRSet
+type Comment struct {
+	Content string `json:"content"`
+	Account string `json:"account"`
+}
+
+// Managed reports whether the sync created the RRSet
+func (r *RRSet) Managed() bool {
+	for _, c := range r.Comments {
+		if c.Account == Account {
+			return true
+		}
+	}
+	return false
+}
+
+// Zones returns the zones the server hosts, without their records
+func (c *Client) Zones(ctx context.Context) ([]Zone, error) {
+	var zones []Zone
+	err := c.do(ctx, http.MethodGet, "zones", nil, &zones)
+	return zones, err
+}
+
+// Zone returns a zone with its records
+func (c *Client) Zone(ctx context.Context, id string) (*Zone, error) {
+	var zone Zone
+	if err := c.do(ctx, http.MethodGet, "zones/"+url.PathEscape(id), nil, &zone); err != nil {
+		return nil, err
+	}
+	return &zone, nil
+}
+
+// PatchZone replaces or deletes RRSets of a zone in one transaction
+func (c *Client) PatchZone(ctx context.Context, id string, rrsets []RRSet) error {
+	return c.do(ctx, http.MethodPatch, "zones/"+url.PathEscape(id), map[string]any{"rrsets": rrsets}, nil)
+}
+
+// do sends a request to path below the server's API and decodes the
+// response into v
+func (c *Client) do(ctx context.Context, method, path string, body, v any) error {
+	serverID := c.ServerID
+	if serverID == "" {
+		serverID = DefaultServerID
+	}
+	endpoint := strings.TrimSuffix(c.URL, "/") + "/api/v1/servers/" + url.PathEscape(serverID) + "/" + path
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("X-API-Key", c.APIKey)
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("powerdns %s %s failed: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&apiErr)
+		if apiErr.Error != "" {
+			return fmt.Errorf("powerdns %s %s failed: %s: %s", method, path, resp.Status, apiErr.Error)
+		}
+		return fmt.Errorf("powerdns %s %s failed: %s", method, path, resp.Status)
+	}
+	if v == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 256<<20)).Decode(v); err != nil {
+		return fmt.Errorf("powerdns %s %s: invalid response: %w", method, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package powerdns
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePDNS is a PowerDNS API keeping zones in memory
+type fakePDNS struct {
+	zones   map[string]*Zone
+	patches int
+}
+
+func (f *fakePDNS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-API-Key") != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+		return
+	}
+	path, ok := strings.CutPrefix(r.URL.Path, "/api/v1/servers/localhost/zones")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if path == "" {
+		zones := []Zone{}
+		for _, z := range f.zones {
+			zones = append(zones, Zone{ID: z.ID, Name: z.Name, Kind: z.Kind})
+		}
+		json.NewEncoder(w).Encode(zones)
+		return
+	}
+	zone, ok := f.zones[strings.TrimPrefix(path, "/")]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Could not find domain"})
+		return
+	}
+	if r.Method == http.MethodGet {
+		json.NewEncoder(w).Encode(zone)
+		return
+	}
+
+	var patch struct {
+		RRSets []RRSet `json:"rrsets"`
+	}
+	json.NewDecoder(r.Body).Decode(&patch)
+	f.patches++
+	for _, change := range patch.RRSets {
+		var kept []RRSet
+		for _, rrset := range zone.RRSets {
+			if rrset.Name != change.Name || rrset.Type != change.Type {
+				kept = append(kept, rrset)
+			}
+		}
+		if change.ChangeType == "REPLACE" {
+			change.ChangeType = ""
+			kept = append(kept, change)
+		}
+		zone.RRSets = kept
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rrset returns the RRSet of name and type in zone, or nil
+func (f *fakePDNS) rrset(zone, name, typ string) *RRSet {
+	for i, rrset := range f.zones[zone].RRSets {
+		if rrset.Name == name && rrset.Type == typ {
+			return &f.zones[zone].RRSets[i]
+		}
+	}
+	return nil
+}
+
+func TestSync(t *testing.T) {
+	s, err := store.NewPebbleStore(t.TempDir())
+	require.NoError(t, err)
+	defer s.Close()
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "lan", CIDR: "192.168.30.0/24", Tags: []string{"domain=lan.example.com"}}))
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "v6", CIDR: "2001:db8::/64"}))
+	for _, alloc := range []*ipam.IPAllocation{
+		{ID: "web1", NetworkID: "lan", IP: "192.168.30.10", Hostname: "web"},
+		{ID: "web2", NetworkID: "lan", IP: "192.168.30.11", Hostname: "web"},
+		{ID: "db", NetworkID: "lan", IP: "192.168.30.20", Hostname: "db"},
+		{ID: "mail", NetworkID: "lan", IP: "192.168.30.25", Hostname: "mail"},
+		{ID: "anon", NetworkID: "lan", IP: "192.168.30.30"},
+		{ID: "api", NetworkID: "v6", IP: "2001:db8::10", Hostname: "api"},
+	} {
+		require.NoError(t, s.SaveAllocation(alloc))
+	}
+
+	// db and mail are managed by hand in the lan zone, more specific than
+	// example.com for their names
+	managed := []Comment{{Content: "Managed by go-ipam", Account: Account}}
+	pdns := &fakePDNS{zones: map[string]*Zone{
+		"example.com.": {ID: "example.com.", Name: "example.com.", Kind: "Native", RRSets: []RRSet{
+			{Name: "old.lan.example.com.", Type: "A", TTL: 3600, Records: []Record{{Content: "192.168.30.50"}}, Comments: managed},
+		}},
+		"lan.example.com.": {ID: "lan.example.com.", Name: "lan.example.com.", Kind: "Native", RRSets: []RRSet{
+			{Name: "db.lan.example.com.", Type: "A", TTL: 3600, Records: []Record{{Content: "192.168.30.99"}}},
+			{Name: "mail.lan.example.com.", Type: "CNAME", TTL: 3600, Records: []Record{{Content: "mx.example.net."}}},
+		}},
+		"30.168.192.in-addr.arpa.": {ID: "30.168.192.in-addr.arpa.", Name: "30.168.192.in-addr.arpa.", Kind: "Master"},
+		"example.org.": {ID: "example.org.", Name: "example.org.", Kind: "Slave", RRSets: []RRSet{
+			{Name: "x.example.org.", Type: "A", Records: []Record{{Content: "192.0.2.1"}}, Comments: managed},
+		}},
+	}}
+
+	server := httptest.NewServer(pdns)
+	defer server.Close()
+	syncer := &Syncer{Store: s, Client: NewClient(server.URL, "secret"), Domain: "example.com", BatchSize: 2}
+
+	syncer.DryRun = true
+	result, err := syncer.Sync(context.Background(), now)
+	require.NoError(t, err)
+	assert.Equal(t, 0, pdns.patches)
+	assert.Equal(t, 6, result.Created)
+
+	syncer.DryRun = false
+	result, err = syncer.Sync(context.Background(), now)
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.Zones)
+	assert.Equal(t, 6, result.Created)
+	assert.Equal(t, 1, result.Deleted)
+	assert.Equal(t, []Conflict{
+		{Zone: "lan.example.com.", Name: "db.lan.example.com.", Type: "A", Reason: "record set is not managed by go-ipam"},
+		{Zone: "lan.example.com.", Name: "mail.lan.example.com.", Type: "A", Reason: "name has a CNAME record"},
+	}, result.Conflicts)
+	assert.Equal(t, []string{"0.1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa."}, result.Unzoned)
+	// One update per zone, with the four PTR records in batches of two
+	assert.Equal(t, 4, pdns.patches)
+
+	web := pdns.rrset("lan.example.com.", "web.lan.example.com.", "A")
+	require.NotNil(t, web)
+	assert.Equal(t, []Record{{Content: "192.168.30.10"}, {Content: "192.168.30.11"}}, web.Records)
+	assert.True(t, web.Managed())
+	ptr := pdns.rrset("30.168.192.in-addr.arpa.", "20.30.168.192.in-addr.arpa.", "PTR")
+	require.NotNil(t, ptr)
+	assert.Equal(t, []Record{{Content: "db.lan.example.com."}}, ptr.Records)
+	assert.Nil(t, pdns.rrset("example.com.", "old.lan.example.com.", "A"))
+	assert.NotNil(t, pdns.rrset("example.com.", "api.example.com.", "AAAA"))
+	assert.NotNil(t, pdns.rrset("example.org.", "x.example.org.", "A"))
+
+	// In sync, nothing changes
+	pdns.patches = 0
+	result, err = syncer.Sync(context.Background(), now)
+	require.NoError(t, err)
+	assert.Equal(t, 0, pdns.patches)
+	assert.Equal(t, 0, result.Created+result.Updated+result.Deleted)
+
+	// Drift is repaired: a released allocation's records go, and a record
+	// edited in PowerDNS is put back
+	web2, err := s.GetAllocation("web2")
+	require.NoError(t, err)
+	web2.ReleasedAt = &now
+	require.NoError(t, s.SaveAllocation(web2))
+	pdns.rrset("30.168.192.in-addr.arpa.", "10.30.168.192.in-addr.arpa.", "PTR").Records = []Record{{Content: "wrong.example.com."}}
+
+	result, err = syncer.Sync(context.Background(), now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Updated)
+	assert.Equal(t, 1, result.Deleted)
+	assert.Equal(t, []Record{{Content: "192.168.30.10"}}, pdns.rrset("lan.example.com.", "web.lan.example.com.", "A").Records)
+	assert.Equal(t, []Record{{Content: "web.lan.example.com."}}, pdns.rrset("30.168.192.in-addr.arpa.", "10.30.168.192.in-addr.arpa.", "PTR").Records)
+	assert.Nil(t, pdns.rrset("30.168.192.in-addr.arpa.", "11.30.168.192.in-addr.arpa.", "PTR"))
+
+	// Zones limits the sync
+	syncer.Zones = []string{"lan.example.com"}
+	result, err = syncer.Sync(context.Background(), now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Zones)
+	assert.Contains(t, result.Unzoned, "api.example.com.")
+	assert.NotNil(t, pdns.rrset("example.com.", "api.example.com.", "AAAA"))
+
+	syncer.Client.APIKey = "wrong"
+	_, err = syncer.Sync(context.Background(), now)
+	assert.EqualError(t, err, "powerdns GET zones failed: 401 Unauthorized: Unauthorized")
+}
+
+func TestSyncClassless(t *testing.T) {
+	s, err := store.NewPebbleStore(t.TempDir())
+	require.NoError(t, err)
+	defer s.Close()
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "dmz", CIDR: "192.0.2.64/26"}))
+	require.NoError(t, s.SaveAllocation(&ipam.IPAllocation{ID: "www", NetworkID: "dmz", IP: "192.0.2.65", Hostname: "www.example.com."}))
+
+	// The /24 zone delegates 64/26 with CNAMEs, as RFC 2317 describes
+	pdns := &fakePDNS{zones: map[string]*Zone{
+		"2.0.192.in-addr.arpa.": {ID: "2.0.192.in-addr.arpa.", Name: "2.0.192.in-addr.arpa.", Kind: "Native", RRSets: []RRSet{
+			{Name: "65.2.0.192.in-addr.arpa.", Type: "CNAME", TTL: 3600, Records: []Record{{Content: "65.64/26.2.0.192.in-addr.arpa."}}},
+		}},
+		"64=2F26.2.0.192.in-addr.arpa.": {ID: "64=2F26.2.0.192.in-addr.arpa.", Name: "64/26.2.0.192.in-addr.arpa.", Kind: "Native"},
+	}}
+	server := httptest.NewServer(pdns)
+	defer server.Close()
+
+	syncer := &Syncer{Store: s, Client: NewClient(server.URL, "secret")}
+	result, err := syncer.Sync(context.Background(), now)
+	require.NoError(t, err)
+	assert.Empty(t, result.Conflicts)
+	assert.Equal(t, 1, result.Created)
+	ptr := pdns.rrset("64=2F26.2.0.192.in-addr.arpa.", "65.64/26.2.0.192.in-addr.arpa.", "PTR")
+	require.NotNil(t, ptr)
+	assert.Equal(t, []Record{{Content: "www.example.com."}}, ptr.Records)
+}
@@ -0,0 +1,410 @@
+package powerdns
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/netip"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/rdns"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// DefaultTTL is the TTL of the records the sync creates by default
+const DefaultTTL = 3600
+
+// DefaultBatchSize is how many record sets one zone update changes at most
+// by default
+const DefaultBatchSize = 500
+
+// Result is the outcome of a sync
+type Result struct {
+	// Zones counts the zones reconciled
+	Zones int `json:"zones"`
+
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Deleted   int `json:"deleted"`
+	Unchanged int `json:"unchanged"`
+
+	Conflicts []Conflict `json:"conflicts"`
+
+	// Unzoned lists the names no zone of the server contains
+	Unzoned []string `json:"unzoned"`
+}
+
+// Conflict is a record set the sync would manage but may not change
+type Conflict struct {
+	Zone   string `json:"zone"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// Syncer pushes the records of Store's allocations into PowerDNS
+type Syncer struct {
+	Store  ipam.Store
+	Client *Client
+
+	// Zones, when set, limits the sync to these zones
+	Zones []string
+
+	// Domain qualifies the hostnames of allocations in networks without a
+	// domain=<name> tag
+	Domain string
+
+	// TTL of the records created, DefaultTTL when zero
+	TTL int
+
+	// BatchSize caps the record sets changed per zone update,
+	// DefaultBatchSize when zero
+	BatchSize int
+
+	// DryRun reports the changes without making them
+	DryRun bool
+
+	// Active, when set, limits syncing by Run to when it returns true,
+	// e.g. on a cluster's Raft leader
+	Active func() bool
+}
+
+// rrsetKey identifies a record set
+type rrsetKey struct {
+	name, typ string
+}
+
+// managedTypes are the record types the sync manages
+var managedTypes = []string{"A", "AAAA", "PTR"}
+
+// Sync brings the A, AAAA and PTR records of the server's zones in line
+// with the active allocations that have hostnames. A record set the sync
+// created is replaced when its records drift from the allocations, and
+// deleted once no allocation calls for it. Record sets made by other
+// means are never changed: one the allocations would change is reported
+// as a conflict.
+func (s *Syncer) Sync(ctx context.Context, now time.Time) (*Result, error) {
+	desired, err := s.desired(now)
+	if err != nil {
+		return nil, err
+	}
+
+	zones, err := s.Client.Zones(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var only map[string]bool
+	if len(s.Zones) > 0 {
+		only = make(map[string]bool, len(s.Zones))
+		for _, zone := range s.Zones {
+			only[canonical(zone)] = true
+		}
+	}
+	var managed []Zone
+	for _, zone := range zones {
+		// Secondary zones are transferred from their primary
+		if strings.EqualFold(zone.Kind, "Slave") || strings.EqualFold(zone.Kind, "Secondary") {
+			continue
+		}
+		if only != nil && !only[canonical(zone.Name)] {
+			continue
+		}
+		managed = append(managed, zone)
+	}
+	sort.Slice(managed, func(i, j int) bool { return managed[i].Name < managed[j].Name })
+
+	// Place each name in the most specific zone containing it. The PTR
+	// record of an address in a classless reverse zone (RFC 2317) goes
+	// there instead, named after the address's last octet, for the CNAME
+	// in the /24 zone to point to.
+	classless := make(map[string]netip.Prefix)
+	for _, z := range managed {
+		if prefix, ok := rdns.ParseClasslessZone(z.Name); ok {
+			classless[canonical(z.Name)] = prefix
+		}
+	}
+	byZone := make(map[string]map[rrsetKey][]Record)
+	result := &Result{Conflicts: []Conflict{}, Unzoned: []string{}}
+	unzoned := make(map[string]bool)
+	for key, records := range desired {
+		zone := ""
+		if addr, ok := reverseAddr(key.name); ok && key.typ == "PTR" {
+			for name, prefix := range classless {
+				if prefix.Contains(addr) && (zone == "" || prefix.Bits() > classless[zone].Bits()) {
+					zone = name
+				}
+			}
+			if zone != "" {
+				key.name = strconv.Itoa(int(addr.As4()[3])) + "." + zone
+			}
+		}
+		if zone == "" {
+			zone = enclosingZone(key.name, managed)
+		}
+		if zone == "" {
+			unzoned[key.name] = true
+			continue
+		}
+		if byZone[zone] == nil {
+			byZone[zone] = make(map[rrsetKey][]Record)
+		}
+		byZone[zone][key] = records
+	}
+	for name := range unzoned {
+		result.Unzoned = append(result.Unzoned, name)
+	}
+	sort.Strings(result.Unzoned)
+
+	for _, zone := range managed {
+		result.Zones++
+		if err := s.syncZone(ctx, zone, byZone[canonical(zone.Name)], result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// enclosingZone returns the most specific of zones containing name, or ""
+func enclosingZone(name string, zones []Zone) string {
+	zone := ""
+	for _, z := range zones {
+		zoneName := canonical(z.Name)
+		if (name == zoneName || strings.HasSuffix(name, "."+zoneName)) && len(zoneName) > len(zone) {
+			zone = zoneName
+		}
+	}
+	return zone
+}
+
+// reverseAddr returns the IPv4 address of an in-addr.arpa name
+func reverseAddr(name string) (netip.Addr, bool) {
+	labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+	if len(labels) != 6 || labels[4] != "in-addr" || labels[5] != "arpa" {
+		return netip.Addr{}, false
+	}
+	addr, err := netip.ParseAddr(labels[3] + "." + labels[2] + "." + labels[1] + "." + labels[0])
+	return addr, err == nil
+}
+
+// desired returns the record sets the active allocations with hostnames
+// call for
+func (s *Syncer) desired(now time.Time) (map[rrsetKey][]Record, error) {
+	networks, err := s.Store.ListNetworks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+	contents := make(map[rrsetKey]map[string]bool)
+	add := func(key rrsetKey, content string) {
+		if contents[key] == nil {
+			contents[key] = make(map[string]bool)
+		}
+		contents[key][content] = true
+	}
+	for _, network := range networks {
+		domain := s.Domain
+		for _, tag := range network.Tags {
+			if value, ok := strings.CutPrefix(tag, "domain="); ok {
+				domain = value
+			}
+		}
+		allocations, err := s.Store.ListAllocations(network.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list allocations: %w", err)
+		}
+		for _, alloc := range allocations {
+			if alloc.Hostname == "" || store.AllocationStatus(alloc, now) != store.StatusActive {
+				continue
+			}
+			ips, err := rdns.ExpandRange(alloc.IP, alloc.EndIP)
+			if err != nil {
+				log.Printf("powerdns: skipping allocation %s: %v", alloc.ID, err)
+				continue
+			}
+			name := canonical(rdns.QualifyHostname(alloc.Hostname, domain))
+			for _, ip := range ips {
+				typ := "AAAA"
+				if ip.To4() != nil {
+					typ = "A"
+				}
+				add(rrsetKey{name, typ}, normalize(typ, ip.String()))
+				add(rrsetKey{rdns.ReverseName(ip), "PTR"}, name)
+			}
+		}
+	}
+
+	desired := make(map[rrsetKey][]Record, len(contents))
+	for key, set := range contents {
+		records := make([]Record, 0, len(set))
+		for content := range set {
+			records = append(records, Record{Content: content})
+		}
+		sort.Slice(records, func(i, j int) bool { return records[i].Content < records[j].Content })
+		desired[key] = records
+	}
+	return desired, nil
+}
+
+// syncZone brings the record sets of one zone in line with desired
+func (s *Syncer) syncZone(ctx context.Context, zone Zone, desired map[rrsetKey][]Record, result *Result) error {
+	full, err := s.Client.Zone(ctx, zone.ID)
+	if err != nil {
+		return err
+	}
+	ttl := s.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	existing := make(map[rrsetKey]*RRSet)
+	cnames := make(map[string]bool)
+	for i := range full.RRSets {
+		rrset := &full.RRSets[i]
+		name := canonical(rrset.Name)
+		if rrset.Type == "CNAME" {
+			cnames[name] = true
+		}
+		if slices.Contains(managedTypes, rrset.Type) {
+			existing[rrsetKey{name, rrset.Type}] = rrset
+		}
+	}
+
+	keys := make([]rrsetKey, 0, len(desired))
+	for key := range desired {
+		keys = append(keys, key)
+	}
+	sortKeys(keys)
+
+	var changes []RRSet
+	comments := []Comment{{Content: "Managed by go-ipam", Account: Account}}
+	for _, key := range keys {
+		records := desired[key]
+		conflict := Conflict{Zone: canonical(zone.Name), Name: key.name, Type: key.typ}
+		if cnames[key.name] {
+			conflict.Reason = "name has a CNAME record"
+			result.Conflicts = append(result.Conflicts, conflict)
+			continue
+		}
+		rrset, ok := existing[key]
+		switch {
+		case !ok:
+			result.Created++
+		case !rrset.Managed():
+			if sameRecords(key.typ, rrset.Records, records) {
+				result.Unchanged++
+			} else {
+				conflict.Reason = "record set is not managed by go-ipam"
+				result.Conflicts = append(result.Conflicts, conflict)
+			}
+			continue
+		case rrset.TTL == ttl && sameRecords(key.typ, rrset.Records, records):
+			result.Unchanged++
+			continue
+		default:
+			result.Updated++
+		}
+		changes = append(changes, RRSet{Name: key.name, Type: key.typ, TTL: ttl, Records: records, Comments: comments, ChangeType: "REPLACE"})
+	}
+
+	var stale []rrsetKey
+	for key, rrset := range existing {
+		if _, ok := desired[key]; !ok && rrset.Managed() {
+			stale = append(stale, key)
+		}
+	}
+	sortKeys(stale)
+	for _, key := range stale {
+		result.Deleted++
+		changes = append(changes, RRSet{Name: key.name, Type: key.typ, Records: []Record{}, Comments: []Comment{}, ChangeType: "DELETE"})
+	}
+
+	if s.DryRun {
+		return nil
+	}
+	batch := s.BatchSize
+	if batch <= 0 {
+		batch = DefaultBatchSize
+	}
+	for start := 0; start < len(changes); start += batch {
+		end := min(start+batch, len(changes))
+		if err := s.Client.PatchZone(ctx, zone.ID, changes[start:end]); err != nil {
+			return fmt.Errorf("zone %s: %w", zone.Name, err)
+		}
+	}
+	return nil
+}
+
+// sameRecords reports whether existing holds exactly the enabled records
+// of want
+func sameRecords(typ string, existing, want []Record) bool {
+	if len(existing) != len(want) {
+		return false
+	}
+	have := make(map[string]bool, len(existing))
+	for _, r := range existing {
+		if r.Disabled {
+			return false
+		}
+		have[normalize(typ, r.Content)] = true
+	}
+	for _, r := range want {
+		if !have[r.Content] {
+			return false
+		}
+	}
+	return true
+}
+
+// normalize returns record content in the form the sync writes it, so
+// that equal records compare equal however the server formats them
+func normalize(typ, content string) string {
+	switch typ {
+	case "A", "AAAA":
+		if addr, err := netip.ParseAddr(content); err == nil {
+			return addr.Unmap().String()
+		}
+	}
+	return canonical(content)
+}
+
+// canonical returns a domain name lower-cased and fully qualified
+func canonical(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+// sortKeys orders record sets by name and type
+func sortKeys(keys []rrsetKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return keys[i].typ < keys[j].typ
+	})
+}
+
+// Run syncs records every interval until stop is closed
+func Run(s *Syncer, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if s.Active != nil && !s.Active() {
+				continue
+			}
+			if _, err := s.Sync(context.Background(), time.Now()); err != nil {
+				log.Printf("powerdns: sync failed: %v", err)
+			}
+		}
+	}
+}
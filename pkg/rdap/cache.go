@@ -0,0 +1,72 @@
+package rdap
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCacheTTL is how long cached registration data is considered fresh
+const DefaultCacheTTL = 7 * 24 * time.Hour
+
+// Cache stores lookup results as one JSON file per prefix in a directory
+type Cache struct {
+	Dir string
+	TTL time.Duration
+
+	mu sync.Mutex
+}
+
+// NewCache returns a cache rooted at dir using DefaultCacheTTL
+func NewCache(dir string) *Cache {
+	return &Cache{Dir: dir, TTL: DefaultCacheTTL}
+}
+
+// Get returns the cached entry for prefix if it exists and is fresh
+func (c *Cache) Get(prefix string) (*Info, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, err := os.ReadFile(c.path(prefix))
+	if err != nil {
+		return nil, false
+	}
+
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, false
+	}
+	if c.TTL > 0 && time.Since(info.FetchedAt) > c.TTL {
+		return nil, false
+	}
+	return &info, true
+}
+
+// Put stores info for prefix
+func (c *Cache) Put(prefix string, info *Info) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := c.path(prefix) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path(prefix))
+}
+
+func (c *Cache) path(prefix string) string {
+	name := strings.NewReplacer("/", "_", ":", "-").Replace(prefix)
+	return filepath.Join(c.Dir, name+".json")
+}
@@ -0,0 +1,236 @@
+// Package rdap looks up registration data for public address blocks using
+// the Registration Data Access Protocol (RFC 9083).
+package rdap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the bootstrap service that redirects to the
+// authoritative registry for a prefix
+const DefaultBaseURL = "https://rdap.org"
+
+// ErrNotPublic is returned for private, loopback, link-local and other
+// special-purpose prefixes, which have no registration data
+var ErrNotPublic = errors.New("prefix is not publicly routable")
+
+// Info is the registration data of an address block
+type Info struct {
+	Prefix       string    `json:"prefix"`
+	Handle       string    `json:"handle"`
+	Name         string    `json:"name"`
+	Type         string    `json:"type,omitempty"`
+	StartAddress string    `json:"start_address"`
+	EndAddress   string    `json:"end_address"`
+	Country      string    `json:"country,omitempty"`
+	RIR          string    `json:"rir,omitempty"`
+	Org          string    `json:"org,omitempty"`
+	AbuseEmail   string    `json:"abuse_email,omitempty"`
+	FetchedAt    time.Time `json:"fetched_at"`
+}
+
+// Client queries an RDAP service, consulting an optional cache first
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	Cache      *Cache
+
+	// Refresh ignores cached entries; fresh results are still cached
+	Refresh bool
+}
+
+// NewClient returns a client for DefaultBaseURL with a request timeout
+func NewClient(cache *Cache) *Client {
+	return &Client{
+		BaseURL:    DefaultBaseURL,
+		HTTPClient: &http.Client{Timeout: 15 * time.Second},
+		Cache:      cache,
+	}
+}
+
+// Lookup returns the registration data for prefix, which may be a CIDR or a
+// single address
+func (c *Client) Lookup(ctx context.Context, prefix string) (*Info, error) {
+	p, err := parsePrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if !IsPublic(p.Addr()) {
+		return nil, ErrNotPublic
+	}
+	key := p.String()
+
+	if c.Cache != nil && !c.Refresh {
+		if info, ok := c.Cache.Get(key); ok {
+			return info, nil
+		}
+	}
+
+	info, err := c.fetch(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Cache != nil {
+		if err := c.Cache.Put(key, info); err != nil {
+			return nil, fmt.Errorf("failed to cache RDAP response: %w", err)
+		}
+	}
+	return info, nil
+}
+
+func (c *Client) fetch(ctx context.Context, prefix string) (*Info, error) {
+	url := strings.TrimSuffix(c.BaseURL, "/") + "/ip/" + prefix
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("RDAP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("RDAP lookup for %s returned %s", prefix, resp.Status)
+	}
+
+	var network ipNetwork
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(&network); err != nil {
+		return nil, fmt.Errorf("invalid RDAP response: %w", err)
+	}
+
+	info := &Info{
+		Prefix:       prefix,
+		Handle:       network.Handle,
+		Name:         network.Name,
+		Type:         network.Type,
+		StartAddress: network.StartAddress,
+		EndAddress:   network.EndAddress,
+		Country:      network.Country,
+		RIR:          rirFromPort43(network.Port43),
+		FetchedAt:    time.Now().UTC(),
+	}
+	if e := findEntity(network.Entities, "registrant"); e != nil {
+		info.Org = e.vcardText("fn")
+	}
+	if e := findEntity(network.Entities, "abuse"); e != nil {
+		info.AbuseEmail = e.vcardText("email")
+	}
+	return info, nil
+}
+
+// ipNetwork is the subset of the RDAP IP network object we use
+type ipNetwork struct {
+	Handle       string   `json:"handle"`
+	Name         string   `json:"name"`
+	Type         string   `json:"type"`
+	StartAddress string   `json:"startAddress"`
+	EndAddress   string   `json:"endAddress"`
+	Country      string   `json:"country"`
+	Port43       string   `json:"port43"`
+	Entities     []entity `json:"entities"`
+}
+
+type entity struct {
+	Handle     string            `json:"handle"`
+	Roles      []string          `json:"roles"`
+	VCardArray []json.RawMessage `json:"vcardArray"`
+	Entities   []entity          `json:"entities"`
+}
+
+// findEntity searches entities depth-first for one carrying role; abuse
+// contacts are usually nested under the registrant
+func findEntity(entities []entity, role string) *entity {
+	for i := range entities {
+		for _, r := range entities[i].Roles {
+			if r == role {
+				return &entities[i]
+			}
+		}
+	}
+	for i := range entities {
+		if e := findEntity(entities[i].Entities, role); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// vcardText returns the first text value of a jCard property (RFC 7095)
+func (e *entity) vcardText(name string) string {
+	if len(e.VCardArray) < 2 {
+		return ""
+	}
+	var props [][]json.RawMessage
+	if err := json.Unmarshal(e.VCardArray[1], &props); err != nil {
+		return ""
+	}
+	for _, prop := range props {
+		if len(prop) < 4 {
+			continue
+		}
+		var propName, value string
+		if json.Unmarshal(prop[0], &propName) != nil || propName != name {
+			continue
+		}
+		if json.Unmarshal(prop[3], &value) == nil {
+			return value
+		}
+	}
+	return ""
+}
+
+var port43RIRs = map[string]string{
+	"whois.arin.net":    "ARIN",
+	"whois.ripe.net":    "RIPE NCC",
+	"whois.apnic.net":   "APNIC",
+	"whois.lacnic.net":  "LACNIC",
+	"whois.afrinic.net": "AFRINIC",
+}
+
+func rirFromPort43(port43 string) string {
+	if rir, ok := port43RIRs[strings.ToLower(port43)]; ok {
+		return rir
+	}
+	return port43
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q", s)
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid address %q", s)
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+// sharedAddressSpace is RFC 6598 carrier-grade NAT space
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// IsPublic reports whether addr is publicly routable: global unicast, and
+// neither private nor carrier-grade NAT space
+func IsPublic(addr netip.Addr) bool {
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
@@ -0,0 +1,99 @@
+package rdap
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const arinResponse = `{
+  "objectClassName": "ip network",
+  "handle": "NET-8-8-8-0-2",
+  "startAddress": "8.8.8.0",
+  "endAddress": "8.8.8.255",
+  "ipVersion": "v4",
+  "name": "GOGL",
+  "type": "DIRECT ALLOCATION",
+  "port43": "whois.arin.net",
+  "entities": [
+    {
+      "handle": "GOGL",
+      "roles": ["registrant"],
+      "vcardArray": ["vcard", [["version", {}, "text", "4.0"], ["fn", {}, "text", "Google LLC"]]],
+      "entities": [
+        {
+          "handle": "ABUSE5250-ARIN",
+          "roles": ["abuse"],
+          "vcardArray": ["vcard", [["fn", {}, "text", "Abuse"], ["email", {}, "text", "network-abuse@google.com"]]]
+        }
+      ]
+    }
+  ]
+}`
+
+func TestLookup(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "/ip/8.8.8.0/24", r.URL.Path)
+		assert.Equal(t, "application/rdap+json", r.Header.Get("Accept"))
+		w.Write([]byte(arinResponse))
+	}))
+	defer srv.Close()
+
+	client := &Client{BaseURL: srv.URL, Cache: NewCache(t.TempDir())}
+
+	info, err := client.Lookup(context.Background(), "8.8.8.8/24")
+	require.NoError(t, err)
+	assert.Equal(t, "8.8.8.0/24", info.Prefix)
+	assert.Equal(t, "NET-8-8-8-0-2", info.Handle)
+	assert.Equal(t, "ARIN", info.RIR)
+	assert.Equal(t, "Google LLC", info.Org)
+	assert.Equal(t, "network-abuse@google.com", info.AbuseEmail)
+	assert.Equal(t, "8.8.8.255", info.EndAddress)
+
+	// Second lookup is served from the cache
+	cached, err := client.Lookup(context.Background(), "8.8.8.0/24")
+	require.NoError(t, err)
+	assert.Equal(t, info.Org, cached.Org)
+	assert.Equal(t, 1, requests)
+}
+
+func TestLookupRejectsNonPublic(t *testing.T) {
+	client := &Client{BaseURL: "http://127.0.0.1:0"}
+	for _, prefix := range []string{"10.0.0.0/8", "192.168.1.0/24", "100.64.0.0/10", "127.0.0.1", "fd00::/8", "fe80::1"} {
+		_, err := client.Lookup(context.Background(), prefix)
+		assert.ErrorIs(t, err, ErrNotPublic, prefix)
+	}
+
+	_, err := client.Lookup(context.Background(), "not-a-cidr")
+	assert.Error(t, err)
+}
+
+func TestLookupHTTPError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	client := &Client{BaseURL: srv.URL}
+	_, err := client.Lookup(context.Background(), "2001:4860::/32")
+	assert.ErrorContains(t, err, "404")
+}
+
+func TestCacheExpiry(t *testing.T) {
+	cache := NewCache(t.TempDir())
+	require.NoError(t, cache.Put("8.8.8.0/24", &Info{Prefix: "8.8.8.0/24", FetchedAt: time.Now().Add(-8 * 24 * time.Hour)}))
+
+	_, ok := cache.Get("8.8.8.0/24")
+	assert.False(t, ok)
+
+	cache.TTL = 0
+	_, ok = cache.Get("8.8.8.0/24")
+	assert.True(t, ok)
+}
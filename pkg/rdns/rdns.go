@@ -0,0 +1,250 @@
+package rdns
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxRangeRecords caps how many PTR records a single range allocation may
+// expand to, so a large block cannot produce an unbounded zone
+const MaxRangeRecords = 65536
+
+// Classless reverse zone naming styles for networks longer than /24
+// (RFC 2317), by the zone of 192.0.2.64/26:
+//
+//	slash  64/26.2.0.192.in-addr.arpa. (the RFC's example)
+//	dash   64-26.2.0.192.in-addr.arpa.
+//	range  64-127.2.0.192.in-addr.arpa.
+const (
+	StyleSlash = "slash"
+	StyleDash  = "dash"
+	StyleRange = "range"
+)
+
+// ClasslessStyles lists the classless zone naming styles
+var ClasslessStyles = []string{StyleSlash, StyleDash, StyleRange}
+
+// Record is a single record, PTR unless Type says otherwise. Name is
+// relative to the zone origin.
+type Record struct {
+	Name   string
+	Type   string
+	Target string
+}
+
+// Zone is a reverse DNS zone ready to be rendered in master file format
+type Zone struct {
+	Origin  string
+	TTL     int
+	NS      string // Optional; an SOA and NS record are emitted when set
+	Email   string // SOA responsible mailbox, e.g. hostmaster.example.com
+	Serial  uint32
+	Records []Record
+}
+
+// ReverseName returns the fully qualified in-addr.arpa or ip6.arpa name of ip
+func ReverseName(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", v4[3], v4[2], v4[1], v4[0])
+	}
+
+	const hexDigits = "0123456789abcdef"
+	ip = ip.To16()
+	var b strings.Builder
+	for i := len(ip) - 1; i >= 0; i-- {
+		b.WriteByte(hexDigits[ip[i]&0x0f])
+		b.WriteByte('.')
+		b.WriteByte(hexDigits[ip[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa.")
+	return b.String()
+}
+
+// ZoneOrigin returns the reverse zone that contains ipNet. The prefix is
+// rounded down to the enclosing octet (IPv4) or nibble (IPv6) boundary,
+// since reverse zones can only be delegated on those boundaries.
+func ZoneOrigin(ipNet *net.IPNet) string {
+	ones, bits := ipNet.Mask.Size()
+	full := ReverseName(ipNet.IP)
+
+	labelBits := 8
+	if bits == 128 {
+		labelBits = 4
+	}
+	keep := ones / labelBits
+
+	labels := strings.Split(strings.TrimSuffix(full, "."), ".")
+	// labels holds the address labels followed by the two arpa labels
+	addrLabels := len(labels) - 2
+	return strings.Join(labels[addrLabels-keep:], ".") + "."
+}
+
+// IsClassless reports whether ipNet is an IPv4 network longer than /24,
+// whose reverse zone cannot be delegated on an octet boundary and is
+// delegated as RFC 2317 describes instead
+func IsClassless(ipNet *net.IPNet) bool {
+	ones, bits := ipNet.Mask.Size()
+	return bits == 32 && ones > 24
+}
+
+// ClasslessLabel returns the label naming the classless zone of ipNet in
+// its parent /24 zone, e.g. "64/26", in style
+func ClasslessLabel(ipNet *net.IPNet, style string) string {
+	ones, _ := ipNet.Mask.Size()
+	first := ipNet.IP.To4()[3]
+	switch style {
+	case StyleDash:
+		return fmt.Sprintf("%d-%d", first, ones)
+	case StyleRange:
+		last := first | ^ipNet.Mask[3]
+		return fmt.Sprintf("%d-%d", first, last)
+	default:
+		return fmt.Sprintf("%d/%d", first, ones)
+	}
+}
+
+// ClasslessOrigin returns the RFC 2317 reverse zone of a classless
+// network, below its parent /24 zone
+func ClasslessOrigin(ipNet *net.IPNet, style string) string {
+	return ClasslessLabel(ipNet, style) + "." + ZoneOrigin(ipNet)
+}
+
+// ParseClasslessZone returns the addresses a classless reverse zone in
+// any style covers, e.g. 192.0.2.64/26 for 64/26.2.0.192.in-addr.arpa.
+func ParseClasslessZone(name string) (netip.Prefix, bool) {
+	labels := strings.Split(strings.TrimSuffix(strings.ToLower(name), "."), ".")
+	if len(labels) != 6 || labels[4] != "in-addr" || labels[5] != "arpa" {
+		return netip.Prefix{}, false
+	}
+	label := labels[0]
+	sep := strings.IndexAny(label, "/-")
+	if sep < 0 {
+		return netip.Prefix{}, false
+	}
+	first, err1 := strconv.ParseUint(label[:sep], 10, 8)
+	second, err2 := strconv.ParseUint(label[sep+1:], 10, 8)
+	if err1 != nil || err2 != nil {
+		return netip.Prefix{}, false
+	}
+	addr, err := netip.ParseAddr(fmt.Sprintf("%s.%s.%s.%d", labels[3], labels[2], labels[1], first))
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+
+	// After a dash comes a last address as in 64-127 when the two span a
+	// power of two addresses, else a prefix length as in 64-26
+	bits := int(second)
+	if size := second - first + 1; label[sep] == '-' && second > first && size&(size-1) == 0 {
+		bits = 32
+		for ; size > 1; size >>= 1 {
+			bits--
+		}
+	}
+	if bits <= 24 || bits > 32 {
+		return netip.Prefix{}, false
+	}
+	prefix := netip.PrefixFrom(addr, bits)
+	if prefix.Masked() != prefix {
+		return netip.Prefix{}, false
+	}
+	return prefix, true
+}
+
+// RelativeName returns fqdn relative to origin, or fqdn unchanged when it
+// lies outside origin
+func RelativeName(fqdn, origin string) string {
+	if fqdn == origin {
+		return "@"
+	}
+	if strings.HasSuffix(fqdn, "."+origin) {
+		return strings.TrimSuffix(fqdn, "."+origin)
+	}
+	return fqdn
+}
+
+// QualifyHostname turns hostname into an absolute domain name, appending
+// domain when the hostname is not already qualified
+func QualifyHostname(hostname, domain string) string {
+	if strings.HasSuffix(hostname, ".") {
+		return hostname
+	}
+	domain = strings.TrimSuffix(domain, ".")
+	if domain != "" && hostname != domain && !strings.HasSuffix(hostname, "."+domain) {
+		hostname += "." + domain
+	}
+	return hostname + "."
+}
+
+// ExpandRange returns every address from start to end inclusive. end may be
+// empty for a single address.
+func ExpandRange(start, end string) ([]net.IP, error) {
+	first, err := netip.ParseAddr(start)
+	if err != nil {
+		return nil, err
+	}
+	last := first
+	if end != "" {
+		if last, err = netip.ParseAddr(end); err != nil {
+			return nil, err
+		}
+	}
+	if last.Less(first) {
+		return nil, fmt.Errorf("range end %s precedes start %s", end, start)
+	}
+
+	var ips []net.IP
+	for addr := first; ; addr = addr.Next() {
+		if len(ips) >= MaxRangeRecords {
+			return nil, fmt.Errorf("range %s-%s exceeds %d addresses", start, end, MaxRangeRecords)
+		}
+		ips = append(ips, net.IP(addr.AsSlice()))
+		if addr == last {
+			break
+		}
+	}
+	return ips, nil
+}
+
+// Render writes the zone in RFC 1035 master file format
+func (z *Zone) Render(w io.Writer) error {
+	ttl := z.TTL
+	if ttl <= 0 {
+		ttl = 3600
+	}
+
+	fmt.Fprintf(w, "$ORIGIN %s\n", z.Origin)
+	fmt.Fprintf(w, "$TTL %d\n", ttl)
+
+	if z.NS != "" {
+		ns := QualifyHostname(z.NS, "")
+		email := z.Email
+		if email == "" {
+			email = "hostmaster." + strings.TrimSuffix(ns, ".")
+		}
+		serial := z.Serial
+		if serial == 0 {
+			serial = uint32(time.Now().Unix())
+		}
+		fmt.Fprintf(w, "@\tIN\tSOA\t%s %s (%d 3600 900 604800 %d)\n",
+			ns, QualifyHostname(strings.Replace(email, "@", ".", 1), ""), serial, ttl)
+		fmt.Fprintf(w, "@\tIN\tNS\t%s\n", ns)
+	}
+
+	for _, rec := range z.Records {
+		typ := rec.Type
+		if typ == "" {
+			typ = "PTR"
+		}
+		if _, err := fmt.Fprintf(w, "%s\tIN\t%s\t%s\n", rec.Name, typ, rec.Target); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
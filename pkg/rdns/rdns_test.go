@@ -0,0 +1,124 @@
+package rdns
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReverseName(t *testing.T) {
+	assert.Equal(t, "7.3.42.10.in-addr.arpa.", ReverseName(net.ParseIP("10.42.3.7")))
+	assert.Equal(t,
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+		ReverseName(net.ParseIP("2001:db8::1")))
+}
+
+func TestZoneOrigin(t *testing.T) {
+	cases := map[string]string{
+		"10.42.3.0/24":     "3.42.10.in-addr.arpa.",
+		"10.42.0.0/16":     "42.10.in-addr.arpa.",
+		"10.42.0.0/22":     "42.10.in-addr.arpa.",
+		"10.0.0.0/8":       "10.in-addr.arpa.",
+		"2001:db8::/32":    "8.b.d.0.1.0.0.2.ip6.arpa.",
+		"2001:db8:1::/48":  "1.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+		"2001:db8:10::/46": "1.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+	}
+	for cidr, want := range cases {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		assert.Equal(t, want, ZoneOrigin(ipNet), cidr)
+	}
+}
+
+func TestClassless(t *testing.T) {
+	_, ipNet, err := net.ParseCIDR("192.0.2.64/26")
+	require.NoError(t, err)
+	assert.True(t, IsClassless(ipNet))
+	assert.Equal(t, "64/26.2.0.192.in-addr.arpa.", ClasslessOrigin(ipNet, StyleSlash))
+	assert.Equal(t, "64-26.2.0.192.in-addr.arpa.", ClasslessOrigin(ipNet, StyleDash))
+	assert.Equal(t, "64-127.2.0.192.in-addr.arpa.", ClasslessOrigin(ipNet, StyleRange))
+
+	for _, cidr := range []string{"192.0.2.0/24", "10.0.0.0/16", "2001:db8::/120"} {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		assert.False(t, IsClassless(ipNet), cidr)
+	}
+
+	cases := map[string]string{
+		"64/26.2.0.192.in-addr.arpa.":  "192.0.2.64/26",
+		"64-26.2.0.192.IN-ADDR.ARPA":   "192.0.2.64/26",
+		"64-127.2.0.192.in-addr.arpa.": "192.0.2.64/26",
+		"0-31.2.0.192.in-addr.arpa.":   "192.0.2.0/27",
+		"128/25.2.0.192.in-addr.arpa.": "192.0.2.128/25",
+	}
+	for name, want := range cases {
+		prefix, ok := ParseClasslessZone(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, want, prefix.String(), name)
+	}
+	for _, name := range []string{"2.0.192.in-addr.arpa.", "65/26.2.0.192.in-addr.arpa.", "0/24.2.0.192.in-addr.arpa.", "64-100.2.0.192.in-addr.arpa.", "64/26.example.com."} {
+		_, ok := ParseClasslessZone(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestQualifyHostname(t *testing.T) {
+	assert.Equal(t, "web.example.com.", QualifyHostname("web", "example.com"))
+	assert.Equal(t, "web.example.com.", QualifyHostname("web.example.com", "example.com."))
+	assert.Equal(t, "web.other.org.", QualifyHostname("web.other.org.", "example.com"))
+	assert.Equal(t, "web.", QualifyHostname("web", ""))
+}
+
+func TestExpandRange(t *testing.T) {
+	ips, err := ExpandRange("10.0.0.254", "10.0.1.1")
+	require.NoError(t, err)
+	require.Len(t, ips, 4)
+	assert.Equal(t, "10.0.1.0", ips[2].String())
+
+	ips, err = ExpandRange("2001:db8::1", "")
+	require.NoError(t, err)
+	assert.Len(t, ips, 1)
+
+	_, err = ExpandRange("10.0.0.5", "10.0.0.1")
+	assert.Error(t, err)
+}
+
+func TestRender(t *testing.T) {
+	zone := &Zone{
+		Origin: "3.42.10.in-addr.arpa.",
+		TTL:    300,
+		NS:     "ns1.example.com",
+		Serial: 42,
+		Records: []Record{
+			{Name: RelativeName("7.3.42.10.in-addr.arpa.", "3.42.10.in-addr.arpa."), Target: "web.example.com."},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, zone.Render(&buf))
+	assert.Equal(t, "$ORIGIN 3.42.10.in-addr.arpa.\n"+
+		"$TTL 300\n"+
+		"@\tIN\tSOA\tns1.example.com. hostmaster.ns1.example.com. (42 3600 900 604800 300)\n"+
+		"@\tIN\tNS\tns1.example.com.\n"+
+		"7\tIN\tPTR\tweb.example.com.\n", buf.String())
+}
+
+func TestRenderRecordTypes(t *testing.T) {
+	zone := &Zone{
+		Origin: "2.0.192.in-addr.arpa.",
+		Records: []Record{
+			{Name: "64/26", Type: "NS", Target: "ns1.example.com."},
+			{Name: "65", Type: "CNAME", Target: "65.64/26"},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, zone.Render(&buf))
+	assert.Equal(t, "$ORIGIN 2.0.192.in-addr.arpa.\n"+
+		"$TTL 3600\n"+
+		"64/26\tIN\tNS\tns1.example.com.\n"+
+		"65\tIN\tCNAME\t65.64/26\n", buf.String())
+}
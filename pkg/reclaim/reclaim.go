@@ -0,0 +1,431 @@
+// Package reclaim releases allocations that have stopped answering health
+// checks, to fight address sprawl.
+//
+// A network opts in with tags: reclaim-after=<duration> enables the policy,
+// reclaim-tag=<tag> limits it to allocations carrying that tag (e.g.
+// ephemeral) and reclaim-grace=<duration> sets the warning period. Durations
+// accept a d suffix for days, e.g. reclaim-after=30d.
+//
+// An allocation silent for reclaim-after is first marked with
+// reclaim-pending=<RFC3339 time> and a notification is sent. If it is still
+// silent once that time has passed it is released; if it answers a probe
+// first, the mark is removed. Allocations tagged reclaim-exempt are never
+// reclaimed. Every step is recorded in the audit log.
+package reclaim
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/health"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/jeremyhahn/go-ipam/pkg/webhook"
+)
+
+const (
+	AfterTagPrefix   = "reclaim-after="
+	GraceTagPrefix   = "reclaim-grace="
+	MatchTagPrefix   = "reclaim-tag="
+	PendingTagPrefix = "reclaim-pending="
+	ExemptTag        = "reclaim-exempt"
+)
+
+// DefaultGrace is the warning period of a policy without reclaim-grace
+const DefaultGrace = 7 * 24 * time.Hour
+
+// auditUser is the user recorded for reclamation audit entries
+const auditUser = "reclaim"
+
+// Event types, also used as audit actions
+const (
+	EventPending   = "reclaim_pending"
+	EventCancelled = "reclaim_cancelled"
+	EventReleased  = "reclaim_released"
+)
+
+// Policy is a network's reclamation policy
+type Policy struct {
+	// After is how long an allocation must be silent to be reclaimed
+	After time.Duration
+
+	// Grace is how long a pending reclamation waits before releasing
+	Grace time.Duration
+
+	// Tags limits the policy to allocations carrying one of them; empty
+	// means every allocation
+	Tags []string
+}
+
+// NetworkPolicy returns network's reclamation policy, or nil when it has none
+func NetworkPolicy(network *ipam.Network) (*Policy, error) {
+	var p Policy
+	var enabled bool
+	for _, tag := range network.Tags {
+		var err error
+		if value, ok := strings.CutPrefix(tag, AfterTagPrefix); ok {
+			p.After, err = ParseDuration(value)
+			enabled = true
+		} else if value, ok := strings.CutPrefix(tag, GraceTagPrefix); ok {
+			p.Grace, err = ParseDuration(value)
+		} else if value, ok := strings.CutPrefix(tag, MatchTagPrefix); ok {
+			p.Tags = append(p.Tags, value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("network %s: invalid tag %q: %w", network.CIDR, tag, err)
+		}
+	}
+	if !enabled {
+		return nil, nil
+	}
+	if p.Grace == 0 {
+		p.Grace = DefaultGrace
+	}
+	return &p, nil
+}
+
+// PolicyTags returns tags with any previous policy replaced by p. A nil p
+// removes the policy.
+func PolicyTags(tags []string, p *Policy) []string {
+	var out []string
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, AfterTagPrefix) && !strings.HasPrefix(tag, GraceTagPrefix) && !strings.HasPrefix(tag, MatchTagPrefix) {
+			out = append(out, tag)
+		}
+	}
+	if p == nil {
+		return out
+	}
+	out = append(out, AfterTagPrefix+FormatDuration(p.After))
+	if p.Grace != 0 {
+		out = append(out, GraceTagPrefix+FormatDuration(p.Grace))
+	}
+	for _, tag := range p.Tags {
+		out = append(out, MatchTagPrefix+tag)
+	}
+	return out
+}
+
+func (p *Policy) String() string {
+	s := fmt.Sprintf("reclaim after %s silent, %s grace", FormatDuration(p.After), FormatDuration(p.Grace))
+	if len(p.Tags) > 0 {
+		s += ", tagged " + strings.Join(p.Tags, " or ")
+	}
+	return s
+}
+
+// Applies reports whether alloc falls under the policy
+func (p *Policy) Applies(alloc *ipam.IPAllocation) bool {
+	if alloc.ReleasedAt != nil || alloc.EndIP != "" || slices.Contains(alloc.Tags, ExemptTag) {
+		return false
+	}
+	if len(p.Tags) == 0 {
+		return true
+	}
+	for _, tag := range p.Tags {
+		if slices.Contains(alloc.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseDuration parses a Go duration or a whole number of days such as 30d
+func ParseDuration(s string) (time.Duration, error) {
+	var d time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return 0, err
+		}
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("duration %q must be positive", s)
+	}
+	return d, nil
+}
+
+// FormatDuration formats d in days when it is a whole number of days
+func FormatDuration(d time.Duration) string {
+	day := 24 * time.Hour
+	if d >= day && d%day == 0 {
+		return strconv.Itoa(int(d/day)) + "d"
+	}
+	return d.String()
+}
+
+// Pending returns when alloc's pending reclamation is due
+func Pending(alloc *ipam.IPAllocation) (time.Time, bool) {
+	for _, tag := range alloc.Tags {
+		if value, ok := strings.CutPrefix(tag, PendingTagPrefix); ok {
+			t, err := time.Parse(time.RFC3339, value)
+			return t, err == nil
+		}
+	}
+	return time.Time{}, false
+}
+
+func withoutPending(tags []string) []string {
+	var out []string
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, PendingTagPrefix) {
+			out = append(out, tag)
+		}
+	}
+	return out
+}
+
+// Event describes a step taken on an allocation
+type Event struct {
+	Type         string     `json:"type"`
+	NetworkID    string     `json:"network_id"`
+	CIDR         string     `json:"cidr"`
+	AllocationID string     `json:"allocation_id"`
+	IP           string     `json:"ip"`
+	Hostname     string     `json:"hostname,omitempty"`
+	LastSeen     *time.Time `json:"last_seen,omitempty"`
+	ReclaimAt    *time.Time `json:"reclaim_at,omitempty"`
+	Time         time.Time  `json:"time"`
+}
+
+func (e *Event) String() string {
+	lastSeen := "never answered"
+	if e.LastSeen != nil {
+		lastSeen = "last seen " + e.LastSeen.Format(time.RFC3339)
+	}
+	switch e.Type {
+	case EventPending:
+		return fmt.Sprintf("%s (%s) will be reclaimed at %s, %s", e.IP, e.CIDR, e.ReclaimAt.Format(time.RFC3339), lastSeen)
+	case EventCancelled:
+		return fmt.Sprintf("%s (%s) is no longer due for reclamation", e.IP, e.CIDR)
+	default:
+		return fmt.Sprintf("%s (%s) reclaimed, %s", e.IP, e.CIDR, lastSeen)
+	}
+}
+
+// Notifier is told about every event
+type Notifier interface {
+	Notify(ctx context.Context, event *Event) error
+}
+
+// Notifiers tells every notifier in turn
+type Notifiers []Notifier
+
+func (n Notifiers) Notify(ctx context.Context, event *Event) error {
+	var errs []error
+	for _, notifier := range n {
+		errs = append(errs, notifier.Notify(ctx, event))
+	}
+	return errors.Join(errs...)
+}
+
+// Webhook posts events as JSON to a URL, signed by Signer when set
+type Webhook struct {
+	URL    string
+	Client *http.Client
+	Signer *webhook.Signer
+}
+
+func (w *Webhook) Notify(ctx context.Context, event *Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := w.Signer.Sign(req, body); err != nil {
+		return err
+	}
+
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Reclaimer applies the reclamation policies of a store's networks
+type Reclaimer struct {
+	Store ipam.Store
+
+	// Release releases an allocation, e.g. through the lifecycle hooks
+	Release func(ctx context.Context, alloc *ipam.IPAllocation) error
+
+	// Notifier, if set, is told about every event
+	Notifier Notifier
+
+	// DryRun reports the events without changing anything
+	DryRun bool
+}
+
+// Reclaim applies every network's policy at now and returns the events.
+// Frozen networks are skipped. A network with an invalid policy does not
+// stop the others; its error is returned with the events.
+func (r *Reclaimer) Reclaim(ctx context.Context, now time.Time) ([]*Event, error) {
+	networks, err := r.Store.ListNetworks()
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*Event
+	var errs []error
+	for _, network := range networks {
+		policy, err := NetworkPolicy(network)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if store.NetworkFreeze(network, now) != nil {
+			continue
+		}
+		networkEvents, err := r.reclaimNetwork(ctx, network, policy, now)
+		events = append(events, networkEvents...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("network %s: %w", network.CIDR, err))
+		}
+	}
+	return events, errors.Join(errs...)
+}
+
+func (r *Reclaimer) reclaimNetwork(ctx context.Context, network *ipam.Network, policy *Policy, now time.Time) ([]*Event, error) {
+	allocations, err := r.Store.ListAllocations(network.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pending marks outlive a removed policy so they can be cleared
+	due := make(map[string]health.Candidate)
+	if policy != nil {
+		var applicable []*ipam.IPAllocation
+		for _, alloc := range allocations {
+			if policy.Applies(alloc) {
+				applicable = append(applicable, alloc)
+			}
+		}
+		for _, c := range health.Candidates(applicable, now, policy.After) {
+			due[c.Allocation.ID] = c
+		}
+	}
+
+	var events []*Event
+	for _, alloc := range allocations {
+		if alloc.ReleasedAt != nil {
+			continue
+		}
+		reclaimAt, pending := Pending(alloc)
+		candidate, isDue := due[alloc.ID]
+
+		event := &Event{
+			NetworkID:    network.ID,
+			CIDR:         network.CIDR,
+			AllocationID: alloc.ID,
+			IP:           alloc.IP,
+			Hostname:     alloc.Hostname,
+			LastSeen:     candidate.LastSeen,
+			Time:         now,
+		}
+		switch {
+		case isDue && !pending:
+			at := now.Add(policy.Grace)
+			event.Type, event.ReclaimAt = EventPending, &at
+			if !r.DryRun {
+				alloc.Tags = append(withoutPending(alloc.Tags), PendingTagPrefix+at.UTC().Format(time.RFC3339))
+				err = r.Store.SaveAllocation(alloc)
+			}
+		case isDue && !now.Before(reclaimAt):
+			event.Type, event.ReclaimAt = EventReleased, &reclaimAt
+			if !r.DryRun {
+				err = r.Release(ctx, alloc)
+			}
+		case !isDue && pending:
+			event.Type = EventCancelled
+			if !r.DryRun {
+				alloc.Tags = withoutPending(alloc.Tags)
+				err = r.Store.SaveAllocation(alloc)
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return events, fmt.Errorf("%s %s: %w", event.Type, alloc.IP, err)
+		}
+
+		events = append(events, event)
+		if r.DryRun {
+			continue
+		}
+		// Stamped when written so the entry follows the allocator's own
+		// release entry
+		r.Store.SaveAuditEntry(&ipam.AuditEntry{
+			ID:        newAuditID(),
+			Timestamp: time.Now(),
+			Action:    event.Type,
+			Resource:  alloc.ID,
+			Details:   fmt.Sprintf("%s (policy: %s)", event, policyString(policy)),
+			User:      auditUser,
+		})
+		if r.Notifier != nil {
+			if err := r.Notifier.Notify(ctx, event); err != nil {
+				log.Printf("reclaim: notification for %s failed: %v", alloc.IP, err)
+			}
+		}
+	}
+	return events, nil
+}
+
+func policyString(p *Policy) string {
+	if p == nil {
+		return "none"
+	}
+	return p.String()
+}
+
+// Run reclaims every interval until stop is closed, logging failures
+func Run(r *Reclaimer, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := r.Reclaim(context.Background(), time.Now()); err != nil {
+				log.Printf("reclaim failed: %v", err)
+			}
+		}
+	}
+}
+
+func newAuditID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,185 @@
+package reclaim
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/health"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/jeremyhahn/go-ipam/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkPolicy(t *testing.T) {
+	p, err := NetworkPolicy(&ipam.Network{Tags: []string{"env=dev"}})
+	require.NoError(t, err)
+	assert.Nil(t, p)
+
+	network := &ipam.Network{CIDR: "10.0.0.0/24", Tags: []string{"env=dev", "reclaim-after=30d", "reclaim-tag=ephemeral"}}
+	p, err = NetworkPolicy(network)
+	require.NoError(t, err)
+	assert.Equal(t, 30*24*time.Hour, p.After)
+	assert.Equal(t, DefaultGrace, p.Grace)
+	assert.Equal(t, []string{"ephemeral"}, p.Tags)
+
+	assert.True(t, p.Applies(&ipam.IPAllocation{Tags: []string{"ephemeral"}}))
+	assert.False(t, p.Applies(&ipam.IPAllocation{Tags: []string{"prod"}}))
+	assert.False(t, p.Applies(&ipam.IPAllocation{Tags: []string{"ephemeral", ExemptTag}}))
+
+	p.Grace = 72 * time.Hour
+	assert.Equal(t, []string{"env=dev", "reclaim-after=30d", "reclaim-grace=3d", "reclaim-tag=ephemeral"}, PolicyTags(network.Tags, p))
+	assert.Equal(t, []string{"env=dev"}, PolicyTags(network.Tags, nil))
+
+	_, err = NetworkPolicy(&ipam.Network{Tags: []string{"reclaim-after=soon"}})
+	assert.Error(t, err)
+	_, err = ParseDuration("-1d")
+	assert.Error(t, err)
+}
+
+// recorder collects notifications
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) Notify(_ context.Context, event *Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event.Type+" "+event.IP)
+	return nil
+}
+
+func TestReclaim(t *testing.T) {
+	dir, err := os.MkdirTemp("", "reclaim-test-*")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	s, err := store.NewPebbleStore(filepath.Join(dir, "db"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-40 * 24 * time.Hour)
+	lastSeen := health.LastSeenTagPrefix + old.Format(time.RFC3339)
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "net1", CIDR: "10.0.0.0/24", Tags: []string{"reclaim-after=30d", "reclaim-grace=2d", "reclaim-tag=ephemeral"}}))
+	for _, alloc := range []*ipam.IPAllocation{
+		{ID: "a1", NetworkID: "net1", IP: "10.0.0.1", AllocatedAt: old, Tags: []string{"ephemeral", lastSeen, health.StaleTag}},
+		{ID: "a2", NetworkID: "net1", IP: "10.0.0.2", AllocatedAt: old, Tags: []string{"prod", lastSeen, health.StaleTag}},
+		{ID: "a3", NetworkID: "net1", IP: "10.0.0.3", AllocatedAt: old, Tags: []string{"ephemeral", health.LastSeenTagPrefix + now.Format(time.RFC3339)}},
+		{ID: "a4", NetworkID: "net1", IP: "10.0.0.4", AllocatedAt: old, Tags: []string{"ephemeral", lastSeen, health.StaleTag}},
+	} {
+		require.NoError(t, s.SaveAllocation(alloc))
+	}
+
+	var released []string
+	notes := &recorder{}
+	r := &Reclaimer{
+		Store: s,
+		Release: func(_ context.Context, alloc *ipam.IPAllocation) error {
+			released = append(released, alloc.IP)
+			t := now
+			alloc.ReleasedAt = &t
+			return s.SaveAllocation(alloc)
+		},
+		Notifier: notes,
+	}
+
+	// A dry run changes nothing
+	r.DryRun = true
+	events, err := r.Reclaim(context.Background(), now)
+	require.NoError(t, err)
+	assert.Len(t, events, 2)
+	a1, err := s.GetAllocation("a1")
+	require.NoError(t, err)
+	_, pending := Pending(a1)
+	assert.False(t, pending)
+	r.DryRun = false
+
+	events, err = r.Reclaim(context.Background(), now)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, EventPending, events[0].Type)
+	assert.Equal(t, now.Add(48*time.Hour), *events[0].ReclaimAt)
+	assert.Equal(t, []string{"reclaim_pending 10.0.0.1", "reclaim_pending 10.0.0.4"}, notes.events)
+
+	// Nothing happens during the grace period
+	events, err = r.Reclaim(context.Background(), now.Add(24*time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	// a4 answers a probe before the grace period ends
+	a4, err := s.GetAllocation("a4")
+	require.NoError(t, err)
+	reclaimAt, pending := Pending(a4)
+	require.True(t, pending)
+	a4.Tags = []string{"ephemeral", health.LastSeenTagPrefix + now.Add(36*time.Hour).Format(time.RFC3339), PendingTagPrefix + reclaimAt.Format(time.RFC3339)}
+	require.NoError(t, s.SaveAllocation(a4))
+
+	later := now.Add(49 * time.Hour)
+	events, err = r.Reclaim(context.Background(), later)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, []string{"10.0.0.1"}, released)
+	assert.Equal(t, []string{"reclaim_pending 10.0.0.1", "reclaim_pending 10.0.0.4", "reclaim_released 10.0.0.1", "reclaim_cancelled 10.0.0.4"}, notes.events)
+
+	a4, err = s.GetAllocation("a4")
+	require.NoError(t, err)
+	_, pending = Pending(a4)
+	assert.False(t, pending)
+
+	entries, err := s.ListAuditEntries(0)
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+	assert.Equal(t, "reclaim", entries[0].User)
+	assert.Equal(t, "reclaim", store.ReleasedBy(s, &ipam.IPAllocation{ID: "a1"}))
+}
+
+func TestReclaimSkipsFrozenNetworks(t *testing.T) {
+	dir, err := os.MkdirTemp("", "reclaim-test-*")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	s, err := store.NewPebbleStore(filepath.Join(dir, "db"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	now := time.Now()
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "net1", CIDR: "10.0.0.0/24", Tags: []string{"reclaim-after=1d", "freeze=change-42"}}))
+	require.NoError(t, s.SaveAllocation(&ipam.IPAllocation{ID: "a1", NetworkID: "net1", IP: "10.0.0.1", AllocatedAt: now.Add(-48 * time.Hour), Tags: []string{health.StaleTag}}))
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "net2", CIDR: "10.0.1.0/24", Tags: []string{"reclaim-after=never"}}))
+
+	r := &Reclaimer{Store: s}
+	events, err := r.Reclaim(context.Background(), now)
+	assert.Error(t, err)
+	assert.Empty(t, events)
+}
+
+func TestWebhook(t *testing.T) {
+	var got Event
+	var verifyErr error
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, verifyErr = webhook.Verify(r, []byte("s3cret"), nil)
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	w := &Webhook{URL: srv.URL}
+	require.NoError(t, w.Notify(context.Background(), &Event{Type: EventPending, IP: "10.0.0.1"}))
+	assert.Equal(t, "10.0.0.1", got.IP)
+	assert.ErrorIs(t, verifyErr, webhook.ErrMissingSignature)
+
+	w.Signer = webhook.NewSigner([]byte("s3cret"))
+	require.NoError(t, w.Notify(context.Background(), &Event{Type: EventPending, IP: "10.0.0.2"}))
+	assert.Equal(t, "10.0.0.2", got.IP)
+	assert.NoError(t, verifyErr)
+
+	srv.Close()
+	assert.Error(t, w.Notify(context.Background(), &Event{Type: EventPending}))
+}
@@ -0,0 +1,233 @@
+// Package reconcile compares recorded IPAM allocations against addresses
+// observed on the network (ARP tables, nmap scans, cloud inventories).
+package reconcile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// Supported observation formats
+const (
+	FormatText    = "text"
+	FormatNmap    = "nmap"
+	FormatMasscan = "masscan"
+	FormatJSON    = "json"
+)
+
+// maxRangeAddresses bounds how many addresses a single range allocation is
+// expanded to when looking for unobserved addresses
+const maxRangeAddresses = 65536
+
+// Report is the result of comparing a network's allocations with observed
+// addresses
+type Report struct {
+	NetworkID  string       `json:"network_id"`
+	CIDR       string       `json:"cidr"`
+	Observed   int          `json:"observed"`
+	Recorded   int          `json:"recorded"`
+	Matched    int          `json:"matched"`
+	Unrecorded []string     `json:"unrecorded"`
+	Unobserved []Unobserved `json:"unobserved"`
+
+	// Discovered lists observed addresses whose allocation was recorded by
+	// a scan import and is still tagged discovered, see Import; they are
+	// not counted as matched
+	Discovered []Unobserved `json:"discovered"`
+}
+
+// Unobserved is an allocated address that was not seen in the observation
+type Unobserved struct {
+	IP           string `json:"ip"`
+	AllocationID string `json:"allocation_id"`
+	Hostname     string `json:"hostname,omitempty"`
+}
+
+// Parse reads observed addresses from r in the given format:
+//
+//   - text: any whitespace, comma or bracket separated tokens that parse as
+//     IP addresses, which covers plain lists, `arp -an` and `ip neigh` output
+//   - nmap, masscan: nmap or masscan XML output (-oX); only hosts
+//     reported up are included, see ParseScan
+//   - json: an array of address strings, or of objects carrying the address
+//     in an "ip", "address", "private_ip" or "ip_address" field
+func Parse(r io.Reader, format string) ([]netip.Addr, error) {
+	switch format {
+	case "", FormatText:
+		return parseText(r)
+	case FormatNmap, FormatMasscan:
+		return parseNmap(r)
+	case FormatJSON:
+		return parseJSON(r)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+func parseText(r io.Reader) ([]netip.Addr, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []netip.Addr
+	fields := strings.FieldsFunc(string(data), func(c rune) bool {
+		return strings.ContainsRune(" \t\r\n,;()[]", c)
+	})
+	for _, field := range fields {
+		if addr, err := netip.ParseAddr(field); err == nil {
+			addrs = append(addrs, addr.Unmap())
+		}
+	}
+	return addrs, nil
+}
+
+func parseNmap(r io.Reader) ([]netip.Addr, error) {
+	hosts, err := ParseScan(r)
+	if err != nil {
+		return nil, err
+	}
+	return Addrs(hosts), nil
+}
+
+func parseJSON(r io.Reader) ([]netip.Addr, error) {
+	var items []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&items); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	var addrs []netip.Addr
+	for i, item := range items {
+		var s string
+		if err := json.Unmarshal(item, &s); err != nil {
+			var obj struct {
+				IP        string `json:"ip"`
+				Address   string `json:"address"`
+				PrivateIP string `json:"private_ip"`
+				IPAddress string `json:"ip_address"`
+			}
+			if err := json.Unmarshal(item, &obj); err != nil {
+				return nil, fmt.Errorf("item %d: expected a string or object", i)
+			}
+			for _, v := range []string{obj.IP, obj.Address, obj.PrivateIP, obj.IPAddress} {
+				if v != "" {
+					s = v
+					break
+				}
+			}
+		}
+		if s == "" {
+			return nil, fmt.Errorf("item %d: no address field", i)
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("item %d: invalid address %q", i, s)
+		}
+		addrs = append(addrs, addr.Unmap())
+	}
+	return addrs, nil
+}
+
+// Compare reports observed addresses inside the network that have no active
+// allocation, or only one a scan import recorded, and actively allocated
+// addresses that were not observed.
+// Observed addresses outside the network are ignored. Released and expired
+// allocations do not count as recorded.
+func Compare(network *ipam.Network, allocations []*ipam.IPAllocation, observed []netip.Addr, now time.Time) (*Report, error) {
+	prefix, err := netip.ParsePrefix(network.CIDR)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network CIDR %q: %w", network.CIDR, err)
+	}
+	prefix = prefix.Masked()
+
+	seen := make(map[netip.Addr]bool)
+	for _, addr := range observed {
+		if prefix.Contains(addr) {
+			seen[addr] = true
+		}
+	}
+
+	report := &Report{
+		NetworkID:  network.ID,
+		CIDR:       network.CIDR,
+		Observed:   len(seen),
+		Unrecorded: []string{},
+		Unobserved: []Unobserved{},
+		Discovered: []Unobserved{},
+	}
+
+	recorded := make(map[netip.Addr]*ipam.IPAllocation)
+	for _, alloc := range allocations {
+		if alloc.ReleasedAt != nil || (alloc.ExpiresAt != nil && alloc.ExpiresAt.Before(now)) {
+			continue
+		}
+
+		first, err := netip.ParseAddr(alloc.IP)
+		if err != nil {
+			return nil, fmt.Errorf("allocation %s: invalid IP %q", alloc.ID, alloc.IP)
+		}
+		last := first
+		if alloc.EndIP != "" {
+			if last, err = netip.ParseAddr(alloc.EndIP); err != nil {
+				return nil, fmt.Errorf("allocation %s: invalid end IP %q", alloc.ID, alloc.EndIP)
+			}
+		}
+
+		n := 0
+		for addr := first.Unmap(); addr.IsValid(); addr = addr.Next() {
+			if n++; n > maxRangeAddresses {
+				return nil, fmt.Errorf("allocation %s: range exceeds %d addresses", alloc.ID, maxRangeAddresses)
+			}
+			recorded[addr] = alloc
+			if !seen[addr] {
+				report.Unobserved = append(report.Unobserved, Unobserved{
+					IP:           addr.String(),
+					AllocationID: alloc.ID,
+					Hostname:     alloc.Hostname,
+				})
+			}
+			if addr == last.Unmap() {
+				break
+			}
+		}
+	}
+	report.Recorded = len(recorded)
+
+	var unrecorded []netip.Addr
+	for addr := range seen {
+		switch alloc := recorded[addr]; {
+		case alloc == nil:
+			unrecorded = append(unrecorded, addr)
+		case slices.Contains(alloc.Tags, DiscoveredTag):
+			report.Discovered = append(report.Discovered, Unobserved{
+				IP:           addr.String(),
+				AllocationID: alloc.ID,
+				Hostname:     alloc.Hostname,
+			})
+		default:
+			report.Matched++
+		}
+	}
+	sort.Slice(unrecorded, func(i, j int) bool { return unrecorded[i].Less(unrecorded[j]) })
+	for _, addr := range unrecorded {
+		report.Unrecorded = append(report.Unrecorded, addr.String())
+	}
+
+	for _, list := range [][]Unobserved{report.Unobserved, report.Discovered} {
+		sort.Slice(list, func(i, j int) bool {
+			a, _ := netip.ParseAddr(list[i].IP)
+			b, _ := netip.ParseAddr(list[j].IP)
+			return a.Less(b)
+		})
+	}
+
+	return report, nil
+}
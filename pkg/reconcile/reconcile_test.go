@@ -0,0 +1,112 @@
+package reconcile
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func addrs(t *testing.T, ss ...string) []netip.Addr {
+	var out []netip.Addr
+	for _, s := range ss {
+		out = append(out, netip.MustParseAddr(s))
+	}
+	return out
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+		want   []string
+	}{
+		{
+			name:   "plain list",
+			format: FormatText,
+			input:  "10.0.0.1\n10.0.0.2, 2001:db8::1\n",
+			want:   []string{"10.0.0.1", "10.0.0.2", "2001:db8::1"},
+		},
+		{
+			name:   "arp -an",
+			format: "",
+			input:  "? (192.168.1.1) at 00:11:22:33:44:55 [ether] on eth0\n? (192.168.1.7) at aa:bb:cc:dd:ee:ff [ether] on eth0\n",
+			want:   []string{"192.168.1.1", "192.168.1.7"},
+		},
+		{
+			name:   "ip neigh",
+			format: FormatText,
+			input:  "10.1.0.254 dev eth0 lladdr 00:11:22:33:44:55 REACHABLE\n",
+			want:   []string{"10.1.0.254"},
+		},
+		{
+			name:   "nmap",
+			format: FormatNmap,
+			input: `<?xml version="1.0"?><nmaprun>
+<host><status state="up"/><address addr="10.0.0.5" addrtype="ipv4"/><address addr="00:11:22:33:44:55" addrtype="mac"/></host>
+<host><status state="down"/><address addr="10.0.0.6" addrtype="ipv4"/></host>
+</nmaprun>`,
+			want: []string{"10.0.0.5"},
+		},
+		{
+			name:   "masscan",
+			format: FormatMasscan,
+			input: `<?xml version="1.0"?><nmaprun scanner="masscan">
+<host endtime="1760616000"><address addr="10.0.0.7" addrtype="ipv4"/><ports><port protocol="tcp" portid="22"><state state="open"/></port></ports></host>
+<host endtime="1760616001"><address addr="10.0.0.7" addrtype="ipv4"/><ports><port protocol="tcp" portid="443"><state state="open"/></port></ports></host>
+</nmaprun>`,
+			want: []string{"10.0.0.7"},
+		},
+		{
+			name:   "json strings and objects",
+			format: FormatJSON,
+			input:  `["10.0.0.1", {"private_ip": "10.0.0.2"}, {"ip": "10.0.0.3"}]`,
+			want:   []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.input), tt.format)
+			require.NoError(t, err)
+			assert.Equal(t, addrs(t, tt.want...), got)
+		})
+	}
+
+	_, err := Parse(strings.NewReader("[]"), "csv")
+	assert.Error(t, err)
+	_, err = Parse(strings.NewReader(`[{"name": "x"}]`), FormatJSON)
+	assert.Error(t, err)
+}
+
+func TestCompare(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	network := &ipam.Network{ID: "net-1", CIDR: "10.0.0.0/24"}
+	allocations := []*ipam.IPAllocation{
+		{ID: "a1", IP: "10.0.0.1", Hostname: "gw"},
+		{ID: "a2", IP: "10.0.0.10", EndIP: "10.0.0.12"},
+		{ID: "a3", IP: "10.0.0.20", ReleasedAt: &past},
+		{ID: "a4", IP: "10.0.0.21", ExpiresAt: &past},
+		{ID: "a5", IP: "10.0.0.30", Hostname: "printer", Tags: []string{DiscoveredTag}},
+	}
+	observed := addrs(t, "10.0.0.1", "10.0.0.11", "10.0.0.20", "10.0.0.30", "10.0.0.99", "10.0.0.1", "192.168.0.1")
+
+	report, err := Compare(network, allocations, observed, now)
+	require.NoError(t, err)
+
+	assert.Equal(t, 5, report.Observed)
+	assert.Equal(t, 5, report.Recorded)
+	assert.Equal(t, 2, report.Matched)
+	assert.Equal(t, []string{"10.0.0.20", "10.0.0.99"}, report.Unrecorded)
+	assert.Equal(t, []Unobserved{
+		{IP: "10.0.0.10", AllocationID: "a2"},
+		{IP: "10.0.0.12", AllocationID: "a2"},
+	}, report.Unobserved)
+	assert.Equal(t, []Unobserved{{IP: "10.0.0.30", AllocationID: "a5", Hostname: "printer"}}, report.Discovered)
+}
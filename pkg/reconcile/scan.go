@@ -0,0 +1,264 @@
+package reconcile
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/health"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// DiscoveredTag marks the allocations Import records for hosts a scan
+// found without one. They hold their addresses like any other allocation,
+// but Compare keeps reporting them until the tag is removed, so that hosts
+// nobody allocated stay visible until someone reviews them.
+const DiscoveredTag = "discovered"
+
+// Host is a host a scan found up
+type Host struct {
+	Addr     netip.Addr
+	Hostname string
+
+	// SeenAt is when the host was scanned; zero when the scan does not say
+	SeenAt time.Time
+}
+
+// nmapRun is the XML output (-oX) of nmap, which masscan also writes
+type nmapRun struct {
+	Start int64 `xml:"start,attr"`
+	Hosts []struct {
+		EndTime int64 `xml:"endtime,attr"`
+		Status  struct {
+			State string `xml:"state,attr"`
+		} `xml:"status"`
+		Addresses []struct {
+			Addr     string `xml:"addr,attr"`
+			AddrType string `xml:"addrtype,attr"`
+		} `xml:"address"`
+		Hostnames []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"hostnames>hostname"`
+	} `xml:"host"`
+	Finished struct {
+		Time int64 `xml:"time,attr"`
+	} `xml:"runstats>finished"`
+}
+
+// ParseScan reads the hosts found up from nmap or masscan XML output (-oX).
+// masscan reports no host status and lists a host once per open port;
+// every listed host counts as up, and each address is returned once.
+func ParseScan(r io.Reader) ([]Host, error) {
+	var run nmapRun
+	if err := xml.NewDecoder(r).Decode(&run); err != nil {
+		return nil, fmt.Errorf("invalid nmap XML: %w", err)
+	}
+
+	var runTime time.Time
+	switch {
+	case run.Finished.Time > 0:
+		runTime = time.Unix(run.Finished.Time, 0).UTC()
+	case run.Start > 0:
+		runTime = time.Unix(run.Start, 0).UTC()
+	}
+
+	var hosts []Host
+	index := make(map[netip.Addr]int)
+	for _, h := range run.Hosts {
+		if h.Status.State != "" && h.Status.State != "up" {
+			continue
+		}
+		seenAt := runTime
+		if h.EndTime > 0 {
+			seenAt = time.Unix(h.EndTime, 0).UTC()
+		}
+		var hostname string
+		for _, name := range h.Hostnames {
+			if hostname = strings.TrimSuffix(name.Name, "."); hostname != "" {
+				break
+			}
+		}
+
+		for _, a := range h.Addresses {
+			if a.AddrType != "ipv4" && a.AddrType != "ipv6" {
+				continue
+			}
+			addr, err := netip.ParseAddr(a.Addr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q in nmap XML", a.Addr)
+			}
+			addr = addr.Unmap()
+
+			i, ok := index[addr]
+			if !ok {
+				index[addr] = len(hosts)
+				hosts = append(hosts, Host{Addr: addr, Hostname: hostname, SeenAt: seenAt})
+				continue
+			}
+			if hosts[i].Hostname == "" {
+				hosts[i].Hostname = hostname
+			}
+			if seenAt.After(hosts[i].SeenAt) {
+				hosts[i].SeenAt = seenAt
+			}
+		}
+	}
+	return hosts, nil
+}
+
+// Addrs returns the addresses of hosts
+func Addrs(hosts []Host) []netip.Addr {
+	addrs := make([]netip.Addr, len(hosts))
+	for i, host := range hosts {
+		addrs[i] = host.Addr
+	}
+	return addrs
+}
+
+// ImportResult is the outcome of importing a scan into a network
+type ImportResult struct {
+	// Scanned counts the hosts found inside the network
+	Scanned int `json:"scanned"`
+
+	// Seen counts the allocations whose last-seen tag the scan moved on
+	Seen int `json:"seen"`
+
+	Discovered []Discovered `json:"discovered"`
+
+	// Skipped lists addresses found up that no allocation may hold, such
+	// as the network and broadcast addresses
+	Skipped []string `json:"skipped"`
+}
+
+// Discovered is an allocation Import recorded for an unknown host
+type Discovered struct {
+	IP           string `json:"ip"`
+	AllocationID string `json:"allocation_id"`
+	Hostname     string `json:"hostname,omitempty"`
+}
+
+// Import brings network in line with the hosts a scan found up. Active
+// single-address allocations of scanned hosts get their last-seen tag
+// updated, as a successful health probe would; ranges are left alone. A
+// host without an active allocation gets a new one tagged discovered and
+// last-seen. Hosts outside the network are ignored, and hosts without a
+// scan time count as seen at now.
+func Import(s ipam.Store, network *ipam.Network, hosts []Host, now time.Time) (*ImportResult, error) {
+	prefix, err := netip.ParsePrefix(network.CIDR)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network CIDR %q: %w", network.CIDR, err)
+	}
+	prefix = prefix.Masked()
+
+	allocations, err := s.ListAllocations(network.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{Discovered: []Discovered{}, Skipped: []string{}}
+	for _, host := range hosts {
+		if !prefix.Contains(host.Addr) {
+			continue
+		}
+		result.Scanned++
+		seenAt := host.SeenAt
+		if seenAt.IsZero() {
+			seenAt = now
+		}
+
+		alloc, err := covering(allocations, host.Addr, now)
+		if err != nil {
+			return result, err
+		}
+		if alloc != nil {
+			if alloc.EndIP != "" {
+				continue
+			}
+			saved, err := markSeen(s, alloc.ID, seenAt)
+			if err != nil {
+				return result, err
+			}
+			if saved {
+				result.Seen++
+			}
+			continue
+		}
+
+		ip, err := store.CheckRequestedIP(network, allocations, host.Addr.String(), now)
+		if errors.Is(err, store.ErrIPOutOfRange) {
+			result.Skipped = append(result.Skipped, host.Addr.String())
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		id, err := store.NewAllocationID()
+		if err != nil {
+			return result, err
+		}
+		alloc = &ipam.IPAllocation{
+			ID:          id,
+			NetworkID:   network.ID,
+			IP:          ip,
+			Hostname:    host.Hostname,
+			Description: "Discovered by network scan",
+			Status:      "allocated",
+			AllocatedAt: now,
+		}
+		alloc.Tags = append([]string{DiscoveredTag}, health.Seen(alloc, seenAt)...)
+		if err := s.SaveAllocation(alloc); err != nil {
+			return result, fmt.Errorf("failed to save allocation for %s: %w", ip, err)
+		}
+		allocations = append(allocations, alloc)
+		result.Discovered = append(result.Discovered, Discovered{IP: ip, AllocationID: alloc.ID, Hostname: alloc.Hostname})
+	}
+	return result, nil
+}
+
+// covering returns the active allocation holding addr, if any
+func covering(allocations []*ipam.IPAllocation, addr netip.Addr, now time.Time) (*ipam.IPAllocation, error) {
+	for _, alloc := range allocations {
+		if store.AllocationStatus(alloc, now) != store.StatusActive {
+			continue
+		}
+		first, err := netip.ParseAddr(alloc.IP)
+		if err != nil {
+			return nil, fmt.Errorf("allocation %s: invalid IP %q", alloc.ID, alloc.IP)
+		}
+		last := first
+		if alloc.EndIP != "" {
+			if last, err = netip.ParseAddr(alloc.EndIP); err != nil {
+				return nil, fmt.Errorf("allocation %s: invalid end IP %q", alloc.ID, alloc.EndIP)
+			}
+		}
+		if !addr.Less(first.Unmap()) && !last.Unmap().Less(addr) {
+			return alloc, nil
+		}
+	}
+	return nil, nil
+}
+
+// markSeen moves the last-seen tag of the allocation id on to seenAt and
+// reports whether it changed
+func markSeen(s ipam.Store, id string, seenAt time.Time) (bool, error) {
+	// Save onto the current record in case it changed meanwhile
+	current, err := s.GetAllocation(id)
+	if err != nil || current.ReleasedAt != nil {
+		return false, nil
+	}
+	tags := health.Seen(current, seenAt)
+	if slices.Equal(tags, current.Tags) {
+		return false, nil
+	}
+	current.Tags = tags
+	if err := s.SaveAllocation(current); err != nil {
+		return false, fmt.Errorf("failed to save allocation %s: %w", id, err)
+	}
+	return true, nil
+}
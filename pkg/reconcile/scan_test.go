@@ -0,0 +1,99 @@
+package reconcile
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/health"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseScan(t *testing.T) {
+	hosts, err := ParseScan(strings.NewReader(`<?xml version="1.0"?>
+<nmaprun scanner="nmap" start="1760612400">
+<host starttime="1760612400" endtime="1760612460"><status state="up"/>
+  <address addr="10.0.0.5" addrtype="ipv4"/><address addr="00:11:22:33:44:55" addrtype="mac"/>
+  <hostnames><hostname name="web01.example.com." type="PTR"/></hostnames>
+</host>
+<host><status state="down"/><address addr="10.0.0.6" addrtype="ipv4"/></host>
+<host><status state="up"/><address addr="10.0.0.7" addrtype="ipv4"/></host>
+<runstats><finished time="1760612500"/></runstats>
+</nmaprun>`))
+	require.NoError(t, err)
+	assert.Equal(t, []Host{
+		{Addr: addrs(t, "10.0.0.5")[0], Hostname: "web01.example.com", SeenAt: time.Unix(1760612460, 0).UTC()},
+		{Addr: addrs(t, "10.0.0.7")[0], SeenAt: time.Unix(1760612500, 0).UTC()},
+	}, hosts)
+
+	// masscan lists a host per open port
+	hosts, err = ParseScan(strings.NewReader(`<?xml version="1.0"?><nmaprun scanner="masscan" start="1760612400">
+<host endtime="1760612401"><address addr="10.0.0.8" addrtype="ipv4"/><ports><port protocol="tcp" portid="22"><state state="open"/></port></ports></host>
+<host endtime="1760612403"><address addr="10.0.0.8" addrtype="ipv4"/><ports><port protocol="tcp" portid="443"><state state="open"/></port></ports></host>
+</nmaprun>`))
+	require.NoError(t, err)
+	assert.Equal(t, []Host{{Addr: addrs(t, "10.0.0.8")[0], SeenAt: time.Unix(1760612403, 0).UTC()}}, hosts)
+
+	_, err = ParseScan(strings.NewReader("10.0.0.1"))
+	assert.Error(t, err)
+}
+
+func TestImport(t *testing.T) {
+	s, err := store.NewPebbleStore(t.TempDir())
+	require.NoError(t, err)
+	defer s.Close()
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	scanned := now.Add(-time.Hour)
+	earlier := health.LastSeenTagPrefix + now.Add(-48*time.Hour).Format(time.RFC3339)
+	later := health.LastSeenTagPrefix + now.Add(-time.Minute).Format(time.RFC3339)
+
+	network := &ipam.Network{ID: "lan", CIDR: "10.0.0.0/24"}
+	require.NoError(t, s.SaveNetwork(network))
+	for _, alloc := range []*ipam.IPAllocation{
+		{ID: "web", NetworkID: "lan", IP: "10.0.0.10", Tags: []string{"prod", earlier, health.StaleTag}},
+		{ID: "db", NetworkID: "lan", IP: "10.0.0.11", Tags: []string{later}},
+		{ID: "pool", NetworkID: "lan", IP: "10.0.0.100", EndIP: "10.0.0.199"},
+	} {
+		require.NoError(t, s.SaveAllocation(alloc))
+	}
+
+	hosts := []Host{
+		{Addr: addrs(t, "10.0.0.10")[0], SeenAt: scanned},
+		{Addr: addrs(t, "10.0.0.11")[0], SeenAt: scanned},
+		{Addr: addrs(t, "10.0.0.150")[0], SeenAt: scanned},
+		{Addr: addrs(t, "10.0.0.42")[0], Hostname: "printer"},
+		{Addr: addrs(t, "10.0.0.255")[0], SeenAt: scanned},
+		{Addr: addrs(t, "192.168.0.1")[0], SeenAt: scanned},
+	}
+	result, err := Import(s, network, hosts, now)
+	require.NoError(t, err)
+	assert.Equal(t, 5, result.Scanned)
+	assert.Equal(t, 1, result.Seen)
+	assert.Equal(t, []string{"10.0.0.255"}, result.Skipped)
+	require.Len(t, result.Discovered, 1)
+	assert.Equal(t, "10.0.0.42", result.Discovered[0].IP)
+	assert.Equal(t, "printer", result.Discovered[0].Hostname)
+
+	// The scan moves last-seen on and clears stale, but never back
+	web, err := s.GetAllocation("web")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"prod", health.LastSeenTagPrefix + scanned.Format(time.RFC3339)}, web.Tags)
+	db, err := s.GetAllocation("db")
+	require.NoError(t, err)
+	assert.Equal(t, []string{later}, db.Tags)
+
+	// Hosts without a scan time were seen at the import
+	discovered, err := s.GetAllocation(result.Discovered[0].AllocationID)
+	require.NoError(t, err)
+	assert.Equal(t, "lan", discovered.NetworkID)
+	assert.Equal(t, []string{DiscoveredTag, health.LastSeenTagPrefix + now.Format(time.RFC3339)}, discovered.Tags)
+
+	// Importing again records nothing new
+	result, err = Import(s, network, hosts, now)
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.Seen)
+	assert.Empty(t, result.Discovered)
+}
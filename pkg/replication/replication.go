@@ -0,0 +1,475 @@
+// Package replication keeps a standby copy of another server's networks and
+// allocations, for disaster recovery in another region.
+//
+// A standby pulls a snapshot of the primary's networks and allocations over
+// its REST API and applies the differences to its own store. Until it is
+// promoted it serves reads only; promotion stops replication for good, so
+// the standby can take over writes. A read-only replica, such as a copy in
+// a branch office, is never promoted.
+package replication
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/snapshot"
+)
+
+// promotedFile records a promotion in the state directory
+const promotedFile = "promoted.json"
+
+// The primary's endpoints a replica pulls: the snapshot, or the two lists
+// from primaries predating it
+const (
+	snapshotPath    = "/api/v1/snapshot"
+	networksPath    = "/api/v1/networks"
+	allocationsPath = "/api/v1/allocations?all=true"
+)
+
+var (
+	// ErrPromoted is returned by Sync once the replica has been promoted
+	ErrPromoted = errors.New("replica has been promoted")
+	// ErrReadOnly is returned by Promote on a read-only replica
+	ErrReadOnly = errors.New("replica is read-only and cannot be promoted")
+
+	// errNotFound is returned by fetch when the primary lacks an endpoint
+	errNotFound = errors.New("not found")
+)
+
+// Status describes a replica
+type Status struct {
+	Primary    string     `json:"primary"`
+	Standby    bool       `json:"standby"`
+	ReadOnly   bool       `json:"read_only,omitempty"`
+	PromotedAt *time.Time `json:"promoted_at,omitempty"`
+
+	// LastSync is when the replica last matched the primary, and
+	// LastAttempt when it last tried to
+	LastSync    *time.Time `json:"last_sync,omitempty"`
+	LastAttempt *time.Time `json:"last_attempt,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+
+	Networks    int `json:"networks"`
+	Allocations int `json:"allocations"`
+}
+
+// Result counts the changes one Sync applied
+type Result struct {
+	Saved   int
+	Deleted int
+}
+
+// Replica pulls a primary server's networks and allocations into Store
+type Replica struct {
+	// Primary is the primary server's base URL, e.g.
+	// https://ipam.us-east.example.com:8443
+	Primary string
+
+	// Token is sent as a bearer token when the primary requires one
+	Token string
+
+	Store  ipam.Store
+	Client *http.Client
+
+	// Active, when set, limits syncing to when it returns true, e.g. on a
+	// standby cluster's Raft leader
+	Active func() bool
+
+	// StateDir, when set, records a promotion so it survives restarts
+	StateDir string
+
+	// ReadOnly refuses promotion, for a copy that must never take writes
+	ReadOnly bool
+
+	// MaxAge, when set, is how long after the last sync the replica is
+	// Stale
+	MaxAge time.Duration
+
+	syncMu sync.Mutex // Held while syncing
+	mu     sync.Mutex // Guards the fields below
+	status Status
+	etags  map[string]string
+	lists  bool          // The primary has no snapshot endpoint
+	done   chan struct{} // Closed on promotion
+}
+
+// PromotedAt reports when the replica whose state is kept in dir was
+// promoted, if it was
+func PromotedAt(dir string) (time.Time, bool) {
+	data, err := os.ReadFile(filepath.Join(dir, promotedFile))
+	if err != nil {
+		return time.Time{}, false
+	}
+	var s Status
+	if err := json.Unmarshal(data, &s); err != nil || s.PromotedAt == nil {
+		return time.Time{}, false
+	}
+	return *s.PromotedAt, true
+}
+
+// Standby reports whether the replica still follows the primary, so writes
+// must go to the primary
+func (r *Replica) Standby() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.PromotedAt == nil
+}
+
+// Promoted returns a channel closed when the replica is promoted
+func (r *Replica) Promoted() <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.doneLocked()
+}
+
+func (r *Replica) doneLocked() chan struct{} {
+	if r.done == nil {
+		r.done = make(chan struct{})
+	}
+	return r.done
+}
+
+// Status returns the replica's current status
+func (r *Replica) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.status
+	s.Primary = r.Primary
+	s.Standby = s.PromotedAt == nil
+	s.ReadOnly = r.ReadOnly
+	return s
+}
+
+// Age returns how long ago the replica last matched the primary, and false
+// when it has not since starting
+func (r *Replica) Age(now time.Time) (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.status.LastSync == nil {
+		return 0, false
+	}
+	return now.Sub(*r.status.LastSync), true
+}
+
+// Stale reports whether a standby has not matched the primary within
+// MaxAge, counting one that has not synced since starting as stale
+func (r *Replica) Stale(now time.Time) bool {
+	if r.MaxAge <= 0 || !r.Standby() {
+		return false
+	}
+	age, ok := r.Age(now)
+	return !ok || age > r.MaxAge
+}
+
+// Promote stops replication for good, so the replica can accept writes. It
+// waits for a sync in progress to finish, and records the promotion in
+// StateDir, so a restart does not resume following a primary that may come
+// back with stale data.
+func (r *Replica) Promote(now time.Time) (Status, error) {
+	r.syncMu.Lock()
+	defer r.syncMu.Unlock()
+
+	if !r.Standby() {
+		return r.Status(), nil
+	}
+	if r.ReadOnly {
+		return Status{}, ErrReadOnly
+	}
+	at := now.UTC()
+	s := r.Status()
+	s.Standby = false
+	s.PromotedAt = &at
+	if r.StateDir != "" {
+		data, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return Status{}, err
+		}
+		if err := os.WriteFile(filepath.Join(r.StateDir, promotedFile), data, 0o640); err != nil {
+			return Status{}, fmt.Errorf("failed to record promotion: %w", err)
+		}
+	}
+
+	r.mu.Lock()
+	r.status.PromotedAt = &at
+	close(r.doneLocked())
+	r.mu.Unlock()
+	return s, nil
+}
+
+// Sync pulls the primary's networks and allocations once and applies the
+// differences to Store. Nothing is written when they did not change since
+// the last Sync.
+func (r *Replica) Sync(now time.Time) (Result, error) {
+	r.syncMu.Lock()
+	defer r.syncMu.Unlock()
+	if !r.Standby() {
+		return Result{}, ErrPromoted
+	}
+
+	result, networks, allocations, err := r.sync()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	at := now.UTC()
+	r.status.LastAttempt = &at
+	if err != nil {
+		r.status.LastError = err.Error()
+		return result, err
+	}
+	r.status.LastError = ""
+	r.status.LastSync = &at
+	if networks >= 0 {
+		r.status.Networks, r.status.Allocations = networks, allocations
+	}
+	return result, nil
+}
+
+// sync does the work of Sync, returning the number of networks and
+// allocations replicated, or -1 when nothing changed
+func (r *Replica) sync() (Result, int, int, error) {
+	if !r.pullsLists() {
+		var snap snapshot.Snapshot
+		changed, err := r.fetch(snapshotPath, &snap, true)
+		switch {
+		case errors.Is(err, errNotFound):
+			log.Printf("%s serves no snapshot; replicating its network and allocation lists", r.Primary)
+			r.mu.Lock()
+			r.lists = true
+			r.mu.Unlock()
+		case err != nil:
+			return Result{}, 0, 0, fmt.Errorf("failed to fetch snapshot: %w", err)
+		case !changed:
+			return Result{}, -1, -1, nil
+		default:
+			return r.apply(snap.Networks, snap.Allocations)
+		}
+	}
+
+	var networks []*ipam.Network
+	networksChanged, err := r.fetch(networksPath, &networks, true)
+	if err != nil {
+		return Result{}, 0, 0, fmt.Errorf("failed to fetch networks: %w", err)
+	}
+	var allocations []*ipam.IPAllocation
+	allocationsChanged, err := r.fetch(allocationsPath, &allocations, true)
+	if err != nil {
+		return Result{}, 0, 0, fmt.Errorf("failed to fetch allocations: %w", err)
+	}
+	if !networksChanged && !allocationsChanged {
+		return Result{}, -1, -1, nil
+	}
+
+	// Applying needs both lists, so fetch the unchanged one in full
+	if !networksChanged {
+		if _, err := r.fetch(networksPath, &networks, false); err != nil {
+			return Result{}, 0, 0, fmt.Errorf("failed to fetch networks: %w", err)
+		}
+	}
+	if !allocationsChanged {
+		if _, err := r.fetch(allocationsPath, &allocations, false); err != nil {
+			return Result{}, 0, 0, fmt.Errorf("failed to fetch allocations: %w", err)
+		}
+	}
+
+	return r.apply(networks, allocations)
+}
+
+// apply applies networks and allocations to Store, returning how many
+// there are
+func (r *Replica) apply(networks []*ipam.Network, allocations []*ipam.IPAllocation) (Result, int, int, error) {
+	result, err := Apply(r.Store, networks, allocations)
+	if err != nil {
+		// Apply everything again next time
+		r.setETags(nil)
+		return result, 0, 0, err
+	}
+	return result, len(networks), len(allocations), nil
+}
+
+func (r *Replica) pullsLists() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lists
+}
+
+// fetch decodes the primary's response to a GET of path into v. With
+// conditional set it reports false, leaving v alone, when the response has
+// not changed since the last fetch.
+func (r *Replica) fetch(path string, v interface{}, conditional bool) (bool, error) {
+	url := strings.TrimSuffix(r.Primary, "/") + path
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return false, err
+	}
+	if r.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.Token)
+	}
+	if etag := r.etag(path); conditional && etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return false, nil
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return false, fmt.Errorf("GET %s: %w", url, errNotFound)
+	default:
+		return false, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return false, fmt.Errorf("GET %s: %w", url, err)
+	}
+
+	r.mu.Lock()
+	if r.etags == nil {
+		r.etags = map[string]string{}
+	}
+	r.etags[path] = resp.Header.Get("ETag")
+	r.mu.Unlock()
+	return true, nil
+}
+
+func (r *Replica) etag(path string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.etags[path]
+}
+
+func (r *Replica) setETags(etags map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.etags = etags
+}
+
+// Apply makes s hold exactly networks and allocations, writing only the
+// entries that differ
+func Apply(s ipam.Store, networks []*ipam.Network, allocations []*ipam.IPAllocation) (Result, error) {
+	var result Result
+
+	localNetworks, err := s.ListNetworks()
+	if err != nil {
+		return result, fmt.Errorf("failed to list networks: %w", err)
+	}
+	var localAllocations []*ipam.IPAllocation
+	for _, network := range localNetworks {
+		list, err := s.ListAllocations(network.ID)
+		if err != nil {
+			return result, fmt.Errorf("failed to list allocations: %w", err)
+		}
+		localAllocations = append(localAllocations, list...)
+	}
+
+	wantNetworks := map[string]*ipam.Network{}
+	for _, network := range networks {
+		wantNetworks[network.ID] = network
+	}
+	wantAllocations := map[string]*ipam.IPAllocation{}
+	for _, alloc := range allocations {
+		wantAllocations[alloc.ID] = alloc
+	}
+
+	// Delete first: an allocation that moved, or a network recreated with
+	// the same CIDR, must not collide with its old index entries
+	haveAllocations := map[string]*ipam.IPAllocation{}
+	for _, alloc := range localAllocations {
+		want, ok := wantAllocations[alloc.ID]
+		if ok && want.NetworkID == alloc.NetworkID && want.IP == alloc.IP {
+			haveAllocations[alloc.ID] = alloc
+			continue
+		}
+		if err := s.DeleteAllocation(alloc.ID); err != nil {
+			return result, fmt.Errorf("failed to delete allocation %s: %w", alloc.ID, err)
+		}
+		result.Deleted++
+	}
+	haveNetworks := map[string]*ipam.Network{}
+	for _, network := range localNetworks {
+		want, ok := wantNetworks[network.ID]
+		if ok && want.CIDR == network.CIDR {
+			haveNetworks[network.ID] = network
+			continue
+		}
+		if err := s.DeleteNetwork(network.ID); err != nil {
+			return result, fmt.Errorf("failed to delete network %s: %w", network.ID, err)
+		}
+		result.Deleted++
+	}
+
+	for _, network := range networks {
+		if have, ok := haveNetworks[network.ID]; ok && equal(have, network) {
+			continue
+		}
+		if err := s.SaveNetwork(network); err != nil {
+			return result, fmt.Errorf("failed to save network %s: %w", network.ID, err)
+		}
+		result.Saved++
+	}
+	for _, alloc := range allocations {
+		if have, ok := haveAllocations[alloc.ID]; ok && equal(have, alloc) {
+			continue
+		}
+		if err := s.SaveAllocation(alloc); err != nil {
+			return result, fmt.Errorf("failed to save allocation %s: %w", alloc.ID, err)
+		}
+		result.Saved++
+	}
+	return result, nil
+}
+
+// equal compares two entries as the API serializes them
+func equal(a, b interface{}) bool {
+	x, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	y, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return string(x) == string(y)
+}
+
+// Run syncs every interval until stop is closed or the replica is promoted
+func Run(r *Replica, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if r.Active == nil || r.Active() {
+			result, err := r.Sync(time.Now())
+			switch {
+			case errors.Is(err, ErrPromoted):
+				return
+			case err != nil:
+				log.Printf("replication from %s failed: %v", r.Primary, err)
+			case result.Saved > 0 || result.Deleted > 0:
+				log.Printf("Replicated from %s: %d saved, %d deleted", r.Primary, result.Saved, result.Deleted)
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package replication
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/snapshot"
+	"github.com/jeremyhahn/go-ipam/pkg/store/pebbletest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePrimary serves networks and allocations like the REST API, with
+// ETags, counting the full responses. Without snapshots set it predates the
+// snapshot endpoint.
+type fakePrimary struct {
+	networks    []*ipam.Network
+	allocations []*ipam.IPAllocation
+	token       string
+	snapshots   bool
+	full        atomic.Int32
+	requests    atomic.Int32
+}
+
+func (p *fakePrimary) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if p.token != "" && r.Header.Get("Authorization") != "Bearer "+p.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	p.requests.Add(1)
+	var v interface{}
+	switch r.URL.Path {
+	case "/api/v1/snapshot":
+		if !p.snapshots {
+			http.NotFound(w, r)
+			return
+		}
+		v = &snapshot.Snapshot{Networks: p.networks, Allocations: p.allocations}
+	case "/api/v1/networks":
+		v = p.networks
+	case "/api/v1/allocations":
+		v = p.allocations
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	data, _ := json.Marshal(v)
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	p.full.Add(1)
+	w.Write(data)
+}
+
+func TestSync(t *testing.T) {
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	primary := &fakePrimary{
+		token: "secret",
+		networks: []*ipam.Network{
+			{ID: "net-1", CIDR: "10.0.0.0/24", CreatedAt: now, UpdatedAt: now},
+			{ID: "net-2", CIDR: "10.0.1.0/24", CreatedAt: now, UpdatedAt: now},
+		},
+		allocations: []*ipam.IPAllocation{
+			{ID: "alloc-1", NetworkID: "net-1", IP: "10.0.0.1", Hostname: "web-1", Status: "allocated", AllocatedAt: now},
+			{ID: "alloc-2", NetworkID: "net-2", IP: "10.0.1.1", Hostname: "db-1", Status: "allocated", AllocatedAt: now},
+		},
+	}
+	ts := httptest.NewServer(primary)
+	defer ts.Close()
+
+	s := pebbletest.New(t)
+	// Present only on the standby
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "net-old", CIDR: "10.9.0.0/24"}))
+
+	r := &Replica{Primary: ts.URL + "/", Token: "secret", Store: s}
+	result, err := r.Sync(now)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Saved: 4, Deleted: 1}, result)
+
+	networks, err := s.ListNetworks()
+	require.NoError(t, err)
+	assert.Len(t, networks, 2)
+	alloc, err := s.GetAllocation("alloc-2")
+	require.NoError(t, err)
+	assert.Equal(t, "db-1", alloc.Hostname)
+
+	status := r.Status()
+	assert.True(t, status.Standby)
+	assert.Equal(t, 2, status.Networks)
+	assert.Equal(t, 2, status.Allocations)
+	assert.Equal(t, now, *status.LastSync)
+
+	// Unchanged lists are neither downloaded nor applied again
+	full := primary.full.Load()
+	result, err = r.Sync(now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, Result{}, result)
+	assert.Equal(t, full, primary.full.Load())
+
+	// Only the differences are written
+	released := now.Add(time.Hour)
+	primary.allocations = []*ipam.IPAllocation{
+		primary.allocations[0],
+		{ID: "alloc-2", NetworkID: "net-2", IP: "10.0.1.1", Hostname: "db-1", Status: "released", AllocatedAt: now, ReleasedAt: &released},
+		{ID: "alloc-3", NetworkID: "net-2", IP: "10.0.1.2", Status: "allocated", AllocatedAt: now},
+	}
+	result, err = r.Sync(now.Add(2 * time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, Result{Saved: 2}, result)
+	alloc, err = s.GetAllocation("alloc-2")
+	require.NoError(t, err)
+	assert.Equal(t, "released", alloc.Status)
+
+	// Deleted networks are deleted with their allocations
+	primary.networks = primary.networks[:1]
+	primary.allocations = primary.allocations[:1]
+	result, err = r.Sync(now.Add(2 * time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, Result{Deleted: 3}, result)
+	_, err = s.GetNetwork("net-2")
+	assert.Error(t, err)
+
+	// A failing primary is reported in the status
+	r.Token = "wrong"
+	_, err = r.Sync(now.Add(3 * time.Minute))
+	assert.ErrorContains(t, err, "401")
+	status = r.Status()
+	assert.Contains(t, status.LastError, "401")
+	assert.Equal(t, now.Add(2*time.Minute), *status.LastSync)
+	assert.Equal(t, now.Add(3*time.Minute), *status.LastAttempt)
+}
+
+func TestSyncSnapshot(t *testing.T) {
+	primary := &fakePrimary{
+		snapshots:   true,
+		networks:    []*ipam.Network{{ID: "net-1", CIDR: "10.0.0.0/24"}},
+		allocations: []*ipam.IPAllocation{{ID: "alloc-1", NetworkID: "net-1", IP: "10.0.0.1", Status: "allocated"}},
+	}
+	ts := httptest.NewServer(primary)
+	defer ts.Close()
+
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	s := pebbletest.New(t)
+	r := &Replica{Primary: ts.URL, Store: s, MaxAge: time.Hour}
+	assert.True(t, r.Stale(now), "stale until synced")
+
+	// One request per sync
+	result, err := r.Sync(now)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Saved: 2}, result)
+	assert.Equal(t, int32(1), primary.requests.Load())
+	_, err = s.GetAllocation("alloc-1")
+	require.NoError(t, err)
+
+	result, err = r.Sync(now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, Result{}, result)
+	assert.Equal(t, int32(2), primary.requests.Load())
+	assert.Equal(t, int32(1), primary.full.Load())
+
+	age, ok := r.Age(now.Add(31 * time.Minute))
+	require.True(t, ok)
+	assert.Equal(t, 30*time.Minute, age)
+	assert.False(t, r.Stale(now.Add(time.Hour)))
+	assert.True(t, r.Stale(now.Add(2*time.Hour)))
+
+	// A primary predating snapshots is replicated from its lists
+	primary.snapshots = false
+	r = &Replica{Primary: ts.URL, Store: pebbletest.New(t)}
+	_, err = r.Sync(now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, r.Status().Allocations+r.Status().Networks)
+	before := primary.requests.Load()
+	_, err = r.Sync(now)
+	require.NoError(t, err)
+	assert.Equal(t, before+2, primary.requests.Load(), "the snapshot is not asked for again")
+}
+
+func TestApplyMovedAllocation(t *testing.T) {
+	s := pebbletest.New(t)
+	networks := []*ipam.Network{{ID: "net-1", CIDR: "10.0.0.0/24"}, {ID: "net-2", CIDR: "10.0.1.0/24"}}
+	_, err := Apply(s, networks, []*ipam.IPAllocation{{ID: "alloc-1", NetworkID: "net-1", IP: "10.0.0.5", Status: "allocated"}})
+	require.NoError(t, err)
+
+	result, err := Apply(s, networks, []*ipam.IPAllocation{{ID: "alloc-1", NetworkID: "net-2", IP: "10.0.1.5", Status: "allocated"}})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Saved: 1, Deleted: 1}, result)
+
+	_, err = s.GetAllocationByIP("net-1", "10.0.0.5")
+	assert.Error(t, err, "the old address is free on the standby too")
+	alloc, err := s.GetAllocationByIP("net-2", "10.0.1.5")
+	require.NoError(t, err)
+	assert.Equal(t, "alloc-1", alloc.ID)
+}
+
+func TestPromote(t *testing.T) {
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	r := &Replica{Primary: "http://127.0.0.1:1", Store: pebbletest.New(t), StateDir: dir}
+
+	_, ok := PromotedAt(dir)
+	assert.False(t, ok)
+	select {
+	case <-r.Promoted():
+		t.Fatal("promoted before Promote")
+	default:
+	}
+
+	status, err := r.Promote(now)
+	require.NoError(t, err)
+	assert.False(t, status.Standby)
+	assert.Equal(t, now, *status.PromotedAt)
+	assert.False(t, r.Standby())
+	<-r.Promoted()
+
+	at, ok := PromotedAt(dir)
+	require.True(t, ok, "the promotion survives a restart")
+	assert.Equal(t, now, at)
+
+	// Promoting again keeps the first promotion
+	status, err = r.Promote(now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, now, *status.PromotedAt)
+
+	_, err = r.Sync(now)
+	assert.ErrorIs(t, err, ErrPromoted)
+
+	// A read-only replica stays a standby
+	readOnly := &Replica{Primary: "http://127.0.0.1:1", Store: pebbletest.New(t), ReadOnly: true}
+	_, err = readOnly.Promote(now)
+	assert.ErrorIs(t, err, ErrReadOnly)
+	assert.True(t, readOnly.Standby())
+	assert.True(t, readOnly.Status().ReadOnly)
+
+	// Run returns once promoted
+	done := make(chan struct{})
+	go func() {
+		Run(r, time.Millisecond, nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after promotion")
+	}
+}
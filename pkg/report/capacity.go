@@ -0,0 +1,211 @@
+// Package report summarizes address capacity across networks, for
+// dashboards and management reviews.
+//
+// Networks are grouped by label, e.g. site=ams or tenant=acme tags. Each
+// network's soft quota is its notify-threshold utilization (see package
+// notify): reaching it is reported, but allocations are not refused.
+package report
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/notify"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// DefaultGroupBy are the labels networks are grouped by when none are given
+var DefaultGroupBy = []string{"site", "tenant"}
+
+// GroupByTag groups networks by each of their tags instead of by the
+// value of one label. A network with several tags counts in the group of
+// each, so unlike label groups the tag groups need not add up to the totals.
+const GroupByTag = "tag"
+
+// DefaultTop is how many nearly-full networks are listed when not given
+const DefaultTop = 10
+
+// StatsSource computes a network's utilization; *ipam.IPAM is one
+type StatsSource interface {
+	GetNetworkStats(networkID string) (*ipam.NetworkStats, error)
+}
+
+// Usage adds up the utilization of a set of networks
+type Usage struct {
+	Networks           int     `json:"networks"`
+	TotalIPs           uint64  `json:"total_ips"`
+	AllocatedIPs       uint64  `json:"allocated_ips"`
+	AvailableIPs       uint64  `json:"available_ips"`
+	ReservedIPs        uint64  `json:"reserved_ips"`
+	UtilizationPercent float64 `json:"utilization_percent"`
+
+	// OverQuota counts the networks at or above their soft quota
+	OverQuota int `json:"over_quota"`
+}
+
+// Group is the usage of the networks sharing a label value. Networks
+// without the label form the group with an empty value, so the groups of
+// one label add up to the totals.
+type Group struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+	Usage
+}
+
+// NetworkUsage is one network's utilization and soft quota
+type NetworkUsage struct {
+	*ipam.NetworkStats
+	CIDR         string   `json:"cidr"`
+	Description  string   `json:"description,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	QuotaPercent float64  `json:"quota_percent"`
+	OverQuota    bool     `json:"over_quota"`
+}
+
+// Capacity is the capacity report of a set of networks
+type Capacity struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Totals      Usage     `json:"totals"`
+	Groups      []*Group  `json:"groups"`
+
+	// NearlyFull lists the most utilized networks, fullest first
+	NearlyFull []*NetworkUsage `json:"nearly_full"`
+}
+
+// Options selects how a capacity report is broken down
+type Options struct {
+	GroupBy []string // Label keys; nil means DefaultGroupBy
+	Top     int      // Nearly-full networks listed; 0 means DefaultTop
+}
+
+// BuildCapacity reports the capacity of networks as of now
+func BuildCapacity(source StatsSource, networks []*ipam.Network, opts Options, now time.Time) (*Capacity, error) {
+	usages, err := networkUsages(source, networks)
+	if err != nil {
+		return nil, err
+	}
+	return summarize(usages, opts, now), nil
+}
+
+// networkUsages reads the utilization of each network
+func networkUsages(source StatsSource, networks []*ipam.Network) ([]*NetworkUsage, error) {
+	usages := make([]*NetworkUsage, 0, len(networks))
+	for _, network := range networks {
+		stats, err := source.GetNetworkStats(network.ID)
+		if err != nil {
+			return nil, err
+		}
+		quota := notify.Threshold(network)
+		usages = append(usages, &NetworkUsage{
+			NetworkStats: stats,
+			CIDR:         network.CIDR,
+			Description:  network.Description,
+			Tags:         network.Tags,
+			QuotaPercent: quota,
+			OverQuota:    stats.UtilizationPercent >= quota,
+		})
+	}
+	return usages, nil
+}
+
+// summarize totals and groups usages and picks the fullest networks
+func summarize(usages []*NetworkUsage, opts Options, now time.Time) *Capacity {
+	groupBy := opts.GroupBy
+	if groupBy == nil {
+		groupBy = DefaultGroupBy
+	}
+	top := opts.Top
+	if top <= 0 {
+		top = DefaultTop
+	}
+
+	c := &Capacity{GeneratedAt: now, Groups: []*Group{}}
+	for _, u := range usages {
+		c.Totals.add(u)
+	}
+
+	for _, label := range groupBy {
+		c.Groups = append(c.Groups, group(usages, label)...)
+	}
+
+	fullest := append([]*NetworkUsage(nil), usages...)
+	sort.SliceStable(fullest, func(i, j int) bool {
+		if fullest[i].UtilizationPercent != fullest[j].UtilizationPercent {
+			return fullest[i].UtilizationPercent > fullest[j].UtilizationPercent
+		}
+		return fullest[i].CIDR < fullest[j].CIDR
+	})
+	c.NearlyFull = fullest[:min(top, len(fullest))]
+	return c
+}
+
+// GroupUsage adds up the utilization of networks per value of label, or
+// per tag for GroupByTag, in value order
+func GroupUsage(source StatsSource, networks []*ipam.Network, label string) ([]*Group, error) {
+	usages, err := networkUsages(source, networks)
+	if err != nil {
+		return nil, err
+	}
+	return group(usages, label), nil
+}
+
+// group groups usages by label, see GroupUsage
+func group(usages []*NetworkUsage, label string) []*Group {
+	groups := map[string]*Group{}
+	for _, u := range usages {
+		var values []string
+		if label == GroupByTag {
+			values = slices.Compact(slices.Sorted(slices.Values(u.Tags)))
+		} else {
+			values = []string{store.LabelsFromTags(u.Tags)[label]}
+		}
+		if len(values) == 0 {
+			values = []string{""}
+		}
+		for _, value := range values {
+			g, ok := groups[value]
+			if !ok {
+				g = &Group{Label: label, Value: value}
+				groups[value] = g
+			}
+			g.add(u)
+		}
+	}
+
+	values := make([]string, 0, len(groups))
+	for value := range groups {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	result := make([]*Group, 0, len(values))
+	for _, value := range values {
+		result = append(result, groups[value])
+	}
+	return result
+}
+
+// add counts network u in the usage
+func (s *Usage) add(u *NetworkUsage) {
+	s.Networks++
+	s.TotalIPs = addCapped(s.TotalIPs, u.TotalIPs)
+	s.AllocatedIPs = addCapped(s.AllocatedIPs, u.AllocatedIPs)
+	s.AvailableIPs = addCapped(s.AvailableIPs, u.AvailableIPs)
+	s.ReservedIPs = addCapped(s.ReservedIPs, u.ReservedIPs)
+	if u.OverQuota {
+		s.OverQuota++
+	}
+	if s.TotalIPs > s.ReservedIPs {
+		s.UtilizationPercent = float64(s.AllocatedIPs) / float64(s.TotalIPs-s.ReservedIPs) * 100
+	}
+}
+
+// addCapped adds address counts, which IPv6 networks can make overflow
+func addCapped(a, b uint64) uint64 {
+	if a > math.MaxUint64-b {
+		return math.MaxUint64
+	}
+	return a + b
+}
@@ -0,0 +1,142 @@
+package report
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStats serves fixed network stats
+type fakeStats map[string]*ipam.NetworkStats
+
+func (f fakeStats) GetNetworkStats(networkID string) (*ipam.NetworkStats, error) {
+	stats, ok := f[networkID]
+	if !ok {
+		return nil, ipam.ErrNetworkNotFound
+	}
+	return stats, nil
+}
+
+func stats(id string, total, allocated uint64) *ipam.NetworkStats {
+	return &ipam.NetworkStats{
+		NetworkID:          id,
+		TotalIPs:           total,
+		AllocatedIPs:       allocated,
+		AvailableIPs:       total - allocated,
+		UtilizationPercent: float64(allocated) / float64(total) * 100,
+	}
+}
+
+func TestBuildCapacity(t *testing.T) {
+	networks := []*ipam.Network{
+		{ID: "a", CIDR: "10.0.0.0/24", Tags: []string{"site=ams", "tenant=acme"}},
+		{ID: "b", CIDR: "10.0.1.0/24", Tags: []string{"site=ams", "notify-threshold=50"}},
+		{ID: "c", CIDR: "10.0.2.0/24", Tags: []string{"site=fra", "tenant=acme"}},
+		{ID: "d", CIDR: "10.0.3.0/24"},
+	}
+	source := fakeStats{
+		"a": stats("a", 100, 95),
+		"b": stats("b", 100, 60),
+		"c": stats("c", 100, 10),
+		"d": stats("d", 100, 0),
+	}
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+
+	c, err := BuildCapacity(source, networks, Options{Top: 2}, now)
+	require.NoError(t, err)
+	assert.Equal(t, now, c.GeneratedAt)
+	assert.Equal(t, Usage{
+		Networks:           4,
+		TotalIPs:           400,
+		AllocatedIPs:       165,
+		AvailableIPs:       235,
+		UtilizationPercent: 41.25,
+		OverQuota:          2,
+	}, c.Totals)
+
+	type row struct {
+		label, value string
+		networks     int
+		allocated    uint64
+		overQuota    int
+	}
+	var rows []row
+	for _, g := range c.Groups {
+		rows = append(rows, row{g.Label, g.Value, g.Networks, g.AllocatedIPs, g.OverQuota})
+	}
+	assert.Equal(t, []row{
+		{"site", "", 1, 0, 0},
+		{"site", "ams", 2, 155, 2},
+		{"site", "fra", 1, 10, 0},
+		{"tenant", "", 2, 60, 1},
+		{"tenant", "acme", 2, 105, 1},
+	}, rows)
+
+	require.Len(t, c.NearlyFull, 2)
+	assert.Equal(t, "a", c.NearlyFull[0].NetworkID)
+	assert.Equal(t, float64(90), c.NearlyFull[0].QuotaPercent)
+	assert.True(t, c.NearlyFull[0].OverQuota)
+	assert.Equal(t, "b", c.NearlyFull[1].NetworkID)
+	assert.Equal(t, float64(50), c.NearlyFull[1].QuotaPercent)
+	assert.True(t, c.NearlyFull[1].OverQuota)
+
+	// An explicit empty grouping reports totals only
+	c, err = BuildCapacity(source, networks, Options{GroupBy: []string{}}, now)
+	require.NoError(t, err)
+	assert.Empty(t, c.Groups)
+	assert.Len(t, c.NearlyFull, 4)
+
+	_, err = BuildCapacity(fakeStats{}, networks, Options{}, now)
+	assert.True(t, errors.Is(err, ipam.ErrNetworkNotFound))
+}
+
+func TestUsageCapped(t *testing.T) {
+	var u Usage
+	u.add(&NetworkUsage{NetworkStats: &ipam.NetworkStats{TotalIPs: math.MaxUint64, AvailableIPs: math.MaxUint64}})
+	u.add(&NetworkUsage{NetworkStats: &ipam.NetworkStats{TotalIPs: 256, AllocatedIPs: 10, AvailableIPs: 246}})
+	assert.Equal(t, uint64(math.MaxUint64), u.TotalIPs)
+	assert.Equal(t, uint64(10), u.AllocatedIPs)
+	assert.Less(t, u.UtilizationPercent, 0.001)
+}
+
+func TestGroupUsage(t *testing.T) {
+	networks := []*ipam.Network{
+		{ID: "a", CIDR: "10.0.0.0/24", Tags: []string{"site=ams", "env=prod"}},
+		{ID: "b", CIDR: "10.0.1.0/24", Tags: []string{"site=ams"}},
+		{ID: "c", CIDR: "10.0.2.0/24"},
+	}
+	source := fakeStats{
+		"a": stats("a", 100, 40),
+		"b": stats("b", 100, 20),
+		"c": stats("c", 100, 10),
+	}
+
+	type row struct {
+		value     string
+		networks  int
+		allocated uint64
+	}
+	rows := func(groups []*Group) []row {
+		var rows []row
+		for _, g := range groups {
+			rows = append(rows, row{g.Value, g.Networks, g.AllocatedIPs})
+		}
+		return rows
+	}
+
+	groups, err := GroupUsage(source, networks, "site")
+	require.NoError(t, err)
+	assert.Equal(t, []row{{"", 1, 10}, {"ams", 2, 60}}, rows(groups))
+	assert.Equal(t, float64(30), groups[1].UtilizationPercent)
+
+	// A network counts in the group of each of its tags
+	groups, err = GroupUsage(source, networks, GroupByTag)
+	require.NoError(t, err)
+	assert.Equal(t, []row{{"", 1, 10}, {"env=prod", 1, 40}, {"site=ams", 2, 60}}, rows(groups))
+	assert.Equal(t, GroupByTag, groups[0].Label)
+}
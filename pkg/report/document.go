@@ -0,0 +1,195 @@
+package report
+
+import (
+	"math"
+	"math/big"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// Defaults of DocumentOptions
+const (
+	DefaultMonths = 6
+	DefaultWindow = 90 * 24 * time.Hour
+	DefaultTitle  = "IP Address Utilization Report"
+)
+
+// DocumentOptions selects what a utilization report covers
+type DocumentOptions struct {
+	Options
+	Title  string        // DefaultTitle when empty
+	Months int           // Months of trend; DefaultMonths when 0
+	Window time.Duration // Period growth is measured over; DefaultWindow when 0
+}
+
+// Document is a utilization and allocation report for management reviews:
+// current capacity, how it developed month by month, and when networks are
+// expected to run out at their recent rate of growth
+type Document struct {
+	Title       string     `json:"title"`
+	GeneratedAt time.Time  `json:"generated_at"`
+	Capacity    *Capacity  `json:"capacity"`
+	Trend       []*Month   `json:"trend"`
+	Forecasts   []Forecast `json:"forecasts"`
+
+	// WindowDays is the period forecasts measure growth over
+	WindowDays int `json:"window_days"`
+}
+
+// Month is the allocation activity of one calendar month and the addresses
+// allocated at its end (or at the report's time, for the current month)
+type Month struct {
+	Start              time.Time `json:"start"`
+	End                time.Time `json:"end"`
+	Allocations        int       `json:"allocations"`
+	Releases           int       `json:"releases"`
+	AllocatedIPs       uint64    `json:"allocated_ips"`
+	UsableIPs          uint64    `json:"usable_ips"`
+	UtilizationPercent float64   `json:"utilization_percent"`
+}
+
+// Forecast is when a growing network is expected to run out of addresses
+type Forecast struct {
+	NetworkID    string    `json:"network_id"`
+	CIDR         string    `json:"cidr"`
+	AvailableIPs uint64    `json:"available_ips"`
+	GrowthPerDay float64   `json:"growth_per_day"`
+	DaysLeft     int       `json:"days_left"`
+	ExhaustsAt   time.Time `json:"exhausts_at"`
+}
+
+// BuildDocument reports on networks as of now, reading their allocation
+// history from s
+func BuildDocument(s ipam.Store, source StatsSource, networks []*ipam.Network, opts DocumentOptions, now time.Time) (*Document, error) {
+	if opts.Title == "" {
+		opts.Title = DefaultTitle
+	}
+	if opts.Months <= 0 {
+		opts.Months = DefaultMonths
+	}
+	if opts.Window <= 0 {
+		opts.Window = DefaultWindow
+	}
+
+	usages, err := networkUsages(source, networks)
+	if err != nil {
+		return nil, err
+	}
+	doc := &Document{
+		Title:       opts.Title,
+		GeneratedAt: now,
+		Capacity:    summarize(usages, opts.Options, now),
+		Trend:       months(now, opts.Months),
+		Forecasts:   []Forecast{},
+		WindowDays:  int(opts.Window / (24 * time.Hour)),
+	}
+
+	for i, network := range networks {
+		stats := usages[i].NetworkStats
+		allocations, err := s.ListAllocations(network.ID)
+		if err != nil {
+			return nil, err
+		}
+		usable := stats.TotalIPs - min(stats.ReservedIPs, stats.TotalIPs)
+		for _, m := range doc.Trend {
+			if !network.CreatedAt.After(m.End) {
+				m.UsableIPs = addCapped(m.UsableIPs, usable)
+			}
+			m.AllocatedIPs = addCapped(m.AllocatedIPs, allocatedAt(allocations, m.End))
+			for _, a := range allocations {
+				if within(a.AllocatedAt, m.Start, m.End) {
+					m.Allocations++
+				}
+				if a.ReleasedAt != nil && within(*a.ReleasedAt, m.Start, m.End) {
+					m.Releases++
+				}
+			}
+		}
+
+		growth := float64(allocatedAt(allocations, now)) - float64(allocatedAt(allocations, now.Add(-opts.Window)))
+		if growth <= 0 {
+			continue
+		}
+		perDay := growth / opts.Window.Hours() * 24
+		days := float64(stats.AvailableIPs) / perDay
+		if days > math.MaxInt32 {
+			continue
+		}
+		doc.Forecasts = append(doc.Forecasts, Forecast{
+			NetworkID:    network.ID,
+			CIDR:         network.CIDR,
+			AvailableIPs: stats.AvailableIPs,
+			GrowthPerDay: perDay,
+			DaysLeft:     int(days),
+			ExhaustsAt:   now.Add(time.Duration(days * float64(24*time.Hour))),
+		})
+	}
+
+	for _, m := range doc.Trend {
+		if m.UsableIPs > 0 {
+			m.UtilizationPercent = float64(m.AllocatedIPs) / float64(m.UsableIPs) * 100
+		}
+	}
+	sort.SliceStable(doc.Forecasts, func(i, j int) bool {
+		return doc.Forecasts[i].DaysLeft < doc.Forecasts[j].DaysLeft
+	})
+	return doc, nil
+}
+
+// months returns the last n calendar months up to now, oldest first. The
+// current month ends at now.
+func months(now time.Time, n int) []*Month {
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	trend := make([]*Month, n)
+	end := now
+	for i := n - 1; i >= 0; i-- {
+		trend[i] = &Month{Start: start, End: end}
+		end = start
+		start = start.AddDate(0, -1, 0)
+	}
+	return trend
+}
+
+// within reports whether t falls in [start, end)
+func within(t, start, end time.Time) bool {
+	return !t.Before(start) && t.Before(end)
+}
+
+// allocatedAt counts the addresses allocated at t
+func allocatedAt(allocations []*ipam.IPAllocation, t time.Time) uint64 {
+	var n uint64
+	for _, a := range allocations {
+		if a.AllocatedAt.After(t) || (a.ReleasedAt != nil && !a.ReleasedAt.After(t)) {
+			continue
+		}
+		n = addCapped(n, addresses(a))
+	}
+	return n
+}
+
+// addresses counts the addresses of a single or range allocation
+func addresses(a *ipam.IPAllocation) uint64 {
+	if a.EndIP == "" {
+		return 1
+	}
+	first, err := netip.ParseAddr(a.IP)
+	if err != nil {
+		return 1
+	}
+	last, err := netip.ParseAddr(a.EndIP)
+	if err != nil {
+		return 1
+	}
+	f, l := first.As16(), last.As16()
+	n := new(big.Int).Sub(new(big.Int).SetBytes(l[:]), new(big.Int).SetBytes(f[:]))
+	if n.Sign() < 0 {
+		return 1
+	}
+	if !n.IsUint64() || n.Uint64() == math.MaxUint64 {
+		return math.MaxUint64
+	}
+	return n.Uint64() + 1
+}
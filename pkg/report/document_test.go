@@ -0,0 +1,133 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStore serves fixed allocations; only ListAllocations is used
+type fakeStore struct {
+	ipam.Store
+	allocations map[string][]*ipam.IPAllocation
+}
+
+func (f *fakeStore) ListAllocations(networkID string) ([]*ipam.IPAllocation, error) {
+	return f.allocations[networkID], nil
+}
+
+func day(month time.Month, d int) time.Time {
+	return time.Date(2024, month, d, 12, 0, 0, 0, time.UTC)
+}
+
+func testDocument(t *testing.T) *Document {
+	released := day(time.March, 10)
+	networks := []*ipam.Network{
+		{ID: "a", CIDR: "10.0.0.0/24", CreatedAt: day(time.January, 1), Tags: []string{"site=ams"}},
+		{ID: "b", CIDR: "10.0.1.0/24", CreatedAt: day(time.March, 1)},
+	}
+	st := &fakeStore{allocations: map[string][]*ipam.IPAllocation{
+		"a": {
+			{ID: "1", IP: "10.0.0.1", EndIP: "10.0.0.10", AllocatedAt: day(time.January, 5)},
+			{ID: "2", IP: "10.0.0.11", AllocatedAt: day(time.February, 5), ReleasedAt: &released},
+			{ID: "3", IP: "10.0.0.12", EndIP: "10.0.0.101", AllocatedAt: day(time.April, 20)},
+		},
+		"b": {
+			{ID: "4", IP: "10.0.1.1", AllocatedAt: day(time.March, 2)},
+		},
+	}}
+	source := fakeStats{"a": stats("a", 200, 100), "b": stats("b", 200, 1)}
+
+	doc, err := BuildDocument(st, source, networks, DocumentOptions{Months: 4, Window: 30 * 24 * time.Hour}, day(time.April, 30))
+	require.NoError(t, err)
+	return doc
+}
+
+func TestBuildDocument(t *testing.T) {
+	doc := testDocument(t)
+	assert.Equal(t, DefaultTitle, doc.Title)
+	assert.Equal(t, 30, doc.WindowDays)
+	assert.Equal(t, 2, doc.Capacity.Totals.Networks)
+
+	type row struct {
+		month                 time.Month
+		allocations, releases int
+		allocated, usable     uint64
+	}
+	var rows []row
+	for _, m := range doc.Trend {
+		rows = append(rows, row{m.Start.Month(), m.Allocations, m.Releases, m.AllocatedIPs, m.UsableIPs})
+	}
+	assert.Equal(t, []row{
+		{time.January, 1, 0, 10, 200},
+		{time.February, 1, 0, 11, 200},
+		{time.March, 1, 1, 11, 400},
+		{time.April, 1, 0, 101, 400},
+	}, rows)
+	assert.InDelta(t, 25.25, doc.Trend[3].UtilizationPercent, 0.001)
+	assert.Equal(t, day(time.April, 30), doc.Trend[3].End)
+
+	// Only network a grew in the window: 90 addresses in 30 days
+	require.Len(t, doc.Forecasts, 1)
+	f := doc.Forecasts[0]
+	assert.Equal(t, "a", f.NetworkID)
+	assert.InDelta(t, 3.0, f.GrowthPerDay, 0.001)
+	assert.Equal(t, 33, f.DaysLeft)
+	assert.WithinDuration(t, day(time.April, 30).Add(100*24*time.Hour/3), f.ExhaustsAt, time.Second)
+}
+
+func TestRender(t *testing.T) {
+	doc := testDocument(t)
+	doc.Title = "Review <Q2>"
+
+	var html bytes.Buffer
+	require.NoError(t, Render(&html, FormatHTML, doc, ""))
+	assert.Contains(t, html.String(), "<title>Review &lt;Q2&gt;</title>")
+	assert.Contains(t, html.String(), "Apr 2024")
+	assert.Contains(t, html.String(), "2024-06-02")
+	assert.Contains(t, html.String(), `style="width: 50.0%"`)
+
+	var pdf bytes.Buffer
+	require.NoError(t, Render(&pdf, FormatPDF, doc, ""))
+	out := pdf.String()
+	assert.True(t, strings.HasPrefix(out, "%PDF-1.4\n"))
+	assert.True(t, strings.HasSuffix(out, "%%EOF\n"))
+	assert.Contains(t, out, "/Title (Review <Q2>)")
+	assert.Contains(t, out, "(EXHAUSTION FORECAST) '")
+	assert.Contains(t, out, "/Count 1")
+
+	var custom bytes.Buffer
+	require.NoError(t, Render(&custom, FormatHTML, doc, `{{range .Forecasts}}{{.CIDR}} {{.DaysLeft}}{{end}}`))
+	assert.Equal(t, "10.0.0.0/24 33", custom.String())
+
+	assert.Error(t, Render(&custom, FormatHTML, doc, `{{.Missing`))
+	assert.Error(t, Render(&custom, "docx", doc, ""))
+}
+
+func TestWritePDF(t *testing.T) {
+	lines := make([]string, pdfLinesPerPage+4)
+	lines[0] = `(a\b) ü €`
+	lines[3] = "\fpage two"
+
+	var buf bytes.Buffer
+	require.NoError(t, writePDF(&buf, "t", lines))
+	out := buf.String()
+	assert.Contains(t, out, `(\(a\\b\) \374 ?) '`)
+	assert.Contains(t, out, "/Count 3")
+	assert.Contains(t, out, "(page two) '")
+
+	// The cross-reference table points at each object
+	xref := strings.Index(out, "xref\n")
+	assert.Contains(t, out, fmt.Sprintf("startxref\n%d\n", xref))
+	for _, obj := range []string{"1 0 obj", "2 0 obj", "3 0 obj", "4 0 obj"} {
+		offset := strings.Index(out, obj)
+		require.Positive(t, offset)
+		assert.Contains(t, out[xref:], fmt.Sprintf("%010d 00000 n", offset))
+	}
+}
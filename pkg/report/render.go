@@ -0,0 +1,81 @@
+package report
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Output formats
+const (
+	FormatHTML = "html"
+	FormatPDF  = "pdf"
+)
+
+// Formats lists the output formats Render supports
+var Formats = []string{FormatHTML, FormatPDF}
+
+//go:embed templates
+var templates embed.FS
+
+// templateFuncs are available to report templates besides the built-ins
+var templateFuncs = map[string]any{
+	"percent":  func(p float64) string { return fmt.Sprintf("%.1f%%", p) },
+	"bar":      func(p float64) string { return fmt.Sprintf("%.1f%%", max(0, min(p, 100))) },
+	"usable":   func(total, reserved uint64) uint64 { return total - min(reserved, total) },
+	"date":     func(t time.Time) string { return t.Format("2006-01-02") },
+	"datetime": func(t time.Time) string { return t.Format("2006-01-02 15:04 MST") },
+	"month":    func(t time.Time) string { return t.Format("Jan 2006") },
+}
+
+// Render writes doc to w in format. An HTML report executes an
+// html/template, a PDF report lays out the text a text/template produces.
+// custom replaces the built-in template when not empty; it is given the
+// Document and the functions percent, bar, usable, date, datetime and month.
+func Render(w io.Writer, format string, doc *Document, custom string) error {
+	switch format {
+	case FormatHTML:
+		text, err := templateText("report.html.tmpl", custom)
+		if err != nil {
+			return err
+		}
+		tmpl, err := htmltemplate.New("report").Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			return fmt.Errorf("invalid template: %w", err)
+		}
+		return tmpl.Execute(w, doc)
+	case FormatPDF:
+		text, err := templateText("report.txt.tmpl", custom)
+		if err != nil {
+			return err
+		}
+		tmpl, err := texttemplate.New("report").Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			return fmt.Errorf("invalid template: %w", err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, doc); err != nil {
+			return err
+		}
+		return writePDF(w, doc.Title, strings.Split(strings.TrimRight(buf.String(), "\n"), "\n"))
+	default:
+		return fmt.Errorf("unsupported format %q (use %s)", format, strings.Join(Formats, " or "))
+	}
+}
+
+// templateText returns custom, or the built-in template name when empty
+func templateText(name, custom string) (string, error) {
+	if custom != "" {
+		return custom, nil
+	}
+	data, err := templates.ReadFile("templates/" + name)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
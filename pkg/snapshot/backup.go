@@ -0,0 +1,116 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// backupPrefix and backupSuffix frame the timestamp in backup file names
+const (
+	backupPrefix = "ipam-backup-"
+	backupSuffix = ".json"
+)
+
+// FromStore exports every network and allocation in s, including released
+// allocations
+func FromStore(s ipam.Store, now time.Time) (*Snapshot, error) {
+	networks, err := s.ListNetworks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+
+	snap := &Snapshot{ExportedAt: now.UTC(), Networks: networks}
+	for _, network := range networks {
+		allocations, err := s.ListAllocations(network.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list allocations: %w", err)
+		}
+		snap.Allocations = append(snap.Allocations, allocations...)
+	}
+	return snap, nil
+}
+
+// Backup writes an export of s to dir as ipam-backup-<time>.json and
+// removes the oldest backups beyond keep. keep 0 keeps every backup. It
+// returns the path written.
+func Backup(s ipam.Store, dir string, keep int, now time.Time) (string, error) {
+	snap, err := FromStore(s, now)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, backupPrefix+now.UTC().Format("20060102T150405Z")+backupSuffix)
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	// Write then rename so a crash never leaves a truncated backup
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	if keep > 0 {
+		backups, err := Backups(dir)
+		if err != nil {
+			return path, err
+		}
+		for len(backups) > keep {
+			if err := os.Remove(backups[0]); err != nil {
+				return path, err
+			}
+			backups = backups[1:]
+		}
+	}
+	return path, nil
+}
+
+// Backups returns the backups in dir, oldest first
+func Backups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix) {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+	}
+	// The timestamps sort lexically
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// RunBackups backs s up every interval until stop is closed, logging
+// failures
+func RunBackups(s ipam.Store, dir string, keep int, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := Backup(s, dir, keep, time.Now()); err != nil {
+				log.Printf("backup failed: %v", err)
+			}
+		}
+	}
+}
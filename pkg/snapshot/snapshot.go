@@ -0,0 +1,217 @@
+// Package snapshot defines the export format of networks and allocations
+// and compares two exports.
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// Snapshot is a point-in-time export of all networks and allocations
+type Snapshot struct {
+	ExportedAt  time.Time            `json:"exported_at"`
+	Networks    []*ipam.Network      `json:"networks"`
+	Allocations []*ipam.IPAllocation `json:"allocations"`
+}
+
+// Read decodes a JSON export
+func Read(r io.Reader) (*Snapshot, error) {
+	var s Snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, fmt.Errorf("invalid export: %w", err)
+	}
+	return &s, nil
+}
+
+// Change classifies a diff entry
+type Change string
+
+const (
+	Added   Change = "added"
+	Removed Change = "removed"
+	Changed Change = "changed"
+)
+
+// FieldChange is one differing field of a changed entry
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// Entry is a network or allocation that differs between two snapshots.
+// Networks are identified by CIDR; allocations by network CIDR and address.
+type Entry struct {
+	Change   Change        `json:"change"`
+	Network  string        `json:"network"`
+	Address  string        `json:"address,omitempty"`
+	Hostname string        `json:"hostname,omitempty"`
+	Fields   []FieldChange `json:"fields,omitempty"`
+}
+
+// Diff lists the differences between two snapshots
+type Diff struct {
+	Networks    []Entry `json:"networks"`
+	Allocations []Entry `json:"allocations"`
+}
+
+// Empty reports whether the snapshots are equivalent
+func (d *Diff) Empty() bool {
+	return len(d.Networks) == 0 && len(d.Allocations) == 0
+}
+
+// Compare returns what changed from old to new. Released allocations are
+// history rather than state and are not compared.
+func Compare(old, new *Snapshot) *Diff {
+	diff := &Diff{Networks: []Entry{}, Allocations: []Entry{}}
+
+	oldNetworks, newNetworks := networksByCIDR(old), networksByCIDR(new)
+	for _, cidr := range unionKeys(oldNetworks, newNetworks) {
+		o, n := oldNetworks[cidr], newNetworks[cidr]
+		switch {
+		case o == nil:
+			diff.Networks = append(diff.Networks, Entry{Change: Added, Network: cidr})
+		case n == nil:
+			diff.Networks = append(diff.Networks, Entry{Change: Removed, Network: cidr})
+		default:
+			fields := compareFields([][3]string{
+				{"description", o.Description, n.Description},
+				{"tags", tagString(o.Tags), tagString(n.Tags)},
+			})
+			if len(fields) > 0 {
+				diff.Networks = append(diff.Networks, Entry{Change: Changed, Network: cidr, Fields: fields})
+			}
+		}
+	}
+
+	oldAllocs, newAllocs := allocationsByKey(old), allocationsByKey(new)
+	for _, key := range unionKeys(oldAllocs, newAllocs) {
+		o, n := oldAllocs[key], newAllocs[key]
+		entry := Entry{Network: key.network, Address: key.address}
+		switch {
+		case o == nil:
+			entry.Change, entry.Hostname = Added, n.Hostname
+		case n == nil:
+			entry.Change, entry.Hostname = Removed, o.Hostname
+		default:
+			entry.Change, entry.Hostname = Changed, n.Hostname
+			entry.Fields = compareFields([][3]string{
+				{"hostname", o.Hostname, n.Hostname},
+				{"description", o.Description, n.Description},
+				{"tags", tagString(o.Tags), tagString(n.Tags)},
+				{"status", o.Status, n.Status},
+				{"expires_at", timeString(o.ExpiresAt), timeString(n.ExpiresAt)},
+			})
+			if len(entry.Fields) == 0 {
+				continue
+			}
+		}
+		diff.Allocations = append(diff.Allocations, entry)
+	}
+
+	return diff
+}
+
+// allocationKey identifies an allocation independently of its ID
+type allocationKey struct {
+	network string
+	address string
+	sortKey netip.Addr
+}
+
+func networksByCIDR(s *Snapshot) map[string]*ipam.Network {
+	m := make(map[string]*ipam.Network, len(s.Networks))
+	for _, network := range s.Networks {
+		m[network.CIDR] = network
+	}
+	return m
+}
+
+func allocationsByKey(s *Snapshot) map[allocationKey]*ipam.IPAllocation {
+	cidrs := make(map[string]string, len(s.Networks))
+	for _, network := range s.Networks {
+		cidrs[network.ID] = network.CIDR
+	}
+
+	m := make(map[allocationKey]*ipam.IPAllocation, len(s.Allocations))
+	for _, alloc := range s.Allocations {
+		if alloc.ReleasedAt != nil {
+			continue
+		}
+		network := cidrs[alloc.NetworkID]
+		if network == "" {
+			network = alloc.NetworkID
+		}
+		address := alloc.IP
+		if alloc.EndIP != "" {
+			address += "-" + alloc.EndIP
+		}
+		addr, _ := netip.ParseAddr(alloc.IP)
+		m[allocationKey{network: network, address: address, sortKey: addr}] = alloc
+	}
+	return m
+}
+
+// unionKeys returns the keys of both maps in a stable order
+func unionKeys[K comparable, V any](a, b map[K]V) []K {
+	keys := make([]K, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keyLess(keys[i], keys[j]) })
+	return keys
+}
+
+func keyLess(a, b any) bool {
+	switch a := a.(type) {
+	case string:
+		return a < b.(string)
+	case allocationKey:
+		b := b.(allocationKey)
+		if a.network != b.network {
+			return a.network < b.network
+		}
+		if a.sortKey != b.sortKey {
+			return a.sortKey.Less(b.sortKey)
+		}
+		return a.address < b.address
+	}
+	return false
+}
+
+func compareFields(fields [][3]string) []FieldChange {
+	var changes []FieldChange
+	for _, f := range fields {
+		if f[1] != f[2] {
+			changes = append(changes, FieldChange{Field: f[0], Old: f[1], New: f[2]})
+		}
+	}
+	return changes
+}
+
+// tagString renders tags in a canonical order so reordering is not a change
+func tagString(tags []string) string {
+	sorted := slices.Clone(tags)
+	slices.Sort(sorted)
+	return strings.Join(sorted, ",")
+}
+
+func timeString(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,94 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompare(t *testing.T) {
+	now := time.Now()
+	old := &Snapshot{
+		Networks: []*ipam.Network{
+			{ID: "n1", CIDR: "10.0.0.0/24", Description: "Servers", Tags: []string{"a", "b"}},
+			{ID: "n2", CIDR: "10.1.0.0/24"},
+		},
+		Allocations: []*ipam.IPAllocation{
+			{ID: "a1", NetworkID: "n1", IP: "10.0.0.10", Hostname: "db01", Status: "allocated"},
+			{ID: "a2", NetworkID: "n1", IP: "10.0.0.11", Hostname: "web01", Status: "allocated"},
+			{ID: "a3", NetworkID: "n1", IP: "10.0.0.12", Status: "released", ReleasedAt: &now},
+		},
+	}
+	new := &Snapshot{
+		Networks: []*ipam.Network{
+			// Different ID and tag order on another instance is not a change
+			{ID: "x1", CIDR: "10.0.0.0/24", Description: "Servers", Tags: []string{"b", "a"}},
+			{ID: "x3", CIDR: "10.2.0.0/24", Description: "New"},
+		},
+		Allocations: []*ipam.IPAllocation{
+			{ID: "b1", NetworkID: "x1", IP: "10.0.0.10", Hostname: "db02", Status: "allocated"},
+			{ID: "b2", NetworkID: "x1", IP: "10.0.0.2", EndIP: "10.0.0.9", Status: "reserved"},
+		},
+	}
+
+	diff := Compare(old, new)
+	assert.False(t, diff.Empty())
+	assert.Equal(t, []Entry{
+		{Change: Removed, Network: "10.1.0.0/24"},
+		{Change: Added, Network: "10.2.0.0/24"},
+	}, diff.Networks)
+	assert.Equal(t, []Entry{
+		{Change: Added, Network: "10.0.0.0/24", Address: "10.0.0.2-10.0.0.9"},
+		{Change: Changed, Network: "10.0.0.0/24", Address: "10.0.0.10", Hostname: "db02",
+			Fields: []FieldChange{{Field: "hostname", Old: "db01", New: "db02"}}},
+		{Change: Removed, Network: "10.0.0.0/24", Address: "10.0.0.11", Hostname: "web01"},
+	}, diff.Allocations)
+
+	assert.True(t, Compare(old, old).Empty())
+}
+
+func TestRead(t *testing.T) {
+	snap, err := Read(strings.NewReader(`{"networks":[{"id":"n1","cidr":"10.0.0.0/24"}],"allocations":[]}`))
+	require.NoError(t, err)
+	require.Len(t, snap.Networks, 1)
+
+	_, err = Read(strings.NewReader("not json"))
+	assert.Error(t, err)
+}
+
+func TestBackup(t *testing.T) {
+	s, err := store.NewPebbleStore(t.TempDir())
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "n1", CIDR: "10.0.0.0/24"}))
+	require.NoError(t, s.SaveAllocation(&ipam.IPAllocation{ID: "a1", NetworkID: "n1", IP: "10.0.0.10", Status: "allocated"}))
+
+	dir := filepath.Join(t.TempDir(), "backups")
+	start := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		_, err := Backup(s, dir, 3, start.Add(time.Duration(i)*time.Hour))
+		require.NoError(t, err)
+	}
+
+	// The oldest backup is pruned
+	backups, err := Backups(dir)
+	require.NoError(t, err)
+	require.Len(t, backups, 3)
+	assert.Equal(t, "ipam-backup-20261016T010000Z.json", filepath.Base(backups[0]))
+
+	f, err := os.Open(backups[2])
+	require.NoError(t, err)
+	defer f.Close()
+	snap, err := Read(f)
+	require.NoError(t, err)
+	assert.Len(t, snap.Networks, 1)
+	assert.Len(t, snap.Allocations, 1)
+	assert.Equal(t, start.Add(3*time.Hour), snap.ExportedAt)
+}
@@ -0,0 +1,231 @@
+package snmp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BER tags of the types SNMP messages carry
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagNull        = 0x05
+	tagOID         = 0x06
+	tagSequence    = 0x30
+	tagGauge32     = 0x42
+
+	tagGetRequest     = 0xa0
+	tagGetNextRequest = 0xa1
+	tagGetResponse    = 0xa2
+	tagSetRequest     = 0xa3
+	tagGetBulkRequest = 0xa5
+
+	// Exceptions in place of a value, SNMPv2c only
+	tagNoSuchObject   = 0x80
+	tagNoSuchInstance = 0x81
+	tagEndOfMibView   = 0x82
+)
+
+var errTruncated = errors.New("truncated BER encoding")
+
+// OID is an object identifier
+type OID []uint32
+
+// ParseOID parses a dotted object identifier such as 1.3.6.1.4.1
+func ParseOID(s string) (OID, error) {
+	parts := strings.Split(strings.TrimPrefix(s, "."), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", s)
+	}
+	oid := make(OID, len(parts))
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q", s)
+		}
+		oid[i] = uint32(n)
+	}
+	if oid[0] > 2 || (oid[0] < 2 && oid[1] >= 40) {
+		return nil, fmt.Errorf("invalid OID %q", s)
+	}
+	return oid, nil
+}
+
+func (o OID) String() string {
+	parts := make([]string, len(o))
+	for i, n := range o {
+		parts[i] = strconv.FormatUint(uint64(n), 10)
+	}
+	return strings.Join(parts, ".")
+}
+
+// compare orders OIDs lexicographically, as GetNext walks them
+func (o OID) compare(other OID) int {
+	for i := 0; i < len(o) && i < len(other); i++ {
+		switch {
+		case o[i] < other[i]:
+			return -1
+		case o[i] > other[i]:
+			return 1
+		}
+	}
+	return len(o) - len(other)
+}
+
+// hasPrefix reports whether o is prefix or below it
+func (o OID) hasPrefix(prefix OID) bool {
+	return len(o) >= len(prefix) && o[:len(prefix)].compare(prefix) == 0
+}
+
+// append returns o followed by sub, leaving o untouched
+func (o OID) append(sub ...uint32) OID {
+	return append(append(make(OID, 0, len(o)+len(sub)), o...), sub...)
+}
+
+// tlv is one decoded BER element
+type tlv struct {
+	tag   byte
+	value []byte
+}
+
+// readTLV decodes the element at the start of b and returns it with the
+// bytes following it
+func readTLV(b []byte) (tlv, []byte, error) {
+	if len(b) < 2 {
+		return tlv{}, nil, errTruncated
+	}
+	tag, n := b[0], int(b[1])
+	b = b[2:]
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 || len(b) < size {
+			return tlv{}, nil, errors.New("unsupported BER length")
+		}
+		n = 0
+		for _, c := range b[:size] {
+			n = n<<8 | int(c)
+		}
+		b = b[size:]
+	}
+	if n < 0 || len(b) < n {
+		return tlv{}, nil, errTruncated
+	}
+	return tlv{tag: tag, value: b[:n]}, b[n:], nil
+}
+
+// expect decodes the element at the start of b, which must have tag
+func expect(b []byte, tag byte) ([]byte, []byte, error) {
+	e, rest, err := readTLV(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if e.tag != tag {
+		return nil, nil, fmt.Errorf("unexpected BER tag 0x%02x, want 0x%02x", e.tag, tag)
+	}
+	return e.value, rest, nil
+}
+
+func decodeInt(b []byte) (int64, error) {
+	if len(b) == 0 || len(b) > 8 {
+		return 0, errors.New("invalid BER integer")
+	}
+	n := int64(int8(b[0]))
+	for _, c := range b[1:] {
+		n = n<<8 | int64(c)
+	}
+	return n, nil
+}
+
+func decodeOID(b []byte) (OID, error) {
+	if len(b) == 0 {
+		return nil, errors.New("empty OID")
+	}
+	var oid OID
+	var n uint64
+	for i, c := range b {
+		n = n<<7 | uint64(c&0x7f)
+		if n > 0xffffffff {
+			return nil, errors.New("OID sub-identifier out of range")
+		}
+		if c&0x80 != 0 {
+			if i == len(b)-1 {
+				return nil, errTruncated
+			}
+			continue
+		}
+		if oid == nil {
+			// The first sub-identifier packs the first two arcs
+			first := min(n/40, 2)
+			oid = OID{uint32(first), uint32(n - 40*first)}
+		} else {
+			oid = append(oid, uint32(n))
+		}
+		n = 0
+	}
+	return oid, nil
+}
+
+// encode returns the BER element of tag holding the concatenated values
+func encode(tag byte, values ...[]byte) []byte {
+	n := 0
+	for _, v := range values {
+		n += len(v)
+	}
+	b := make([]byte, 0, n+6)
+	b = append(b, tag)
+	switch {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n <= 0xff:
+		b = append(b, 0x81, byte(n))
+	case n <= 0xffff:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	for _, v := range values {
+		b = append(b, v...)
+	}
+	return b
+}
+
+func encodeInt(n int64) []byte {
+	b := []byte{byte(n)}
+	for n > 127 || n < -128 {
+		n >>= 8
+		b = append([]byte{byte(n)}, b...)
+	}
+	return encode(tagInteger, b)
+}
+
+func encodeGauge(n uint32) []byte {
+	b := []byte{byte(n)}
+	for n >>= 8; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return encode(tagGauge32, b)
+}
+
+func encodeString(s string) []byte {
+	return encode(tagOctetString, []byte(s))
+}
+
+func encodeOID(oid OID) []byte {
+	if len(oid) < 2 {
+		return encode(tagOID, []byte{0})
+	}
+	var b []byte
+	for _, n := range append(OID{oid[0]*40 + oid[1]}, oid[2:]...) {
+		chunk := []byte{byte(n & 0x7f)}
+		for n >>= 7; n > 0; n >>= 7 {
+			chunk = append([]byte{byte(n&0x7f) | 0x80}, chunk...)
+		}
+		b = append(b, chunk...)
+	}
+	return encode(tagOID, b)
+}
@@ -0,0 +1,489 @@
+// Package snmp is a minimal, read-only SNMP agent publishing the
+// utilization of each network, for monitoring systems that can only poll
+// SNMP.
+//
+// The agent answers SNMPv1 and SNMPv2c Get, GetNext and GetBulk requests
+// carrying its community; other requests are dropped, and Set is refused.
+// Below its base OID, by default the NET-SNMP playpen
+// 1.3.6.1.4.1.8072.9999.9999.1, it publishes:
+//
+//	<base>.1.0        number of networks (INTEGER)
+//	<base>.2.1.<c>.<i> network table, one row i = 1..N per network in
+//	                   address order, with columns c:
+//	                     1 index (INTEGER)
+//	                     2 network ID (OCTET STRING)
+//	                     3 CIDR (OCTET STRING)
+//	                     4 description (OCTET STRING)
+//	                     5 total addresses (Gauge32)
+//	                     6 allocated addresses (Gauge32)
+//	                     7 available addresses (Gauge32)
+//	                     8 reserved addresses (Gauge32)
+//	                     9 utilization in percent (Gauge32)
+//	                    10 utilization in hundredths of a percent (Gauge32)
+//
+// Counts beyond 2^32-1, as in IPv6 networks, read as 4294967295. Row
+// indexes follow the networks, so pollers should key on the ID column
+// rather than the index when networks come and go.
+package snmp
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+const (
+	// DefaultOID is the base OID objects are published below
+	DefaultOID = "1.3.6.1.4.1.8072.9999.9999.1"
+
+	// DefaultMaxAge is how long the agent answers from the same reading of
+	// the networks, which also keeps a walk consistent
+	DefaultMaxAge = 10 * time.Second
+)
+
+// Protocol versions as carried in messages
+const (
+	versionV1  = 0
+	versionV2c = 1
+)
+
+// Error statuses of responses
+const (
+	noError     = 0
+	tooBig      = 1
+	noSuchName  = 2 // SNMPv1
+	genErr      = 5
+	notWritable = 17
+)
+
+// maxMessageSize bounds responses so that they fit an Ethernet frame; a
+// GetBulk response is cut short to fit
+const maxMessageSize = 1472
+
+// Columns of the network table
+const (
+	columnIndex = iota + 1
+	columnID
+	columnCIDR
+	columnDescription
+	columnTotal
+	columnAllocated
+	columnAvailable
+	columnReserved
+	columnUtilization
+	columnUtilizationHundredths
+	columns = columnUtilizationHundredths
+)
+
+// Agent answers SNMP requests from the networks of Store
+type Agent struct {
+	Store ipam.Store
+
+	// Stats returns the utilization of a network
+	Stats func(networkID string) (*ipam.NetworkStats, error)
+
+	// Community is the community string requests must carry
+	Community string
+
+	// Base is the OID objects are published below, DefaultOID when empty
+	Base OID
+
+	// MaxAge is how long a reading of the networks is reused; zero reads
+	// them for every request
+	MaxAge time.Duration
+
+	mu     sync.Mutex
+	objs   []object
+	readAt time.Time
+}
+
+// object is a published object instance and its encoded value
+type object struct {
+	oid   OID
+	value []byte
+}
+
+// Serve answers requests arriving on conn until reading from it fails,
+// e.g. because it was closed, and returns that error
+func (a *Agent) Serve(conn net.PacketConn) error {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		if resp := a.Handle(buf[:n]); resp != nil {
+			if _, err := conn.WriteTo(resp, addr); err != nil {
+				log.Printf("snmp: failed to respond to %s: %v", addr, err)
+			}
+		}
+	}
+}
+
+// ListenAndServe serves requests on the UDP address addr
+func (a *Agent) ListenAndServe(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return a.Serve(conn)
+}
+
+// request is a decoded request PDU
+type request struct {
+	version   int64
+	community []byte
+	pduType   byte
+	id        int64
+
+	// nonRepeaters and maxRepetitions are set for GetBulk only
+	nonRepeaters   int64
+	maxRepetitions int64
+
+	names    []OID
+	varBinds []byte // As received, echoed by error responses
+}
+
+// Handle returns the response to the request message packet, or nil when
+// the request is malformed, of another version or community, or not one
+// the agent answers
+func (a *Agent) Handle(packet []byte) []byte {
+	req, err := decodeRequest(packet)
+	if err != nil {
+		return nil
+	}
+	if subtle.ConstantTimeCompare(req.community, []byte(a.Community)) != 1 {
+		return nil
+	}
+
+	switch req.pduType {
+	case tagGetRequest, tagGetNextRequest:
+	case tagGetBulkRequest:
+		if req.version == versionV1 {
+			return nil
+		}
+	case tagSetRequest:
+		status := notWritable
+		if req.version == versionV1 {
+			status = noSuchName
+		}
+		return req.response(status, 1, req.varBinds)
+	default:
+		return nil
+	}
+
+	objs, err := a.objects()
+	if err != nil {
+		log.Printf("snmp: %v", err)
+		return req.response(genErr, 0, req.varBinds)
+	}
+	base, err := a.base()
+	if err != nil {
+		return req.response(genErr, 0, req.varBinds)
+	}
+
+	var varBinds [][]byte
+	switch req.pduType {
+	case tagGetRequest:
+		for i, name := range req.names {
+			value := lookup(objs, name)
+			if value == nil {
+				if req.version == versionV1 {
+					return req.response(noSuchName, i+1, req.varBinds)
+				}
+				value = missing(base, name)
+			}
+			varBinds = append(varBinds, encodeVarBind(name, value))
+		}
+	case tagGetNextRequest:
+		for i, name := range req.names {
+			obj, ok := next(objs, name)
+			if !ok {
+				if req.version == versionV1 {
+					return req.response(noSuchName, i+1, req.varBinds)
+				}
+				varBinds = append(varBinds, encodeVarBind(name, encode(tagEndOfMibView)))
+				continue
+			}
+			varBinds = append(varBinds, encodeVarBind(obj.oid, obj.value))
+		}
+	case tagGetBulkRequest:
+		return req.bulkResponse(objs)
+	}
+
+	resp := req.response(noError, 0, varBinds...)
+	if len(resp) > maxMessageSize {
+		return req.response(tooBig, 0)
+	}
+	return resp
+}
+
+// bulkResponse answers a GetBulk request: GetNext once for the first
+// nonRepeaters names, then repeatedly for the others, for as many
+// varbinds as fit a response
+func (req *request) bulkResponse(objs []object) []byte {
+	nonRepeaters := int(min(max(req.nonRepeaters, 0), int64(len(req.names))))
+	repetitions := int(min(max(req.maxRepetitions, 0), int64(len(objs)+1)))
+
+	var varBinds [][]byte
+	size := len(req.response(noError, 0))
+	add := func(vb []byte) bool {
+		// Allow for the lengths of the enclosing elements growing
+		if size+len(vb)+8 > maxMessageSize {
+			return false
+		}
+		size += len(vb)
+		varBinds = append(varBinds, vb)
+		return true
+	}
+	walk := func(name OID) (OID, []byte) {
+		if obj, ok := next(objs, name); ok {
+			return obj.oid, encodeVarBind(obj.oid, obj.value)
+		}
+		return name, encodeVarBind(name, encode(tagEndOfMibView))
+	}
+
+	for _, name := range req.names[:nonRepeaters] {
+		if _, vb := walk(name); !add(vb) {
+			return req.response(noError, 0, varBinds...)
+		}
+	}
+	last := append([]OID(nil), req.names[nonRepeaters:]...)
+	for r := 0; r < repetitions && len(last) > 0; r++ {
+		done := true
+		for i, name := range last {
+			oid, vb := walk(name)
+			if !add(vb) {
+				return req.response(noError, 0, varBinds...)
+			}
+			if oid.compare(name) != 0 {
+				done = false
+			}
+			last[i] = oid
+		}
+		if done {
+			break
+		}
+	}
+	return req.response(noError, 0, varBinds...)
+}
+
+// response encodes a response to req
+func (req *request) response(status, index int, varBinds ...[]byte) []byte {
+	return encode(tagSequence,
+		encodeInt(req.version),
+		encode(tagOctetString, req.community),
+		encode(tagGetResponse,
+			encodeInt(req.id),
+			encodeInt(int64(status)),
+			encodeInt(int64(index)),
+			encode(tagSequence, varBinds...),
+		),
+	)
+}
+
+func encodeVarBind(name OID, value []byte) []byte {
+	return encode(tagSequence, encodeOID(name), value)
+}
+
+func decodeRequest(packet []byte) (*request, error) {
+	msg, _, err := expect(packet, tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	req := &request{}
+	b, msg, err := expect(msg, tagInteger)
+	if err != nil {
+		return nil, err
+	}
+	if req.version, err = decodeInt(b); err != nil {
+		return nil, err
+	}
+	if req.version != versionV1 && req.version != versionV2c {
+		return nil, fmt.Errorf("unsupported SNMP version %d", req.version)
+	}
+	if req.community, msg, err = expect(msg, tagOctetString); err != nil {
+		return nil, err
+	}
+
+	pdu, _, err := readTLV(msg)
+	if err != nil {
+		return nil, err
+	}
+	req.pduType = pdu.tag
+	fields := make([]int64, 3)
+	rest := pdu.value
+	for i := range fields {
+		if b, rest, err = expect(rest, tagInteger); err != nil {
+			return nil, err
+		}
+		if fields[i], err = decodeInt(b); err != nil {
+			return nil, err
+		}
+	}
+	req.id = fields[0]
+	if req.pduType == tagGetBulkRequest {
+		req.nonRepeaters, req.maxRepetitions = fields[1], fields[2]
+	}
+
+	list, _, err := expect(rest, tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	req.varBinds = list
+	for len(list) > 0 {
+		var vb []byte
+		if vb, list, err = expect(list, tagSequence); err != nil {
+			return nil, err
+		}
+		b, _, err := expect(vb, tagOID)
+		if err != nil {
+			return nil, err
+		}
+		name, err := decodeOID(b)
+		if err != nil {
+			return nil, err
+		}
+		req.names = append(req.names, name)
+	}
+	return req, nil
+}
+
+// base returns the OID objects are published below
+func (a *Agent) base() (OID, error) {
+	if len(a.Base) > 0 {
+		return a.Base, nil
+	}
+	return ParseOID(DefaultOID)
+}
+
+// lookup returns the value of the object name, or nil
+func lookup(objs []object, name OID) []byte {
+	i := sort.Search(len(objs), func(i int) bool { return objs[i].oid.compare(name) >= 0 })
+	if i < len(objs) && objs[i].oid.compare(name) == 0 {
+		return objs[i].value
+	}
+	return nil
+}
+
+// next returns the first object after name
+func next(objs []object, name OID) (object, bool) {
+	i := sort.Search(len(objs), func(i int) bool { return objs[i].oid.compare(name) > 0 })
+	if i == len(objs) {
+		return object{}, false
+	}
+	return objs[i], true
+}
+
+// missing returns the exception for a Get of name: noSuchInstance below
+// an object the agent publishes, noSuchObject otherwise
+func missing(base, name OID) []byte {
+	if name.hasPrefix(base.append(1)) && len(name) == len(base)+2 {
+		return encode(tagNoSuchInstance)
+	}
+	table := base.append(2, 1)
+	if name.hasPrefix(table) && len(name) > len(table) && name[len(table)] >= 1 && name[len(table)] <= columns {
+		return encode(tagNoSuchInstance)
+	}
+	return encode(tagNoSuchObject)
+}
+
+// objects returns the published objects in OID order, reading the
+// networks again once the last reading is older than MaxAge
+func (a *Agent) objects() ([]object, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.objs != nil && time.Since(a.readAt) < a.MaxAge {
+		return a.objs, nil
+	}
+
+	base, err := a.base()
+	if err != nil {
+		return nil, err
+	}
+	networks, err := a.Store.ListNetworks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+	sortNetworks(networks)
+
+	type row struct {
+		network *ipam.Network
+		stats   *ipam.NetworkStats
+	}
+	var rows []row
+	for _, network := range networks {
+		stats, err := a.Stats(network.ID)
+		if err != nil {
+			// Most likely deleted since it was listed
+			continue
+		}
+		rows = append(rows, row{network, stats})
+	}
+
+	objs := []object{{base.append(1, 0), encodeInt(int64(len(rows)))}}
+	table := base.append(2, 1)
+	for c := uint32(1); c <= columns; c++ {
+		for i, r := range rows {
+			var value []byte
+			switch c {
+			case columnIndex:
+				value = encodeInt(int64(i + 1))
+			case columnID:
+				value = encodeString(r.network.ID)
+			case columnCIDR:
+				value = encodeString(r.network.CIDR)
+			case columnDescription:
+				value = encodeString(r.network.Description)
+			case columnTotal:
+				value = encodeGauge(gauge(r.stats.TotalIPs))
+			case columnAllocated:
+				value = encodeGauge(gauge(r.stats.AllocatedIPs))
+			case columnAvailable:
+				value = encodeGauge(gauge(r.stats.AvailableIPs))
+			case columnReserved:
+				value = encodeGauge(gauge(r.stats.ReservedIPs))
+			case columnUtilization:
+				value = encodeGauge(gauge(uint64(math.Round(max(r.stats.UtilizationPercent, 0)))))
+			case columnUtilizationHundredths:
+				value = encodeGauge(gauge(uint64(math.Round(max(r.stats.UtilizationPercent, 0) * 100))))
+			}
+			objs = append(objs, object{table.append(c, uint32(i+1)), value})
+		}
+	}
+
+	a.objs, a.readAt = objs, time.Now()
+	return objs, nil
+}
+
+// gauge saturates n to a Gauge32
+func gauge(n uint64) uint32 {
+	if n > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(n)
+}
+
+// sortNetworks orders networks by address, then prefix length, IPv4 first
+func sortNetworks(networks []*ipam.Network) {
+	sort.SliceStable(networks, func(i, j int) bool {
+		a, errA := netip.ParsePrefix(networks[i].CIDR)
+		b, errB := netip.ParsePrefix(networks[j].CIDR)
+		if errA != nil || errB != nil {
+			return errA == nil || (errB != nil && networks[i].CIDR < networks[j].CIDR)
+		}
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c < 0
+		}
+		return a.Bits() < b.Bits()
+	})
+}
@@ -0,0 +1,211 @@
+package snmp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// varBind is a decoded response varbind
+type varBind struct {
+	oid   string
+	tag   byte
+	value any
+}
+
+type response struct {
+	id, status, index int64
+	varBinds          []varBind
+}
+
+func packet(t *testing.T, version int64, community string, pduType byte, id, f1, f2 int64, oids ...string) []byte {
+	t.Helper()
+	var list [][]byte
+	for _, s := range oids {
+		oid, err := ParseOID(s)
+		require.NoError(t, err)
+		list = append(list, encodeVarBind(oid, encode(tagNull)))
+	}
+	return encode(tagSequence, encodeInt(version), encodeString(community),
+		encode(pduType, encodeInt(id), encodeInt(f1), encodeInt(f2), encode(tagSequence, list...)))
+}
+
+func decodeResponse(t *testing.T, b []byte) response {
+	t.Helper()
+	msg, _, err := expect(b, tagSequence)
+	require.NoError(t, err)
+	_, msg, err = expect(msg, tagInteger)
+	require.NoError(t, err)
+	_, msg, err = expect(msg, tagOctetString)
+	require.NoError(t, err)
+	pdu, _, err := expect(msg, tagGetResponse)
+	require.NoError(t, err)
+
+	var resp response
+	for _, field := range []*int64{&resp.id, &resp.status, &resp.index} {
+		var v []byte
+		v, pdu, err = expect(pdu, tagInteger)
+		require.NoError(t, err)
+		*field, err = decodeInt(v)
+		require.NoError(t, err)
+	}
+	list, _, err := expect(pdu, tagSequence)
+	require.NoError(t, err)
+	for len(list) > 0 {
+		var vb []byte
+		vb, list, err = expect(list, tagSequence)
+		require.NoError(t, err)
+		b, rest, err := expect(vb, tagOID)
+		require.NoError(t, err)
+		oid, err := decodeOID(b)
+		require.NoError(t, err)
+		value, _, err := readTLV(rest)
+		require.NoError(t, err)
+
+		decoded := varBind{oid: oid.String(), tag: value.tag}
+		switch value.tag {
+		case tagInteger:
+			decoded.value, _ = decodeInt(value.value)
+		case tagGauge32:
+			n, _ := decodeInt(append([]byte{0}, value.value...))
+			decoded.value = n
+		case tagOctetString:
+			decoded.value = string(value.value)
+		}
+		resp.varBinds = append(resp.varBinds, decoded)
+	}
+	return resp
+}
+
+func newAgent(t *testing.T) *Agent {
+	s, err := store.NewPebbleStore(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "v6", CIDR: "2001:db8::/64"}))
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "lan", CIDR: "10.0.0.0/24", Description: "Office LAN"}))
+	stats := map[string]*ipam.NetworkStats{
+		"lan": {TotalIPs: 254, AllocatedIPs: 127, AvailableIPs: 127, UtilizationPercent: 50},
+		"v6":  {TotalIPs: 1 << 64 / 2, AllocatedIPs: 3, AvailableIPs: 1<<63 - 3, UtilizationPercent: 0.0000001},
+	}
+	return &Agent{
+		Store:     s,
+		Stats:     func(id string) (*ipam.NetworkStats, error) { return stats[id], nil },
+		Community: "secret",
+	}
+}
+
+const base = DefaultOID
+
+func TestGet(t *testing.T) {
+	a := newAgent(t)
+
+	resp := decodeResponse(t, a.Handle(packet(t, versionV2c, "secret", tagGetRequest, 42, 0, 0,
+		base+".1.0", base+".2.1.3.1", base+".2.1.4.1", base+".2.1.10.1", base+".2.1.5.2", base+".2.1.9.3", "1.3.6.1.2.1.1.1.0")))
+	assert.Equal(t, int64(42), resp.id)
+	assert.Equal(t, int64(noError), resp.status)
+	assert.Equal(t, []varBind{
+		{base + ".1.0", tagInteger, int64(2)},
+		// IPv4 first
+		{base + ".2.1.3.1", tagOctetString, "10.0.0.0/24"},
+		{base + ".2.1.4.1", tagOctetString, "Office LAN"},
+		{base + ".2.1.10.1", tagGauge32, int64(5000)},
+		// Saturated
+		{base + ".2.1.5.2", tagGauge32, int64(4294967295)},
+		{base + ".2.1.9.3", tagNoSuchInstance, nil},
+		{"1.3.6.1.2.1.1.1.0", tagNoSuchObject, nil},
+	}, resp.varBinds)
+
+	// SNMPv1 has no exceptions
+	resp = decodeResponse(t, a.Handle(packet(t, versionV1, "secret", tagGetRequest, 43, 0, 0, base+".1.0", base+".2.1.9.3")))
+	assert.Equal(t, int64(noSuchName), resp.status)
+	assert.Equal(t, int64(2), resp.index)
+
+	// Other communities, versions and malformed requests go unanswered
+	assert.Nil(t, a.Handle(packet(t, versionV2c, "public", tagGetRequest, 44, 0, 0, base+".1.0")))
+	assert.Nil(t, a.Handle(packet(t, 3, "secret", tagGetRequest, 45, 0, 0, base+".1.0")))
+	assert.Nil(t, a.Handle([]byte{0x30, 0x10, 0x02}))
+
+	// The agent is read-only
+	resp = decodeResponse(t, a.Handle(packet(t, versionV2c, "secret", tagSetRequest, 46, 0, 0, base+".1.0")))
+	assert.Equal(t, int64(notWritable), resp.status)
+	assert.Equal(t, int64(1), resp.index)
+}
+
+func TestWalk(t *testing.T) {
+	a := newAgent(t)
+
+	// GetNext walks the table column by column
+	var walked []varBind
+	oid := base
+	for {
+		resp := decodeResponse(t, a.Handle(packet(t, versionV2c, "secret", tagGetNextRequest, 1, 0, 0, oid)))
+		require.Len(t, resp.varBinds, 1)
+		vb := resp.varBinds[0]
+		if vb.tag == tagEndOfMibView {
+			break
+		}
+		walked = append(walked, vb)
+		oid = vb.oid
+	}
+	require.Len(t, walked, 1+2*columns)
+	assert.Equal(t, base+".2.1.1.1", walked[1].oid)
+	assert.Equal(t, varBind{base + ".2.1.2.2", tagOctetString, "v6"}, walked[4])
+	assert.Equal(t, varBind{base + ".2.1.9.1", tagGauge32, int64(50)}, walked[17])
+
+	// GetBulk returns the same in one request
+	resp := decodeResponse(t, a.Handle(packet(t, versionV2c, "secret", tagGetBulkRequest, 2, 0, 50, base)))
+	assert.Equal(t, walked, resp.varBinds[:len(walked)])
+	assert.Equal(t, byte(tagEndOfMibView), resp.varBinds[len(walked)].tag)
+
+	// Non-repeaters are answered once, ahead of the rows
+	resp = decodeResponse(t, a.Handle(packet(t, versionV2c, "secret", tagGetBulkRequest, 3, 1, 2, base+".1", base+".2.1.3")))
+	assert.Equal(t, []varBind{
+		{base + ".1.0", tagInteger, int64(2)},
+		{base + ".2.1.3.1", tagOctetString, "10.0.0.0/24"},
+		{base + ".2.1.3.2", tagOctetString, "2001:db8::/64"},
+	}, resp.varBinds)
+
+	// SNMPv1 reports the end of the MIB as noSuchName
+	resp = decodeResponse(t, a.Handle(packet(t, versionV1, "secret", tagGetNextRequest, 4, 0, 0, base+".3")))
+	assert.Equal(t, int64(noSuchName), resp.status)
+}
+
+func TestServe(t *testing.T) {
+	a := newAgent(t)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go a.Serve(conn)
+	defer conn.Close()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write(packet(t, versionV2c, "secret", tagGetRequest, 7, 0, 0, base+".1.0"))
+	require.NoError(t, err)
+
+	buf := make([]byte, maxMessageSize)
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := client.Read(buf)
+	require.NoError(t, err)
+	resp := decodeResponse(t, buf[:n])
+	assert.Equal(t, []varBind{{base + ".1.0", tagInteger, int64(2)}}, resp.varBinds)
+}
+
+func TestOIDEncoding(t *testing.T) {
+	oid, err := ParseOID(".1.3.6.1.4.1.8072.9999.9999.1.4294967295")
+	require.NoError(t, err)
+	decoded, err := decodeOID(encodeOID(oid)[2:])
+	require.NoError(t, err)
+	assert.Equal(t, oid, decoded)
+
+	for _, s := range []string{"", "1", "1.3.x", "3.1", "1.40"} {
+		_, err := ParseOID(s)
+		assert.Error(t, err, s)
+	}
+}
@@ -0,0 +1,163 @@
+// Package spiffe authenticates API clients by their SPIFFE X.509 SVIDs, so
+// workloads of a service mesh can use the API without distributing tokens.
+//
+// The TLS handshake verifies a client's certificate chain against the trust
+// bundle; this package then reads the SPIFFE ID from the certificate and
+// maps it to the principal that network ACLs name, e.g.
+// spiffe://example.org/ns/lab/* to lab.
+package spiffe
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// PrincipalPrefix marks the principals of SVID-authenticated callers, so
+// that they cannot be mistaken for token fingerprints
+const PrincipalPrefix = "spiffe:"
+
+// ErrNoSPIFFEID is returned for a certificate that is not an X.509 SVID
+var ErrNoSPIFFEID = errors.New("certificate has no SPIFFE ID")
+
+// The SPIFFE ID spec limits trust domains and path segments to these
+// characters; principals are limited so that they fit in ACL tags
+var (
+	trustDomainPattern = regexp.MustCompile(`^[a-z0-9._-]+$`)
+	segmentPattern     = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+	principalPattern   = regexp.MustCompile(`^[A-Za-z0-9._:/-]{1,40}$`)
+)
+
+// Mapping maps a SPIFFE ID, or every ID below a path when it ends in /*,
+// to a principal
+type Mapping struct {
+	Pattern   string
+	Principal string
+}
+
+// Matches reports whether the mapping covers id
+func (m Mapping) Matches(id string) bool {
+	if prefix, ok := strings.CutSuffix(m.Pattern, "/*"); ok {
+		return strings.HasPrefix(id, prefix+"/")
+	}
+	return id == m.Pattern
+}
+
+// Mapper maps the SPIFFE IDs of clients to principals. A nil Mapper maps
+// none.
+type Mapper struct {
+	mappings []Mapping
+}
+
+// ParseMappings parses specs of the form <SPIFFE ID>=<principal>. The ID
+// may end in /* to cover every ID below it. An ID covered by several
+// mappings gets the principal of the most specific one.
+func ParseMappings(specs []string) (*Mapper, error) {
+	m := &Mapper{}
+	for _, spec := range specs {
+		pattern, principal, ok := strings.Cut(spec, "=")
+		if !ok || pattern == "" || principal == "" {
+			return nil, fmt.Errorf("invalid SPIFFE ID mapping %q: expected spiffe://<trust domain>/<path>=<principal>", spec)
+		}
+		if err := ValidateID(strings.TrimSuffix(pattern, "/*")); err != nil {
+			return nil, fmt.Errorf("invalid SPIFFE ID mapping %q: %w", spec, err)
+		}
+		if !principalPattern.MatchString(principal) {
+			return nil, fmt.Errorf("invalid SPIFFE ID mapping %q: principal must be up to 40 letters, digits and . _ : / -", spec)
+		}
+		for _, existing := range m.mappings {
+			if existing.Pattern == pattern {
+				return nil, fmt.Errorf("SPIFFE ID %q is mapped twice", pattern)
+			}
+		}
+		m.mappings = append(m.mappings, Mapping{Pattern: pattern, Principal: principal})
+	}
+	return m, nil
+}
+
+// Principal returns the principal id maps to, prefixed with
+// PrincipalPrefix, and whether any mapping covers it
+func (m *Mapper) Principal(id string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	var best *Mapping
+	for i, mapping := range m.mappings {
+		if mapping.Matches(id) && (best == nil || len(mapping.Pattern) > len(best.Pattern)) {
+			best = &m.mappings[i]
+		}
+	}
+	if best == nil {
+		return "", false
+	}
+	return PrincipalPrefix + best.Principal, true
+}
+
+// ValidateID checks that id is a SPIFFE ID: spiffe://<trust domain> with
+// an optional path, without query, fragment, port or user info
+func ValidateID(id string) error {
+	u, err := url.Parse(id)
+	if err != nil {
+		return err
+	}
+	switch {
+	case u.Scheme != "spiffe":
+		return fmt.Errorf("%q does not start with spiffe://", id)
+	case u.User != nil, u.Port() != "", u.RawQuery != "", u.Fragment != "", u.Opaque != "":
+		return fmt.Errorf("%q may not have user info, a port, a query or a fragment", id)
+	case !trustDomainPattern.MatchString(u.Host):
+		return fmt.Errorf("%q has an invalid trust domain", id)
+	}
+	if u.Path == "" {
+		return nil
+	}
+	for _, segment := range strings.Split(strings.TrimPrefix(u.Path, "/"), "/") {
+		if !segmentPattern.MatchString(segment) || segment == "." || segment == ".." {
+			return fmt.Errorf("%q has an invalid path", id)
+		}
+	}
+	return nil
+}
+
+// ID returns the SPIFFE ID of an X.509 SVID: its only URI SAN. CA
+// certificates are not SVIDs.
+func ID(cert *x509.Certificate) (string, error) {
+	if cert.IsCA || len(cert.URIs) != 1 {
+		return "", ErrNoSPIFFEID
+	}
+	id := cert.URIs[0].String()
+	if err := ValidateID(id); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrNoSPIFFEID, err)
+	}
+	return id, nil
+}
+
+// LoadBundle reads the X.509 authorities of a trust bundle from a PEM file
+func LoadBundle(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SPIFFE trust bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	count := 0
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate in SPIFFE trust bundle %s: %w", path, err)
+		}
+		pool.AddCert(cert)
+		count++
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("SPIFFE trust bundle %s has no certificates", path)
+	}
+	return pool, nil
+}
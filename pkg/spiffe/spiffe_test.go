@@ -0,0 +1,127 @@
+package spiffe
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newCert creates a self-signed certificate with the given URI SANs
+func newCert(t *testing.T, isCA bool, uris ...string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	for _, uri := range uris {
+		u, err := url.Parse(uri)
+		require.NoError(t, err)
+		tmpl.URIs = append(tmpl.URIs, u)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestParseMappings(t *testing.T) {
+	m, err := ParseMappings([]string{
+		"spiffe://example.org/ns/lab/*=lab",
+		"spiffe://example.org/ns/lab/sa/ipam-admin=lab-admin",
+		"spiffe://example.org/ns/prod/sa/deployer=prod",
+	})
+	require.NoError(t, err)
+
+	for id, want := range map[string]string{
+		"spiffe://example.org/ns/lab/sa/ci":         "spiffe:lab",
+		"spiffe://example.org/ns/lab/sa/ipam-admin": "spiffe:lab-admin",
+		"spiffe://example.org/ns/prod/sa/deployer":  "spiffe:prod",
+	} {
+		got, ok := m.Principal(id)
+		assert.True(t, ok, id)
+		assert.Equal(t, want, got, id)
+	}
+	for _, id := range []string{
+		"spiffe://example.org/ns/lab",
+		"spiffe://example.org/ns/laboratory/sa/ci",
+		"spiffe://example.org/ns/prod/sa/deployer/x",
+		"spiffe://other.org/ns/lab/sa/ci",
+	} {
+		_, ok := m.Principal(id)
+		assert.False(t, ok, id)
+	}
+
+	var none *Mapper
+	_, ok := none.Principal("spiffe://example.org/ns/lab/sa/ci")
+	assert.False(t, ok)
+
+	for _, spec := range []string{
+		"spiffe://example.org/web",
+		"https://example.org/web=web",
+		"spiffe://Example.org/web=web",
+		"spiffe://example.org:8443/web=web",
+		"spiffe://example.org/web?x=1=web",
+		"spiffe://example.org/../web=web",
+		"spiffe://example.org/web=has space",
+	} {
+		_, err := ParseMappings([]string{spec})
+		assert.Error(t, err, spec)
+	}
+	_, err = ParseMappings([]string{"spiffe://example.org/web=a", "spiffe://example.org/web=b"})
+	assert.ErrorContains(t, err, "mapped twice")
+}
+
+func TestID(t *testing.T) {
+	id, err := ID(newCert(t, false, "spiffe://example.org/ns/lab/sa/ci"))
+	require.NoError(t, err)
+	assert.Equal(t, "spiffe://example.org/ns/lab/sa/ci", id)
+
+	for name, cert := range map[string]*x509.Certificate{
+		"no URI":       newCert(t, false),
+		"two URIs":     newCert(t, false, "spiffe://example.org/a", "spiffe://example.org/b"),
+		"not SPIFFE":   newCert(t, false, "https://example.org/a"),
+		"CA":           newCert(t, true, "spiffe://example.org"),
+		"invalid path": newCert(t, false, "spiffe://example.org/a//b"),
+	} {
+		_, err := ID(cert)
+		assert.True(t, errors.Is(err, ErrNoSPIFFEID), name)
+	}
+}
+
+func TestLoadBundle(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCert(t, true, "spiffe://example.org")
+	path := filepath.Join(dir, "bundle.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0o600))
+
+	pool, err := LoadBundle(path)
+	require.NoError(t, err)
+	_, err = ca.Verify(x509.VerifyOptions{Roots: pool})
+	assert.NoError(t, err)
+
+	empty := filepath.Join(dir, "empty.pem")
+	require.NoError(t, os.WriteFile(empty, []byte("not a bundle\n"), 0o600))
+	_, err = LoadBundle(empty)
+	assert.ErrorContains(t, err, "no certificates")
+
+	_, err = LoadBundle(filepath.Join(dir, "missing.pem"))
+	assert.Error(t, err)
+}
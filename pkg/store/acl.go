@@ -0,0 +1,138 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// Permission is what a network ACL grants a principal
+type Permission string
+
+const (
+	// PermissionView covers reading the network and its allocations
+	PermissionView Permission = "view"
+	// PermissionAllocate covers allocating, holding and changing
+	// allocations in the network
+	PermissionAllocate Permission = "allocate"
+	// PermissionRelease covers releasing allocations of the network
+	PermissionRelease Permission = "release"
+	// PermissionAdmin covers everything else, changing the ACL included,
+	// and implies the other permissions
+	PermissionAdmin Permission = "admin"
+)
+
+// Permissions lists every permission, in the order ACLs are shown
+var Permissions = []Permission{PermissionView, PermissionAllocate, PermissionRelease, PermissionAdmin}
+
+// A network ACL is recorded in the network's tags, like a freeze, so it
+// travels with the network through every store and export:
+// acl-<permission>=<principal> grants the permission to the principal.
+const aclTagPrefix = "acl-"
+
+// AnyPrincipal in an ACL grants the permission to every caller
+const AnyPrincipal = "any"
+
+// ErrPermissionDenied is returned when a network's ACL does not grant the
+// caller what an operation needs
+var ErrPermissionDenied = errors.New("permission denied")
+
+// ACL maps permissions to the principals granted them. Principals are API
+// token fingerprints as recorded in the audit log, e.g. token:9f86d081,
+// the principals SPIFFE IDs map to, e.g. spiffe:lab, anonymous when
+// authentication is off, or AnyPrincipal.
+type ACL map[Permission][]string
+
+// ACLTagPrefix returns the prefix of the tags granting p
+func ACLTagPrefix(p Permission) string {
+	return aclTagPrefix + string(p) + "="
+}
+
+// ValidPermission reports whether p is one of Permissions
+func ValidPermission(p Permission) bool {
+	return slices.Contains(Permissions, p)
+}
+
+// IsACLTag reports whether tag is part of a network ACL
+func IsACLTag(tag string) bool {
+	for _, p := range Permissions {
+		if strings.HasPrefix(tag, ACLTagPrefix(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// NetworkACL returns the ACL of network, empty when it has none
+func NetworkACL(network *ipam.Network) ACL {
+	acl := make(ACL)
+	for _, tag := range network.Tags {
+		for _, p := range Permissions {
+			if principal, ok := strings.CutPrefix(tag, ACLTagPrefix(p)); ok && principal != "" {
+				acl[p] = append(acl[p], principal)
+			}
+		}
+	}
+	return acl
+}
+
+// Empty reports whether the ACL grants nothing, leaving the network open
+func (acl ACL) Empty() bool {
+	for _, principals := range acl {
+		if len(principals) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Allows reports whether principal holds p. A network without an ACL
+// allows everything to everyone; once it has one, only what it grants is
+// allowed, admin granting every permission.
+func (acl ACL) Allows(p Permission, principal string) bool {
+	if acl.Empty() {
+		return true
+	}
+	for _, granted := range []Permission{p, PermissionAdmin} {
+		for _, candidate := range acl[granted] {
+			if candidate == principal || candidate == AnyPrincipal {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Tags renders the ACL as network tags, in the order of Permissions
+func (acl ACL) Tags() []string {
+	var tags []string
+	for _, p := range Permissions {
+		for _, principal := range acl[p] {
+			tags = append(tags, ACLTagPrefix(p)+principal)
+		}
+	}
+	return tags
+}
+
+// ACLTags returns tags with any previous ACL replaced by acl
+func ACLTags(tags []string, acl ACL) []string {
+	kept := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if !IsACLTag(tag) {
+			kept = append(kept, tag)
+		}
+	}
+	return append(kept, acl.Tags()...)
+}
+
+// CheckPermission returns ErrPermissionDenied when the ACL of network does
+// not grant principal p
+func CheckPermission(network *ipam.Network, p Permission, principal string) error {
+	if !NetworkACL(network).Allows(p, principal) {
+		return fmt.Errorf("%w: %s lacks %s permission on %s", ErrPermissionDenied, principal, p, network.CIDR)
+	}
+	return nil
+}
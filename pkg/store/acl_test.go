@@ -0,0 +1,45 @@
+package store
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetworkACL(t *testing.T) {
+	network := &ipam.Network{ID: "net1", CIDR: "10.0.0.0/24", Tags: []string{"env=prod"}}
+
+	// Without an ACL the network is open
+	assert.True(t, NetworkACL(network).Empty())
+	assert.NoError(t, CheckPermission(network, PermissionAdmin, "token:11111111"))
+
+	network.Tags = ACLTags(network.Tags, ACL{
+		PermissionAdmin:    {"token:aaaaaaaa"},
+		PermissionView:     {AnyPrincipal},
+		PermissionAllocate: {"token:11111111", "token:22222222"},
+	})
+	assert.Equal(t, []string{"env=prod", "acl-view=any", "acl-allocate=token:11111111",
+		"acl-allocate=token:22222222", "acl-admin=token:aaaaaaaa"}, network.Tags)
+
+	acl := NetworkACL(network)
+	assert.Equal(t, []string{"token:11111111", "token:22222222"}, acl[PermissionAllocate])
+	assert.True(t, acl.Allows(PermissionView, "anonymous"))
+	assert.True(t, acl.Allows(PermissionAllocate, "token:22222222"))
+	assert.False(t, acl.Allows(PermissionRelease, "token:22222222"))
+	assert.False(t, acl.Allows(PermissionAdmin, "token:11111111"))
+
+	// Admin implies every permission
+	assert.True(t, acl.Allows(PermissionRelease, "token:aaaaaaaa"))
+
+	err := CheckPermission(network, PermissionRelease, "token:11111111")
+	assert.True(t, errors.Is(err, ErrPermissionDenied))
+	assert.Contains(t, err.Error(), "token:11111111 lacks release permission on 10.0.0.0/24")
+
+	// Replacing the ACL keeps the other tags; an empty one reopens the network
+	network.Tags = ACLTags(network.Tags, nil)
+	assert.Equal(t, []string{"env=prod"}, network.Tags)
+	assert.True(t, IsACLTag("acl-release=token:11111111"))
+	assert.False(t, IsACLTag("acl=token:11111111"))
+}
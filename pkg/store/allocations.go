@@ -0,0 +1,324 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// Allocation statuses reported by AllocationStatus
+const (
+	StatusActive   = "active"
+	StatusExpired  = "expired"
+	StatusReleased = "released"
+)
+
+var (
+	// ErrAlreadyReleased is returned when releasing an allocation that has
+	// already been released, as opposed to an address that was never allocated
+	ErrAlreadyReleased = errors.New("allocation already released")
+
+	// ErrIPOutOfRange is returned when an address requested explicitly is not
+	// a usable host address of the network
+	ErrIPOutOfRange = errors.New("IP address is not a usable address of the network")
+)
+
+// NewAllocationID returns a random ID for an allocation recorded outside
+// the allocator, in the form the allocator gives its own
+func NewAllocationID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate allocation ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CheckReleasable returns ErrAlreadyReleased, with the address, release
+// time and releasing user, when alloc has been released
+func CheckReleasable(s ipam.Store, alloc *ipam.IPAllocation) error {
+	if alloc.ReleasedAt == nil {
+		return nil
+	}
+	err := fmt.Errorf("%w: %s was released at %s", ErrAlreadyReleased, alloc.IP, alloc.ReleasedAt.Local().Format("2006-01-02 15:04:05"))
+	if by := ReleasedBy(s, alloc); by != "" {
+		err = fmt.Errorf("%w by %s", err, by)
+	}
+	return err
+}
+
+// releaseActions are the audit actions recording the release of an
+// allocation, by a user and by the reclaimer
+var releaseActions = []string{"ip_released", "reclaim_released"}
+
+// releaseAuditSkew bounds how long before the release time its audit entry
+// may be timestamped, should the clocks recording them differ slightly
+const releaseAuditSkew = time.Minute
+
+// ReleasedBy returns the user of the most recent audit entry releasing
+// alloc, or "" when the audit log has no record of the release. Only
+// entries from around the release time on are read.
+func ReleasedBy(s ipam.Store, alloc *ipam.IPAllocation) string {
+	var since time.Time
+	if alloc.ReleasedAt != nil {
+		since = alloc.ReleasedAt.Add(-releaseAuditSkew)
+	}
+	var by string
+	ScanAuditEntries(s, since, func(entry *ipam.AuditEntry) error {
+		if entry.Resource == alloc.ID && slices.Contains(releaseActions, entry.Action) {
+			by = entry.User
+		}
+		return nil
+	})
+	return by
+}
+
+// LastReleased returns the most recently released allocation covering ip in
+// networkID, or nil when the address was never allocated and released there
+func LastReleased(s ipam.Store, networkID, ip string) (*ipam.IPAllocation, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP address %q", ip)
+	}
+	addr = addr.Unmap()
+
+	allocations, err := s.ListAllocations(networkID)
+	if err != nil {
+		return nil, err
+	}
+
+	var last *ipam.IPAllocation
+	for _, alloc := range allocations {
+		if alloc.ReleasedAt == nil {
+			continue
+		}
+		first, end, err := allocationRange(alloc)
+		if err != nil || addr.Less(first) || end.Less(addr) {
+			continue
+		}
+		if last == nil || alloc.ReleasedAt.After(*last.ReleasedAt) {
+			last = alloc
+		}
+	}
+	return last, nil
+}
+
+// CheckRequestedIP verifies that ip, requested explicitly, can be allocated
+// in network and returns it in canonical form. The address must be a host
+// address of the network, see PointToPoint, and not covered by an active
+// allocation; released and expired allocations do not conflict, so a
+// released address can be requested again.
+func CheckRequestedIP(network *ipam.Network, allocations []*ipam.IPAllocation, ip string, now time.Time) (string, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", fmt.Errorf("invalid IP address %q", ip)
+	}
+	addr = addr.Unmap()
+
+	prefix, err := netip.ParsePrefix(network.CIDR)
+	if err != nil {
+		return "", fmt.Errorf("invalid CIDR %q: %w", network.CIDR, err)
+	}
+	prefix = prefix.Masked()
+	if !prefix.Contains(addr) {
+		return "", fmt.Errorf("%w: %s is outside %s", ErrIPOutOfRange, addr, network.CIDR)
+	}
+	if reservedAddr(prefix, PointToPoint(network), addr) {
+		return "", fmt.Errorf("%w: %s is a reserved address of %s", ErrIPOutOfRange, addr, network.CIDR)
+	}
+
+	for _, other := range allocations {
+		if AllocationStatus(other, now) != StatusActive {
+			continue
+		}
+		first, last, err := allocationRange(other)
+		if err != nil {
+			return "", err
+		}
+		if addr.Less(first) || last.Less(addr) {
+			continue
+		}
+		if other.ExpiresAt != nil && slices.ContainsFunc(other.Tags, func(tag string) bool { return strings.HasPrefix(tag, HoldTagPrefix) }) {
+			return "", fmt.Errorf("%w: %s is on hold until %s", ipam.ErrIPNotAvailable, addr, other.ExpiresAt.Local().Format("2006-01-02 15:04:05"))
+		}
+		return "", fmt.Errorf("%w: %s is held by allocation %s", ipam.ErrIPNotAvailable, addr, other.ID)
+	}
+
+	return addr.String(), nil
+}
+
+// AllocationStatus classifies an allocation as active, expired or released
+func AllocationStatus(alloc *ipam.IPAllocation, now time.Time) string {
+	switch {
+	case alloc.ReleasedAt != nil:
+		return StatusReleased
+	case alloc.ExpiresAt != nil && alloc.ExpiresAt.Before(now):
+		return StatusExpired
+	default:
+		return StatusActive
+	}
+}
+
+// AllocationFilter selects allocations in FilterAllocations. Empty fields
+// match everything.
+type AllocationFilter struct {
+	// Status is active, expired or released
+	Status string
+
+	// Tags must all be present on the allocation
+	Tags []string
+
+	// ExpiringWithin matches active allocations that expire within this
+	// long
+	ExpiringWithin time.Duration
+}
+
+// ParseAllocationFilter parses a comma-separated list of key=value terms,
+// e.g. "status=active,tag=prod,expiring_within=24h". tag may be repeated.
+// country and asn match the tags CountryTag and ASNTag return.
+func ParseAllocationFilter(s string) (AllocationFilter, error) {
+	var f AllocationFilter
+	if s == "" {
+		return f, nil
+	}
+
+	for _, term := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(term), "=")
+		if !ok || value == "" {
+			return f, fmt.Errorf("invalid filter term %q: expected key=value", term)
+		}
+		switch key {
+		case "status":
+			f.Status = value
+		case "tag":
+			f.Tags = append(f.Tags, value)
+		case "expiring_within":
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return f, fmt.Errorf("invalid expiring_within %q: %w", value, err)
+			}
+			f.ExpiringWithin = d
+		case "country":
+			tag, err := CountryTag(value)
+			if err != nil {
+				return f, err
+			}
+			f.Tags = append(f.Tags, tag)
+		case "asn":
+			tag, err := ASNTag(value)
+			if err != nil {
+				return f, err
+			}
+			f.Tags = append(f.Tags, tag)
+		default:
+			return f, fmt.Errorf("unknown filter key %q: must be status, tag, expiring_within, country or asn", key)
+		}
+	}
+
+	return f, f.Validate()
+}
+
+// Validate checks the status value and expiry window
+func (f *AllocationFilter) Validate() error {
+	if f.ExpiringWithin < 0 {
+		return fmt.Errorf("expiring_within must not be negative")
+	}
+	switch f.Status {
+	case "", StatusActive, StatusExpired, StatusReleased:
+		return nil
+	}
+	return fmt.Errorf("invalid status %q: must be active, expired or released", f.Status)
+}
+
+// ExpiresWithin reports whether alloc is active at now and expires within d
+func ExpiresWithin(alloc *ipam.IPAllocation, now time.Time, d time.Duration) bool {
+	return alloc.ExpiresAt != nil && AllocationStatus(alloc, now) == StatusActive && !alloc.ExpiresAt.After(now.Add(d))
+}
+
+// FilterAllocations returns the allocations matching f, preserving order
+func FilterAllocations(allocations []*ipam.IPAllocation, f AllocationFilter, now time.Time) []*ipam.IPAllocation {
+	if f.Status == "" && len(f.Tags) == 0 && f.ExpiringWithin == 0 {
+		return allocations
+	}
+
+	matched := make([]*ipam.IPAllocation, 0, len(allocations))
+	for _, alloc := range allocations {
+		if f.Status != "" && AllocationStatus(alloc, now) != f.Status {
+			continue
+		}
+		if f.ExpiringWithin != 0 && !ExpiresWithin(alloc, now, f.ExpiringWithin) {
+			continue
+		}
+		ok := true
+		for _, tag := range f.Tags {
+			if !hasTag(alloc.Tags, tag) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			matched = append(matched, alloc)
+		}
+	}
+	return matched
+}
+
+// AllocationSortFields are the fields accepted by SortAllocations
+var AllocationSortFields = []string{"ip", "hostname", "allocated_at", "expires_at"}
+
+// ValidAllocationSortField reports whether field is accepted by
+// SortAllocations
+func ValidAllocationSortField(field string) bool {
+	for _, f := range AllocationSortFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// SortAllocations orders allocations in place by field. Addresses sort
+// numerically; allocations without an expiry sort after those with one.
+func SortAllocations(allocations []*ipam.IPAllocation, field string, desc bool) error {
+	var less func(a, b *ipam.IPAllocation) bool
+	switch field {
+	case "ip":
+		less = func(a, b *ipam.IPAllocation) bool {
+			x, _ := netip.ParseAddr(a.IP)
+			y, _ := netip.ParseAddr(b.IP)
+			return x.Unmap().Less(y.Unmap())
+		}
+	case "hostname":
+		less = func(a, b *ipam.IPAllocation) bool { return a.Hostname < b.Hostname }
+	case "allocated_at":
+		less = func(a, b *ipam.IPAllocation) bool { return a.AllocatedAt.Before(b.AllocatedAt) }
+	case "expires_at":
+		less = func(a, b *ipam.IPAllocation) bool {
+			switch {
+			case a.ExpiresAt == nil:
+				return false
+			case b.ExpiresAt == nil:
+				return true
+			default:
+				return a.ExpiresAt.Before(*b.ExpiresAt)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid sort field %q: must be one of %s", field, strings.Join(AllocationSortFields, ", "))
+	}
+
+	sort.SliceStable(allocations, func(i, j int) bool {
+		if desc {
+			return less(allocations[j], allocations[i])
+		}
+		return less(allocations[i], allocations[j])
+	})
+	return nil
+}
@@ -0,0 +1,165 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func allocationIDs(allocations []*ipam.IPAllocation) []string {
+	ids := make([]string, 0, len(allocations))
+	for _, a := range allocations {
+		ids = append(ids, a.ID)
+	}
+	return ids
+}
+
+func TestParseAllocationFilter(t *testing.T) {
+	f, err := ParseAllocationFilter("status=active, tag=prod,tag=web")
+	require.NoError(t, err)
+	assert.Equal(t, AllocationFilter{Status: "active", Tags: []string{"prod", "web"}}, f)
+
+	f, err = ParseAllocationFilter("expiring_within=24h")
+	require.NoError(t, err)
+	assert.Equal(t, 24*time.Hour, f.ExpiringWithin)
+
+	f, err = ParseAllocationFilter("country=gb,asn=AS20712")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"geo-country=GB", "geo-asn=20712"}, f.Tags)
+
+	for _, bad := range []string{"status", "status=gone", "owner=me", "tag=", "expiring_within=soon", "expiring_within=-1h", "country=GBR", "country=g1", "asn=AS"} {
+		_, err := ParseAllocationFilter(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestFilterAndSortAllocations(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	soon := now.Add(time.Hour)
+	later := now.Add(2 * time.Hour)
+
+	allocations := []*ipam.IPAllocation{
+		{ID: "a", IP: "10.0.0.10", Hostname: "web", Tags: []string{"prod"}, AllocatedAt: now.Add(-3 * time.Minute), ExpiresAt: &later},
+		{ID: "b", IP: "10.0.0.9", Hostname: "db", Tags: []string{"prod"}, AllocatedAt: now.Add(-1 * time.Minute)},
+		{ID: "c", IP: "10.0.0.100", Hostname: "app", AllocatedAt: now.Add(-2 * time.Minute), ExpiresAt: &soon},
+		{ID: "d", IP: "10.0.0.2", Hostname: "old", ReleasedAt: &past, AllocatedAt: now.Add(-4 * time.Minute)},
+		{ID: "e", IP: "10.0.0.3", Hostname: "tmp", ExpiresAt: &past, AllocatedAt: now.Add(-5 * time.Minute)},
+	}
+
+	assert.Equal(t, []string{"a", "b", "c"}, allocationIDs(FilterAllocations(allocations, AllocationFilter{Status: StatusActive}, now)))
+	assert.Equal(t, []string{"d"}, allocationIDs(FilterAllocations(allocations, AllocationFilter{Status: StatusReleased}, now)))
+	assert.Equal(t, []string{"e"}, allocationIDs(FilterAllocations(allocations, AllocationFilter{Status: StatusExpired}, now)))
+	assert.Equal(t, []string{"a", "b"}, allocationIDs(FilterAllocations(allocations, AllocationFilter{Tags: []string{"prod"}}, now)))
+	assert.Equal(t, []string{"c"}, allocationIDs(FilterAllocations(allocations, AllocationFilter{ExpiringWithin: 90 * time.Minute}, now)))
+	assert.Equal(t, []string{"a", "c"}, allocationIDs(FilterAllocations(allocations, AllocationFilter{ExpiringWithin: 2 * time.Hour}, now)))
+
+	sorted := func(field string, desc bool) []string {
+		list := append([]*ipam.IPAllocation(nil), allocations...)
+		require.NoError(t, SortAllocations(list, field, desc))
+		return allocationIDs(list)
+	}
+
+	assert.Equal(t, []string{"d", "e", "b", "a", "c"}, sorted("ip", false))
+	assert.Equal(t, []string{"c", "a", "b", "e", "d"}, sorted("ip", true))
+	assert.Equal(t, []string{"c", "b", "d", "e", "a"}, sorted("hostname", false))
+	assert.Equal(t, []string{"b", "c", "a", "d", "e"}, sorted("allocated_at", true))
+	assert.Equal(t, []string{"e", "c", "a", "b", "d"}, sorted("expires_at", false))
+
+	assert.Error(t, SortAllocations(allocations, "size", false))
+	assert.False(t, ValidAllocationSortField("size"))
+}
+
+func TestCheckReleasable(t *testing.T) {
+	store, cleanup := createTestPebbleStore(t)
+	defer cleanup()
+
+	alloc := &ipam.IPAllocation{ID: "a1", IP: "10.0.0.5"}
+	assert.NoError(t, CheckReleasable(store, alloc))
+
+	released := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	alloc.ReleasedAt = &released
+	err := CheckReleasable(store, alloc)
+	assert.ErrorIs(t, err, ErrAlreadyReleased)
+	assert.Contains(t, err.Error(), "10.0.0.5")
+	assert.NotContains(t, err.Error(), " by ")
+
+	require.NoError(t, store.SaveAuditEntry(&ipam.AuditEntry{ID: "e1", Timestamp: released, Action: "allocate", Resource: "a1", User: "alice"}))
+	require.NoError(t, store.SaveAuditEntry(&ipam.AuditEntry{ID: "e2", Timestamp: released.Add(time.Minute), Action: "ip_released", Resource: "a1", User: "bob"}))
+	require.NoError(t, store.SaveAuditEntry(&ipam.AuditEntry{ID: "e3", Timestamp: released.Add(2 * time.Minute), Action: "ip_released", Resource: "a2", User: "carol"}))
+	assert.Equal(t, "bob", ReleasedBy(store, alloc))
+
+	// Only release actions count, matched exactly, and only from around the
+	// release on
+	require.NoError(t, store.SaveAuditEntry(&ipam.AuditEntry{ID: "e4", Timestamp: released.Add(3 * time.Minute), Action: "release_requested", Resource: "a1", User: "dave"}))
+	require.NoError(t, store.SaveAuditEntry(&ipam.AuditEntry{ID: "e5", Timestamp: released.Add(-time.Hour), Action: "reclaim_released", Resource: "a3", User: "reclaim"}))
+	assert.Equal(t, "bob", ReleasedBy(store, alloc))
+	later := released.Add(time.Hour)
+	assert.Equal(t, "", ReleasedBy(store, &ipam.IPAllocation{ID: "a3", ReleasedAt: &later}))
+	assert.Equal(t, "reclaim", ReleasedBy(store, &ipam.IPAllocation{ID: "a3"}))
+	assert.Contains(t, CheckReleasable(store, alloc).Error(), "by bob")
+}
+
+func TestLastReleased(t *testing.T) {
+	store, cleanup := createTestPebbleStore(t)
+	defer cleanup()
+
+	first := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+	require.NoError(t, store.SaveAllocation(&ipam.IPAllocation{ID: "a1", NetworkID: "net1", IP: "10.0.0.5", ReleasedAt: &first}))
+	require.NoError(t, store.SaveAllocation(&ipam.IPAllocation{ID: "a2", NetworkID: "net1", IP: "10.0.0.4", EndIP: "10.0.0.6", ReleasedAt: &second}))
+	require.NoError(t, store.SaveAllocation(&ipam.IPAllocation{ID: "a3", NetworkID: "net1", IP: "10.0.0.7"}))
+
+	alloc, err := LastReleased(store, "net1", "10.0.0.5")
+	require.NoError(t, err)
+	require.NotNil(t, alloc)
+	assert.Equal(t, "a2", alloc.ID)
+
+	alloc, err = LastReleased(store, "net1", "10.0.0.7")
+	require.NoError(t, err)
+	assert.Nil(t, alloc)
+
+	_, err = LastReleased(store, "net1", "bogus")
+	assert.Error(t, err)
+}
+
+func TestCheckRequestedIP(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	earlier := now.Add(-time.Hour)
+	later := now.Add(time.Minute)
+	network := &ipam.Network{ID: "net1", CIDR: "10.0.0.0/24"}
+	allocations := []*ipam.IPAllocation{
+		{ID: "active", NetworkID: "net1", IP: "10.0.0.10", EndIP: "10.0.0.12"},
+		{ID: "released", NetworkID: "net1", IP: "10.0.0.20", ReleasedAt: &earlier},
+		{ID: "expired", NetworkID: "net1", IP: "10.0.0.30", ExpiresAt: &earlier},
+		{ID: "held", NetworkID: "net1", IP: "10.0.0.40", Tags: []string{HoldTag("t1")}, ExpiresAt: &later},
+	}
+
+	ip, err := CheckRequestedIP(network, allocations, "10.0.0.20", now)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.20", ip)
+
+	ip, err = CheckRequestedIP(network, allocations, "::ffff:10.0.0.30", now)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.30", ip)
+
+	_, err = CheckRequestedIP(network, allocations, "10.0.0.11", now)
+	assert.ErrorIs(t, err, ipam.ErrIPNotAvailable)
+	assert.Contains(t, err.Error(), "active")
+
+	// Held addresses stay unavailable until the hold expires
+	_, err = CheckRequestedIP(network, allocations, "10.0.0.40", now)
+	assert.ErrorIs(t, err, ipam.ErrIPNotAvailable)
+	assert.Contains(t, err.Error(), "on hold")
+
+	for _, ip := range []string{"10.0.1.1", "10.0.0.0", "10.0.0.255"} {
+		_, err = CheckRequestedIP(network, allocations, ip, now)
+		assert.ErrorIs(t, err, ErrIPOutOfRange, ip)
+	}
+
+	_, err = CheckRequestedIP(network, allocations, "bogus", now)
+	assert.Error(t, err)
+}
@@ -0,0 +1,55 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// ScanAuditEntries calls fn with each audit entry of s logged at or after
+// since, oldest first, and stops at the first error fn returns. Stores that
+// can stream their log are read one entry at a time; the others are listed
+// in full.
+func ScanAuditEntries(s ipam.Store, since time.Time, fn func(*ipam.AuditEntry) error) error {
+	if scanner, ok := s.(interface {
+		ScanAuditEntries(time.Time, func(*ipam.AuditEntry) error) error
+	}); ok {
+		return scanner.ScanAuditEntries(since, fn)
+	}
+
+	entries, err := s.ListAuditEntries(0)
+	if err != nil {
+		return err
+	}
+	// Listed most recent first
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Timestamp.Before(since) {
+			continue
+		}
+		if err := fn(entries[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordAudit saves an audit entry, logged by user at now, for a change
+// made directly on s rather than through the allocator, which audits its
+// own changes
+func RecordAudit(s ipam.Store, user, action, resource, details string, now time.Time) error {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("failed to generate audit entry ID: %w", err)
+	}
+	return s.SaveAuditEntry(&ipam.AuditEntry{
+		ID:        hex.EncodeToString(b),
+		Timestamp: now,
+		Action:    action,
+		Resource:  resource,
+		Details:   details,
+		User:      user,
+	})
+}
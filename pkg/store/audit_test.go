@@ -0,0 +1,52 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanAuditEntries(t *testing.T) {
+	store, cleanup := createTestPebbleStore(t)
+	defer cleanup()
+
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, store.SaveAuditEntry(&ipam.AuditEntry{
+			ID: fmt.Sprintf("e%d", i), Timestamp: start.Add(time.Duration(i) * time.Minute), Action: "allocate",
+		}))
+	}
+	scan := func(s ipam.Store, since time.Time) []string {
+		var ids []string
+		require.NoError(t, ScanAuditEntries(s, since, func(e *ipam.AuditEntry) error {
+			ids = append(ids, e.ID)
+			return nil
+		}))
+		return ids
+	}
+
+	assert.Equal(t, []string{"e0", "e1", "e2", "e3", "e4"}, scan(store, time.Time{}))
+	assert.Equal(t, []string{"e2", "e3", "e4"}, scan(store, start.Add(2*time.Minute)))
+	assert.Empty(t, scan(store, start.Add(time.Hour)))
+
+	// Stores that cannot stream are listed in full
+	staging := NewStaging(store)
+	require.NoError(t, staging.SaveAuditEntry(&ipam.AuditEntry{ID: "staged", Timestamp: start.Add(5 * time.Minute)}))
+	assert.Equal(t, []string{"e3", "e4", "staged"}, scan(staging, start.Add(3*time.Minute)))
+
+	stop := errors.New("stop")
+	var n int
+	err := ScanAuditEntries(store, time.Time{}, func(*ipam.AuditEntry) error {
+		if n++; n == 2 {
+			return stop
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 2, n)
+}
@@ -0,0 +1,5 @@
+package store
+
+// AutoCreatedTag marks a network the server created because an allocation
+// named its CIDR before it was registered
+const AutoCreatedTag = "auto_created"
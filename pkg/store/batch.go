@@ -0,0 +1,104 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// ErrBatchConflict is returned by ApplyBatch when records a batch was
+// prepared from changed before it was applied. Preparing the batch again
+// from the current records may succeed.
+var ErrBatchConflict = errors.New("records changed while the batch was prepared")
+
+// Batch is a set of records saved together: either all of them or, when the
+// store fails or a check does not hold, none
+type Batch struct {
+	Networks     []*ipam.Network
+	Allocations  []*ipam.IPAllocation
+	AuditEntries []*ipam.AuditEntry
+
+	// NetworksDigest, when set, is the NetworksDigest the store's networks
+	// must still have for the batch to apply
+	NetworksDigest string
+
+	// AllocationDigests maps network IDs to the AllocationsDigest their
+	// allocations must still have for the batch to apply
+	AllocationDigests map[string]string
+}
+
+// NetworksDigest summarizes the networks' IDs and CIDRs, in any order. It
+// changes when a network is added, removed or resized.
+func NetworksDigest(networks []*ipam.Network) string {
+	lines := make([]string, len(networks))
+	for i, n := range networks {
+		lines[i] = n.ID + " " + n.CIDR
+	}
+	return digestLines(lines)
+}
+
+// AllocationsDigest summarizes the allocations' IDs, addresses, release and
+// expiry, in any order. It changes whenever the addresses free for
+// allocation may have.
+func AllocationsDigest(allocations []*ipam.IPAllocation) string {
+	lines := make([]string, len(allocations))
+	for i, a := range allocations {
+		lines[i] = fmt.Sprintf("%s %s %s %d %d", a.ID, a.IP, a.EndIP, unixNano(a.ReleasedAt), unixNano(a.ExpiresAt))
+	}
+	return digestLines(lines)
+}
+
+// digestLines hashes lines regardless of their order. Only comparable
+// values go into them, not encodings such as a time's zone, so replicas
+// holding the same records always agree.
+func digestLines(lines []string) string {
+	sort.Strings(lines)
+	h := sha256.New()
+	for _, line := range lines {
+		h.Write([]byte(line))
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func unixNano(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// checkBatch verifies b's digests against the records listed by
+// listNetworks and listAllocations
+func checkBatch(b *Batch, listNetworks func() ([]*ipam.Network, error), listAllocations func(networkID string) ([]*ipam.IPAllocation, error)) error {
+	if b.NetworksDigest != "" {
+		networks, err := listNetworks()
+		if err != nil {
+			return err
+		}
+		if NetworksDigest(networks) != b.NetworksDigest {
+			return fmt.Errorf("%w: networks", ErrBatchConflict)
+		}
+	}
+	// Sorted so every replica reports the same network
+	ids := make([]string, 0, len(b.AllocationDigests))
+	for id := range b.AllocationDigests {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		allocations, err := listAllocations(id)
+		if err != nil {
+			return err
+		}
+		if AllocationsDigest(allocations) != b.AllocationDigests[id] {
+			return fmt.Errorf("%w: allocations of network %s", ErrBatchConflict, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaging(t *testing.T) {
+	base, cleanup := createTestPebbleStore(t)
+	defer cleanup()
+	require.NoError(t, base.SaveNetwork(&ipam.Network{ID: "net-1", CIDR: "10.0.0.0/24"}))
+	require.NoError(t, base.SaveAllocation(&ipam.IPAllocation{ID: "alloc-1", NetworkID: "net-1", IP: "10.0.0.1"}))
+
+	staging := NewStaging(base)
+	require.NoError(t, staging.SaveNetwork(&ipam.Network{ID: "net-2", CIDR: "10.1.0.0/24"}))
+	require.NoError(t, staging.SaveAllocation(&ipam.IPAllocation{ID: "alloc-2", NetworkID: "net-1", IP: "10.0.0.2"}))
+	require.NoError(t, staging.SaveAllocation(&ipam.IPAllocation{ID: "alloc-3", NetworkID: "net-2", IP: "10.1.0.1"}))
+	require.NoError(t, staging.SaveAuditEntry(&ipam.AuditEntry{ID: "audit-1", Action: "network_add"}))
+
+	// Reads see staged writes over the base
+	network, err := staging.GetNetworkByCIDR("10.1.0.0/24")
+	require.NoError(t, err)
+	assert.Equal(t, "net-2", network.ID)
+	networks, err := staging.ListNetworks()
+	require.NoError(t, err)
+	assert.Len(t, networks, 2)
+	allocations, err := staging.ListAllocations("net-1")
+	require.NoError(t, err)
+	assert.Len(t, allocations, 2)
+	alloc, err := staging.GetAllocationByIP("net-2", "10.1.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, "alloc-3", alloc.ID)
+	entries, err := staging.ListAuditEntries(1)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "audit-1", entries[0].ID)
+
+	assert.ErrorIs(t, staging.DeleteNetwork("net-1"), ErrStagedDelete)
+	assert.ErrorIs(t, staging.DeleteAllocation("alloc-1"), ErrStagedDelete)
+
+	// The base is untouched
+	_, err = base.GetNetwork("net-2")
+	assert.ErrorIs(t, err, ipam.ErrNetworkNotFound)
+	allocations, err = base.ListAllocations("net-1")
+	require.NoError(t, err)
+	assert.Len(t, allocations, 1)
+
+	// The batch holds the writes and the digests of the records read
+	batch := staging.Batch()
+	assert.Len(t, batch.Networks, 1)
+	assert.Len(t, batch.Allocations, 2)
+	assert.Len(t, batch.AuditEntries, 1)
+	baseNetworks, err := base.ListNetworks()
+	require.NoError(t, err)
+	assert.Equal(t, NetworksDigest(baseNetworks), batch.NetworksDigest)
+	baseAllocations, err := base.ListAllocations("net-1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"net-1": AllocationsDigest(baseAllocations),
+		"net-2": AllocationsDigest(nil),
+	}, batch.AllocationDigests)
+}
+
+func TestDigests(t *testing.T) {
+	a := &ipam.IPAllocation{ID: "a", IP: "10.0.0.1"}
+	b := &ipam.IPAllocation{ID: "b", IP: "10.0.0.2"}
+	assert.Equal(t, AllocationsDigest([]*ipam.IPAllocation{a, b}), AllocationsDigest([]*ipam.IPAllocation{b, a}))
+
+	// Releasing changes the digest; the time's zone does not
+	released := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	inZone := released.In(time.FixedZone("", 3600))
+	a2, a3 := *a, *a
+	a2.ReleasedAt, a3.ReleasedAt = &released, &inZone
+	assert.NotEqual(t, AllocationsDigest([]*ipam.IPAllocation{a}), AllocationsDigest([]*ipam.IPAllocation{&a2}))
+	assert.Equal(t, AllocationsDigest([]*ipam.IPAllocation{&a2}), AllocationsDigest([]*ipam.IPAllocation{&a3}))
+
+	n := &ipam.Network{ID: "n", CIDR: "10.0.0.0/24"}
+	resized := &ipam.Network{ID: "n", CIDR: "10.0.0.0/23"}
+	assert.NotEqual(t, NetworksDigest([]*ipam.Network{n}), NetworksDigest([]*ipam.Network{resized}))
+}
+
+func TestApplyBatch(t *testing.T) {
+	stores := map[string]func(t *testing.T) (ipam.Store, func()){
+		"Pebble": func(t *testing.T) (ipam.Store, func()) { return createTestPebbleStore(t) },
+		"Raft":   func(t *testing.T) (ipam.Store, func()) { return createTestRaftStore(t, 1) },
+	}
+	for name, create := range stores {
+		t.Run(name, func(t *testing.T) {
+			s, cleanup := create(t)
+			defer cleanup()
+			applier := s.(interface{ ApplyBatch(*Batch) error })
+			require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "net-1", CIDR: "10.0.0.0/24"}))
+
+			staging := NewStaging(s)
+			_, err := staging.ListAllocations("net-1")
+			require.NoError(t, err)
+			require.NoError(t, staging.SaveNetwork(&ipam.Network{ID: "net-2", CIDR: "10.1.0.0/24"}))
+			require.NoError(t, staging.SaveAllocation(&ipam.IPAllocation{ID: "alloc-1", NetworkID: "net-1", IP: "10.0.0.1"}))
+			require.NoError(t, staging.SaveAuditEntry(&ipam.AuditEntry{ID: "audit-1", Timestamp: time.Now(), Action: "ip_allocated"}))
+			batch := staging.Batch()
+
+			// A write to a network the batch read from makes it stale
+			require.NoError(t, s.SaveAllocation(&ipam.IPAllocation{ID: "alloc-0", NetworkID: "net-1", IP: "10.0.0.1"}))
+			assert.ErrorIs(t, applier.ApplyBatch(batch), ErrBatchConflict)
+			_, err = s.GetNetwork("net-2")
+			assert.ErrorIs(t, err, ipam.ErrNetworkNotFound)
+
+			// Prepared again, it applies in full
+			staging = NewStaging(s)
+			_, err = staging.ListAllocations("net-1")
+			require.NoError(t, err)
+			require.NoError(t, staging.SaveNetwork(&ipam.Network{ID: "net-2", CIDR: "10.1.0.0/24"}))
+			require.NoError(t, staging.SaveAllocation(&ipam.IPAllocation{ID: "alloc-1", NetworkID: "net-1", IP: "10.0.0.2"}))
+			require.NoError(t, staging.SaveAuditEntry(&ipam.AuditEntry{ID: "audit-1", Timestamp: time.Now(), Action: "ip_allocated"}))
+			require.NoError(t, applier.ApplyBatch(staging.Batch()))
+
+			network, err := s.GetNetworkByCIDR("10.1.0.0/24")
+			require.NoError(t, err)
+			assert.Equal(t, "net-2", network.ID)
+			alloc, err := s.GetAllocationByIP("net-1", "10.0.0.2")
+			require.NoError(t, err)
+			assert.Equal(t, "alloc-1", alloc.ID)
+			entries, err := s.ListAuditEntries(0)
+			require.NoError(t, err)
+			require.Len(t, entries, 1)
+			assert.Equal(t, "audit-1", entries[0].ID)
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package store
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/lni/dragonboat/v3/logger"
+)
+
+// End-to-end benchmarks of the engine over each store. "make bench" runs
+// them and "make bench-check" compares the results with a baseline recorded
+// by "make bench-baseline" on the same machine. No baseline is committed and
+// CI does not run the check.
+
+// listAllocations is the number of allocations BenchmarkListAllocations
+// lists; -short lists fewer
+const listAllocations = 1_000_000
+
+// benchNetworks numbers the networks benchmarks create, as the testing
+// package runs each benchmark function several times against the same store
+var benchNetworks int
+
+// addBenchNetwork adds a fresh network; cidr is a format for its number
+func addBenchNetwork(b *testing.B, client *ipam.IPAM, cidr string) *ipam.Network {
+	b.Helper()
+	benchNetworks++
+	network, err := client.AddNetwork(fmt.Sprintf(cidr, benchNetworks), "bench", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return network
+}
+
+// benchStore is a store benchmarked end to end
+type benchStore struct {
+	name  string
+	store ipam.Store
+}
+
+// benchStores opens each store benchmarked end to end
+func benchStores(b *testing.B) []benchStore {
+	b.Helper()
+
+	pebbleStore, err := NewPebbleStore(b.TempDir())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { pebbleStore.Close() })
+
+	return []benchStore{
+		{"pebble", pebbleStore},
+		{"raft", benchRaftStore(b)},
+	}
+}
+
+// benchRaftStore starts a single-node Raft cluster on a free port
+func benchRaftStore(b *testing.B) *RaftStore {
+	b.Helper()
+
+	// Keep node startup chatter out of the results
+	for _, name := range []string{"dragonboat", "logdb", "transport", "config", "settings"} {
+		logger.GetLogger(name).SetLevel(logger.ERROR)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	members := map[uint64]string{1: addr}
+	raftStore, err := NewRaftStore(1, 1, addr, false, members, b.TempDir())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { raftStore.Close() })
+
+	for deadline := time.Now().Add(30 * time.Second); ; {
+		if info, err := raftStore.GetClusterInfo(); err == nil && info.HasLeader {
+			return raftStore
+		}
+		if time.Now().After(deadline) {
+			b.Fatal("cluster failed to elect leader")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// BenchmarkAllocateRelease measures one allocation and its release through
+// the engine, the basic unit of IPAM traffic
+func BenchmarkAllocateRelease(b *testing.B) {
+	for _, s := range benchStores(b) {
+		b.Run(s.name, func(b *testing.B) {
+			client := ipam.New(s.store)
+			network := addBenchNetwork(b, client, "10.%d.0.0/16")
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				alloc, err := client.AllocateIP(&ipam.AllocationRequest{NetworkID: network.ID, Hostname: fmt.Sprintf("host-%d", i)})
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := client.ReleaseIP(network.ID, alloc.IP); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkAllocate measures allocations into a filling network, which
+// the engine must skip past
+func BenchmarkAllocate(b *testing.B) {
+	for _, s := range benchStores(b) {
+		b.Run(s.name, func(b *testing.B) {
+			client := ipam.New(s.store)
+			network := addBenchNetwork(b, client, "fd00:%x::/64")
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := client.AllocateIP(&ipam.AllocationRequest{NetworkID: network.ID}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkListAllocations measures listing a network holding a million
+// allocations, written directly to the store to keep setup fast
+func BenchmarkListAllocations(b *testing.B) {
+	count := listAllocations
+	if testing.Short() {
+		count = 10_000
+	}
+
+	s, err := NewPebbleStore(b.TempDir())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+
+	network := &ipam.Network{ID: "bench-net", CIDR: "10.0.0.0/8", CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	if err := s.SaveNetwork(network); err != nil {
+		b.Fatal(err)
+	}
+	now := time.Now()
+	for i := 0; i < count; i++ {
+		err := s.SaveAllocation(&ipam.IPAllocation{
+			ID:          fmt.Sprintf("alloc%07d", i),
+			NetworkID:   network.ID,
+			IP:          fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff),
+			Hostname:    fmt.Sprintf("host-%d", i),
+			Status:      "allocated",
+			AllocatedAt: now,
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.Run(fmt.Sprintf("allocations=%d", count), func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			allocations, err := s.ListAllocations(network.ID)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if len(allocations) != count {
+				b.Fatalf("listed %d allocations, want %d", len(allocations), count)
+			}
+		}
+	})
+}
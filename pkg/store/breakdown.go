@@ -0,0 +1,164 @@
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// GatewayTag marks the allocation holding a network's gateway address
+const GatewayTag = "gateway"
+
+// ReservationStatus is the stored status of allocations that set addresses
+// aside rather than assign them, such as the reservations of a plan
+const ReservationStatus = "reserved"
+
+// AddressBreakdown accounts for every address of a network exactly once, so
+// that the counters add up to Total, the size of the CIDR. Counts saturate
+// at the largest uint64, which only IPv6 networks of /64 and larger reach.
+type AddressBreakdown struct {
+	Total uint64 `json:"total"`
+
+	// Network is the network address; for IPv6 the Subnet-Router anycast
+	// address. Broadcast is the IPv4 broadcast address. Both are zero for
+	// point-to-point networks and single addresses.
+	Network   uint64 `json:"network"`
+	Broadcast uint64 `json:"broadcast"`
+
+	// Gateway counts addresses of allocations tagged gateway
+	Gateway uint64 `json:"gateway"`
+
+	// ReservedRanges counts addresses of reservations, see ReservationStatus
+	ReservedRanges uint64 `json:"reserved_ranges"`
+
+	// Holds counts addresses held for a token, see HoldTagPrefix
+	Holds uint64 `json:"holds"`
+
+	// Allocated counts the addresses of every other active allocation
+	Allocated uint64 `json:"allocated"`
+
+	// Expired counts the addresses of allocations that expired but were
+	// not released yet: still taken, but reclaimable
+	Expired uint64 `json:"expired"`
+
+	// Free is what is left
+	Free uint64 `json:"free"`
+}
+
+// BreakDownAddresses classifies the addresses of network at now. Released
+// allocations do not count and expired ones count as Expired, whatever
+// their kind. An active allocation tagged gateway counts as the gateway
+// even when it is also a hold or a reservation, and a hold counts as one
+// even when reserved.
+func BreakDownAddresses(network *ipam.Network, allocations []*ipam.IPAllocation, now time.Time) (*AddressBreakdown, error) {
+	prefix, err := netip.ParsePrefix(network.CIDR)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q: %w", network.CIDR, err)
+	}
+	prefix = prefix.Masked()
+	first, last := prefix.Addr(), lastAddr(prefix)
+	pointToPoint := PointToPoint(network)
+
+	b := &AddressBreakdown{Total: span(first, last)}
+	if reservedAddr(prefix, pointToPoint, first) {
+		b.Network = 1
+	}
+	if reservedAddr(prefix, pointToPoint, last) {
+		b.Broadcast = 1
+	}
+
+	type addrRange struct {
+		first, last netip.Addr
+		counter     *uint64
+	}
+	var ranges []addrRange
+	for _, alloc := range allocations {
+		status := AllocationStatus(alloc, now)
+		if status == StatusReleased {
+			continue
+		}
+		start, end, err := allocationRange(alloc)
+		if err != nil {
+			return nil, err
+		}
+		if !prefix.Contains(start) || !prefix.Contains(end) || end.Less(start) {
+			continue
+		}
+		counter := &b.Allocated
+		switch {
+		case status == StatusExpired:
+			counter = &b.Expired
+		case slices.Contains(alloc.Tags, GatewayTag):
+			counter = &b.Gateway
+		case slices.ContainsFunc(alloc.Tags, func(tag string) bool { return strings.HasPrefix(tag, HoldTagPrefix) }):
+			counter = &b.Holds
+		case alloc.Status == ReservationStatus:
+			counter = &b.ReservedRanges
+		}
+		ranges = append(ranges, addrRange{start, end, counter})
+	}
+	slices.SortFunc(ranges, func(a, b addrRange) int { return a.first.Compare(b.first) })
+
+	// Allocations should not overlap each other or the network and
+	// broadcast addresses; should they, each address is counted once
+	var next netip.Addr // First address not counted yet, invalid at the start
+	for _, r := range ranges {
+		start := r.first
+		if next.IsValid() && start.Less(next) {
+			start = next
+		}
+		if r.last.Less(start) {
+			continue
+		}
+		n := span(start, r.last)
+		if b.Network == 1 && start == first {
+			n--
+		}
+		if b.Broadcast == 1 && r.last == last {
+			n--
+		}
+		*r.counter = addCapped(*r.counter, n)
+		next = r.last.Next()
+		if !next.IsValid() {
+			break
+		}
+	}
+
+	used := b.Network + b.Broadcast
+	for _, n := range []uint64{b.Gateway, b.ReservedRanges, b.Holds, b.Allocated, b.Expired} {
+		used = addCapped(used, n)
+	}
+	if b.Total > used {
+		b.Free = b.Total - used
+	}
+	return b, nil
+}
+
+// span returns the number of addresses from first to last, both of the same
+// family with first not after last
+func span(first, last netip.Addr) uint64 {
+	f, l := first.As16(), last.As16()
+	fHi, fLo := binary.BigEndian.Uint64(f[:8]), binary.BigEndian.Uint64(f[8:])
+	lHi, lLo := binary.BigEndian.Uint64(l[:8]), binary.BigEndian.Uint64(l[8:])
+	if lHi != fHi && !(lHi-fHi == 1 && lLo < fLo) {
+		return math.MaxUint64
+	}
+	diff := lLo - fLo
+	if diff == math.MaxUint64 {
+		return diff
+	}
+	return diff + 1
+}
+
+func addCapped(a, b uint64) uint64 {
+	if a > math.MaxUint64-b {
+		return math.MaxUint64
+	}
+	return a + b
+}
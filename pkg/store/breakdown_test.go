@@ -0,0 +1,76 @@
+package store
+
+import (
+	"math"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreakDownAddresses(t *testing.T) {
+	now := time.Now()
+	earlier := now.Add(-time.Hour)
+	later := now.Add(time.Hour)
+
+	network := &ipam.Network{ID: "net1", CIDR: "10.0.0.0/24"}
+	allocations := []*ipam.IPAllocation{
+		{ID: "gw", IP: "10.0.0.1", Tags: []string{GatewayTag}},
+		{ID: "dhcp", IP: "10.0.0.100", EndIP: "10.0.0.199", Status: ReservationStatus},
+		{ID: "hold", IP: "10.0.0.2", Tags: []string{HoldTag("t1")}, ExpiresAt: &later},
+		{ID: "web", IP: "10.0.0.3", EndIP: "10.0.0.4"},
+		{ID: "released", IP: "10.0.0.5", ReleasedAt: &earlier},
+		{ID: "expired", IP: "10.0.0.6", ExpiresAt: &earlier},
+		{ID: "overlap", IP: "10.0.0.4", EndIP: "10.0.0.5"},
+		{ID: "outside", IP: "10.0.1.1"},
+	}
+	b, err := BreakDownAddresses(network, allocations, now)
+	require.NoError(t, err)
+	assert.Equal(t, &AddressBreakdown{
+		Total: 256, Network: 1, Broadcast: 1, Gateway: 1, ReservedRanges: 100, Holds: 1, Allocated: 3, Expired: 1, Free: 148,
+	}, b)
+
+	// A reservation covering the whole network leaves its network and
+	// broadcast addresses to those counters
+	b, err = BreakDownAddresses(&ipam.Network{CIDR: "10.0.1.0/30"}, []*ipam.IPAllocation{
+		{ID: "all", IP: "10.0.1.0", EndIP: "10.0.1.3", Status: ReservationStatus},
+	}, now)
+	require.NoError(t, err)
+	assert.Equal(t, &AddressBreakdown{Total: 4, Network: 1, Broadcast: 1, ReservedRanges: 2}, b)
+
+	b, err = BreakDownAddresses(&ipam.Network{CIDR: "10.0.2.0/31"}, nil, now)
+	require.NoError(t, err)
+	assert.Equal(t, &AddressBreakdown{Total: 2, Free: 2}, b)
+
+	b, err = BreakDownAddresses(&ipam.Network{CIDR: "2001:db8::/120"}, nil, now)
+	require.NoError(t, err)
+	assert.Equal(t, &AddressBreakdown{Total: 256, Network: 1, Free: 255}, b)
+
+	b, err = BreakDownAddresses(&ipam.Network{CIDR: "2001:db8::/64"}, nil, now)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(math.MaxUint64), b.Total)
+
+	_, err = BreakDownAddresses(&ipam.Network{CIDR: "bogus"}, nil, now)
+	assert.Error(t, err)
+}
+
+func TestSpan(t *testing.T) {
+	tests := []struct {
+		first, last string
+		want        uint64
+	}{
+		{"10.0.0.0", "10.0.0.0", 1},
+		{"10.0.0.0", "10.0.1.255", 512},
+		{"2001:db8::ffff:ffff:ffff:ff00", "2001:db8:0:1::ff", 512},
+		{"2001:db8::", "2001:db8::ffff:ffff:ffff:fffe", math.MaxUint64},
+		{"2001:db8::", "2001:db8::ffff:ffff:ffff:ffff", math.MaxUint64},
+		{"2001:db8::", "2001:db9::", math.MaxUint64},
+	}
+	for _, tt := range tests {
+		got := span(netip.MustParseAddr(tt.first), netip.MustParseAddr(tt.last))
+		assert.Equal(t, tt.want, got, "%s-%s", tt.first, tt.last)
+	}
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// OwnerTagPrefix labels an allocation with the team or person owning it
+const OwnerTagPrefix = "owner="
+
+// BulkSelector picks the allocations a bulk update changes. Empty fields
+// match everything.
+type BulkSelector struct {
+	// NetworkID or CIDR limits the update to one network
+	NetworkID string
+	CIDR      string
+
+	// Hostname is a glob in path.Match syntax, e.g. "web-*.example.com"
+	Hostname string
+
+	AllocationFilter
+}
+
+// Matches reports whether alloc is selected, apart from its network
+func (s *BulkSelector) Matches(alloc *ipam.IPAllocation, now time.Time) bool {
+	if s.Hostname != "" {
+		if ok, _ := path.Match(s.Hostname, alloc.Hostname); !ok {
+			return false
+		}
+	}
+	return len(FilterAllocations([]*ipam.IPAllocation{alloc}, s.AllocationFilter, now)) == 1
+}
+
+// AllocationPatch is a change to the metadata of many allocations
+type AllocationPatch struct {
+	// AddTags are added when missing; RemoveTags are removed first
+	AddTags    []string
+	RemoveTags []string
+
+	// Owner, when set, replaces the owner=<name> tag. An empty name
+	// removes it.
+	Owner *string
+}
+
+// Apply changes alloc's tags and reports whether they changed
+func (p *AllocationPatch) Apply(alloc *ipam.IPAllocation) bool {
+	var tags []string
+	for _, tag := range alloc.Tags {
+		if slices.Contains(p.RemoveTags, tag) || (p.Owner != nil && strings.HasPrefix(tag, OwnerTagPrefix)) {
+			continue
+		}
+		tags = append(tags, tag)
+	}
+
+	add := p.AddTags
+	if p.Owner != nil && *p.Owner != "" {
+		add = append(slices.Clip(add), OwnerTagPrefix+*p.Owner)
+	}
+	for _, tag := range add {
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+
+	if slices.Equal(tags, alloc.Tags) {
+		return false
+	}
+	alloc.Tags = tags
+	return true
+}
+
+// BulkUpdate is what PrepareBulkUpdate found to change
+type BulkUpdate struct {
+	// Matched counts the selected allocations, Updated those the patch
+	// changed
+	Matched int
+	Updated int
+
+	// Batch saves the changed allocations, and only applies while the
+	// networks' allocations are as they were read
+	Batch *Batch
+}
+
+// PrepareBulkUpdate applies patch to copies of the allocations sel selects
+func PrepareBulkUpdate(s ipam.Store, sel *BulkSelector, patch *AllocationPatch, now time.Time) (*BulkUpdate, error) {
+	var networks []*ipam.Network
+	switch {
+	case sel.NetworkID != "":
+		network, err := s.GetNetwork(sel.NetworkID)
+		if err != nil {
+			return nil, err
+		}
+		networks = []*ipam.Network{network}
+	case sel.CIDR != "":
+		network, err := s.GetNetworkByCIDR(sel.CIDR)
+		if err != nil {
+			return nil, err
+		}
+		networks = []*ipam.Network{network}
+	default:
+		var err error
+		if networks, err = s.ListNetworks(); err != nil {
+			return nil, err
+		}
+	}
+
+	update := &BulkUpdate{Batch: &Batch{AllocationDigests: make(map[string]string, len(networks))}}
+	for _, network := range networks {
+		allocations, err := s.ListAllocations(network.ID)
+		if err != nil {
+			return nil, err
+		}
+		update.Batch.AllocationDigests[network.ID] = AllocationsDigest(allocations)
+
+		for _, alloc := range allocations {
+			if !sel.Matches(alloc, now) {
+				continue
+			}
+			update.Matched++
+			patched := *alloc
+			if patch.Apply(&patched) {
+				update.Batch.Allocations = append(update.Batch.Allocations, &patched)
+				update.Updated++
+			}
+		}
+	}
+	return update, nil
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllocationPatch(t *testing.T) {
+	owner := "team-db"
+	none := ""
+	tests := []struct {
+		name  string
+		patch AllocationPatch
+		tags  []string
+		want  []string
+	}{
+		{"add", AllocationPatch{AddTags: []string{"pci", "env=prod"}}, []string{"env=prod"}, []string{"env=prod", "pci"}},
+		{"remove", AllocationPatch{RemoveTags: []string{"stale", "missing"}}, []string{"stale", "env=prod"}, []string{"env=prod"}},
+		{"replace", AllocationPatch{RemoveTags: []string{"env=dev"}, AddTags: []string{"env=prod"}}, []string{"env=dev"}, []string{"env=prod"}},
+		{"set owner", AllocationPatch{Owner: &owner}, []string{"owner=team-web", "pci"}, []string{"pci", "owner=team-db"}},
+		{"clear owner", AllocationPatch{Owner: &none}, []string{"owner=team-web", "pci"}, []string{"pci"}},
+		{"unchanged", AllocationPatch{AddTags: []string{"pci"}, Owner: &owner}, []string{"pci", "owner=team-db"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alloc := &ipam.IPAllocation{Tags: tt.tags}
+			changed := tt.patch.Apply(alloc)
+			if tt.want == nil {
+				assert.False(t, changed)
+				assert.Equal(t, tt.tags, alloc.Tags)
+				return
+			}
+			assert.True(t, changed)
+			assert.Equal(t, tt.want, alloc.Tags)
+		})
+	}
+}
+
+func TestPrepareBulkUpdate(t *testing.T) {
+	store, cleanup := createTestPebbleStore(t)
+	defer cleanup()
+
+	now := time.Now()
+	require.NoError(t, store.SaveNetwork(&ipam.Network{ID: "net1", CIDR: "10.0.0.0/24"}))
+	require.NoError(t, store.SaveNetwork(&ipam.Network{ID: "net2", CIDR: "10.0.1.0/24"}))
+	for _, a := range []*ipam.IPAllocation{
+		{ID: "a1", NetworkID: "net1", IP: "10.0.0.1", Hostname: "web-1.example.com", Tags: []string{"env=prod"}},
+		{ID: "a2", NetworkID: "net1", IP: "10.0.0.2", Hostname: "web-2.example.com", Tags: []string{"env=prod", "pci"}},
+		{ID: "a3", NetworkID: "net1", IP: "10.0.0.3", Hostname: "db-1.example.com", Tags: []string{"env=prod"}},
+		{ID: "a4", NetworkID: "net2", IP: "10.0.1.1", Hostname: "web-3.example.com", Tags: []string{"env=prod"}, ReleasedAt: &now},
+	} {
+		require.NoError(t, store.SaveAllocation(a))
+	}
+
+	patch := &AllocationPatch{AddTags: []string{"pci"}}
+	sel := &BulkSelector{Hostname: "web-*", AllocationFilter: AllocationFilter{Tags: []string{"env=prod"}}}
+	update, err := PrepareBulkUpdate(store, sel, patch, now)
+	require.NoError(t, err)
+	assert.Equal(t, 3, update.Matched)
+	assert.Equal(t, 2, update.Updated)
+	require.Len(t, update.Batch.Allocations, 2)
+	assert.Equal(t, "a1", update.Batch.Allocations[0].ID)
+	assert.Equal(t, "a4", update.Batch.Allocations[1].ID)
+	require.NoError(t, store.ApplyBatch(update.Batch))
+
+	a1, err := store.GetAllocation("a1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"env=prod", "pci"}, a1.Tags)
+
+	// Only one network, and only active allocations
+	sel = &BulkSelector{CIDR: "10.0.1.0/24", AllocationFilter: AllocationFilter{Status: StatusActive}}
+	update, err = PrepareBulkUpdate(store, sel, patch, now)
+	require.NoError(t, err)
+	assert.Zero(t, update.Matched)
+
+	// A batch prepared before an allocation is released does not undo it
+	sel = &BulkSelector{NetworkID: "net1"}
+	update, err = PrepareBulkUpdate(store, sel, &AllocationPatch{RemoveTags: []string{"pci"}}, now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, update.Updated)
+	a2, err := store.GetAllocation("a2")
+	require.NoError(t, err)
+	a2.ReleasedAt = &now
+	require.NoError(t, store.SaveAllocation(a2))
+	assert.True(t, errors.Is(store.ApplyBatch(update.Batch), ErrBatchConflict))
+
+	_, err = PrepareBulkUpdate(store, &BulkSelector{NetworkID: "missing"}, patch, now)
+	assert.Error(t, err)
+}
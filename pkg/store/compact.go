@@ -0,0 +1,106 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lni/dragonboat/v3"
+)
+
+// ErrNothingToCompact is returned by Compact when nothing was written since
+// the node's last snapshot
+var ErrNothingToCompact = errors.New("nothing written since the last snapshot")
+
+// snapshotDirPattern matches the directory of one snapshot, named after its
+// log index
+var snapshotDirPattern = regexp.MustCompile(`^snapshot-[0-9A-F]{16}$`)
+
+// StorageInfo reports the disk space a cluster node's Raft data uses
+type StorageInfo struct {
+	NodeID        uint64 `json:"node_id"`
+	Dir           string `json:"dir"`
+	LogBytes      int64  `json:"log_bytes"`
+	SnapshotBytes int64  `json:"snapshot_bytes"`
+	Snapshots     int    `json:"snapshots"`
+	TotalBytes    int64  `json:"total_bytes"`
+}
+
+// Storage measures this node's Raft log and snapshots on disk
+func (s *RaftStore) Storage() (*StorageInfo, error) {
+	info := &StorageInfo{NodeID: s.nodeID, Dir: s.dir}
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		// Files and directories may be removed by a compaction as we walk
+		if errors.Is(err, fs.ErrNotExist) && path != s.dir {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if snapshotDirPattern.MatchString(d.Name()) {
+				info.Snapshots++
+			}
+			return nil
+		}
+		fi, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		info.TotalBytes += fi.Size()
+		switch {
+		case underDir(rel, "logdb-"):
+			info.LogBytes += fi.Size()
+		case underDir(rel, "snapshot-"):
+			info.SnapshotBytes += fi.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// underDir reports whether the relative path rel lies in a directory whose
+// name starts with prefix
+func underDir(rel, prefix string) bool {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for _, part := range parts[:len(parts)-1] {
+		if strings.HasPrefix(part, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compact snapshots this node's state machine now, rather than after the
+// next SnapshotEntries writes, and discards every Raft log entry the
+// snapshot covers. It returns the snapshot's log index. The log's disk space
+// is reclaimed in the background. Each node keeps its own log, so compacting
+// one node does not compact the others.
+func (s *RaftStore) Compact(timeout time.Duration) (uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	index, err := s.nh.SyncRequestSnapshot(ctx, s.clusterID, dragonboat.SnapshotOption{
+		OverrideCompactionOverhead: true,
+		CompactionOverhead:         0,
+	})
+	if errors.Is(err, dragonboat.ErrRejected) {
+		return 0, ErrNothingToCompact
+	}
+	return index, err
+}
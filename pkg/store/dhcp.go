@@ -0,0 +1,112 @@
+package store
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// BootFileTagPrefix names the file network booting clients load, e.g.
+// boot-file=pxelinux.0
+const BootFileTagPrefix = "boot-file="
+
+// NextServerTagPrefix names the server network booting clients load their
+// boot file from
+const NextServerTagPrefix = "next-server="
+
+// DHCPOptionTagPrefix carries a DHCP option as <name or code>:<data>, e.g.
+// dhcp-option=tftp-server-name:10.0.0.5; tags may carry several
+const DHCPOptionTagPrefix = "dhcp-option="
+
+// DHCPOptions are the DHCP options handed out with an address, such as the
+// PXE provisioning metadata of a host
+type DHCPOptions struct {
+	// BootFile comes from the boot-file=<file> tag
+	BootFile string `json:"boot_file,omitempty"`
+
+	// NextServer comes from the next-server=<address> tag
+	NextServer string `json:"next_server,omitempty"`
+
+	// Options come from the dhcp-option=<name>:<data> tags, by option name
+	// or code
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// TagDHCPOptions returns the DHCP options tags carry, or nil when they carry
+// none
+func TagDHCPOptions(tags []string) *DHCPOptions {
+	o := &DHCPOptions{}
+	for _, tag := range tags {
+		if value, ok := strings.CutPrefix(tag, BootFileTagPrefix); ok {
+			o.BootFile = value
+		}
+		if value, ok := strings.CutPrefix(tag, NextServerTagPrefix); ok {
+			o.NextServer = value
+		}
+		if value, ok := strings.CutPrefix(tag, DHCPOptionTagPrefix); ok {
+			if name, data, ok := strings.Cut(value, ":"); ok && name != "" {
+				if o.Options == nil {
+					o.Options = make(map[string]string)
+				}
+				o.Options[name] = data
+			}
+		}
+	}
+	if o.BootFile == "" && o.NextServer == "" && len(o.Options) == 0 {
+		return nil
+	}
+	return o
+}
+
+// AllocationDHCPOptions returns the DHCP options of alloc, those of its
+// network overridden by its own one by one, or nil when neither has any
+func AllocationDHCPOptions(network *ipam.Network, alloc *ipam.IPAllocation) *DHCPOptions {
+	var networkTags []string
+	if network != nil {
+		networkTags = network.Tags
+	}
+	o := TagDHCPOptions(networkTags)
+	own := TagDHCPOptions(alloc.Tags)
+	if o == nil || own == nil {
+		if o == nil {
+			return own
+		}
+		return o
+	}
+	if own.BootFile != "" {
+		o.BootFile = own.BootFile
+	}
+	if own.NextServer != "" {
+		o.NextServer = own.NextServer
+	}
+	if o.Options == nil {
+		o.Options = make(map[string]string)
+	}
+	maps.Copy(o.Options, own.Options)
+	return o
+}
+
+// DHCPTags returns tags with their boot-file=, next-server= and dhcp-option=
+// tags replaced by those of options, for each of the three it sets
+func DHCPTags(tags []string, options *DHCPOptions) []string {
+	if options == nil {
+		return tags
+	}
+	kept := slices.DeleteFunc(slices.Clone(tags), func(tag string) bool {
+		return (options.BootFile != "" && strings.HasPrefix(tag, BootFileTagPrefix)) ||
+			(options.NextServer != "" && strings.HasPrefix(tag, NextServerTagPrefix)) ||
+			(len(options.Options) > 0 && strings.HasPrefix(tag, DHCPOptionTagPrefix))
+	})
+	if options.BootFile != "" {
+		kept = append(kept, BootFileTagPrefix+options.BootFile)
+	}
+	if options.NextServer != "" {
+		kept = append(kept, NextServerTagPrefix+options.NextServer)
+	}
+	for _, name := range slices.Sorted(maps.Keys(options.Options)) {
+		kept = append(kept, DHCPOptionTagPrefix+name+":"+options.Options[name])
+	}
+	return kept
+}
@@ -0,0 +1,33 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDHCPOptions(t *testing.T) {
+	network := &ipam.Network{ID: "net1", CIDR: "10.0.0.0/24", Tags: []string{"env=prod"}}
+	alloc := &ipam.IPAllocation{ID: "host", NetworkID: "net1", IP: "10.0.0.10"}
+	assert.Nil(t, AllocationDHCPOptions(network, alloc))
+
+	network.Tags = DHCPTags(append(network.Tags, "boot-file=old.0"), &DHCPOptions{
+		BootFile:   "pxelinux.0",
+		NextServer: "10.0.0.5",
+		Options:    map[string]string{"tftp-server-name": "10.0.0.5", "66": "boot.example.com"},
+	})
+	assert.Equal(t, []string{"env=prod", "boot-file=pxelinux.0", "next-server=10.0.0.5",
+		"dhcp-option=66:boot.example.com", "dhcp-option=tftp-server-name:10.0.0.5"}, network.Tags)
+	assert.Equal(t, network.Tags, DHCPTags(network.Tags, &DHCPOptions{}))
+
+	// The allocation's own options win one by one
+	alloc.Tags = []string{"boot-file=ipxe/undionly.kpxe", "dhcp-option=66:10.0.0.6", "dhcp-option=bad"}
+	assert.Equal(t, &DHCPOptions{
+		BootFile:   "ipxe/undionly.kpxe",
+		NextServer: "10.0.0.5",
+		Options:    map[string]string{"tftp-server-name": "10.0.0.5", "66": "10.0.0.6"},
+	}, AllocationDHCPOptions(network, alloc))
+	assert.Equal(t, &DHCPOptions{BootFile: "ipxe/undionly.kpxe", Options: map[string]string{"66": "10.0.0.6"}},
+		AllocationDHCPOptions(nil, alloc))
+}
@@ -0,0 +1,12 @@
+package store
+
+import "github.com/jeremyhahn/go-ipam/pkg/ipam"
+
+// Event is an audit entry numbered in the order the store recorded it.
+// Sequence numbers start at 1 and only grow, so a consumer that was offline
+// catches up by listing the events after the last one it handled. A jump of
+// more than one means the entries in between were trimmed from the log.
+type Event struct {
+	Seq uint64 `json:"seq"`
+	*ipam.AuditEntry
+}
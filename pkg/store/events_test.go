@@ -0,0 +1,102 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/lni/dragonboat/v3/statemachine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func eventSeqs(events []*Event) []string {
+	var seqs []string
+	for _, e := range events {
+		seqs = append(seqs, fmt.Sprintf("%d:%s", e.Seq, e.ID))
+	}
+	return seqs
+}
+
+func TestPebbleStoreEvents(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewPebbleStore(dir)
+	require.NoError(t, err)
+
+	// Events are numbered in the order they are saved, not by timestamp
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, s.SaveAuditEntry(&ipam.AuditEntry{ID: "a", Timestamp: start.Add(time.Minute)}))
+	require.NoError(t, s.SaveAuditEntry(&ipam.AuditEntry{ID: "b", Timestamp: start}))
+	require.NoError(t, s.ApplyBatch(&Batch{AuditEntries: []*ipam.AuditEntry{
+		{ID: "c", Timestamp: start.Add(2 * time.Minute)},
+		{ID: "d", Timestamp: start.Add(3 * time.Minute)},
+	}}))
+
+	events, err := s.ListEvents(0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1:a", "2:b", "3:c", "4:d"}, eventSeqs(events))
+	events, err = s.ListEvents(1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2:b", "3:c"}, eventSeqs(events))
+	events, err = s.ListEvents(4, 0)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	// A batch that does not apply uses no sequence numbers
+	assert.ErrorIs(t, s.ApplyBatch(&Batch{NetworksDigest: "stale", AuditEntries: []*ipam.AuditEntry{{ID: "x"}}}), ErrBatchConflict)
+
+	// Numbering carries on after reopening
+	require.NoError(t, s.Close())
+	s, err = NewPebbleStore(dir)
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.SaveAuditEntry(&ipam.AuditEntry{ID: "e", Timestamp: start}))
+	events, err = s.ListEvents(3, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"4:d", "5:e"}, eventSeqs(events))
+}
+
+func TestStateMachineEvents(t *testing.T) {
+	sm := newIPAMStateMachine(1, 1)
+	save := func(id string) {
+		data, err := encode(&saveAuditCmd{Entry: &ipam.AuditEntry{ID: id}})
+		require.NoError(t, err)
+		_, err = sm.Update(append([]byte{byte(cmdSaveAudit)}, data...))
+		require.NoError(t, err)
+	}
+	list := func(sm statemachine.IStateMachine, since uint64, limit int) []string {
+		data, err := encode(&listEventsQuery{Since: since, Limit: limit})
+		require.NoError(t, err)
+		result, err := sm.Lookup(append([]byte{byte(queryListEvents)}, data...))
+		require.NoError(t, err)
+		return eventSeqs(result.([]*Event))
+	}
+
+	for i := 1; i <= 10002; i++ {
+		save(fmt.Sprintf("e%d", i))
+	}
+	assert.Equal(t, []string{"10001:e10001", "10002:e10002"}, list(sm, 10000, 0))
+
+	// The two oldest were trimmed, which a consumer sees as a gap
+	assert.Equal(t, []string{"3:e3", "4:e4"}, list(sm, 0, 2))
+
+	// Snapshots keep the numbering
+	var buf bytes.Buffer
+	require.NoError(t, sm.SaveSnapshot(&buf, nil, nil))
+	restored := newIPAMStateMachine(1, 2)
+	require.NoError(t, restored.RecoverFromSnapshot(&buf, nil, nil))
+	assert.Equal(t, []string{"10002:e10002"}, list(restored, 10001, 0))
+
+	// Entries are kept once by ID, also after a restore, while trimmed ones
+	// are forgotten
+	save("e10002")
+	save("e1")
+	assert.Equal(t, []string{"10003:e1"}, list(sm, 10002, 0))
+	data, err := encode(&saveAuditCmd{Entry: &ipam.AuditEntry{ID: "e10002"}})
+	require.NoError(t, err)
+	_, err = restored.Update(append([]byte{byte(cmdSaveAudit)}, data...))
+	require.NoError(t, err)
+	assert.Empty(t, list(restored, 10002, 0))
+}
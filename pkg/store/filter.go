@@ -0,0 +1,130 @@
+package store
+
+import (
+	"net"
+	"strings"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// NetworkFilter selects networks in FindNetworks. Empty fields match
+// everything; all non-empty fields must match.
+type NetworkFilter struct {
+	// CIDR matches the network CIDR exactly (after normalization)
+	CIDR string
+
+	// ContainsIP matches networks whose range includes this address
+	ContainsIP string
+
+	// Tags must all be present on the network
+	Tags []string
+
+	// Query is a case-insensitive substring of the description or CIDR
+	Query string
+
+	// Selector is a label selector evaluated over the network's tags, see
+	// ParseSelector. An invalid selector matches nothing.
+	Selector string
+}
+
+// IsEmpty reports whether the filter matches every network
+func (f *NetworkFilter) IsEmpty() bool {
+	return f.CIDR == "" && f.ContainsIP == "" && len(f.Tags) == 0 && f.Query == "" && f.Selector == ""
+}
+
+// Match reports whether network satisfies the filter
+func (f *NetworkFilter) Match(network *ipam.Network) bool {
+	if !f.matchFields(network) {
+		return false
+	}
+	if f.Selector == "" {
+		return true
+	}
+	sel, err := ParseSelector(f.Selector)
+	return err == nil && sel.MatchesTags(network.Tags)
+}
+
+// matchFields evaluates every criterion except the selector
+func (f *NetworkFilter) matchFields(network *ipam.Network) bool {
+	if f.CIDR != "" && network.CIDR != normalizeCIDR(f.CIDR) {
+		return false
+	}
+
+	if f.ContainsIP != "" {
+		ip := net.ParseIP(f.ContainsIP)
+		_, ipNet, err := net.ParseCIDR(network.CIDR)
+		if ip == nil || err != nil || !ipNet.Contains(ip) {
+			return false
+		}
+	}
+
+	for _, tag := range f.Tags {
+		if !hasTag(network.Tags, tag) {
+			return false
+		}
+	}
+
+	if f.Query != "" {
+		q := strings.ToLower(f.Query)
+		if !strings.Contains(strings.ToLower(network.Description), q) &&
+			!strings.Contains(network.CIDR, q) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FilterNetworks returns the networks matching f, preserving order
+func FilterNetworks(networks []*ipam.Network, f NetworkFilter) []*ipam.Network {
+	if f.IsEmpty() {
+		return networks
+	}
+
+	var matched []*ipam.Network
+	for _, network := range networks {
+		if f.matchFields(network) {
+			matched = append(matched, network)
+		}
+	}
+	return f.applySelector(matched)
+}
+
+// applySelector narrows networks, which already passed matchFields, to those
+// matching the selector using a label index
+func (f *NetworkFilter) applySelector(networks []*ipam.Network) []*ipam.Network {
+	if f.Selector == "" {
+		return networks
+	}
+	sel, err := ParseSelector(f.Selector)
+	if err != nil {
+		return nil
+	}
+
+	tagSets := make([][]string, len(networks))
+	for i, network := range networks {
+		tagSets[i] = network.Tags
+	}
+
+	var selected []*ipam.Network
+	for _, i := range NewLabelIndex(tagSets).Select(sel) {
+		selected = append(selected, networks[i])
+	}
+	return selected
+}
+
+func normalizeCIDR(cidr string) string {
+	if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+		return ipNet.String()
+	}
+	return cidr
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
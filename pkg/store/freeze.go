@@ -0,0 +1,136 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// A maintenance freeze is recorded in a network's tags so it travels with
+// the network through every store and export: freeze=<reason> marks the
+// network frozen and an optional freeze-until=<RFC3339 time> ends it.
+const (
+	FreezeTagPrefix      = "freeze="
+	FreezeUntilTagPrefix = "freeze-until="
+)
+
+// ErrNetworkFrozen is returned when allocating or releasing in a network
+// under a maintenance freeze
+var ErrNetworkFrozen = errors.New("network is frozen")
+
+// Freeze describes an active maintenance freeze
+type Freeze struct {
+	Reason string     `json:"reason"`
+	Until  *time.Time `json:"until,omitempty"`
+}
+
+func (f *Freeze) String() string {
+	if f.Until == nil {
+		return f.Reason
+	}
+	return fmt.Sprintf("%s (until %s)", f.Reason, f.Until.Local().Format("2006-01-02 15:04:05"))
+}
+
+// NetworkFreeze returns the freeze in effect on network at now, or nil when
+// the network is not frozen or its freeze has expired
+func NetworkFreeze(network *ipam.Network, now time.Time) *Freeze {
+	var f *Freeze
+	var until string
+	for _, tag := range network.Tags {
+		if reason, ok := strings.CutPrefix(tag, FreezeTagPrefix); ok {
+			f = &Freeze{Reason: reason}
+		} else if value, ok := strings.CutPrefix(tag, FreezeUntilTagPrefix); ok {
+			until = value
+		}
+	}
+	if f == nil || until == "" {
+		return f
+	}
+
+	t, err := time.Parse(time.RFC3339, until)
+	if err != nil {
+		// An unreadable expiry keeps the network frozen rather than opening it
+		return f
+	}
+	if !now.Before(t) {
+		return nil
+	}
+	f.Until = &t
+	return f
+}
+
+// FreezeTags returns tags with any previous freeze replaced by one for
+// reason, ending at until unless it is nil
+func FreezeTags(tags []string, reason string, until *time.Time) []string {
+	tags = UnfreezeTags(tags)
+	tags = append(tags, FreezeTagPrefix+reason)
+	if until != nil {
+		tags = append(tags, FreezeUntilTagPrefix+until.UTC().Format(time.RFC3339))
+	}
+	return tags
+}
+
+// UnfreezeTags returns tags without the freeze tags
+func UnfreezeTags(tags []string) []string {
+	kept := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, FreezeTagPrefix) && !strings.HasPrefix(tag, FreezeUntilTagPrefix) {
+			kept = append(kept, tag)
+		}
+	}
+	return kept
+}
+
+// IsSystemTag reports whether tag is set on networks through endpoints of
+// its own, a maintenance freeze or an ACL, rather than with the network's
+// other tags
+func IsSystemTag(tag string) bool {
+	return strings.HasPrefix(tag, FreezeTagPrefix) || strings.HasPrefix(tag, FreezeUntilTagPrefix) || IsACLTag(tag)
+}
+
+// KeepSystemTags returns tags, without system tags, followed by the system
+// tags of current, so that replacing a network's tags with them leaves its
+// freeze and ACL as they are
+func KeepSystemTags(tags, current []string) []string {
+	kept := make([]string, 0, len(tags)+len(current))
+	for _, tag := range tags {
+		if !IsSystemTag(tag) {
+			kept = append(kept, tag)
+		}
+	}
+	for _, tag := range current {
+		if IsSystemTag(tag) {
+			kept = append(kept, tag)
+		}
+	}
+	return kept
+}
+
+// CheckNotFrozen returns ErrNetworkFrozen, with the reason, when network is
+// frozen at now
+func CheckNotFrozen(network *ipam.Network, now time.Time) error {
+	if f := NetworkFreeze(network, now); f != nil {
+		return fmt.Errorf("%w: %s: %s", ErrNetworkFrozen, network.CIDR, f)
+	}
+	return nil
+}
+
+// CheckNetworkNotFrozen looks up the network by id, or by cidr when id is
+// empty, and checks that it is not frozen. A network that cannot be found
+// is not reported here; the operation being guarded reports it.
+func CheckNetworkNotFrozen(s ipam.Store, id, cidr string, now time.Time) error {
+	var network *ipam.Network
+	var err error
+	if id != "" {
+		network, err = s.GetNetwork(id)
+	} else {
+		network, err = s.GetNetworkByCIDR(cidr)
+	}
+	if err != nil {
+		return nil
+	}
+	return CheckNotFrozen(network, now)
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkFreeze(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	until := now.Add(2 * time.Hour)
+
+	network := &ipam.Network{ID: "net1", CIDR: "10.0.0.0/24", Tags: []string{"env=prod"}}
+	assert.Nil(t, NetworkFreeze(network, now))
+	assert.NoError(t, CheckNotFrozen(network, now))
+
+	network.Tags = FreezeTags(network.Tags, "q4-change-freeze", &until)
+	assert.Equal(t, []string{"env=prod", "freeze=q4-change-freeze", "freeze-until=2026-10-16T14:00:00Z"}, network.Tags)
+
+	f := NetworkFreeze(network, now)
+	require.NotNil(t, f)
+	assert.Equal(t, "q4-change-freeze", f.Reason)
+	require.NotNil(t, f.Until)
+	assert.True(t, f.Until.Equal(until))
+
+	err := CheckNotFrozen(network, now)
+	assert.True(t, errors.Is(err, ErrNetworkFrozen))
+	assert.Contains(t, err.Error(), "q4-change-freeze")
+
+	// The freeze lapses at its expiry
+	assert.Nil(t, NetworkFreeze(network, until))
+
+	// Re-freezing replaces the previous freeze; no expiry means indefinite
+	network.Tags = FreezeTags(network.Tags, "incident", nil)
+	assert.Equal(t, []string{"env=prod", "freeze=incident"}, network.Tags)
+	require.NotNil(t, NetworkFreeze(network, until.Add(24*time.Hour)))
+
+	network.Tags = UnfreezeTags(network.Tags)
+	assert.Equal(t, []string{"env=prod"}, network.Tags)
+	assert.Nil(t, NetworkFreeze(network, now))
+}
+
+func TestCheckNetworkNotFrozen(t *testing.T) {
+	store, cleanup := createTestPebbleStore(t)
+	defer cleanup()
+
+	now := time.Now()
+	require.NoError(t, store.SaveNetwork(&ipam.Network{ID: "net1", CIDR: "10.0.0.0/24", Tags: FreezeTags(nil, "audit", nil)}))
+	require.NoError(t, store.SaveNetwork(&ipam.Network{ID: "net2", CIDR: "10.0.1.0/24"}))
+
+	assert.True(t, errors.Is(CheckNetworkNotFrozen(store, "net1", "", now), ErrNetworkFrozen))
+	assert.True(t, errors.Is(CheckNetworkNotFrozen(store, "", "10.0.0.0/24", now), ErrNetworkFrozen))
+	assert.NoError(t, CheckNetworkNotFrozen(store, "net2", "", now))
+	assert.NoError(t, CheckNetworkNotFrozen(store, "missing", "", now))
+}
+
+func TestKeepSystemTags(t *testing.T) {
+	current := []string{"env=dev", "freeze=incident", ACLTagPrefix(PermissionView) + "ops"}
+	assert.Equal(t, []string{"env=prod", "freeze=incident", "acl-view=ops"}, KeepSystemTags([]string{"env=prod", "freeze=other"}, current))
+	assert.Equal(t, []string{"env=dev"}, KeepSystemTags(current, nil))
+}
@@ -0,0 +1,33 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Tags recording where a public address is registered, set by the GeoIP
+// annotator: an ISO 3166-1 alpha-2 country code and an autonomous system
+// number, e.g. geo-country=DE and geo-asn=3320
+const (
+	CountryTagPrefix = "geo-country="
+	ASNTagPrefix     = "geo-asn="
+)
+
+// CountryTag returns the tag of country, a two-letter code in any case
+func CountryTag(country string) (string, error) {
+	code := strings.ToUpper(country)
+	if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+		return "", fmt.Errorf("invalid country %q: must be a two-letter ISO 3166-1 code", country)
+	}
+	return CountryTagPrefix + code, nil
+}
+
+// ASNTag returns the tag of asn, a number with or without an AS prefix
+func ASNTag(asn string) (string, error) {
+	n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(asn), "AS"), 10, 32)
+	if err != nil {
+		return "", fmt.Errorf("invalid asn %q: must be an AS number such as 3320 or AS3320", asn)
+	}
+	return ASNTagPrefix + strconv.FormatUint(n, 10), nil
+}
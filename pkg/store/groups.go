@@ -0,0 +1,60 @@
+package store
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// GroupTagPrefix marks an allocation as a member of a linked group. Groups
+// have no record of their own: a group exists while it has active members.
+const GroupTagPrefix = "group="
+
+// ErrGroupNotFound is returned when no active allocation belongs to a group
+var ErrGroupNotFound = errors.New("group not found")
+
+// GroupTag returns the tag that places an allocation in group id
+func GroupTag(id string) string {
+	return GroupTagPrefix + id
+}
+
+// AllocationGroup returns the group alloc belongs to, or "" if none
+func AllocationGroup(alloc *ipam.IPAllocation) string {
+	for _, tag := range alloc.Tags {
+		if id, ok := strings.CutPrefix(tag, GroupTagPrefix); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// GroupMembers returns the active allocations of group id across all
+// networks, oldest first
+func GroupMembers(s ipam.Store, id string, now time.Time) ([]*ipam.IPAllocation, error) {
+	networks, err := s.ListNetworks()
+	if err != nil {
+		return nil, err
+	}
+
+	var members []*ipam.IPAllocation
+	for _, network := range networks {
+		allocations, err := s.ListAllocations(network.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, alloc := range allocations {
+			if AllocationStatus(alloc, now) == StatusActive && AllocationGroup(alloc) == id {
+				members = append(members, alloc)
+			}
+		}
+	}
+
+	if len(members) == 0 {
+		return nil, ErrGroupNotFound
+	}
+	sort.SliceStable(members, func(i, j int) bool { return members[i].AllocatedAt.Before(members[j].AllocatedAt) })
+	return members, nil
+}
@@ -0,0 +1,36 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupMembers(t *testing.T) {
+	store, cleanup := createTestPebbleStore(t)
+	defer cleanup()
+
+	now := time.Now()
+	past := now.Add(-time.Hour)
+
+	require.NoError(t, store.SaveNetwork(&ipam.Network{ID: "net1", CIDR: "10.0.0.0/24"}))
+	require.NoError(t, store.SaveNetwork(&ipam.Network{ID: "net2", CIDR: "10.0.1.0/24"}))
+	require.NoError(t, store.SaveAllocation(&ipam.IPAllocation{ID: "a1", NetworkID: "net2", IP: "10.0.1.5", Tags: []string{"env=prod", GroupTag("svc")}, AllocatedAt: now}))
+	require.NoError(t, store.SaveAllocation(&ipam.IPAllocation{ID: "a2", NetworkID: "net1", IP: "10.0.0.5", Tags: []string{GroupTag("svc")}, AllocatedAt: past}))
+	require.NoError(t, store.SaveAllocation(&ipam.IPAllocation{ID: "a3", NetworkID: "net1", IP: "10.0.0.6", Tags: []string{GroupTag("svc")}, ReleasedAt: &now}))
+	require.NoError(t, store.SaveAllocation(&ipam.IPAllocation{ID: "a4", NetworkID: "net1", IP: "10.0.0.7", Tags: []string{GroupTag("other")}}))
+
+	members, err := GroupMembers(store, "svc", now)
+	require.NoError(t, err)
+	require.Len(t, members, 2)
+	assert.Equal(t, "a2", members[0].ID)
+	assert.Equal(t, "a1", members[1].ID)
+	assert.Equal(t, "svc", AllocationGroup(members[1]))
+
+	_, err = GroupMembers(store, "missing", now)
+	assert.True(t, errors.Is(err, ErrGroupNotFound))
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// HoldTagPrefix marks an allocation as a hold: an address set aside for a
+// short TTL until an allocation presenting the hold's token takes it over.
+// Holds have no record of their own; an expired hold is an expired
+// allocation and frees its address like any other.
+const HoldTagPrefix = "hold="
+
+// ErrHoldNotFound is returned when no active hold in a network has a token
+var ErrHoldNotFound = errors.New("hold not found or expired")
+
+// HoldTag returns the tag that makes an allocation the hold with token
+func HoldTag(token string) string {
+	return HoldTagPrefix + token
+}
+
+// FindHold returns the active hold with token in networkID
+func FindHold(s ipam.Store, networkID, token string, now time.Time) (*ipam.IPAllocation, error) {
+	allocations, err := s.ListAllocations(networkID)
+	if err != nil {
+		return nil, err
+	}
+	for _, alloc := range allocations {
+		if AllocationStatus(alloc, now) == StatusActive && slices.Contains(alloc.Tags, HoldTag(token)) {
+			return alloc, nil
+		}
+	}
+	return nil, ErrHoldNotFound
+}
+
+// ConvertHold returns hold turned into the allocation req asks for, keeping
+// its ID and address
+func ConvertHold(hold *ipam.IPAllocation, req *ipam.AllocationRequest, now time.Time) *ipam.IPAllocation {
+	alloc := *hold
+	alloc.Hostname = req.Hostname
+	alloc.Description = req.Description
+	alloc.Tags = nil
+	for _, tag := range req.Tags {
+		if !strings.HasPrefix(tag, HoldTagPrefix) {
+			alloc.Tags = append(alloc.Tags, tag)
+		}
+	}
+	alloc.AllocatedAt = now
+	alloc.ExpiresAt = nil
+	if req.TTL > 0 {
+		expires := now.Add(time.Duration(req.TTL) * time.Second)
+		alloc.ExpiresAt = &expires
+	}
+	return &alloc
+}
@@ -0,0 +1,54 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHolds(t *testing.T) {
+	store, cleanup := createTestPebbleStore(t)
+	defer cleanup()
+
+	now := time.Now()
+	soon := now.Add(time.Minute)
+	require.NoError(t, store.SaveNetwork(&ipam.Network{ID: "net1", CIDR: "10.0.0.0/24"}))
+	hold := &ipam.IPAllocation{ID: "h1", NetworkID: "net1", IP: "10.0.0.1", Tags: []string{HoldTag("abc")}, AllocatedAt: now, ExpiresAt: &soon}
+	require.NoError(t, store.SaveAllocation(hold))
+
+	found, err := FindHold(store, "net1", "abc", now)
+	require.NoError(t, err)
+	assert.Equal(t, "h1", found.ID)
+
+	// Expired holds and unknown tokens are not found
+	for _, tt := range []struct {
+		token string
+		at    time.Time
+	}{{"abc", soon.Add(time.Second)}, {"other", now}} {
+		_, err = FindHold(store, "net1", tt.token, tt.at)
+		assert.True(t, errors.Is(err, ErrHoldNotFound), tt.token)
+	}
+
+	later := now.Add(time.Hour)
+	alloc := ConvertHold(found, &ipam.AllocationRequest{
+		Hostname: "web-1",
+		Tags:     []string{"env=prod", HoldTag("forged")},
+		TTL:      60,
+	}, later)
+	assert.Equal(t, "h1", alloc.ID)
+	assert.Equal(t, "10.0.0.1", alloc.IP)
+	assert.Equal(t, "web-1", alloc.Hostname)
+	assert.Equal(t, []string{"env=prod"}, alloc.Tags)
+	assert.Equal(t, later, alloc.AllocatedAt)
+	require.NotNil(t, alloc.ExpiresAt)
+	assert.Equal(t, later.Add(time.Minute), *alloc.ExpiresAt)
+
+	// Without a TTL the allocation no longer expires
+	alloc = ConvertHold(found, &ipam.AllocationRequest{}, later)
+	assert.Nil(t, alloc.ExpiresAt)
+	assert.Equal(t, []string{HoldTag("abc")}, found.Tags, "the hold itself is not modified")
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"sync"
+
+	"github.com/lni/dragonboat/v3/raftio"
+)
+
+// leaderChangeBuffer is how many leader changes are kept for a slow
+// reader; older ones are dropped once it is full
+const leaderChangeBuffer = 16
+
+// LeaderChange reports that the Raft cluster elected a new leader, as seen
+// by this node
+type LeaderChange struct {
+	LeaderID uint64 `json:"leader_id"`
+	Term     uint64 `json:"term"`
+
+	// Previous is the leader this node knew before, or 0 for none
+	Previous uint64 `json:"previous"`
+}
+
+// leaderListener turns dragonboat's leader updates into LeaderChanges. A
+// node is told when it loses a leader (LeaderID 0) as well as when it
+// learns of one; only the latter are passed on.
+type leaderListener struct {
+	clusterID uint64
+	mu        sync.Mutex
+	previous  uint64
+	closed    bool
+	changes   chan LeaderChange
+}
+
+func newLeaderListener(clusterID uint64) *leaderListener {
+	return &leaderListener{clusterID: clusterID, changes: make(chan LeaderChange, leaderChangeBuffer)}
+}
+
+// LeaderUpdated implements raftio.IRaftEventListener. It must not block, as
+// dragonboat calls every listener from one goroutine.
+func (l *leaderListener) LeaderUpdated(info raftio.LeaderInfo) {
+	if info.ClusterID != l.clusterID || info.LeaderID == 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed || info.LeaderID == l.previous {
+		return
+	}
+	change := LeaderChange{LeaderID: info.LeaderID, Term: info.Term, Previous: l.previous}
+	l.previous = info.LeaderID
+	select {
+	case l.changes <- change:
+	default:
+	}
+}
+
+func (l *leaderListener) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.closed {
+		l.closed = true
+		close(l.changes)
+	}
+}
+
+// LeaderChanges returns the leaders this node learns of, in order, until
+// the store is closed. Every node sees each election, so a consumer that
+// should act once per election acts only when LeaderID is its own node.
+func (s *RaftStore) LeaderChanges() <-chan LeaderChange {
+	return s.leaders.changes
+}
@@ -0,0 +1,33 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/lni/dragonboat/v3/raftio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaderListener(t *testing.T) {
+	l := newLeaderListener(1)
+	updates := []raftio.LeaderInfo{
+		{ClusterID: 1, NodeID: 2, Term: 1, LeaderID: 1},
+		{ClusterID: 1, NodeID: 2, Term: 1, LeaderID: 1}, // Repeated
+		{ClusterID: 1, NodeID: 2, Term: 2, LeaderID: 0}, // Leader lost
+		{ClusterID: 9, NodeID: 2, Term: 2, LeaderID: 3}, // Another cluster
+		{ClusterID: 1, NodeID: 2, Term: 3, LeaderID: 2},
+	}
+	for _, info := range updates {
+		l.LeaderUpdated(info)
+	}
+	l.close()
+	l.LeaderUpdated(raftio.LeaderInfo{ClusterID: 1, NodeID: 2, Term: 4, LeaderID: 3})
+
+	var changes []LeaderChange
+	for change := range l.changes {
+		changes = append(changes, change)
+	}
+	assert.Equal(t, []LeaderChange{
+		{LeaderID: 1, Term: 1},
+		{LeaderID: 2, Term: 3, Previous: 1},
+	}, changes)
+}
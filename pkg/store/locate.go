@@ -0,0 +1,99 @@
+package store
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// Location describes where an address sits in the network tree
+type Location struct {
+	IP string `json:"ip"`
+
+	// Network is the most specific network containing the address
+	Network *ipam.Network `json:"network"`
+
+	// Networks lists every containing network, most specific first
+	Networks []*ipam.Network `json:"networks"`
+
+	// Allocation is the unreleased allocation covering the address, if any,
+	// searched from the most specific network outwards
+	Allocation *ipam.IPAllocation `json:"allocation,omitempty"`
+
+	// Status is "active" or "expired" when Allocation is set, otherwise
+	// "available"
+	Status string `json:"status"`
+}
+
+// Locate finds the networks containing ip and the allocation covering it.
+// It returns ipam.ErrNetworkNotFound when no network contains the address.
+func Locate(s ipam.Store, ip string, now time.Time) (*Location, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP address %q", ip)
+	}
+	addr = addr.Unmap()
+
+	var networks []*ipam.Network
+	filter := NetworkFilter{ContainsIP: addr.String()}
+	if finder, ok := s.(interface {
+		FindNetworks(NetworkFilter) ([]*ipam.Network, error)
+	}); ok {
+		networks, err = finder.FindNetworks(filter)
+	} else {
+		networks, err = s.ListNetworks()
+		networks = FilterNetworks(networks, filter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(networks) == 0 {
+		return nil, ipam.ErrNetworkNotFound
+	}
+
+	sort.SliceStable(networks, func(i, j int) bool {
+		return prefixBits(networks[i]) > prefixBits(networks[j])
+	})
+
+	loc := &Location{
+		IP:       addr.String(),
+		Network:  networks[0],
+		Networks: networks,
+		Status:   "available",
+	}
+
+	for _, network := range networks {
+		allocations, err := s.ListAllocations(network.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, alloc := range allocations {
+			if alloc.ReleasedAt != nil {
+				continue
+			}
+			first, last, err := allocationRange(alloc)
+			if err != nil || addr.Less(first) || last.Less(addr) {
+				continue
+			}
+			loc.Allocation = alloc
+			loc.Status = "active"
+			if alloc.ExpiresAt != nil && alloc.ExpiresAt.Before(now) {
+				loc.Status = "expired"
+			}
+			return loc, nil
+		}
+	}
+
+	return loc, nil
+}
+
+func prefixBits(network *ipam.Network) int {
+	p, err := netip.ParsePrefix(network.CIDR)
+	if err != nil {
+		return -1
+	}
+	return p.Bits()
+}
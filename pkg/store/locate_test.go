@@ -0,0 +1,50 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocate(t *testing.T) {
+	store, cleanup := createTestPebbleStore(t)
+	defer cleanup()
+
+	now := time.Now()
+	past := now.Add(-time.Hour)
+
+	require.NoError(t, store.SaveNetwork(&ipam.Network{ID: "net1", CIDR: "10.0.0.0/8"}))
+	require.NoError(t, store.SaveNetwork(&ipam.Network{ID: "net2", CIDR: "10.1.0.0/16"}))
+	require.NoError(t, store.SaveNetwork(&ipam.Network{ID: "net3", CIDR: "10.1.2.0/24"}))
+	require.NoError(t, store.SaveAllocation(&ipam.IPAllocation{ID: "a1", NetworkID: "net2", IP: "10.1.2.10", EndIP: "10.1.2.20", Hostname: "pool"}))
+	require.NoError(t, store.SaveAllocation(&ipam.IPAllocation{ID: "a2", NetworkID: "net3", IP: "10.1.2.30", ReleasedAt: &past}))
+	require.NoError(t, store.SaveAllocation(&ipam.IPAllocation{ID: "a3", NetworkID: "net1", IP: "10.9.0.1", ExpiresAt: &past}))
+
+	loc, err := Locate(store, "10.1.2.15", now)
+	require.NoError(t, err)
+	assert.Equal(t, "net3", loc.Network.ID)
+	require.Len(t, loc.Networks, 3)
+	assert.Equal(t, []string{"net3", "net2", "net1"}, []string{loc.Networks[0].ID, loc.Networks[1].ID, loc.Networks[2].ID})
+	require.NotNil(t, loc.Allocation)
+	assert.Equal(t, "a1", loc.Allocation.ID)
+	assert.Equal(t, "active", loc.Status)
+
+	loc, err = Locate(store, "10.1.2.30", now)
+	require.NoError(t, err)
+	assert.Nil(t, loc.Allocation)
+	assert.Equal(t, "available", loc.Status)
+
+	loc, err = Locate(store, "10.9.0.1", now)
+	require.NoError(t, err)
+	assert.Equal(t, "net1", loc.Network.ID)
+	assert.Equal(t, "expired", loc.Status)
+
+	_, err = Locate(store, "192.168.0.1", now)
+	assert.Equal(t, ipam.ErrNetworkNotFound, err)
+
+	_, err = Locate(store, "bogus", now)
+	assert.Error(t, err)
+}
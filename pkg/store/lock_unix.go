@@ -0,0 +1,15 @@
+//go:build !windows
+
+package store
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isLockError reports whether err is a failure to lock a database directory
+// that another process holds, which fcntl reports as EAGAIN. EACCES is not
+// one: it is what opening a directory without permission reports.
+func isLockError(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EWOULDBLOCK)
+}
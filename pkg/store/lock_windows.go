@@ -0,0 +1,20 @@
+//go:build windows
+
+package store
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Windows errors for a file another process has locked or opened
+const (
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// isLockError reports whether err is a failure to lock a database directory
+// that another process holds
+func isLockError(err error) bool {
+	return errors.Is(err, errorSharingViolation) || errors.Is(err, errorLockViolation)
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// Point-to-point semantics (RFC 3021 for IPv4 /31, RFC 6164 for IPv6 /127)
+// make every address of a network usable: nothing is set aside for the
+// network, broadcast or Subnet-Router anycast address. A network's policy is
+// recorded in its tags as point-to-point=on or point-to-point=off; without
+// the tag it follows the prefix length, on for /31 and /127 only.
+const PointToPointTagPrefix = "point-to-point="
+
+// Point-to-point policy values
+const (
+	PointToPointOn  = "on"
+	PointToPointOff = "off"
+)
+
+// DefaultPointToPoint reports whether a network with prefix uses
+// point-to-point semantics when its tags do not say
+func DefaultPointToPoint(prefix netip.Prefix) bool {
+	return prefix.Bits() == prefix.Addr().BitLen()-1
+}
+
+// PointToPoint reports whether network uses point-to-point semantics
+func PointToPoint(network *ipam.Network) bool {
+	for _, tag := range network.Tags {
+		if value, ok := strings.CutPrefix(tag, PointToPointTagPrefix); ok {
+			return value == PointToPointOn
+		}
+	}
+	prefix, err := netip.ParsePrefix(network.CIDR)
+	return err == nil && DefaultPointToPoint(prefix)
+}
+
+// CheckPointToPoint validates the point-to-point policy tags give a network
+// with cidr. The policy is set at most once, to on or off, and only a /31
+// or /127 may turn it on, so that a larger network cannot lose its
+// reserved addresses by mistake.
+func CheckPointToPoint(cidr string, tags []string) error {
+	var policy string
+	for _, tag := range tags {
+		value, ok := strings.CutPrefix(tag, PointToPointTagPrefix)
+		if !ok {
+			continue
+		}
+		if value != PointToPointOn && value != PointToPointOff {
+			return fmt.Errorf("invalid tag %q: point-to-point must be %s or %s", tag, PointToPointOn, PointToPointOff)
+		}
+		if policy != "" {
+			return fmt.Errorf("point-to-point is set more than once")
+		}
+		policy = value
+	}
+	if policy != PointToPointOn {
+		return nil
+	}
+
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+	}
+	if !DefaultPointToPoint(prefix) {
+		want := 31
+		if prefix.Addr().Is6() {
+			want = 127
+		}
+		return fmt.Errorf("point-to-point=on requires a /%d, %s is a /%d", want, cidr, prefix.Bits())
+	}
+	return nil
+}
+
+// reservedAddr reports whether addr, inside prefix, is set aside rather
+// than allocated: the network and broadcast addresses of IPv4 networks and
+// the Subnet-Router anycast address of IPv6 ones, unless the network is
+// point-to-point. A single-address prefix reserves nothing.
+func reservedAddr(prefix netip.Prefix, pointToPoint bool, addr netip.Addr) bool {
+	if pointToPoint || prefix.IsSingleIP() {
+		return false
+	}
+	if addr == prefix.Addr() {
+		return true
+	}
+	return addr.Is4() && addr == lastAddr(prefix)
+}
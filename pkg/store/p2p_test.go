@@ -0,0 +1,85 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPointToPoint(t *testing.T) {
+	tests := []struct {
+		cidr string
+		tags []string
+		want bool
+	}{
+		{"10.0.0.0/31", nil, true},
+		{"10.0.0.0/30", nil, false},
+		{"10.0.0.1/32", nil, false},
+		{"2001:db8::/127", nil, true},
+		{"2001:db8::/64", nil, false},
+		{"10.0.0.0/31", []string{"point-to-point=off"}, false},
+		{"2001:db8::/127", []string{"point-to-point=off"}, false},
+	}
+	for _, tt := range tests {
+		network := &ipam.Network{CIDR: tt.cidr, Tags: tt.tags}
+		assert.Equal(t, tt.want, PointToPoint(network), "%s %v", tt.cidr, tt.tags)
+	}
+}
+
+func TestCheckPointToPoint(t *testing.T) {
+	tests := []struct {
+		cidr    string
+		tags    []string
+		wantErr string
+	}{
+		{"10.0.0.0/31", []string{"point-to-point=on"}, ""},
+		{"2001:db8::/127", []string{"point-to-point=on"}, ""},
+		{"10.0.0.0/24", []string{"point-to-point=off"}, ""},
+		{"10.0.0.0/24", []string{"env=prod"}, ""},
+		{"10.0.0.0/30", []string{"point-to-point=on"}, "requires a /31"},
+		{"10.0.0.1/32", []string{"point-to-point=on"}, "requires a /31"},
+		{"2001:db8::/64", []string{"point-to-point=on"}, "requires a /127"},
+		{"10.0.0.0/31", []string{"point-to-point=yes"}, "must be on or off"},
+		{"10.0.0.0/31", []string{"point-to-point=on", "point-to-point=off"}, "more than once"},
+	}
+	for _, tt := range tests {
+		err := CheckPointToPoint(tt.cidr, tt.tags)
+		if tt.wantErr == "" {
+			assert.NoError(t, err, "%s %v", tt.cidr, tt.tags)
+		} else if assert.Error(t, err, "%s %v", tt.cidr, tt.tags) {
+			assert.Contains(t, err.Error(), tt.wantErr)
+		}
+	}
+}
+
+func TestCheckRequestedIPPointToPoint(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		cidr     string
+		tags     []string
+		ip       string
+		reserved bool
+	}{
+		{"10.0.0.0/31", nil, "10.0.0.0", false},
+		{"10.0.0.0/31", nil, "10.0.0.1", false},
+		{"10.0.0.0/31", []string{"point-to-point=off"}, "10.0.0.0", true},
+		{"10.0.0.0/31", []string{"point-to-point=off"}, "10.0.0.1", true},
+		{"10.0.0.1/32", nil, "10.0.0.1", false},
+		{"2001:db8::/127", nil, "2001:db8::", false},
+		{"2001:db8::/127", []string{"point-to-point=off"}, "2001:db8::", true},
+		{"2001:db8::/127", []string{"point-to-point=off"}, "2001:db8::1", false},
+		{"2001:db8::/64", nil, "2001:db8::", true},
+		{"2001:db8::/64", nil, "2001:db8::ffff:ffff:ffff:ffff", false},
+	}
+	for _, tt := range tests {
+		network := &ipam.Network{ID: "net1", CIDR: tt.cidr, Tags: tt.tags}
+		_, err := CheckRequestedIP(network, nil, tt.ip, now)
+		if tt.reserved {
+			assert.ErrorIs(t, err, ErrIPOutOfRange, "%s in %s %v", tt.ip, tt.cidr, tt.tags)
+		} else {
+			assert.NoError(t, err, "%s in %s %v", tt.ip, tt.cidr, tt.tags)
+		}
+	}
+}
@@ -2,9 +2,13 @@ package store
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/cockroachdb/pebble"
 	"github.com/jeremyhahn/go-ipam/pkg/ipam"
@@ -14,6 +18,9 @@ import (
 type PebbleStore struct {
 	db *pebble.DB
 	mu sync.RWMutex
+
+	// eventSeq is the sequence number of the last audit entry saved
+	eventSeq uint64
 }
 
 // Key prefixes for different data types
@@ -22,8 +29,13 @@ const (
 	prefixAllocation = "allocation:"
 	prefixAudit      = "audit:"
 	prefixIndex      = "index:"
+	prefixEvent      = "event:"
 )
 
+// ErrLocked is returned by NewPebbleStore when another process has the
+// database open. Only one process can use a Pebble database at a time.
+var ErrLocked = errors.New("database is in use by another process")
+
 // NewPebbleStore creates a new PebbleDB-based store
 func NewPebbleStore(path string) (*PebbleStore, error) {
 	opts := &pebble.Options{
@@ -38,14 +50,39 @@ func NewPebbleStore(path string) (*PebbleStore, error) {
 
 	db, err := pebble.Open(filepath.Join(path, "ipam.pebble"), opts)
 	if err != nil {
+		if isLockError(err) {
+			return nil, fmt.Errorf("%w: %s", ErrLocked, path)
+		}
 		return nil, fmt.Errorf("failed to open PebbleDB: %w", err)
 	}
 
+	seq, err := lastEventSeq(db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to read event sequence: %w", err)
+	}
+
 	return &PebbleStore{
-		db: db,
+		db:       db,
+		eventSeq: seq,
 	}, nil
 }
 
+// Ping reports whether the database can serve reads
+func (s *PebbleStore) Ping() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, closer, err := s.db.Get([]byte(prefixIndex + "ping"))
+	if err == pebble.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return closer.Close()
+}
+
 // Close closes the database
 func (s *PebbleStore) Close() error {
 	return s.db.Close()
@@ -57,13 +94,40 @@ func (s *PebbleStore) SaveNetwork(network *ipam.Network) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	batch := s.db.NewBatch()
+	defer batch.Close()
+
+	if err := s.putNetwork(batch, network); err != nil {
+		return err
+	}
+	return batch.Commit(nil)
+}
+
+// putNetwork adds the writes saving network to batch. The caller holds
+// s.mu.
+func (s *PebbleStore) putNetwork(batch *pebble.Batch, network *ipam.Network) error {
 	data, err := json.Marshal(network)
 	if err != nil {
 		return err
 	}
 
-	batch := s.db.NewBatch()
-	defer batch.Close()
+	// Drop the CIDR index of the previous version if the CIDR changed
+	value, closer, err := s.db.Get([]byte(prefixNetwork + network.ID))
+	if err == nil {
+		var previous ipam.Network
+		err = json.Unmarshal(value, &previous)
+		closer.Close()
+		if err != nil {
+			return err
+		}
+		if previous.CIDR != network.CIDR {
+			if err := batch.Delete([]byte(prefixIndex+"cidr:"+previous.CIDR), nil); err != nil {
+				return err
+			}
+		}
+	} else if err != pebble.ErrNotFound {
+		return err
+	}
 
 	// Save network
 	if err := batch.Set([]byte(prefixNetwork+network.ID), data, nil); err != nil {
@@ -71,11 +135,7 @@ func (s *PebbleStore) SaveNetwork(network *ipam.Network) error {
 	}
 
 	// Create CIDR index
-	if err := batch.Set([]byte(prefixIndex+"cidr:"+network.CIDR), []byte(network.ID), nil); err != nil {
-		return err
-	}
-
-	return batch.Commit(nil)
+	return batch.Set([]byte(prefixIndex+"cidr:"+network.CIDR), []byte(network.ID), nil)
 }
 
 func (s *PebbleStore) GetNetwork(id string) (*ipam.Network, error) {
@@ -119,6 +179,11 @@ func (s *PebbleStore) ListNetworks() ([]*ipam.Network, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	return s.listNetworks()
+}
+
+// listNetworks is ListNetworks for callers holding s.mu
+func (s *PebbleStore) listNetworks() ([]*ipam.Network, error) {
 	var networks []*ipam.Network
 	iter := s.db.NewIter(&pebble.IterOptions{
 		LowerBound: []byte(prefixNetwork),
@@ -141,6 +206,36 @@ func (s *PebbleStore) ListNetworks() ([]*ipam.Network, error) {
 	return networks, nil
 }
 
+// FindNetworks returns the networks matching filter, evaluated while
+// iterating so non-matching records are never collected
+func (s *PebbleStore) FindNetworks(filter NetworkFilter) ([]*ipam.Network, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var networks []*ipam.Network
+	iter := s.db.NewIter(&pebble.IterOptions{
+		LowerBound: []byte(prefixNetwork),
+		UpperBound: []byte(prefixNetwork + "\xff"),
+	})
+	defer iter.Close()
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		var network ipam.Network
+		if err := json.Unmarshal(iter.Value(), &network); err != nil {
+			return nil, err
+		}
+		if filter.matchFields(&network) {
+			networks = append(networks, &network)
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	return filter.applySelector(networks), nil
+}
+
 func (s *PebbleStore) DeleteNetwork(id string) error {
 	// Get network to find CIDR for index deletion first (before locking)
 	network, err := s.GetNetwork(id)
@@ -197,14 +292,22 @@ func (s *PebbleStore) SaveAllocation(allocation *ipam.IPAllocation) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	batch := s.db.NewBatch()
+	defer batch.Close()
+
+	if err := putAllocation(batch, allocation); err != nil {
+		return err
+	}
+	return batch.Commit(nil)
+}
+
+// putAllocation adds the writes saving allocation to batch
+func putAllocation(batch *pebble.Batch, allocation *ipam.IPAllocation) error {
 	data, err := json.Marshal(allocation)
 	if err != nil {
 		return err
 	}
 
-	batch := s.db.NewBatch()
-	defer batch.Close()
-
 	// Save allocation
 	if err := batch.Set([]byte(prefixAllocation+allocation.ID), data, nil); err != nil {
 		return err
@@ -212,11 +315,7 @@ func (s *PebbleStore) SaveAllocation(allocation *ipam.IPAllocation) error {
 
 	// Create IP index
 	indexKey := fmt.Sprintf("%sip:%s:%s", prefixIndex, allocation.NetworkID, allocation.IP)
-	if err := batch.Set([]byte(indexKey), []byte(allocation.ID), nil); err != nil {
-		return err
-	}
-
-	return batch.Commit(nil)
+	return batch.Set([]byte(indexKey), []byte(allocation.ID), nil)
 }
 
 func (s *PebbleStore) GetAllocation(id string) (*ipam.IPAllocation, error) {
@@ -264,6 +363,11 @@ func (s *PebbleStore) ListAllocations(networkID string) ([]*ipam.IPAllocation, e
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	return s.listAllocations(networkID)
+}
+
+// listAllocations is ListAllocations for callers holding s.mu
+func (s *PebbleStore) listAllocations(networkID string) ([]*ipam.IPAllocation, error) {
 	var allocations []*ipam.IPAllocation
 	iter := s.db.NewIter(&pebble.IterOptions{
 		LowerBound: []byte(prefixAllocation),
@@ -288,6 +392,91 @@ func (s *PebbleStore) ListAllocations(networkID string) ([]*ipam.IPAllocation, e
 	return allocations, nil
 }
 
+// MoveAllocation reassigns an allocation to another network, keeping its ID,
+// addresses and history. The caller is responsible for checking the target
+// network with CheckTransfer.
+func (s *PebbleStore) MoveAllocation(id, networkID string) error {
+	allocation, err := s.GetAllocation(id)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batch := s.db.NewBatch()
+	defer batch.Close()
+
+	// Drop the old IP index unless a newer allocation of the same IP owns it
+	oldIndexKey := fmt.Sprintf("%sip:%s:%s", prefixIndex, allocation.NetworkID, allocation.IP)
+	value, closer, err := s.db.Get([]byte(oldIndexKey))
+	if err == nil {
+		owner := string(value)
+		closer.Close()
+		if owner == id {
+			if err := batch.Delete([]byte(oldIndexKey), nil); err != nil {
+				return err
+			}
+		}
+	} else if err != pebble.ErrNotFound {
+		return err
+	}
+
+	allocation.NetworkID = networkID
+	data, err := json.Marshal(allocation)
+	if err != nil {
+		return err
+	}
+	if err := batch.Set([]byte(prefixAllocation+id), data, nil); err != nil {
+		return err
+	}
+
+	indexKey := fmt.Sprintf("%sip:%s:%s", prefixIndex, networkID, allocation.IP)
+	if err := batch.Set([]byte(indexKey), []byte(id), nil); err != nil {
+		return err
+	}
+
+	return batch.Commit(nil)
+}
+
+// ApplyBatch saves every record of b in one atomic write, after checking
+// b's digests against the stored records. Nothing is saved when a check
+// fails with ErrBatchConflict.
+func (s *PebbleStore) ApplyBatch(b *Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := checkBatch(b, s.listNetworks, s.listAllocations); err != nil {
+		return err
+	}
+
+	batch := s.db.NewBatch()
+	defer batch.Close()
+
+	for _, network := range b.Networks {
+		if err := s.putNetwork(batch, network); err != nil {
+			return err
+		}
+	}
+	for _, allocation := range b.Allocations {
+		if err := putAllocation(batch, allocation); err != nil {
+			return err
+		}
+	}
+	seq := s.eventSeq
+	for _, entry := range b.AuditEntries {
+		seq++
+		if err := putAuditEntry(batch, entry, seq); err != nil {
+			return err
+		}
+	}
+	if err := batch.Commit(nil); err != nil {
+		return err
+	}
+	s.eventSeq = seq
+	return nil
+}
+
 func (s *PebbleStore) DeleteAllocation(id string) error {
 	// Get allocation to find IP for index deletion first (before locking)
 	allocation, err := s.GetAllocation(id)
@@ -306,9 +495,18 @@ func (s *PebbleStore) DeleteAllocation(id string) error {
 		return err
 	}
 
-	// Delete IP index
+	// Delete the IP index unless a newer allocation of the same IP owns it
 	indexKey := fmt.Sprintf("%sip:%s:%s", prefixIndex, allocation.NetworkID, allocation.IP)
-	if err := batch.Delete([]byte(indexKey), nil); err != nil {
+	value, closer, err := s.db.Get([]byte(indexKey))
+	if err == nil {
+		owner := string(value)
+		closer.Close()
+		if owner == id {
+			if err := batch.Delete([]byte(indexKey), nil); err != nil {
+				return err
+			}
+		}
+	} else if err != pebble.ErrNotFound {
 		return err
 	}
 
@@ -321,14 +519,94 @@ func (s *PebbleStore) SaveAuditEntry(entry *ipam.AuditEntry) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	batch := s.db.NewBatch()
+	defer batch.Close()
+
+	if err := putAuditEntry(batch, entry, s.eventSeq+1); err != nil {
+		return err
+	}
+	if err := batch.Commit(nil); err != nil {
+		return err
+	}
+	s.eventSeq++
+	return nil
+}
+
+// putAuditEntry adds the writes saving entry as event seq to batch. The
+// caller holds s.mu.
+func putAuditEntry(batch *pebble.Batch, entry *ipam.AuditEntry, seq uint64) error {
 	data, err := json.Marshal(entry)
 	if err != nil {
 		return err
 	}
+	key := auditKey(entry)
+	if err := batch.Set(key, data, nil); err != nil {
+		return err
+	}
+	// The event index points at the entry rather than copying it
+	return batch.Set(eventKey(seq), key, nil)
+}
+
+// auditKey leads with the timestamp so entries sort oldest first
+func auditKey(entry *ipam.AuditEntry) []byte {
+	return []byte(fmt.Sprintf("%s%d_%s", prefixAudit, entry.Timestamp.UnixNano(), entry.ID))
+}
+
+// eventKey zero-pads seq so that event keys sort by sequence number
+func eventKey(seq uint64) []byte {
+	return []byte(fmt.Sprintf("%s%020d", prefixEvent, seq))
+}
 
-	// Use timestamp as part of key for natural ordering
-	key := fmt.Sprintf("%s%d_%s", prefixAudit, entry.Timestamp.UnixNano(), entry.ID)
-	return s.db.Set([]byte(key), data, nil)
+// lastEventSeq returns the sequence number of the last event in db, zero
+// when it has none
+func lastEventSeq(db *pebble.DB) (uint64, error) {
+	iter := db.NewIter(&pebble.IterOptions{
+		LowerBound: []byte(prefixEvent),
+		UpperBound: []byte(prefixEvent + "\xff"),
+	})
+	defer iter.Close()
+
+	if !iter.Last() {
+		return 0, iter.Error()
+	}
+	return strconv.ParseUint(strings.TrimPrefix(string(iter.Key()), prefixEvent), 10, 64)
+}
+
+// ListEvents returns up to limit audit entries numbered after since, oldest
+// first. Entries saved before the store numbered them have no number and
+// are not listed.
+func (s *PebbleStore) ListEvents(since uint64, limit int) ([]*Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	iter := s.db.NewIter(&pebble.IterOptions{
+		LowerBound: eventKey(since + 1),
+		UpperBound: []byte(prefixEvent + "\xff"),
+	})
+	defer iter.Close()
+
+	var events []*Event
+	for iter.First(); iter.Valid() && (limit <= 0 || len(events) < limit); iter.Next() {
+		seq, err := strconv.ParseUint(strings.TrimPrefix(string(iter.Key()), prefixEvent), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		value, closer, err := s.db.Get(iter.Value())
+		if err == pebble.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var entry ipam.AuditEntry
+		err = json.Unmarshal(value, &entry)
+		closer.Close()
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, &Event{Seq: seq, AuditEntry: &entry})
+	}
+	return events, iter.Error()
 }
 
 func (s *PebbleStore) ListAuditEntries(limit int) ([]*ipam.AuditEntry, error) {
@@ -358,9 +636,10 @@ func (s *PebbleStore) ListAuditEntries(limit int) ([]*ipam.AuditEntry, error) {
 		return nil, err
 	}
 
-	// Return the last 'limit' entries (most recent)
+	// Return the last 'limit' entries (most recent), or all of them when
+	// limit is not positive
 	start := len(allEntries) - limit
-	if start < 0 {
+	if start < 0 || limit <= 0 {
 		start = 0
 	}
 
@@ -372,6 +651,40 @@ func (s *PebbleStore) ListAuditEntries(limit int) ([]*ipam.AuditEntry, error) {
 	return entries, nil
 }
 
+// ScanAuditEntries calls fn with each audit entry logged at or after since,
+// oldest first, without loading the log into memory. The entries are those
+// present when the scan starts; the store stays writable meanwhile.
+func (s *PebbleStore) ScanAuditEntries(since time.Time, fn func(*ipam.AuditEntry) error) error {
+	lower := []byte(prefixAudit)
+	if ns := since.UnixNano(); ns >= 1e18 {
+		// Keys hold the timestamp in decimal, which sorts by time while
+		// it has 19 digits: from 2001 to 2286
+		lower = []byte(fmt.Sprintf("%s%d", prefixAudit, ns))
+	}
+
+	s.mu.RLock()
+	iter := s.db.NewIter(&pebble.IterOptions{
+		LowerBound: lower,
+		UpperBound: []byte(prefixAudit + "\xff"),
+	})
+	s.mu.RUnlock()
+	defer iter.Close()
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		var entry ipam.AuditEntry
+		if err := json.Unmarshal(iter.Value(), &entry); err != nil {
+			return err
+		}
+		if entry.Timestamp.Before(since) {
+			continue
+		}
+		if err := fn(&entry); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
 // Helper method to get store statistics
 func (s *PebbleStore) GetStats() (*pebble.Metrics, error) {
 	return s.db.Metrics(), nil
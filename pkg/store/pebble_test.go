@@ -1,11 +1,17 @@
 package store
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store/storetest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -340,3 +346,122 @@ func BenchmarkPebbleStoreRead(b *testing.B) {
 		store.GetAllocationByIP("bench-net", fmt.Sprintf("10.0.0.%d", (i%100)+1))
 	}
 }
+
+func TestPebbleStoreFindNetworks(t *testing.T) {
+	store, cleanup := createTestPebbleStore(t)
+	defer cleanup()
+
+	networks := []*ipam.Network{
+		{ID: "net1", CIDR: "10.42.0.0/16", Description: "Datacenter East", Tags: []string{"prod", "east"}},
+		{ID: "net2", CIDR: "10.42.3.0/24", Description: "East web tier", Tags: []string{"prod", "web"}},
+		{ID: "net3", CIDR: "192.168.0.0/24", Description: "Lab", Tags: []string{"lab"}},
+	}
+	for _, n := range networks {
+		require.NoError(t, store.SaveNetwork(n))
+	}
+
+	ids := func(filter NetworkFilter) []string {
+		found, err := store.FindNetworks(filter)
+		require.NoError(t, err)
+		var out []string
+		for _, n := range found {
+			out = append(out, n.ID)
+		}
+		return out
+	}
+
+	assert.Equal(t, []string{"net1", "net2", "net3"}, ids(NetworkFilter{}))
+	assert.Equal(t, []string{"net2"}, ids(NetworkFilter{CIDR: "10.42.3.7/24"}))
+	assert.Equal(t, []string{"net1", "net2"}, ids(NetworkFilter{ContainsIP: "10.42.3.7"}))
+	assert.Equal(t, []string{"net1"}, ids(NetworkFilter{ContainsIP: "10.42.9.1"}))
+	assert.Equal(t, []string{"net2"}, ids(NetworkFilter{Tags: []string{"prod", "web"}}))
+	assert.Equal(t, []string{"net1", "net2"}, ids(NetworkFilter{Query: "EAST"}))
+	assert.Equal(t, []string{"net3"}, ids(NetworkFilter{Query: "192.168"}))
+	assert.Empty(t, ids(NetworkFilter{Tags: []string{"lab"}, ContainsIP: "10.42.3.7"}))
+	assert.Equal(t, []string{"net1", "net2"}, ids(NetworkFilter{Selector: "prod"}))
+	assert.Equal(t, []string{"net1"}, ids(NetworkFilter{Selector: "prod,!web", ContainsIP: "10.42.3.7"}))
+	assert.Empty(t, ids(NetworkFilter{Selector: "tier in (web"}))
+}
+
+func TestPebbleStoreMoveAllocation(t *testing.T) {
+	store, cleanup := createTestPebbleStore(t)
+	defer cleanup()
+
+	require.NoError(t, store.SaveNetwork(&ipam.Network{ID: "net1", CIDR: "10.0.0.0/16"}))
+	require.NoError(t, store.SaveNetwork(&ipam.Network{ID: "net2", CIDR: "10.0.1.0/24"}))
+	require.NoError(t, store.SaveAllocation(&ipam.IPAllocation{ID: "alloc1", NetworkID: "net1", IP: "10.0.1.5"}))
+
+	require.NoError(t, store.MoveAllocation("alloc1", "net2"))
+
+	alloc, err := store.GetAllocation("alloc1")
+	require.NoError(t, err)
+	assert.Equal(t, "net2", alloc.NetworkID)
+
+	_, err = store.GetAllocationByIP("net1", "10.0.1.5")
+	assert.Equal(t, ipam.ErrIPNotAllocated, err)
+	byIP, err := store.GetAllocationByIP("net2", "10.0.1.5")
+	require.NoError(t, err)
+	assert.Equal(t, "alloc1", byIP.ID)
+
+	old, err := store.ListAllocations("net1")
+	require.NoError(t, err)
+	assert.Empty(t, old)
+
+	assert.Equal(t, ipam.ErrIPNotAllocated, store.MoveAllocation("missing", "net2"))
+}
+
+func TestPebbleStoreConformance(t *testing.T) {
+	storetest.TestStore(t, func(t *testing.T) ipam.Store {
+		store, cleanup := createTestPebbleStore(t)
+		t.Cleanup(cleanup)
+		return store
+	})
+}
+
+// TestPebbleStoreLockedHelper holds a database open for
+// TestPebbleStoreLocked, which runs it in a child process
+func TestPebbleStoreLockedHelper(t *testing.T) {
+	dir := os.Getenv("IPAM_TEST_LOCK_DIR")
+	if dir == "" {
+		t.Skip("run by TestPebbleStoreLocked")
+	}
+	s, err := NewPebbleStore(dir)
+	require.NoError(t, err)
+	defer s.Close()
+	fmt.Println("locked")
+	// Hold the lock until the parent closes stdin
+	io.Copy(io.Discard, os.Stdin)
+}
+
+func TestPebbleStoreLocked(t *testing.T) {
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestPebbleStoreLockedHelper$")
+	cmd.Env = append(os.Environ(), "IPAM_TEST_LOCK_DIR="+dir)
+	stdin, err := cmd.StdinPipe()
+	require.NoError(t, err)
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	defer stdin.Close()
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "locked\n", line)
+
+	_, err = NewPebbleStore(dir)
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.ErrorContains(t, err, dir)
+
+	// Once the other process exits the database opens
+	stdin.Close()
+	require.NoError(t, cmd.Wait())
+	s, err := NewPebbleStore(dir)
+	require.NoError(t, err)
+	s.Close()
+}
+
+func TestIsLockError(t *testing.T) {
+	// Permission errors are reported as they are, not as a lock to wait for
+	assert.False(t, isLockError(&os.PathError{Op: "open", Path: "LOCK", Err: syscall.EACCES}))
+	assert.False(t, isLockError(os.ErrNotExist))
+}
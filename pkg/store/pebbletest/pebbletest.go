@@ -0,0 +1,26 @@
+// Package pebbletest provides Pebble stores for the tests of packages built
+// on ipam.Store:
+//
+//	func TestSync(t *testing.T) {
+//		s := pebbletest.New(t)
+//		...
+//	}
+package pebbletest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/stretchr/testify/require"
+)
+
+// New returns a new, empty Pebble store in a temporary directory of t. The
+// store is closed and the directory removed when the test ends.
+func New(t testing.TB) *store.PebbleStore {
+	t.Helper()
+	s, err := store.NewPebbleStore(filepath.Join(t.TempDir(), "db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
@@ -0,0 +1,247 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// PoolTagPrefix names a pool of a network, a range of its addresses set
+// aside for one use, as pool=<name>:<first>-<last>, e.g.
+// pool=dhcp:10.0.0.100-10.0.0.200; networks may carry several
+const PoolTagPrefix = "pool="
+
+// ErrPoolNotFound is returned for a pool name a network has no pool of
+var ErrPoolNotFound = errors.New("pool not found")
+
+// poolNamePattern allows short lower-case pool names
+var poolNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// Pool is a named range of a network's addresses
+type Pool struct {
+	Name  string `json:"name"`
+	First string `json:"first"`
+	Last  string `json:"last"`
+}
+
+// PoolTag returns the tag giving a network pool
+func PoolTag(pool Pool) string {
+	return PoolTagPrefix + pool.Name + ":" + pool.First + "-" + pool.Last
+}
+
+// parsePool returns the pool a pool= tag value gives, with its addresses
+func parsePool(value string) (Pool, netip.Addr, netip.Addr, error) {
+	name, addrs, ok := strings.Cut(value, ":")
+	if !ok || !poolNamePattern.MatchString(name) {
+		return Pool{}, netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid pool %q: want <name>:<first>-<last>", value)
+	}
+	firstText, lastText, ok := strings.Cut(addrs, "-")
+	first, err := netip.ParseAddr(firstText)
+	if err != nil || !ok {
+		return Pool{}, netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid pool %q: want <name>:<first>-<last>", value)
+	}
+	last, err := netip.ParseAddr(lastText)
+	if err != nil {
+		return Pool{}, netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid pool %q: want <name>:<first>-<last>", value)
+	}
+	first, last = first.Unmap(), last.Unmap()
+	return Pool{Name: name, First: first.String(), Last: last.String()}, first, last, nil
+}
+
+// Pools returns the valid pools tags give, in tag order
+func Pools(tags []string) []Pool {
+	var pools []Pool
+	for _, tag := range tags {
+		if value, ok := strings.CutPrefix(tag, PoolTagPrefix); ok {
+			if pool, _, _, err := parsePool(value); err == nil {
+				pools = append(pools, pool)
+			}
+		}
+	}
+	return pools
+}
+
+// CheckPools validates the pools tags give a network with cidr: each must
+// lie within the network, and no two may share a name or an address
+func CheckPools(cidr string, tags []string) error {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+	}
+	type poolRange struct {
+		name        string
+		first, last netip.Addr
+	}
+	var ranges []poolRange
+	for _, tag := range tags {
+		value, ok := strings.CutPrefix(tag, PoolTagPrefix)
+		if !ok {
+			continue
+		}
+		pool, first, last, err := parsePool(value)
+		if err != nil {
+			return err
+		}
+		if !prefix.Contains(first) || !prefix.Contains(last) || last.Less(first) {
+			return fmt.Errorf("pool %s: %s-%s is not a range of %s", pool.Name, first, last, cidr)
+		}
+		for _, other := range ranges {
+			if other.name == pool.Name {
+				return fmt.Errorf("pool %s is given twice", pool.Name)
+			}
+			if !last.Less(other.first) && !other.last.Less(first) {
+				return fmt.Errorf("pool %s overlaps pool %s", pool.Name, other.name)
+			}
+		}
+		ranges = append(ranges, poolRange{pool.Name, first, last})
+	}
+	return nil
+}
+
+// PoolTags returns tags with their pool= tags replaced by pools, when there
+// are any
+func PoolTags(tags []string, pools []Pool) []string {
+	if len(pools) == 0 {
+		return tags
+	}
+	kept := slices.DeleteFunc(slices.Clone(tags), func(tag string) bool { return strings.HasPrefix(tag, PoolTagPrefix) })
+	for _, pool := range pools {
+		kept = append(kept, PoolTag(pool))
+	}
+	return kept
+}
+
+// findPool returns the pool of network named name, with its addresses
+func findPool(network *ipam.Network, name string) (netip.Addr, netip.Addr, error) {
+	for _, tag := range network.Tags {
+		if value, ok := strings.CutPrefix(tag, PoolTagPrefix); ok {
+			if pool, first, last, err := parsePool(value); err == nil && pool.Name == name {
+				return first, last, nil
+			}
+		}
+	}
+	return netip.Addr{}, netip.Addr{}, fmt.Errorf("%w: network %s has no pool %q", ErrPoolNotFound, network.ID, name)
+}
+
+// activeRanges returns the address ranges of the active allocations among
+// allocations, ordered by their first address
+func activeRanges(allocations []*ipam.IPAllocation, now time.Time) ([][2]netip.Addr, error) {
+	var ranges [][2]netip.Addr
+	for _, alloc := range allocations {
+		if AllocationStatus(alloc, now) != StatusActive {
+			continue
+		}
+		first, last, err := allocationRange(alloc)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, [2]netip.Addr{first, last})
+	}
+	slices.SortFunc(ranges, func(a, b [2]netip.Addr) int { return a[0].Compare(b[0]) })
+	return ranges, nil
+}
+
+// NextInPool returns the lowest address of the pool of network named name
+// that no active allocation holds. It fails with ipam.ErrNetworkFull when
+// the pool has none left.
+func NextInPool(network *ipam.Network, allocations []*ipam.IPAllocation, name string, now time.Time) (string, error) {
+	first, last, err := findPool(network, name)
+	if err != nil {
+		return "", err
+	}
+	prefix, err := netip.ParsePrefix(network.CIDR)
+	if err != nil {
+		return "", fmt.Errorf("invalid CIDR %q: %w", network.CIDR, err)
+	}
+	prefix = prefix.Masked()
+	pointToPoint := PointToPoint(network)
+	ranges, err := activeRanges(allocations, now)
+	if err != nil {
+		return "", err
+	}
+
+	addr := first
+	for addr.IsValid() && !last.Less(addr) {
+		taken := slices.IndexFunc(ranges, func(r [2]netip.Addr) bool { return !addr.Less(r[0]) && !r[1].Less(addr) })
+		switch {
+		case taken >= 0:
+			addr = ranges[taken][1].Next()
+		case reservedAddr(prefix, pointToPoint, addr):
+			addr = addr.Next()
+		default:
+			return addr.String(), nil
+		}
+	}
+	return "", fmt.Errorf("%w: pool %s has no free address", ipam.ErrNetworkFull, name)
+}
+
+// PoolStats counts the addresses of a pool. Counts saturate like those of
+// AddressBreakdown.
+type PoolStats struct {
+	Pool
+
+	// Total counts the usable addresses of the pool
+	Total uint64 `json:"total"`
+
+	// Allocated counts those active allocations hold
+	Allocated uint64 `json:"allocated"`
+
+	// Available is what is left
+	Available uint64 `json:"available"`
+}
+
+// CountPools returns the stats of every pool of network at now, in tag
+// order
+func CountPools(network *ipam.Network, allocations []*ipam.IPAllocation, now time.Time) ([]PoolStats, error) {
+	prefix, err := netip.ParsePrefix(network.CIDR)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q: %w", network.CIDR, err)
+	}
+	prefix = prefix.Masked()
+	pointToPoint := PointToPoint(network)
+	ranges, err := activeRanges(allocations, now)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := []PoolStats{}
+	for _, pool := range Pools(network.Tags) {
+		first, last, _ := findPool(network, pool.Name)
+		s := PoolStats{Pool: pool, Total: span(first, last)}
+		for _, addr := range []netip.Addr{prefix.Addr(), lastAddr(prefix)} {
+			if reservedAddr(prefix, pointToPoint, addr) && !addr.Less(first) && !last.Less(addr) {
+				s.Total--
+			}
+		}
+
+		// Count each address once, should allocations overlap
+		next := first
+		for _, r := range ranges {
+			start, end := r[0], r[1]
+			if start.Less(next) {
+				start = next
+			}
+			if last.Less(end) {
+				end = last
+			}
+			if end.Less(start) {
+				continue
+			}
+			s.Allocated = addCapped(s.Allocated, span(start, end))
+			if next = end.Next(); !next.IsValid() || last.Less(next) {
+				break
+			}
+		}
+		if s.Total > s.Allocated {
+			s.Available = s.Total - s.Allocated
+		}
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPools(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	released := now.Add(-time.Hour)
+
+	tags := PoolTags([]string{"env=prod", "pool=old:10.0.0.1-10.0.0.5"}, []Pool{
+		{Name: "static", First: "10.0.0.10", Last: "10.0.0.99"},
+		{Name: "dhcp", First: "10.0.0.100", Last: "10.0.0.200"},
+		{Name: "broadcast", First: "10.0.0.250", Last: "10.0.0.255"},
+	})
+	assert.Equal(t, []string{"env=prod", "pool=static:10.0.0.10-10.0.0.99", "pool=dhcp:10.0.0.100-10.0.0.200",
+		"pool=broadcast:10.0.0.250-10.0.0.255"}, tags)
+	require.NoError(t, CheckPools("10.0.0.0/24", tags))
+	assert.ErrorContains(t, CheckPools("10.0.0.0/24", append(tags, "pool=lb:10.0.0.200-10.0.0.210")), "overlaps pool dhcp")
+	assert.ErrorContains(t, CheckPools("10.0.0.0/24", append(tags, "pool=dhcp:10.0.0.201-10.0.0.210")), "given twice")
+	assert.ErrorContains(t, CheckPools("10.0.0.0/24", []string{"pool=lb:10.0.1.1-10.0.1.9"}), "not a range of")
+	assert.ErrorContains(t, CheckPools("10.0.0.0/24", []string{"pool=lb:10.0.0.9-10.0.0.1"}), "not a range of")
+	assert.ErrorContains(t, CheckPools("10.0.0.0/24", []string{"pool=Bad:10.0.0.1-10.0.0.9"}), "invalid pool")
+
+	network := &ipam.Network{ID: "net1", CIDR: "10.0.0.0/24", Tags: tags}
+	allocations := []*ipam.IPAllocation{
+		{ID: "a", NetworkID: "net1", IP: "10.0.0.100"},
+		{ID: "b", NetworkID: "net1", IP: "10.0.0.101", EndIP: "10.0.0.104"},
+		{ID: "old", NetworkID: "net1", IP: "10.0.0.105", ReleasedAt: &released},
+		{ID: "c", NetworkID: "net1", IP: "10.0.0.10"},
+	}
+	ip, err := NextInPool(network, allocations, "dhcp", now)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.105", ip)
+	ip, err = NextInPool(network, allocations, "static", now)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.11", ip)
+	_, err = NextInPool(network, allocations, "lb", now)
+	assert.ErrorIs(t, err, ErrPoolNotFound)
+
+	full := append(allocations, &ipam.IPAllocation{ID: "d", NetworkID: "net1", IP: "10.0.0.250", EndIP: "10.0.0.254"})
+	_, err = NextInPool(network, full, "broadcast", now)
+	assert.ErrorIs(t, err, ipam.ErrNetworkFull)
+
+	stats, err := CountPools(network, full, now)
+	require.NoError(t, err)
+	assert.Equal(t, []PoolStats{
+		{Pool: Pool{Name: "static", First: "10.0.0.10", Last: "10.0.0.99"}, Total: 90, Allocated: 1, Available: 89},
+		{Pool: Pool{Name: "dhcp", First: "10.0.0.100", Last: "10.0.0.200"}, Total: 101, Allocated: 5, Available: 96},
+		{Pool: Pool{Name: "broadcast", First: "10.0.0.250", Last: "10.0.0.255"}, Total: 5, Allocated: 5, Available: 0},
+	}, stats)
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lni/dragonboat/v3"
+)
+
+// catchUpTimeout is how long a node may take to apply everything the leader
+// has committed and still count as caught up
+const catchUpTimeout = 2 * time.Second
+
+// Progress is how far a node has applied the replicated log
+type Progress struct {
+	NodeID uint64 `json:"node_id"`
+
+	// Applied counts the commands the node has applied, including those in
+	// the snapshot it started from. Nodes that have applied the same log
+	// report the same count, except that a node restored from a snapshot
+	// taken before the count was kept starts from zero.
+	Applied uint64 `json:"applied"`
+
+	// CaughtUp reports whether the node has a leader and applies what the
+	// leader commits within catchUpTimeout, i.e. it is not still receiving
+	// a snapshot or replaying a backlog of log entries
+	CaughtUp bool `json:"caught_up"`
+}
+
+// NodeID returns this node's ID
+func (s *RaftStore) NodeID() uint64 {
+	return s.nodeID
+}
+
+// Progress reports how far this node has applied the log
+func (s *RaftStore) Progress() (*Progress, error) {
+	result, err := s.nh.StaleRead(s.clusterID, []byte{byte(queryApplied)})
+	if err != nil {
+		return nil, err
+	}
+	applied, ok := result.(uint64)
+	if !ok {
+		return nil, fmt.Errorf("unexpected applied count %T", result)
+	}
+
+	// A linearizable read completes once the node has applied everything
+	// committed when it started
+	caughtUp := false
+	if s.Ping() == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), catchUpTimeout)
+		defer cancel()
+		_, err := s.nh.SyncRead(ctx, s.clusterID, []byte{byte(queryApplied)})
+		caughtUp = err == nil
+	}
+	return &Progress{NodeID: s.nodeID, Applied: applied, CaughtUp: caughtUp}, nil
+}
+
+// LocalMembership returns the Raft members by node ID as this node last
+// learned them. Unlike GetClusterInfo it needs no quorum, so it answers
+// while a newly added member is still catching up.
+func (s *RaftStore) LocalMembership() map[uint64]string {
+	info := s.nh.GetNodeHostInfo(dragonboat.NodeHostInfoOption{SkipLogInfo: true})
+	for _, c := range info.ClusterInfoList {
+		if c.ClusterID == s.clusterID {
+			return c.Nodes
+		}
+	}
+	return nil
+}
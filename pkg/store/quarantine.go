@@ -0,0 +1,119 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// ReuseDelayTagPrefix sets how long a network keeps a released address out
+// of circulation, e.g. reuse-delay=10m, so that ARP and DNS caches and
+// firewall states forget the previous host before the address is reissued
+const ReuseDelayTagPrefix = "reuse-delay="
+
+// quarantineAttempts bounds how often a release is staged again after other
+// writes changed its network
+const quarantineAttempts = 3
+
+// ReuseDelayTag returns the tag giving a network the reuse delay d
+func ReuseDelayTag(d time.Duration) string {
+	return ReuseDelayTagPrefix + d.String()
+}
+
+// ReuseDelay returns the reuse delay of network, zero when it has none
+func ReuseDelay(network *ipam.Network) time.Duration {
+	var delay time.Duration
+	for _, tag := range network.Tags {
+		if value, ok := strings.CutPrefix(tag, ReuseDelayTagPrefix); ok {
+			if d, err := time.ParseDuration(value); err == nil && d > 0 {
+				delay = d
+			}
+		}
+	}
+	return delay
+}
+
+// Quarantine saves to s a hold on the addresses of released, an allocation
+// being released, lasting the reuse delay of its network, and returns it;
+// nil when the network has no reuse delay or released is itself a hold. The
+// hold's token is random and never handed out, so the addresses are free
+// again once it expires.
+func Quarantine(s ipam.Store, released *ipam.IPAllocation, now time.Time) (*ipam.IPAllocation, error) {
+	if slices.ContainsFunc(released.Tags, func(tag string) bool { return strings.HasPrefix(tag, HoldTagPrefix) }) {
+		return nil, nil
+	}
+	network, err := s.GetNetwork(released.NetworkID)
+	if err != nil {
+		return nil, err
+	}
+	delay := ReuseDelay(network)
+	if delay == 0 {
+		return nil, nil
+	}
+
+	id, err := NewAllocationID()
+	if err != nil {
+		return nil, err
+	}
+	token, err := NewAllocationID()
+	if err != nil {
+		return nil, err
+	}
+	expires := now.Add(delay)
+	hold := &ipam.IPAllocation{
+		ID:          id,
+		NetworkID:   released.NetworkID,
+		IP:          released.IP,
+		EndIP:       released.EndIP,
+		Description: fmt.Sprintf("Reuse delay after release of %s", released.ID),
+		Tags:        []string{HoldTag(token)},
+		Status:      "allocated",
+		AllocatedAt: now,
+		ExpiresAt:   &expires,
+	}
+	if err := s.SaveAllocation(hold); err != nil {
+		return nil, err
+	}
+	return hold, nil
+}
+
+// ReleaseQuarantined releases alloc and quarantines its addresses in one
+// batch written to s, so that the allocator cannot hand them out in
+// between, staging them again if other writes changed the network
+// meanwhile. When the network has no reuse delay, or s cannot write
+// batches, it releases alloc with release instead and returns no hold.
+func ReleaseQuarantined(s ipam.Store, alloc *ipam.IPAllocation, now time.Time, release func() error) (*ipam.IPAllocation, error) {
+	applier, ok := s.(interface{ ApplyBatch(*Batch) error })
+	if !ok {
+		return nil, release()
+	}
+	network, err := s.GetNetwork(alloc.NetworkID)
+	if err != nil {
+		return nil, err
+	}
+	if ReuseDelay(network) == 0 {
+		return nil, release()
+	}
+
+	var hold *ipam.IPAllocation
+	for attempt := 0; attempt < quarantineAttempts; attempt++ {
+		staging := NewStaging(s)
+		if err = ipam.New(staging).ReleaseIP(alloc.NetworkID, alloc.IP); err != nil {
+			return nil, err
+		}
+		if hold, err = Quarantine(staging, alloc, now); err != nil {
+			return nil, err
+		}
+		if err = applier.ApplyBatch(staging.Batch()); !errors.Is(err, ErrBatchConflict) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return hold, nil
+}
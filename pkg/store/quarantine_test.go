@@ -0,0 +1,60 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReleaseQuarantined(t *testing.T) {
+	store, cleanup := createTestPebbleStore(t)
+	defer cleanup()
+
+	now := time.Now()
+	require.NoError(t, store.SaveNetwork(&ipam.Network{ID: "net1", CIDR: "10.0.0.0/24", Tags: []string{ReuseDelayTag(10 * time.Minute)}}))
+	require.NoError(t, store.SaveNetwork(&ipam.Network{ID: "net2", CIDR: "10.0.1.0/24", Tags: []string{"reuse-delay=soon"}}))
+	assert.Equal(t, 10*time.Minute, ReuseDelay(&ipam.Network{Tags: []string{"reuse-delay=10m0s"}}))
+	assert.Zero(t, ReuseDelay(&ipam.Network{Tags: []string{"reuse-delay=soon"}}))
+
+	released := false
+	release := func() error { released = true; return nil }
+
+	// Without a reuse delay the allocation is released as usual
+	plain := &ipam.IPAllocation{ID: "a2", NetworkID: "net2", IP: "10.0.1.1", Status: "allocated", AllocatedAt: now}
+	require.NoError(t, store.SaveAllocation(plain))
+	hold, err := ReleaseQuarantined(store, plain, now, release)
+	require.NoError(t, err)
+	assert.Nil(t, hold)
+	assert.True(t, released)
+
+	released = false
+	alloc := &ipam.IPAllocation{ID: "a1", NetworkID: "net1", IP: "10.0.0.1", Status: "allocated", AllocatedAt: now}
+	require.NoError(t, store.SaveAllocation(alloc))
+	hold, err = ReleaseQuarantined(store, alloc, now, release)
+	require.NoError(t, err)
+	require.NotNil(t, hold)
+	assert.False(t, released, "the release is staged with the hold")
+	assert.Equal(t, "10.0.0.1", hold.IP)
+	require.NotNil(t, hold.ExpiresAt)
+	assert.Equal(t, now.Add(10*time.Minute), *hold.ExpiresAt)
+
+	stored, err := store.GetAllocation("a1")
+	require.NoError(t, err)
+	assert.NotNil(t, stored.ReleasedAt)
+	stored, err = store.GetAllocation(hold.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusActive, AllocationStatus(stored, now))
+
+	_, err = CheckRequestedIP(&ipam.Network{CIDR: "10.0.0.0/24"}, []*ipam.IPAllocation{stored}, "10.0.0.1", now)
+	assert.ErrorContains(t, err, "is on hold until")
+	_, err = CheckRequestedIP(&ipam.Network{CIDR: "10.0.0.0/24"}, []*ipam.IPAllocation{stored}, "10.0.0.1", now.Add(11*time.Minute))
+	assert.NoError(t, err)
+
+	// Releasing the hold itself does not hold the address again
+	hold, err = Quarantine(store, stored, now)
+	require.NoError(t, err)
+	assert.Nil(t, hold)
+}
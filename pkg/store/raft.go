@@ -6,6 +6,7 @@ import (
 	"encoding/gob"
 	"fmt"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -36,17 +37,22 @@ type NodeInfo struct {
 type RaftStore struct {
 	nodeID    uint64
 	clusterID uint64
+	dir       string
 	nh        *dragonboat.NodeHost
+	leaders   *leaderListener
 	mu        sync.RWMutex
 }
 
 // NewRaftStore creates a new Raft-based store
 func NewRaftStore(nodeID, clusterID uint64, nodeAddr string, join bool, initialMembers map[uint64]string, dataDir string) (*RaftStore, error) {
 	// Configure Dragonboat
+	dir := filepath.Join(dataDir, fmt.Sprintf("node-%d", nodeID))
+	leaders := newLeaderListener(clusterID)
 	nhc := config.NodeHostConfig{
-		NodeHostDir:    filepath.Join(dataDir, fmt.Sprintf("node-%d", nodeID)),
-		RTTMillisecond: 200,
-		RaftAddress:    nodeAddr,
+		NodeHostDir:       dir,
+		RTTMillisecond:    200,
+		RaftAddress:       nodeAddr,
+		RaftEventListener: leaders,
 	}
 
 	// Disable default logger to reduce noise
@@ -92,7 +98,9 @@ func NewRaftStore(nodeID, clusterID uint64, nodeAddr string, join bool, initialM
 	return &RaftStore{
 		nodeID:    nodeID,
 		clusterID: clusterID,
+		dir:       dir,
 		nh:        nh,
+		leaders:   leaders,
 	}, nil
 }
 
@@ -101,14 +109,24 @@ func (s *RaftStore) Close() error {
 	if s.nh != nil {
 		s.nh.Stop()
 	}
+	if s.leaders != nil {
+		s.leaders.close()
+	}
 	return nil
 }
 
 // executeCommand submits a command to the Raft cluster
 func (s *RaftStore) executeCommand(cmdType commandType, cmd interface{}) error {
+	_, err := s.proposeCommand(cmdType, cmd)
+	return err
+}
+
+// proposeCommand submits a command to the Raft cluster and returns the
+// state machine's result
+func (s *RaftStore) proposeCommand(cmdType commandType, cmd interface{}) (sm.Result, error) {
 	cmdData, err := encode(cmd)
 	if err != nil {
-		return err
+		return sm.Result{}, err
 	}
 
 	// Prepend command type
@@ -118,8 +136,7 @@ func (s *RaftStore) executeCommand(cmdType commandType, cmd interface{}) error {
 	defer cancel()
 
 	session := s.nh.GetNoOPSession(s.clusterID)
-	_, err = s.nh.SyncPropose(ctx, session, data)
-	return err
+	return s.nh.SyncPropose(ctx, session, data)
 }
 
 // executeQuery performs a read-only query
@@ -188,7 +205,22 @@ func (s *RaftStore) ListNetworks() ([]*ipam.Network, error) {
 	return result.([]*ipam.Network), nil
 }
 
+// FindNetworks returns the networks matching filter, evaluated inside the
+// state machine
+func (s *RaftStore) FindNetworks(filter NetworkFilter) ([]*ipam.Network, error) {
+	query := &findNetworksQuery{Filter: filter}
+	result, err := s.executeQuery(queryFindNetworks, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.([]*ipam.Network), nil
+}
+
 func (s *RaftStore) DeleteNetwork(id string) error {
+	if _, err := s.GetNetwork(id); err != nil {
+		return err
+	}
 	cmd := &deleteNetworkCmd{ID: id}
 	return s.executeCommand(cmdDeleteNetwork, cmd)
 }
@@ -239,10 +271,38 @@ func (s *RaftStore) ListAllocations(networkID string) ([]*ipam.IPAllocation, err
 }
 
 func (s *RaftStore) DeleteAllocation(id string) error {
+	if _, err := s.GetAllocation(id); err != nil {
+		return err
+	}
 	cmd := &deleteAllocationCmd{ID: id}
 	return s.executeCommand(cmdDeleteAllocation, cmd)
 }
 
+// ApplyBatch saves every record of b in a single Raft proposal, after each
+// replica checks b's digests against its records. Nothing is saved when a
+// check fails with ErrBatchConflict.
+func (s *RaftStore) ApplyBatch(b *Batch) error {
+	result, err := s.proposeCommand(cmdApplyBatch, &applyBatchCmd{Batch: b})
+	if err != nil {
+		return err
+	}
+	if len(result.Data) > 0 {
+		conflict := strings.TrimPrefix(string(result.Data), ErrBatchConflict.Error()+": ")
+		return fmt.Errorf("%w: %s", ErrBatchConflict, conflict)
+	}
+	return nil
+}
+
+// MoveAllocation reassigns an allocation to another network, keeping its ID,
+// addresses and history
+func (s *RaftStore) MoveAllocation(id, networkID string) error {
+	if _, err := s.GetAllocation(id); err != nil {
+		return err
+	}
+	cmd := &moveAllocationCmd{ID: id, NetworkID: networkID}
+	return s.executeCommand(cmdMoveAllocation, cmd)
+}
+
 // Audit operations
 
 func (s *RaftStore) SaveAuditEntry(entry *ipam.AuditEntry) error {
@@ -260,6 +320,31 @@ func (s *RaftStore) ListAuditEntries(limit int) ([]*ipam.AuditEntry, error) {
 	return result.([]*ipam.AuditEntry), nil
 }
 
+// ListEvents returns up to limit audit entries numbered after since, oldest
+// first. The cluster keeps the last 10000 entries, so a consumer that fell
+// further behind sees a gap in the sequence numbers.
+func (s *RaftStore) ListEvents(since uint64, limit int) ([]*Event, error) {
+	query := &listEventsQuery{Since: since, Limit: limit}
+	result, err := s.executeQuery(queryListEvents, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.([]*Event), nil
+}
+
+// Ping reports whether the node can serve requests, which needs a leader
+func (s *RaftStore) Ping() error {
+	_, ok, err := s.nh.GetLeaderID(s.clusterID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("cluster %d has no leader", s.clusterID)
+	}
+	return nil
+}
+
 // GetClusterInfo returns information about the Raft cluster
 func (s *RaftStore) GetClusterInfo() (*ClusterInfo, error) {
 	leader, ok, err := s.nh.GetLeaderID(s.clusterID)
@@ -1,12 +1,14 @@
 package store
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store/storetest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -176,6 +178,26 @@ func TestRaftStoreAuditOperations(t *testing.T) {
 	assert.Equal(t, "audit2", entries[2].ID)
 }
 
+func TestRaftStoreAuditRetry(t *testing.T) {
+	store, cleanup := createTestRaftStore(t, 1)
+	defer cleanup()
+
+	// A write retried after its proposal was committed, as the audit writer
+	// does when a proposal times out, stores the entry once
+	entry := &ipam.AuditEntry{ID: "audit1", Timestamp: time.Now(), Action: "test_action", User: "test_user"}
+	require.NoError(t, store.SaveAuditEntry(entry))
+	require.NoError(t, store.SaveAuditEntry(entry))
+	other := &ipam.AuditEntry{ID: "audit2", Timestamp: time.Now(), Action: "test_action", User: "test_user"}
+	require.NoError(t, store.ApplyBatch(&Batch{AuditEntries: []*ipam.AuditEntry{entry, other}}))
+	require.NoError(t, store.ApplyBatch(&Batch{AuditEntries: []*ipam.AuditEntry{entry, other}}))
+
+	entries, err := store.ListAuditEntries(0)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "audit2", entries[0].ID)
+	assert.Equal(t, "audit1", entries[1].ID)
+}
+
 func TestRaftStoreClusterInfo(t *testing.T) {
 	store, cleanup := createTestRaftStore(t, 1)
 	defer cleanup()
@@ -191,6 +213,132 @@ func TestRaftStoreClusterInfo(t *testing.T) {
 	assert.True(t, info.Nodes[0].IsLeader)
 }
 
+func TestRaftStoreCompact(t *testing.T) {
+	store, cleanup := createTestRaftStore(t, 1)
+	defer cleanup()
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, store.SaveNetwork(&ipam.Network{
+			ID:   fmt.Sprintf("net-%d", i),
+			CIDR: fmt.Sprintf("10.%d.0.0/24", i),
+		}))
+	}
+
+	before, err := store.Storage()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), before.NodeID)
+	assert.Positive(t, before.LogBytes)
+	assert.Zero(t, before.Snapshots)
+
+	index, err := store.Compact(10 * time.Second)
+	require.NoError(t, err)
+	assert.Positive(t, index)
+
+	after, err := store.Storage()
+	require.NoError(t, err)
+	assert.Equal(t, 1, after.Snapshots)
+	assert.Positive(t, after.SnapshotBytes)
+	assert.GreaterOrEqual(t, after.TotalBytes, after.LogBytes+after.SnapshotBytes)
+
+	_, err = store.Compact(10 * time.Second)
+	assert.ErrorIs(t, err, ErrNothingToCompact)
+
+	// The data survives the compaction
+	networks, err := store.ListNetworks()
+	require.NoError(t, err)
+	assert.Len(t, networks, 20)
+}
+
+func TestRaftStoreProgress(t *testing.T) {
+	store, cleanup := createTestRaftStore(t, 1)
+	defer cleanup()
+
+	before, err := store.Progress()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), before.NodeID)
+	assert.True(t, before.CaughtUp)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, store.SaveNetwork(&ipam.Network{
+			ID:   fmt.Sprintf("net-%d", i),
+			CIDR: fmt.Sprintf("10.%d.0.0/24", i),
+		}))
+	}
+	after, err := store.Progress()
+	require.NoError(t, err)
+	assert.Equal(t, before.Applied+5, after.Applied)
+	assert.True(t, after.CaughtUp)
+}
+
+func TestRaftStoreProgressJoin(t *testing.T) {
+	leader, cleanup := createTestRaftStore(t, 1)
+	defer cleanup()
+	for i := 0; i < 50; i++ {
+		require.NoError(t, leader.SaveNetwork(&ipam.Network{
+			ID:   fmt.Sprintf("net-%d", i),
+			CIDR: fmt.Sprintf("10.%d.0.0/24", i),
+		}))
+	}
+	// The new node starts from this snapshot, then replays the rest
+	_, err := leader.Compact(10 * time.Second)
+	require.NoError(t, err)
+	require.NoError(t, leader.SaveNetwork(&ipam.Network{ID: "net-last", CIDR: "10.99.0.0/24"}))
+
+	require.NoError(t, leader.AddNode(2, "localhost:5022"))
+	joined, err := NewRaftStore(2, 1, "localhost:5022", true, nil, t.TempDir())
+	require.NoError(t, err)
+	defer joined.Close()
+
+	want, err := leader.Progress()
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		p, err := joined.Progress()
+		return err == nil && p.CaughtUp
+	}, 30*time.Second, 200*time.Millisecond)
+
+	got, err := joined.Progress()
+	require.NoError(t, err)
+	assert.Equal(t, want.Applied, got.Applied, "nodes that applied the same log agree on the count")
+	assert.Contains(t, joined.LocalMembership(), uint64(1))
+}
+
+func TestRaftStoreLeaderChanges(t *testing.T) {
+	store, cleanup := createTestRaftStore(t, 1)
+
+	select {
+	case change := <-store.LeaderChanges():
+		assert.Equal(t, uint64(1), change.LeaderID)
+		assert.NotZero(t, change.Term)
+		assert.Zero(t, change.Previous)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no leader change reported")
+	}
+
+	// Closing the store ends the stream
+	cleanup()
+	for range store.LeaderChanges() {
+	}
+}
+
+func TestStateMachineAppliedSnapshot(t *testing.T) {
+	sm := newIPAMStateMachine(1, 1)
+	for i := 0; i < 3; i++ {
+		data, err := encode(&saveNetworkCmd{Network: &ipam.Network{ID: fmt.Sprintf("net-%d", i), CIDR: fmt.Sprintf("10.%d.0.0/24", i)}})
+		require.NoError(t, err)
+		_, err = sm.Update(append([]byte{byte(cmdSaveNetwork)}, data...))
+		require.NoError(t, err)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, sm.SaveSnapshot(&buf, nil, nil))
+	restored := newIPAMStateMachine(1, 2)
+	require.NoError(t, restored.RecoverFromSnapshot(&buf, nil, nil))
+
+	applied, err := restored.Lookup([]byte{byte(queryApplied)})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), applied, "a node restored from a snapshot counts the commands in it")
+}
+
 func TestRaftStoreConsistency(t *testing.T) {
 	store, cleanup := createTestRaftStore(t, 1)
 	defer cleanup()
@@ -233,3 +381,59 @@ func TestRaftStoreConsistency(t *testing.T) {
 		assert.Equal(t, fmt.Sprintf("10.%d.0.1", i), allocations[0].IP)
 	}
 }
+
+func TestRaftStoreFindNetworks(t *testing.T) {
+	store, cleanup := createTestRaftStore(t, 1)
+	defer cleanup()
+
+	require.NoError(t, store.SaveNetwork(&ipam.Network{ID: "net1", CIDR: "10.42.0.0/16", Tags: []string{"prod"}}))
+	require.NoError(t, store.SaveNetwork(&ipam.Network{ID: "net2", CIDR: "10.43.0.0/16", Tags: []string{"lab"}}))
+
+	found, err := store.FindNetworks(NetworkFilter{ContainsIP: "10.42.3.7"})
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, "net1", found[0].ID)
+
+	found, err = store.FindNetworks(NetworkFilter{Tags: []string{"lab"}})
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, "net2", found[0].ID)
+
+	found, err = store.FindNetworks(NetworkFilter{Selector: "!prod"})
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, "net2", found[0].ID)
+}
+
+func TestRaftStoreMoveAllocation(t *testing.T) {
+	store, cleanup := createTestRaftStore(t, 1)
+	defer cleanup()
+
+	require.NoError(t, store.SaveNetwork(&ipam.Network{ID: "net1", CIDR: "10.0.0.0/16"}))
+	require.NoError(t, store.SaveNetwork(&ipam.Network{ID: "net2", CIDR: "10.0.1.0/24"}))
+	require.NoError(t, store.SaveAllocation(&ipam.IPAllocation{ID: "alloc1", NetworkID: "net1", IP: "10.0.1.5"}))
+
+	require.NoError(t, store.MoveAllocation("alloc1", "net2"))
+
+	old, err := store.ListAllocations("net1")
+	require.NoError(t, err)
+	assert.Empty(t, old)
+
+	moved, err := store.ListAllocations("net2")
+	require.NoError(t, err)
+	require.Len(t, moved, 1)
+	assert.Equal(t, "alloc1", moved[0].ID)
+	assert.Equal(t, "net2", moved[0].NetworkID)
+
+	byIP, err := store.GetAllocationByIP("net2", "10.0.1.5")
+	require.NoError(t, err)
+	assert.Equal(t, "alloc1", byIP.ID)
+}
+
+func TestRaftStoreConformance(t *testing.T) {
+	storetest.TestStore(t, func(t *testing.T) ipam.Store {
+		store, cleanup := createTestRaftStore(t, 1)
+		t.Cleanup(cleanup)
+		return store
+	})
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// ParseAddressRange reads an address, a dash range such as
+// 10.0.0.10-10.0.0.20 or a CIDR, and returns its first and last address
+func ParseAddressRange(s string) (first, last netip.Addr, err error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return first, last, fmt.Errorf("invalid CIDR %q", s)
+		}
+		prefix = prefix.Masked()
+		return prefix.Addr().Unmap(), lastAddr(prefix).Unmap(), nil
+	}
+
+	startStr, endStr, isRange := strings.Cut(s, "-")
+	if first, err = netip.ParseAddr(strings.TrimSpace(startStr)); err != nil {
+		return first, last, fmt.Errorf("invalid address %q", s)
+	}
+	first = first.Unmap()
+	last = first
+	if isRange {
+		if last, err = netip.ParseAddr(strings.TrimSpace(endStr)); err != nil {
+			return first, last, fmt.Errorf("invalid address %q", s)
+		}
+		last = last.Unmap()
+		if first.Is4() != last.Is4() {
+			return first, last, fmt.Errorf("range %q mixes IPv4 and IPv6", s)
+		}
+		if last.Less(first) {
+			return first, last, fmt.Errorf("range %q ends before it starts", s)
+		}
+	}
+	return first, last, nil
+}
+
+// AllocationsWithin returns the unreleased allocations lying within first
+// to last, in address order, and apart those only partly inside. networkID,
+// when set, limits the search to that network.
+func AllocationsWithin(s ipam.Store, networkID string, first, last netip.Addr) (within, partial []*ipam.IPAllocation, err error) {
+	var networks []*ipam.Network
+	if networkID != "" {
+		network, err := s.GetNetwork(networkID)
+		if err != nil {
+			return nil, nil, err
+		}
+		networks = []*ipam.Network{network}
+	} else if networks, err = s.ListNetworks(); err != nil {
+		return nil, nil, err
+	}
+
+	for _, network := range networks {
+		prefix, err := netip.ParsePrefix(network.CIDR)
+		if err != nil || prefix.Addr().Is4() != first.Is4() {
+			continue
+		}
+		prefix = prefix.Masked()
+		if last.Less(prefix.Addr()) || lastAddr(prefix).Less(first) {
+			continue
+		}
+
+		allocations, err := s.ListAllocations(network.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, alloc := range allocations {
+			if alloc.ReleasedAt != nil {
+				continue
+			}
+			start, end, err := allocationRange(alloc)
+			if err != nil || last.Less(start) || end.Less(first) {
+				continue
+			}
+			if start.Less(first) || last.Less(end) {
+				partial = append(partial, alloc)
+			} else {
+				within = append(within, alloc)
+			}
+		}
+	}
+
+	byAddress := func(allocations []*ipam.IPAllocation) {
+		sort.SliceStable(allocations, func(i, j int) bool {
+			a, _ := netip.ParseAddr(allocations[i].IP)
+			b, _ := netip.ParseAddr(allocations[j].IP)
+			return a.Less(b)
+		})
+	}
+	byAddress(within)
+	byAddress(partial)
+	return within, partial, nil
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAddressRange(t *testing.T) {
+	tests := []struct {
+		in          string
+		first, last string
+		wantErr     string
+	}{
+		{"10.0.0.5", "10.0.0.5", "10.0.0.5", ""},
+		{"10.0.0.10-10.0.0.20", "10.0.0.10", "10.0.0.20", ""},
+		{"10.0.0.10 - 10.0.0.20", "10.0.0.10", "10.0.0.20", ""},
+		{"10.0.0.7/29", "10.0.0.0", "10.0.0.7", ""},
+		{"2001:db8::/126", "2001:db8::", "2001:db8::3", ""},
+		{"10.0.0.20-10.0.0.10", "", "", "ends before it starts"},
+		{"10.0.0.1-2001:db8::1", "", "", "mixes IPv4 and IPv6"},
+		{"10.0.0.0/33", "", "", "invalid CIDR"},
+		{"host-1", "", "", "invalid address"},
+	}
+	for _, tt := range tests {
+		first, last, err := ParseAddressRange(tt.in)
+		if tt.wantErr != "" {
+			assert.ErrorContains(t, err, tt.wantErr, tt.in)
+			continue
+		}
+		require.NoError(t, err, tt.in)
+		assert.Equal(t, tt.first, first.String(), tt.in)
+		assert.Equal(t, tt.last, last.String(), tt.in)
+	}
+}
+
+func TestAllocationsWithin(t *testing.T) {
+	store, cleanup := createTestPebbleStore(t)
+	defer cleanup()
+
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, store.SaveNetwork(&ipam.Network{ID: "net1", CIDR: "10.0.0.0/24"}))
+	require.NoError(t, store.SaveNetwork(&ipam.Network{ID: "net2", CIDR: "10.0.1.0/24"}))
+	require.NoError(t, store.SaveAllocation(&ipam.IPAllocation{ID: "a1", NetworkID: "net1", IP: "10.0.0.20"}))
+	require.NoError(t, store.SaveAllocation(&ipam.IPAllocation{ID: "a2", NetworkID: "net1", IP: "10.0.0.10"}))
+	require.NoError(t, store.SaveAllocation(&ipam.IPAllocation{ID: "a3", NetworkID: "net1", IP: "10.0.0.250", EndIP: "10.0.0.255"}))
+	require.NoError(t, store.SaveAllocation(&ipam.IPAllocation{ID: "a4", NetworkID: "net1", IP: "10.0.0.11", ReleasedAt: &past}))
+	require.NoError(t, store.SaveAllocation(&ipam.IPAllocation{ID: "a5", NetworkID: "net2", IP: "10.0.1.1"}))
+
+	ids := func(allocations []*ipam.IPAllocation) []string {
+		var ids []string
+		for _, alloc := range allocations {
+			ids = append(ids, alloc.ID)
+		}
+		return ids
+	}
+
+	// Released allocations are left out, partly covered ones set apart
+	within, partial, err := AllocationsWithin(store, "", netip.MustParseAddr("10.0.0.0"), netip.MustParseAddr("10.0.0.252"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a2", "a1"}, ids(within))
+	assert.Equal(t, []string{"a3"}, ids(partial))
+
+	within, partial, err = AllocationsWithin(store, "", netip.MustParseAddr("10.0.0.0"), netip.MustParseAddr("10.0.1.255"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a2", "a1", "a3", "a5"}, ids(within))
+	assert.Empty(t, partial)
+
+	within, _, err = AllocationsWithin(store, "net2", netip.MustParseAddr("10.0.0.0"), netip.MustParseAddr("10.0.1.255"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a5"}, ids(within))
+
+	_, _, err = AllocationsWithin(store, "missing", netip.MustParseAddr("10.0.0.0"), netip.MustParseAddr("10.0.0.1"))
+	assert.Error(t, err)
+}
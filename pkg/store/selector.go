@@ -0,0 +1,343 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Labels are key/value pairs derived from tags. A tag of the form
+// "key=value" becomes the label key with that value; any other tag becomes
+// a label with an empty value, so it can still be matched by existence.
+type Labels map[string]string
+
+// LabelsFromTags converts tags into labels
+func LabelsFromTags(tags []string) Labels {
+	labels := make(Labels, len(tags))
+	for _, tag := range tags {
+		key, value, _ := strings.Cut(tag, "=")
+		labels[key] = value
+	}
+	return labels
+}
+
+// SelectorOp is the operator of a single selector requirement
+type SelectorOp string
+
+const (
+	OpEquals       SelectorOp = "="
+	OpNotEquals    SelectorOp = "!="
+	OpIn           SelectorOp = "in"
+	OpNotIn        SelectorOp = "notin"
+	OpExists       SelectorOp = "exists"
+	OpDoesNotExist SelectorOp = "!"
+)
+
+// Requirement is one comma-separated term of a selector
+type Requirement struct {
+	Key    string
+	Op     SelectorOp
+	Values []string
+}
+
+// Matches reports whether labels satisfy the requirement
+func (r Requirement) Matches(labels Labels) bool {
+	value, ok := labels[r.Key]
+	switch r.Op {
+	case OpEquals, OpIn:
+		return ok && containsString(r.Values, value)
+	case OpNotEquals, OpNotIn:
+		return !ok || !containsString(r.Values, value)
+	case OpExists:
+		return ok
+	case OpDoesNotExist:
+		return !ok
+	}
+	return false
+}
+
+// positive reports whether the requirement can only match items carrying
+// the key, which lets the label index narrow candidates
+func (r Requirement) positive() bool {
+	return r.Op == OpEquals || r.Op == OpIn || r.Op == OpExists
+}
+
+// Selector is a parsed Kubernetes-style label selector such as
+// "env=prod,team!=dbre,tier in (web,api),!deprecated". All requirements
+// must match.
+type Selector struct {
+	Requirements []Requirement
+}
+
+// ParseSelector parses a label selector. Supported terms are key, !key,
+// key=value, key==value, key!=value, key in (a,b) and key notin (a,b).
+func ParseSelector(s string) (*Selector, error) {
+	terms, err := splitSelector(s)
+	if err != nil {
+		return nil, err
+	}
+
+	sel := &Selector{}
+	for _, term := range terms {
+		req, err := parseRequirement(term)
+		if err != nil {
+			return nil, err
+		}
+		sel.Requirements = append(sel.Requirements, req)
+	}
+	return sel, nil
+}
+
+// Empty reports whether the selector matches everything
+func (s *Selector) Empty() bool {
+	return s == nil || len(s.Requirements) == 0
+}
+
+// Matches reports whether labels satisfy every requirement
+func (s *Selector) Matches(labels Labels) bool {
+	if s == nil {
+		return true
+	}
+	for _, req := range s.Requirements {
+		if !req.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchesTags reports whether the labels derived from tags satisfy the
+// selector
+func (s *Selector) MatchesTags(tags []string) bool {
+	return s.Matches(LabelsFromTags(tags))
+}
+
+// splitSelector splits on commas outside parentheses
+func splitSelector(s string) ([]string, error) {
+	var terms []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+			if depth > 1 {
+				return nil, fmt.Errorf("nested parentheses in selector")
+			}
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses in selector")
+			}
+		case ',':
+			if depth == 0 {
+				terms = append(terms, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses in selector")
+	}
+	terms = append(terms, s[start:])
+
+	for i, term := range terms {
+		terms[i] = strings.TrimSpace(term)
+		if terms[i] == "" {
+			if len(terms) == 1 {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("empty term in selector")
+		}
+	}
+	return terms, nil
+}
+
+func parseRequirement(term string) (Requirement, error) {
+	if strings.HasPrefix(term, "!") && !strings.Contains(term, "=") {
+		key := strings.TrimSpace(term[1:])
+		if err := validateLabelToken(key, "key"); err != nil {
+			return Requirement{}, err
+		}
+		return Requirement{Key: key, Op: OpDoesNotExist}, nil
+	}
+
+	if key, set, ok := cutSetOp(term, " notin "); ok {
+		return newSetRequirement(key, OpNotIn, set)
+	}
+	if key, set, ok := cutSetOp(term, " in "); ok {
+		return newSetRequirement(key, OpIn, set)
+	}
+
+	for _, op := range []struct {
+		token string
+		op    SelectorOp
+	}{{"!=", OpNotEquals}, {"==", OpEquals}, {"=", OpEquals}} {
+		if key, value, ok := strings.Cut(term, op.token); ok {
+			key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+			if err := validateLabelToken(key, "key"); err != nil {
+				return Requirement{}, err
+			}
+			if strings.ContainsAny(value, " \t=!(),") {
+				return Requirement{}, fmt.Errorf("invalid value %q in selector", value)
+			}
+			return Requirement{Key: key, Op: op.op, Values: []string{value}}, nil
+		}
+	}
+
+	if err := validateLabelToken(term, "key"); err != nil {
+		return Requirement{}, err
+	}
+	return Requirement{Key: term, Op: OpExists}, nil
+}
+
+// cutSetOp splits "key in (a,b)" around the operator
+func cutSetOp(term, op string) (key, set string, ok bool) {
+	i := strings.Index(term, op)
+	if i < 0 {
+		return "", "", false
+	}
+	return strings.TrimSpace(term[:i]), strings.TrimSpace(term[i+len(op):]), true
+}
+
+func newSetRequirement(key string, op SelectorOp, set string) (Requirement, error) {
+	if err := validateLabelToken(key, "key"); err != nil {
+		return Requirement{}, err
+	}
+	if !strings.HasPrefix(set, "(") || !strings.HasSuffix(set, ")") {
+		return Requirement{}, fmt.Errorf("%s requires a parenthesized value list", op)
+	}
+
+	var values []string
+	for _, v := range strings.Split(set[1:len(set)-1], ",") {
+		v = strings.TrimSpace(v)
+		if err := validateLabelToken(v, "value"); err != nil {
+			return Requirement{}, err
+		}
+		values = append(values, v)
+	}
+	return Requirement{Key: key, Op: op, Values: values}, nil
+}
+
+func validateLabelToken(s, kind string) error {
+	if s == "" {
+		return fmt.Errorf("empty %s in selector", kind)
+	}
+	if strings.ContainsAny(s, " \t=!(),") {
+		return fmt.Errorf("invalid %s %q in selector", kind, s)
+	}
+	return nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// LabelIndex is an inverted index from label key and value to item
+// positions. Selecting with it intersects the posting lists of the
+// selector's positive requirements, smallest first, and only evaluates the
+// remaining requirements on the surviving candidates.
+type LabelIndex struct {
+	labels []Labels
+	keys   map[string]map[string][]int
+}
+
+// NewLabelIndex indexes the labels of each item's tags; positions in the
+// result of Select refer to the order of tagSets
+func NewLabelIndex(tagSets [][]string) *LabelIndex {
+	idx := &LabelIndex{
+		labels: make([]Labels, len(tagSets)),
+		keys:   make(map[string]map[string][]int),
+	}
+	for i, tags := range tagSets {
+		labels := LabelsFromTags(tags)
+		idx.labels[i] = labels
+		for key, value := range labels {
+			values, ok := idx.keys[key]
+			if !ok {
+				values = make(map[string][]int)
+				idx.keys[key] = values
+			}
+			values[value] = append(values[value], i)
+		}
+	}
+	return idx
+}
+
+// Select returns the positions of the items matching sel in ascending order
+func (idx *LabelIndex) Select(sel *Selector) []int {
+	var postings [][]int
+	if !sel.Empty() {
+		for _, req := range sel.Requirements {
+			if req.positive() {
+				postings = append(postings, idx.postings(req))
+			}
+		}
+	}
+
+	var candidates []int
+	if len(postings) == 0 {
+		candidates = make([]int, len(idx.labels))
+		for i := range candidates {
+			candidates[i] = i
+		}
+	} else {
+		sort.Slice(postings, func(i, j int) bool { return len(postings[i]) < len(postings[j]) })
+		candidates = postings[0]
+		for _, p := range postings[1:] {
+			candidates = intersectSorted(candidates, p)
+		}
+	}
+
+	matched := candidates[:0:0]
+	for _, i := range candidates {
+		if sel.Matches(idx.labels[i]) {
+			matched = append(matched, i)
+		}
+	}
+	return matched
+}
+
+// postings returns the sorted positions that carry the requirement's key
+// with one of its values (any value for exists)
+func (idx *LabelIndex) postings(req Requirement) []int {
+	values := idx.keys[req.Key]
+	var lists [][]int
+	if req.Op == OpExists {
+		for _, list := range values {
+			lists = append(lists, list)
+		}
+	} else {
+		for _, v := range req.Values {
+			lists = append(lists, values[v])
+		}
+	}
+
+	var merged []int
+	for _, list := range lists {
+		merged = append(merged, list...)
+	}
+	sort.Ints(merged)
+	return merged
+}
+
+func intersectSorted(a, b []int) []int {
+	var out []int
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
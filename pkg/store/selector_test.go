@@ -0,0 +1,90 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSelector(t *testing.T) {
+	sel, err := ParseSelector("env=prod, team!=dbre,tier in (web, api),region notin (eu),owner,!deprecated,zone==a")
+	require.NoError(t, err)
+	assert.Equal(t, []Requirement{
+		{Key: "env", Op: OpEquals, Values: []string{"prod"}},
+		{Key: "team", Op: OpNotEquals, Values: []string{"dbre"}},
+		{Key: "tier", Op: OpIn, Values: []string{"web", "api"}},
+		{Key: "region", Op: OpNotIn, Values: []string{"eu"}},
+		{Key: "owner", Op: OpExists},
+		{Key: "deprecated", Op: OpDoesNotExist},
+		{Key: "zone", Op: OpEquals, Values: []string{"a"}},
+	}, sel.Requirements)
+
+	sel, err = ParseSelector("")
+	require.NoError(t, err)
+	assert.True(t, sel.Empty())
+
+	for _, bad := range []string{
+		"env=prod,,team=x",
+		"tier in (web",
+		"tier in web",
+		"tier in ()",
+		"=prod",
+		"env=a=b",
+		"!",
+		"a b",
+	} {
+		_, err := ParseSelector(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	tags := []string{"env=prod", "team=web", "pci"}
+
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{"env=prod", true},
+		{"env=dev", false},
+		{"env!=dev", true},
+		{"team!=web", false},
+		{"missing!=x", true},
+		{"team in (web,api)", true},
+		{"team notin (web,api)", false},
+		{"pci", true},
+		{"!pci", false},
+		{"!deprecated", true},
+		{"env=prod,team!=dbre,pci", true},
+	}
+
+	for _, tt := range tests {
+		sel, err := ParseSelector(tt.selector)
+		require.NoError(t, err, tt.selector)
+		assert.Equal(t, tt.want, sel.MatchesTags(tags), tt.selector)
+	}
+}
+
+func TestLabelIndexSelect(t *testing.T) {
+	idx := NewLabelIndex([][]string{
+		{"env=prod", "team=web"},
+		{"env=prod", "team=dbre"},
+		{"env=dev", "team=web"},
+		{"lab"},
+	})
+
+	sel := func(s string) []int {
+		parsed, err := ParseSelector(s)
+		require.NoError(t, err)
+		return idx.Select(parsed)
+	}
+
+	assert.Equal(t, []int{0, 1, 2, 3}, sel(""))
+	assert.Equal(t, []int{0}, sel("env=prod,team!=dbre"))
+	assert.Equal(t, []int{0, 2}, sel("team=web"))
+	assert.Equal(t, []int{0, 1, 2}, sel("env in (prod,dev)"))
+	assert.Equal(t, []int{2, 3}, sel("env!=prod"))
+	assert.Equal(t, []int{3}, sel("!env"))
+	assert.Empty(t, sel("env=staging"))
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// DNSTagPrefix names a name server of a network; networks may carry
+// several
+const DNSTagPrefix = "dns="
+
+// DomainTagPrefix names the DNS domain of a network
+const DomainTagPrefix = "domain="
+
+// Settings are what a host needs besides its address to configure an
+// interface in a network
+type Settings struct {
+	// Gateway is the address of the network's active allocation tagged
+	// GatewayTag
+	Gateway string `json:"gateway,omitempty"`
+
+	// DNSServers come from the network's dns=<address> tags
+	DNSServers []string `json:"dns_servers,omitempty"`
+
+	// Domain comes from the network's domain=<name> tag
+	Domain string `json:"domain,omitempty"`
+
+	// DHCP are the DHCP options handed out with addresses of the network,
+	// see TagDHCPOptions
+	DHCP *DHCPOptions `json:"dhcp,omitempty"`
+}
+
+// NetworkSettings returns the settings of network, whose allocations are
+// searched for the gateway, or nil when it has none
+func NetworkSettings(network *ipam.Network, allocations []*ipam.IPAllocation, now time.Time) *Settings {
+	s := &Settings{DHCP: TagDHCPOptions(network.Tags)}
+	for _, tag := range network.Tags {
+		if value, ok := strings.CutPrefix(tag, DNSTagPrefix); ok {
+			if ns, err := netip.ParseAddr(value); err == nil && !slices.Contains(s.DNSServers, ns.Unmap().String()) {
+				s.DNSServers = append(s.DNSServers, ns.Unmap().String())
+			}
+		}
+		if value, ok := strings.CutPrefix(tag, DomainTagPrefix); ok {
+			s.Domain = value
+		}
+	}
+	for _, alloc := range allocations {
+		if alloc.EndIP == "" && slices.Contains(alloc.Tags, GatewayTag) && AllocationStatus(alloc, now) == StatusActive {
+			s.Gateway = alloc.IP
+			break
+		}
+	}
+
+	if s.Gateway == "" && len(s.DNSServers) == 0 && s.Domain == "" && s.DHCP == nil {
+		return nil
+	}
+	return s
+}
+
+// AllocationSettings returns the settings of alloc: those of its network,
+// with the DHCP options alloc overrides, or nil when there are none
+func AllocationSettings(network *ipam.Network, allocations []*ipam.IPAllocation, alloc *ipam.IPAllocation, now time.Time) *Settings {
+	s := NetworkSettings(network, allocations, now)
+	options := AllocationDHCPOptions(network, alloc)
+	if options == nil {
+		return s
+	}
+	if s == nil {
+		s = &Settings{}
+	}
+	s.DHCP = options
+	return s
+}
+
+// SettingsTags returns tags with their dns= tags replaced by dnsServers,
+// when there are any, and their domain= tag replaced by domain, when set
+func SettingsTags(tags, dnsServers []string, domain string) []string {
+	kept := slices.DeleteFunc(slices.Clone(tags), func(tag string) bool {
+		return (len(dnsServers) > 0 && strings.HasPrefix(tag, DNSTagPrefix)) ||
+			(domain != "" && strings.HasPrefix(tag, DomainTagPrefix))
+	})
+	for _, ns := range dnsServers {
+		kept = append(kept, DNSTagPrefix+ns)
+	}
+	if domain != "" {
+		kept = append(kept, DomainTagPrefix+domain)
+	}
+	return kept
+}
+
+// settingsTagPrefixes are the prefixes of the tags holding a network's
+// settings
+var settingsTagPrefixes = []string{DNSTagPrefix, DomainTagPrefix, ReuseDelayTagPrefix,
+	BootFileTagPrefix, NextServerTagPrefix, DHCPOptionTagPrefix, PoolTagPrefix}
+
+// KeepSettingsTags returns tags followed by the settings tags of current of
+// every kind tags has none of, so that replacing a network's tags with them
+// changes only the settings tags gives
+func KeepSettingsTags(tags, current []string) []string {
+	kept := slices.Clone(tags)
+	for _, prefix := range settingsTagPrefixes {
+		hasPrefix := func(tag string) bool { return strings.HasPrefix(tag, prefix) }
+		if slices.ContainsFunc(tags, hasPrefix) {
+			continue
+		}
+		for _, tag := range current {
+			if hasPrefix(tag) {
+				kept = append(kept, tag)
+			}
+		}
+	}
+	return kept
+}
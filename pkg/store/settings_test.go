@@ -0,0 +1,31 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkSettings(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	released := now.Add(-time.Hour)
+
+	network := &ipam.Network{ID: "net1", CIDR: "10.0.0.0/24", Tags: []string{"env=prod"}}
+	assert.Nil(t, NetworkSettings(network, nil, now))
+
+	network.Tags = SettingsTags(append(network.Tags, "dns=192.0.2.1"), []string{"10.0.0.53", "10.0.0.54"}, "lab.example.com")
+	assert.Equal(t, []string{"env=prod", "dns=10.0.0.53", "dns=10.0.0.54", "domain=lab.example.com"}, network.Tags)
+	assert.Equal(t, network.Tags, SettingsTags(network.Tags, nil, ""))
+
+	allocations := []*ipam.IPAllocation{
+		{ID: "old", NetworkID: "net1", IP: "10.0.0.254", Tags: []string{GatewayTag}, ReleasedAt: &released},
+		{ID: "host", NetworkID: "net1", IP: "10.0.0.10"},
+		{ID: "gw", NetworkID: "net1", IP: "10.0.0.1", Tags: []string{GatewayTag}},
+	}
+	s := NetworkSettings(network, allocations, now)
+	require.NotNil(t, s)
+	assert.Equal(t, &Settings{Gateway: "10.0.0.1", DNSServers: []string{"10.0.0.53", "10.0.0.54"}, Domain: "lab.example.com"}, s)
+}
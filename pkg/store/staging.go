@@ -0,0 +1,282 @@
+package store
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+// ErrStagedDelete is returned by a Staging store's deletes, which a Batch
+// cannot express
+var ErrStagedDelete = errors.New("deletes cannot be staged")
+
+// Staging is an ipam.Store that holds writes back so they can be applied
+// together with ApplyBatch. Reads see the staged writes over the records of
+// the store underneath. The first read of the networks, and of each
+// network's allocations, records their digest in the batch, so that
+// ApplyBatch refuses the writes if those records change in the meantime.
+type Staging struct {
+	base ipam.Store
+
+	mu          sync.Mutex
+	networks    []*ipam.Network      // Latest staged version of each, in order
+	allocations []*ipam.IPAllocation // Likewise
+	audit       []*ipam.AuditEntry
+	digests     Batch
+}
+
+// NewStaging stages writes to base
+func NewStaging(base ipam.Store) *Staging {
+	return &Staging{base: base, digests: Batch{AllocationDigests: map[string]string{}}}
+}
+
+// Batch returns the staged writes and the digests of the records they were
+// based on
+func (s *Staging) Batch() *Batch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	digests := make(map[string]string, len(s.digests.AllocationDigests))
+	for id, digest := range s.digests.AllocationDigests {
+		digests[id] = digest
+	}
+	return &Batch{
+		Networks:          append([]*ipam.Network(nil), s.networks...),
+		Allocations:       append([]*ipam.IPAllocation(nil), s.allocations...),
+		AuditEntries:      append([]*ipam.AuditEntry(nil), s.audit...),
+		NetworksDigest:    s.digests.NetworksDigest,
+		AllocationDigests: digests,
+	}
+}
+
+// watchNetworks records the digest of base's networks on first use
+func (s *Staging) watchNetworks() error {
+	if s.digests.NetworksDigest != "" {
+		return nil
+	}
+	networks, err := s.base.ListNetworks()
+	if err != nil {
+		return err
+	}
+	s.digests.NetworksDigest = NetworksDigest(networks)
+	return nil
+}
+
+// watchAllocations records the digest of base's allocations in networkID
+// on first use
+func (s *Staging) watchAllocations(networkID string) error {
+	if _, ok := s.digests.AllocationDigests[networkID]; ok {
+		return nil
+	}
+	allocations, err := s.base.ListAllocations(networkID)
+	if err != nil {
+		return err
+	}
+	s.digests.AllocationDigests[networkID] = AllocationsDigest(allocations)
+	return nil
+}
+
+func (s *Staging) SaveNetwork(network *ipam.Network) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	staged := *network
+	for i, n := range s.networks {
+		if n.ID == network.ID {
+			s.networks[i] = &staged
+			return nil
+		}
+	}
+	s.networks = append(s.networks, &staged)
+	return nil
+}
+
+func (s *Staging) GetNetwork(id string) (*ipam.Network, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.watchNetworks(); err != nil {
+		return nil, err
+	}
+	for _, n := range s.networks {
+		if n.ID == id {
+			staged := *n
+			return &staged, nil
+		}
+	}
+	return s.base.GetNetwork(id)
+}
+
+func (s *Staging) GetNetworkByCIDR(cidr string) (*ipam.Network, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.watchNetworks(); err != nil {
+		return nil, err
+	}
+	for _, n := range s.networks {
+		if n.CIDR == cidr {
+			staged := *n
+			return &staged, nil
+		}
+	}
+	network, err := s.base.GetNetworkByCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	// The staged version may have moved to another CIDR
+	for _, n := range s.networks {
+		if n.ID == network.ID {
+			return nil, ipam.ErrNetworkNotFound
+		}
+	}
+	return network, nil
+}
+
+func (s *Staging) ListNetworks() ([]*ipam.Network, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.watchNetworks(); err != nil {
+		return nil, err
+	}
+	networks, err := s.base.ListNetworks()
+	if err != nil {
+		return nil, err
+	}
+	return overlay(networks, s.networks, func(n *ipam.Network) string { return n.ID }), nil
+}
+
+func (s *Staging) DeleteNetwork(id string) error {
+	return ErrStagedDelete
+}
+
+func (s *Staging) SaveAllocation(allocation *ipam.IPAllocation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	staged := *allocation
+	for i, a := range s.allocations {
+		if a.ID == allocation.ID {
+			s.allocations[i] = &staged
+			return nil
+		}
+	}
+	s.allocations = append(s.allocations, &staged)
+	return nil
+}
+
+func (s *Staging) GetAllocation(id string) (*ipam.IPAllocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range s.allocations {
+		if a.ID == id {
+			staged := *a
+			return &staged, nil
+		}
+	}
+	allocation, err := s.base.GetAllocation(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.watchAllocations(allocation.NetworkID); err != nil {
+		return nil, err
+	}
+	return allocation, nil
+}
+
+func (s *Staging) GetAllocationByIP(networkID, ip string) (*ipam.IPAllocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.watchAllocations(networkID); err != nil {
+		return nil, err
+	}
+	// Like the stores' IP index, the latest save of an address wins
+	for i := len(s.allocations) - 1; i >= 0; i-- {
+		if a := s.allocations[i]; a.NetworkID == networkID && a.IP == ip {
+			staged := *a
+			return &staged, nil
+		}
+	}
+	return s.base.GetAllocationByIP(networkID, ip)
+}
+
+func (s *Staging) ListAllocations(networkID string) ([]*ipam.IPAllocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.watchAllocations(networkID); err != nil {
+		return nil, err
+	}
+	allocations, err := s.base.ListAllocations(networkID)
+	if err != nil {
+		return nil, err
+	}
+	var staged []*ipam.IPAllocation
+	for _, a := range s.allocations {
+		if a.NetworkID == networkID {
+			staged = append(staged, a)
+		}
+	}
+	return overlay(allocations, staged, func(a *ipam.IPAllocation) string { return a.ID }), nil
+}
+
+func (s *Staging) DeleteAllocation(id string) error {
+	return ErrStagedDelete
+}
+
+func (s *Staging) SaveAuditEntry(entry *ipam.AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	staged := *entry
+	s.audit = append(s.audit, &staged)
+	return nil
+}
+
+// ListAuditEntries returns the staged entries, most recent first, followed
+// by base's
+func (s *Staging) ListAuditEntries(limit int) ([]*ipam.AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []*ipam.AuditEntry
+	for i := len(s.audit) - 1; i >= 0; i-- {
+		entry := *s.audit[i]
+		entries = append(entries, &entry)
+	}
+	if limit > 0 && len(entries) >= limit {
+		return entries[:limit], nil
+	}
+
+	rest := 0
+	if limit > 0 {
+		rest = limit - len(entries)
+	}
+	base, err := s.base.ListAuditEntries(rest)
+	if err != nil {
+		return nil, err
+	}
+	return append(entries, base...), nil
+}
+
+// overlay replaces the records of base that were staged with their staged
+// copies and appends the staged records that are new
+func overlay[T any](base, staged []*T, id func(*T) string) []*T {
+	index := make(map[string]int, len(base))
+	for i, record := range base {
+		index[id(record)] = i
+	}
+	for _, record := range staged {
+		copied := *record
+		if i, ok := index[id(record)]; ok {
+			base[i] = &copied
+		} else {
+			base = append(base, &copied)
+		}
+	}
+	return base
+}
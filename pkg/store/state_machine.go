@@ -4,6 +4,8 @@ import (
 	"encoding/gob"
 	"fmt"
 	"io"
+	"slices"
+	"sort"
 	"sync"
 
 	"github.com/jeremyhahn/go-ipam/pkg/ipam"
@@ -24,6 +26,10 @@ func init() {
 	gob.Register(&getAllocationByIPQuery{})
 	gob.Register(&listAllocationsQuery{})
 	gob.Register(&listAuditQuery{})
+	gob.Register(&listEventsQuery{})
+	gob.Register(&findNetworksQuery{})
+	gob.Register(&moveAllocationCmd{})
+	gob.Register(&applyBatchCmd{})
 }
 
 // Command types
@@ -35,6 +41,8 @@ const (
 	cmdSaveAllocation
 	cmdDeleteAllocation
 	cmdSaveAudit
+	cmdMoveAllocation
+	cmdApplyBatch
 )
 
 // Query types
@@ -48,6 +56,9 @@ const (
 	queryGetAllocationByIP
 	queryListAllocations
 	queryListAudit
+	queryFindNetworks
+	queryApplied
+	queryListEvents
 )
 
 // Commands
@@ -67,10 +78,19 @@ type deleteAllocationCmd struct {
 	ID string
 }
 
+type moveAllocationCmd struct {
+	ID        string
+	NetworkID string
+}
+
 type saveAuditCmd struct {
 	Entry *ipam.AuditEntry
 }
 
+type applyBatchCmd struct {
+	Batch *Batch
+}
+
 // Queries
 type getNetworkQuery struct {
 	ID string
@@ -99,6 +119,15 @@ type listAuditQuery struct {
 	Limit int
 }
 
+type listEventsQuery struct {
+	Since uint64
+	Limit int
+}
+
+type findNetworksQuery struct {
+	Filter NetworkFilter
+}
+
 // ipamStateMachine implements the Raft state machine for IPAM
 type ipamStateMachine struct {
 	clusterID uint64
@@ -109,6 +138,19 @@ type ipamStateMachine struct {
 	allocations map[string]*ipam.IPAllocation
 	audit       []*ipam.AuditEntry
 
+	// auditTrimmed counts the audit entries dropped from the front of
+	// audit, so that the entry at index i is event auditTrimmed+i+1
+	auditTrimmed uint64
+
+	// auditIDs holds the IDs of the entries in audit, so that an entry
+	// proposed again, e.g. retried after a proposal that timed out but was
+	// committed, is kept once
+	auditIDs map[string]struct{}
+
+	// applied counts the commands applied, snapshots included, so nodes
+	// that have applied the same log agree on it
+	applied uint64
+
 	// Indexes for fast lookup
 	networkByCIDR    map[string]string   // CIDR -> Network ID
 	allocationByIP   map[string]string   // NetworkID:IP -> Allocation ID
@@ -122,6 +164,7 @@ func newIPAMStateMachine(clusterID, nodeID uint64) sm.IStateMachine {
 		networks:         make(map[string]*ipam.Network),
 		allocations:      make(map[string]*ipam.IPAllocation),
 		audit:            make([]*ipam.AuditEntry, 0),
+		auditIDs:         make(map[string]struct{}),
 		networkByCIDR:    make(map[string]string),
 		allocationByIP:   make(map[string]string),
 		allocationsByNet: make(map[string][]string),
@@ -133,6 +176,7 @@ func (s *ipamStateMachine) Update(data []byte) (sm.Result, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	s.applied++
 	result, err := s.applyEntry(data)
 	if err != nil {
 		return sm.Result{
@@ -171,7 +215,10 @@ func (s *ipamStateMachine) Lookup(query interface{}) (interface{}, error) {
 		if err := decode(queryData, &q); err != nil {
 			return nil, err
 		}
-		return s.networks[q.ID], nil
+		if network, ok := s.networks[q.ID]; ok {
+			return cloneNetwork(network), nil
+		}
+		return nil, nil
 
 	case queryGetNetworkByCIDR:
 		var q getNetworkByCIDRQuery
@@ -179,23 +226,46 @@ func (s *ipamStateMachine) Lookup(query interface{}) (interface{}, error) {
 			return nil, err
 		}
 		if id, ok := s.networkByCIDR[q.CIDR]; ok {
-			return s.networks[id], nil
+			return cloneNetwork(s.networks[id]), nil
 		}
 		return nil, nil
 
 	case queryListNetworks:
 		networks := make([]*ipam.Network, 0, len(s.networks))
 		for _, n := range s.networks {
-			networks = append(networks, n)
+			networks = append(networks, cloneNetwork(n))
 		}
+		// Match the key ordering of the Pebble store so listings are stable
+		sort.Slice(networks, func(i, j int) bool {
+			return networks[i].ID < networks[j].ID
+		})
 		return networks, nil
 
+	case queryFindNetworks:
+		var q findNetworksQuery
+		if err := decode(queryData, &q); err != nil {
+			return nil, err
+		}
+		networks := make([]*ipam.Network, 0)
+		for _, n := range s.networks {
+			if q.Filter.matchFields(n) {
+				networks = append(networks, cloneNetwork(n))
+			}
+		}
+		sort.Slice(networks, func(i, j int) bool {
+			return networks[i].ID < networks[j].ID
+		})
+		return q.Filter.applySelector(networks), nil
+
 	case queryGetAllocation:
 		var q getAllocationQuery
 		if err := decode(queryData, &q); err != nil {
 			return nil, err
 		}
-		return s.allocations[q.ID], nil
+		if alloc, ok := s.allocations[q.ID]; ok {
+			return cloneAllocation(alloc), nil
+		}
+		return nil, nil
 
 	case queryGetAllocationByIP:
 		var q getAllocationByIPQuery
@@ -204,7 +274,7 @@ func (s *ipamStateMachine) Lookup(query interface{}) (interface{}, error) {
 		}
 		key := fmt.Sprintf("%s:%s", q.NetworkID, q.IP)
 		if id, ok := s.allocationByIP[key]; ok {
-			return s.allocations[id], nil
+			return cloneAllocation(s.allocations[id]), nil
 		}
 		return nil, nil
 
@@ -217,11 +287,33 @@ func (s *ipamStateMachine) Lookup(query interface{}) (interface{}, error) {
 		allocations := make([]*ipam.IPAllocation, 0, len(allocIDs))
 		for _, id := range allocIDs {
 			if alloc, ok := s.allocations[id]; ok {
-				allocations = append(allocations, alloc)
+				allocations = append(allocations, cloneAllocation(alloc))
 			}
 		}
+		sort.Slice(allocations, func(i, j int) bool {
+			return allocations[i].ID < allocations[j].ID
+		})
 		return allocations, nil
 
+	case queryApplied:
+		return s.applied, nil
+
+	case queryListEvents:
+		var q listEventsQuery
+		if err := decode(queryData, &q); err != nil {
+			return nil, err
+		}
+		start := uint64(0)
+		if q.Since > s.auditTrimmed {
+			start = q.Since - s.auditTrimmed
+		}
+		var events []*Event
+		for i := start; i < uint64(len(s.audit)) && (q.Limit <= 0 || len(events) < q.Limit); i++ {
+			entry := *s.audit[i]
+			events = append(events, &Event{Seq: s.auditTrimmed + i + 1, AuditEntry: &entry})
+		}
+		return events, nil
+
 	case queryListAudit:
 		var q listAuditQuery
 		if err := decode(queryData, &q); err != nil {
@@ -232,9 +324,10 @@ func (s *ipamStateMachine) Lookup(query interface{}) (interface{}, error) {
 		if start < 0 || q.Limit <= 0 {
 			start = 0
 		}
-		result := make([]*ipam.AuditEntry, 0, q.Limit)
+		result := make([]*ipam.AuditEntry, 0, len(s.audit)-start)
 		for i := len(s.audit) - 1; i >= start && i >= 0; i-- {
-			result = append(result, s.audit[i])
+			entry := *s.audit[i]
+			result = append(result, &entry)
 		}
 		return result, nil
 
@@ -250,9 +343,11 @@ func (s *ipamStateMachine) SaveSnapshot(w io.Writer, fc sm.ISnapshotFileCollecti
 
 	// Create snapshot data
 	snapshot := &snapshotData{
-		Networks:    s.networks,
-		Allocations: s.allocations,
-		Audit:       s.audit,
+		Networks:     s.networks,
+		Allocations:  s.allocations,
+		Audit:        s.audit,
+		AuditTrimmed: s.auditTrimmed,
+		Applied:      s.applied,
 	}
 
 	// Encode and write
@@ -276,6 +371,8 @@ func (s *ipamStateMachine) RecoverFromSnapshot(r io.Reader, files []sm.SnapshotF
 	s.networks = snapshot.Networks
 	s.allocations = snapshot.Allocations
 	s.audit = snapshot.Audit
+	s.auditTrimmed = snapshot.AuditTrimmed
+	s.applied = snapshot.Applied
 
 	// Rebuild indexes
 	s.rebuildIndexes()
@@ -303,8 +400,7 @@ func (s *ipamStateMachine) applyEntry(cmd []byte) ([]byte, error) {
 		if err := decode(cmdData, &c); err != nil {
 			return nil, err
 		}
-		s.networks[c.Network.ID] = c.Network
-		s.networkByCIDR[c.Network.CIDR] = c.Network.ID
+		s.saveNetwork(c.Network)
 		return nil, nil
 
 	case cmdDeleteNetwork:
@@ -334,29 +430,7 @@ func (s *ipamStateMachine) applyEntry(cmd []byte) ([]byte, error) {
 		if err := decode(cmdData, &c); err != nil {
 			return nil, err
 		}
-		alloc := c.Allocation
-		s.allocations[alloc.ID] = alloc
-
-		// Update indexes
-		key := fmt.Sprintf("%s:%s", alloc.NetworkID, alloc.IP)
-		s.allocationByIP[key] = alloc.ID
-
-		// Add to network's allocation list
-		if _, exists := s.allocationsByNet[alloc.NetworkID]; !exists {
-			s.allocationsByNet[alloc.NetworkID] = []string{}
-		}
-		// Check if already in list
-		found := false
-		for _, id := range s.allocationsByNet[alloc.NetworkID] {
-			if id == alloc.ID {
-				found = true
-				break
-			}
-		}
-		if !found {
-			s.allocationsByNet[alloc.NetworkID] = append(s.allocationsByNet[alloc.NetworkID], alloc.ID)
-		}
-
+		s.saveAllocation(c.Allocation)
 		return nil, nil
 
 	case cmdDeleteAllocation:
@@ -366,8 +440,11 @@ func (s *ipamStateMachine) applyEntry(cmd []byte) ([]byte, error) {
 		}
 		if alloc, ok := s.allocations[c.ID]; ok {
 			delete(s.allocations, c.ID)
+			// A newer allocation of the same IP keeps the index
 			key := fmt.Sprintf("%s:%s", alloc.NetworkID, alloc.IP)
-			delete(s.allocationByIP, key)
+			if s.allocationByIP[key] == c.ID {
+				delete(s.allocationByIP, key)
+			}
 
 			// Remove from network's allocation list
 			if allocIDs, ok := s.allocationsByNet[alloc.NetworkID]; ok {
@@ -382,15 +459,63 @@ func (s *ipamStateMachine) applyEntry(cmd []byte) ([]byte, error) {
 		}
 		return nil, nil
 
+	case cmdMoveAllocation:
+		var c moveAllocationCmd
+		if err := decode(cmdData, &c); err != nil {
+			return nil, err
+		}
+		alloc, ok := s.allocations[c.ID]
+		if !ok {
+			return nil, nil
+		}
+
+		oldKey := fmt.Sprintf("%s:%s", alloc.NetworkID, alloc.IP)
+		if s.allocationByIP[oldKey] == c.ID {
+			delete(s.allocationByIP, oldKey)
+		}
+		if allocIDs, ok := s.allocationsByNet[alloc.NetworkID]; ok {
+			newList := make([]string, 0, len(allocIDs))
+			for _, id := range allocIDs {
+				if id != c.ID {
+					newList = append(newList, id)
+				}
+			}
+			s.allocationsByNet[alloc.NetworkID] = newList
+		}
+
+		moved := *alloc
+		moved.NetworkID = c.NetworkID
+		s.allocations[c.ID] = &moved
+		s.allocationByIP[fmt.Sprintf("%s:%s", moved.NetworkID, moved.IP)] = c.ID
+		s.allocationsByNet[moved.NetworkID] = append(s.allocationsByNet[moved.NetworkID], c.ID)
+		return nil, nil
+
 	case cmdSaveAudit:
 		var c saveAuditCmd
 		if err := decode(cmdData, &c); err != nil {
 			return nil, err
 		}
-		s.audit = append(s.audit, c.Entry)
-		// Keep only last 10000 entries
-		if len(s.audit) > 10000 {
-			s.audit = s.audit[len(s.audit)-10000:]
+		s.saveAudit(c.Entry)
+		return nil, nil
+
+	case cmdApplyBatch:
+		var c applyBatchCmd
+		if err := decode(cmdData, &c); err != nil {
+			return nil, err
+		}
+		// A failed check is the batch's outcome, not a failure of the
+		// state machine, so it is returned as the result
+		if err := checkBatch(c.Batch, s.listNetworks, s.listAllocations); err != nil {
+			return []byte(err.Error()), nil
+		}
+		for _, network := range c.Batch.Networks {
+			s.saveNetwork(network)
+		}
+		for _, alloc := range c.Batch.Allocations {
+			s.saveAllocation(alloc)
+		}
+		for _, entry := range c.Batch.AuditEntries {
+			s.saveAudit(entry)
 		}
 		return nil, nil
 
@@ -399,11 +524,86 @@ func (s *ipamStateMachine) applyEntry(cmd []byte) ([]byte, error) {
 	}
 }
 
+func (s *ipamStateMachine) saveNetwork(network *ipam.Network) {
+	if previous, ok := s.networks[network.ID]; ok && previous.CIDR != network.CIDR {
+		delete(s.networkByCIDR, previous.CIDR)
+	}
+	s.networks[network.ID] = network
+	s.networkByCIDR[network.CIDR] = network.ID
+}
+
+func (s *ipamStateMachine) saveAllocation(alloc *ipam.IPAllocation) {
+	s.allocations[alloc.ID] = alloc
+
+	// Update indexes
+	key := fmt.Sprintf("%s:%s", alloc.NetworkID, alloc.IP)
+	s.allocationByIP[key] = alloc.ID
+
+	// Add to network's allocation list
+	if _, exists := s.allocationsByNet[alloc.NetworkID]; !exists {
+		s.allocationsByNet[alloc.NetworkID] = []string{}
+	}
+	// Check if already in list
+	found := false
+	for _, id := range s.allocationsByNet[alloc.NetworkID] {
+		if id == alloc.ID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		s.allocationsByNet[alloc.NetworkID] = append(s.allocationsByNet[alloc.NetworkID], alloc.ID)
+	}
+}
+
+func (s *ipamStateMachine) saveAudit(entry *ipam.AuditEntry) {
+	if _, ok := s.auditIDs[entry.ID]; ok {
+		return
+	}
+	s.audit = append(s.audit, entry)
+	s.auditIDs[entry.ID] = struct{}{}
+	// Keep only last 10000 entries
+	if len(s.audit) > 10000 {
+		trimmed := len(s.audit) - 10000
+		for _, old := range s.audit[:trimmed] {
+			delete(s.auditIDs, old.ID)
+		}
+		s.auditTrimmed += uint64(trimmed)
+		s.audit = s.audit[trimmed:]
+	}
+}
+
+// listNetworks returns the networks, unsorted and uncloned, for checkBatch
+func (s *ipamStateMachine) listNetworks() ([]*ipam.Network, error) {
+	networks := make([]*ipam.Network, 0, len(s.networks))
+	for _, n := range s.networks {
+		networks = append(networks, n)
+	}
+	return networks, nil
+}
+
+// listAllocations returns a network's allocations, unsorted and uncloned,
+// for checkBatch
+func (s *ipamStateMachine) listAllocations(networkID string) ([]*ipam.IPAllocation, error) {
+	allocations := make([]*ipam.IPAllocation, 0, len(s.allocationsByNet[networkID]))
+	for _, id := range s.allocationsByNet[networkID] {
+		if alloc, ok := s.allocations[id]; ok {
+			allocations = append(allocations, alloc)
+		}
+	}
+	return allocations, nil
+}
+
 // rebuildIndexes rebuilds the lookup indexes after snapshot recovery
 func (s *ipamStateMachine) rebuildIndexes() {
 	s.networkByCIDR = make(map[string]string)
 	s.allocationByIP = make(map[string]string)
 	s.allocationsByNet = make(map[string][]string)
+	s.auditIDs = make(map[string]struct{}, len(s.audit))
+
+	for _, entry := range s.audit {
+		s.auditIDs[entry.ID] = struct{}{}
+	}
 
 	// Rebuild network index
 	for id, network := range s.networks {
@@ -422,9 +622,34 @@ func (s *ipamStateMachine) rebuildIndexes() {
 	}
 }
 
+// cloneNetwork returns a copy of n sharing no memory with the state machine,
+// so callers cannot change replicated state by modifying query results
+func cloneNetwork(n *ipam.Network) *ipam.Network {
+	c := *n
+	c.Tags = slices.Clone(n.Tags)
+	return &c
+}
+
+// cloneAllocation is cloneNetwork for allocations
+func cloneAllocation(a *ipam.IPAllocation) *ipam.IPAllocation {
+	c := *a
+	c.Tags = slices.Clone(a.Tags)
+	if a.ExpiresAt != nil {
+		t := *a.ExpiresAt
+		c.ExpiresAt = &t
+	}
+	if a.ReleasedAt != nil {
+		t := *a.ReleasedAt
+		c.ReleasedAt = &t
+	}
+	return &c
+}
+
 // snapshotData holds the complete state for snapshots
 type snapshotData struct {
-	Networks    map[string]*ipam.Network
-	Allocations map[string]*ipam.IPAllocation
-	Audit       []*ipam.AuditEntry
+	Networks     map[string]*ipam.Network
+	Allocations  map[string]*ipam.IPAllocation
+	Audit        []*ipam.AuditEntry
+	AuditTrimmed uint64 // Zero in snapshots taken before it was counted
+	Applied      uint64 // Likewise
 }
@@ -0,0 +1,386 @@
+// Package storetest is a conformance suite for ipam.Store implementations.
+// Every backend runs the same suite so that the API, CLI and allocator see
+// identical behavior whichever store is configured:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.TestStore(t, func(t *testing.T) ipam.Store {
+//			return newMyStore(t)
+//		})
+//	}
+package storetest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Factory returns a new, empty store. It is called once per subtest and is
+// responsible for registering cleanup with t.
+type Factory func(t *testing.T) ipam.Store
+
+// TestStore runs the conformance suite against stores created by factory
+func TestStore(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s ipam.Store)
+	}{
+		{"EmptyStore", testEmptyStore},
+		{"NetworkCRUD", testNetworkCRUD},
+		{"NetworkCIDRChange", testNetworkCIDRChange},
+		{"ListNetworksOrder", testListNetworksOrder},
+		{"DeleteNetworkCascades", testDeleteNetworkCascades},
+		{"AllocationCRUD", testAllocationCRUD},
+		{"AllocationIPIndex", testAllocationIPIndex},
+		{"ListAllocationsOrder", testListAllocationsOrder},
+		{"ResultsAreCopies", testResultsAreCopies},
+		{"AuditEntries", testAuditEntries},
+		{"Concurrency", testConcurrency},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, factory(t))
+		})
+	}
+}
+
+func network(id, cidr string) *ipam.Network {
+	now := time.Now().UTC().Truncate(time.Second)
+	return &ipam.Network{ID: id, CIDR: cidr, Description: "network " + id, Tags: []string{"env=test"}, CreatedAt: now, UpdatedAt: now}
+}
+
+func allocation(id, networkID, ip string) *ipam.IPAllocation {
+	return &ipam.IPAllocation{ID: id, NetworkID: networkID, IP: ip, Status: "allocated", AllocatedAt: time.Now().UTC().Truncate(time.Second)}
+}
+
+func ids[T any](items []T, id func(T) string) []string {
+	out := make([]string, len(items))
+	for i, item := range items {
+		out[i] = id(item)
+	}
+	return out
+}
+
+func networkIDs(networks []*ipam.Network) []string {
+	return ids(networks, func(n *ipam.Network) string { return n.ID })
+}
+
+func allocationIDs(allocations []*ipam.IPAllocation) []string {
+	return ids(allocations, func(a *ipam.IPAllocation) string { return a.ID })
+}
+
+func testEmptyStore(t *testing.T, s ipam.Store) {
+	networks, err := s.ListNetworks()
+	require.NoError(t, err)
+	assert.Empty(t, networks)
+
+	allocations, err := s.ListAllocations("missing")
+	require.NoError(t, err)
+	assert.Empty(t, allocations)
+
+	entries, err := s.ListAuditEntries(10)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	_, err = s.GetNetwork("missing")
+	assert.ErrorIs(t, err, ipam.ErrNetworkNotFound)
+	_, err = s.GetNetworkByCIDR("10.0.0.0/24")
+	assert.ErrorIs(t, err, ipam.ErrNetworkNotFound)
+	_, err = s.GetAllocation("missing")
+	assert.ErrorIs(t, err, ipam.ErrIPNotAllocated)
+	_, err = s.GetAllocationByIP("missing", "10.0.0.1")
+	assert.ErrorIs(t, err, ipam.ErrIPNotAllocated)
+	assert.ErrorIs(t, s.DeleteNetwork("missing"), ipam.ErrNetworkNotFound)
+	assert.ErrorIs(t, s.DeleteAllocation("missing"), ipam.ErrIPNotAllocated)
+}
+
+func testNetworkCRUD(t *testing.T, s ipam.Store) {
+	n := network("net1", "10.0.0.0/24")
+	require.NoError(t, s.SaveNetwork(n))
+
+	got, err := s.GetNetwork("net1")
+	require.NoError(t, err)
+	assert.Equal(t, n.CIDR, got.CIDR)
+	assert.Equal(t, n.Description, got.Description)
+	assert.Equal(t, n.Tags, got.Tags)
+	assert.True(t, n.CreatedAt.Equal(got.CreatedAt))
+
+	byCIDR, err := s.GetNetworkByCIDR("10.0.0.0/24")
+	require.NoError(t, err)
+	assert.Equal(t, "net1", byCIDR.ID)
+
+	// Saving an existing ID replaces the record
+	n.Description = "updated"
+	n.Tags = []string{"env=prod", "tier=db"}
+	require.NoError(t, s.SaveNetwork(n))
+
+	got, err = s.GetNetwork("net1")
+	require.NoError(t, err)
+	assert.Equal(t, "updated", got.Description)
+	assert.Equal(t, []string{"env=prod", "tier=db"}, got.Tags)
+
+	networks, err := s.ListNetworks()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"net1"}, networkIDs(networks))
+
+	require.NoError(t, s.DeleteNetwork("net1"))
+	_, err = s.GetNetwork("net1")
+	assert.ErrorIs(t, err, ipam.ErrNetworkNotFound)
+	_, err = s.GetNetworkByCIDR("10.0.0.0/24")
+	assert.ErrorIs(t, err, ipam.ErrNetworkNotFound)
+}
+
+func testNetworkCIDRChange(t *testing.T, s ipam.Store) {
+	n := network("net1", "10.0.0.0/24")
+	require.NoError(t, s.SaveNetwork(n))
+
+	n.CIDR = "10.0.0.0/23"
+	require.NoError(t, s.SaveNetwork(n))
+
+	_, err := s.GetNetworkByCIDR("10.0.0.0/24")
+	assert.ErrorIs(t, err, ipam.ErrNetworkNotFound, "the old CIDR must no longer resolve")
+
+	got, err := s.GetNetworkByCIDR("10.0.0.0/23")
+	require.NoError(t, err)
+	assert.Equal(t, "net1", got.ID)
+}
+
+func testListNetworksOrder(t *testing.T, s ipam.Store) {
+	for _, id := range []string{"net3", "net1", "net2"} {
+		require.NoError(t, s.SaveNetwork(network(id, fmt.Sprintf("10.%c.0.0/24", id[3]))))
+	}
+
+	networks, err := s.ListNetworks()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"net1", "net2", "net3"}, networkIDs(networks))
+}
+
+func testDeleteNetworkCascades(t *testing.T, s ipam.Store) {
+	require.NoError(t, s.SaveNetwork(network("net1", "10.0.0.0/24")))
+	require.NoError(t, s.SaveNetwork(network("net2", "10.0.1.0/24")))
+	require.NoError(t, s.SaveAllocation(allocation("a1", "net1", "10.0.0.1")))
+	require.NoError(t, s.SaveAllocation(allocation("a2", "net1", "10.0.0.2")))
+	require.NoError(t, s.SaveAllocation(allocation("a3", "net2", "10.0.1.1")))
+
+	require.NoError(t, s.DeleteNetwork("net1"))
+
+	_, err := s.GetAllocation("a1")
+	assert.ErrorIs(t, err, ipam.ErrIPNotAllocated)
+	_, err = s.GetAllocationByIP("net1", "10.0.0.2")
+	assert.ErrorIs(t, err, ipam.ErrIPNotAllocated)
+	allocations, err := s.ListAllocations("net1")
+	require.NoError(t, err)
+	assert.Empty(t, allocations)
+
+	// Other networks are untouched
+	allocations, err = s.ListAllocations("net2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a3"}, allocationIDs(allocations))
+}
+
+func testAllocationCRUD(t *testing.T, s ipam.Store) {
+	require.NoError(t, s.SaveNetwork(network("net1", "10.0.0.0/24")))
+	require.NoError(t, s.SaveNetwork(network("net2", "10.0.1.0/24")))
+
+	expires := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+	a := allocation("a1", "net1", "10.0.0.10")
+	a.EndIP = "10.0.0.13"
+	a.Hostname = "db1"
+	a.Description = "database"
+	a.Tags = []string{"tier=db"}
+	a.ExpiresAt = &expires
+	require.NoError(t, s.SaveAllocation(a))
+	require.NoError(t, s.SaveAllocation(allocation("b1", "net2", "10.0.1.10")))
+
+	got, err := s.GetAllocation("a1")
+	require.NoError(t, err)
+	assert.Equal(t, "net1", got.NetworkID)
+	assert.Equal(t, "10.0.0.10", got.IP)
+	assert.Equal(t, "10.0.0.13", got.EndIP)
+	assert.Equal(t, "db1", got.Hostname)
+	assert.Equal(t, "database", got.Description)
+	assert.Equal(t, []string{"tier=db"}, got.Tags)
+	assert.Equal(t, "allocated", got.Status)
+	require.NotNil(t, got.ExpiresAt)
+	assert.True(t, expires.Equal(*got.ExpiresAt))
+	assert.Nil(t, got.ReleasedAt)
+
+	byIP, err := s.GetAllocationByIP("net1", "10.0.0.10")
+	require.NoError(t, err)
+	assert.Equal(t, "a1", byIP.ID)
+	_, err = s.GetAllocationByIP("net2", "10.0.0.10")
+	assert.ErrorIs(t, err, ipam.ErrIPNotAllocated, "the IP index is per network")
+
+	allocations, err := s.ListAllocations("net1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a1"}, allocationIDs(allocations))
+
+	// Releasing is saving the record again
+	released := time.Now().UTC().Truncate(time.Second)
+	got.Status = "released"
+	got.ReleasedAt = &released
+	require.NoError(t, s.SaveAllocation(got))
+
+	got, err = s.GetAllocation("a1")
+	require.NoError(t, err)
+	assert.Equal(t, "released", got.Status)
+	require.NotNil(t, got.ReleasedAt)
+	assert.True(t, released.Equal(*got.ReleasedAt))
+
+	allocations, err = s.ListAllocations("net1")
+	require.NoError(t, err)
+	assert.Len(t, allocations, 1, "released allocations remain as history")
+
+	require.NoError(t, s.DeleteAllocation("a1"))
+	_, err = s.GetAllocation("a1")
+	assert.ErrorIs(t, err, ipam.ErrIPNotAllocated)
+	_, err = s.GetAllocationByIP("net1", "10.0.0.10")
+	assert.ErrorIs(t, err, ipam.ErrIPNotAllocated)
+	allocations, err = s.ListAllocations("net1")
+	require.NoError(t, err)
+	assert.Empty(t, allocations)
+}
+
+func testAllocationIPIndex(t *testing.T, s ipam.Store) {
+	require.NoError(t, s.SaveNetwork(network("net1", "10.0.0.0/24")))
+
+	old := allocation("a1", "net1", "10.0.0.5")
+	now := time.Now()
+	old.Status = "released"
+	old.ReleasedAt = &now
+	require.NoError(t, s.SaveAllocation(old))
+	require.NoError(t, s.SaveAllocation(allocation("a2", "net1", "10.0.0.5")))
+
+	// The most recently saved allocation of an IP owns the index
+	byIP, err := s.GetAllocationByIP("net1", "10.0.0.5")
+	require.NoError(t, err)
+	assert.Equal(t, "a2", byIP.ID)
+
+	// Deleting an older record of the IP leaves the current one reachable
+	require.NoError(t, s.DeleteAllocation("a1"))
+	byIP, err = s.GetAllocationByIP("net1", "10.0.0.5")
+	require.NoError(t, err)
+	assert.Equal(t, "a2", byIP.ID)
+}
+
+func testListAllocationsOrder(t *testing.T, s ipam.Store) {
+	require.NoError(t, s.SaveNetwork(network("net1", "10.0.0.0/24")))
+	for i, id := range []string{"c", "a", "b"} {
+		require.NoError(t, s.SaveAllocation(allocation(id, "net1", fmt.Sprintf("10.0.0.%d", i+1))))
+	}
+
+	allocations, err := s.ListAllocations("net1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, allocationIDs(allocations))
+}
+
+func testResultsAreCopies(t *testing.T, s ipam.Store) {
+	require.NoError(t, s.SaveNetwork(network("net1", "10.0.0.0/24")))
+	a := allocation("a1", "net1", "10.0.0.1")
+	a.Tags = []string{"tier=db"}
+	require.NoError(t, s.SaveAllocation(a))
+
+	// Changing a saved or returned value must not change the stored record
+	a.Hostname = "changed"
+	n, err := s.GetNetwork("net1")
+	require.NoError(t, err)
+	n.Description = "changed"
+	n.Tags[0] = "changed"
+	got, err := s.GetAllocation("a1")
+	require.NoError(t, err)
+	got.Hostname = "changed"
+	got.Tags[0] = "changed"
+	listed, err := s.ListAllocations("net1")
+	require.NoError(t, err)
+	listed[0].Status = "changed"
+
+	n, err = s.GetNetwork("net1")
+	require.NoError(t, err)
+	assert.Equal(t, "network net1", n.Description)
+	assert.Equal(t, []string{"env=test"}, n.Tags)
+
+	got, err = s.GetAllocation("a1")
+	require.NoError(t, err)
+	assert.Empty(t, got.Hostname)
+	assert.Equal(t, []string{"tier=db"}, got.Tags)
+	assert.Equal(t, "allocated", got.Status)
+}
+
+func testAuditEntries(t *testing.T, s ipam.Store) {
+	start := time.Now().UTC().Truncate(time.Second)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.SaveAuditEntry(&ipam.AuditEntry{
+			ID:        fmt.Sprintf("audit%d", i),
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+			Action:    "test_action",
+			Resource:  fmt.Sprintf("resource%d", i),
+			User:      "test",
+		}))
+	}
+
+	// Most recent first, limited
+	entries, err := s.ListAuditEntries(3)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"audit4", "audit3", "audit2"}, ids(entries, func(e *ipam.AuditEntry) string { return e.ID }))
+	assert.Equal(t, "resource4", entries[0].Resource)
+
+	// A limit of zero or less returns every entry
+	entries, err = s.ListAuditEntries(0)
+	require.NoError(t, err)
+	assert.Len(t, entries, 5)
+
+	entries, err = s.ListAuditEntries(-1)
+	require.NoError(t, err)
+	assert.Len(t, entries, 5)
+
+	entries, err = s.ListAuditEntries(100)
+	require.NoError(t, err)
+	assert.Len(t, entries, 5)
+
+	// An entry written again, as when a write is retried after it was
+	// committed, is stored once
+	require.NoError(t, s.SaveAuditEntry(&ipam.AuditEntry{ID: "audit4", Timestamp: start.Add(4 * time.Second), Action: "test_action", Resource: "resource4", User: "test"}))
+	entries, err = s.ListAuditEntries(0)
+	require.NoError(t, err)
+	assert.Len(t, entries, 5)
+}
+
+func testConcurrency(t *testing.T, s ipam.Store) {
+	require.NoError(t, s.SaveNetwork(network("net1", "10.0.0.0/24")))
+
+	const workers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			errs <- s.SaveAllocation(allocation(fmt.Sprintf("a%02d", i), "net1", fmt.Sprintf("10.0.0.%d", i+1)))
+		}(i)
+		go func() {
+			defer wg.Done()
+			_, err := s.ListAllocations("net1")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	allocations, err := s.ListAllocations("net1")
+	require.NoError(t, err)
+	assert.Len(t, allocations, workers)
+	for i := 0; i < workers; i++ {
+		byIP, err := s.GetAllocationByIP("net1", fmt.Sprintf("10.0.0.%d", i+1))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("a%02d", i), byIP.ID)
+	}
+}
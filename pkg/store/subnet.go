@@ -0,0 +1,122 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+var (
+	// ErrSubnetLength is returned when a sub-prefix length does not fit
+	// inside the parent network
+	ErrSubnetLength = errors.New("invalid subnet prefix length")
+
+	// ErrNotNibbleAligned is returned when an IPv6 sub-prefix length is not
+	// a multiple of 4, which reverse DNS delegation depends on
+	ErrNotNibbleAligned = errors.New("IPv6 subnet prefix length must be nibble aligned (a multiple of 4)")
+
+	// ErrNoFreeSubnet is returned when every sub-prefix of the requested
+	// length overlaps an existing network or allocation
+	ErrNoFreeSubnet = errors.New("no free subnet of the requested length")
+)
+
+// ValidateSubnetLength checks that a sub-prefix of length bits can be carved
+// from parent. IPv6 sub-prefixes must fall on nibble boundaries.
+func ValidateSubnetLength(parent netip.Prefix, bits int) error {
+	if bits <= parent.Bits() || bits > parent.Addr().BitLen() {
+		return fmt.Errorf("%w: /%d must be longer than /%d and at most /%d",
+			ErrSubnetLength, bits, parent.Bits(), parent.Addr().BitLen())
+	}
+	if parent.Addr().Is6() && bits%4 != 0 {
+		return fmt.Errorf("%w: /%d", ErrNotNibbleAligned, bits)
+	}
+	return nil
+}
+
+// addrRange is an inclusive span of addresses
+type addrRange struct {
+	first, last netip.Addr
+}
+
+// NextSubnet returns the lowest sub-prefix of parent with length bits that
+// overlaps none of the other networks or the active allocations of parent
+func NextSubnet(parent *ipam.Network, bits int, networks []*ipam.Network, allocations []*ipam.IPAllocation, now time.Time) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(parent.CIDR)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR %q: %w", parent.CIDR, err)
+	}
+	prefix = prefix.Masked()
+	if err := ValidateSubnetLength(prefix, bits); err != nil {
+		return netip.Prefix{}, err
+	}
+
+	var used []addrRange
+	for _, network := range networks {
+		// The parent and the networks enclosing it do not block carving
+		other, err := netip.ParsePrefix(network.CIDR)
+		if err != nil || other.Bits() <= prefix.Bits() || !other.Overlaps(prefix) {
+			continue
+		}
+		other = other.Masked()
+		used = append(used, addrRange{other.Addr(), lastAddr(other)})
+	}
+	for _, alloc := range allocations {
+		if alloc.ReleasedAt != nil || (alloc.ExpiresAt != nil && alloc.ExpiresAt.Before(now)) {
+			continue
+		}
+		first, last, err := allocationRange(alloc)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		used = append(used, addrRange{first, last})
+	}
+
+	end := lastAddr(prefix)
+	addr := prefix.Addr()
+	for {
+		candidate := netip.PrefixFrom(addr, bits)
+		candidateLast := lastAddr(candidate)
+
+		// Skip past the furthest used range overlapping the candidate
+		blocked := false
+		for _, r := range used {
+			if !candidateLast.Less(r.first) && !r.last.Less(addr) {
+				blocked = true
+				if candidateLast.Less(r.last) {
+					candidateLast = r.last
+				}
+			}
+		}
+		if !blocked {
+			return candidate, nil
+		}
+
+		if !candidateLast.Less(end) {
+			return netip.Prefix{}, ErrNoFreeSubnet
+		}
+		// Round up to the next boundary of the requested length
+		next := candidateLast.Next()
+		aligned := netip.PrefixFrom(next, bits).Masked()
+		if aligned.Addr() != next {
+			last := lastAddr(aligned)
+			if last == end {
+				return netip.Prefix{}, ErrNoFreeSubnet
+			}
+			next = last.Next()
+		}
+		addr = next
+	}
+}
+
+// lastAddr returns the highest address of p
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
@@ -0,0 +1,86 @@
+package store
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSubnetLength(t *testing.T) {
+	v6 := netip.MustParsePrefix("2001:db8::/48")
+	for _, bits := range []int{52, 56, 60, 64} {
+		assert.NoError(t, ValidateSubnetLength(v6, bits))
+	}
+	assert.True(t, errors.Is(ValidateSubnetLength(v6, 58), ErrNotNibbleAligned))
+	assert.True(t, errors.Is(ValidateSubnetLength(v6, 48), ErrSubnetLength))
+	assert.True(t, errors.Is(ValidateSubnetLength(v6, 132), ErrSubnetLength))
+
+	// IPv4 has no nibble requirement
+	assert.NoError(t, ValidateSubnetLength(netip.MustParsePrefix("10.0.0.0/16"), 27))
+}
+
+func TestNextSubnet(t *testing.T) {
+	now := time.Now()
+	parent := &ipam.Network{ID: "p", CIDR: "2001:db8::/48"}
+
+	t.Run("Empty", func(t *testing.T) {
+		subnet, err := NextSubnet(parent, 56, []*ipam.Network{parent}, nil, now)
+		require.NoError(t, err)
+		assert.Equal(t, "2001:db8::/56", subnet.String())
+	})
+
+	t.Run("SkipsExistingNetworks", func(t *testing.T) {
+		networks := []*ipam.Network{
+			{ID: "super", CIDR: "2001:db8::/32"},
+			parent,
+			{ID: "a", CIDR: "2001:db8::/56"},
+			{ID: "b", CIDR: "2001:db8:0:100::/64"},
+			{ID: "other", CIDR: "2001:db9::/56"},
+		}
+		subnet, err := NextSubnet(parent, 56, networks, nil, now)
+		require.NoError(t, err)
+		assert.Equal(t, "2001:db8:0:200::/56", subnet.String())
+
+		subnet, err = NextSubnet(parent, 64, networks, nil, now)
+		require.NoError(t, err)
+		assert.Equal(t, "2001:db8:0:101::/64", subnet.String())
+	})
+
+	t.Run("SkipsActiveAllocations", func(t *testing.T) {
+		allocations := []*ipam.IPAllocation{
+			{IP: "2001:db8::1"},
+			{IP: "2001:db8:0:100::1", ReleasedAt: &now},
+		}
+		subnet, err := NextSubnet(parent, 56, []*ipam.Network{parent}, allocations, now)
+		require.NoError(t, err)
+		assert.Equal(t, "2001:db8:0:100::/56", subnet.String())
+	})
+
+	t.Run("Full", func(t *testing.T) {
+		small := &ipam.Network{ID: "s", CIDR: "2001:db8::/60"}
+		networks := []*ipam.Network{small, {ID: "a", CIDR: "2001:db8::/61"}, {ID: "b", CIDR: "2001:db8:0:8::/62"}, {ID: "c", CIDR: "2001:db8:0:c::/62"}}
+		_, err := NextSubnet(small, 64, networks, nil, now)
+		assert.True(t, errors.Is(err, ErrNoFreeSubnet))
+	})
+
+	t.Run("IPv4", func(t *testing.T) {
+		v4 := &ipam.Network{ID: "v4", CIDR: "10.0.0.0/16"}
+		networks := []*ipam.Network{v4, {ID: "a", CIDR: "10.0.0.0/24"}, {ID: "b", CIDR: "10.0.2.0/24"}}
+		subnet, err := NextSubnet(v4, 24, networks, nil, now)
+		require.NoError(t, err)
+		assert.Equal(t, "10.0.1.0/24", subnet.String())
+
+		_, err = NextSubnet(v4, 15, networks, nil, now)
+		assert.True(t, errors.Is(err, ErrSubnetLength))
+	})
+
+	t.Run("NotNibbleAligned", func(t *testing.T) {
+		_, err := NextSubnet(parent, 62, nil, nil, now)
+		assert.True(t, errors.Is(err, ErrNotNibbleAligned))
+	})
+}
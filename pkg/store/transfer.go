@@ -0,0 +1,75 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+)
+
+var (
+	// ErrTransferSameNetwork is returned when an allocation is transferred to
+	// the network it already belongs to
+	ErrTransferSameNetwork = errors.New("allocation already belongs to the target network")
+
+	// ErrTransferOutOfRange is returned when the target network does not
+	// contain the allocated addresses
+	ErrTransferOutOfRange = errors.New("target network does not contain the allocated addresses")
+
+	// ErrTransferConflict is returned when the target network has an active
+	// allocation overlapping the transferred addresses
+	ErrTransferConflict = errors.New("addresses are already allocated in the target network")
+)
+
+// CheckTransfer verifies that alloc can move to target without changing its
+// addresses. targetAllocations are the target network's current
+// allocations; released and expired ones do not conflict.
+func CheckTransfer(alloc *ipam.IPAllocation, target *ipam.Network, targetAllocations []*ipam.IPAllocation, now time.Time) error {
+	if alloc.NetworkID == target.ID {
+		return ErrTransferSameNetwork
+	}
+
+	prefix, err := netip.ParsePrefix(target.CIDR)
+	if err != nil {
+		return fmt.Errorf("invalid target CIDR %q: %w", target.CIDR, err)
+	}
+	first, last, err := allocationRange(alloc)
+	if err != nil {
+		return err
+	}
+	if !prefix.Contains(first) || !prefix.Contains(last) {
+		return ErrTransferOutOfRange
+	}
+
+	for _, other := range targetAllocations {
+		if other.ReleasedAt != nil || (other.ExpiresAt != nil && other.ExpiresAt.Before(now)) {
+			continue
+		}
+		otherFirst, otherLast, err := allocationRange(other)
+		if err != nil {
+			return err
+		}
+		if !last.Less(otherFirst) && !otherLast.Less(first) {
+			return fmt.Errorf("%w: %s overlaps allocation %s", ErrTransferConflict, alloc.IP, other.ID)
+		}
+	}
+
+	return nil
+}
+
+// allocationRange returns the first and last address of alloc
+func allocationRange(alloc *ipam.IPAllocation) (netip.Addr, netip.Addr, error) {
+	first, err := netip.ParseAddr(alloc.IP)
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("allocation %s: invalid IP %q", alloc.ID, alloc.IP)
+	}
+	last := first
+	if alloc.EndIP != "" {
+		if last, err = netip.ParseAddr(alloc.EndIP); err != nil {
+			return netip.Addr{}, netip.Addr{}, fmt.Errorf("allocation %s: invalid end IP %q", alloc.ID, alloc.EndIP)
+		}
+	}
+	return first.Unmap(), last.Unmap(), nil
+}
@@ -0,0 +1,46 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckTransfer(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	target := &ipam.Network{ID: "net2", CIDR: "10.0.1.0/24"}
+	targetAllocations := []*ipam.IPAllocation{
+		{ID: "a1", NetworkID: "net2", IP: "10.0.1.10", EndIP: "10.0.1.19"},
+		{ID: "a2", NetworkID: "net2", IP: "10.0.1.30", ReleasedAt: &past},
+		{ID: "a3", NetworkID: "net2", IP: "10.0.1.40", ExpiresAt: &past},
+	}
+
+	tests := []struct {
+		name  string
+		alloc *ipam.IPAllocation
+		want  error
+	}{
+		{"fits", &ipam.IPAllocation{ID: "x", NetworkID: "net1", IP: "10.0.1.5"}, nil},
+		{"range fits", &ipam.IPAllocation{ID: "x", NetworkID: "net1", IP: "10.0.1.20", EndIP: "10.0.1.29"}, nil},
+		{"released does not conflict", &ipam.IPAllocation{ID: "x", NetworkID: "net1", IP: "10.0.1.30"}, nil},
+		{"expired does not conflict", &ipam.IPAllocation{ID: "x", NetworkID: "net1", IP: "10.0.1.40"}, nil},
+		{"same network", &ipam.IPAllocation{ID: "x", NetworkID: "net2", IP: "10.0.1.5"}, ErrTransferSameNetwork},
+		{"outside", &ipam.IPAllocation{ID: "x", NetworkID: "net1", IP: "10.0.2.5"}, ErrTransferOutOfRange},
+		{"range leaves network", &ipam.IPAllocation{ID: "x", NetworkID: "net1", IP: "10.0.1.250", EndIP: "10.0.2.3"}, ErrTransferOutOfRange},
+		{"overlap", &ipam.IPAllocation{ID: "x", NetworkID: "net1", IP: "10.0.1.5", EndIP: "10.0.1.10"}, ErrTransferConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckTransfer(tt.alloc, target, targetAllocations, now)
+			if tt.want == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package taxonomy
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// Retag replaces the tags in from with to on every network and allocation,
+// and returns the batch saving the records that changed and how many of
+// each there are. Renaming one tag is retagging from a single tag; merging
+// is retagging from several.
+//
+// The batch carries the digests of the records it was prepared from, so
+// ApplyBatch refuses it with store.ErrBatchConflict rather than undo an
+// allocation released or a network added meanwhile.
+//
+// A tag in from matches itself and, when neither it nor to has a value,
+// also the key=value tags of that key, which keep their value: retagging
+// env to environment turns env=prod into environment=prod.
+func Retag(st ipam.Store, from []string, to string, now time.Time) (*store.Batch, Usage, error) {
+	var changed Usage
+	networks, err := st.ListNetworks()
+	if err != nil {
+		return nil, changed, err
+	}
+	batch := &store.Batch{
+		NetworksDigest:    store.NetworksDigest(networks),
+		AllocationDigests: make(map[string]string, len(networks)),
+	}
+	for _, n := range networks {
+		if tags, ok := retag(n.Tags, from, to); ok {
+			updated := *n
+			updated.Tags = tags
+			updated.UpdatedAt = now
+			batch.Networks = append(batch.Networks, &updated)
+			changed.Networks++
+		}
+
+		allocations, err := st.ListAllocations(n.ID)
+		if err != nil {
+			return nil, changed, err
+		}
+		batch.AllocationDigests[n.ID] = store.AllocationsDigest(allocations)
+		for _, a := range allocations {
+			if tags, ok := retag(a.Tags, from, to); ok {
+				updated := *a
+				updated.Tags = tags
+				batch.Allocations = append(batch.Allocations, &updated)
+				changed.Allocations++
+			}
+		}
+	}
+	return batch, changed, nil
+}
+
+// retag returns tags with from replaced by to, dropping duplicates the
+// replacement creates, and whether anything was replaced
+func retag(tags, from []string, to string) ([]string, bool) {
+	toKey, _, toLabeled := strings.Cut(to, "=")
+
+	var out []string
+	replaced := false
+	for _, tag := range tags {
+		if renamed, ok := replace(tag, from, toKey, toLabeled, to); ok {
+			tag = renamed
+			replaced = true
+		}
+		if !slices.Contains(out, tag) {
+			out = append(out, tag)
+		}
+	}
+	return out, replaced
+}
+
+func replace(tag string, from []string, toKey string, toLabeled bool, to string) (string, bool) {
+	k, value, labeled := strings.Cut(tag, "=")
+	for _, f := range from {
+		switch {
+		case tag == f:
+			return to, true
+		case labeled && !toLabeled && k == f:
+			return toKey + "=" + value, true
+		}
+	}
+	return tag, false
+}
@@ -0,0 +1,280 @@
+// Package taxonomy manages the registry of tags networks and allocations
+// may carry, so freeform tags do not drift into prod, production and PROD.
+//
+// The registry is a YAML list of tags, each with a description and a
+// display color. An entry names a plain tag (pci) or a label key (env); a
+// label key covers the key=value tags of that key, limited to the entry's
+// values when it lists any. Tags the server itself interprets, such as
+// notify=<channel> or freeze=<reason>, are always allowed.
+package taxonomy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/jeremyhahn/go-ipam/pkg/approval"
+	"github.com/jeremyhahn/go-ipam/pkg/cloudinit"
+	"github.com/jeremyhahn/go-ipam/pkg/health"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/neighbor"
+	"github.com/jeremyhahn/go-ipam/pkg/neutron"
+	"github.com/jeremyhahn/go-ipam/pkg/notify"
+	"github.com/jeremyhahn/go-ipam/pkg/reclaim"
+	"github.com/jeremyhahn/go-ipam/pkg/reconcile"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/jeremyhahn/go-ipam/pkg/vmsync"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnknownTag is returned for a tag the registry does not allow
+var ErrUnknownTag = errors.New("unknown tag")
+
+// Reserved are the tags, or label keys, the server interprets. They are
+// allowed whatever the registry says.
+var Reserved = []string{
+	key(notify.ChannelTagPrefix), key(notify.ThresholdTagPrefix), key(notify.ExpiryTagPrefix),
+	key(approval.TagPrefix),
+	key(reclaim.AfterTagPrefix), key(reclaim.GraceTagPrefix), key(reclaim.MatchTagPrefix),
+	key(reclaim.PendingTagPrefix), reclaim.ExemptTag,
+	key(health.LastSeenTagPrefix), health.StaleTag, reconcile.DiscoveredTag,
+	key(neighbor.MACTagPrefix), key(neighbor.ObservedMACTagPrefix), neighbor.ConflictTag,
+	key(neutron.SubnetTagPrefix), key(neutron.NetworkTagPrefix), key(neutron.PortTagPrefix),
+	key(vmsync.VMTagPrefix), key(cloudinit.DNSTagPrefix),
+	key(store.FreezeTagPrefix), key(store.FreezeUntilTagPrefix),
+	key(store.ACLTagPrefix(store.PermissionView)), key(store.ACLTagPrefix(store.PermissionAllocate)),
+	key(store.ACLTagPrefix(store.PermissionRelease)), key(store.ACLTagPrefix(store.PermissionAdmin)),
+	key(store.GroupTagPrefix), key(store.HoldTagPrefix), store.AutoCreatedTag,
+	key(store.PointToPointTagPrefix), store.GatewayTag, key(store.ReuseDelayTagPrefix),
+	key(store.BootFileTagPrefix), key(store.NextServerTagPrefix), key(store.DHCPOptionTagPrefix),
+	key(store.PoolTagPrefix),
+	key(store.CountryTagPrefix), key(store.ASNTagPrefix),
+	"domain", "rir", "rdap",
+}
+
+// IsReserved reports whether tag, or its key, is one of Reserved
+func IsReserved(tag string) bool {
+	return slices.Contains(Reserved, key(tag))
+}
+
+// colorPattern accepts #rgb and #rrggbb colors and CSS color names
+var colorPattern = regexp.MustCompile(`^(#[0-9A-Fa-f]{3}|#[0-9A-Fa-f]{6}|[A-Za-z]+)$`)
+
+// Tag is one entry of the registry
+type Tag struct {
+	// Name is a plain tag, or a label key covering key=value tags
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description"`
+
+	// Color is how user interfaces show the tag: #rgb, #rrggbb or a CSS
+	// color name
+	Color string `json:"color,omitempty" yaml:"color"`
+
+	// Values, when set, are the only values allowed for the label key Name
+	Values []string `json:"values,omitempty" yaml:"values"`
+}
+
+// Registry is the set of allowed tags. A nil Registry allows every tag.
+type Registry struct {
+	Tags []Tag
+
+	// Enforce rejects tags the registry does not allow on new and updated
+	// records; otherwise the registry only documents them
+	Enforce bool
+}
+
+// LoadRegistry reads a registry file
+func LoadRegistry(path string) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []Tag
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&tags); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	r := &Registry{Tags: tags}
+	if err := r.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Validate checks that every entry has a unique name without "=" and a
+// valid color
+func (r *Registry) Validate() error {
+	seen := map[string]bool{}
+	for i, t := range r.Tags {
+		switch {
+		case t.Name == "" || strings.ContainsAny(t.Name, "= \t"):
+			return fmt.Errorf("tag %d: invalid name %q", i+1, t.Name)
+		case seen[t.Name]:
+			return fmt.Errorf("tag %q is listed twice", t.Name)
+		case t.Color != "" && !colorPattern.MatchString(t.Color):
+			return fmt.Errorf("tag %q: invalid color %q", t.Name, t.Color)
+		}
+		seen[t.Name] = true
+	}
+	return nil
+}
+
+// Lookup returns the entry covering tag, if any
+func (r *Registry) Lookup(tag string) (*Tag, bool) {
+	if r == nil {
+		return nil, false
+	}
+	k, value, labeled := strings.Cut(tag, "=")
+	for i := range r.Tags {
+		t := &r.Tags[i]
+		if t.Name != k {
+			continue
+		}
+		if len(t.Values) == 0 || (labeled && slices.Contains(t.Values, value)) {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// Check returns an ErrUnknownTag error when the registry is enforced and
+// does not allow tag. The error suggests a registered tag differing only in
+// case.
+func (r *Registry) Check(tag string) error {
+	if r == nil || !r.Enforce || IsReserved(tag) {
+		return nil
+	}
+	if _, ok := r.Lookup(tag); ok {
+		return nil
+	}
+
+	for _, t := range r.Tags {
+		for _, candidate := range t.names() {
+			if strings.EqualFold(candidate, tag) {
+				return fmt.Errorf("%w %q, did you mean %q?", ErrUnknownTag, tag, candidate)
+			}
+		}
+	}
+	if t, ok := r.lookupKey(key(tag)); ok && len(t.Values) > 0 {
+		return fmt.Errorf("%w %q: %s must be one of %s", ErrUnknownTag, tag, t.Name, strings.Join(t.Values, ", "))
+	}
+	return fmt.Errorf("%w %q", ErrUnknownTag, tag)
+}
+
+func (r *Registry) lookupKey(k string) (*Tag, bool) {
+	for i := range r.Tags {
+		if r.Tags[i].Name == k {
+			return &r.Tags[i], true
+		}
+	}
+	return nil, false
+}
+
+// names lists the full tags t allows by name: the plain tag, or each
+// key=value when t lists values
+func (t *Tag) names() []string {
+	if len(t.Values) == 0 {
+		return []string{t.Name}
+	}
+	names := make([]string, len(t.Values))
+	for i, v := range t.Values {
+		names[i] = t.Name + "=" + v
+	}
+	return names
+}
+
+// key returns a tag's label key: the part before "=", or the whole tag
+func key(tag string) string {
+	k, _, _ := strings.Cut(tag, "=")
+	return k
+}
+
+// Usage counts the records carrying a tag
+type Usage struct {
+	Networks    int `json:"networks"`
+	Allocations int `json:"allocations"`
+}
+
+// RegisteredUsage is a registry entry and the records it covers
+type RegisteredUsage struct {
+	Tag
+	Usage
+}
+
+// UnregisteredUsage is a tag in use that the registry does not allow
+type UnregisteredUsage struct {
+	Tag string `json:"tag"`
+	Usage
+}
+
+// Report is the registry with usage counts, and the tags in use outside it
+type Report struct {
+	Enforce      bool                `json:"enforce"`
+	Registered   []RegisteredUsage   `json:"registered"`
+	Unregistered []UnregisteredUsage `json:"unregistered"`
+}
+
+// BuildReport counts how often each tag is used on networks and
+// allocations, skipping reserved tags
+func (r *Registry) BuildReport(networks []*ipam.Network, allocations []*ipam.IPAllocation) *Report {
+	report := &Report{Registered: []RegisteredUsage{}, Unregistered: []UnregisteredUsage{}}
+	var entries []Tag
+	if r != nil {
+		report.Enforce = r.Enforce
+		entries = r.Tags
+	}
+	registered := make(map[string]*Usage, len(entries))
+	unregistered := map[string]*Usage{}
+
+	count := func(tags []string, inc func(*Usage)) {
+		seen := map[*Usage]bool{}
+		for _, tag := range tags {
+			if slices.Contains(Reserved, key(tag)) {
+				continue
+			}
+			var u *Usage
+			if t, ok := r.Lookup(tag); ok {
+				if u = registered[t.Name]; u == nil {
+					u = &Usage{}
+					registered[t.Name] = u
+				}
+			} else if u = unregistered[tag]; u == nil {
+				u = &Usage{}
+				unregistered[tag] = u
+			}
+			// A record counts once per entry, however many values it has
+			if !seen[u] {
+				seen[u] = true
+				inc(u)
+			}
+		}
+	}
+	for _, n := range networks {
+		count(n.Tags, func(u *Usage) { u.Networks++ })
+	}
+	for _, a := range allocations {
+		count(a.Tags, func(u *Usage) { u.Allocations++ })
+	}
+
+	for _, t := range entries {
+		u := RegisteredUsage{Tag: t}
+		if counted := registered[t.Name]; counted != nil {
+			u.Usage = *counted
+		}
+		report.Registered = append(report.Registered, u)
+	}
+	for tag, u := range unregistered {
+		report.Unregistered = append(report.Unregistered, UnregisteredUsage{Tag: tag, Usage: *u})
+	}
+	sort.Slice(report.Unregistered, func(i, j int) bool {
+		return report.Unregistered[i].Tag < report.Unregistered[j].Tag
+	})
+	return report
+}
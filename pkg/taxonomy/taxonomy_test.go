@@ -0,0 +1,166 @@
+package taxonomy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRegistry() *Registry {
+	return &Registry{Enforce: true, Tags: []Tag{
+		{Name: "env", Description: "Deployment environment", Color: "#0a0", Values: []string{"prod", "staging"}},
+		{Name: "site", Color: "blue"},
+		{Name: "pci", Description: "In PCI scope", Color: "#cc0000"},
+	}}
+}
+
+func TestLoadRegistry(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "tags.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	r, err := LoadRegistry(write(`
+- name: env
+  description: Deployment environment
+  color: "#00aa00"
+  values: [prod, staging]
+- name: pci
+`))
+	require.NoError(t, err)
+	require.Len(t, r.Tags, 2)
+	assert.Equal(t, []string{"prod", "staging"}, r.Tags[0].Values)
+	assert.False(t, r.Enforce)
+
+	for content, want := range map[string]string{
+		"- name: env\n- name: env\n":           "listed twice",
+		"- name: env=prod\n":                   "invalid name",
+		"- name: env\n  color: '#12345'\n":     "invalid color",
+		"- name: env\n  colour: green\n":       "colour",
+		"- name: env\n  values: prod\n":        "cannot unmarshal",
+		"- name: env\n  description: [a, b]\n": "cannot unmarshal",
+	} {
+		_, err := LoadRegistry(write(content))
+		assert.ErrorContains(t, err, want, content)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	r := testRegistry()
+	for _, tag := range []string{"env=prod", "site=ams", "site", "pci", "notify=ops", "reclaim-exempt", "freeze=change window", "acl-view=any"} {
+		assert.NoError(t, r.Check(tag), tag)
+	}
+
+	tests := map[string]string{
+		"env":         `unknown tag "env": env must be one of prod, staging`,
+		"env=dev":     `unknown tag "env=dev": env must be one of prod, staging`,
+		"env=PROD":    `unknown tag "env=PROD", did you mean "env=prod"?`,
+		"PCI":         `unknown tag "PCI", did you mean "pci"?`,
+		"production":  `unknown tag "production"`,
+		"owner=alice": `unknown tag "owner=alice"`,
+	}
+	for tag, want := range tests {
+		err := r.Check(tag)
+		assert.ErrorIs(t, err, ErrUnknownTag, tag)
+		assert.EqualError(t, err, want)
+	}
+
+	// An unenforced or missing registry allows anything
+	r.Enforce = false
+	assert.NoError(t, r.Check("production"))
+	assert.NoError(t, (*Registry)(nil).Check("production"))
+}
+
+func TestBuildReport(t *testing.T) {
+	networks := []*ipam.Network{
+		{ID: "a", Tags: []string{"env=prod", "site=ams", "notify=ops"}},
+		{ID: "b", Tags: []string{"env=prod", "prod"}},
+	}
+	allocations := []*ipam.IPAllocation{
+		{ID: "1", Tags: []string{"site=ams", "site=fra"}},
+		{ID: "2", Tags: []string{"prod", "env=dev"}},
+	}
+
+	report := testRegistry().BuildReport(networks, allocations)
+	assert.True(t, report.Enforce)
+	require.Len(t, report.Registered, 3)
+	assert.Equal(t, "env", report.Registered[0].Name)
+	assert.Equal(t, Usage{Networks: 2}, report.Registered[0].Usage)
+	assert.Equal(t, Usage{Networks: 1, Allocations: 1}, report.Registered[1].Usage)
+	assert.Equal(t, Usage{}, report.Registered[2].Usage)
+	assert.Equal(t, []UnregisteredUsage{
+		{Tag: "env=dev", Usage: Usage{Allocations: 1}},
+		{Tag: "prod", Usage: Usage{Networks: 1, Allocations: 1}},
+	}, report.Unregistered)
+
+	// Without a registry every tag in use is unregistered
+	report = (*Registry)(nil).BuildReport(networks, nil)
+	assert.Empty(t, report.Registered)
+	assert.Len(t, report.Unregistered, 3)
+}
+
+// fakeStore serves fixed records; only the list methods are used
+type fakeStore struct {
+	ipam.Store
+	networks    []*ipam.Network
+	allocations map[string][]*ipam.IPAllocation
+}
+
+func (f *fakeStore) ListNetworks() ([]*ipam.Network, error) {
+	return f.networks, nil
+}
+
+func (f *fakeStore) ListAllocations(networkID string) ([]*ipam.IPAllocation, error) {
+	return f.allocations[networkID], nil
+}
+
+func TestRetag(t *testing.T) {
+	now := time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC)
+	st := &fakeStore{
+		networks: []*ipam.Network{
+			{ID: "a", Tags: []string{"env=production", "site=ams"}},
+			{ID: "b", Tags: []string{"pci"}},
+		},
+		allocations: map[string][]*ipam.IPAllocation{
+			"a": {
+				{ID: "1", Tags: []string{"env=prd", "env=production"}},
+				{ID: "2", Tags: []string{"env=staging"}},
+			},
+		},
+	}
+
+	batch, changed, err := Retag(st, []string{"env=production", "env=prd"}, "env=prod", now)
+	require.NoError(t, err)
+	assert.Equal(t, Usage{Networks: 1, Allocations: 1}, changed)
+	require.Len(t, batch.Networks, 1)
+	assert.Equal(t, []string{"env=prod", "site=ams"}, batch.Networks[0].Tags)
+	assert.Equal(t, now, batch.Networks[0].UpdatedAt)
+	require.Len(t, batch.Allocations, 1)
+	assert.Equal(t, []string{"env=prod"}, batch.Allocations[0].Tags, "merged duplicates are dropped")
+
+	assert.NotEmpty(t, batch.NetworksDigest)
+	assert.Len(t, batch.AllocationDigests, 2, "the batch is checked against every network's allocations")
+
+	// The listed records are not modified; the batch holds copies
+	assert.Equal(t, []string{"env=production", "site=ams"}, st.networks[0].Tags)
+
+	// Renaming a label key keeps the values
+	batch, changed, err = Retag(st, []string{"env"}, "environment", now)
+	require.NoError(t, err)
+	assert.Equal(t, Usage{Networks: 1, Allocations: 2}, changed)
+	assert.Equal(t, []string{"environment=production", "site=ams"}, batch.Networks[0].Tags)
+	assert.Equal(t, []string{"environment=staging"}, batch.Allocations[1].Tags)
+
+	// A plain tag
+	batch, changed, err = Retag(st, []string{"pci"}, "compliance=pci", now)
+	require.NoError(t, err)
+	assert.Equal(t, Usage{Networks: 1}, changed)
+	assert.Equal(t, []string{"compliance=pci"}, batch.Networks[0].Tags)
+}
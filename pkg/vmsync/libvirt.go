@@ -0,0 +1,133 @@
+package vmsync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strings"
+)
+
+// Address sources of virsh domifaddr
+const (
+	SourceLease = "lease"
+	SourceAgent = "agent"
+	SourceARP   = "arp"
+)
+
+// Libvirt reads the domains of a libvirt host with virsh
+type Libvirt struct {
+	// URI is the connection URI, e.g. qemu:///system or
+	// qemu+ssh://root@kvm1/system; virsh's default when empty
+	URI string
+
+	// Source is where virsh domifaddr looks for addresses: lease (the
+	// default), agent or arp
+	Source string
+
+	// Run runs virsh with args; exec when nil
+	Run func(ctx context.Context, args ...string) ([]byte, error)
+}
+
+// VMs returns the host's domains and, for running ones, the addresses
+// domifaddr finds. A running domain it finds nothing for, e.g. one on a
+// bridge without DHCP leases, is not Reported.
+func (l *Libvirt) VMs(ctx context.Context) ([]VM, error) {
+	out, err := l.virsh(ctx, "list", "--all")
+	if err != nil {
+		return nil, err
+	}
+
+	var vms []VM
+	for _, fields := range tableRows(out) {
+		if len(fields) < 3 {
+			continue
+		}
+		vm := VM{ID: fields[1], Name: fields[1]}
+		if strings.Join(fields[2:], " ") == "running" {
+			source := l.Source
+			if source == "" {
+				source = SourceLease
+			}
+			out, err := l.virsh(ctx, "domifaddr", vm.ID, "--source", source)
+			if err != nil {
+				return nil, err
+			}
+			vm.NICs = parseDomifaddr(out)
+			vm.Reported = len(vm.NICs) > 0
+		}
+		vms = append(vms, vm)
+	}
+	return vms, nil
+}
+
+// virsh runs virsh against the host
+func (l *Libvirt) virsh(ctx context.Context, args ...string) ([]byte, error) {
+	if l.URI != "" {
+		args = append([]string{"--connect", l.URI}, args...)
+	}
+	run := l.Run
+	if run == nil {
+		run = func(ctx context.Context, args ...string) ([]byte, error) {
+			var stderr bytes.Buffer
+			cmd := exec.CommandContext(ctx, "virsh", args...)
+			cmd.Stderr = &stderr
+			out, err := cmd.Output()
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+			}
+			return out, nil
+		}
+	}
+	out, err := run(ctx, args...)
+	if err != nil {
+		return nil, fmt.Errorf("virsh %s failed: %w", strings.Join(args, " "), err)
+	}
+	return out, nil
+}
+
+// parseDomifaddr parses virsh domifaddr output, where the further
+// addresses of an interface have "-" for its name and MAC:
+//
+//	Name       MAC address          Protocol     Address
+//	-------------------------------------------------------------
+//	vnet0      52:54:00:12:34:56    ipv4         192.168.122.10/24
+//	-          -                    ipv6         fd00::10/64
+func parseDomifaddr(out []byte) []NIC {
+	var nics []NIC
+	for _, fields := range tableRows(out) {
+		if len(fields) < 4 {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(fields[3])
+		if err != nil {
+			continue
+		}
+		if fields[0] != "-" || len(nics) == 0 {
+			nics = append(nics, NIC{Name: fields[0], MAC: fields[1]})
+		}
+		nic := &nics[len(nics)-1]
+		nic.Addrs = append(nic.Addrs, prefix.Addr())
+	}
+	return nics
+}
+
+// tableRows returns the fields of the rows of a virsh table, below its
+// dashed rule
+func tableRows(out []byte) [][]string {
+	var rows [][]string
+	body := false
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "---"):
+			body = true
+		case body && line != "":
+			rows = append(rows, strings.Fields(line))
+		}
+	}
+	return rows
+}
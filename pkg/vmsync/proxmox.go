@@ -0,0 +1,172 @@
+package vmsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Proxmox reads the VMs and containers of a Proxmox VE cluster
+type Proxmox struct {
+	// URL is the API endpoint of any node, e.g. https://pve1:8006
+	URL string
+
+	// Token is an API token, USER@REALM!TOKENID=SECRET. It needs
+	// VM.Audit, and VM.Monitor for the guest agent.
+	Token string
+
+	HTTPClient *http.Client
+}
+
+// NewProxmox returns a reader for the cluster at url with a request timeout
+func NewProxmox(url, token string) *Proxmox {
+	return &Proxmox{URL: url, Token: token, HTTPClient: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// proxmoxResource is a VM or container of /cluster/resources
+type proxmoxResource struct {
+	Type     string `json:"type"`
+	VMID     int    `json:"vmid"`
+	Name     string `json:"name"`
+	Node     string `json:"node"`
+	Status   string `json:"status"`
+	Template int    `json:"template"`
+}
+
+// VMs returns the cluster's VMs and containers. The addresses of a QEMU
+// VM come from its guest agent, those of a container from the host; a
+// stopped guest, or a VM whose agent does not answer, is not Reported.
+func (p *Proxmox) VMs(ctx context.Context) ([]VM, error) {
+	var resources []proxmoxResource
+	if err := p.get(ctx, "/cluster/resources?type=vm", &resources); err != nil {
+		return nil, fmt.Errorf("failed to list VMs: %w", err)
+	}
+	sort.Slice(resources, func(i, j int) bool { return resources[i].VMID < resources[j].VMID })
+
+	var vms []VM
+	for _, r := range resources {
+		if r.Template == 1 || (r.Type != "qemu" && r.Type != "lxc") {
+			continue
+		}
+		vm := VM{ID: strconv.Itoa(r.VMID), Name: r.Name, Node: r.Node}
+		if r.Status == "running" {
+			var err error
+			if r.Type == "qemu" {
+				vm.NICs, err = p.agentInterfaces(ctx, r)
+			} else {
+				vm.NICs, err = p.containerInterfaces(ctx, r)
+			}
+			if err != nil {
+				return nil, err
+			}
+			vm.Reported = vm.NICs != nil
+		}
+		vms = append(vms, vm)
+	}
+	return vms, nil
+}
+
+// agentInterfaces returns the interfaces the guest agent of a VM reports,
+// or nil when the agent is not running
+func (p *Proxmox) agentInterfaces(ctx context.Context, r proxmoxResource) ([]NIC, error) {
+	var reply struct {
+		Result []struct {
+			Name        string `json:"name"`
+			MAC         string `json:"hardware-address"`
+			IPAddresses []struct {
+				Address string `json:"ip-address"`
+			} `json:"ip-addresses"`
+		} `json:"result"`
+	}
+	path := fmt.Sprintf("/nodes/%s/qemu/%d/agent/network-get-interfaces", url.PathEscape(r.Node), r.VMID)
+	if err := p.get(ctx, path, &reply); err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		// Proxmox answers 500 for a VM without a running agent
+		return nil, nil
+	}
+
+	nics := []NIC{}
+	for _, iface := range reply.Result {
+		nic := NIC{Name: iface.Name, MAC: iface.MAC}
+		for _, ip := range iface.IPAddresses {
+			if addr, err := netip.ParseAddr(ip.Address); err == nil {
+				nic.Addrs = append(nic.Addrs, addr)
+			}
+		}
+		nics = append(nics, nic)
+	}
+	return nics, nil
+}
+
+// containerInterfaces returns the interfaces of a running container
+func (p *Proxmox) containerInterfaces(ctx context.Context, r proxmoxResource) ([]NIC, error) {
+	var reply []struct {
+		Name  string `json:"name"`
+		MAC   string `json:"hwaddr"`
+		Inet  string `json:"inet"`
+		Inet6 string `json:"inet6"`
+	}
+	path := fmt.Sprintf("/nodes/%s/lxc/%d/interfaces", url.PathEscape(r.Node), r.VMID)
+	if err := p.get(ctx, path, &reply); err != nil {
+		return nil, fmt.Errorf("failed to read interfaces of container %d: %w", r.VMID, err)
+	}
+
+	nics := []NIC{}
+	for _, iface := range reply {
+		nic := NIC{Name: iface.Name, MAC: iface.MAC}
+		for _, field := range []string{iface.Inet, iface.Inet6} {
+			for _, s := range strings.Fields(strings.ReplaceAll(field, ",", " ")) {
+				if prefix, err := netip.ParsePrefix(s); err == nil {
+					nic.Addrs = append(nic.Addrs, prefix.Addr())
+				}
+			}
+		}
+		nics = append(nics, nic)
+	}
+	return nics, nil
+}
+
+// get decodes the data of a successful API response into v
+func (p *Proxmox) get(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.URL, "/")+"/api2/json"+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "PVEAPIToken="+p.Token)
+	req.Header.Set("Accept", "application/json")
+
+	httpClient := p.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("GET %s returned %s", req.URL.Path, resp.Status)
+	}
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<20)).Decode(&envelope); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	if err := json.Unmarshal(envelope.Data, v); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	return nil
+}
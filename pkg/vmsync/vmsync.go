@@ -0,0 +1,370 @@
+// Package vmsync reconciles the addresses hypervisors report for their
+// virtual machines against allocations: addresses without an allocation
+// get one, and allocations of VMs that no longer hold their address are
+// flagged stale.
+//
+// Proxmox VE is read over its REST API, from the QEMU guest agent of VMs
+// and the interfaces of containers; libvirt is read with virsh domifaddr.
+package vmsync
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/health"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/neighbor"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+)
+
+// VMTagPrefix marks the allocations Sync manages with the VM holding
+// them: vm=<hypervisor>/<id>
+const VMTagPrefix = "vm="
+
+// auditUser is the user recorded for the audit entries of Sync's changes
+const auditUser = "vmsync"
+
+// VM is a virtual machine or container and the addresses it reports
+type VM struct {
+	// ID identifies the VM on its hypervisor, e.g. a Proxmox VMID or a
+	// libvirt domain name
+	ID   string
+	Name string
+	Node string
+
+	// Reported is false when the VM's addresses are unknown, e.g. it is
+	// stopped or has no guest agent; its allocations are then left alone
+	Reported bool
+	NICs     []NIC
+}
+
+// NIC is a network interface of a VM
+type NIC struct {
+	Name  string
+	MAC   string
+	Addrs []netip.Addr
+}
+
+// Result is the outcome of a sync
+type Result struct {
+	VMs int `json:"vms"`
+
+	// Matched counts the addresses an allocation holds already
+	Matched int `json:"matched"`
+
+	Created []Change `json:"created"`
+	Stale   []Change `json:"stale"`
+
+	// Unmanaged lists addresses outside every network
+	Unmanaged []string `json:"unmanaged"`
+
+	// Skipped lists addresses no allocation may hold, such as a network's
+	// broadcast address
+	Skipped []string `json:"skipped"`
+
+	// Frozen lists addresses left unallocated, and allocations left
+	// unflagged, because their network is under a maintenance freeze
+	Frozen []string `json:"frozen"`
+}
+
+// Change is an allocation Sync created or flagged
+type Change struct {
+	IP           string `json:"ip"`
+	AllocationID string `json:"allocation_id,omitempty"`
+	VM           string `json:"vm"`
+	Hostname     string `json:"hostname,omitempty"`
+}
+
+// Syncer reconciles the VMs of one hypervisor against Store
+type Syncer struct {
+	Store ipam.Store
+
+	// Hypervisor names the hypervisor in vm= tags, so that allocations of
+	// other hypervisors are never flagged
+	Hypervisor string
+
+	// DryRun reports the changes without making them
+	DryRun bool
+}
+
+// address is an address a VM reports
+type address struct {
+	vm   *VM
+	mac  string
+	addr netip.Addr
+}
+
+// Sync reconciles the addresses of vms. Link-local, loopback and multicast
+// addresses are ignored. An address an active allocation holds has its
+// last-seen tag updated, and, when the allocation is one Sync created,
+// its hostname and mac= tag too. An address inside a network but without
+// an allocation gets one in the most specific network, tagged
+// vm=<hypervisor>/<id>, mac=<MAC> and last-seen.
+//
+// An allocation tagged with one of this hypervisor's VMs is tagged stale
+// when the VM is gone or reports addresses without its own, which health
+// reports and reclamation then pick up. Allocations are never released.
+//
+// Networks under a maintenance freeze get no new allocations and no stale
+// flags; their addresses are listed in the result's Frozen instead. Every
+// allocation created or flagged is audited.
+func (s *Syncer) Sync(vms []VM, now time.Time) (*Result, error) {
+	networks, err := s.Store.ListNetworks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+	sort.Slice(networks, func(i, j int) bool { return prefixBits(networks[i]) > prefixBits(networks[j]) })
+
+	result := &Result{VMs: len(vms), Created: []Change{}, Stale: []Change{}, Unmanaged: []string{}, Skipped: []string{}, Frozen: []string{}}
+	byID := make(map[string]*VM)
+	var addresses []address
+	for i := range vms {
+		vm := &vms[i]
+		byID[s.vmTag(vm.ID)] = vm
+		for _, nic := range vm.NICs {
+			mac, _ := neighbor.NormalizeMAC(nic.MAC)
+			for _, addr := range nic.Addrs {
+				addr = addr.Unmap()
+				if addr.IsLinkLocalUnicast() || addr.IsLoopback() || addr.IsMulticast() || addr.IsUnspecified() {
+					continue
+				}
+				addresses = append(addresses, address{vm: vm, mac: mac, addr: addr})
+			}
+		}
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i].addr.Less(addresses[j].addr) })
+
+	allocations := make(map[string][]*ipam.IPAllocation)
+	list := func(network *ipam.Network) ([]*ipam.IPAllocation, error) {
+		if _, ok := allocations[network.ID]; !ok {
+			all, err := s.Store.ListAllocations(network.ID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list allocations: %w", err)
+			}
+			allocations[network.ID] = all
+		}
+		return allocations[network.ID], nil
+	}
+
+	held := make(map[string]bool)
+	for _, a := range addresses {
+		ip := a.addr.String()
+		var network *ipam.Network
+		var alloc *ipam.IPAllocation
+		for _, candidate := range networks {
+			prefix, err := netip.ParsePrefix(candidate.CIDR)
+			if err != nil || !prefix.Masked().Contains(a.addr) {
+				continue
+			}
+			if network == nil {
+				network = candidate
+			}
+			all, err := list(candidate)
+			if err != nil {
+				return result, err
+			}
+			if alloc = covering(all, a.addr, now); alloc != nil {
+				break
+			}
+		}
+		if network == nil {
+			result.Unmanaged = append(result.Unmanaged, ip)
+			continue
+		}
+
+		if alloc != nil {
+			result.Matched++
+			held[alloc.ID] = true
+			if err := s.update(alloc, a, now); err != nil {
+				return result, err
+			}
+			continue
+		}
+
+		if store.CheckNotFrozen(network, now) != nil {
+			result.Frozen = append(result.Frozen, ip)
+			continue
+		}
+		all, err := list(network)
+		if err != nil {
+			return result, err
+		}
+		ip, err = store.CheckRequestedIP(network, all, ip, now)
+		if errors.Is(err, store.ErrIPOutOfRange) {
+			result.Skipped = append(result.Skipped, a.addr.String())
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		id, err := store.NewAllocationID()
+		if err != nil {
+			return result, err
+		}
+		alloc = &ipam.IPAllocation{
+			ID:          id,
+			NetworkID:   network.ID,
+			IP:          ip,
+			Hostname:    a.vm.Name,
+			Description: "Virtual machine " + a.vm.ID,
+			Status:      "allocated",
+			AllocatedAt: now,
+		}
+		if a.vm.Node != "" {
+			alloc.Description += " on " + a.vm.Node
+		}
+		alloc.Tags = s.vmTags(alloc, a)
+		alloc.Tags = health.Seen(alloc, now)
+		allocations[network.ID] = append(all, alloc)
+		held[alloc.ID] = true
+		result.Created = append(result.Created, Change{IP: ip, AllocationID: alloc.ID, VM: a.vm.ID, Hostname: alloc.Hostname})
+		if s.DryRun {
+			continue
+		}
+		if err := s.Store.SaveAllocation(alloc); err != nil {
+			return result, fmt.Errorf("failed to save allocation for %s: %w", ip, err)
+		}
+		if err := store.RecordAudit(s.Store, auditUser, "allocation_discovered", alloc.ID, fmt.Sprintf("Recorded IP %s of virtual machine %s", ip, s.vmTag(a.vm.ID)), now); err != nil {
+			return result, err
+		}
+	}
+
+	// Flag the allocations of this hypervisor's VMs that were not held
+	for _, network := range networks {
+		all, err := list(network)
+		if err != nil {
+			return result, err
+		}
+		for _, alloc := range all {
+			tag, ok := vmTagOf(alloc)
+			if !ok || !strings.HasPrefix(tag, s.vmTag("")) || held[alloc.ID] ||
+				store.AllocationStatus(alloc, now) != store.StatusActive || health.IsStale(alloc) {
+				continue
+			}
+			if vm, ok := byID[tag]; ok && !vm.Reported {
+				continue
+			}
+			if store.CheckNotFrozen(network, now) != nil {
+				result.Frozen = append(result.Frozen, alloc.IP)
+				continue
+			}
+			result.Stale = append(result.Stale, Change{IP: alloc.IP, AllocationID: alloc.ID, VM: strings.TrimPrefix(tag, s.vmTag("")), Hostname: alloc.Hostname})
+			if s.DryRun {
+				continue
+			}
+			alloc.Tags = append(slices.Clone(alloc.Tags), health.StaleTag)
+			if err := s.Store.SaveAllocation(alloc); err != nil {
+				return result, fmt.Errorf("failed to save allocation %s: %w", alloc.ID, err)
+			}
+			if err := store.RecordAudit(s.Store, auditUser, "allocation_flagged_stale", alloc.ID, fmt.Sprintf("Flagged IP %s stale: %s no longer reports it", alloc.IP, tag), now); err != nil {
+				return result, err
+			}
+		}
+	}
+	sort.Slice(result.Stale, func(i, j int) bool { return result.Stale[i].IP < result.Stale[j].IP })
+	return result, nil
+}
+
+// update marks alloc seen and, when Sync created it, brings its hostname
+// and tags in line with the VM now holding its address
+func (s *Syncer) update(alloc *ipam.IPAllocation, a address, now time.Time) error {
+	if alloc.EndIP != "" {
+		return nil
+	}
+	updated := *alloc
+	if tag, ok := vmTagOf(alloc); ok && strings.HasPrefix(tag, s.vmTag("")) {
+		updated.Tags = s.vmTags(alloc, a)
+		if a.vm.Name != "" {
+			updated.Hostname = a.vm.Name
+		}
+	}
+	updated.Tags = health.Seen(&updated, now)
+	if updated.Hostname == alloc.Hostname && slices.Equal(updated.Tags, alloc.Tags) {
+		return nil
+	}
+	if s.DryRun {
+		return nil
+	}
+	if err := s.Store.SaveAllocation(&updated); err != nil {
+		return fmt.Errorf("failed to save allocation %s: %w", alloc.ID, err)
+	}
+	*alloc = updated
+	return nil
+}
+
+// vmTags returns alloc's tags with the vm= and mac= tags of a
+func (s *Syncer) vmTags(alloc *ipam.IPAllocation, a address) []string {
+	tags := make([]string, 0, len(alloc.Tags)+2)
+	for _, tag := range alloc.Tags {
+		if !strings.HasPrefix(tag, VMTagPrefix) && !strings.HasPrefix(tag, neighbor.MACTagPrefix) {
+			tags = append(tags, tag)
+		}
+	}
+	tags = append(tags, s.vmTag(a.vm.ID))
+	if a.mac != "" {
+		tags = append(tags, neighbor.MACTagPrefix+a.mac)
+	}
+	return tags
+}
+
+// vmTag returns the vm= tag of the VM id on this hypervisor
+func (s *Syncer) vmTag(id string) string {
+	return VMTagPrefix + tagSafe(s.Hypervisor) + "/" + tagSafe(id)
+}
+
+// vmTagOf returns the vm= tag of alloc
+func vmTagOf(alloc *ipam.IPAllocation) (string, bool) {
+	for _, tag := range alloc.Tags {
+		if strings.HasPrefix(tag, VMTagPrefix) {
+			return tag, true
+		}
+	}
+	return "", false
+}
+
+// tagSafe replaces the characters tags do not allow with hyphens
+func tagSafe(s string) string {
+	return strings.Map(func(c rune) rune {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("._:-", c) {
+			return c
+		}
+		return '-'
+	}, s)
+}
+
+// covering returns the active allocation holding addr, if any
+func covering(allocations []*ipam.IPAllocation, addr netip.Addr, now time.Time) *ipam.IPAllocation {
+	for _, alloc := range allocations {
+		if store.AllocationStatus(alloc, now) != store.StatusActive {
+			continue
+		}
+		first, err := netip.ParseAddr(alloc.IP)
+		if err != nil {
+			continue
+		}
+		last := first
+		if alloc.EndIP != "" {
+			if last, err = netip.ParseAddr(alloc.EndIP); err != nil {
+				continue
+			}
+		}
+		if !addr.Less(first.Unmap()) && !last.Unmap().Less(addr) {
+			return alloc
+		}
+	}
+	return nil
+}
+
+// prefixBits returns the prefix length of network, or -1 when invalid
+func prefixBits(network *ipam.Network) int {
+	prefix, err := netip.ParsePrefix(network.CIDR)
+	if err != nil {
+		return -1
+	}
+	return prefix.Bits()
+}
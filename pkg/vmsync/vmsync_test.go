@@ -0,0 +1,231 @@
+package vmsync
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/health"
+	"github.com/jeremyhahn/go-ipam/pkg/ipam"
+	"github.com/jeremyhahn/go-ipam/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func addrs(s ...string) []netip.Addr {
+	var out []netip.Addr
+	for _, a := range s {
+		out = append(out, netip.MustParseAddr(a))
+	}
+	return out
+}
+
+func TestProxmox(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "PVEAPIToken=ipam@pve!sync=s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api2/json/cluster/resources":
+			fmt.Fprint(w, `{"data": [
+				{"type": "qemu", "vmid": 101, "name": "web01", "node": "pve1", "status": "running", "template": 0},
+				{"type": "qemu", "vmid": 102, "name": "noagent", "node": "pve1", "status": "running"},
+				{"type": "qemu", "vmid": 900, "name": "tmpl", "node": "pve1", "status": "stopped", "template": 1},
+				{"type": "lxc", "vmid": 200, "name": "dns", "node": "pve2", "status": "running"},
+				{"type": "lxc", "vmid": 201, "name": "off", "node": "pve2", "status": "stopped"}
+			]}`)
+		case "/api2/json/nodes/pve1/qemu/101/agent/network-get-interfaces":
+			fmt.Fprint(w, `{"data": {"result": [
+				{"name": "lo", "hardware-address": "00:00:00:00:00:00", "ip-addresses": [{"ip-address": "127.0.0.1", "ip-address-type": "ipv4", "prefix": 8}]},
+				{"name": "eth0", "hardware-address": "bc:24:11:00:00:01", "ip-addresses": [{"ip-address": "192.168.1.10", "ip-address-type": "ipv4", "prefix": 24}]}
+			]}}`)
+		case "/api2/json/nodes/pve1/qemu/102/agent/network-get-interfaces":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/api2/json/nodes/pve2/lxc/200/interfaces":
+			fmt.Fprint(w, `{"data": [{"name": "eth0", "hwaddr": "bc:24:11:00:00:02", "inet": "192.168.1.53/24", "inet6": "fd00::53/64"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	vms, err := NewProxmox(server.URL, "ipam@pve!sync=s3cret").VMs(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []VM{
+		{ID: "101", Name: "web01", Node: "pve1", Reported: true, NICs: []NIC{
+			{Name: "lo", MAC: "00:00:00:00:00:00", Addrs: addrs("127.0.0.1")},
+			{Name: "eth0", MAC: "bc:24:11:00:00:01", Addrs: addrs("192.168.1.10")},
+		}},
+		{ID: "102", Name: "noagent", Node: "pve1"},
+		{ID: "200", Name: "dns", Node: "pve2", Reported: true, NICs: []NIC{
+			{Name: "eth0", MAC: "bc:24:11:00:00:02", Addrs: addrs("192.168.1.53", "fd00::53")},
+		}},
+		{ID: "201", Name: "off", Node: "pve2"},
+	}, vms)
+
+	_, err = NewProxmox(server.URL, "wrong").VMs(context.Background())
+	assert.ErrorContains(t, err, "401 Unauthorized")
+}
+
+func TestLibvirt(t *testing.T) {
+	outputs := map[string]string{
+		"--connect qemu:///system list --all": " Id   Name     State\n" +
+			"-------------------------\n" +
+			" 1    web01    running\n" +
+			" 2    bridged  running\n" +
+			" -    old      shut off\n",
+		"--connect qemu:///system domifaddr web01 --source agent": " Name       MAC address          Protocol     Address\n" +
+			"-------------------------------------------------------------------------------\n" +
+			" vnet0      52:54:00:12:34:56    ipv4         192.168.122.10/24\n" +
+			" -          -                    ipv6         fd00::10/64\n" +
+			" vnet1      52:54:00:12:34:57    ipv4         10.0.0.10/24\n",
+		"--connect qemu:///system domifaddr bridged --source agent": " Name       MAC address          Protocol     Address\n" +
+			"-------------------------------------------------------------------------------\n\n",
+	}
+	l := &Libvirt{URI: "qemu:///system", Source: SourceAgent, Run: func(ctx context.Context, args ...string) ([]byte, error) {
+		out, ok := outputs[strings.Join(args, " ")]
+		if !ok {
+			return nil, fmt.Errorf("unexpected command %v", args)
+		}
+		return []byte(out), nil
+	}}
+	vms, err := l.VMs(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []VM{
+		{ID: "web01", Name: "web01", Reported: true, NICs: []NIC{
+			{Name: "vnet0", MAC: "52:54:00:12:34:56", Addrs: addrs("192.168.122.10", "fd00::10")},
+			{Name: "vnet1", MAC: "52:54:00:12:34:57", Addrs: addrs("10.0.0.10")},
+		}},
+		{ID: "bridged", Name: "bridged"},
+		{ID: "old", Name: "old"},
+	}, vms)
+
+	l.Source = SourceLease
+	_, err = l.VMs(context.Background())
+	assert.ErrorContains(t, err, "virsh --connect qemu:///system domifaddr web01 --source lease failed")
+}
+
+func TestSync(t *testing.T) {
+	s, err := store.NewPebbleStore(t.TempDir())
+	require.NoError(t, err)
+	defer s.Close()
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	seen := health.LastSeenTagPrefix + now.Format(time.RFC3339)
+
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "lan", CIDR: "192.168.1.0/24"}))
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "servers", CIDR: "192.168.1.0/26"}))
+	for _, alloc := range []*ipam.IPAllocation{
+		{ID: "manual", NetworkID: "lan", IP: "192.168.1.100", Hostname: "printer", Tags: []string{"owner=ops"}},
+		{ID: "pool", NetworkID: "lan", IP: "192.168.1.200", EndIP: "192.168.1.250"},
+		{ID: "gone", NetworkID: "servers", IP: "192.168.1.20", Tags: []string{"vm=pve/150"}},
+		{ID: "off", NetworkID: "servers", IP: "192.168.1.21", Tags: []string{"vm=pve/201"}},
+		{ID: "other", NetworkID: "servers", IP: "192.168.1.22", Tags: []string{"vm=kvm1/db"}},
+	} {
+		require.NoError(t, s.SaveAllocation(alloc))
+	}
+
+	vms := []VM{
+		{ID: "101", Name: "web01", Node: "pve1", Reported: true, NICs: []NIC{
+			{Name: "lo", Addrs: addrs("127.0.0.1", "::1")},
+			{Name: "eth0", MAC: "BC-24-11-00-00-01", Addrs: addrs("192.168.1.10", "fe80::1", "192.168.1.100")},
+		}},
+		{ID: "102", Name: "app", Reported: true, NICs: []NIC{{Addrs: addrs("192.168.1.210", "192.168.1.63", "10.99.0.1")}}},
+		{ID: "201", Name: "off"},
+	}
+	syncer := &Syncer{Store: s, Hypervisor: "pve", DryRun: true}
+	result, err := syncer.Sync(vms, now)
+	require.NoError(t, err)
+	assert.Len(t, result.Created, 1)
+	assert.Len(t, result.Stale, 1)
+	allocations, err := s.ListAllocations("servers")
+	require.NoError(t, err)
+	assert.Len(t, allocations, 3)
+
+	syncer.DryRun = false
+	result, err = syncer.Sync(vms, now)
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.VMs)
+	assert.Equal(t, 2, result.Matched)
+	// The most specific network gets the allocation
+	require.Len(t, result.Created, 1)
+	assert.Equal(t, Change{IP: "192.168.1.10", AllocationID: result.Created[0].AllocationID, VM: "101", Hostname: "web01"}, result.Created[0])
+	// VM 201 is stopped, and kvm1's VMs are not this hypervisor's
+	assert.Equal(t, []Change{{IP: "192.168.1.20", AllocationID: "gone", VM: "150"}}, result.Stale)
+	assert.Equal(t, []string{"10.99.0.1"}, result.Unmanaged)
+	assert.Equal(t, []string{"192.168.1.63"}, result.Skipped)
+	assert.Empty(t, result.Frozen)
+
+	// Both changes are audited, and the dry run left no entries
+	entries, err := s.ListAuditEntries(0)
+	require.NoError(t, err)
+	var audited []string
+	for _, entry := range entries {
+		audited = append(audited, entry.Action+" "+entry.Resource+" by "+entry.User)
+	}
+	assert.ElementsMatch(t, []string{
+		"allocation_discovered " + result.Created[0].AllocationID + " by vmsync",
+		"allocation_flagged_stale gone by vmsync",
+	}, audited)
+
+	created, err := s.GetAllocation(result.Created[0].AllocationID)
+	require.NoError(t, err)
+	assert.Equal(t, "servers", created.NetworkID)
+	assert.Equal(t, "Virtual machine 101 on pve1", created.Description)
+	assert.Equal(t, []string{"vm=pve/101", "mac=bc:24:11:00:00:01", seen}, created.Tags)
+
+	// Allocations made by hand are only marked seen
+	manual, err := s.GetAllocation("manual")
+	require.NoError(t, err)
+	assert.Equal(t, "printer", manual.Hostname)
+	assert.Equal(t, []string{"owner=ops", seen}, manual.Tags)
+	gone, err := s.GetAllocation("gone")
+	require.NoError(t, err)
+	assert.True(t, health.IsStale(gone))
+
+	// A VM taking its address back clears the flag; a renamed VM renames
+	// its allocation
+	vms = append(vms, VM{ID: "150", Name: "back", Reported: true, NICs: []NIC{{Addrs: addrs("192.168.1.20")}}})
+	vms[0].Name = "web02"
+	result, err = syncer.Sync(vms, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, result.Created)
+	assert.Empty(t, result.Stale)
+	gone, err = s.GetAllocation("gone")
+	require.NoError(t, err)
+	assert.False(t, health.IsStale(gone))
+	assert.Equal(t, "back", gone.Hostname)
+	created, err = s.GetAllocation(created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "web02", created.Hostname)
+}
+
+func TestSyncFrozen(t *testing.T) {
+	s, err := store.NewPebbleStore(t.TempDir())
+	require.NoError(t, err)
+	defer s.Close()
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	require.NoError(t, s.SaveNetwork(&ipam.Network{ID: "lan", CIDR: "192.168.1.0/24", Tags: store.FreezeTags(nil, "change window", nil)}))
+	require.NoError(t, s.SaveAllocation(&ipam.IPAllocation{ID: "gone", NetworkID: "lan", IP: "192.168.1.20", Tags: []string{"vm=pve/150"}}))
+
+	vms := []VM{{ID: "101", Name: "web01", Reported: true, NICs: []NIC{{Addrs: addrs("192.168.1.10")}}}}
+	result, err := (&Syncer{Store: s, Hypervisor: "pve"}).Sync(vms, now)
+	require.NoError(t, err)
+	assert.Empty(t, result.Created)
+	assert.Empty(t, result.Stale)
+	assert.Equal(t, []string{"192.168.1.10", "192.168.1.20"}, result.Frozen)
+
+	allocations, err := s.ListAllocations("lan")
+	require.NoError(t, err)
+	require.Len(t, allocations, 1)
+	assert.False(t, health.IsStale(allocations[0]))
+	entries, err := s.ListAuditEntries(0)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,240 @@
+// Package webhook signs the requests the server sends to webhooks, and
+// verifies them on the receiving end, so that a receiver can trust that an
+// event came from a server holding the shared secret and is not a replay.
+//
+// A signed request carries three headers:
+//
+//	X-IPAM-Webhook-ID         unique ID of the delivery
+//	X-IPAM-Webhook-Timestamp  Unix time the request was signed at
+//	X-IPAM-Webhook-Signature  v1=<hex HMAC-SHA256 of "<id>.<timestamp>.<body>">
+//
+// Receivers call Verify, which rejects requests signed with another secret,
+// signed longer ago than the tolerance, or, given Replays, delivered twice.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/clock"
+)
+
+// Headers of a signed request
+const (
+	IDHeader        = "X-IPAM-Webhook-ID"
+	TimestampHeader = "X-IPAM-Webhook-Timestamp"
+	SignatureHeader = "X-IPAM-Webhook-Signature"
+)
+
+// signatureVersion prefixes signatures, so that the scheme can change
+// without receivers mistaking one signature for another
+const signatureVersion = "v1="
+
+// DefaultTolerance is how far the timestamp of a request may be from the
+// receiver's clock when Verifier.Tolerance is zero
+const DefaultTolerance = 5 * time.Minute
+
+// MaxBodySize bounds the body Verify reads
+const MaxBodySize = 1 << 20
+
+var (
+	// ErrMissingSignature is returned for a request without the headers
+	// of a signed one
+	ErrMissingSignature = errors.New("webhook request is not signed")
+	// ErrInvalidSignature is returned when no signature of a request
+	// matches its body
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrStale is returned for a request signed outside the tolerance
+	ErrStale = errors.New("webhook timestamp outside tolerance")
+	// ErrReplayed is returned for a delivery ID seen before
+	ErrReplayed = errors.New("webhook delivery replayed")
+)
+
+// Signature returns the signature of a delivery: the version prefix and
+// the hex HMAC-SHA256 of "<id>.<timestamp>.<body>" under secret
+func Signature(secret []byte, id string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s.%d.", id, timestamp)
+	mac.Write(body)
+	return signatureVersion + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Signer signs outgoing webhook requests. A nil Signer leaves requests
+// unsigned.
+type Signer struct {
+	Secret []byte
+	Clock  clock.Clock
+}
+
+// NewSigner returns a Signer using secret, or nil when secret is empty
+func NewSigner(secret []byte) *Signer {
+	if len(secret) == 0 {
+		return nil
+	}
+	return &Signer{Secret: secret, Clock: clock.System}
+}
+
+// Sign sets the delivery ID, timestamp and signature headers of req, whose
+// body is body
+func (s *Signer) Sign(req *http.Request, body []byte) error {
+	if s == nil {
+		return nil
+	}
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	now := clock.System.Now()
+	if s.Clock != nil {
+		now = s.Clock.Now()
+	}
+	timestamp := now.Unix()
+	req.Header.Set(IDHeader, id)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Signature(s.Secret, id, timestamp, body))
+	return nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate webhook delivery ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Verifier checks incoming webhook requests
+type Verifier struct {
+	// Secrets are the accepted secrets; while rotating, both the old and
+	// the new one
+	Secrets [][]byte
+	// Tolerance bounds how far a request's timestamp may be from now;
+	// DefaultTolerance when zero
+	Tolerance time.Duration
+	// Replays, when set, rejects a delivery ID seen before
+	Replays *Replays
+	Clock   clock.Clock
+}
+
+// Verify checks the signature of r against secret, the default tolerance
+// and, when replays is not nil, the deliveries seen before. It returns the
+// body, which stays readable from r.Body.
+func Verify(r *http.Request, secret []byte, replays *Replays) ([]byte, error) {
+	v := &Verifier{Secrets: [][]byte{secret}, Replays: replays}
+	return v.Verify(r)
+}
+
+// Verify checks the signature of r and returns its body, which stays
+// readable from r.Body
+func (v *Verifier) Verify(r *http.Request) ([]byte, error) {
+	id := r.Header.Get(IDHeader)
+	timestamp, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+	signatures := r.Header.Get(SignatureHeader)
+	if id == "" || err != nil || signatures == "" {
+		return nil, ErrMissingSignature
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook body: %w", err)
+	}
+	if len(body) > MaxBodySize {
+		return nil, fmt.Errorf("webhook body exceeds %d bytes", MaxBodySize)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if !v.matches(signatures, id, timestamp, body) {
+		return nil, ErrInvalidSignature
+	}
+
+	now := clock.System.Now()
+	if v.Clock != nil {
+		now = v.Clock.Now()
+	}
+	tolerance := v.Tolerance
+	if tolerance <= 0 {
+		tolerance = DefaultTolerance
+	}
+	signed := time.Unix(timestamp, 0)
+	if signed.Before(now.Add(-tolerance)) || signed.After(now.Add(tolerance)) {
+		return nil, fmt.Errorf("%w: signed at %s", ErrStale, signed.UTC().Format(time.RFC3339))
+	}
+	if v.Replays != nil && !v.Replays.Record(id, signed.Add(tolerance), now) {
+		return nil, fmt.Errorf("%w: %s", ErrReplayed, id)
+	}
+	return body, nil
+}
+
+// matches reports whether any of the comma separated signatures was made
+// with one of the secrets
+func (v *Verifier) matches(signatures, id string, timestamp int64, body []byte) bool {
+	for _, secret := range v.Secrets {
+		want := []byte(Signature(secret, id, timestamp, body))
+		for _, got := range strings.Split(signatures, ",") {
+			if hmac.Equal([]byte(strings.TrimSpace(got)), want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Handler verifies requests before passing them to next, answering 401 to
+// those failing verification
+func (v *Verifier) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := v.Verify(r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Replays remembers the delivery IDs seen until their timestamps fall out
+// of the tolerance, after which Verify rejects them as stale anyway. It is
+// safe for concurrent use.
+type Replays struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// NewReplays returns an empty replay cache
+func NewReplays() *Replays {
+	return &Replays{seen: make(map[string]time.Time)}
+}
+
+// Record records id as seen until expires and reports whether it is new.
+// IDs expired at now are forgotten.
+func (r *Replays) Record(id string, expires, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for seen, until := range r.seen {
+		if now.After(until) {
+			delete(r.seen, seen)
+		}
+	}
+	if _, ok := r.seen[id]; ok {
+		return false
+	}
+	r.seen[id] = expires
+	return true
+}
+
+// Len returns how many delivery IDs are remembered
+func (r *Replays) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.seen)
+}
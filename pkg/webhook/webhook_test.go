@@ -0,0 +1,121 @@
+package webhook
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeremyhahn/go-ipam/pkg/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signed returns a request carrying body signed with secret at the fake
+// clock's time
+func signed(t *testing.T, secret string, clk clock.Clock, body string) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body))
+	require.NoError(t, (&Signer{Secret: []byte(secret), Clock: clk}).Sign(req, []byte(body)))
+	return req
+}
+
+func TestSignVerify(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	body := `{"type":"exhausted"}`
+
+	req := signed(t, "s3cret", clk, body)
+	assert.Equal(t, "1772366400", req.Header.Get(TimestampHeader))
+	assert.Len(t, req.Header.Get(IDHeader), 32)
+	assert.Equal(t, Signature([]byte("s3cret"), req.Header.Get(IDHeader), 1772366400, []byte(body)), req.Header.Get(SignatureHeader))
+	assert.True(t, strings.HasPrefix(req.Header.Get(SignatureHeader), "v1="))
+
+	v := &Verifier{Secrets: [][]byte{[]byte("s3cret")}, Clock: clk}
+	got, err := v.Verify(req)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(got))
+	again, _ := io.ReadAll(req.Body)
+	assert.Equal(t, body, string(again))
+
+	// Another secret, a changed body or no signature fail
+	_, err = v.Verify(signed(t, "other", clk, body))
+	assert.True(t, errors.Is(err, ErrInvalidSignature))
+	req = signed(t, "s3cret", clk, body)
+	req.Body = io.NopCloser(strings.NewReader(`{"type":"released"}`))
+	_, err = v.Verify(req)
+	assert.True(t, errors.Is(err, ErrInvalidSignature))
+	_, err = v.Verify(httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body)))
+	assert.True(t, errors.Is(err, ErrMissingSignature))
+
+	// While rotating, either secret is accepted
+	v.Secrets = append(v.Secrets, []byte("next"))
+	_, err = v.Verify(signed(t, "next", clk, body))
+	assert.NoError(t, err)
+
+	// A nil signer leaves requests unsigned
+	var none *Signer
+	req = httptest.NewRequest(http.MethodPost, "/hook", nil)
+	require.NoError(t, none.Sign(req, nil))
+	assert.Empty(t, req.Header.Get(SignatureHeader))
+	assert.Nil(t, NewSigner(nil))
+}
+
+func TestVerifyTolerance(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	v := &Verifier{Secrets: [][]byte{[]byte("s3cret")}, Tolerance: time.Minute, Clock: clk}
+
+	old := signed(t, "s3cret", clk, "{}")
+	early := signed(t, "s3cret", clock.NewFake(clk.Now().Add(2*time.Minute)), "{}")
+	clk.Advance(2 * time.Minute)
+	_, err := v.Verify(old)
+	assert.True(t, errors.Is(err, ErrStale))
+	clk.Advance(-4 * time.Minute)
+	_, err = v.Verify(early)
+	assert.True(t, errors.Is(err, ErrStale))
+}
+
+func TestReplays(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	replays := NewReplays()
+	v := &Verifier{Secrets: [][]byte{[]byte("s3cret")}, Tolerance: time.Minute, Replays: replays, Clock: clk}
+
+	req := signed(t, "s3cret", clk, "{}")
+	header := req.Header.Clone()
+	_, err := v.Verify(req)
+	require.NoError(t, err)
+
+	replay := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader("{}"))
+	replay.Header = header
+	_, err = v.Verify(replay)
+	assert.True(t, errors.Is(err, ErrReplayed))
+
+	// Seen IDs are forgotten once their timestamps are out of tolerance
+	_, err = v.Verify(signed(t, "s3cret", clk, "{}"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, replays.Len())
+	clk.Advance(2 * time.Minute)
+	_, err = v.Verify(signed(t, "s3cret", clk, "{}"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, replays.Len())
+}
+
+func TestHandler(t *testing.T) {
+	var got string
+	v := &Verifier{Secrets: [][]byte{[]byte("s3cret")}}
+	h := v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = string(body)
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, signed(t, "s3cret", clock.System, `{"ok":true}`))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `{"ok":true}`, got)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, signed(t, "wrong", clock.System, "{}"))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
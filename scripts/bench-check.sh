@@ -0,0 +1,77 @@
+#!/bin/bash
+# Compare benchmark results with a baseline and fail on regressions
+#
+# Usage: scripts/bench-check.sh BASELINE RESULTS [THRESHOLD_PERCENT]
+#
+# Both files are "go test -bench" output. Each benchmark's mean ns/op over
+# all its runs (-count) is compared; a benchmark slower than the baseline by
+# more than THRESHOLD_PERCENT (default 20) is a regression. Benchmarks only
+# in one file are listed as new or gone but do not fail the check.
+#
+# Without a baseline there is nothing to compare, so the check is skipped:
+# baselines are recorded per machine and are not committed.
+
+set -e
+
+BASELINE=$1
+RESULTS=$2
+THRESHOLD=${3:-20}
+
+if [ -z "$BASELINE" ] || [ -z "$RESULTS" ]; then
+    echo "usage: $0 BASELINE RESULTS [THRESHOLD_PERCENT]" >&2
+    exit 2
+fi
+if [ ! -f "$BASELINE" ]; then
+    echo "No baseline at $BASELINE, skipping the check; record one with: make bench-baseline"
+    exit 0
+fi
+if [ ! -f "$RESULTS" ]; then
+    echo "No results at $RESULTS; run: make bench" >&2
+    exit 2
+fi
+
+awk -v threshold="$THRESHOLD" '
+# Benchmark lines look like: BenchmarkName-8  1000  1234 ns/op ...
+function record(which, line,    n, fields, name, i) {
+    n = split(line, fields)
+    name = fields[1]
+    sub(/-[0-9]+$/, "", name)
+    for (i = 3; i < n; i++) {
+        if (fields[i + 1] == "ns/op") {
+            sum[which, name] += fields[i]
+            runs[which, name]++
+            names[name] = 1
+            return
+        }
+    }
+}
+FNR == NR && /^Benchmark/ { record("old", $0); next }
+/^Benchmark/ { record("new", $0) }
+END {
+    printf "%-50s %14s %14s %8s\n", "benchmark", "baseline ns/op", "ns/op", "delta"
+    sort = "sort"
+    for (name in names) {
+        if (!runs["old", name]) {
+            printf "%-50s %14s %14.0f %8s\n", name, "-", sum["new", name] / runs["new", name], "new" | sort
+            continue
+        }
+        if (!runs["new", name]) {
+            printf "%-50s %14.0f %14s %8s\n", name, sum["old", name] / runs["old", name], "-", "gone" | sort
+            continue
+        }
+        old = sum["old", name] / runs["old", name]
+        new = sum["new", name] / runs["new", name]
+        delta = (new - old) / old * 100
+        flag = ""
+        if (delta > threshold) {
+            flag = "  REGRESSION"
+            regressions++
+        }
+        printf "%-50s %14.0f %14.0f %+7.1f%%%s\n", name, old, new, delta, flag | sort
+    }
+    close(sort)
+    if (regressions) {
+        printf "\n%d benchmark(s) regressed by more than %d%%\n", regressions, threshold
+        exit 1
+    }
+}' "$BASELINE" "$RESULTS"